@@ -0,0 +1,1070 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acmetest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/rs/zerolog"
+)
+
+const defaultListen = "localhost:0"
+const defaultHTTPPort = 80
+const defaultTLSPort = 443
+const defaultValidity = 90 * 24 * time.Hour
+
+const issuerValidity = 10 * 365 * 24 * time.Hour
+const orderValidity = 24 * time.Hour
+const validationTimeout = 10 * time.Second
+const renewalInfoRetryAfter = 6 * time.Hour
+
+const statusPending = "pending"
+const statusReady = "ready"
+const statusValid = "valid"
+const statusInvalid = "invalid"
+const statusDeactivated = "deactivated"
+
+const challengeHTTP01 = "http-01"
+const challengeTLSALPN01 = "tls-alpn-01"
+const challengeDNS01 = "dns-01"
+
+const errorPrefix = "urn:ietf:params:acme:error:"
+
+// Config defines the settings of the ACME test server.
+type Config struct {
+	// The address to listen on (defaults to a random localhost port)
+	Listen string
+	// Whether to serve via TLS (using a server certificate issued by the test CA)
+	TLS bool
+	// The port to connect to for validating HTTP-01 challenges (defaults to 80)
+	HTTPPort int
+	// The port to connect to for validating TLS-ALPN-01 challenges (defaults to 443)
+	TLSPort int
+	// Whether to accept all challenges without validating them (required for DNS-01 challenges)
+	SkipValidation bool
+	// The validity period of issued certificates (defaults to 90 days)
+	Validity time.Duration
+}
+
+// Server is a minimal in-process ACME (RFC 8555) server intended for development and testing.
+//
+// Issued certificates are signed by an ephemeral test CA generated on server start. Besides the core protocol, the
+// server offers ACME Renewal Information (ARI) for the certificates it issued.
+type Server struct {
+	config       Config
+	baseURL      string
+	httpServer   *http.Server
+	issuer       *x509.Certificate
+	issuerKey    crypto.Signer
+	mutex        sync.Mutex
+	nextID       int
+	nonces       map[string]bool
+	accounts     map[string]*account
+	orders       map[string]*order
+	authzs       map[string]*authz
+	challenges   map[string]*challenge
+	certificates map[string]*issuedCertificate
+	logger       *zerolog.Logger
+}
+
+type account struct {
+	id         string
+	key        *jose.JSONWebKey
+	thumbprint string
+	status     string
+	contact    []string
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	id            string
+	accountID     string
+	status        string
+	expires       time.Time
+	identifiers   []identifier
+	authzIDs      []string
+	certificateID string
+	problem       *problem
+}
+
+type authz struct {
+	id           string
+	orderID      string
+	status       string
+	identifier   identifier
+	wildcard     bool
+	challengeIDs []string
+}
+
+type challenge struct {
+	id        string
+	authzID   string
+	kind      string
+	token     string
+	status    string
+	validated time.Time
+	problem   *problem
+}
+
+type issuedCertificate struct {
+	id          string
+	accountID   string
+	certificate *x509.Certificate
+	revoked     bool
+}
+
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status,omitempty"`
+}
+
+func newProblem(status int, kind string, format string, args ...any) *problem {
+	return &problem{Type: errorPrefix + kind, Detail: fmt.Sprintf(format, args...), Status: status}
+}
+
+// Start an ACME test server using the given configuration.
+func Start(config *Config) (*Server, error) {
+	logger := logging.ModuleLogger(logging.ModuleACME).With().Str("acmetest", config.Listen).Logger()
+	server := &Server{
+		config:       *config,
+		nonces:       make(map[string]bool),
+		accounts:     make(map[string]*account),
+		orders:       make(map[string]*order),
+		authzs:       make(map[string]*authz),
+		challenges:   make(map[string]*challenge),
+		certificates: make(map[string]*issuedCertificate),
+		logger:       &logger,
+	}
+	if server.config.Listen == "" {
+		server.config.Listen = defaultListen
+	}
+	if server.config.HTTPPort == 0 {
+		server.config.HTTPPort = defaultHTTPPort
+	}
+	if server.config.TLSPort == 0 {
+		server.config.TLSPort = defaultTLSPort
+	}
+	if server.config.Validity <= 0 {
+		server.config.Validity = defaultValidity
+	}
+	err := server.generateIssuer()
+	if err != nil {
+		return nil, err
+	}
+	listenHost, _, err := net.SplitHostPort(server.config.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address '%s' (cause: %w)", server.config.Listen, err)
+	}
+	listener, err := net.Listen("tcp", server.config.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on '%s' (cause: %w)", server.config.Listen, err)
+	}
+	host := listenHost
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = "localhost"
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	scheme := "http"
+	if server.config.TLS {
+		tlsCertificate, err := server.issueServerCertificate(host)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{*tlsCertificate}, MinVersion: tls.VersionTLS12})
+		scheme = "https"
+	}
+	server.baseURL = scheme + "://" + net.JoinHostPort(host, port)
+	server.httpServer = &http.Server{
+		Handler:           server.handler(),
+		ReadHeaderTimeout: validationTimeout,
+	}
+	go func() {
+		err := server.httpServer.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			server.logger.Error().Err(err).Msg("ACME test server failed")
+		}
+	}()
+	server.logger.Info().Msgf("ACME test server listening on '%s'", server.DirectoryURL())
+	return server, nil
+}
+
+// Get the directory URL of the server.
+func (server *Server) DirectoryURL() string {
+	return server.baseURL + "/dir"
+}
+
+// Get the CA certificate issuing the server's certificates (and the server's own TLS certificate).
+func (server *Server) Issuer() *x509.Certificate {
+	return server.issuer
+}
+
+// Get the PEM encoded CA certificate issuing the server's certificates.
+func (server *Server) IssuerPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.issuer.Raw})
+}
+
+// Stop the server.
+func (server *Server) Close() error {
+	return server.httpServer.Close()
+}
+
+func (server *Server) generateIssuer() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate test CA key (cause: %w)", err)
+	}
+	serialNumber, err := randomSerialNumber()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "certd ACME test CA " + serialNumber.Text(16)[:8]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(issuerValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	issuerBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return fmt.Errorf("failed to create test CA certificate (cause: %w)", err)
+	}
+	issuer, err := x509.ParseCertificate(issuerBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse test CA certificate (cause: %w)", err)
+	}
+	server.issuer = issuer
+	server.issuerKey = key
+	return nil
+}
+
+func (server *Server) issueServerCertificate(host string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate server key (cause: %w)", err)
+	}
+	certificate, err := server.issue([]string{host, "localhost"}, key.Public(), issuerValidity)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{certificate.Raw}, PrivateKey: key, Leaf: certificate}, nil
+}
+
+func (server *Server) issue(domains []string, publicKey crypto.PublicKey, validity time.Duration) (*x509.Certificate, error) {
+	serialNumber, err := randomSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: domains[0]},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, domain := range domains {
+		ip := net.ParseIP(domain)
+		if ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, domain)
+		}
+	}
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, server.issuer, publicKey, server.issuerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate (cause: %w)", err)
+	}
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate (cause: %w)", err)
+	}
+	return certificate, nil
+}
+
+func (server *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dir", server.handleDirectory)
+	mux.HandleFunc("/nonce", server.handleNonce)
+	mux.HandleFunc("/new-account", server.handleNewAccount)
+	mux.HandleFunc("/new-order", server.handleNewOrder)
+	mux.HandleFunc("/revoke-cert", server.handleRevokeCert)
+	mux.HandleFunc("/acct/", server.handleAccount)
+	mux.HandleFunc("/order/", server.handleOrder)
+	mux.HandleFunc("/authz/", server.handleAuthz)
+	mux.HandleFunc("/chall/", server.handleChallenge)
+	mux.HandleFunc("/finalize/", server.handleFinalize)
+	mux.HandleFunc("/cert/", server.handleCertificate)
+	mux.HandleFunc("/renewal-info/", server.handleRenewalInfo)
+	return mux
+}
+
+func (server *Server) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	directory := map[string]any{
+		"newNonce":    server.baseURL + "/nonce",
+		"newAccount":  server.baseURL + "/new-account",
+		"newOrder":    server.baseURL + "/new-order",
+		"revokeCert":  server.baseURL + "/revoke-cert",
+		"renewalInfo": server.baseURL + "/renewal-info/",
+		"meta": map[string]any{
+			"externalAccountRequired": false,
+		},
+	}
+	server.writeJSON(w, http.StatusOK, "", directory)
+}
+
+func (server *Server) handleNonce(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	w.Header().Set("Replay-Nonce", server.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (server *Server) handleNewAccount(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	payload, _, key, problem := server.verifyRequest(r, true, false)
+	if problem != nil {
+		server.writeProblem(w, problem)
+		return
+	}
+	request := &struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}{}
+	err := json.Unmarshal(payload, request)
+	if err != nil {
+		server.writeProblem(w, newProblem(http.StatusBadRequest, "malformed", "failed to decode request (cause: %v)", err))
+		return
+	}
+	thumbprint, err := keyThumbprint(key)
+	if err != nil {
+		server.writeProblem(w, newProblem(http.StatusBadRequest, "badPublicKey", "%v", err))
+		return
+	}
+	for _, existing := range server.accounts {
+		if existing.thumbprint == thumbprint {
+			server.writeJSON(w, http.StatusOK, server.accountURL(existing), server.accountJSON(existing))
+			return
+		}
+	}
+	if request.OnlyReturnExisting {
+		server.writeProblem(w, newProblem(http.StatusBadRequest, "accountDoesNotExist", "no account exists for the given key"))
+		return
+	}
+	created := &account{
+		id:         server.newID(),
+		key:        key,
+		thumbprint: thumbprint,
+		status:     statusValid,
+		contact:    request.Contact,
+	}
+	server.accounts[created.id] = created
+	server.logger.Info().Msgf("Account '%s' created", created.id)
+	server.writeJSON(w, http.StatusCreated, server.accountURL(created), server.accountJSON(created))
+}
+
+func (server *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	payload, requester, _, problem := server.verifyRequest(r, false, true)
+	if problem != nil {
+		server.writeProblem(w, problem)
+		return
+	}
+	if requester.id != strings.TrimPrefix(r.URL.Path, "/acct/") {
+		server.writeProblem(w, newProblem(http.StatusForbidden, "unauthorized", "account mismatch"))
+		return
+	}
+	if len(payload) > 0 {
+		update := &struct {
+			Status  string   `json:"status"`
+			Contact []string `json:"contact"`
+		}{}
+		err := json.Unmarshal(payload, update)
+		if err != nil {
+			server.writeProblem(w, newProblem(http.StatusBadRequest, "malformed", "failed to decode request (cause: %v)", err))
+			return
+		}
+		if update.Status == statusDeactivated {
+			requester.status = statusDeactivated
+		}
+		if update.Contact != nil {
+			requester.contact = update.Contact
+		}
+	}
+	server.writeJSON(w, http.StatusOK, server.accountURL(requester), server.accountJSON(requester))
+}
+
+func (server *Server) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	payload, requester, _, problem := server.verifyRequest(r, false, false)
+	if problem != nil {
+		server.writeProblem(w, problem)
+		return
+	}
+	request := &struct {
+		Identifiers []identifier `json:"identifiers"`
+	}{}
+	err := json.Unmarshal(payload, request)
+	if err != nil {
+		server.writeProblem(w, newProblem(http.StatusBadRequest, "malformed", "failed to decode request (cause: %v)", err))
+		return
+	}
+	if len(request.Identifiers) == 0 {
+		server.writeProblem(w, newProblem(http.StatusBadRequest, "malformed", "missing identifiers"))
+		return
+	}
+	created := &order{
+		id:          server.newID(),
+		accountID:   requester.id,
+		status:      statusPending,
+		expires:     time.Now().Add(orderValidity),
+		identifiers: request.Identifiers,
+	}
+	for _, orderIdentifier := range request.Identifiers {
+		if orderIdentifier.Type != "dns" && orderIdentifier.Type != "ip" {
+			server.writeProblem(w, newProblem(http.StatusBadRequest, "unsupportedIdentifier", "unsupported identifier type '%s'", orderIdentifier.Type))
+			return
+		}
+		created.authzIDs = append(created.authzIDs, server.newAuthz(created.id, orderIdentifier).id)
+	}
+	server.orders[created.id] = created
+	server.writeJSON(w, http.StatusCreated, server.baseURL+"/order/"+created.id, server.orderJSON(created))
+}
+
+func (server *Server) newAuthz(orderID string, orderIdentifier identifier) *authz {
+	created := &authz{
+		id:         server.newID(),
+		orderID:    orderID,
+		status:     statusPending,
+		identifier: orderIdentifier,
+	}
+	kinds := []string{challengeHTTP01, challengeTLSALPN01, challengeDNS01}
+	if strings.HasPrefix(orderIdentifier.Value, "*.") {
+		created.identifier.Value = strings.TrimPrefix(orderIdentifier.Value, "*.")
+		created.wildcard = true
+		kinds = []string{challengeDNS01}
+	} else if orderIdentifier.Type == "ip" {
+		kinds = []string{challengeHTTP01, challengeTLSALPN01}
+	}
+	for _, kind := range kinds {
+		offered := &challenge{
+			id:      server.newID(),
+			authzID: created.id,
+			kind:    kind,
+			token:   randomToken(),
+			status:  statusPending,
+		}
+		server.challenges[offered.id] = offered
+		created.challengeIDs = append(created.challengeIDs, offered.id)
+	}
+	server.authzs[created.id] = created
+	return created
+}
+
+func (server *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	_, requester, _, problem := server.verifyRequest(r, false, false)
+	if problem != nil {
+		server.writeProblem(w, problem)
+		return
+	}
+	requested := server.orders[strings.TrimPrefix(r.URL.Path, "/order/")]
+	if requested == nil || requested.accountID != requester.id {
+		server.writeProblem(w, newProblem(http.StatusNotFound, "malformed", "unknown order"))
+		return
+	}
+	server.writeJSON(w, http.StatusOK, "", server.orderJSON(requested))
+}
+
+func (server *Server) handleAuthz(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	payload, requester, _, problem := server.verifyRequest(r, false, false)
+	if problem != nil {
+		server.writeProblem(w, problem)
+		return
+	}
+	requested := server.authzs[strings.TrimPrefix(r.URL.Path, "/authz/")]
+	if requested == nil || server.orders[requested.orderID].accountID != requester.id {
+		server.writeProblem(w, newProblem(http.StatusNotFound, "malformed", "unknown authorization"))
+		return
+	}
+	if bytes.Contains(payload, []byte(statusDeactivated)) {
+		requested.status = statusDeactivated
+		server.updateOrderStatus(server.orders[requested.orderID])
+	}
+	server.writeJSON(w, http.StatusOK, "", server.authzJSON(requested))
+}
+
+func (server *Server) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	payload, requester, _, problem := server.verifyRequest(r, false, false)
+	if problem != nil {
+		server.writeProblem(w, problem)
+		return
+	}
+	requested := server.challenges[strings.TrimPrefix(r.URL.Path, "/chall/")]
+	if requested == nil {
+		server.writeProblem(w, newProblem(http.StatusNotFound, "malformed", "unknown challenge"))
+		return
+	}
+	challengeAuthz := server.authzs[requested.authzID]
+	challengeOrder := server.orders[challengeAuthz.orderID]
+	if challengeOrder.accountID != requester.id {
+		server.writeProblem(w, newProblem(http.StatusNotFound, "malformed", "unknown challenge"))
+		return
+	}
+	// an empty payload just queries the challenge; a JSON object triggers the validation
+	if len(payload) > 0 && requested.status == statusPending && challengeAuthz.status == statusPending {
+		server.validate(requester, challengeAuthz, requested)
+		server.updateOrderStatus(challengeOrder)
+	}
+	w.Header().Add("Link", fmt.Sprintf("<%s/authz/%s>;rel=\"up\"", server.baseURL, challengeAuthz.id))
+	server.writeJSON(w, http.StatusOK, "", server.challengeJSON(requested))
+}
+
+func (server *Server) validate(requester *account, challengeAuthz *authz, requested *challenge) {
+	var err error
+	if !server.config.SkipValidation {
+		switch requested.kind {
+		case challengeHTTP01:
+			err = server.validateHTTP01(challengeAuthz.identifier.Value, requested.token, requested.token+"."+requester.thumbprint)
+		case challengeTLSALPN01:
+			err = server.validateTLSALPN01(challengeAuthz.identifier.Value, requested.token+"."+requester.thumbprint)
+		default:
+			err = fmt.Errorf("validation of %s challenges is not supported (skip validation to accept them)", requested.kind)
+		}
+	}
+	if err != nil {
+		server.logger.Warn().Err(err).Msgf("Challenge '%s' for '%s' failed", requested.kind, challengeAuthz.identifier.Value)
+		requested.status = statusInvalid
+		requested.problem = newProblem(http.StatusForbidden, "unauthorized", "%v", err)
+		challengeAuthz.status = statusInvalid
+		return
+	}
+	requested.status = statusValid
+	requested.validated = time.Now()
+	challengeAuthz.status = statusValid
+}
+
+func (server *Server) validateHTTP01(domain string, token string, keyAuthorization string) error {
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", net.JoinHostPort(domain, strconv.Itoa(server.config.HTTPPort)), token)
+	client := &http.Client{Timeout: validationTimeout}
+	rsp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch '%s' (cause: %w)", url, err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch '%s' (status: %d)", url, rsp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(rsp.Body, 1024))
+	if err != nil {
+		return fmt.Errorf("failed to read '%s' (cause: %w)", url, err)
+	}
+	if strings.TrimSpace(string(body)) != keyAuthorization {
+		return fmt.Errorf("unexpected key authorization at '%s'", url)
+	}
+	return nil
+}
+
+var oidACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+const tlsALPN01Protocol = "acme-tls/1"
+
+func (server *Server) validateTLSALPN01(domain string, keyAuthorization string) error {
+	address := net.JoinHostPort(domain, strconv.Itoa(server.config.TLSPort))
+	dialer := &net.Dialer{Timeout: validationTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{
+		ServerName:         domain,
+		NextProtos:         []string{tlsALPN01Protocol},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect to '%s' (cause: %w)", address, err)
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != tlsALPN01Protocol || len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("'%s' did not negotiate protocol '%s'", address, tlsALPN01Protocol)
+	}
+	certificate := state.PeerCertificates[0]
+	err = certificate.VerifyHostname(domain)
+	if err != nil {
+		return fmt.Errorf("unexpected challenge certificate at '%s' (cause: %w)", address, err)
+	}
+	expected := sha256.Sum256([]byte(keyAuthorization))
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(oidACMEIdentifier) {
+			continue
+		}
+		var digest []byte
+		_, err = asn1.Unmarshal(extension.Value, &digest)
+		if err != nil || !bytes.Equal(digest, expected[:]) {
+			return fmt.Errorf("unexpected key authorization at '%s'", address)
+		}
+		return nil
+	}
+	return fmt.Errorf("challenge certificate at '%s' lacks the ACME identifier extension", address)
+}
+
+func (server *Server) updateOrderStatus(target *order) {
+	if target.status != statusPending {
+		return
+	}
+	ready := true
+	for _, authzID := range target.authzIDs {
+		switch server.authzs[authzID].status {
+		case statusValid:
+		case statusPending:
+			ready = false
+		default:
+			target.status = statusInvalid
+			target.problem = newProblem(http.StatusForbidden, "unauthorized", "authorization for '%s' failed", server.authzs[authzID].identifier.Value)
+			return
+		}
+	}
+	if ready {
+		target.status = statusReady
+	}
+}
+
+func (server *Server) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	payload, requester, _, problem := server.verifyRequest(r, false, false)
+	if problem != nil {
+		server.writeProblem(w, problem)
+		return
+	}
+	requested := server.orders[strings.TrimPrefix(r.URL.Path, "/finalize/")]
+	if requested == nil || requested.accountID != requester.id {
+		server.writeProblem(w, newProblem(http.StatusNotFound, "malformed", "unknown order"))
+		return
+	}
+	if requested.status != statusReady {
+		server.writeProblem(w, newProblem(http.StatusForbidden, "orderNotReady", "order is '%s'", requested.status))
+		return
+	}
+	request := &struct {
+		CSR string `json:"csr"`
+	}{}
+	err := json.Unmarshal(payload, request)
+	if err != nil {
+		server.writeProblem(w, newProblem(http.StatusBadRequest, "malformed", "failed to decode request (cause: %v)", err))
+		return
+	}
+	csr, problem := server.decodeCSR(request.CSR, requested.identifiers)
+	if problem != nil {
+		server.writeProblem(w, problem)
+		return
+	}
+	domains := make([]string, 0, len(requested.identifiers))
+	for _, orderIdentifier := range requested.identifiers {
+		domains = append(domains, orderIdentifier.Value)
+	}
+	certificate, err := server.issue(domains, csr.PublicKey, server.config.Validity)
+	if err != nil {
+		server.writeProblem(w, newProblem(http.StatusInternalServerError, "serverInternal", "%v", err))
+		return
+	}
+	issued := &issuedCertificate{
+		id:          server.newID(),
+		accountID:   requester.id,
+		certificate: certificate,
+	}
+	server.certificates[issued.id] = issued
+	requested.status = statusValid
+	requested.certificateID = issued.id
+	server.logger.Info().Msgf("Certificate '%s' issued for %v", issued.id, domains)
+	server.writeJSON(w, http.StatusOK, server.baseURL+"/order/"+requested.id, server.orderJSON(requested))
+}
+
+func (server *Server) decodeCSR(encoded string, identifiers []identifier) (*x509.CertificateRequest, *problem) {
+	csrBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, newProblem(http.StatusBadRequest, "badCSR", "failed to decode CSR (cause: %v)", err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrBytes)
+	if err != nil {
+		return nil, newProblem(http.StatusBadRequest, "badCSR", "failed to parse CSR (cause: %v)", err)
+	}
+	err = csr.CheckSignature()
+	if err != nil {
+		return nil, newProblem(http.StatusBadRequest, "badCSR", "invalid CSR signature (cause: %v)", err)
+	}
+	requested := make(map[string]bool)
+	for _, orderIdentifier := range identifiers {
+		requested[orderIdentifier.Value] = true
+	}
+	names := append([]string{}, csr.DNSNames...)
+	for _, ip := range csr.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if csr.Subject.CommonName != "" {
+		names = append(names, csr.Subject.CommonName)
+	}
+	for _, name := range names {
+		if !requested[name] {
+			return nil, newProblem(http.StatusBadRequest, "badCSR", "CSR name '%s' not covered by order", name)
+		}
+	}
+	return csr, nil
+}
+
+func (server *Server) handleCertificate(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	_, requester, _, problem := server.verifyRequest(r, false, false)
+	if problem != nil {
+		server.writeProblem(w, problem)
+		return
+	}
+	issued := server.certificates[strings.TrimPrefix(r.URL.Path, "/cert/")]
+	if issued == nil || issued.accountID != requester.id {
+		server.writeProblem(w, newProblem(http.StatusNotFound, "malformed", "unknown certificate"))
+		return
+	}
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issued.certificate.Raw})
+	chain = append(chain, server.IssuerPEM()...)
+	server.setResponseHeaders(w)
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.WriteHeader(http.StatusOK)
+	w.Write(chain)
+}
+
+func (server *Server) handleRevokeCert(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	payload, requester, key, problem := server.verifyRequest(r, true, false)
+	if problem != nil {
+		server.writeProblem(w, problem)
+		return
+	}
+	request := &struct {
+		Certificate string `json:"certificate"`
+	}{}
+	err := json.Unmarshal(payload, request)
+	if err != nil {
+		server.writeProblem(w, newProblem(http.StatusBadRequest, "malformed", "failed to decode request (cause: %v)", err))
+		return
+	}
+	certificateBytes, err := base64.RawURLEncoding.DecodeString(request.Certificate)
+	if err != nil {
+		server.writeProblem(w, newProblem(http.StatusBadRequest, "malformed", "failed to decode certificate (cause: %v)", err))
+		return
+	}
+	var revoke *issuedCertificate
+	for _, issued := range server.certificates {
+		if bytes.Equal(issued.certificate.Raw, certificateBytes) {
+			revoke = issued
+			break
+		}
+	}
+	if revoke == nil {
+		server.writeProblem(w, newProblem(http.StatusNotFound, "malformed", "unknown certificate"))
+		return
+	}
+	authorized := requester != nil && requester.id == revoke.accountID
+	if key != nil {
+		requestThumbprint, _ := keyThumbprint(key)
+		certificateThumbprint, _ := keyThumbprint(&jose.JSONWebKey{Key: revoke.certificate.PublicKey})
+		authorized = requestThumbprint != "" && requestThumbprint == certificateThumbprint
+	}
+	if !authorized {
+		server.writeProblem(w, newProblem(http.StatusForbidden, "unauthorized", "not authorized to revoke certificate"))
+		return
+	}
+	if revoke.revoked {
+		server.writeProblem(w, newProblem(http.StatusBadRequest, "alreadyRevoked", "certificate already revoked"))
+		return
+	}
+	revoke.revoked = true
+	server.setResponseHeaders(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (server *Server) handleRenewalInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		server.writeProblem(w, newProblem(http.StatusMethodNotAllowed, "malformed", "method not allowed"))
+		return
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	certID := strings.TrimPrefix(r.URL.Path, "/renewal-info/")
+	var requested *issuedCertificate
+	for _, issued := range server.certificates {
+		if renewalCertID(issued.certificate) == certID {
+			requested = issued
+			break
+		}
+	}
+	if requested == nil {
+		server.writeProblem(w, newProblem(http.StatusNotFound, "malformed", "unknown certificate"))
+		return
+	}
+	// suggest renewal during the last third of the validity period (or immediately if revoked)
+	var start, end time.Time
+	if requested.revoked {
+		start = time.Now().Add(-time.Hour)
+		end = time.Now()
+	} else {
+		lifetime := requested.certificate.NotAfter.Sub(requested.certificate.NotBefore)
+		start = requested.certificate.NotAfter.Add(-lifetime / 3)
+		end = requested.certificate.NotAfter.Add(-lifetime / 6)
+	}
+	renewalInfo := map[string]any{
+		"suggestedWindow": map[string]any{
+			"start": start.UTC().Format(time.RFC3339),
+			"end":   end.UTC().Format(time.RFC3339),
+		},
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(renewalInfoRetryAfter.Seconds())))
+	server.writeJSON(w, http.StatusOK, "", renewalInfo)
+}
+
+// Verify the JWS request and return its payload.
+//
+// Requests are either signed by an account key referenced via the key ID header (returning the account) or by the
+// embedded JSON web key (returning the key), if allowed.
+func (server *Server) verifyRequest(r *http.Request, allowJWK bool, allowDeactivated bool) ([]byte, *account, *jose.JSONWebKey, *problem) {
+	if r.Method != http.MethodPost {
+		return nil, nil, nil, newProblem(http.StatusMethodNotAllowed, "malformed", "method not allowed")
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, nil, newProblem(http.StatusBadRequest, "malformed", "failed to read request (cause: %v)", err)
+	}
+	jws, err := jose.ParseSigned(string(body))
+	if err != nil {
+		return nil, nil, nil, newProblem(http.StatusBadRequest, "malformed", "failed to parse JWS (cause: %v)", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, nil, nil, newProblem(http.StatusBadRequest, "malformed", "unexpected number of signatures")
+	}
+	protected := jws.Signatures[0].Protected
+	if !server.nonces[protected.Nonce] {
+		return nil, nil, nil, newProblem(http.StatusBadRequest, "badNonce", "invalid nonce")
+	}
+	delete(server.nonces, protected.Nonce)
+	url, _ := protected.ExtraHeaders[jose.HeaderKey("url")].(string)
+	if url != server.baseURL+r.URL.Path {
+		return nil, nil, nil, newProblem(http.StatusBadRequest, "malformed", "URL header mismatch")
+	}
+	var requester *account
+	var key *jose.JSONWebKey
+	if protected.JSONWebKey != nil {
+		if !allowJWK {
+			return nil, nil, nil, newProblem(http.StatusBadRequest, "malformed", "unexpected JWK header")
+		}
+		key = protected.JSONWebKey
+	} else {
+		requester = server.accounts[strings.TrimPrefix(protected.KeyID, server.baseURL+"/acct/")]
+		if requester == nil {
+			return nil, nil, nil, newProblem(http.StatusBadRequest, "accountDoesNotExist", "unknown account '%s'", protected.KeyID)
+		}
+		if requester.status != statusValid && !allowDeactivated {
+			return nil, nil, nil, newProblem(http.StatusForbidden, "unauthorized", "account is '%s'", requester.status)
+		}
+	}
+	verifyKey := key
+	if requester != nil {
+		verifyKey = requester.key
+	}
+	payload, err := jws.Verify(verifyKey)
+	if err != nil {
+		return nil, nil, nil, newProblem(http.StatusBadRequest, "malformed", "failed to verify JWS (cause: %v)", err)
+	}
+	return payload, requester, key, nil
+}
+
+func (server *Server) accountURL(target *account) string {
+	return server.baseURL + "/acct/" + target.id
+}
+
+func (server *Server) accountJSON(target *account) any {
+	return map[string]any{
+		"status":  target.status,
+		"contact": target.contact,
+		"key":     target.key,
+	}
+}
+
+func (server *Server) orderJSON(target *order) any {
+	authorizations := make([]string, 0, len(target.authzIDs))
+	for _, authzID := range target.authzIDs {
+		authorizations = append(authorizations, server.baseURL+"/authz/"+authzID)
+	}
+	orderJSON := map[string]any{
+		"status":         target.status,
+		"expires":        target.expires.UTC().Format(time.RFC3339),
+		"identifiers":    target.identifiers,
+		"authorizations": authorizations,
+		"finalize":       server.baseURL + "/finalize/" + target.id,
+	}
+	if target.certificateID != "" {
+		orderJSON["certificate"] = server.baseURL + "/cert/" + target.certificateID
+	}
+	if target.problem != nil {
+		orderJSON["error"] = target.problem
+	}
+	return orderJSON
+}
+
+func (server *Server) authzJSON(target *authz) any {
+	challenges := make([]any, 0, len(target.challengeIDs))
+	for _, challengeID := range target.challengeIDs {
+		challenges = append(challenges, server.challengeJSON(server.challenges[challengeID]))
+	}
+	return map[string]any{
+		"status":     target.status,
+		"expires":    server.orders[target.orderID].expires.UTC().Format(time.RFC3339),
+		"identifier": target.identifier,
+		"challenges": challenges,
+		"wildcard":   target.wildcard,
+	}
+}
+
+func (server *Server) challengeJSON(target *challenge) any {
+	challengeJSON := map[string]any{
+		"type":   target.kind,
+		"url":    server.baseURL + "/chall/" + target.id,
+		"token":  target.token,
+		"status": target.status,
+	}
+	if !target.validated.IsZero() {
+		challengeJSON["validated"] = target.validated.UTC().Format(time.RFC3339)
+	}
+	if target.problem != nil {
+		challengeJSON["error"] = target.problem
+	}
+	return challengeJSON
+}
+
+func (server *Server) setResponseHeaders(w http.ResponseWriter) {
+	w.Header().Set("Replay-Nonce", server.newNonce())
+	w.Header().Add("Link", fmt.Sprintf("<%s>;rel=\"index\"", server.DirectoryURL()))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func (server *Server) writeJSON(w http.ResponseWriter, status int, location string, v any) {
+	server.setResponseHeaders(w)
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (server *Server) writeProblem(w http.ResponseWriter, problem *problem) {
+	server.setResponseHeaders(w)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+func (server *Server) newNonce() string {
+	nonce := randomToken()
+	server.nonces[nonce] = true
+	return nonce
+}
+
+func (server *Server) newID() string {
+	server.nextID++
+	return strconv.Itoa(server.nextID)
+}
+
+func keyThumbprint(key *jose.JSONWebKey) (string, error) {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute key thumbprint (cause: %w)", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+func renewalCertID(certificate *x509.Certificate) string {
+	serialBytes := certificate.SerialNumber.Bytes()
+	if len(serialBytes) == 0 || serialBytes[0]&0x80 != 0 {
+		serialBytes = append([]byte{0}, serialBytes...)
+	}
+	return base64.RawURLEncoding.EncodeToString(certificate.AuthorityKeyId) + "." + base64.RawURLEncoding.EncodeToString(serialBytes)
+}
+
+func randomSerialNumber() (*big.Int, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number (cause: %w)", err)
+	}
+	return serialNumber.Add(serialNumber, big.NewInt(1)), nil
+}
+
+func randomToken() string {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return base64.RawURLEncoding.EncodeToString(token)
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acmetest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"testing"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	server, err := Start(&Config{SkipValidation: true})
+	require.NoError(t, err)
+	defer server.Close()
+	accountKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	user := &testUser{key: accountKey}
+	config := lego.NewConfig(user)
+	config.CADirURL = server.DirectoryURL()
+	client, err := lego.NewClient(config)
+	require.NoError(t, err)
+	user.registration, err = client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	require.NoError(t, err)
+	err = client.Challenge.SetDNS01Provider(&testDNSProvider{}, dns01.WrapPreCheck(func(_, _, _ string, _ dns01.PreCheckFunc) (bool, error) {
+		return true, nil
+	}))
+	require.NoError(t, err)
+	// wildcard domains are issued via DNS-01
+	resource, err := client.Certificate.Obtain(certificate.ObtainRequest{Domains: []string{"*.example.org", "example.org"}})
+	require.NoError(t, err)
+	pemBlock, _ := pem.Decode(resource.Certificate)
+	require.NotNil(t, pemBlock)
+	issued, err := x509.ParseCertificate(pemBlock.Bytes)
+	require.NoError(t, err)
+	require.NoError(t, issued.CheckSignatureFrom(server.Issuer()))
+	require.ElementsMatch(t, []string{"*.example.org", "example.org"}, issued.DNSNames)
+	// renewal information is offered for issued certificates only
+	rsp, err := http.Get(server.baseURL + "/renewal-info/" + renewalCertID(issued))
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	rsp, err = http.Get(server.baseURL + "/renewal-info/unknown.AQ")
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	// revocation
+	require.NoError(t, client.Certificate.Revoke(resource.Certificate))
+	require.Error(t, client.Certificate.Revoke(resource.Certificate))
+}
+
+func TestServerValidation(t *testing.T) {
+	server, err := Start(&Config{HTTPPort: 5006, TLSPort: 5005})
+	require.NoError(t, err)
+	defer server.Close()
+	accountKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	user := &testUser{key: accountKey}
+	config := lego.NewConfig(user)
+	config.CADirURL = server.DirectoryURL()
+	client, err := lego.NewClient(config)
+	require.NoError(t, err)
+	user.registration, err = client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	require.NoError(t, err)
+	err = client.Challenge.SetDNS01Provider(&testDNSProvider{}, dns01.WrapPreCheck(func(_, _, _ string, _ dns01.PreCheckFunc) (bool, error) {
+		return true, nil
+	}))
+	require.NoError(t, err)
+	// DNS-01 challenges can not be validated
+	_, err = client.Certificate.Obtain(certificate.ObtainRequest{Domains: []string{"localhost"}})
+	require.Error(t, err)
+}
+
+type testUser struct {
+	key          crypto.PrivateKey
+	registration *registration.Resource
+}
+
+func (user *testUser) GetEmail() string {
+	return "webmaster@localhost"
+}
+
+func (user *testUser) GetRegistration() *registration.Resource {
+	return user.registration
+}
+
+func (user *testUser) GetPrivateKey() crypto.PrivateKey {
+	return user.key
+}
+
+type testDNSProvider struct{}
+
+func (provider *testDNSProvider) Present(domain, token, keyAuth string) error {
+	return nil
+}
+
+func (provider *testDNSProvider) CleanUp(domain, token, keyAuth string) error {
+	return nil
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package audit
+
+import (
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+// Audited store entry changes.
+const (
+	EventLabelsChanged = "labels_changed"
+	EventNoteAdded     = "note_added"
+	EventArchived      = "archived"
+	EventRestored      = "restored"
+	EventPurged        = "purged"
+	EventRenewed       = "renewed"
+	EventRevoked       = "revoked"
+	EventCompromised   = "compromised"
+	// Purge skipped due to dry-run mode
+	EventPurgeCandidate = "purge_candidate"
+)
+
+// Record a change of the given store entry in the audit log.
+//
+// The audit log is written via the "audit" module logger, hence it can be leveled independently of the
+// remaining log output. The note attached to the change (if any) is recorded alongside.
+func Record(correlationID string, event string, entry string, note *certs.StoreEntryNote) {
+	logger := logging.ModuleLogger(logging.ModuleAudit)
+	auditEvent := logger.Info().Str("event", event).Str("entry", entry)
+	if correlationID != "" {
+		auditEvent = auditEvent.Str(logging.CorrelationIDKey, correlationID)
+	}
+	if note != nil {
+		auditEvent = auditEvent.Str("author", note.Author).Str("note", note.Text)
+	}
+	auditEvent.Msgf("Store entry '%s' changed (%s)", entry, event)
+}
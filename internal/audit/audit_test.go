@@ -0,0 +1,49 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := logging.NewLogger(&out, logging.FormatJSON, false)
+	require.NoError(t, err)
+	logging.UpdateRootLogger(logger, zerolog.InfoLevel)
+	defer logging.UpdateRootLogger(logging.NewConsoleLogger(os.Stdout, false), zerolog.WarnLevel)
+	out.Reset()
+	Record("0123", EventNoteAdded, "local0", &certs.StoreEntryNote{Author: "alice", Text: "TICKET-42"})
+	entry := make(map[string]any)
+	err = json.Unmarshal(out.Bytes(), &entry)
+	require.NoError(t, err)
+	require.Equal(t, logging.ModuleAudit, entry["module"])
+	require.Equal(t, EventNoteAdded, entry["event"])
+	require.Equal(t, "local0", entry["entry"])
+	require.Equal(t, "0123", entry[logging.CorrelationIDKey])
+	require.Equal(t, "alice", entry["author"])
+	require.Equal(t, "TICKET-42", entry["note"])
+}
@@ -18,14 +18,21 @@
 package certd
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"runtime/debug"
+	"time"
 
 	"github.com/alecthomas/kong"
+	"github.com/hdecarne-github/certd/internal/acmetest"
 	"github.com/hdecarne-github/certd/internal/buildinfo"
 	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/loadtest"
 	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/internal/offline"
 	"github.com/hdecarne-github/certd/internal/server"
 	"github.com/rs/zerolog"
 )
@@ -33,11 +40,16 @@ import (
 type Runner interface {
 	Version() error
 	Server(config *config.ServerConfig) error
+	Offline(config *config.ServerConfig, command offline.Command) error
+	DevACME(config *acmetest.Config, caFile string) error
+	DevLoad(config *loadtest.Config) error
 }
 
 type cmdline struct {
 	Version versionCmd `cmd:"" help:"Display version and exit"`
 	Server  serverCmd  `cmd:"" help:"Run server"`
+	Offline offlineCmd `cmd:"" help:"Operate directly on the store without running the server"`
+	Dev     devCmd     `cmd:"" help:"Development helpers"`
 	Verbose bool       `help:"Enable verbose output"`
 	Debug   bool       `help:"Enable debug output"`
 	ANSI    bool       `help:"Force ANSI colored output"`
@@ -52,10 +64,11 @@ func (cmd *versionCmd) Run(cmdline *cmdline) error {
 }
 
 type serverCmd struct {
-	Config    string `help:"The configuration file to use (defaults to /etc/certd/certd.yaml)"`
-	ServerURL string `help:"The server URL to listen on (defaults to configuration file value)"`
-	StorePath string `help:"The store path to use (defaults to configuration file value)"`
-	StatePath string `help:"The state path to use (defaults to configuration file value)"`
+	Config      string `help:"The configuration file to use (defaults to /etc/certd/certd.yaml)"`
+	ServerURL   string `help:"The server URL to listen on (defaults to configuration file value)"`
+	StorePath   string `help:"The store path to use (defaults to configuration file value)"`
+	StatePath   string `help:"The state path to use (defaults to configuration file value)"`
+	ForceUnlock bool   `help:"Break a stale store lock left behind by a no longer running process"`
 }
 
 const defaultServerConfigPath = "/etc/certd/certd.yaml"
@@ -70,7 +83,10 @@ func (cmd *serverCmd) Run(cmdline *cmdline) error {
 		return err
 	}
 	mergeServerCmdline(config, cmdline)
-	applyGlobalConfig(config)
+	err = applyGlobalConfig(config)
+	if err != nil {
+		return err
+	}
 	return cmdline.runner.Server(&config.Server)
 }
 
@@ -85,6 +101,194 @@ func mergeServerCmdline(config *config.Config, cmdline *cmdline) {
 	if cmdline.Server.StatePath != "" {
 		config.Server.StatePath = cmdline.Server.StatePath
 	}
+	if cmdline.Server.ForceUnlock {
+		config.Server.ForceUnlock = true
+	}
+}
+
+type offlineCmd struct {
+	Config       string                 `help:"The configuration file to use (defaults to /etc/certd/certd.yaml if existent)"`
+	StorePath    string                 `help:"The store path to use (defaults to configuration file value)"`
+	ForceUnlock  bool                   `help:"Break a stale store lock left behind by a no longer running process"`
+	Generate     offlineGenerateCmd     `cmd:"" help:"Generate a key and certificate"`
+	Sign         offlineSignCmd         `cmd:"" help:"Sign a certificate request"`
+	Export       offlineExportCmd       `cmd:"" help:"Export a certificate or key"`
+	Diff         offlineDiffCmd         `cmd:"" help:"Compare two certificates"`
+	GC           offlineGCCmd           `cmd:"" name:"gc" help:"Report (and optionally remove) orphaned store files"`
+	RotateSecret offlineRotateSecretCmd `cmd:"" help:"Replace the store secret and re-encrypt all keys"`
+}
+
+type offlineGenerateCmd struct {
+	Name     string        `arg:"" help:"The name of the store entry to create"`
+	DN       string        `required:"" help:"The Distinguished Name of the certificate"`
+	KeyType  string        `default:"ECDSA P-256" help:"The key type to generate"`
+	Issuer   string        `help:"The store entry to sign the certificate with (self-signed if empty)"`
+	Validity time.Duration `default:"8760h" help:"The validity period of the certificate"`
+	CA       bool          `help:"Generate a CA certificate"`
+	PathLen  int           `default:"-1" help:"The path length constraint of a CA certificate (-1 for none)"`
+	DNSName  []string      `help:"The DNS names of the certificate"`
+}
+
+func (cmd *offlineGenerateCmd) Run(cmdline *cmdline) error {
+	return cmdline.runOffline(&offline.GenerateCommand{
+		Name:     cmd.Name,
+		DN:       cmd.DN,
+		KeyType:  cmd.KeyType,
+		Issuer:   cmd.Issuer,
+		Validity: cmd.Validity,
+		CA:       cmd.CA,
+		PathLen:  cmd.PathLen,
+		DNSNames: cmd.DNSName,
+	})
+}
+
+type offlineSignCmd struct {
+	Name     string        `arg:"" help:"The name of the store entry to create"`
+	CSR      string        `required:"" type:"existingfile" help:"The certificate request file (PEM or DER encoded)"`
+	Issuer   string        `required:"" help:"The store entry to sign the certificate with"`
+	Validity time.Duration `default:"8760h" help:"The validity period of the certificate"`
+	CA       bool          `help:"Issue a CA certificate"`
+	PathLen  int           `default:"-1" help:"The path length constraint of a CA certificate (-1 for none)"`
+}
+
+func (cmd *offlineSignCmd) Run(cmdline *cmdline) error {
+	return cmdline.runOffline(&offline.SignCommand{
+		Name:     cmd.Name,
+		CSRFile:  cmd.CSR,
+		Issuer:   cmd.Issuer,
+		Validity: cmd.Validity,
+		CA:       cmd.CA,
+		PathLen:  cmd.PathLen,
+	})
+}
+
+type offlineExportCmd struct {
+	Name     string `arg:"" help:"The name of the store entry to export"`
+	Format   string `default:"crt" enum:"crt,key,pkcs8,pkcs12,openssh,openssh-pub" help:"The export format (crt, key, pkcs8, pkcs12, openssh, openssh-pub)"`
+	Password string `help:"The PKCS#8 or PKCS#12 password"`
+	Out      string `help:"The file to write to (defaults to stdout)"`
+}
+
+func (cmd *offlineExportCmd) Run(cmdline *cmdline) error {
+	return cmdline.runOffline(&offline.ExportCommand{
+		Name:     cmd.Name,
+		Format:   cmd.Format,
+		Password: cmd.Password,
+		Out:      cmd.Out,
+	})
+}
+
+type offlineDiffCmd struct {
+	Old string `arg:"" help:"The store entry or certificate file to compare"`
+	New string `arg:"" help:"The store entry or certificate file to compare with"`
+}
+
+func (cmd *offlineDiffCmd) Run(cmdline *cmdline) error {
+	return cmdline.runOffline(&offline.DiffCommand{
+		Old: cmd.Old,
+		New: cmd.New,
+	})
+}
+
+type offlineGCCmd struct {
+	Remove bool `help:"Remove the orphaned files (report only if not set)"`
+}
+
+func (cmd *offlineGCCmd) Run(cmdline *cmdline) error {
+	return cmdline.runOffline(&offline.GCCommand{
+		Remove: cmd.Remove,
+	})
+}
+
+type offlineRotateSecretCmd struct{}
+
+func (cmd *offlineRotateSecretCmd) Run(cmdline *cmdline) error {
+	return cmdline.runOffline(&offline.RotateSecretCommand{})
+}
+
+type devCmd struct {
+	ACME devACMECmd `cmd:"" name:"acme" help:"Run an in-process ACME test server (until interrupted)"`
+	Load devLoadCmd `cmd:"" help:"Run a load test against a server and check the performance budget"`
+}
+
+type devACMECmd struct {
+	Listen         string        `default:"localhost:14000" help:"The address to listen on"`
+	TLS            bool          `name:"tls" help:"Serve via TLS (using a certificate issued by the test CA)"`
+	HTTPPort       int           `default:"5002" help:"The port to connect to for validating HTTP-01 challenges"`
+	TLSPort        int           `default:"5001" help:"The port to connect to for validating TLS-ALPN-01 challenges"`
+	SkipValidation bool          `help:"Accept all challenges without validating them"`
+	Validity       time.Duration `default:"2160h" help:"The validity period of issued certificates"`
+	CAFile         string        `help:"The file to write the test CA certificate to (e.g. for LEGO_CA_CERTIFICATES)"`
+}
+
+func (cmd *devACMECmd) Run(cmdline *cmdline) error {
+	return cmdline.runner.DevACME(&acmetest.Config{
+		Listen:         cmd.Listen,
+		TLS:            cmd.TLS,
+		HTTPPort:       cmd.HTTPPort,
+		TLSPort:        cmd.TLSPort,
+		SkipValidation: cmd.SkipValidation,
+		Validity:       cmd.Validity,
+	}, cmd.CAFile)
+}
+
+type devLoadCmd struct {
+	ServerURL   string        `default:"http://localhost:10509" help:"The URL of the server to load"`
+	Operation   string        `default:"entries" enum:"entries,generate" help:"The operation to run (entries, generate)"`
+	Concurrency int           `default:"4" help:"The number of concurrent clients"`
+	Duration    time.Duration `default:"10s" help:"The duration of the load test"`
+	KeyType     string        `default:"ECDSA P-256" help:"The key type to generate (for operation generate)"`
+	MaxP99      time.Duration `name:"max-p99" help:"Fail if the 99th percentile latency exceeds this value"`
+	MinRate     float64       `help:"Fail if fewer requests per second are completed"`
+}
+
+func (cmd *devLoadCmd) Run(cmdline *cmdline) error {
+	return cmdline.runner.DevLoad(&loadtest.Config{
+		ServerURL:   cmd.ServerURL,
+		Operation:   cmd.Operation,
+		Concurrency: cmd.Concurrency,
+		Duration:    cmd.Duration,
+		KeyType:     cmd.KeyType,
+		MaxP99:      cmd.MaxP99,
+		MinRate:     cmd.MinRate,
+	})
+}
+
+func (cmdline *cmdline) runOffline(command offline.Command) error {
+	configPath := cmdline.Offline.Config
+	var loaded *config.Config
+	if configPath == "" {
+		_, err := os.Stat(defaultServerConfigPath)
+		if err == nil {
+			configPath = defaultServerConfigPath
+		}
+	}
+	if configPath != "" {
+		var err error
+		loaded, err = config.Load(configPath)
+		if err != nil {
+			return err
+		}
+	} else {
+		loaded = config.Defaults()
+		loaded.Server.BasePath = "."
+	}
+	mergeOfflineCmdline(loaded, cmdline)
+	err := applyGlobalConfig(loaded)
+	if err != nil {
+		return err
+	}
+	return cmdline.runner.Offline(&loaded.Server, command)
+}
+
+func mergeOfflineCmdline(config *config.Config, cmdline *cmdline) {
+	mergeGlobalCmdline(config, cmdline)
+	if cmdline.Offline.StorePath != "" {
+		config.Server.StorePath = cmdline.Offline.StorePath
+	}
+	if cmdline.Offline.ForceUnlock {
+		config.Server.ForceUnlock = true
+	}
 }
 
 func mergeGlobalCmdline(config *config.Config, cmdline *cmdline) {
@@ -99,15 +303,36 @@ func mergeGlobalCmdline(config *config.Config, cmdline *cmdline) {
 	}
 }
 
-func applyGlobalConfig(config *config.Config) {
-	logger := logging.NewConsoleLogger(os.Stdout, config.ANSI)
+func applyGlobalConfig(config *config.Config) error {
+	var out io.Writer = os.Stdout
+	logFile := config.Log.ResolveFile()
+	if logFile != "" {
+		rotatingFile, err := logging.NewRotatingFile(logFile, config.Log.MaxSize, config.Log.MaxAge, config.Log.MaxBackups)
+		if err != nil {
+			return err
+		}
+		out = rotatingFile
+	}
+	logger, err := logging.NewLogger(out, config.Log.Format, config.ANSI)
+	if err != nil {
+		return err
+	}
+	level := zerolog.WarnLevel
 	if config.Debug {
-		logging.UpdateRootLogger(logger, zerolog.DebugLevel)
+		level = zerolog.DebugLevel
 	} else if config.Verbose {
-		logging.UpdateRootLogger(logger, zerolog.InfoLevel)
-	} else {
-		logging.UpdateRootLogger(logger, zerolog.WarnLevel)
+		level = zerolog.InfoLevel
 	}
+	moduleLevels := make(map[string]zerolog.Level)
+	for module, moduleLevelName := range config.Log.Levels {
+		moduleLevel, err := zerolog.ParseLevel(moduleLevelName)
+		if err != nil {
+			return fmt.Errorf("invalid log level '%s' for module '%s' (cause: %w)", moduleLevelName, module, err)
+		}
+		moduleLevels[module] = moduleLevel
+	}
+	logging.UpdateLoggers(logger, level, moduleLevels)
+	return nil
 }
 
 func Run(runner Runner) error {
@@ -152,3 +377,41 @@ func (runner *cmdlineRunner) Version() error {
 func (runner *cmdlineRunner) Server(config *config.ServerConfig) error {
 	return server.Run(config)
 }
+
+func (runner *cmdlineRunner) Offline(config *config.ServerConfig, command offline.Command) error {
+	return offline.Run(config, command)
+}
+
+func (runner *cmdlineRunner) DevACME(config *acmetest.Config, caFile string) error {
+	server, err := acmetest.Start(config)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+	if caFile != "" {
+		err = os.WriteFile(caFile, server.IssuerPEM(), 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write test CA certificate to '%s' (cause: %w)", caFile, err)
+		}
+	}
+	fmt.Printf("ACME test server running; directory URL: %s (press Ctrl-C to stop)\n", server.DirectoryURL())
+	sigint := make(chan os.Signal, 1)
+	signal.Notify(sigint, os.Interrupt)
+	defer signal.Stop(sigint)
+	<-sigint
+	return nil
+}
+
+func (runner *cmdlineRunner) DevLoad(config *loadtest.Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := loadtest.Run(ctx, config)
+	if err != nil {
+		return err
+	}
+	err = report.Write(os.Stdout)
+	if err != nil {
+		return err
+	}
+	return report.Check(config)
+}
@@ -20,8 +20,12 @@ package certd
 import (
 	"os"
 	"testing"
+	"time"
 
+	"github.com/hdecarne-github/certd/internal/acmetest"
 	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/loadtest"
+	"github.com/hdecarne-github/certd/internal/offline"
 	"github.com/stretchr/testify/require"
 )
 
@@ -54,12 +58,71 @@ func TestCmdline(t *testing.T) {
 	require.Equal(t, "https://certd.mydomain.org", runner.lastServerConfig.ServerURL)
 	require.Equal(t, "./store", runner.lastServerConfig.StorePath)
 	require.Equal(t, "./state", runner.lastServerConfig.StatePath)
+
+	// <command> server --config=../../certd.yaml --force-unlock
+	os.Args = []string{os.Args[0], "server", "--config=../../certd.yaml", "--force-unlock"}
+	err = Run(runner)
+	require.NoError(t, err)
+	require.Equal(t, 3, runner.serverCalls)
+	require.NotNil(t, runner.lastServerConfig)
+	require.Equal(t, "/var/lib/certd/store", runner.lastServerConfig.StorePath)
+	require.True(t, runner.lastServerConfig.ForceUnlock)
+
+	// <command> offline --config=../../certd.yaml --store-path=./store generate root --dn=CN=Root --ca --path-len=1
+	os.Args = []string{os.Args[0], "offline", "--config=../../certd.yaml", "--store-path=./store", "generate", "root", "--dn=CN=Root", "--ca", "--path-len=1"}
+	err = Run(runner)
+	require.NoError(t, err)
+	require.Equal(t, 1, runner.offlineCalls)
+	require.Equal(t, "./store", runner.lastServerConfig.StorePath)
+	require.Equal(t, &offline.GenerateCommand{Name: "root", DN: "CN=Root", KeyType: "ECDSA P-256", Validity: 8760 * time.Hour, CA: true, PathLen: 1}, runner.lastOfflineCommand)
+
+	// <command> offline --config=../../certd.yaml export root --format=pkcs12 --password=secret --out=root.p12
+	os.Args = []string{os.Args[0], "offline", "--config=../../certd.yaml", "export", "root", "--format=pkcs12", "--password=secret", "--out=root.p12"}
+	err = Run(runner)
+	require.NoError(t, err)
+	require.Equal(t, 2, runner.offlineCalls)
+	require.Equal(t, "/var/lib/certd/store", runner.lastServerConfig.StorePath)
+	require.Equal(t, &offline.ExportCommand{Name: "root", Format: "pkcs12", Password: "secret", Out: "root.p12"}, runner.lastOfflineCommand)
+
+	// <command> offline --config=../../certd.yaml gc --remove
+	os.Args = []string{os.Args[0], "offline", "--config=../../certd.yaml", "gc", "--remove"}
+	err = Run(runner)
+	require.NoError(t, err)
+	require.Equal(t, 3, runner.offlineCalls)
+	require.Equal(t, &offline.GCCommand{Remove: true}, runner.lastOfflineCommand)
+
+	// <command> offline --config=../../certd.yaml rotate-secret
+	os.Args = []string{os.Args[0], "offline", "--config=../../certd.yaml", "rotate-secret"}
+	err = Run(runner)
+	require.NoError(t, err)
+	require.Equal(t, 4, runner.offlineCalls)
+	require.Equal(t, &offline.RotateSecretCommand{}, runner.lastOfflineCommand)
+
+	// <command> dev acme --listen=localhost:14001 --skip-validation
+	os.Args = []string{os.Args[0], "dev", "acme", "--listen=localhost:14001", "--skip-validation"}
+	err = Run(runner)
+	require.NoError(t, err)
+	require.Equal(t, 1, runner.devACMECalls)
+	require.Equal(t, &acmetest.Config{Listen: "localhost:14001", HTTPPort: 5002, TLSPort: 5001, SkipValidation: true, Validity: 2160 * time.Hour}, runner.lastDevACMEConfig)
+
+	// <command> dev load --operation=generate --duration=1m --max-p99=500ms --min-rate=10
+	os.Args = []string{os.Args[0], "dev", "load", "--operation=generate", "--duration=1m", "--max-p99=500ms", "--min-rate=10"}
+	err = Run(runner)
+	require.NoError(t, err)
+	require.Equal(t, 1, runner.devLoadCalls)
+	require.Equal(t, &loadtest.Config{ServerURL: "http://localhost:10509", Operation: "generate", Concurrency: 4, Duration: time.Minute, KeyType: "ECDSA P-256", MaxP99: 500 * time.Millisecond, MinRate: 10}, runner.lastDevLoadConfig)
 }
 
 type testRunner struct {
-	versionCalls     int
-	serverCalls      int
-	lastServerConfig *config.ServerConfig
+	versionCalls       int
+	serverCalls        int
+	offlineCalls       int
+	lastServerConfig   *config.ServerConfig
+	lastOfflineCommand offline.Command
+	devACMECalls       int
+	lastDevACMEConfig  *acmetest.Config
+	devLoadCalls       int
+	lastDevLoadConfig  *loadtest.Config
 }
 
 func (runner *testRunner) Version() error {
@@ -72,3 +135,22 @@ func (runner *testRunner) Server(config *config.ServerConfig) error {
 	runner.lastServerConfig = config
 	return nil
 }
+
+func (runner *testRunner) Offline(config *config.ServerConfig, command offline.Command) error {
+	runner.offlineCalls += 1
+	runner.lastServerConfig = config
+	runner.lastOfflineCommand = command
+	return nil
+}
+
+func (runner *testRunner) DevACME(config *acmetest.Config, caFile string) error {
+	runner.devACMECalls += 1
+	runner.lastDevACMEConfig = config
+	return nil
+}
+
+func (runner *testRunner) DevLoad(config *loadtest.Config) error {
+	runner.devLoadCalls += 1
+	runner.lastDevLoadConfig = config
+	return nil
+}
@@ -0,0 +1,28 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certd
+
+// Upstream CA provider types available in the provider configuration (registered during package initialization).
+import (
+	_ "github.com/hdecarne-github/certd/pkg/certs/adcs"
+	_ "github.com/hdecarne-github/certd/pkg/certs/awspca"
+	_ "github.com/hdecarne-github/certd/pkg/certs/cmp"
+	_ "github.com/hdecarne-github/certd/pkg/certs/googlecas"
+	_ "github.com/hdecarne-github/certd/pkg/certs/stepca"
+	_ "github.com/hdecarne-github/certd/pkg/certs/vaultpki"
+)
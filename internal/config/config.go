@@ -18,11 +18,23 @@
 package config
 
 import (
+	"crypto/x509/pkix"
 	_ "embed"
+	"encoding/asn1"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
 
+	"github.com/hdecarne-github/certd/pkg/clock"
+	"github.com/hdecarne-github/certd/pkg/keys/registry"
 	"gopkg.in/yaml.v3"
 )
 
@@ -44,13 +56,15 @@ func Load(path string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read configuration file '%s' (cause: %w)", path, err)
 	}
-	err = yaml.Unmarshal(configBytes, config)
+	basePath := filepath.Dir(path)
+	err = UnmarshalExpanded(configBytes, basePath, config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal configuration file '%s' (cause: %w)", path, err)
 	}
-	basePath := filepath.Dir(path)
 	config.Server.BasePath = basePath
+	config.Server.ConfigFile = path
 	config.CLI.BasePath = basePath
+	config.Log.BasePath = basePath
 	return config, nil
 }
 
@@ -58,16 +72,61 @@ type Config struct {
 	Debug   bool         `yaml:"debug"`
 	Verbose bool         `yaml:"verbose"`
 	ANSI    bool         `yaml:"ansi"`
+	Log     LogConfig    `yaml:"log"`
 	Server  ServerConfig `yaml:"server"`
 	CLI     CLIConfig    `yaml:"cli"`
 }
 
+type LogConfig struct {
+	BasePath   string            `yaml:"-"`
+	Format     string            `yaml:"format"`
+	File       string            `yaml:"file"`
+	MaxSize    int64             `yaml:"max_size"`
+	MaxAge     time.Duration     `yaml:"max_age"`
+	MaxBackups int               `yaml:"max_backups"`
+	Levels     map[string]string `yaml:"levels"`
+}
+
+func (config *LogConfig) ResolveFile() string {
+	if config.File == "" {
+		return ""
+	}
+	return ResolvePath(config.BasePath, config.File)
+}
+
 type ServerConfig struct {
-	BasePath   string `yaml:"-"`
-	ServerURL  string `yaml:"server_url"`
-	StorePath  string `yaml:"store_path"`
-	StatePath  string `yaml:"state_path"`
-	ACMEConfig string `yaml:"acme_config"`
+	BasePath        string             `yaml:"-"`
+	ConfigFile      string             `yaml:"-"`
+	ServerURL       string             `yaml:"server_url"`
+	StorePath       string             `yaml:"store_path"`
+	StorePerms      string             `yaml:"store_permissions"`
+	StoreLock       string             `yaml:"store_lock"`
+	StoreCrypt      string             `yaml:"store_encryption"`
+	StoreCache      StoreCacheConfig   `yaml:"store_cache"`
+	ForceUnlock     bool               `yaml:"-"`
+	Umask           string             `yaml:"umask"`
+	StatePath       string             `yaml:"state_path"`
+	State           StateConfig        `yaml:"state"`
+	ACMEConfig      string             `yaml:"acme_config"`
+	ProvidersConfig string             `yaml:"providers_config"`
+	ConfigWatch     time.Duration      `yaml:"config_watch"`
+	Bootstrap       BootstrapConfig    `yaml:"bootstrap"`
+	Local           LocalConfig        `yaml:"local"`
+	Notify          NotifyConfig       `yaml:"notify"`
+	TLSChecks       TLSChecksConfig    `yaml:"tls_checks"`
+	CTMonitor       CTMonitorConfig    `yaml:"ct_monitor"`
+	Trust           TrustConfig        `yaml:"trust"`
+	Reissue         ReissueConfig      `yaml:"reissue"`
+	Retention       RetentionConfig    `yaml:"retention"`
+	Publish         PublishConfig      `yaml:"publish"`
+	SecretDeploy    SecretDeployConfig `yaml:"secret_deploy"`
+	CodeSigning     CodeSigningConfig  `yaml:"code_signing"`
+	TSA             TSAConfig          `yaml:"tsa"`
+	Admin           AdminConfig        `yaml:"admin"`
+	PIV             PIVConfig          `yaml:"piv"`
+	KeyPolicy       KeyPolicyConfig    `yaml:"key_policy"`
+	Workers         WorkersConfig      `yaml:"workers"`
+	Testing         TestingConfig      `yaml:"testing"`
 }
 
 func (config *ServerConfig) ResolveStorePath() string {
@@ -82,6 +141,535 @@ func (config *ServerConfig) ResolveACMEConfig() string {
 	return ResolvePath(config.BasePath, config.ACMEConfig)
 }
 
+func (config *ServerConfig) ResolveProvidersConfig() string {
+	return ResolvePath(config.BasePath, config.ProvidersConfig)
+}
+
+// Validate the configuration options which can be changed during a configuration reload.
+func (config *ServerConfig) Validate() error {
+	if config.Local.DNTemplate != "" {
+		_, err := template.New("dn_template").Parse(config.Local.DNTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid DN template '%s' (cause: %w)", config.Local.DNTemplate, err)
+		}
+	}
+	for _, emailPattern := range config.Local.SMIME.EmailPatterns {
+		_, err := path.Match(emailPattern, "")
+		if err != nil {
+			return fmt.Errorf("invalid email pattern '%s' (cause: %w)", emailPattern, err)
+		}
+	}
+	for issuer, policy := range config.Local.Policies {
+		err := policy.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid name policy for issuer '%s' (cause: %w)", issuer, err)
+		}
+	}
+	err := config.Retention.Validate()
+	if err != nil {
+		return err
+	}
+	if config.CodeSigning.TSAURL != "" {
+		_, err := url.Parse(config.CodeSigning.TSAURL)
+		if err != nil {
+			return fmt.Errorf("invalid TSA URL '%s' (cause: %w)", config.CodeSigning.TSAURL, err)
+		}
+	}
+	return nil
+}
+
+func (config *ServerConfig) ResolveAttestationRoots() string {
+	if config.Local.AttestationRoots == "" {
+		return ""
+	}
+	return ResolvePath(config.BasePath, config.Local.AttestationRoots)
+}
+
+type LocalConfig struct {
+	DNTemplate       string                      `yaml:"dn_template"`
+	DNDefaults       DNDefaultsConfig            `yaml:"dn_defaults"`
+	AttestationRoots string                      `yaml:"attestation_roots"`
+	SMIME            SMIMEConfig                 `yaml:"smime"`
+	NotBeforeSkew    time.Duration               `yaml:"not_before_skew"`
+	Policies         map[string]NamePolicyConfig `yaml:"policies"`
+}
+
+type SMIMEConfig struct {
+	EmailPatterns []string `yaml:"email_patterns"`
+}
+
+// Check whether the given email address is allowed by the configured S/MIME email patterns.
+//
+// If no patterns are configured, all email addresses are allowed.
+func (config *SMIMEConfig) MatchEmail(email string) bool {
+	if len(config.EmailPatterns) == 0 {
+		return true
+	}
+	for _, emailPattern := range config.EmailPatterns {
+		matched, err := path.Match(strings.ToLower(emailPattern), strings.ToLower(email))
+		if err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+type NamePolicyConfig struct {
+	DNSNames     []string `yaml:"dns_names"`
+	IPRanges     []string `yaml:"ip_ranges"`
+	EmailDomains []string `yaml:"email_domains"`
+	URISchemes   []string `yaml:"uri_schemes"`
+}
+
+const namePolicyRegexPrefix = "regex:"
+
+// Get the name policy of the given issuer (nil if the issuer is not restricted).
+func (config *LocalConfig) NamePolicy(issuer string) *NamePolicyConfig {
+	policy, found := config.Policies[issuer]
+	if !found {
+		return nil
+	}
+	return &policy
+}
+
+// Validate the rules of the name policy.
+func (policy *NamePolicyConfig) Validate() error {
+	for _, rule := range policy.DNSNames {
+		if strings.HasPrefix(rule, namePolicyRegexPrefix) {
+			_, err := regexp.Compile(strings.TrimPrefix(rule, namePolicyRegexPrefix))
+			if err != nil {
+				return fmt.Errorf("invalid DNS name rule '%s' (cause: %w)", rule, err)
+			}
+		}
+	}
+	for _, rule := range policy.IPRanges {
+		_, _, err := net.ParseCIDR(rule)
+		if err != nil {
+			return fmt.Errorf("invalid IP range rule '%s' (cause: %w)", rule, err)
+		}
+	}
+	return nil
+}
+
+// Check whether the given names are allowed by the name policy.
+//
+// DNS name and email domain rules are either domain suffixes (e.g. "team.example.com" matching the domain
+// itself as well as all its subdomains) or regular expressions prefixed with "regex:". Names of a type
+// without any rules are rejected.
+func (policy *NamePolicyConfig) Check(dnsNames []string, ips []net.IP, emails []string, uris []*url.URL) error {
+	for _, dnsName := range dnsNames {
+		if !matchDomainRules(policy.DNSNames, dnsName) {
+			return fmt.Errorf("DNS name '%s' not allowed by policy", dnsName)
+		}
+	}
+	for _, ip := range ips {
+		if !matchIPRangeRules(policy.IPRanges, ip) {
+			return fmt.Errorf("IP address '%s' not allowed by policy", ip)
+		}
+	}
+	for _, email := range emails {
+		at := strings.LastIndex(email, "@")
+		if at < 0 || !matchDomainRules(policy.EmailDomains, email[at+1:]) {
+			return fmt.Errorf("email address '%s' not allowed by policy", email)
+		}
+	}
+	for _, uri := range uris {
+		if !matchSchemeRules(policy.URISchemes, uri.Scheme) {
+			return fmt.Errorf("URI '%s' not allowed by policy", uri)
+		}
+	}
+	return nil
+}
+
+func matchDomainRules(rules []string, domain string) bool {
+	domain = strings.ToLower(domain)
+	for _, rule := range rules {
+		if strings.HasPrefix(rule, namePolicyRegexPrefix) {
+			pattern, err := regexp.Compile("^(?:" + strings.TrimPrefix(rule, namePolicyRegexPrefix) + ")$")
+			if err == nil && pattern.MatchString(domain) {
+				return true
+			}
+			continue
+		}
+		suffix := strings.ToLower(strings.TrimPrefix(rule, "."))
+		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchIPRangeRules(rules []string, ip net.IP) bool {
+	for _, rule := range rules {
+		_, ipRange, err := net.ParseCIDR(rule)
+		if err == nil && ipRange.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSchemeRules(rules []string, scheme string) bool {
+	for _, rule := range rules {
+		if strings.EqualFold(rule, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+type CodeSigningConfig struct {
+	TSAURL string `yaml:"tsa_url"`
+}
+
+type BootstrapConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	CAEntry     string        `yaml:"ca_entry"`
+	CADN        string        `yaml:"ca_dn"`
+	CAValidity  time.Duration `yaml:"ca_validity"`
+	ServerEntry string        `yaml:"server_entry"`
+	ServerDN    string        `yaml:"server_dn"`
+	DNSNames    []string      `yaml:"dns_names"`
+	Validity    time.Duration `yaml:"validity"`
+	KeyType     string        `yaml:"key_type"`
+}
+
+type TSAConfig struct {
+	Entry  string `yaml:"entry"`
+	Policy string `yaml:"policy"`
+}
+
+type StateConfig struct {
+	Backend string           `yaml:"backend"`
+	Encrypt bool             `yaml:"encrypt"`
+	SQL     SQLStateConfig   `yaml:"sql"`
+	Vault   VaultStateConfig `yaml:"vault"`
+}
+
+type SQLStateConfig struct {
+	Driver string `yaml:"driver"`
+	DSN    string `yaml:"dsn"`
+	Table  string `yaml:"table"`
+}
+
+type VaultStateConfig struct {
+	Address string `yaml:"address"`
+	Token   string `yaml:"token"`
+	Mount   string `yaml:"mount"`
+	Prefix  string `yaml:"prefix"`
+}
+
+type PIVConfig struct {
+	Serial        uint32 `yaml:"serial"`
+	Slot          string `yaml:"slot"`
+	Algorithm     string `yaml:"algorithm"`
+	PINPolicy     string `yaml:"pin_policy"`
+	TouchPolicy   string `yaml:"touch_policy"`
+	PIN           string `yaml:"pin"`
+	ManagementKey string `yaml:"management_key"`
+}
+
+type KeyPolicyConfig struct {
+	MinRSABits    int      `yaml:"min_rsa_bits"`
+	AllowedCurves []string `yaml:"allowed_curves"`
+	ACMEForbidden []string `yaml:"acme_forbidden"`
+	FIPS          bool     `yaml:"fips"`
+	Experimental  bool     `yaml:"experimental"`
+}
+
+// Get the key policy defined by this configuration.
+func (config *KeyPolicyConfig) Policy() *registry.Policy {
+	return &registry.Policy{
+		MinRSABits:    config.MinRSABits,
+		AllowedCurves: config.AllowedCurves,
+		ACMEForbidden: config.ACMEForbidden,
+		FIPS:          config.FIPS,
+		Experimental:  config.Experimental,
+	}
+}
+
+type StoreCacheConfig struct {
+	Capacity uint64 `yaml:"capacity"`
+	Warm     bool   `yaml:"warm"`
+}
+
+type WorkersConfig struct {
+	KeyGeneration int `yaml:"key_generation"`
+	Jobs          int `yaml:"jobs"`
+}
+
+type TestingConfig struct {
+	Time time.Time `yaml:"time"`
+}
+
+// Apply the fixed clock (if configured).
+//
+// Returns true if the fixed clock has been applied.
+func (config *TestingConfig) Apply() bool {
+	if config.Time.IsZero() {
+		return false
+	}
+	clock.Set(config.Clock())
+	return true
+}
+
+// Get the clock defined by this configuration (the fixed clock if configured and the system clock otherwise).
+func (config *TestingConfig) Clock() clock.Clock {
+	if config.Time.IsZero() {
+		return clock.System
+	}
+	return clock.Fixed(config.Time)
+}
+
+type AdminConfig struct {
+	Token string `yaml:"token"`
+	PProf bool   `yaml:"pprof"`
+}
+
+type NotifyConfig struct {
+	WebhookURL string `yaml:"webhook_url"`
+}
+
+type TLSChecksConfig struct {
+	Interval      time.Duration    `yaml:"interval"`
+	ExpiryWarning time.Duration    `yaml:"expiry_warning"`
+	Targets       []TLSCheckTarget `yaml:"targets"`
+}
+
+type TLSCheckTarget struct {
+	Address string `yaml:"address"`
+	Entry   string `yaml:"entry"`
+}
+
+type CTMonitorConfig struct {
+	Interval  time.Duration `yaml:"interval"`
+	SourceURL string        `yaml:"source_url"`
+	Domains   []string      `yaml:"domains"`
+}
+
+type TrustConfig struct {
+	Interval time.Duration       `yaml:"interval"`
+	Sources  []TrustSourceConfig `yaml:"sources"`
+}
+
+type TrustSourceConfig struct {
+	Name   string `yaml:"name"`
+	System bool   `yaml:"system"`
+	URL    string `yaml:"url"`
+}
+
+type ReissueConfig struct {
+	Interval time.Duration   `yaml:"interval"`
+	Targets  []ReissueTarget `yaml:"targets"`
+}
+
+type RetentionConfig struct {
+	MinArchiveAge time.Duration   `yaml:"min_archive_age"`
+	RetainCAs     bool            `yaml:"retain_cas"`
+	Interval      time.Duration   `yaml:"interval"`
+	DryRun        bool            `yaml:"dry_run"`
+	Rules         []RetentionRule `yaml:"rules"`
+}
+
+// Retention kinds a rule can be restricted to.
+const (
+	RetentionKindCA   = "ca"
+	RetentionKindLeaf = "leaf"
+)
+
+type RetentionRule struct {
+	Kind             string        `yaml:"kind"`
+	PurgeAfterExpiry time.Duration `yaml:"purge_after_expiry"`
+	Retain           bool          `yaml:"retain"`
+}
+
+// Get the first rule matching the given entry kind (nil if no rule matches).
+func (config *RetentionConfig) MatchRule(ca bool) *RetentionRule {
+	kind := RetentionKindLeaf
+	if ca {
+		kind = RetentionKindCA
+	}
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if rule.Kind == "" || rule.Kind == kind {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Validate the retention rules.
+func (config *RetentionConfig) Validate() error {
+	for _, rule := range config.Rules {
+		switch rule.Kind {
+		case "", RetentionKindCA, RetentionKindLeaf:
+		default:
+			return fmt.Errorf("unrecognized retention rule kind '%s'", rule.Kind)
+		}
+	}
+	return nil
+}
+
+// Check whether an entry archived at the given time may be purged.
+func (config *RetentionConfig) CheckPurge(archived time.Time, ca bool, now time.Time) error {
+	if ca && config.RetainCAs {
+		return errors.New("CA entries are retained")
+	}
+	retainedUntil := archived.Add(config.MinArchiveAge)
+	if now.Before(retainedUntil) {
+		return fmt.Errorf("archived entries are retained until %s", retainedUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+type ReissueTarget struct {
+	Entry       string        `yaml:"entry"`
+	Issuer      string        `yaml:"issuer"`
+	Lifetime    time.Duration `yaml:"lifetime"`
+	RenewBefore time.Duration `yaml:"renew_before"`
+	KeyType     string        `yaml:"key_type"`
+	Deploy      DeployConfig  `yaml:"deploy"`
+}
+
+const defaultReissueLifetime = 24 * time.Hour
+
+// Get the validity period of the re-issued certificates (defaults to 24h).
+func (target *ReissueTarget) ResolveLifetime() time.Duration {
+	if target.Lifetime <= 0 {
+		return defaultReissueLifetime
+	}
+	return target.Lifetime
+}
+
+// Get the remaining validity below which the certificate is re-issued (defaults to a third of the lifetime).
+func (target *ReissueTarget) ResolveRenewBefore() time.Duration {
+	if target.RenewBefore <= 0 {
+		return target.ResolveLifetime() / 3
+	}
+	return target.RenewBefore
+}
+
+type DeployConfig struct {
+	CertFile string   `yaml:"cert_file"`
+	KeyFile  string   `yaml:"key_file"`
+	Command  []string `yaml:"command"`
+}
+
+type SecretDeployConfig struct {
+	Targets []SecretDeployTarget `yaml:"targets"`
+}
+
+type SecretDeployTarget struct {
+	Name    string                  `yaml:"name"`
+	Type    string                  `yaml:"type"`
+	Entries []string                `yaml:"entries"`
+	Labels  map[string]string       `yaml:"labels"`
+	Secret  string                  `yaml:"secret"`
+	AWS     AWSSecretsManagerConfig `yaml:"aws"`
+	GCP     GCPSecretManagerConfig  `yaml:"gcp"`
+	Azure   AzureKeyVaultConfig     `yaml:"azure"`
+	File    FileDeployConfig        `yaml:"file"`
+}
+
+// Check whether the given store entry is subject to this target (either listed explicitly or matching all labels).
+func (target *SecretDeployTarget) Matches(name string, labels map[string]string) bool {
+	for _, entry := range target.Entries {
+		if entry == name {
+			return true
+		}
+	}
+	if len(target.Labels) == 0 {
+		return false
+	}
+	for key, value := range target.Labels {
+		labelValue, ok := labels[key]
+		if !ok || labelValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+type AWSSecretsManagerConfig struct {
+	Region          string `yaml:"region"`
+	Endpoint        string `yaml:"endpoint"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token"`
+	Profile         string `yaml:"profile"`
+}
+
+type GCPSecretManagerConfig struct {
+	Project     string `yaml:"project"`
+	Credentials string `yaml:"credentials"`
+	Endpoint    string `yaml:"endpoint"`
+}
+
+type AzureKeyVaultConfig struct {
+	VaultURL      string `yaml:"vault_url"`
+	TenantID      string `yaml:"tenant_id"`
+	ClientID      string `yaml:"client_id"`
+	ClientSecret  string `yaml:"client_secret"`
+	LoginEndpoint string `yaml:"login_endpoint"`
+}
+
+type FileDeployConfig struct {
+	CertFile  string   `yaml:"cert_file"`
+	KeyFile   string   `yaml:"key_file"`
+	ChainFile string   `yaml:"chain_file"`
+	Owner     string   `yaml:"owner"`
+	Mode      string   `yaml:"mode"`
+	KeyMode   string   `yaml:"key_mode"`
+	Command   []string `yaml:"command"`
+}
+
+type PublishConfig struct {
+	LDAP LDAPPublishConfig `yaml:"ldap"`
+}
+
+type LDAPPublishConfig struct {
+	URL          string            `yaml:"url"`
+	BindDN       string            `yaml:"bind_dn"`
+	BindPassword string            `yaml:"bind_password"`
+	DNTemplate   string            `yaml:"dn_template"`
+	DNs          map[string]string `yaml:"dns"`
+}
+
+type DNDefaultsConfig struct {
+	Organization       string `yaml:"o"`
+	OrganizationalUnit string `yaml:"ou"`
+	Country            string `yaml:"c"`
+}
+
+var oidCountry = asn1.ObjectIdentifier{2, 5, 4, 6}
+var oidOrganization = asn1.ObjectIdentifier{2, 5, 4, 10}
+var oidOrganizationalUnit = asn1.ObjectIdentifier{2, 5, 4, 11}
+
+// Apply the configured DN defaults to all DN attributes not already set.
+//
+// Missing attributes are prepended as the most significant RDNs (in the order C, O, OU).
+func (config *LocalConfig) ApplyDNDefaults(rdns pkix.RDNSequence) pkix.RDNSequence {
+	defaults := make(pkix.RDNSequence, 0)
+	defaults = appendDNDefault(defaults, rdns, oidCountry, config.DNDefaults.Country)
+	defaults = appendDNDefault(defaults, rdns, oidOrganization, config.DNDefaults.Organization)
+	defaults = appendDNDefault(defaults, rdns, oidOrganizationalUnit, config.DNDefaults.OrganizationalUnit)
+	return append(defaults, rdns...)
+}
+
+func appendDNDefault(defaults pkix.RDNSequence, rdns pkix.RDNSequence, oid asn1.ObjectIdentifier, value string) pkix.RDNSequence {
+	if value == "" {
+		return defaults
+	}
+	for _, rdn := range rdns {
+		for _, atv := range rdn {
+			if atv.Type.Equal(oid) {
+				return defaults
+			}
+		}
+	}
+	return append(defaults, []pkix.AttributeTypeAndValue{{Type: oid, Value: value}})
+}
+
 type CLIConfig struct {
 	BasePath  string `yaml:"-"`
 	ServerURL string `yaml:"server_url"`
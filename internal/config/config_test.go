@@ -18,7 +18,12 @@
 package config
 
 import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -30,11 +35,54 @@ func TestDefaults(t *testing.T) {
 	require.Equal(t, false, config.Debug)
 	require.Equal(t, false, config.Verbose)
 	require.Equal(t, false, config.ANSI)
+	require.Equal(t, "console", config.Log.Format)
+	require.Equal(t, "", config.Log.ResolveFile())
+	require.Equal(t, int64(104857600), config.Log.MaxSize)
+	require.Equal(t, 7, config.Log.MaxBackups)
 	// Server
 	require.Equal(t, "http://localhost:10509", config.Server.ServerURL)
 	require.Equal(t, "/var/lib/certd/store", config.Server.StorePath)
+	require.Equal(t, "enforce", config.Server.StorePerms)
+	require.Equal(t, "refuse", config.Server.StoreLock)
+	require.Equal(t, "keys", config.Server.StoreCrypt)
+	require.Equal(t, "", config.Server.Umask)
 	require.Equal(t, "/var/lib/certd/state", config.Server.StatePath)
+	require.Equal(t, "fs", config.Server.State.Backend)
+	require.True(t, config.Server.State.Encrypt)
+	require.Equal(t, "certd_state", config.Server.State.SQL.Table)
+	require.Equal(t, "secret", config.Server.State.Vault.Mount)
+	require.Equal(t, "certd", config.Server.State.Vault.Prefix)
 	require.Equal(t, "acme.yaml", config.Server.ACMEConfig)
+	require.Equal(t, "providers.yaml", config.Server.ProvidersConfig)
+	require.Equal(t, time.Duration(0), config.Server.ConfigWatch)
+	require.False(t, config.Server.Bootstrap.Enabled)
+	require.Equal(t, "certd-ca", config.Server.Bootstrap.CAEntry)
+	require.Equal(t, "CN=certd Root CA", config.Server.Bootstrap.CADN)
+	require.Equal(t, 87600*time.Hour, config.Server.Bootstrap.CAValidity)
+	require.Equal(t, "certd-server", config.Server.Bootstrap.ServerEntry)
+	require.Equal(t, "CN=localhost", config.Server.Bootstrap.ServerDN)
+	require.Equal(t, []string{"localhost"}, config.Server.Bootstrap.DNSNames)
+	require.Equal(t, 8760*time.Hour, config.Server.Bootstrap.Validity)
+	require.Equal(t, "ECDSA P-256", config.Server.Bootstrap.KeyType)
+	require.Equal(t, 5*time.Minute, config.Server.Local.NotBeforeSkew)
+	require.Equal(t, time.Hour, config.Server.TLSChecks.Interval)
+	require.Equal(t, 720*time.Hour, config.Server.TLSChecks.ExpiryWarning)
+	require.Equal(t, 6*time.Hour, config.Server.CTMonitor.Interval)
+	require.Equal(t, "https://crt.sh/?q={domain}&output=json", config.Server.CTMonitor.SourceURL)
+	require.Equal(t, 24*time.Hour, config.Server.Trust.Interval)
+	require.Equal(t, 10*time.Minute, config.Server.Reissue.Interval)
+	require.Equal(t, 720*time.Hour, config.Server.Retention.MinArchiveAge)
+	require.True(t, config.Server.Retention.RetainCAs)
+	require.Equal(t, 24*time.Hour, config.Server.Retention.Interval)
+	require.False(t, config.Server.Retention.DryRun)
+	require.Equal(t, "9c", config.Server.PIV.Slot)
+	require.Equal(t, "EC256", config.Server.PIV.Algorithm)
+	require.Equal(t, "once", config.Server.PIV.PINPolicy)
+	require.Equal(t, "always", config.Server.PIV.TouchPolicy)
+	require.Equal(t, 2048, config.Server.KeyPolicy.MinRSABits)
+	require.Equal(t, []string{"ED25519"}, config.Server.KeyPolicy.ACMEForbidden)
+	require.False(t, config.Server.KeyPolicy.FIPS)
+	require.False(t, config.Server.KeyPolicy.Experimental)
 	// CLI
 	require.Equal(t, "http://localhost:10509", config.CLI.ServerURL)
 }
@@ -47,11 +95,161 @@ func TestLoad(t *testing.T) {
 	require.Equal(t, true, config.Debug)
 	require.Equal(t, true, config.Verbose)
 	require.Equal(t, true, config.ANSI)
+	require.Equal(t, "json", config.Log.Format)
+	require.Equal(t, "testdata/certd.log", config.Log.ResolveFile())
+	require.Equal(t, int64(1048576), config.Log.MaxSize)
+	require.Equal(t, 24*time.Hour, config.Log.MaxAge)
+	require.Equal(t, 3, config.Log.MaxBackups)
+	require.Equal(t, map[string]string{"acme": "debug"}, config.Log.Levels)
 	// Server
 	require.Equal(t, "https://certd.mydomain.org", config.Server.ServerURL)
 	require.Equal(t, "./store", config.Server.StorePath)
+	require.Equal(t, "repair", config.Server.StorePerms)
+	require.Equal(t, "readonly", config.Server.StoreLock)
+	require.Equal(t, "entries", config.Server.StoreCrypt)
+	require.Equal(t, "0077", config.Server.Umask)
 	require.Equal(t, "./state", config.Server.StatePath)
+	require.Equal(t, "vault", config.Server.State.Backend)
+	require.True(t, config.Server.State.Encrypt)
+	require.Equal(t, "https://vault.mydomain.org:8200", config.Server.State.Vault.Address)
+	require.Equal(t, "vault-token", config.Server.State.Vault.Token)
+	require.Equal(t, "secret", config.Server.State.Vault.Mount)
 	require.Equal(t, "./acme.yaml", config.Server.ACMEConfig)
+	require.Equal(t, "./providers.yaml", config.Server.ProvidersConfig)
+	require.Equal(t, "./testdata/certd-test.yaml", config.Server.ConfigFile)
+	require.Equal(t, 10*time.Second, config.Server.ConfigWatch)
+	require.Equal(t, "CN={{.Name}},OU=Test", config.Server.Local.DNTemplate)
+	require.Equal(t, "Organization", config.Server.Local.DNDefaults.Organization)
+	require.Equal(t, "OrganizationalUnit", config.Server.Local.DNDefaults.OrganizationalUnit)
+	require.Equal(t, "DE", config.Server.Local.DNDefaults.Country)
+	require.Equal(t, 10*time.Minute, config.Server.Local.NotBeforeSkew)
+	require.Equal(t, "https://tsa.mydomain.org", config.Server.CodeSigning.TSAURL)
+	require.Equal(t, "tsa", config.Server.TSA.Entry)
+	require.Equal(t, "1.3.6.1.4.1.99999.1", config.Server.TSA.Policy)
+	require.Equal(t, "admin-token", config.Server.Admin.Token)
+	require.Equal(t, uint32(12345678), config.Server.PIV.Serial)
+	require.Equal(t, "82", config.Server.PIV.Slot)
+	require.Equal(t, "123456", config.Server.PIV.PIN)
+	require.Equal(t, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), config.Server.Testing.Time)
+	require.True(t, config.Server.Admin.PProf)
+	require.Equal(t, "https://hooks.mydomain.org/certd", config.Server.Notify.WebhookURL)
+	require.Equal(t, "secret", config.Server.Publish.LDAP.BindPassword)
+	require.Equal(t, 30*time.Minute, config.Server.TLSChecks.Interval)
+	require.Equal(t, 720*time.Hour, config.Server.TLSChecks.ExpiryWarning)
+	require.Equal(t, []TLSCheckTarget{{Address: "www.mydomain.org:443", Entry: "www"}}, config.Server.TLSChecks.Targets)
+	require.Equal(t, 5*time.Minute, config.Server.Reissue.Interval)
+	require.Equal(t, 24*time.Hour, config.Server.Retention.MinArchiveAge)
+	require.False(t, config.Server.Retention.RetainCAs)
+	require.Equal(t, time.Hour, config.Server.Retention.Interval)
+	require.True(t, config.Server.Retention.DryRun)
+	require.Equal(t, 2, len(config.Server.Retention.Rules))
+	require.True(t, config.Server.Retention.MatchRule(true).Retain)
+	require.Equal(t, 8760*time.Hour, config.Server.Retention.MatchRule(false).PurgeAfterExpiry)
+	require.Equal(t, 1, len(config.Server.Reissue.Targets))
+	reissueTarget := config.Server.Reissue.Targets[0]
+	require.Equal(t, "mtls-client", reissueTarget.Entry)
+	require.Equal(t, "mtls-ca", reissueTarget.Issuer)
+	require.Equal(t, 12*time.Hour, reissueTarget.ResolveLifetime())
+	require.Equal(t, 4*time.Hour, reissueTarget.ResolveRenewBefore())
+	require.Equal(t, "/etc/mtls/client.crt", reissueTarget.Deploy.CertFile)
+	require.Equal(t, []string{"systemctl", "reload", "envoy"}, reissueTarget.Deploy.Command)
 	// CLI
 	require.Equal(t, "https://certd.mydomain.org", config.CLI.ServerURL)
 }
+
+func TestLocalDNDefaults(t *testing.T) {
+	config, err := Load("./testdata/certd-test.yaml")
+	require.NoError(t, err)
+	rdns := pkix.RDNSequence{
+		{{Type: asn1.ObjectIdentifier{2, 5, 4, 11}, Value: "Test"}},
+		{{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "local"}},
+	}
+	require.Equal(t, "CN=local,OU=Test,O=Organization,C=DE", config.Server.Local.ApplyDNDefaults(rdns).String())
+}
+
+func TestSMIMEMatchEmail(t *testing.T) {
+	config, err := Load("./testdata/certd-test.yaml")
+	require.NoError(t, err)
+	require.True(t, config.Server.Local.SMIME.MatchEmail("user@mydomain.org"))
+	require.True(t, config.Server.Local.SMIME.MatchEmail("User@MyDomain.org"))
+	require.False(t, config.Server.Local.SMIME.MatchEmail("user@otherdomain.org"))
+	require.True(t, Defaults().Server.Local.SMIME.MatchEmail("user@otherdomain.org"))
+}
+
+func TestSecretDeployTargetMatches(t *testing.T) {
+	target := &SecretDeployTarget{Entries: []string{"www"}, Labels: map[string]string{"env": "prod", "team": "ops"}}
+	require.True(t, target.Matches("www", nil))
+	require.True(t, target.Matches("api", map[string]string{"env": "prod", "team": "ops", "tier": "1"}))
+	require.False(t, target.Matches("api", map[string]string{"env": "prod"}))
+	target.Labels = nil
+	require.False(t, target.Matches("api", nil))
+}
+
+func TestNamePolicyCheck(t *testing.T) {
+	config, err := Load("./testdata/certd-test.yaml")
+	require.NoError(t, err)
+	require.Nil(t, config.Server.Local.NamePolicy("other-ca"))
+	policy := config.Server.Local.NamePolicy("team-ca")
+	require.NotNil(t, policy)
+	spiffe, _ := url.Parse("spiffe://mydomain.org/service")
+	https, _ := url.Parse("https://mydomain.org/")
+	require.NoError(t, policy.Check([]string{"team.mydomain.org", "www.Team.mydomain.org", "svc42.mydomain.org"}, []net.IP{net.ParseIP("10.1.2.3")}, []string{"user@mydomain.org"}, []*url.URL{spiffe}))
+	require.Error(t, policy.Check([]string{"otherteam.mydomain.org"}, nil, nil, nil))
+	require.Error(t, policy.Check([]string{"svc42.mydomain.org.evil.org"}, nil, nil, nil))
+	require.Error(t, policy.Check(nil, []net.IP{net.ParseIP("10.2.0.1")}, nil, nil))
+	require.Error(t, policy.Check(nil, nil, []string{"user@otherdomain.org"}, nil))
+	require.Error(t, policy.Check(nil, nil, nil, []*url.URL{https}))
+	require.Error(t, (&NamePolicyConfig{}).Check([]string{"team.mydomain.org"}, nil, nil, nil))
+}
+
+func TestRetentionCheckPurge(t *testing.T) {
+	retention := &Defaults().Server.Retention
+	archived := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Error(t, retention.CheckPurge(archived, false, archived.Add(24*time.Hour)))
+	require.NoError(t, retention.CheckPurge(archived, false, archived.Add(720*time.Hour)))
+	require.Error(t, retention.CheckPurge(archived, true, archived.Add(8760*time.Hour)))
+	retention.RetainCAs = false
+	require.NoError(t, retention.CheckPurge(archived, true, archived.Add(8760*time.Hour)))
+}
+
+func TestValidate(t *testing.T) {
+	config, err := Load("./testdata/certd-test.yaml")
+	require.NoError(t, err)
+	require.NoError(t, config.Server.Validate())
+	config.Server.Local.DNTemplate = "CN={{.Name"
+	require.Error(t, config.Server.Validate())
+	config.Server.Local.DNTemplate = ""
+	config.Server.Local.SMIME.EmailPatterns = []string{"[*@mydomain.org"}
+	require.Error(t, config.Server.Validate())
+	config.Server.Local.SMIME.EmailPatterns = nil
+	config.Server.Local.Policies["team-ca"] = NamePolicyConfig{IPRanges: []string{"10.1.0.0"}}
+	require.Error(t, config.Server.Validate())
+	config.Server.Local.Policies["team-ca"] = NamePolicyConfig{DNSNames: []string{"regex:("}}
+	require.Error(t, config.Server.Validate())
+	config.Server.Local.Policies = nil
+	config.Server.Retention.Rules = []RetentionRule{{Kind: "intermediate"}}
+	require.Error(t, config.Server.Validate())
+	config.Server.Retention.Rules = nil
+	config.Server.CodeSigning.TSAURL = "http://tsa.mydomain.org:port"
+	require.Error(t, config.Server.Validate())
+}
+
+func TestLoadExpanded(t *testing.T) {
+	t.Setenv("CERTD_TEST_WEBHOOK_PATH", "env")
+	config, err := Load("./testdata/certd-test.yaml")
+	require.NoError(t, err)
+	require.Equal(t, "https://hooks.mydomain.org/env", config.Server.Notify.WebhookURL)
+	require.Equal(t, "secret", config.Server.Publish.LDAP.BindPassword)
+}
+
+func TestUnmarshalExpanded(t *testing.T) {
+	t.Setenv("CERTD_TEST_VALUE", "value")
+	values := make(map[string]string)
+	err := UnmarshalExpanded([]byte("env: \"${CERTD_TEST_VALUE}\"\nfile: \"file:ldap-password.txt\"\nurl: \"file:///etc/ssl/cert.pem\"\n"), "./testdata", &values)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"env": "value", "file": "secret", "url": "file:///etc/ssl/cert.pem"}, values)
+	err = UnmarshalExpanded([]byte("env: \"${CERTD_TEST_UNDEFINED}\"\n"), "./testdata", &values)
+	require.Error(t, err)
+	err = UnmarshalExpanded([]byte("file: \"file:unknown.txt\"\n"), "./testdata", &values)
+	require.Error(t, err)
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const fileReferencePrefix = "file:"
+
+var envReferencePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Unmarshal the given YAML data after expanding environment variable and secret file references.
+//
+// Within all scalar values ${NAME} (or ${NAME:-default}) is replaced by the value of the environment variable NAME.
+// Afterwards, scalar values of the form "file:<path>" are replaced by the content of the referenced file (with
+// trailing line breaks removed). Relative paths are resolved against the given base path. URLs using the file
+// scheme (file://...) are not considered as file references.
+func UnmarshalExpanded(data []byte, basePath string, v any) error {
+	var root yaml.Node
+	err := yaml.Unmarshal(data, &root)
+	if err != nil {
+		return err
+	}
+	if root.Kind == 0 {
+		return nil
+	}
+	err = expandNode(&root, basePath)
+	if err != nil {
+		return err
+	}
+	return root.Decode(v)
+}
+
+func expandNode(node *yaml.Node, basePath string) error {
+	if node.Kind == yaml.ScalarNode {
+		expanded, err := expandValue(node.Value, basePath)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		node.Value = expanded
+		return nil
+	}
+	for _, child := range node.Content {
+		err := expandNode(child, basePath)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expandValue(value string, basePath string) (string, error) {
+	var err error
+	expanded := envReferencePattern.ReplaceAllStringFunc(value, func(reference string) string {
+		match := envReferencePattern.FindStringSubmatch(reference)
+		envValue, found := os.LookupEnv(match[1])
+		if found {
+			return envValue
+		}
+		if match[2] != "" {
+			return match[3]
+		}
+		if err == nil {
+			err = fmt.Errorf("undefined environment variable '%s'", match[1])
+		}
+		return reference
+	})
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(expanded, fileReferencePrefix) && !strings.HasPrefix(expanded, fileReferencePrefix+"//") {
+		file := ResolvePath(basePath, strings.TrimPrefix(expanded, fileReferencePrefix))
+		fileBytes, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file '%s' (cause: %w)", file, err)
+		}
+		expanded = strings.TrimRight(string(fileBytes), "\r\n")
+	}
+	return expanded, nil
+}
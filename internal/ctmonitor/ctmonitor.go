@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ctmonitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/internal/notify"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/rs/zerolog"
+)
+
+const EventUnknownCertificate = "ct_unknown_certificate"
+
+const domainPlaceholder = "{domain}"
+
+// Finding describes a logged certificate which has not been issued via certd.
+type Finding struct {
+	Domain     string
+	ID         int64
+	Issuer     string
+	CommonName string
+	Names      []string
+	Serial     string
+	NotBefore  time.Time
+	NotAfter   time.Time
+}
+
+// crt.sh compatible log entry
+type logEntry struct {
+	ID           int64  `json:"id"`
+	IssuerName   string `json:"issuer_name"`
+	CommonName   string `json:"common_name"`
+	NameValue    string `json:"name_value"`
+	SerialNumber string `json:"serial_number"`
+	NotBefore    string `json:"not_before"`
+	NotAfter     string `json:"not_after"`
+}
+
+const logEntryTimeLayout = "2006-01-02T15:04:05"
+
+type Monitor struct {
+	config   *config.CTMonitorConfig
+	store    certs.Store
+	notifier notify.Notifier
+	client   *http.Client
+	findings map[int64]*Finding
+	lock     sync.RWMutex
+	logger   *zerolog.Logger
+}
+
+func NewMonitor(config *config.CTMonitorConfig, store certs.Store, notifier notify.Notifier) *Monitor {
+	logger := logging.RootLogger().With().Str("monitor", "ct").Logger()
+	return &Monitor{
+		config:   config,
+		store:    store,
+		notifier: notifier,
+		client:   &http.Client{Timeout: 60 * time.Second},
+		findings: make(map[int64]*Finding),
+		logger:   &logger,
+	}
+}
+
+// Query the CT logs for all configured domains and report certificates not known to the store.
+func (monitor *Monitor) Run(ctx context.Context) {
+	knownSerials := monitor.knownSerials()
+	for _, domain := range monitor.config.Domains {
+		if ctx.Err() != nil {
+			return
+		}
+		err := monitor.checkDomain(ctx, domain, knownSerials)
+		if err != nil {
+			monitor.logger.Error().Err(err).Msgf("CT check for domain '%s' failed", domain)
+		}
+	}
+}
+
+// Get the current findings (ordered by log entry id).
+func (monitor *Monitor) Findings() []*Finding {
+	monitor.lock.RLock()
+	defer monitor.lock.RUnlock()
+	findings := make([]*Finding, 0, len(monitor.findings))
+	for _, finding := range monitor.findings {
+		findings = append(findings, finding)
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].ID < findings[j].ID
+	})
+	return findings
+}
+
+func (monitor *Monitor) knownSerials() map[string]bool {
+	serials := make(map[string]bool)
+	storeEntries := monitor.store.Entries()
+	for {
+		storeEntry := storeEntries.Next()
+		if storeEntry == nil {
+			break
+		}
+		if !storeEntry.HasCertificate() {
+			continue
+		}
+		certificate, err := storeEntry.Certificate()
+		if err != nil {
+			monitor.logger.Warn().Err(err).Msgf("Ignoring inaccessible store entry '%s'", storeEntry.Name())
+			continue
+		}
+		serials[certificate.SerialNumber.Text(16)] = true
+	}
+	return serials
+}
+
+func (monitor *Monitor) checkDomain(ctx context.Context, domain string, knownSerials map[string]bool) error {
+	monitor.logger.Debug().Msgf("Checking CT logs for domain '%s'...", domain)
+	entries, err := monitor.fetchLogEntries(ctx, domain)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		serial, ok := new(big.Int).SetString(entry.SerialNumber, 16)
+		if !ok {
+			monitor.logger.Warn().Msgf("Ignoring log entry %d with invalid serial '%s'", entry.ID, entry.SerialNumber)
+			continue
+		}
+		if knownSerials[serial.Text(16)] {
+			continue
+		}
+		monitor.report(domain, entry, serial)
+	}
+	return nil
+}
+
+func (monitor *Monitor) fetchLogEntries(ctx context.Context, domain string) ([]logEntry, error) {
+	sourceURL := strings.ReplaceAll(monitor.config.SourceURL, domainPlaceholder, url.QueryEscape(domain))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CT source URL '%s' (cause: %w)", sourceURL, err)
+	}
+	rsp, err := monitor.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query CT source '%s' (cause: %w)", sourceURL, err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to query CT source '%s' (status: %s)", sourceURL, rsp.Status)
+	}
+	entries := make([]logEntry, 0)
+	err = json.NewDecoder(rsp.Body).Decode(&entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode CT source response '%s' (cause: %w)", sourceURL, err)
+	}
+	return entries, nil
+}
+
+func (monitor *Monitor) report(domain string, entry logEntry, serial *big.Int) {
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	if monitor.findings[entry.ID] != nil {
+		return
+	}
+	notBefore, _ := time.Parse(logEntryTimeLayout, entry.NotBefore)
+	notAfter, _ := time.Parse(logEntryTimeLayout, entry.NotAfter)
+	finding := &Finding{
+		Domain:     domain,
+		ID:         entry.ID,
+		Issuer:     entry.IssuerName,
+		CommonName: entry.CommonName,
+		Names:      strings.Fields(entry.NameValue),
+		Serial:     "0x" + serial.Text(16),
+		NotBefore:  notBefore,
+		NotAfter:   notAfter,
+	}
+	monitor.findings[entry.ID] = finding
+	message := fmt.Sprintf("unknown certificate '%s' (serial: %s) issued by '%s' found in CT logs", finding.CommonName, finding.Serial, finding.Issuer)
+	err := monitor.notifier.Notify(notify.NewEvent(EventUnknownCertificate, domain, message))
+	if err != nil {
+		monitor.logger.Error().Err(err).Msgf("Failed to send notification for domain '%s'", domain)
+	}
+}
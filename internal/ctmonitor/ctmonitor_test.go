@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ctmonitor
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/notify"
+	"github.com/hdecarne-github/certd/pkg/certs/fsstore"
+	"github.com/hdecarne-github/certd/pkg/certs/local"
+	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/stretchr/testify/require"
+)
+
+const testLogEntries = `[
+	{"id":1,"issuer_name":"CN=certd","common_name":"www.example.org","name_value":"www.example.org","serial_number":"01e240","not_before":"2023-01-01T00:00:00","not_after":"2024-01-01T00:00:00"},
+	{"id":2,"issuer_name":"CN=Unknown CA","common_name":"www.example.org","name_value":"www.example.org\nexample.org","serial_number":"0abcdef0","not_before":"2023-02-01T00:00:00","not_after":"2024-02-01T00:00:00"}
+]`
+
+func TestMonitor(t *testing.T) {
+	storePath, err := os.MkdirTemp("", "certd")
+	require.NoError(t, err)
+	defer os.RemoveAll(storePath)
+	store, err := fsstore.Init(filepath.Join(storePath, "store"))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(123456),
+		Subject:      pkix.Name{CommonName: "www.example.org"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	_, err = store.CreateCertificate(context.Background(), "www", local.NewLocalCertificateFactory(template, ecdsa.StandardKeys()[1], nil, nil))
+	require.NoError(t, err)
+	var queried string
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queried = r.URL.Query().Get("q")
+		fmt.Fprint(w, testLogEntries)
+	}))
+	defer source.Close()
+	monitorConfig := &config.CTMonitorConfig{
+		SourceURL: source.URL + "/?q={domain}&output=json",
+		Domains:   []string{"%.example.org"},
+	}
+	notifier := &testNotifier{}
+	monitor := NewMonitor(monitorConfig, store, notifier)
+	monitor.Run(context.Background())
+	monitor.Run(context.Background())
+	require.Equal(t, "%.example.org", queried)
+	findings := monitor.Findings()
+	require.Equal(t, 1, len(findings))
+	require.Equal(t, int64(2), findings[0].ID)
+	require.Equal(t, "0xabcdef0", findings[0].Serial)
+	require.Equal(t, []string{"www.example.org", "example.org"}, findings[0].Names)
+	require.Equal(t, 1, len(notifier.events))
+	require.Equal(t, EventUnknownCertificate, notifier.events[0].Type)
+}
+
+type testNotifier struct {
+	events []*notify.Event
+}
+
+func (notifier *testNotifier) Notify(event *notify.Event) error {
+	notifier.events = append(notifier.events, event)
+	return nil
+}
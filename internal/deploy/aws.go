@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/pkg/certs/awspca"
+)
+
+const awsSecretsManagerService = "secretsmanager"
+const awsSecretsManagerTargetPrefix = "secretsmanager."
+
+const awsResourceNotFound = "ResourceNotFoundException"
+
+type awsSecretsManagerWriter struct {
+	endpoint string
+	signer   *awspca.Signer
+	client   *http.Client
+}
+
+func newAWSSecretsManagerWriter(config *config.AWSSecretsManagerConfig) (secretWriter, error) {
+	region := config.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		return nil, errors.New("missing AWS region")
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", awsSecretsManagerService, region)
+	}
+	signer, err := awspca.NewSigner(&awspca.Config{
+		AccessKeyID:     config.AccessKeyID,
+		SecretAccessKey: config.SecretAccessKey,
+		SessionToken:    config.SessionToken,
+		Profile:         config.Profile,
+	}, region)
+	if err != nil {
+		return nil, err
+	}
+	return &awsSecretsManagerWriter{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		signer:   signer,
+		client:   &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+type awsPutSecretValueRequest struct {
+	SecretId     string `json:"SecretId"`
+	SecretString string `json:"SecretString"`
+}
+
+type awsCreateSecretRequest struct {
+	Name         string `json:"Name"`
+	SecretString string `json:"SecretString"`
+}
+
+type awsErrorResponse struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (err *awsErrorResponse) exception() string {
+	return err.Type[strings.LastIndex(err.Type, "#")+1:]
+}
+
+func (err *awsErrorResponse) Error() string {
+	return fmt.Sprintf("%s: %s", err.exception(), err.Message)
+}
+
+func (writer *awsSecretsManagerWriter) writeSecret(secret string, deployment *Deployment) error {
+	value, err := encodeSecretValue(deployment)
+	if err != nil {
+		return err
+	}
+	err = writer.call("PutSecretValue", &awsPutSecretValueRequest{SecretId: secret, SecretString: string(value)})
+	var errResponse *awsErrorResponse
+	if errors.As(err, &errResponse) && errResponse.exception() == awsResourceNotFound {
+		err = writer.call("CreateSecret", &awsCreateSecretRequest{Name: secret, SecretString: string(value)})
+	}
+	return err
+}
+
+func (writer *awsSecretsManagerWriter) call(operation string, input any) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request (cause: %w)", operation, err)
+	}
+	req, err := http.NewRequest(http.MethodPost, writer.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to prepare %s request (cause: %w)", operation, err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", awsSecretsManagerTargetPrefix+operation)
+	err = writer.signer.Sign(req.Context(), req, body, awsSecretsManagerService, time.Now())
+	if err != nil {
+		return err
+	}
+	resp, err := writer.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send %s request (cause: %w)", operation, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		errResponse := &awsErrorResponse{}
+		err = json.NewDecoder(resp.Body).Decode(errResponse)
+		if err == nil && errResponse.Type != "" {
+			return errResponse
+		}
+		return fmt.Errorf("%s request failed (status: %s)", operation, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+)
+
+const defaultAzureLoginEndpoint = "https://login.microsoftonline.com"
+const azureKeyVaultScope = "https://vault.azure.net/.default"
+const azureKeyVaultAPIVersion = "7.4"
+
+// Key Vault object names are restricted to alphanumeric characters and dashes.
+var azureInvalidNameChars = regexp.MustCompile("[^0-9a-zA-Z-]")
+
+type azureKeyVaultWriter struct {
+	vaultURL     string
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+	lock         sync.Mutex
+	accessToken  string
+	expiry       time.Time
+}
+
+// Create the writer importing certificates into an Azure Key Vault.
+//
+// Credentials (service principal client secret) not defined in the config are taken from the environment
+// (AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET).
+func newAzureKeyVaultWriter(config *config.AzureKeyVaultConfig) (secretWriter, error) {
+	if config.VaultURL == "" {
+		return nil, errors.New("missing Azure Key Vault URL")
+	}
+	tenantID := resolveAzureSetting(config.TenantID, "AZURE_TENANT_ID")
+	clientID := resolveAzureSetting(config.ClientID, "AZURE_CLIENT_ID")
+	clientSecret := resolveAzureSetting(config.ClientSecret, "AZURE_CLIENT_SECRET")
+	if tenantID == "" || clientID == "" || clientSecret == "" {
+		return nil, errors.New("missing Azure credentials")
+	}
+	loginEndpoint := config.LoginEndpoint
+	if loginEndpoint == "" {
+		loginEndpoint = defaultAzureLoginEndpoint
+	}
+	return &azureKeyVaultWriter{
+		vaultURL:     strings.TrimSuffix(config.VaultURL, "/"),
+		tokenURL:     fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(loginEndpoint, "/"), url.PathEscape(tenantID)),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+func resolveAzureSetting(value string, env string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
+
+type azureImportCertificateRequest struct {
+	Value  string                 `json:"value"`
+	Policy azureCertificatePolicy `json:"policy"`
+}
+
+type azureCertificatePolicy struct {
+	SecretProperties azureSecretProperties `json:"secret_props"`
+}
+
+type azureSecretProperties struct {
+	ContentType string `json:"contentType"`
+}
+
+type azureTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+	TokenType   string `json:"token_type"`
+}
+
+func (writer *azureKeyVaultWriter) writeSecret(secret string, deployment *Deployment) error {
+	keyPEM, err := encodeKeyPEM(deployment.Key)
+	if err != nil {
+		return err
+	}
+	importRequest := &azureImportCertificateRequest{
+		Value:  string(keyPEM) + string(encodeCertificatesPEM(deployment)),
+		Policy: azureCertificatePolicy{SecretProperties: azureSecretProperties{ContentType: "application/x-pem-file"}},
+	}
+	body, err := json.Marshal(importRequest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal import request (cause: %w)", err)
+	}
+	token, err := writer.token()
+	if err != nil {
+		return err
+	}
+	name := azureInvalidNameChars.ReplaceAllString(secret, "-")
+	importURL := fmt.Sprintf("%s/certificates/%s/import?api-version=%s", writer.vaultURL, name, azureKeyVaultAPIVersion)
+	req, err := http.NewRequest(http.MethodPost, importURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to prepare import request '%s' (cause: %w)", importURL, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := writer.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send import request '%s' (cause: %w)", importURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("import request '%s' failed (status: %s)", importURL, resp.Status)
+	}
+	return nil
+}
+
+func (writer *azureKeyVaultWriter) token() (string, error) {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+	if writer.accessToken != "" && time.Now().Before(writer.expiry) {
+		return writer.accessToken, nil
+	}
+	resp, err := writer.client.PostForm(writer.tokenURL, url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {writer.clientID},
+		"client_secret": {writer.clientSecret},
+		"scope":         {azureKeyVaultScope},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to send token request '%s' (cause: %w)", writer.tokenURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request '%s' failed (status: %s)", writer.tokenURL, resp.Status)
+	}
+	response := &azureTokenResponse{}
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode token response '%s' (cause: %w)", writer.tokenURL, err)
+	}
+	if !strings.EqualFold(response.TokenType, "Bearer") || response.AccessToken == "" {
+		return "", fmt.Errorf("unexpected token response '%s' (type: '%s')", writer.tokenURL, response.TokenType)
+	}
+	writer.accessToken = response.AccessToken
+	// refresh tokens a minute ahead of their expiry
+	writer.expiry = time.Now().Add(time.Duration(response.ExpiresIn)*time.Second - time.Minute)
+	return writer.accessToken, nil
+}
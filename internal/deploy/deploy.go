@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package deploy
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/rs/zerolog"
+)
+
+// Supported secret manager types.
+const (
+	TypeAWSSecretsManager = "aws-secrets-manager"
+	TypeGCPSecretManager  = "gcp-secret-manager"
+	TypeAzureKeyVault     = "azure-key-vault"
+	TypeFile              = "file"
+)
+
+const defaultSecretTemplate = "{{.Name}}"
+
+const requestTimeout = 30 * time.Second
+
+// Deployment holds the store entry data to write to the secret managers.
+type Deployment struct {
+	Name        string
+	Labels      map[string]string
+	Certificate *x509.Certificate
+	Chain       []*x509.Certificate
+	Key         crypto.PrivateKey
+}
+
+// Deployer writes renewed certificates (including chain and key) to external secret managers or local files.
+type Deployer interface {
+	Deploy(deployment *Deployment) error
+}
+
+type secretWriter interface {
+	writeSecret(secret string, deployment *Deployment) error
+}
+
+// Create the deployer for the secret manager targets defined by the given configuration.
+//
+// If no target is configured, the returned deployer does nothing.
+func NewDeployer(config *config.SecretDeployConfig) (Deployer, error) {
+	logger := logging.RootLogger().With().Str("deploy", "secrets").Logger()
+	deployers := make([]*targetDeployer, 0, len(config.Targets))
+	for i := range config.Targets {
+		target := &config.Targets[i]
+		secretTemplateText := target.Secret
+		if secretTemplateText == "" {
+			secretTemplateText = defaultSecretTemplate
+		}
+		secretTemplate, err := template.New("secret").Parse(secretTemplateText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret template '%s' for deploy target '%s' (cause: %w)", secretTemplateText, target.Name, err)
+		}
+		var writer secretWriter
+		switch target.Type {
+		case TypeAWSSecretsManager:
+			writer, err = newAWSSecretsManagerWriter(&target.AWS)
+		case TypeGCPSecretManager:
+			writer, err = newGCPSecretManagerWriter(&target.GCP)
+		case TypeAzureKeyVault:
+			writer, err = newAzureKeyVaultWriter(&target.Azure)
+		case TypeFile:
+			writer, err = newFileWriter(&target.File)
+		default:
+			err = fmt.Errorf("unrecognized type '%s'", target.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid deploy target '%s' (cause: %w)", target.Name, err)
+		}
+		deployers = append(deployers, &targetDeployer{
+			target:         target,
+			secretTemplate: secretTemplate,
+			writer:         writer,
+		})
+	}
+	return &multiDeployer{deployers: deployers, logger: &logger}, nil
+}
+
+type targetDeployer struct {
+	target         *config.SecretDeployTarget
+	secretTemplate *template.Template
+	writer         secretWriter
+}
+
+type multiDeployer struct {
+	deployers []*targetDeployer
+	logger    *zerolog.Logger
+}
+
+func (deployer *multiDeployer) Deploy(deployment *Deployment) error {
+	errs := make([]error, 0)
+	for _, target := range deployer.deployers {
+		if !target.target.Matches(deployment.Name, deployment.Labels) {
+			continue
+		}
+		if deployment.Key == nil {
+			deployer.logger.Warn().Msgf("Skipping deployment of store entry '%s' to '%s' (no key)", deployment.Name, target.target.Name)
+			continue
+		}
+		var secret bytes.Buffer
+		err := target.secretTemplate.Execute(&secret, deployment)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to evaluate secret template for store entry '%s' (cause: %w)", deployment.Name, err))
+			continue
+		}
+		deployer.logger.Info().Msgf("Deploying store entry '%s' to '%s' (secret: '%s')...", deployment.Name, target.target.Name, secret.String())
+		err = target.writer.writeSecret(secret.String(), deployment)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to deploy store entry '%s' to '%s' (cause: %w)", deployment.Name, target.target.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// The secret value written to AWS Secrets Manager and GCP Secret Manager.
+type secretValue struct {
+	Certificate string `json:"certificate"`
+	PrivateKey  string `json:"private_key"`
+}
+
+func encodeSecretValue(deployment *Deployment) ([]byte, error) {
+	keyPEM, err := encodeKeyPEM(deployment.Key)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&secretValue{
+		Certificate: string(encodeCertificatesPEM(deployment)),
+		PrivateKey:  string(keyPEM),
+	})
+}
+
+func encodeCertificatesPEM(deployment *Deployment) []byte {
+	return encodeChainPEM(append([]*x509.Certificate{deployment.Certificate}, deployment.Chain...))
+}
+
+func encodeChainPEM(chain []*x509.Certificate) []byte {
+	var encoded bytes.Buffer
+	for _, certificate := range chain {
+		encoded.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw}))
+	}
+	return encoded.Bytes()
+}
+
+func encodeKeyPEM(key crypto.PrivateKey) ([]byte, error) {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key (cause: %w)", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}), nil
+}
@@ -0,0 +1,254 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+)
+
+const defaultCertFileMode = 0644
+const defaultKeyFileMode = 0600
+
+const commandTimeout = time.Minute
+
+type fileWriter struct {
+	certFile  *fileTemplate
+	keyFile   *fileTemplate
+	chainFile *fileTemplate
+	uid       int
+	gid       int
+	mode      os.FileMode
+	keyMode   os.FileMode
+	command   []string
+}
+
+// Create the writer deploying to local files (e.g. for a web server running on the same host).
+//
+// The file paths are templates evaluated for every deployed entry. After all files have been written, the
+// configured command is run (e.g. to reload the web server).
+func newFileWriter(config *config.FileDeployConfig) (secretWriter, error) {
+	if config.CertFile == "" && config.KeyFile == "" && config.ChainFile == "" {
+		return nil, errors.New("missing deploy files")
+	}
+	writer := &fileWriter{uid: -1, gid: -1, command: config.Command}
+	var err error
+	writer.certFile, err = parseFileTemplate("cert_file", config.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	writer.keyFile, err = parseFileTemplate("key_file", config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	writer.chainFile, err = parseFileTemplate("chain_file", config.ChainFile)
+	if err != nil {
+		return nil, err
+	}
+	writer.mode, err = parseFileMode(config.Mode, defaultCertFileMode)
+	if err != nil {
+		return nil, err
+	}
+	writer.keyMode, err = parseFileMode(config.KeyMode, defaultKeyFileMode)
+	if err != nil {
+		return nil, err
+	}
+	if config.Owner != "" {
+		writer.uid, writer.gid, err = lookupOwner(config.Owner)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return writer, nil
+}
+
+// A deploy file path template and the directory all evaluated paths have to stay within.
+type fileTemplate struct {
+	template *template.Template
+	dir      string
+}
+
+func parseFileTemplate(name string, text string) (*fileTemplate, error) {
+	if text == "" {
+		return nil, nil
+	}
+	pathTemplate, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template '%s' (cause: %w)", name, text, err)
+	}
+	// the static part of the template (up to the first action) determines the directory to deploy to
+	staticPath, _, _ := strings.Cut(text, "{{")
+	dir := filepath.Dir(staticPath + "_")
+	return &fileTemplate{template: pathTemplate, dir: filepath.Clean(dir)}, nil
+}
+
+func parseFileMode(mode string, defaultMode os.FileMode) (os.FileMode, error) {
+	if mode == "" {
+		return defaultMode, nil
+	}
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || parsed > 0777 {
+		return 0, fmt.Errorf("invalid file mode '%s'", mode)
+	}
+	return os.FileMode(parsed), nil
+}
+
+// Resolve an owner of the form user[:group] (names or numeric ids).
+func lookupOwner(owner string) (int, int, error) {
+	userName, groupName, hasGroup := strings.Cut(owner, ":")
+	uid, err := strconv.Atoi(userName)
+	if err != nil {
+		resolvedUser, err := user.Lookup(userName)
+		if err != nil {
+			return -1, -1, fmt.Errorf("unknown user '%s' (cause: %w)", userName, err)
+		}
+		uid, _ = strconv.Atoi(resolvedUser.Uid)
+	}
+	if !hasGroup {
+		return uid, -1, nil
+	}
+	gid, err := strconv.Atoi(groupName)
+	if err != nil {
+		resolvedGroup, err := user.LookupGroup(groupName)
+		if err != nil {
+			return -1, -1, fmt.Errorf("unknown group '%s' (cause: %w)", groupName, err)
+		}
+		gid, _ = strconv.Atoi(resolvedGroup.Gid)
+	}
+	return uid, gid, nil
+}
+
+func (writer *fileWriter) writeSecret(secret string, deployment *Deployment) error {
+	certPath, err := evaluateFileTemplate(writer.certFile, deployment)
+	if err != nil {
+		return err
+	}
+	keyPath, err := evaluateFileTemplate(writer.keyFile, deployment)
+	if err != nil {
+		return err
+	}
+	chainPath, err := evaluateFileTemplate(writer.chainFile, deployment)
+	if err != nil {
+		return err
+	}
+	if certPath != "" {
+		err = writer.writeFile(certPath, encodeCertificatesPEM(deployment), writer.mode)
+		if err != nil {
+			return err
+		}
+	}
+	if chainPath != "" && len(deployment.Chain) > 0 {
+		err = writer.writeFile(chainPath, encodeChainPEM(deployment.Chain), writer.mode)
+		if err != nil {
+			return err
+		}
+	}
+	if keyPath != "" {
+		keyPEM, err := encodeKeyPEM(deployment.Key)
+		if err != nil {
+			return err
+		}
+		err = writer.writeFile(keyPath, keyPEM, writer.keyMode)
+		if err != nil {
+			return err
+		}
+	}
+	if len(writer.command) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, writer.command[0], writer.command[1:]...)
+	cmd.Env = append(os.Environ(), "CERTD_ENTRY="+deployment.Name, "CERTD_CERT_FILE="+certPath, "CERTD_KEY_FILE="+keyPath, "CERTD_CHAIN_FILE="+chainPath)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("deploy command '%s' failed (cause: %w, output: %s)", writer.command[0], err, output.String())
+	}
+	return nil
+}
+
+func evaluateFileTemplate(fileTemplate *fileTemplate, deployment *Deployment) (string, error) {
+	if fileTemplate == nil {
+		return "", nil
+	}
+	// entry names may contain path separators and dots, which must not select the deploy directory
+	if strings.ContainsAny(deployment.Name, "/\\") || strings.Contains(deployment.Name, "..") {
+		return "", fmt.Errorf("entry name '%s' not suitable for %s template", deployment.Name, fileTemplate.template.Name())
+	}
+	var path bytes.Buffer
+	err := fileTemplate.template.Execute(&path, deployment)
+	if err != nil {
+		return "", fmt.Errorf("failed to evaluate %s template (cause: %w)", fileTemplate.template.Name(), err)
+	}
+	resolvedPath := filepath.Clean(path.String())
+	relativePath, err := filepath.Rel(fileTemplate.dir, resolvedPath)
+	if err != nil || !filepath.IsLocal(relativePath) {
+		return "", fmt.Errorf("evaluated %s template '%s' leaves deploy directory '%s'", fileTemplate.template.Name(), resolvedPath, fileTemplate.dir)
+	}
+	return resolvedPath, nil
+}
+
+func (writer *fileWriter) writeFile(path string, data []byte, perm os.FileMode) error {
+	return WriteFile(path, data, perm, func(updatePath string) error {
+		if writer.uid < 0 && writer.gid < 0 {
+			return nil
+		}
+		return os.Chown(updatePath, writer.uid, writer.gid)
+	})
+}
+
+// Atomically replace the given file.
+//
+// The data is written to a temporary file next to the target file, which is renamed afterwards. The optional
+// prepare function is invoked on the temporary file before it is renamed (e.g. to change its owner).
+func WriteFile(path string, data []byte, perm os.FileMode, prepare func(updatePath string) error) error {
+	updatePath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".update")
+	err := os.WriteFile(updatePath, data, perm)
+	if err == nil {
+		// enforce the requested permissions regardless of umask and a previously existing update file
+		err = os.Chmod(updatePath, perm)
+	}
+	if err == nil && prepare != nil {
+		err = prepare(updatePath)
+	}
+	if err != nil {
+		os.Remove(updatePath)
+		return fmt.Errorf("failed to write deploy file '%s' (cause: %w)", updatePath, err)
+	}
+	err = os.Rename(updatePath, path)
+	if err != nil {
+		os.Remove(updatePath)
+		return fmt.Errorf("failed to replace deploy file '%s' (cause: %w)", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package deploy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/pkg/certs/googlecas"
+)
+
+const defaultGCPSecretManagerEndpoint = "https://secretmanager.googleapis.com"
+
+type gcpSecretManagerWriter struct {
+	project  string
+	endpoint string
+	token    func() (string, error)
+	client   *http.Client
+}
+
+func newGCPSecretManagerWriter(config *config.GCPSecretManagerConfig) (secretWriter, error) {
+	if config.Project == "" {
+		return nil, errors.New("missing GCP project")
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = defaultGCPSecretManagerEndpoint
+	}
+	client := &http.Client{Timeout: requestTimeout}
+	token, err := googlecas.NewTokenSource(config.Credentials, client)
+	if err != nil {
+		return nil, err
+	}
+	return &gcpSecretManagerWriter{
+		project:  config.Project,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		token:    token,
+		client:   client,
+	}, nil
+}
+
+type gcpAddVersionRequest struct {
+	Payload gcpSecretPayload `json:"payload"`
+}
+
+type gcpSecretPayload struct {
+	Data string `json:"data"`
+}
+
+type gcpCreateSecretRequest struct {
+	Replication gcpReplication `json:"replication"`
+}
+
+type gcpReplication struct {
+	Automatic struct{} `json:"automatic"`
+}
+
+var errGCPSecretNotFound = errors.New("secret not found")
+
+func (writer *gcpSecretManagerWriter) writeSecret(secret string, deployment *Deployment) error {
+	value, err := encodeSecretValue(deployment)
+	if err != nil {
+		return err
+	}
+	secretPath := fmt.Sprintf("/v1/projects/%s/secrets", url.PathEscape(writer.project))
+	addVersion := &gcpAddVersionRequest{Payload: gcpSecretPayload{Data: base64.StdEncoding.EncodeToString(value)}}
+	addVersionPath := fmt.Sprintf("%s/%s:addVersion", secretPath, url.PathEscape(secret))
+	err = writer.call(addVersionPath, addVersion)
+	if errors.Is(err, errGCPSecretNotFound) {
+		err = writer.call(secretPath+"?"+url.Values{"secretId": {secret}}.Encode(), &gcpCreateSecretRequest{})
+		if err != nil {
+			return err
+		}
+		err = writer.call(addVersionPath, addVersion)
+	}
+	return err
+}
+
+func (writer *gcpSecretManagerWriter) call(path string, input any) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request '%s' (cause: %w)", path, err)
+	}
+	token, err := writer.token()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, writer.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to prepare request '%s' (cause: %w)", path, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := writer.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request '%s' (cause: %w)", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errGCPSecretNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request '%s' failed (status: %s)", path, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ginextra
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Principal name assigned to requests authenticated via the admin token.
+const AdminPrincipal = "admin"
+
+const bearerPrefix = "Bearer "
+
+// AdminAuth middleware granting access to requests presenting the admin token as bearer token.
+//
+// The token is evaluated per request (to honor configuration reloads). If no token is configured,
+// all requests are rejected.
+func AdminAuth(token func() string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expected := token()
+		if expected == "" {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		authorization := c.GetHeader("Authorization")
+		if !strings.HasPrefix(authorization, bearerPrefix) {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		presented := strings.TrimPrefix(authorization, bearerPrefix)
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(expected)) != 1 {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Set(PrincipalKey, AdminPrincipal)
+		c.Next()
+	}
+}
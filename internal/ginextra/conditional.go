@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ginextra
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConditionalJSON writes the given object as JSON using a content hash based ETag.
+//
+// If the request's If-None-Match header matches the ETag, only the status 304 is sent.
+func ConditionalJSON(c *gin.Context, obj any) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ConditionalData(c, gin.MIMEJSON+"; charset=utf-8", data)
+}
+
+// ConditionalData writes the given data using a content hash based ETag.
+//
+// If the request's If-None-Match header matches the ETag, only the status 304 is sent.
+func ConditionalData(c *gin.Context, contentType string, data []byte) {
+	hash := sha256.Sum256(data)
+	if NotModified(c, ContentETag(hash[:])) {
+		return
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// ContentETag derives a strong ETag from the given content hash.
+func ContentETag(hash []byte) string {
+	if len(hash) > 16 {
+		hash = hash[:16]
+	}
+	return "\"" + hex.EncodeToString(hash) + "\""
+}
+
+// NotModified sets the given ETag and sends the status 304 if the request's If-None-Match header matches it.
+//
+// Returns true if the status 304 has been sent and the response is complete.
+func NotModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+const weakETagPrefix = "W/"
+
+// If-None-Match uses the weak comparison (RFC 9110 section 13.1.2), hence weak and strong ETags match alike.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	etag = strings.TrimPrefix(etag, weakETagPrefix)
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), weakETagPrefix)
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
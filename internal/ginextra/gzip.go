@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ginextra
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gzip middleware compressing the responses of all requests below the given path prefix
+// (if the client accepts gzip encoding).
+func Gzip(pathPrefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.HasPrefix(c.Request.URL.Path, pathPrefix) {
+			c.Next()
+			return
+		}
+		c.Header("Vary", "Accept-Encoding")
+		if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+			c.Next()
+			return
+		}
+		writer := &gzipResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		defer writer.close()
+		c.Next()
+	}
+}
+
+// gzipResponseWriter compresses the response body on the fly.
+//
+// The compressor is created on the first write, hence body-less responses (e.g. 304) are passed through unchanged.
+// As the compressed representation differs from the identity one, strong ETags are weakened for compressed as well as
+// for 304 responses (RFC 9110 section 8.8.3).
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	compressor *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if code == http.StatusNotModified {
+		weakenETag(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if w.compressor == nil {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		weakenETag(w.Header())
+		w.compressor = gzip.NewWriter(w.ResponseWriter)
+	}
+	return w.compressor.Write(data)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if w.compressor != nil {
+		_ = w.compressor.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.compressor != nil {
+		_ = w.compressor.Close()
+	}
+}
+
+func weakenETag(header http.Header) {
+	etag := header.Get("ETag")
+	if etag != "" && !strings.HasPrefix(etag, weakETagPrefix) {
+		header.Set("ETag", weakETagPrefix+etag)
+	}
+}
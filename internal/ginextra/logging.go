@@ -18,18 +18,44 @@
 package ginextra
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/internal/logging"
 	"github.com/rs/zerolog"
 )
 
+// HTTP header used to pass the request ID to the client (or to take over the client's request ID).
+const RequestIDHeader = "X-Request-ID"
+
+// Context key of the request ID.
+const RequestIDKey = "request_id"
+
+// Context key of the authenticated principal (if any).
+const PrincipalKey = "principal"
+
+const requestLoggerKey = "request_logger"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Logger middleware assigning a request ID to each request and logging the request's outcome.
+//
+// The request ID is taken over from the request's X-Request-ID header (if valid) or generated otherwise. It is
+// returned to the client via the response's X-Request-ID header and attached to all log entries of the request
+// logger (see RequestLogger).
 func Logger(logger *zerolog.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if !logger.Debug().Enabled() {
-			c.Next()
-			return
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
 		}
+		requestLogger := logger.With().Str(logging.CorrelationIDKey, requestID).Logger()
+		c.Set(RequestIDKey, requestID)
+		c.Set(requestLoggerKey, &requestLogger)
+		c.Header(RequestIDHeader, requestID)
 		path := c.Request.URL.Path
 		raw := c.Request.URL.RawQuery
 		if raw != "" {
@@ -38,9 +64,49 @@ func Logger(logger *zerolog.Logger) gin.HandlerFunc {
 		start := time.Now()
 		c.Next()
 		elapsed := time.Since(start)
-		clientIP := c.ClientIP()
-		method := c.Request.Method
 		status := c.Writer.Status()
-		logger.Debug().Msgf("%s %s %s - %d (%s)", clientIP, method, path, status, elapsed)
+		var event *zerolog.Event
+		if status >= 400 {
+			event = requestLogger.Warn()
+		} else {
+			event = requestLogger.Debug()
+		}
+		if !event.Enabled() {
+			return
+		}
+		event = event.Str("client", c.ClientIP()).Str("method", c.Request.Method).Str("path", path).Int("status", status).Dur("latency", elapsed)
+		principal := c.GetString(PrincipalKey)
+		if principal != "" {
+			event = event.Str("principal", principal)
+		}
+		if len(c.Errors) > 0 {
+			event = event.Str("errors", c.Errors.String())
+		}
+		event.Msgf("%s %s - %d", c.Request.Method, path, status)
+	}
+}
+
+// Get the request ID of the given request.
+func RequestID(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
+
+// Get the logger for the given request.
+//
+// If the request has not been processed by the Logger middleware, the given fallback logger is returned.
+func RequestLogger(c *gin.Context, fallback *zerolog.Logger) *zerolog.Logger {
+	requestLogger, found := c.Get(requestLoggerKey)
+	if !found {
+		return fallback
+	}
+	return requestLogger.(*zerolog.Logger)
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return time.Now().UTC().Format("20060102150405.000000000")
 	}
+	return hex.EncodeToString(id)
 }
@@ -0,0 +1,255 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/server"
+)
+
+// Supported load test operations.
+const (
+	OperationEntries  = "entries"
+	OperationGenerate = "generate"
+)
+
+const defaultConcurrency = 1
+const defaultDuration = 10 * time.Second
+const defaultKeyType = "ECDSA P-256"
+
+const requestTimeout = time.Minute
+
+// Config defines the load to generate as well as the performance budget to check.
+type Config struct {
+	ServerURL   string
+	Operation   string
+	Concurrency int
+	Duration    time.Duration
+	KeyType     string
+	// Maximum accepted 99th percentile latency (unchecked if 0)
+	MaxP99 time.Duration
+	// Minimum accepted throughput in requests per second (unchecked if 0)
+	MinRate float64
+}
+
+// Report summarizes the outcome of a load test run.
+type Report struct {
+	Operation string
+	Requests  int
+	Failures  int
+	Duration  time.Duration
+	P50       time.Duration
+	P90       time.Duration
+	P99       time.Duration
+	Max       time.Duration
+}
+
+// Get the achieved throughput in requests per second.
+func (report *Report) Rate() float64 {
+	if report.Duration <= 0 {
+		return 0
+	}
+	return float64(report.Requests) / report.Duration.Seconds()
+}
+
+// Write a human readable summary of the report.
+func (report *Report) Write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "operation: %s\nrequests: %d (failures: %d)\nduration: %s\nrate: %.1f/s\nlatency: p50=%s p90=%s p99=%s max=%s\n",
+		report.Operation, report.Requests, report.Failures, report.Duration.Round(time.Millisecond), report.Rate(),
+		report.P50, report.P90, report.P99, report.Max)
+	return err
+}
+
+// Check the report against the performance budget of the given configuration.
+func (report *Report) Check(config *Config) error {
+	violations := make([]string, 0)
+	if report.Failures > 0 {
+		violations = append(violations, fmt.Sprintf("%d failed requests", report.Failures))
+	}
+	if config.MaxP99 > 0 && report.P99 > config.MaxP99 {
+		violations = append(violations, fmt.Sprintf("p99 latency %s exceeds %s", report.P99, config.MaxP99))
+	}
+	if config.MinRate > 0 && report.Rate() < config.MinRate {
+		violations = append(violations, fmt.Sprintf("rate %.1f/s below %.1f/s", report.Rate(), config.MinRate))
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("performance budget violated (%s)", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// Run a load test against the server as defined by the given configuration.
+//
+// The test ends after the configured duration or as soon as the context is done.
+func Run(ctx context.Context, config *Config) (*Report, error) {
+	runner, err := newRunner(config)
+	if err != nil {
+		return nil, err
+	}
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	duration := config.Duration
+	if duration <= 0 {
+		duration = defaultDuration
+	}
+	runCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	var latencies []time.Duration
+	failures := 0
+	var resultsLock sync.Mutex
+	var workers sync.WaitGroup
+	started := time.Now()
+	for worker := 0; worker < concurrency; worker++ {
+		workers.Add(1)
+		go func(worker int) {
+			defer workers.Done()
+			for request := 0; runCtx.Err() == nil; request++ {
+				requestStarted := time.Now()
+				err := runner.do(runCtx, worker, request)
+				latency := time.Since(requestStarted)
+				if runCtx.Err() != nil {
+					// requests interrupted by the end of the test are not accounted
+					return
+				}
+				resultsLock.Lock()
+				latencies = append(latencies, latency)
+				if err != nil {
+					failures++
+				}
+				resultsLock.Unlock()
+			}
+		}(worker)
+	}
+	workers.Wait()
+	report := &Report{
+		Operation: runner.operation,
+		Requests:  len(latencies),
+		Failures:  failures,
+		Duration:  time.Since(started),
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.P50 = percentile(latencies, 50)
+	report.P90 = percentile(latencies, 90)
+	report.P99 = percentile(latencies, 99)
+	if len(latencies) > 0 {
+		report.Max = latencies[len(latencies)-1]
+	}
+	return report, nil
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+type runner struct {
+	serverURL string
+	operation string
+	keyType   string
+	runID     string
+	client    *http.Client
+}
+
+func newRunner(config *Config) (*runner, error) {
+	operation := config.Operation
+	if operation == "" {
+		operation = OperationEntries
+	}
+	switch operation {
+	case OperationEntries, OperationGenerate:
+	default:
+		return nil, fmt.Errorf("unrecognized load test operation '%s'", operation)
+	}
+	keyType := config.KeyType
+	if keyType == "" {
+		keyType = defaultKeyType
+	}
+	return &runner{
+		serverURL: strings.TrimSuffix(config.ServerURL, "/"),
+		operation: operation,
+		keyType:   keyType,
+		runID:     time.Now().UTC().Format("20060102150405"),
+		client:    &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+func (runner *runner) do(ctx context.Context, worker int, request int) error {
+	switch runner.operation {
+	case OperationGenerate:
+		return runner.generate(ctx, fmt.Sprintf("loadtest-%s-%d-%d", runner.runID, worker, request))
+	default:
+		return runner.send(ctx, http.MethodGet, "/api/store/entries", nil)
+	}
+}
+
+func (runner *runner) generate(ctx context.Context, name string) error {
+	now := time.Now()
+	generateLocal := &server.StoreGenerateLocalRequest{
+		StoreGenerateRequest: server.StoreGenerateRequest{
+			Name: name,
+			CA:   "Local",
+		},
+		DN:        "CN=" + name,
+		KeyType:   runner.keyType,
+		ValidFrom: now,
+		ValidTo:   now.Add(24 * time.Hour),
+	}
+	body, err := json.Marshal(generateLocal)
+	if err != nil {
+		return err
+	}
+	return runner.send(ctx, http.MethodPut, "/api/store/local/generate", body)
+}
+
+func (runner *runner) send(ctx context.Context, method string, path string, body []byte) error {
+	url := runner.serverURL + path
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to prepare request for url '%s' (cause: %w)", url, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := runner.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to access url '%s' (cause: %w)", url, err)
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response from url '%s' (cause: %w)", url, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status from url '%s' (status: %s)", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunEntries(t *testing.T) {
+	var requests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "/api/store/entries", r.URL.Path)
+		requests.Add(1)
+		w.Write([]byte(`{"entries":[]}`))
+	}))
+	defer ts.Close()
+	config := &Config{ServerURL: ts.URL + "/", Concurrency: 2, Duration: 100 * time.Millisecond}
+	report, err := Run(context.Background(), config)
+	require.NoError(t, err)
+	require.Equal(t, OperationEntries, report.Operation)
+	require.Greater(t, report.Requests, 0)
+	require.LessOrEqual(t, int64(report.Requests), requests.Load())
+	require.Equal(t, 0, report.Failures)
+	require.LessOrEqual(t, report.P50, report.P99)
+	require.LessOrEqual(t, report.P99, report.Max)
+	require.NoError(t, report.Check(config))
+	var summary strings.Builder
+	require.NoError(t, report.Write(&summary))
+	require.Contains(t, summary.String(), "operation: entries\n")
+	require.ErrorContains(t, report.Check(&Config{MinRate: 1e12}), "rate")
+	require.ErrorContains(t, report.Check(&Config{MaxP99: time.Nanosecond}), "p99 latency")
+}
+
+func TestRunGenerate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		require.Equal(t, "/api/store/local/generate", r.URL.Path)
+		generateLocal := &server.StoreGenerateLocalRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(generateLocal))
+		require.True(t, strings.HasPrefix(generateLocal.Name, "loadtest-"))
+		require.Equal(t, "RSA 2048", generateLocal.KeyType)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+	config := &Config{ServerURL: ts.URL, Operation: OperationGenerate, KeyType: "RSA 2048", Duration: 50 * time.Millisecond}
+	report, err := Run(context.Background(), config)
+	require.NoError(t, err)
+	require.Equal(t, report.Requests, report.Failures)
+	require.ErrorContains(t, report.Check(config), "failed requests")
+}
+
+func TestRunInvalidOperation(t *testing.T) {
+	_, err := Run(context.Background(), &Config{Operation: "invalid"})
+	require.Error(t, err)
+}
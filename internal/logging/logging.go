@@ -18,6 +18,8 @@
 package logging
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"os"
 
@@ -25,13 +27,49 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// Module names used for module specific log levels.
+const (
+	ModuleServer = "server"
+	ModuleStore  = "store"
+	ModuleACME   = "acme"
+	ModuleAudit  = "audit"
+)
+
+// Log field used to correlate log entries belonging to the same request.
+const CorrelationIDKey = "request_id"
+
+// Supported log formats.
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
 var rootLogger = NewConsoleLogger(os.Stdout, false)
+var moduleLevels = make(map[string]zerolog.Level)
 
 func UpdateRootLogger(logger *zerolog.Logger, level zerolog.Level) {
-	zerolog.SetGlobalLevel(level)
-	rootLogger = logger
+	UpdateLoggers(logger, level, nil)
+}
+
+// Update the root logger as well as the module specific log levels.
+//
+// Modules without explicit log level use the root logger's level.
+func UpdateLoggers(logger *zerolog.Logger, level zerolog.Level, levels map[string]zerolog.Level) {
+	globalLevel := level
+	for _, moduleLevel := range levels {
+		if moduleLevel < globalLevel {
+			globalLevel = moduleLevel
+		}
+	}
+	zerolog.SetGlobalLevel(globalLevel)
+	updatedLogger := logger.Level(level)
+	rootLogger = &updatedLogger
+	moduleLevels = make(map[string]zerolog.Level)
+	for module, moduleLevel := range levels {
+		moduleLevels[module] = moduleLevel
+	}
 	log.SetFlags(0)
-	log.SetOutput(logger)
+	log.SetOutput(rootLogger)
 	rootLogger.Info().Msg("root logger configured")
 }
 
@@ -39,6 +77,18 @@ func RootLogger() *zerolog.Logger {
 	return rootLogger
 }
 
+// Get the logger for the given module.
+//
+// The returned logger honors the module specific log level (if configured).
+func ModuleLogger(module string) *zerolog.Logger {
+	logger := rootLogger.With().Str("module", module).Logger()
+	moduleLevel, found := moduleLevels[module]
+	if found {
+		logger = logger.Level(moduleLevel)
+	}
+	return &logger
+}
+
 func NewConsoleLogger(out *os.File, forceColor bool) *zerolog.Logger {
 	color := forceColor
 	if !color {
@@ -48,6 +98,25 @@ func NewConsoleLogger(out *os.File, forceColor bool) *zerolog.Logger {
 	return &logger
 }
 
+// Create a logger writing to the given output using the given format.
+//
+// Console output is colored if requested or if the output is a terminal.
+func NewLogger(out io.Writer, format string, forceColor bool) (*zerolog.Logger, error) {
+	switch format {
+	case "", FormatConsole:
+		file, isFile := out.(*os.File)
+		if isFile {
+			return NewConsoleLogger(file, forceColor), nil
+		}
+		logger := zerolog.New(zerolog.ConsoleWriter{Out: out, NoColor: !forceColor}).With().Timestamp().Logger()
+		return &logger, nil
+	case FormatJSON:
+		logger := zerolog.New(out).With().Timestamp().Logger()
+		return &logger, nil
+	}
+	return nil, fmt.Errorf("unrecognized log format '%s'", format)
+}
+
 func init() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	zerolog.SetGlobalLevel(zerolog.WarnLevel)
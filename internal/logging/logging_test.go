@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModuleLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := NewLogger(&out, FormatJSON, false)
+	require.NoError(t, err)
+	UpdateLoggers(logger, zerolog.WarnLevel, map[string]zerolog.Level{ModuleACME: zerolog.DebugLevel})
+	defer UpdateRootLogger(NewConsoleLogger(os.Stdout, false), zerolog.WarnLevel)
+	out.Reset()
+	ModuleLogger(ModuleServer).Debug().Msg("server debug")
+	require.Empty(t, out.String())
+	ModuleLogger(ModuleACME).Debug().Msg("acme debug")
+	entry := make(map[string]any)
+	err = json.Unmarshal(out.Bytes(), &entry)
+	require.NoError(t, err)
+	require.Equal(t, "acme debug", entry["message"])
+	require.Equal(t, ModuleACME, entry["module"])
+	require.Equal(t, "debug", entry["level"])
+	_, err = NewLogger(&out, "unknown", false)
+	require.Error(t, err)
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.log")
+	file, err := NewRotatingFile(path, 16, 0, 2)
+	require.NoError(t, err)
+	defer file.Close()
+	for i := 0; i < 5; i++ {
+		_, err = file.Write([]byte("0123456789\n"))
+		require.NoError(t, err)
+	}
+	logBytes, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789\n", string(logBytes))
+	backups, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(backups))
+}
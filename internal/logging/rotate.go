@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const rotatedSuffixLayout = "20060102-150405.000"
+
+// RotatingFile is a log file writer rotating the log file based on its size and age.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	file       *os.File
+	size       int64
+	opened     time.Time
+	lock       sync.Mutex
+}
+
+// Open a rotating log file.
+//
+// The log file is rotated as soon as it exceeds maxSize bytes or gets older than maxAge. A zero maxSize or maxAge
+// disables the corresponding rotation trigger. Rotated files are named after the log file with the rotation time
+// appended. Only the newest maxBackups rotated files are kept (all if maxBackups is zero).
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	file := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	err := file.open()
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (file *RotatingFile) Write(p []byte) (int, error) {
+	file.lock.Lock()
+	defer file.lock.Unlock()
+	if file.file == nil {
+		return 0, os.ErrClosed
+	}
+	if file.rotationDue(int64(len(p))) {
+		err := file.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := file.file.Write(p)
+	file.size += int64(n)
+	return n, err
+}
+
+func (file *RotatingFile) Close() error {
+	file.lock.Lock()
+	defer file.lock.Unlock()
+	if file.file == nil {
+		return nil
+	}
+	err := file.file.Close()
+	file.file = nil
+	return err
+}
+
+func (file *RotatingFile) open() error {
+	opened, err := os.OpenFile(file.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file '%s' (cause: %w)", file.path, err)
+	}
+	fileInfo, err := opened.Stat()
+	if err != nil {
+		opened.Close()
+		return fmt.Errorf("failed to stat log file '%s' (cause: %w)", file.path, err)
+	}
+	file.file = opened
+	file.size = fileInfo.Size()
+	file.opened = time.Now()
+	return nil
+}
+
+func (file *RotatingFile) rotationDue(writeSize int64) bool {
+	if file.size == 0 {
+		return false
+	}
+	if file.maxSize > 0 && file.size+writeSize > file.maxSize {
+		return true
+	}
+	return file.maxAge > 0 && time.Since(file.opened) >= file.maxAge
+}
+
+func (file *RotatingFile) rotate() error {
+	err := file.file.Close()
+	file.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to close log file '%s' (cause: %w)", file.path, err)
+	}
+	rotatedPath := file.path + "." + time.Now().Format(rotatedSuffixLayout)
+	for i := 1; ; i++ {
+		_, err = os.Stat(rotatedPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		rotatedPath = fmt.Sprintf("%s.%s-%d", file.path, time.Now().Format(rotatedSuffixLayout), i)
+	}
+	err = os.Rename(file.path, rotatedPath)
+	if err != nil {
+		return fmt.Errorf("failed to rotate log file '%s' (cause: %w)", file.path, err)
+	}
+	err = file.open()
+	if err != nil {
+		return err
+	}
+	return file.removeBackups()
+}
+
+func (file *RotatingFile) removeBackups() error {
+	if file.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(file.path + ".*")
+	if err != nil {
+		return fmt.Errorf("failed to list rotated log files for '%s' (cause: %w)", file.path, err)
+	}
+	if len(backups) <= file.maxBackups {
+		return nil
+	}
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-file.maxBackups] {
+		err = os.Remove(backup)
+		if err != nil {
+			return fmt.Errorf("failed to remove rotated log file '%s' (cause: %w)", backup, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var gauges = make(map[string]*Gauge)
+var gaugesLock sync.RWMutex
+
+// Gauge represents a labeled metric value which can go up and down.
+type Gauge struct {
+	name       string
+	help       string
+	labelNames []string
+	values     map[string]float64
+	lock       sync.Mutex
+}
+
+// Get or register the gauge with the given name.
+func NewGauge(name string, help string, labelNames ...string) *Gauge {
+	gaugesLock.Lock()
+	defer gaugesLock.Unlock()
+	gauge := gauges[name]
+	if gauge == nil {
+		gauge = &Gauge{
+			name:       name,
+			help:       help,
+			labelNames: labelNames,
+			values:     make(map[string]float64),
+		}
+		gauges[name] = gauge
+	}
+	return gauge
+}
+
+// Set the gauge value for the given label values.
+func (gauge *Gauge) Set(value float64, labelValues ...string) {
+	gauge.lock.Lock()
+	defer gauge.lock.Unlock()
+	gauge.values[gauge.labels(labelValues)] = value
+}
+
+// Remove the gauge value for the given label values.
+func (gauge *Gauge) Delete(labelValues ...string) {
+	gauge.lock.Lock()
+	defer gauge.lock.Unlock()
+	delete(gauge.values, gauge.labels(labelValues))
+}
+
+func (gauge *Gauge) labels(labelValues []string) string {
+	if len(gauge.labelNames) == 0 {
+		return ""
+	}
+	labels := make([]string, len(gauge.labelNames))
+	for i, labelName := range gauge.labelNames {
+		labelValue := ""
+		if i < len(labelValues) {
+			labelValue = labelValues[i]
+		}
+		labels[i] = labelName + "=" + strconv.Quote(labelValue)
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func (gauge *Gauge) write(w io.Writer) error {
+	gauge.lock.Lock()
+	defer gauge.lock.Unlock()
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", gauge.name, gauge.help, gauge.name)
+	if err != nil {
+		return err
+	}
+	labels := make([]string, 0, len(gauge.values))
+	for label := range gauge.values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		_, err = fmt.Fprintf(w, "%s%s %s\n", gauge.name, label, strconv.FormatFloat(gauge.values[label], 'g', -1, 64))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write all registered metrics in Prometheus text exposition format.
+func Write(w io.Writer) error {
+	gaugesLock.RLock()
+	defer gaugesLock.RUnlock()
+	names := make([]string, 0, len(gauges))
+	for name := range gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := gauges[name].write(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGauge(t *testing.T) {
+	gauge := NewGauge("certd_test_gauge", "Test gauge", "target")
+	require.Equal(t, gauge, NewGauge("certd_test_gauge", "Test gauge", "target"))
+	gauge.Set(1, "b")
+	gauge.Set(2.5, "a")
+	gauge.Set(3, "c")
+	gauge.Delete("c")
+	var builder strings.Builder
+	err := Write(&builder)
+	require.NoError(t, err)
+	require.Contains(t, builder.String(), "# TYPE certd_test_gauge gauge\ncertd_test_gauge{target=\"a\"} 2.5\ncertd_test_gauge{target=\"b\"} 1\n")
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package notify
+
+import (
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/logging"
+)
+
+// Event describes a noteworthy incident to notify about.
+type Event struct {
+	Type      string    `json:"type"`
+	Subject   string    `json:"subject"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func NewEvent(eventType string, subject string, message string) *Event {
+	return &Event{
+		Type:      eventType,
+		Subject:   subject,
+		Message:   message,
+		Timestamp: time.Now(),
+	}
+}
+
+type Notifier interface {
+	Notify(event *Event) error
+}
+
+// Create the notifier as defined by the given configuration.
+//
+// Events are always logged and additionally sent to any configured target.
+func NewNotifier(config *config.NotifyConfig) Notifier {
+	notifiers := []Notifier{&logNotifier{}}
+	if config.WebhookURL != "" {
+		notifiers = append(notifiers, NewWebhookNotifier(config.WebhookURL))
+	}
+	return &multiNotifier{notifiers: notifiers}
+}
+
+type multiNotifier struct {
+	notifiers []Notifier
+}
+
+func (notifier *multiNotifier) Notify(event *Event) error {
+	var firstErr error
+	for _, target := range notifier.notifiers {
+		err := target.Notify(event)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+type logNotifier struct{}
+
+func (notifier *logNotifier) Notify(event *Event) error {
+	logging.RootLogger().Warn().Str("event", event.Type).Str("subject", event.Subject).Msg(event.Message)
+	return nil
+}
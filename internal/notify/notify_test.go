@@ -0,0 +1,49 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	received := make(chan *Event, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := &Event{}
+		err := json.NewDecoder(r.Body).Decode(event)
+		require.NoError(t, err)
+		received <- event
+	}))
+	defer webhook.Close()
+	notifier := NewNotifier(&config.NotifyConfig{WebhookURL: webhook.URL})
+	err := notifier.Notify(NewEvent("test", "subject", "message"))
+	require.NoError(t, err)
+	event := <-received
+	require.Equal(t, "test", event.Type)
+	require.Equal(t, "subject", event.Subject)
+	require.Equal(t, "message", event.Message)
+	webhook.Close()
+	err = notifier.Notify(NewEvent("test", "subject", "message"))
+	require.Error(t, err)
+}
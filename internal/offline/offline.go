@@ -0,0 +1,469 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package offline
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/internal/state"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/certs/export"
+	"github.com/hdecarne-github/certd/pkg/certs/fsstore"
+	"github.com/hdecarne-github/certd/pkg/certs/local"
+	"github.com/hdecarne-github/certd/pkg/clock"
+	"github.com/hdecarne-github/certd/pkg/entropy"
+	"github.com/hdecarne-github/certd/pkg/keys/registry"
+)
+
+const FormatCRT = "crt"
+const FormatKey = "key"
+const FormatPKCS8 = export.FormatPKCS8
+const FormatOpenSSH = export.FormatOpenSSH
+const FormatOpenSSHPub = export.FormatOpenSSHPub
+const FormatPKCS12 = export.FormatPKCS12
+
+// Command to run against an opened store.
+type Command interface {
+	Run(config *config.ServerConfig, store *fsstore.FSStore) error
+}
+
+// Open the configured store and run the given command on it.
+//
+// The store is locked for the duration of the command, hence a running server using the same store causes
+// the command to fail.
+func Run(config *config.ServerConfig, command Command) error {
+	if config.Testing.Apply() {
+		logging.RootLogger().Warn().Msg("Fixed testing clock enabled; never use in production")
+	}
+	store, err := openStore(config)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return command.Run(config, store)
+}
+
+func openStore(config *config.ServerConfig) (*fsstore.FSStore, error) {
+	permissionPolicy, err := fsstore.ParsePermissionPolicy(config.StorePerms)
+	if err != nil {
+		return nil, err
+	}
+	options := []fsstore.Option{fsstore.WithPermissionPolicy(permissionPolicy)}
+	switch config.StoreCrypt {
+	case "", "keys":
+	case "entries":
+		options = append(options, fsstore.WithEntryEncryption())
+	default:
+		return nil, fmt.Errorf("unrecognized store encryption mode '%s'", config.StoreCrypt)
+	}
+	if config.ForceUnlock {
+		options = append(options, fsstore.WithForceUnlock())
+	}
+	if config.State.Encrypt {
+		stateHandler, err := state.NewHandler(&config.State, config.ResolveStatePath())
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, fsstore.WithSecretRotationHook(state.NewRotation(stateHandler)))
+	}
+	storePath := config.ResolveStorePath()
+	_, err = os.Stat(storePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		logging.RootLogger().Info().Msgf("Initializing store '%s'...", storePath)
+		return fsstore.Init(storePath, options...)
+	} else if err != nil {
+		return nil, err
+	}
+	return fsstore.Open(storePath, options...)
+}
+
+// Generate a new key and certificate (self-signed or signed by an issuer entry holding a key).
+type GenerateCommand struct {
+	Name     string
+	DN       string
+	KeyType  string
+	Issuer   string
+	Validity time.Duration
+	CA       bool
+	PathLen  int
+	DNSNames []string
+}
+
+func (command *GenerateCommand) Run(config *config.ServerConfig, store *fsstore.FSStore) error {
+	keyFactory, err := registry.FactoryFor(command.KeyType)
+	if err != nil {
+		return err
+	}
+	err = config.KeyPolicy.Policy().Check(keyFactory, false)
+	if err != nil {
+		return err
+	}
+	rawSubject, err := certs.ParseRawDN(command.DN)
+	if err != nil {
+		return err
+	}
+	template, err := newTemplate(&config.Local, command.Validity, command.CA, command.PathLen)
+	if err != nil {
+		return err
+	}
+	template.RawSubject = rawSubject
+	template.DNSNames = command.DNSNames
+	var parent *x509.Certificate
+	var signer crypto.Signer
+	if command.Issuer != "" {
+		err = checkNamePolicy(&config.Local, command.Issuer, template)
+		if err != nil {
+			return err
+		}
+		parent, signer, err = resolveIssuer(config, store, command.Issuer)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = store.CreateCertificate(context.Background(), command.Name, local.NewLocalCertificateFactory(template, keyFactory, parent, signer))
+	return err
+}
+
+// Sign a certificate request file using an issuer entry holding a key.
+type SignCommand struct {
+	Name     string
+	CSRFile  string
+	Issuer   string
+	Validity time.Duration
+	CA       bool
+	PathLen  int
+}
+
+func (command *SignCommand) Run(config *config.ServerConfig, store *fsstore.FSStore) error {
+	csrBytes, err := os.ReadFile(command.CSRFile)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate request file '%s' (cause: %w)", command.CSRFile, err)
+	}
+	csrBlock, _ := pem.Decode(csrBytes)
+	if csrBlock != nil {
+		csrBytes = csrBlock.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(csrBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate request file '%s' (cause: %w)", command.CSRFile, err)
+	}
+	err = config.KeyPolicy.Policy().CheckPublicKey(csr.PublicKey)
+	if err != nil {
+		return err
+	}
+	err = checkNamePolicy(&config.Local, command.Issuer, &x509.Certificate{DNSNames: csr.DNSNames, IPAddresses: csr.IPAddresses, EmailAddresses: csr.EmailAddresses, URIs: csr.URIs})
+	if err != nil {
+		return err
+	}
+	parent, signer, err := resolveIssuer(config, store, command.Issuer)
+	if err != nil {
+		return err
+	}
+	template, err := newTemplate(&config.Local, command.Validity, command.CA, command.PathLen)
+	if err != nil {
+		return err
+	}
+	_, _, err = store.CreateCertificateWithoutKey(context.Background(), command.Name, local.NewLocalCSRCertificateFactory(template, csr, parent, signer))
+	return err
+}
+
+// Export the certificate or key of a store entry (PEM encoded; optionally password protected or in OpenSSH format) or both as a PKCS#12 archive.
+type ExportCommand struct {
+	Name     string
+	Format   string
+	Password string
+	Out      string
+}
+
+func (command *ExportCommand) Run(config *config.ServerConfig, store *fsstore.FSStore) error {
+	storeEntry, err := store.Entry(command.Name)
+	if err != nil {
+		return fmt.Errorf("failed to access store entry '%s' (cause: %w)", command.Name, err)
+	}
+	var exported []byte
+	switch command.Format {
+	case FormatCRT:
+		certificate, err := entryCertificate(storeEntry)
+		if err != nil {
+			return err
+		}
+		exported = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+	case FormatKey:
+		key, err := entryKey(storeEntry)
+		if err != nil {
+			return err
+		}
+		keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return fmt.Errorf("failed to marshal private key (cause: %w)", err)
+		}
+		exported = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+	case FormatPKCS8:
+		key, err := entryKey(storeEntry)
+		if err != nil {
+			return err
+		}
+		exported, err = export.KeyPKCS8(key, command.Password)
+		if err != nil {
+			return err
+		}
+	case FormatOpenSSH:
+		key, err := entryKey(storeEntry)
+		if err != nil {
+			return err
+		}
+		exported, err = export.KeyOpenSSH(key, command.Name)
+		if err != nil {
+			return err
+		}
+	case FormatOpenSSHPub:
+		key, err := entryKey(storeEntry)
+		if err != nil {
+			return err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("unsupported key type of store entry '%s'", command.Name)
+		}
+		exported, err = export.PublicKeyOpenSSH(signer.Public(), command.Name)
+		if err != nil {
+			return err
+		}
+	case FormatPKCS12:
+		certificate, err := entryCertificate(storeEntry)
+		if err != nil {
+			return err
+		}
+		key, err := entryKey(storeEntry)
+		if err != nil {
+			return err
+		}
+		exported, err = export.PKCS12(key, certificate, issuerChain(store, certificate), command.Password)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unrecognized export format '%s'", command.Format)
+	}
+	if command.Out == "" {
+		_, err = os.Stdout.Write(exported)
+		return err
+	}
+	err = os.WriteFile(command.Out, exported, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write export file '%s' (cause: %w)", command.Out, err)
+	}
+	return nil
+}
+
+// Compare two certificates (each given by store entry name or certificate file).
+type DiffCommand struct {
+	Old string
+	New string
+}
+
+func (command *DiffCommand) Run(config *config.ServerConfig, store *fsstore.FSStore) error {
+	oldCertificate, err := resolveCertificate(store, command.Old)
+	if err != nil {
+		return err
+	}
+	newCertificate, err := resolveCertificate(store, command.New)
+	if err != nil {
+		return err
+	}
+	return certs.WriteCertificateDiff(os.Stdout, certs.DiffCertificates(oldCertificate, newCertificate))
+}
+
+func resolveCertificate(store *fsstore.FSStore, entryOrFile string) (*x509.Certificate, error) {
+	_, err := os.Stat(entryOrFile)
+	if err != nil {
+		storeEntry, err := store.Entry(entryOrFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to access store entry '%s' (cause: %w)", entryOrFile, err)
+		}
+		return entryCertificate(storeEntry)
+	}
+	certificates, err := certs.ReadCertificates(entryOrFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("no certificate found in file '%s'", entryOrFile)
+	}
+	return certificates[0], nil
+}
+
+// Report (and optionally remove) orphaned store files.
+type GCCommand struct {
+	Remove bool
+}
+
+func (command *GCCommand) Run(config *config.ServerConfig, store *fsstore.FSStore) error {
+	orphans, err := store.CollectGarbage(command.Remove)
+	for _, orphan := range orphans {
+		status := "retained"
+		if orphan.Removed {
+			status = "removed"
+		} else if orphan.Removable {
+			status = "removable"
+		}
+		fmt.Printf("%s\t%d\t%s\t%s\n", orphan.File, orphan.Size, orphan.Reason, status)
+	}
+	return err
+}
+
+// Replace the store secret and re-encrypt all key files (and encrypted entry files as well as encrypted state) using the
+// new secret.
+type RotateSecretCommand struct{}
+
+func (command *RotateSecretCommand) Run(config *config.ServerConfig, store *fsstore.FSStore) error {
+	rotated, err := store.RotateSecret()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Store secret rotated (%d files re-encrypted)\n", rotated)
+	return nil
+}
+
+func newTemplate(localConfig *config.LocalConfig, validity time.Duration, ca bool, pathLen int) (*x509.Certificate, error) {
+	serialNumber, err := entropy.SerialNumber(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number (cause: %w)", err)
+	}
+	clk := clock.Current()
+	notBefore, notAfter, warnings := local.ResolveValidity(clk, localConfig.NotBeforeSkew, time.Time{}, clk.Now().Add(validity))
+	for _, warning := range warnings {
+		logging.RootLogger().Warn().Msgf("Odd validity period requested (%s)", warning)
+	}
+	template := &x509.Certificate{
+		Version:               3,
+		SerialNumber:          serialNumber,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+	if ca {
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		if pathLen >= 0 {
+			template.MaxPathLen = pathLen
+			template.MaxPathLenZero = true
+		} else {
+			template.MaxPathLen = -1
+		}
+	} else {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+	return template, nil
+}
+
+func resolveIssuer(config *config.ServerConfig, store *fsstore.FSStore, issuer string) (*x509.Certificate, crypto.Signer, error) {
+	issuerEntry, err := store.Entry(issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to access issuer store entry '%s' (cause: %w)", issuer, err)
+	}
+	parent, err := entryCertificate(issuerEntry)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = config.KeyPolicy.Policy().CheckCertificate(parent)
+	if err != nil {
+		return nil, nil, fmt.Errorf("issuer '%s' rejected (cause: %w)", issuer, err)
+	}
+	key, err := entryKey(issuerEntry)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, err := certs.KeySigner(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to access key of issuer store entry '%s' (cause: %w)", issuer, err)
+	}
+	return parent, signer, nil
+}
+
+func checkNamePolicy(localConfig *config.LocalConfig, issuer string, template *x509.Certificate) error {
+	policy := localConfig.NamePolicy(issuer)
+	if policy == nil {
+		return nil
+	}
+	err := policy.Check(template.DNSNames, template.IPAddresses, template.EmailAddresses, template.URIs)
+	if err != nil {
+		return fmt.Errorf("issuer '%s' rejected certificate (cause: %w)", issuer, err)
+	}
+	return nil
+}
+
+func entryCertificate(storeEntry certs.StoreEntry) (*x509.Certificate, error) {
+	if !storeEntry.HasCertificate() {
+		return nil, fmt.Errorf("store entry '%s' has no certificate", storeEntry.Name())
+	}
+	return storeEntry.Certificate()
+}
+
+func entryKey(storeEntry certs.StoreEntry) (crypto.PrivateKey, error) {
+	if !storeEntry.HasKey() {
+		return nil, fmt.Errorf("store entry '%s' has no key", storeEntry.Name())
+	}
+	return storeEntry.Key()
+}
+
+// Collect the issuer certificates of the given certificate as far as they are available in the store.
+func issuerChain(store certs.Store, certificate *x509.Certificate) []*x509.Certificate {
+	chain := make([]*x509.Certificate, 0)
+	current := certificate
+	for len(chain) < 10 && !certs.IsIssuedBy(current, current) {
+		issuer := findIssuer(store, current)
+		if issuer == nil {
+			break
+		}
+		chain = append(chain, issuer)
+		current = issuer
+	}
+	return chain
+}
+
+func findIssuer(store certs.Store, certificate *x509.Certificate) *x509.Certificate {
+	storeEntries := store.Entries()
+	for {
+		storeEntry := storeEntries.Next()
+		if storeEntry == nil {
+			return nil
+		}
+		if !storeEntry.HasCertificate() {
+			continue
+		}
+		issuer, err := storeEntry.Certificate()
+		if err != nil || !issuer.IsCA {
+			continue
+		}
+		if certs.IsIssuedBy(certificate, issuer) && certificate.CheckSignatureFrom(issuer) == nil {
+			return issuer
+		}
+	}
+}
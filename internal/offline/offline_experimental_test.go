@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package offline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExperimentalGenerate(t *testing.T) {
+	home, err := os.MkdirTemp("", "certd")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	serverConfig := &config.Defaults().Server
+	serverConfig.BasePath = home
+	serverConfig.StorePath = "store"
+	generateRoot := &GenerateCommand{
+		Name:     "pqc-root",
+		DN:       "CN=PQC Root CA",
+		KeyType:  "ML-DSA-87",
+		Validity: 87600 * time.Hour,
+		CA:       true,
+		PathLen:  0,
+	}
+	require.Error(t, Run(serverConfig, generateRoot))
+	serverConfig.KeyPolicy.Experimental = true
+	require.NoError(t, Run(serverConfig, generateRoot))
+	generateLeaf := &GenerateCommand{
+		Name:     "pqc-leaf",
+		DN:       "CN=pqc-leaf",
+		KeyType:  "ML-DSA-44",
+		Issuer:   "pqc-root",
+		Validity: 24 * time.Hour,
+		DNSNames: []string{"pqc.example.org"},
+	}
+	require.NoError(t, Run(serverConfig, generateLeaf))
+	crtFile := filepath.Join(home, "pqc-chain.crt")
+	require.NoError(t, Run(serverConfig, &ExportCommand{Name: "pqc-root", Format: FormatCRT, Out: crtFile}))
+	roots, err := certs.ReadCertificates(crtFile)
+	require.NoError(t, err)
+	require.NoError(t, Run(serverConfig, &ExportCommand{Name: "pqc-leaf", Format: FormatCRT, Out: crtFile}))
+	leaves, err := certs.ReadCertificates(crtFile)
+	require.NoError(t, err)
+	require.Equal(t, "ML-DSA-87", leaves[0].SignatureAlgorithm.String())
+	require.NoError(t, leaves[0].CheckSignatureFrom(roots[0]))
+}
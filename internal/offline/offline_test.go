@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package offline
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/state"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/clock"
+	"github.com/hdecarne-github/certd/pkg/entropy"
+	"github.com/stretchr/testify/require"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestOfflineCommands(t *testing.T) {
+	home, err := os.MkdirTemp("", "certd")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	serverConfig := &config.Defaults().Server
+	serverConfig.BasePath = home
+	serverConfig.StorePath = "store"
+	generateRoot := &GenerateCommand{
+		Name:     "root",
+		DN:       "CN=Root CA",
+		KeyType:  "ECDSA P-384",
+		Validity: 87600 * time.Hour,
+		CA:       true,
+		PathLen:  1,
+	}
+	require.NoError(t, Run(serverConfig, generateRoot))
+	generateLeaf := &GenerateCommand{
+		Name:     "leaf",
+		DN:       "CN=leaf",
+		KeyType:  "ECDSA P-256",
+		Issuer:   "root",
+		Validity: 24 * time.Hour,
+		DNSNames: []string{"leaf.example.org"},
+	}
+	require.NoError(t, Run(serverConfig, generateLeaf))
+	serverConfig.Local.Policies = map[string]config.NamePolicyConfig{"root": {DNSNames: []string{"team.example.org"}}}
+	require.Error(t, Run(serverConfig, &GenerateCommand{Name: "denied", DN: "CN=denied", KeyType: "ECDSA P-256", Issuer: "root", Validity: time.Hour, DNSNames: []string{"denied.example.org"}}))
+	serverConfig.Local.Policies = nil
+	require.Error(t, Run(serverConfig, &GenerateCommand{Name: "invalid", DN: "CN=invalid", KeyType: "unknown"}))
+	sign := &SignCommand{
+		Name:     "signed",
+		CSRFile:  writeTestCSR(t, home),
+		Issuer:   "root",
+		Validity: 24 * time.Hour,
+	}
+	require.NoError(t, Run(serverConfig, sign))
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	require.Error(t, Run(serverConfig, &SignCommand{Name: "weak", CSRFile: writeTestCSRForKey(t, home, weakKey), Issuer: "root", Validity: 24 * time.Hour}))
+	crtFile := filepath.Join(home, "signed.crt")
+	require.NoError(t, Run(serverConfig, &ExportCommand{Name: "signed", Format: FormatCRT, Out: crtFile}))
+	exportedCertificates, err := certs.ReadCertificates(crtFile)
+	require.NoError(t, err)
+	require.Equal(t, "CN=request", exportedCertificates[0].Subject.String())
+	require.Equal(t, "CN=Root CA", exportedCertificates[0].Issuer.String())
+	require.Error(t, Run(serverConfig, &ExportCommand{Name: "signed", Format: FormatKey}))
+	p12File := filepath.Join(home, "leaf.p12")
+	require.NoError(t, Run(serverConfig, &ExportCommand{Name: "leaf", Format: FormatPKCS12, Password: "secret", Out: p12File}))
+	p12Bytes, err := os.ReadFile(p12File)
+	require.NoError(t, err)
+	_, certificate, chain, err := pkcs12.DecodeChain(p12Bytes, "secret")
+	require.NoError(t, err)
+	require.Equal(t, "CN=leaf", certificate.Subject.String())
+	require.Equal(t, []string{"leaf.example.org"}, certificate.DNSNames)
+	require.Equal(t, 1, len(chain))
+	require.Equal(t, "CN=Root CA", chain[0].Subject.String())
+	pkcs8File := filepath.Join(home, "leaf.key")
+	require.NoError(t, Run(serverConfig, &ExportCommand{Name: "leaf", Format: FormatPKCS8, Password: "secret", Out: pkcs8File}))
+	pkcs8Bytes, err := os.ReadFile(pkcs8File)
+	require.NoError(t, err)
+	require.Contains(t, string(pkcs8Bytes), "ENCRYPTED PRIVATE KEY")
+	require.Error(t, Run(serverConfig, &ExportCommand{Name: "leaf", Format: FormatPKCS8}))
+	sshFile := filepath.Join(home, "leaf.pub")
+	require.NoError(t, Run(serverConfig, &ExportCommand{Name: "leaf", Format: FormatOpenSSHPub, Out: sshFile}))
+	sshBytes, err := os.ReadFile(sshFile)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(sshBytes), "ecdsa-sha2-nistp256 "))
+	require.Error(t, Run(serverConfig, &ExportCommand{Name: "leaf", Format: "unknown"}))
+	require.NoError(t, Run(serverConfig, &DiffCommand{Old: "leaf", New: crtFile}))
+	require.Error(t, Run(serverConfig, &DiffCommand{Old: "leaf", New: "unknown"}))
+	orphanFile := filepath.Join(home, "store", "lost.key")
+	require.NoError(t, os.WriteFile(orphanFile, []byte{}, 0600))
+	require.NoError(t, Run(serverConfig, &GCCommand{}))
+	require.FileExists(t, orphanFile)
+	require.NoError(t, Run(serverConfig, &GCCommand{Remove: true}))
+	require.NoFileExists(t, orphanFile)
+	require.NoError(t, Run(serverConfig, &ExportCommand{Name: "leaf", Format: FormatCRT, Out: crtFile}))
+	require.NoError(t, Run(serverConfig, &RotateSecretCommand{}))
+	require.NoError(t, Run(serverConfig, &ExportCommand{Name: "leaf", Format: FormatPKCS12, Password: "secret", Out: p12File}))
+}
+
+func TestRotateSecretEncryptedState(t *testing.T) {
+	home, err := os.MkdirTemp("", "certd")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	serverConfig := &config.Defaults().Server
+	serverConfig.BasePath = home
+	serverConfig.StorePath = "store"
+	serverConfig.StatePath = "state"
+	serverConfig.State.Encrypt = true
+	require.NoError(t, Run(serverConfig, &GenerateCommand{Name: "root", DN: "CN=Root CA", KeyType: "ECDSA P-256", Validity: time.Hour, CA: true, PathLen: -1}))
+	const statePath = "offline-test.json"
+	state.RegisterPath(statePath)
+	fsHandler := state.NewFSHandler(serverConfig.ResolveStatePath())
+	require.NoError(t, openEncryptedState(t, serverConfig, fsHandler).Write(statePath, []byte("state")))
+	require.NoError(t, Run(serverConfig, &RotateSecretCommand{}))
+	data, err := openEncryptedState(t, serverConfig, fsHandler).Read(statePath)
+	require.NoError(t, err)
+	require.Equal(t, "state", string(data))
+}
+
+func openEncryptedState(t *testing.T, serverConfig *config.ServerConfig, fsHandler state.Handler) state.Handler {
+	store, err := openStore(serverConfig)
+	require.NoError(t, err)
+	defer store.Close()
+	key, err := store.DeriveKey(state.KeyPurpose, state.KeyLength)
+	require.NoError(t, err)
+	handler, err := state.NewEncryptedHandler(fsHandler, key)
+	require.NoError(t, err)
+	return handler
+}
+
+func TestDeterministicGenerate(t *testing.T) {
+	defer entropy.Reset()
+	defer clock.Reset()
+	home, err := os.MkdirTemp("", "certd")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	serverConfig := &config.Defaults().Server
+	serverConfig.BasePath = home
+	serverConfig.Testing.Time = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	generate := &GenerateCommand{
+		Name:     "root",
+		DN:       "CN=Root CA",
+		KeyType:  "ED25519",
+		Validity: 24 * time.Hour,
+		CA:       true,
+		PathLen:  -1,
+	}
+	serverConfig.StorePath = "store1"
+	entropy.Seed("seed")
+	require.NoError(t, Run(serverConfig, generate))
+	certificate1, err := certs.ReadCertificates(exportTestCRT(t, serverConfig, "root"))
+	require.NoError(t, err)
+	serverConfig.StorePath = "store2"
+	entropy.Seed("seed")
+	require.NoError(t, Run(serverConfig, generate))
+	certificate2, err := certs.ReadCertificates(exportTestCRT(t, serverConfig, "root"))
+	require.NoError(t, err)
+	require.Equal(t, certificate1[0].Raw, certificate2[0].Raw)
+	require.Equal(t, serverConfig.Testing.Time.Add(-serverConfig.Local.NotBeforeSkew), certificate1[0].NotBefore)
+}
+
+func exportTestCRT(t *testing.T, serverConfig *config.ServerConfig, name string) string {
+	crtFile := filepath.Join(serverConfig.BasePath, serverConfig.StorePath+".crt")
+	require.NoError(t, Run(serverConfig, &ExportCommand{Name: name, Format: FormatCRT, Out: crtFile}))
+	return crtFile
+}
+
+func writeTestCSR(t *testing.T, dir string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return writeTestCSRForKey(t, dir, key)
+}
+
+func writeTestCSRForKey(t *testing.T, dir string, key crypto.Signer) string {
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "request"}}, key)
+	require.NoError(t, err)
+	csrFile := filepath.Join(dir, "request.csr")
+	err = os.WriteFile(csrFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes}), 0600)
+	require.NoError(t, err)
+	return csrFile
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package publish
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"text/template"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/rs/zerolog"
+)
+
+const ldapUserCertificateAttribute = "userCertificate;binary"
+const ldapCACertificateAttribute = "cACertificate;binary"
+const ldapRevocationListAttribute = "certificateRevocationList;binary"
+
+var ldapDial = func(url string) (ldap.Client, error) {
+	return ldap.DialURL(url)
+}
+
+type LDAPPublisher struct {
+	config *config.LDAPPublishConfig
+	logger *zerolog.Logger
+}
+
+type ldapDNTemplateData struct {
+	Name string
+}
+
+// Create a publisher writing certificates and revocation lists to the configured LDAP directory.
+//
+// The target LDAP entries must already exist; only the corresponding attributes are replaced.
+func NewLDAPPublisher(config *config.LDAPPublishConfig) *LDAPPublisher {
+	logger := logging.RootLogger().With().Str("publisher", config.URL).Logger()
+	return &LDAPPublisher{
+		config: config,
+		logger: &logger,
+	}
+}
+
+func (publisher *LDAPPublisher) PublishCertificate(name string, certificate *x509.Certificate) error {
+	attribute := ldapUserCertificateAttribute
+	if certificate.IsCA {
+		attribute = ldapCACertificateAttribute
+	}
+	return publisher.publish(name, attribute, certificate.Raw)
+}
+
+func (publisher *LDAPPublisher) PublishRevocationList(name string, revocationList *x509.RevocationList) error {
+	return publisher.publish(name, ldapRevocationListAttribute, revocationList.Raw)
+}
+
+func (publisher *LDAPPublisher) publish(name string, attribute string, value []byte) error {
+	dn, err := publisher.resolveDN(name)
+	if err != nil {
+		return err
+	}
+	if dn == "" {
+		publisher.logger.Debug().Msgf("No LDAP DN defined for store entry '%s'; skipping publication", name)
+		return nil
+	}
+	publisher.logger.Info().Msgf("Publishing '%s' of store entry '%s' to '%s'...", attribute, name, dn)
+	conn, err := ldapDial(publisher.config.URL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to LDAP server '%s' (cause: %w)", publisher.config.URL, err)
+	}
+	defer conn.Close()
+	if publisher.config.BindDN != "" {
+		err = conn.Bind(publisher.config.BindDN, publisher.config.BindPassword)
+		if err != nil {
+			return fmt.Errorf("failed to bind to LDAP server '%s' (cause: %w)", publisher.config.URL, err)
+		}
+	}
+	modify := ldap.NewModifyRequest(dn, nil)
+	modify.Replace(attribute, []string{string(value)})
+	err = conn.Modify(modify)
+	if err != nil {
+		return fmt.Errorf("failed to publish '%s' to LDAP entry '%s' (cause: %w)", attribute, dn, err)
+	}
+	return nil
+}
+
+func (publisher *LDAPPublisher) resolveDN(name string) (string, error) {
+	dn := publisher.config.DNs[name]
+	if dn != "" || publisher.config.DNTemplate == "" {
+		return dn, nil
+	}
+	dnTemplate, err := template.New("dn_template").Parse(publisher.config.DNTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid LDAP DN template '%s' (cause: %w)", publisher.config.DNTemplate, err)
+	}
+	var resolved bytes.Buffer
+	err = dnTemplate.Execute(&resolved, &ldapDNTemplateData{Name: certs.EscapeDNValue(name)})
+	if err != nil {
+		return "", fmt.Errorf("failed to evaluate LDAP DN template '%s' (cause: %w)", publisher.config.DNTemplate, err)
+	}
+	return resolved.String(), nil
+}
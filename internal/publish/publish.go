@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package publish
+
+import (
+	"crypto/x509"
+	"errors"
+
+	"github.com/hdecarne-github/certd/internal/config"
+)
+
+// Publisher makes issued certificates and revocation lists available to external directories.
+type Publisher interface {
+	PublishCertificate(name string, certificate *x509.Certificate) error
+	PublishRevocationList(name string, revocationList *x509.RevocationList) error
+}
+
+// Create the publisher as defined by the given configuration.
+//
+// If no publication target is configured, the returned publisher does nothing.
+func NewPublisher(config *config.PublishConfig) Publisher {
+	publishers := make([]Publisher, 0)
+	if config.LDAP.URL != "" {
+		publishers = append(publishers, NewLDAPPublisher(&config.LDAP))
+	}
+	return &multiPublisher{publishers: publishers}
+}
+
+type multiPublisher struct {
+	publishers []Publisher
+}
+
+func (publisher *multiPublisher) PublishCertificate(name string, certificate *x509.Certificate) error {
+	errs := make([]error, 0)
+	for _, target := range publisher.publishers {
+		err := target.PublishCertificate(name, certificate)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (publisher *multiPublisher) PublishRevocationList(name string, revocationList *x509.RevocationList) error {
+	errs := make([]error, 0)
+	for _, target := range publisher.publishers {
+		err := target.PublishRevocationList(name, revocationList)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
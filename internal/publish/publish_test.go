@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package publish
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLDAPPublisher(t *testing.T) {
+	client := &testLDAPClient{}
+	ldapDial = func(url string) (ldap.Client, error) {
+		client.url = url
+		return client, nil
+	}
+	publishConfig := &config.PublishConfig{
+		LDAP: config.LDAPPublishConfig{
+			URL:          "ldap://localhost",
+			BindDN:       "cn=admin,dc=example,dc=org",
+			BindPassword: "secret",
+			DNTemplate:   "cn={{.Name}},ou=PKI,dc=example,dc=org",
+			DNs:          map[string]string{"ca": "cn=Root CA,ou=PKI,dc=example,dc=org"},
+		},
+	}
+	publisher := NewPublisher(publishConfig)
+	ca := &x509.Certificate{Raw: []byte{0x01}, IsCA: true}
+	err := publisher.PublishCertificate("ca", ca)
+	require.NoError(t, err)
+	require.Equal(t, "ldap://localhost", client.url)
+	require.Equal(t, "cn=admin,dc=example,dc=org", client.bindDN)
+	require.Equal(t, "cn=Root CA,ou=PKI,dc=example,dc=org", client.modify.DN)
+	require.Equal(t, ldapCACertificateAttribute, client.modify.Changes[0].Modification.Type)
+	require.True(t, client.closed)
+	user := &x509.Certificate{Raw: []byte{0x02}}
+	err = publisher.PublishCertificate("user,1", user)
+	require.NoError(t, err)
+	require.Equal(t, "cn=user\\,1,ou=PKI,dc=example,dc=org", client.modify.DN)
+	require.Equal(t, ldapUserCertificateAttribute, client.modify.Changes[0].Modification.Type)
+	require.Equal(t, []string{"\x02"}, client.modify.Changes[0].Modification.Vals)
+	revocationList := &x509.RevocationList{Raw: []byte{0x03}}
+	err = publisher.PublishRevocationList("ca", revocationList)
+	require.NoError(t, err)
+	require.Equal(t, ldapRevocationListAttribute, client.modify.Changes[0].Modification.Type)
+}
+
+func TestNoPublisher(t *testing.T) {
+	publisher := NewPublisher(&config.PublishConfig{})
+	err := publisher.PublishCertificate("ca", &x509.Certificate{})
+	require.NoError(t, err)
+}
+
+type testLDAPClient struct {
+	ldap.Client
+	url    string
+	bindDN string
+	modify *ldap.ModifyRequest
+	closed bool
+}
+
+func (client *testLDAPClient) Bind(username, password string) error {
+	client.bindDN = username
+	return nil
+}
+
+func (client *testLDAPClient) Modify(modify *ldap.ModifyRequest) error {
+	client.modify = modify
+	return nil
+}
+
+func (client *testLDAPClient) Close() {
+	client.closed = true
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package reissue
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/deploy"
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/internal/notify"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/certs/local"
+	"github.com/hdecarne-github/certd/pkg/clock"
+	"github.com/hdecarne-github/certd/pkg/entropy"
+	"github.com/hdecarne-github/certd/pkg/keys"
+	"github.com/hdecarne-github/certd/pkg/keys/registry"
+	"github.com/rs/zerolog"
+)
+
+const EventReissued = "reissued"
+const EventReissueFailure = "reissue_failure"
+
+const deployKeyFilePerm = 0600
+const deployCertFilePerm = 0644
+
+// Store holding the re-issued entries as well as their issuers.
+type Store interface {
+	Entry(name string) (certs.StoreEntry, error)
+	ReplaceCertificate(ctx context.Context, name string, factory certs.CertificateFactory) (certs.StoreEntry, error)
+}
+
+type Reissuer struct {
+	config        *config.ReissueConfig
+	notBeforeSkew time.Duration
+	keyPolicy     *registry.Policy
+	store         Store
+	notifier      notify.Notifier
+	onReissue     func(name string)
+	resolveSigner func(storeEntry certs.StoreEntry, certificate *x509.Certificate) (crypto.Signer, error)
+	logger        *zerolog.Logger
+}
+
+func NewReissuer(config *config.ReissueConfig, notBeforeSkew time.Duration, keyPolicy *registry.Policy, store Store, notifier notify.Notifier) *Reissuer {
+	logger := logging.RootLogger().With().Str("reissuer", "local").Logger()
+	return &Reissuer{
+		config:        config,
+		notBeforeSkew: notBeforeSkew,
+		keyPolicy:     keyPolicy,
+		store:         store,
+		notifier:      notifier,
+		logger:        &logger,
+	}
+}
+
+// Set the function resolving the signer of an issuer entry.
+//
+// By default the issuer's key held by the store is used. Set a custom resolver to support issuer keys held
+// outside the store (e.g. by a PIV token).
+func (reissuer *Reissuer) ResolveSigner(resolver func(storeEntry certs.StoreEntry, certificate *x509.Certificate) (crypto.Signer, error)) {
+	reissuer.resolveSigner = resolver
+}
+
+// Set the hook invoked for every entry re-issued during a run (e.g. to deploy it to secret managers).
+func (reissuer *Reissuer) OnReissue(hook func(name string)) {
+	reissuer.onReissue = hook
+}
+
+// Re-issue all configured targets due for renewal and invoke the corresponding deploy hooks.
+func (reissuer *Reissuer) Run(ctx context.Context) {
+	for i := range reissuer.config.Targets {
+		if ctx.Err() != nil {
+			return
+		}
+		target := &reissuer.config.Targets[i]
+		reissued, err := reissuer.Reissue(ctx, target, clock.FromContext(ctx).Now())
+		var event *notify.Event
+		if err != nil {
+			event = notify.NewEvent(EventReissueFailure, target.Entry, err.Error())
+		} else if reissued {
+			if reissuer.onReissue != nil {
+				reissuer.onReissue(target.Entry)
+			}
+			event = notify.NewEvent(EventReissued, target.Entry, fmt.Sprintf("certificate of store entry '%s' has been re-issued", target.Entry))
+		}
+		if event != nil {
+			err = reissuer.notifier.Notify(event)
+			if err != nil {
+				reissuer.logger.Error().Err(err).Msgf("Failed to send notification for '%s'", target.Entry)
+			}
+		}
+	}
+}
+
+// Re-issue the given target if its remaining validity is below the configured threshold.
+//
+// The returned flag indicates whether the certificate has been re-issued.
+func (reissuer *Reissuer) Reissue(ctx context.Context, target *config.ReissueTarget, now time.Time) (bool, error) {
+	storeEntry, err := reissuer.store.Entry(target.Entry)
+	if err != nil {
+		return false, fmt.Errorf("failed to access store entry '%s' (cause: %w)", target.Entry, err)
+	}
+	certificate, err := storeEntry.Certificate()
+	if err != nil || certificate == nil {
+		return false, fmt.Errorf("failed to access certificate of store entry '%s' (cause: %v)", target.Entry, err)
+	}
+	if certificate.NotAfter.Sub(now) > target.ResolveRenewBefore() {
+		return false, nil
+	}
+	reissuedEntry, issuer, err := reissuer.replace(ctx, target.Entry, certificate, target.Issuer, target.KeyType, target.ResolveLifetime(), now)
+	if err != nil {
+		return false, err
+	}
+	return true, reissuer.deploy(ctx, target, reissuedEntry, issuer)
+}
+
+// Unconditionally re-issue the certificate of the given store entry using the given issuer entry.
+//
+// The key type and the validity period of the current certificate are retained.
+func (reissuer *Reissuer) Renew(ctx context.Context, name string, issuerName string, now time.Time) (certs.StoreEntry, error) {
+	storeEntry, err := reissuer.store.Entry(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access store entry '%s' (cause: %w)", name, err)
+	}
+	certificate, err := storeEntry.Certificate()
+	if err != nil || certificate == nil {
+		return nil, fmt.Errorf("failed to access certificate of store entry '%s' (cause: %v)", name, err)
+	}
+	lifetime := certificate.NotAfter.Sub(certificate.NotBefore)
+	if lifetime > reissuer.notBeforeSkew {
+		lifetime -= reissuer.notBeforeSkew
+	}
+	renewedEntry, _, err := reissuer.replace(ctx, name, certificate, issuerName, "", lifetime, now)
+	return renewedEntry, err
+}
+
+func (reissuer *Reissuer) replace(ctx context.Context, name string, certificate *x509.Certificate, issuerName string, keyType string, lifetime time.Duration, now time.Time) (certs.StoreEntry, *x509.Certificate, error) {
+	reissuer.logger.Info().Msgf("Re-issuing certificate of store entry '%s'...", name)
+	issuerEntry, err := reissuer.store.Entry(issuerName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to access issuer store entry '%s' (cause: %w)", issuerName, err)
+	}
+	issuer, err := issuerEntry.Certificate()
+	if err != nil || issuer == nil {
+		return nil, nil, fmt.Errorf("failed to access certificate of issuer store entry '%s' (cause: %v)", issuerName, err)
+	}
+	signer, err := reissuer.issuerSigner(issuerEntry, issuer)
+	if err != nil || signer == nil {
+		return nil, nil, fmt.Errorf("failed to access key of issuer store entry '%s' (cause: %v)", issuerName, err)
+	}
+	keyFactory, err := resolveKeyFactory(keyType, certificate.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = reissuer.keyPolicy.Check(keyFactory, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to re-issue store entry '%s' (cause: %w)", name, err)
+	}
+	template, err := reissueTemplate(ctx, certificate, now, reissuer.notBeforeSkew, lifetime)
+	if err != nil {
+		return nil, nil, err
+	}
+	reissuedEntry, err := reissuer.store.ReplaceCertificate(ctx, name, local.NewLocalCertificateFactory(template, keyFactory, issuer, signer))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to re-issue store entry '%s' (cause: %w)", name, err)
+	}
+	return reissuedEntry, issuer, nil
+}
+
+func (reissuer *Reissuer) deploy(ctx context.Context, target *config.ReissueTarget, storeEntry certs.StoreEntry, issuer *x509.Certificate) error {
+	deployConfig := &target.Deploy
+	if deployConfig.CertFile != "" {
+		certificate, err := storeEntry.Certificate()
+		if err != nil {
+			return err
+		}
+		certBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+		certBytes = append(certBytes, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.Raw})...)
+		err = deploy.WriteFile(deployConfig.CertFile, certBytes, deployCertFilePerm, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if deployConfig.KeyFile != "" {
+		key, err := storeEntry.Key()
+		if err != nil {
+			return err
+		}
+		keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return fmt.Errorf("failed to marshal private key (cause: %w)", err)
+		}
+		err = deploy.WriteFile(deployConfig.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}), deployKeyFilePerm, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if len(deployConfig.Command) > 0 {
+		reissuer.logger.Info().Msgf("Running deploy command '%s' for store entry '%s'...", deployConfig.Command[0], target.Entry)
+		cmd := exec.CommandContext(ctx, deployConfig.Command[0], deployConfig.Command[1:]...)
+		cmd.Env = append(os.Environ(), "CERTD_ENTRY="+target.Entry, "CERTD_CERT_FILE="+deployConfig.CertFile, "CERTD_KEY_FILE="+deployConfig.KeyFile)
+		var output bytes.Buffer
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		err := cmd.Run()
+		if err != nil {
+			return fmt.Errorf("deploy command '%s' failed for store entry '%s' (cause: %w, output: %s)", deployConfig.Command[0], target.Entry, err, output.String())
+		}
+	}
+	return nil
+}
+
+func reissueTemplate(ctx context.Context, certificate *x509.Certificate, now time.Time, notBeforeSkew time.Duration, lifetime time.Duration) (*x509.Certificate, error) {
+	serialNumber, err := entropy.SerialNumber(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number (cause: %w)", err)
+	}
+	return &x509.Certificate{
+		Version:               3,
+		SerialNumber:          serialNumber,
+		RawSubject:            certificate.RawSubject,
+		NotBefore:             now.Add(-notBeforeSkew),
+		NotAfter:              now.Add(lifetime),
+		KeyUsage:              certificate.KeyUsage,
+		ExtKeyUsage:           certificate.ExtKeyUsage,
+		BasicConstraintsValid: certificate.BasicConstraintsValid,
+		DNSNames:              certificate.DNSNames,
+		EmailAddresses:        certificate.EmailAddresses,
+		IPAddresses:           certificate.IPAddresses,
+		URIs:                  certificate.URIs,
+	}, nil
+}
+
+func (reissuer *Reissuer) issuerSigner(issuerEntry certs.StoreEntry, issuer *x509.Certificate) (crypto.Signer, error) {
+	if reissuer.resolveSigner != nil {
+		return reissuer.resolveSigner(issuerEntry, issuer)
+	}
+	key, err := issuerEntry.Key()
+	if err != nil {
+		return nil, err
+	}
+	return certs.KeySigner(key)
+}
+
+func resolveKeyFactory(keyType string, publicKey crypto.PublicKey) (keys.KeyPairFactory, error) {
+	if keyType == "" {
+		return registry.FactoryForPublicKey(publicKey)
+	}
+	return registry.FactoryFor(keyType)
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package reissue
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/notify"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/certs/fsstore"
+	"github.com/hdecarne-github/certd/pkg/certs/local"
+	"github.com/hdecarne-github/certd/pkg/certs/memstore"
+	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReissuer(t *testing.T) {
+	home, err := os.MkdirTemp("", "certd")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	store, err := fsstore.Init(filepath.Join(home, "store"))
+	require.NoError(t, err)
+	defer store.Close()
+	caEntry, err := store.CreateCertificate(context.Background(), "ca", local.NewLocalCertificateFactory(newCATemplate(), ecdsa.StandardKeys()[1], nil, nil))
+	require.NoError(t, err)
+	ca, err := caEntry.Certificate()
+	require.NoError(t, err)
+	caKey, err := caEntry.Key()
+	require.NoError(t, err)
+	_, err = store.CreateCertificate(context.Background(), "client", local.NewLocalCertificateFactory(newClientTemplate(), ecdsa.StandardKeys()[0], ca, caKey.(crypto.Signer)))
+	require.NoError(t, err)
+	deployPath := filepath.Join(home, "deploy")
+	require.NoError(t, os.Mkdir(deployPath, 0700))
+	reissueConfig := &config.ReissueConfig{
+		Targets: []config.ReissueTarget{
+			{
+				Entry:    "client",
+				Issuer:   "ca",
+				Lifetime: 2 * time.Hour,
+				Deploy: config.DeployConfig{
+					CertFile: filepath.Join(deployPath, "client.crt"),
+					KeyFile:  filepath.Join(deployPath, "client.key"),
+				},
+			},
+		},
+	}
+	notifier := &testNotifier{}
+	reissuer := NewReissuer(reissueConfig, local.DefaultNotBeforeSkew, nil, store, notifier)
+	// initial certificate is still valid for 1h (renew before is 40m)
+	reissued, err := reissuer.Reissue(context.Background(), &reissueConfig.Targets[0], time.Now())
+	require.NoError(t, err)
+	require.False(t, reissued)
+	require.NoFileExists(t, reissueConfig.Targets[0].Deploy.CertFile)
+	reissued, err = reissuer.Reissue(context.Background(), &reissueConfig.Targets[0], time.Now().Add(30*time.Minute))
+	require.NoError(t, err)
+	require.True(t, reissued)
+	deployedCertificates, err := certs.ReadCertificates(reissueConfig.Targets[0].Deploy.CertFile)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(deployedCertificates))
+	require.Equal(t, "client", deployedCertificates[0].Subject.CommonName)
+	require.Equal(t, []string{"client.example.org"}, deployedCertificates[0].DNSNames)
+	require.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, deployedCertificates[0].ExtKeyUsage)
+	require.NoError(t, deployedCertificates[0].CheckSignatureFrom(ca))
+	require.Equal(t, 2*time.Hour+local.DefaultNotBeforeSkew, deployedCertificates[0].NotAfter.Sub(deployedCertificates[0].NotBefore))
+	require.FileExists(t, reissueConfig.Targets[0].Deploy.KeyFile)
+	reissuer.Run(context.Background())
+	require.Empty(t, notifier.events)
+	reissueConfig.Targets[0].Deploy.Command = []string{"false"}
+	reissueConfig.Targets[0].RenewBefore = 3 * time.Hour
+	reissuer.Run(context.Background())
+	require.Equal(t, 1, len(notifier.events))
+	require.Equal(t, EventReissueFailure, notifier.events[0].Type)
+	reissueConfig.Targets[0].Deploy.Command = []string{"true"}
+	hooked := make([]string, 0)
+	reissuer.OnReissue(func(name string) {
+		hooked = append(hooked, name)
+	})
+	reissuer.Run(context.Background())
+	require.Equal(t, 2, len(notifier.events))
+	require.Equal(t, EventReissued, notifier.events[1].Type)
+	require.Equal(t, []string{"client"}, hooked)
+}
+
+func TestRenew(t *testing.T) {
+	store := memstore.New("test")
+	caEntry, err := store.CreateCertificate(context.Background(), "ca", local.NewLocalCertificateFactory(newCATemplate(), ecdsa.StandardKeys()[1], nil, nil))
+	require.NoError(t, err)
+	ca, err := caEntry.Certificate()
+	require.NoError(t, err)
+	caKey, err := caEntry.Key()
+	require.NoError(t, err)
+	clientEntry, err := store.CreateCertificate(context.Background(), "client", local.NewLocalCertificateFactory(newClientTemplate(), ecdsa.StandardKeys()[0], ca, caKey.(crypto.Signer)))
+	require.NoError(t, err)
+	client, err := clientEntry.Certificate()
+	require.NoError(t, err)
+	reissuer := NewReissuer(&config.ReissueConfig{}, local.DefaultNotBeforeSkew, nil, store, &testNotifier{})
+	renewedEntry, err := reissuer.Renew(context.Background(), "client", "ca", time.Now())
+	require.NoError(t, err)
+	renewed, err := renewedEntry.Certificate()
+	require.NoError(t, err)
+	require.NotEqual(t, client.SerialNumber, renewed.SerialNumber)
+	require.Equal(t, client.NotAfter.Sub(client.NotBefore), renewed.NotAfter.Sub(renewed.NotBefore))
+	require.Equal(t, client.PublicKeyAlgorithm, renewed.PublicKeyAlgorithm)
+	require.NoError(t, renewed.CheckSignatureFrom(ca))
+	_, err = reissuer.Renew(context.Background(), "client", "unknown", time.Now())
+	require.Error(t, err)
+}
+
+func TestRenewWithSignerResolver(t *testing.T) {
+	// the CA key is only available outside the store (like a HSM held key)
+	caStore := memstore.New("ca")
+	caEntry, err := caStore.CreateCertificate(context.Background(), "ca", local.NewLocalCertificateFactory(newCATemplate(), ecdsa.StandardKeys()[1], nil, nil))
+	require.NoError(t, err)
+	ca, err := caEntry.Certificate()
+	require.NoError(t, err)
+	caKey, err := caEntry.Key()
+	require.NoError(t, err)
+	store := memstore.New("test")
+	require.NoError(t, store.Add("ca", nil, ca, nil))
+	_, err = store.CreateCertificate(context.Background(), "client", local.NewLocalCertificateFactory(newClientTemplate(), ecdsa.StandardKeys()[0], ca, caKey.(crypto.Signer)))
+	require.NoError(t, err)
+	reissuer := NewReissuer(&config.ReissueConfig{}, local.DefaultNotBeforeSkew, nil, store, &testNotifier{})
+	_, err = reissuer.Renew(context.Background(), "client", "ca", time.Now())
+	require.Error(t, err)
+	reissuer.ResolveSigner(func(storeEntry certs.StoreEntry, certificate *x509.Certificate) (crypto.Signer, error) {
+		require.Equal(t, "ca", storeEntry.Name())
+		return caKey.(crypto.Signer), nil
+	})
+	renewedEntry, err := reissuer.Renew(context.Background(), "client", "ca", time.Now())
+	require.NoError(t, err)
+	renewed, err := renewedEntry.Certificate()
+	require.NoError(t, err)
+	require.NoError(t, renewed.CheckSignatureFrom(ca))
+}
+
+func newCATemplate() *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+}
+
+func newClientTemplate() *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"client.example.org"},
+	}
+}
+
+type testNotifier struct {
+	events []*notify.Event
+}
+
+func (notifier *testNotifier) Notify(event *notify.Event) error {
+	notifier.events = append(notifier.events, event)
+	return nil
+}
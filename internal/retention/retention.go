@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package retention
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/audit"
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/clock"
+	"github.com/rs/zerolog"
+)
+
+// Store holding the archived entries to evaluate.
+type Store interface {
+	ArchivedEntries() certs.StoreEntries
+	PurgeEntry(name string) error
+}
+
+// Result of the retention evaluation of a single archived entry.
+type Result struct {
+	Entry  string
+	Purge  bool
+	Purged bool
+	Reason string
+}
+
+type Enforcer struct {
+	config *config.RetentionConfig
+	store  Store
+	logger *zerolog.Logger
+}
+
+func NewEnforcer(config *config.RetentionConfig, store Store) *Enforcer {
+	logger := logging.RootLogger().With().Str("retention", "archive").Logger()
+	return &Enforcer{
+		config: config,
+		store:  store,
+		logger: &logger,
+	}
+}
+
+// Evaluate the retention rules against all archived entries and purge the ones due.
+func (enforcer *Enforcer) Run(ctx context.Context) {
+	enforcer.Enforce(ctx, clock.FromContext(ctx).Now())
+}
+
+// Evaluate the retention rules against all archived entries at the given time.
+//
+// Entries due for purging are purged unless dry-run mode is enabled. In both cases the outcome is recorded in the
+// audit log.
+func (enforcer *Enforcer) Enforce(ctx context.Context, now time.Time) []Result {
+	results := make([]Result, 0)
+	archivedEntries := enforcer.store.ArchivedEntries()
+	for {
+		if ctx.Err() != nil {
+			break
+		}
+		archivedEntry := archivedEntries.Next()
+		if archivedEntry == nil {
+			break
+		}
+		result := enforcer.evaluate(archivedEntry, now)
+		if result.Purge {
+			if enforcer.config.DryRun {
+				audit.Record("", audit.EventPurgeCandidate, result.Entry, nil)
+			} else {
+				err := enforcer.store.PurgeEntry(result.Entry)
+				if err != nil {
+					enforcer.logger.Error().Err(err).Msgf("Failed to purge archived store entry '%s'", result.Entry)
+					result.Reason = err.Error()
+				} else {
+					result.Purged = true
+					audit.Record("", audit.EventPurged, result.Entry, nil)
+				}
+			}
+		}
+		enforcer.logger.Debug().Msgf("Retention of archived store entry '%s' evaluated (purge: %t; %s)", result.Entry, result.Purge, result.Reason)
+		results = append(results, result)
+	}
+	return results
+}
+
+func (enforcer *Enforcer) evaluate(archivedEntry certs.StoreEntry, now time.Time) Result {
+	result := Result{Entry: archivedEntry.Name()}
+	attributes, err := archivedEntry.Attributes()
+	if err != nil {
+		result.Reason = fmt.Sprintf("failed to access attributes (cause: %v)", err)
+		return result
+	}
+	var archived time.Time
+	if attributes.Archived != nil {
+		archived = *attributes.Archived
+	}
+	// entries without certificate (i.e. pending requests) expire with their archival
+	ca := false
+	expiry := archived
+	if archivedEntry.HasCertificate() {
+		certificate, err := archivedEntry.Certificate()
+		if err != nil {
+			result.Reason = fmt.Sprintf("failed to access certificate (cause: %v)", err)
+			return result
+		}
+		ca = certificate.IsCA
+		expiry = certificate.NotAfter
+	}
+	rule := enforcer.config.MatchRule(ca)
+	if rule == nil {
+		result.Reason = "no matching retention rule"
+		return result
+	}
+	if rule.Retain {
+		result.Reason = "retained by rule"
+		return result
+	}
+	retainedUntil := expiry.Add(rule.PurgeAfterExpiry)
+	if now.Before(retainedUntil) {
+		result.Reason = fmt.Sprintf("retained until %s", retainedUntil.Format(time.RFC3339))
+		return result
+	}
+	err = enforcer.config.CheckPurge(archived, ca, now)
+	if err != nil {
+		result.Reason = err.Error()
+		return result
+	}
+	result.Purge = true
+	result.Reason = fmt.Sprintf("expired since %s", expiry.Format(time.RFC3339))
+	return result
+}
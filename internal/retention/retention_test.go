@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package retention
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/pkg/certs/fsstore"
+	"github.com/hdecarne-github/certd/pkg/certs/local"
+	"github.com/hdecarne-github/certd/pkg/keys/ed25519"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnforcer(t *testing.T) {
+	home, err := os.MkdirTemp("", "certd")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	store, err := fsstore.Init(filepath.Join(home, "store"))
+	require.NoError(t, err)
+	defer store.Close()
+	for _, name := range []string{"ca", "leaf"} {
+		_, err = store.CreateCertificate(context.Background(), name, local.NewLocalCertificateFactory(newTemplate(name, name == "ca"), ed25519.StandardKeys()[0], nil, nil))
+		require.NoError(t, err)
+		require.NoError(t, store.ArchiveEntry(name))
+	}
+	retentionConfig := &config.RetentionConfig{
+		MinArchiveAge: time.Hour,
+		DryRun:        true,
+		Rules: []config.RetentionRule{
+			{Kind: config.RetentionKindCA, Retain: true},
+			{Kind: config.RetentionKindLeaf, PurgeAfterExpiry: 24 * time.Hour},
+		},
+	}
+	enforcer := NewEnforcer(retentionConfig, store)
+	results := enforcer.Enforce(context.Background(), time.Now())
+	require.Equal(t, 2, len(results))
+	require.False(t, results[0].Purge)
+	require.False(t, results[1].Purge)
+	results = enforcer.Enforce(context.Background(), time.Now().Add(48*time.Hour))
+	require.False(t, results[0].Purge)
+	require.True(t, results[1].Purge)
+	require.False(t, results[1].Purged)
+	_, err = store.ArchivedEntry("leaf")
+	require.NoError(t, err)
+	retentionConfig.DryRun = false
+	results = enforcer.Enforce(context.Background(), time.Now().Add(48*time.Hour))
+	require.True(t, results[1].Purged)
+	_, err = store.ArchivedEntry("leaf")
+	require.Error(t, err)
+	_, err = store.ArchivedEntry("ca")
+	require.NoError(t, err)
+}
+
+func newTemplate(name string, ca bool) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+}
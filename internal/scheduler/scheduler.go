@@ -0,0 +1,99 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/rs/zerolog"
+)
+
+// Job functions are invoked periodically by the scheduler.
+type Job func(ctx context.Context)
+
+type Scheduler struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   sync.WaitGroup
+	logger *zerolog.Logger
+}
+
+// Create a new scheduler running its jobs with a context derived from the given one.
+//
+// Values bound to the given context (e.g. the clock or the random source) are therefore visible to all jobs.
+func NewScheduler(ctx context.Context) *Scheduler {
+	ctx, cancel := context.WithCancel(ctx)
+	logger := logging.RootLogger().With().Str("scheduler", "").Logger()
+	return &Scheduler{
+		ctx:    ctx,
+		cancel: cancel,
+		logger: &logger,
+	}
+}
+
+// Schedule a job for periodic execution.
+//
+// The job is run once immediately and afterwards every interval until the scheduler is stopped.
+func (scheduler *Scheduler) Schedule(name string, interval time.Duration, job Job) {
+	scheduler.logger.Info().Msgf("Scheduling job '%s' (interval: %s)...", name, interval)
+	scheduler.jobs.Add(1)
+	go func() {
+		defer scheduler.jobs.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			scheduler.logger.Debug().Msgf("Running job '%s'...", name)
+			job(scheduler.ctx)
+			select {
+			case <-scheduler.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Schedule a job for a single execution at the given time.
+//
+// The job is dropped if the scheduler is stopped before the given time.
+func (scheduler *Scheduler) ScheduleOnce(name string, at time.Time, job Job) {
+	scheduler.logger.Info().Msgf("Scheduling job '%s' (at: %s)...", name, at.Format(time.RFC3339))
+	scheduler.jobs.Add(1)
+	go func() {
+		defer scheduler.jobs.Done()
+		timer := time.NewTimer(time.Until(at))
+		defer timer.Stop()
+		select {
+		case <-scheduler.ctx.Done():
+			return
+		case <-timer.C:
+		}
+		scheduler.logger.Debug().Msgf("Running job '%s'...", name)
+		job(scheduler.ctx)
+	}()
+}
+
+// Stop the scheduler and wait for all running jobs to finish.
+func (scheduler *Scheduler) Stop() {
+	scheduler.logger.Info().Msg("Stopping scheduler...")
+	scheduler.cancel()
+	scheduler.jobs.Wait()
+}
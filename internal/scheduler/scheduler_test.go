@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package scheduler
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler(t *testing.T) {
+	scheduler := NewScheduler(context.Background())
+	var runs int32
+	scheduler.Schedule("test", 10*time.Millisecond, func(ctx context.Context) {
+		atomic.AddInt32(&runs, 1)
+	})
+	time.Sleep(100 * time.Millisecond)
+	scheduler.Stop()
+	stoppedRuns := atomic.LoadInt32(&runs)
+	require.Greater(t, stoppedRuns, int32(1))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, stoppedRuns, atomic.LoadInt32(&runs))
+}
+
+func TestSchedulerOnce(t *testing.T) {
+	scheduler := NewScheduler(context.Background())
+	var runs int32
+	scheduler.ScheduleOnce("once", time.Now().Add(10*time.Millisecond), func(ctx context.Context) {
+		atomic.AddInt32(&runs, 1)
+	})
+	scheduler.ScheduleOnce("dropped", time.Now().Add(time.Hour), func(ctx context.Context) {
+		atomic.AddInt32(&runs, 1)
+	})
+	time.Sleep(100 * time.Millisecond)
+	scheduler.Stop()
+	require.Equal(t, int32(1), atomic.LoadInt32(&runs))
+}
@@ -19,22 +19,43 @@ package server
 
 import (
 	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
 	"embed"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/ctmonitor"
 	"github.com/hdecarne-github/certd/internal/ginextra"
 	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/internal/notify"
+	"github.com/hdecarne-github/certd/internal/reissue"
+	"github.com/hdecarne-github/certd/internal/retention"
+	"github.com/hdecarne-github/certd/internal/scheduler"
 	"github.com/hdecarne-github/certd/internal/state"
+	"github.com/hdecarne-github/certd/internal/tlscheck"
+	"github.com/hdecarne-github/certd/internal/trust"
+	"github.com/hdecarne-github/certd/internal/workpool"
+	"github.com/hdecarne-github/certd/pkg/certs"
 	"github.com/hdecarne-github/certd/pkg/certs/fsstore"
+	"github.com/hdecarne-github/certd/pkg/clock"
+	"github.com/hdecarne-github/certd/pkg/entropy"
+	"github.com/hdecarne-github/certd/pkg/keys"
+	"github.com/jellydator/ttlcache/v3"
 	"github.com/rs/zerolog"
 )
 
@@ -46,49 +67,125 @@ func htdocsFS() (fs.FS, error) {
 }
 
 func Run(config *config.ServerConfig) error {
-	logger := logging.RootLogger().With().Str("server", config.ServerURL).Logger()
+	logger := logging.ModuleLogger(logging.ModuleServer).With().Str("server", config.ServerURL).Logger()
+	runtime, err := newServerRuntime(config)
+	if err != nil {
+		return err
+	}
 	s := &server{
-		config: config,
-		logger: &logger,
+		started: time.Now(),
+		logger:  &logger,
 	}
+	s.runtime.Store(runtime)
 	return s.Run()
 }
 
+// Store defines the store operations used by the server.
+//
+// Run always uses a FS store. The in-memory store is used by TestServer if no store path is configured. Features
+// only available for FS stores (diagnostics and garbage collection) are reported as not supported otherwise.
+type Store interface {
+	certs.Store
+	CreateCertificate(ctx context.Context, name string, factory certs.CertificateFactory) (certs.StoreEntry, error)
+	CreateCertificateWithoutKey(ctx context.Context, name string, factory certs.CertificateFactory) (certs.StoreEntry, crypto.PrivateKey, error)
+	ReplaceCertificate(ctx context.Context, name string, factory certs.CertificateFactory) (certs.StoreEntry, error)
+	CreateCertificateRequest(ctx context.Context, name string, factory certs.CertificateRequestFactory) (certs.StoreEntry, error)
+	UpdateRevocationList(name string, revocationList *x509.RevocationList) error
+	UpdateAttributes(name string, update func(attributes *certs.StoreEntryAttributes)) error
+	ArchivedEntries() certs.StoreEntries
+	ArchivedEntry(name string) (certs.StoreEntry, error)
+	ArchiveEntry(name string) error
+	RestoreEntry(name string) error
+	PurgeEntry(name string) error
+	Expiries(from time.Time, until time.Time) []certs.StoreExpiry
+	NextExpiry(after time.Time) *certs.StoreExpiry
+	UpdateTrust(source string, certificates []*x509.Certificate) error
+	Trust() (map[string][]*x509.Certificate, error)
+	DeriveKey(purpose string, length int) ([]byte, error)
+	Generation() string
+	Close() error
+}
+
 type server struct {
-	config *config.ServerConfig
-	store  *fsstore.FSStore
-	logger *zerolog.Logger
+	started        time.Time
+	runtime        atomic.Pointer[serverRuntime]
+	reloadLock     sync.Mutex
+	store          Store
+	stateHandler   state.Handler
+	clock          clock.Clock
+	random         io.Reader
+	keyLimiter     keys.Limiter
+	notifier       notify.Notifier
+	scheduler      *scheduler.Scheduler
+	ctMonitor      *ctmonitor.Monitor
+	ocspStaples    *ttlcache.Cache[string, []byte]
+	downloadsLock  sync.Mutex
+	jobs           *ttlcache.Cache[string, *adminJob]
+	jobWorkers     *workpool.Pool
+	tlsCertificate atomic.Pointer[tls.Certificate]
+	sigint         chan os.Signal
+	logger         *zerolog.Logger
 }
 
 func (s *server) Run() error {
 	s.logger.Info().Msg("Starting server...")
-	state.UpdateHandler(state.NewFSHandler(s.config.ResolveStatePath()))
-	err := s.prepareStore()
+	release, err := s.prepare()
 	if err != nil {
 		return err
 	}
-	_, listen, prefix, err := s.splitServerURL()
+	defer release()
+	useTLS, listen, prefix, err := s.splitServerURL()
 	if err != nil {
 		return err
 	}
+	var tlsConfig *tls.Config
+	if useTLS {
+		tlsConfig, err = s.tlsConfig()
+		if err != nil {
+			return err
+		}
+	}
 	router, err := s.setupRouter(prefix)
 	if err != nil {
 		return err
 	}
-	sigint := make(chan os.Signal, 1)
-	signal.Notify(sigint, os.Interrupt)
+	s.sigint = make(chan os.Signal, 1)
+	signal.Notify(s.sigint, os.Interrupt)
+	defer signal.Stop(s.sigint)
 	sigintCtx, cancelListenAndServe := context.WithCancel(context.Background())
 	go func() {
-		<-sigint
+		<-s.sigint
 		s.logger.Info().Msg("SIGINT received; stopping server...")
 		cancelListenAndServe()
 	}()
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+	go func() {
+		for {
+			select {
+			case <-sighup:
+				s.logger.Info().Msg("SIGHUP received; reloading configuration...")
+				s.reloadAndLog()
+			case <-sigintCtx.Done():
+				return
+			}
+		}
+	}()
 	httpServer := &http.Server{
-		Addr:    listen,
-		Handler: router,
+		Addr:      listen,
+		Handler:   router,
+		TLSConfig: tlsConfig,
+		// Request contexts end with the server, aborting pending issuances on shutdown.
+		BaseContext: func(net.Listener) context.Context { return sigintCtx },
 	}
 	go func() {
-		err := httpServer.ListenAndServe()
+		var err error
+		if tlsConfig != nil {
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
 		if err != http.ErrServerClosed {
 			s.logger.Error().Err(err).Msgf("Server failure: %v", err)
 		}
@@ -106,26 +203,212 @@ func (s *server) Run() error {
 	return nil
 }
 
+// Prepare store, state and background services.
+//
+// The returned function stops the background services and closes the store again.
+func (s *server) prepare() (func(), error) {
+	if s.clock == nil {
+		s.clock = s.config().Testing.Clock()
+	}
+	if s.clock != clock.System {
+		s.logger.Warn().Msg("Fixed testing clock enabled; never use in production")
+	}
+	workersConfig := &s.config().Workers
+	if s.keyLimiter == nil {
+		keyWorkers := workpool.New("key_generation", workersConfig.KeyGeneration)
+		s.logger.Info().Msgf("Limiting key generations to %d concurrent workers", keyWorkers.Size())
+		s.keyLimiter = keyWorkers
+	}
+	s.jobWorkers = workpool.New("jobs", workersConfig.Jobs)
+	s.logger.Info().Msgf("Limiting jobs to %d concurrent workers", s.jobWorkers.Size())
+	err := s.applyUmask()
+	if err != nil {
+		return nil, err
+	}
+	// the store may have been set up already (see TestServer)
+	if s.store == nil {
+		err = s.prepareStore()
+		if err != nil {
+			return nil, err
+		}
+	}
+	ctx := s.bindContext(context.Background())
+	err = s.bootstrapStore(ctx)
+	if err == nil {
+		err = s.prepareState()
+	}
+	if err != nil {
+		s.store.Close()
+		return nil, err
+	}
+	s.notifier = notify.NewNotifier(&s.config().Notify)
+	s.ocspStaples = ttlcache.New(ocspStapleCacheOptions...)
+	s.jobs = ttlcache.New(jobCacheOptions...)
+	s.scheduler = scheduler.NewScheduler(ctx)
+	s.scheduleJobs()
+	return func() {
+		s.scheduler.Stop()
+		s.store.Close()
+	}, nil
+}
+
+// Bind the server's clock, random source and key generation limiter to the given context.
+//
+// All work done on behalf of the server (request handling, scheduled jobs and bootstrapping) runs with a bound
+// context, hence multiple server instances (e.g. parallel tests) do not interfere with each other.
+func (s *server) bindContext(ctx context.Context) context.Context {
+	ctx = clock.WithClock(ctx, s.clock)
+	ctx = keys.WithLimiter(ctx, s.keyLimiter)
+	if s.random != nil {
+		ctx = entropy.WithReader(ctx, s.random)
+	}
+	return ctx
+}
+
+// Middleware binding the request context (see bindContext).
+func (s *server) bindRequestContext(c *gin.Context) {
+	c.Request = c.Request.WithContext(s.bindContext(c.Request.Context()))
+	c.Next()
+}
+
 func (s *server) prepareStore() error {
-	storePath := s.config.ResolveStorePath()
-	_, err := os.Stat(storePath)
+	permissionPolicy, err := fsstore.ParsePermissionPolicy(s.config().StorePerms)
+	if err != nil {
+		return err
+	}
+	options := []fsstore.Option{fsstore.WithPermissionPolicy(permissionPolicy), fsstore.WithClock(s.clock)}
+	switch s.config().StoreLock {
+	case "", "refuse":
+	case "readonly":
+		options = append(options, fsstore.WithReadOnlyFallback())
+	default:
+		return fmt.Errorf("unrecognized store lock mode '%s'", s.config().StoreLock)
+	}
+	switch s.config().StoreCrypt {
+	case "", "keys":
+	case "entries":
+		options = append(options, fsstore.WithEntryEncryption())
+	default:
+		return fmt.Errorf("unrecognized store encryption mode '%s'", s.config().StoreCrypt)
+	}
+	if s.config().ForceUnlock {
+		options = append(options, fsstore.WithForceUnlock())
+	}
+	if s.config().StoreCache.Capacity > 0 {
+		options = append(options, fsstore.WithCacheCapacity(s.config().StoreCache.Capacity))
+	}
+	if s.config().StoreCache.Warm {
+		options = append(options, fsstore.WithCacheWarming())
+	}
+	if s.config().State.Encrypt {
+		// state encrypted with a store derived key has to be re-encrypted when completing a secret rotation
+		s.stateHandler, err = state.NewHandler(&s.config().State, s.config().ResolveStatePath())
+		if err != nil {
+			return err
+		}
+		options = append(options, fsstore.WithSecretRotationHook(state.NewRotation(s.stateHandler)))
+	}
+	storePath := s.config().ResolveStorePath()
+	_, err = os.Stat(storePath)
 	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return err
 	}
 	s.logger.Info().Msgf("Preparing store '%s'...", storePath)
+	var store *fsstore.FSStore
 	if err != nil {
-		s.store, err = fsstore.Init(storePath)
+		store, err = fsstore.Init(storePath, options...)
 	} else {
-		s.store, err = fsstore.Open(storePath)
+		store, err = fsstore.Open(storePath, options...)
+	}
+	if err != nil {
+		return err
+	}
+	s.store = store
+	return nil
+}
+
+func (s *server) prepareState() error {
+	handler := s.stateHandler
+	if handler == nil {
+		var err error
+		handler, err = state.NewHandler(&s.config().State, s.config().ResolveStatePath())
+		if err != nil {
+			return err
+		}
+	}
+	if s.config().State.Encrypt {
+		key, err := s.store.DeriveKey(state.KeyPurpose, state.KeyLength)
+		if err != nil {
+			return err
+		}
+		handler, err = state.NewEncryptedHandler(handler, key)
+		if err != nil {
+			return err
+		}
+	}
+	state.UpdateHandler(handler)
+	return nil
+}
+
+// Schedule the periodic jobs.
+//
+// Jobs are set up using the configuration active during server start. Configuration reloads do not affect them.
+func (s *server) scheduleJobs() {
+	serverConfig := s.config()
+	if serverConfig.ConfigWatch > 0 {
+		s.scheduler.Schedule("config_watch", serverConfig.ConfigWatch, s.configWatcher())
+	}
+	if len(serverConfig.TLSChecks.Targets) > 0 {
+		tlsChecker := tlscheck.NewChecker(&serverConfig.TLSChecks, s.store, s.notifier)
+		s.scheduler.Schedule("tls_check", serverConfig.TLSChecks.Interval, tlsChecker.Run)
+	}
+	if len(serverConfig.Trust.Sources) > 0 {
+		trustRefresher := trust.NewRefresher(&serverConfig.Trust, s.store)
+		s.scheduler.Schedule("trust_refresh", serverConfig.Trust.Interval, trustRefresher.Run)
+	}
+	if len(serverConfig.CTMonitor.Domains) > 0 {
+		s.ctMonitor = ctmonitor.NewMonitor(&serverConfig.CTMonitor, s.store, s.notifier)
+		s.scheduler.Schedule("ct_monitor", serverConfig.CTMonitor.Interval, s.ctMonitor.Run)
+	}
+	if len(serverConfig.Reissue.Targets) > 0 {
+		reissuer := reissue.NewReissuer(&serverConfig.Reissue, serverConfig.Local.NotBeforeSkew, serverConfig.KeyPolicy.Policy(), s.store, s.notifier)
+		reissuer.ResolveSigner(s.entrySigner)
+		reissuer.OnReissue(s.publishEntry)
+		s.scheduler.Schedule("reissue", serverConfig.Reissue.Interval, reissuer.Run)
+	}
+	if len(serverConfig.Retention.Rules) > 0 {
+		enforcer := retention.NewEnforcer(&serverConfig.Retention, s.store)
+		s.scheduler.Schedule("retention", serverConfig.Retention.Interval, enforcer.Run)
+	}
+	acmeRenewal := &s.acmeConfig().Renewal
+	if acmeRenewal.Enabled {
+		s.scheduler.Schedule("acme_renewal", acmeRenewal.Interval, s.acmeRenewer())
 	}
-	return err
+}
+
+func (s *server) requestLogger(c *gin.Context) *zerolog.Logger {
+	return ginextra.RequestLogger(c, s.logger)
+}
+
+// Get the store to use for processing the given request (tagging all FS store log entries with the request ID).
+func (s *server) requestStore(c *gin.Context) Store {
+	requestID := ginextra.RequestID(c)
+	fsStore, ok := s.store.(*fsstore.FSStore)
+	if requestID == "" || !ok {
+		return s.store
+	}
+	return fsStore.WithCorrelationID(requestID)
 }
 
 const httpPrefix = "http://"
 const httpsPrefix = "https://"
 
 func (s *server) splitServerURL() (bool, string, string, error) {
-	remaining := s.config.ServerURL
+	return splitServerURL(s.config().ServerURL)
+}
+
+func splitServerURL(serverURL string) (bool, string, string, error) {
+	remaining := serverURL
 	var tls bool
 	if strings.HasPrefix(remaining, httpPrefix) {
 		tls = false
@@ -134,7 +417,7 @@ func (s *server) splitServerURL() (bool, string, string, error) {
 		tls = true
 		remaining = strings.TrimPrefix(remaining, httpsPrefix)
 	} else {
-		return false, "", "", fmt.Errorf("invalid server URL '%s'; unrecognized protocol", s.config.ServerURL)
+		return false, "", "", fmt.Errorf("invalid server URL '%s'; unrecognized protocol", serverURL)
 	}
 	remainings := strings.SplitN(remaining, "/", 2)
 	listen := remainings[0]
@@ -149,7 +432,9 @@ func (s *server) splitServerURL() (bool, string, string, error) {
 func (s *server) setupRouter(prefix string) (*gin.Engine, error) {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	router.Use(ginextra.Logger(s.logger), gin.Recovery())
+	// match the escaped path to support entry names containing slashes
+	router.UseRawPath = true
+	router.Use(ginextra.Logger(s.logger), gin.Recovery(), ginextra.Gzip(prefix+"/api/"), s.bindRequestContext)
 	htdocs, err := htdocsFS()
 	if err != nil {
 		return nil, fmt.Errorf("unexpected error: %w", err)
@@ -157,12 +442,55 @@ func (s *server) setupRouter(prefix string) (*gin.Engine, error) {
 	router.GET(prefix+"/api/shutdown", s.shutdown)
 	router.GET(prefix+"/api/about", s.about)
 	router.GET(prefix+"/api/store/entries", s.storeEntries)
+	router.GET(prefix+"/api/store/stats", s.storeStats)
 	router.GET(prefix+"/api/store/entry/details/:name", s.storeEntryDetails)
+	router.PUT(prefix+"/api/store/entry/export/:name", s.storeEntryExport)
+	router.PUT(prefix+"/api/store/entry/labels/:name", s.storeEntryLabels)
+	router.POST(prefix+"/api/store/entry/notes/:name", s.storeEntryNotes)
+	router.POST(prefix+"/api/store/entry/download-url/:name", s.storeEntryDownloadURL)
+	router.POST(prefix+"/api/store/entry/archive/:name", s.storeEntryArchive)
+	router.PUT(prefix+"/api/store/entry/sign/:name", s.storeEntrySign)
+	router.GET(prefix+"/api/store/entry/p7b/:name", s.storeEntryP7B)
+	router.GET(prefix+"/api/store/entry/ocsp-staple/:name", s.storeEntryOCSPStaple)
+	router.GET(prefix+"/api/store/entry/text/:name", s.storeEntryText)
+	router.GET(prefix+"/api/store/entry/spki/:name", s.storeEntrySPKI)
+	router.GET(prefix+"/api/store/entry/compare", s.storeEntryCompare)
+	router.PUT(prefix+"/api/store/p7b/import", s.storeP7BImport)
+	router.POST(prefix+"/api/store/export", s.storeExport)
+	router.GET(prefix+"/api/store/archive", s.storeArchive)
+	router.POST(prefix+"/api/store/archive/restore/:name", s.storeArchiveRestore)
+	router.DELETE(prefix+"/api/store/archive/:name", s.storeArchivePurge)
 	router.GET(prefix+"/api/store/cas", s.storeCAs)
+	router.PUT(prefix+"/api/store/generate", s.storeGenerate)
 	router.GET(prefix+"/api/store/local/issuers", s.storeLocalIssuers)
+	router.GET(prefix+"/api/store/local/issuers/:name", s.storeLocalIssuer)
 	router.PUT(prefix+"/api/store/local/generate", s.storeLocalGenerate)
+	router.PUT(prefix+"/api/store/local/sign", s.storeLocalSign)
 	router.PUT(prefix+"/api/store/remote/generate", s.storeRemoteGenerate)
 	router.PUT(prefix+"/api/store/acme/generate", s.storeACMEGenerate)
+	router.GET(prefix+"/api/store/acme/providers", s.storeACMEProviders)
+	router.GET(prefix+"/api/store/trust", s.storeTrust)
+	router.PUT(prefix+"/api/store/trust/import", s.storeTrustImport)
+	router.POST(prefix+"/api/verify", s.verify)
+	router.POST(prefix+"/api/diff", s.diff)
+	router.GET(prefix+"/api/oids/:oid", s.oid)
+	router.GET(prefix+"/api/ct/findings", s.ctFindings)
+	router.GET(prefix+"/metrics", s.metrics)
+	router.POST(prefix+"/tsa", s.tsa)
+	router.GET(prefix+"/download/:name/:format", s.download)
+	adminAuth := ginextra.AdminAuth(s.adminToken)
+	router.GET(prefix+"/api/admin/diag", adminAuth, s.adminDiag)
+	router.GET(prefix+"/api/admin/store/gc", adminAuth, s.adminStoreGC)
+	router.POST(prefix+"/api/admin/store/gc", adminAuth, s.adminStoreGC)
+	router.POST(prefix+"/api/admin/store/renew", adminAuth, s.adminStoreRenew)
+	router.POST(prefix+"/api/admin/store/revoke", adminAuth, s.adminStoreRevoke)
+	router.POST(prefix+"/api/admin/store/compromise/:name", adminAuth, s.adminStoreCompromise)
+	router.GET(prefix+"/api/admin/jobs/:id", adminAuth, s.adminJob)
+	router.POST(prefix+"/api/admin/acme/rollover/:ca", adminAuth, s.adminACMERollover)
+	router.POST(prefix+"/api/admin/acme/deactivate/:ca", adminAuth, s.adminACMEDeactivate)
+	if s.config().Admin.PProf {
+		router.Any(prefix+"/debug/pprof/*name", adminAuth, s.pprof)
+	}
 	router.NoRoute(ginextra.StaticFS(prefix, http.FS(htdocs)))
 	return router, nil
 }
@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/pkg/certs/acme"
+)
+
+const defaultACMEAccountKeyType = "ECDSA P-256"
+
+func (s *server) adminACMERollover(c *gin.Context) {
+	rolloverRequest := &AdminACMERolloverRequest{}
+	err := json.NewDecoder(c.Request.Body).Decode(rolloverRequest)
+	if err != nil && err != io.EOF {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	keyType := rolloverRequest.KeyType
+	if keyType == "" {
+		keyType = defaultACMEAccountKeyType
+	}
+	keyFactory, err := s.getKeyFactory(keyType, true)
+	if err != nil {
+		s.abortKeyTypeError(c, err)
+		return
+	}
+	acmeConfig, acmeProvider := s.adminACMEProvider(c)
+	if acmeConfig == nil {
+		return
+	}
+	err = acme.RolloverAccountKey(c.Request.Context(), acmeConfig, acmeProvider, keyFactory)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	s.requestLogger(c).Warn().Msgf("Rolled over ACME account key for provider '%s'", acmeProvider)
+	c.Status(http.StatusOK)
+}
+
+func (s *server) adminACMEDeactivate(c *gin.Context) {
+	acmeConfig, acmeProvider := s.adminACMEProvider(c)
+	if acmeConfig == nil {
+		return
+	}
+	err := acme.DeactivateAccount(acmeConfig, acmeProvider)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	s.requestLogger(c).Warn().Msgf("Deactivated ACME account for provider '%s'", acmeProvider)
+	c.Status(http.StatusOK)
+}
+
+func (s *server) adminACMEProvider(c *gin.Context) (*acme.Config, string) {
+	acmeConfig := s.acmeConfig()
+	acmeProvider, err := s.getACMEProvider(c.Param("ca"))
+	if err == nil {
+		_, found := acmeConfig.Providers[acmeProvider]
+		if found {
+			return acmeConfig, acmeProvider
+		}
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidACMECA})
+	return nil, ""
+}
+
+func (s *server) storeACMEProviders(c *gin.Context) {
+	providers := make([]StoreACMEProviderResponse, 0)
+	for _, status := range acme.ProbeProviders(s.acmeConfig()) {
+		providers = append(providers, StoreACMEProviderResponse{
+			Name:           acme.ProviderPrefix + status.Name,
+			DirectoryURL:   status.DirectoryURL,
+			Reachable:      status.Reachable,
+			Error:          status.Error,
+			TermsOfService: status.TermsOfService,
+			Registered:     status.Registered,
+			AccountURL:     status.AccountURL,
+		})
+	}
+	c.JSON(http.StatusOK, &StoreACMEProvidersResponse{Providers: providers})
+}
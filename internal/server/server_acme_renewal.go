@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/certs/acme"
+	"github.com/hdecarne-github/certd/pkg/keys/registry"
+)
+
+// Get the job renewing all ACME issued store entries due for renewal.
+//
+// If enabled and offered by the CA, the renewal window is queried via ACME Renewal Information (ARI). Otherwise the
+// configured renew before threshold is applied.
+func (s *server) acmeRenewer() func(ctx context.Context) {
+	nextQueries := make(map[string]time.Time)
+	return func(ctx context.Context) {
+		acmeConfig := s.acmeConfig()
+		if !acmeConfig.Renewal.Enabled {
+			return
+		}
+		now := s.clock.Now()
+		storeEntries := s.store.Entries()
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+			storeEntry := storeEntries.Next()
+			if storeEntry == nil {
+				break
+			}
+			providerName, certificate := s.acmeRenewalCandidate(storeEntry)
+			if certificate == nil {
+				continue
+			}
+			name := storeEntry.Name()
+			renewalTime := certificate.NotAfter.Add(-acmeConfig.Renewal.RenewBefore)
+			if acmeConfig.Renewal.ARI && now.After(nextQueries[name]) {
+				info, err := acme.FetchRenewalInfo(acmeConfig, providerName, certificate)
+				if err == nil {
+					renewalTime = info.RenewalTime(certificate)
+					if !info.RetryAfter.IsZero() {
+						nextQueries[name] = info.RetryAfter
+					}
+					if info.ExplanationURL != "" && now.After(renewalTime) {
+						s.logger.Info().Msgf("ACME CA suggests renewal of '%s' (explanation: %s)", name, info.ExplanationURL)
+					}
+				} else if !errors.Is(err, acme.ErrRenewalInfoUnsupported) {
+					s.logger.Warn().Err(err).Msgf("Failed to query renewal information of '%s'; using renew before threshold", name)
+				}
+			}
+			if now.Before(renewalTime) {
+				continue
+			}
+			err := s.renewACMEEntry(ctx, name, providerName, certificate)
+			if err != nil {
+				s.logger.Error().Err(err).Msgf("ACME renewal of '%s' failed (cause: %v)", name, err)
+				continue
+			}
+			delete(nextQueries, name)
+			s.publishEntry(name)
+		}
+	}
+}
+
+func (s *server) acmeRenewalCandidate(storeEntry certs.StoreEntry) (string, *x509.Certificate) {
+	if !storeEntry.HasCertificate() || !storeEntry.HasKey() {
+		return "", nil
+	}
+	attributes, err := storeEntry.Attributes()
+	if err != nil || attributes.Archived != nil {
+		return "", nil
+	}
+	providerName, found := strings.CutPrefix(attributes.Provider, acme.ProviderPrefix)
+	if !found {
+		return "", nil
+	}
+	certificate, err := storeEntry.Certificate()
+	if err != nil {
+		s.logger.Warn().Err(err).Msgf("Ignoring broken certificate of store entry '%s'", storeEntry.Name())
+		return "", nil
+	}
+	return providerName, certificate
+}
+
+func (s *server) renewACMEEntry(ctx context.Context, name string, providerName string, certificate *x509.Certificate) error {
+	s.logger.Info().Msgf("Renewing ACME certificate of store entry '%s'...", name)
+	keyFactory, err := registry.FactoryForPublicKey(certificate.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to renew store entry '%s' (cause: %w)", name, err)
+	}
+	keyFactory, err = s.getKeyFactory(keyFactory.Name(), true)
+	if err != nil {
+		return fmt.Errorf("failed to renew store entry '%s' (cause: %w)", name, err)
+	}
+	domains := certificate.DNSNames
+	if len(domains) == 0 {
+		domains = []string{certificate.Subject.CommonName}
+	}
+	factory := acme.NewACMECertificateFactoryWithConfig(domains, s.acmeConfig(), providerName, keyFactory)
+	_, err = s.store.ReplaceCertificate(ctx, name, factory)
+	if err != nil {
+		return fmt.Errorf("failed to renew store entry '%s' (cause: %w)", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/internal/buildinfo"
+	"github.com/hdecarne-github/certd/pkg/certs/fsstore"
+)
+
+func (s *server) adminToken() string {
+	return s.config().Admin.Token
+}
+
+const errorStoreFeatureNotSupported = "Store does not support this feature"
+
+func (s *server) adminDiag(c *gin.Context) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	response := &AdminDiagResponse{
+		Version:     buildinfo.Version(),
+		GoVersion:   runtime.Version(),
+		Uptime:      time.Since(s.started).Round(time.Second).String(),
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   memStats.HeapAlloc,
+		HeapObjects: memStats.HeapObjects,
+		NumGC:       memStats.NumGC,
+		Store: AdminDiagStoreResponse{
+			Name:   s.store.Name(),
+			Caches: make(map[string]AdminDiagCacheResponse),
+		},
+	}
+	// store diagnostics are only available for FS stores
+	fsStore, ok := s.store.(*fsstore.FSStore)
+	if ok {
+		storeDiagnostics := fsStore.Diagnostics()
+		for name, cache := range storeDiagnostics.Caches {
+			response.Store.Caches[name] = AdminDiagCacheResponse{
+				Items:      cache.Items,
+				Insertions: cache.Insertions,
+				Hits:       cache.Hits,
+				Misses:     cache.Misses,
+				Evictions:  cache.Evictions,
+			}
+		}
+		response.Store.ReadOnly = fsStore.ReadOnly()
+		response.Store.Entries = storeDiagnostics.Entries
+		response.Store.ScanDuration = storeDiagnostics.ScanDuration.String()
+		if storeDiagnostics.WarmDuration > 0 {
+			response.Store.WarmDuration = storeDiagnostics.WarmDuration.String()
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *server) adminStoreGC(c *gin.Context) {
+	fsStore, ok := s.requestStore(c).(*fsstore.FSStore)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotImplemented, &ServerErrorResponse{Message: errorStoreFeatureNotSupported})
+		return
+	}
+	remove := c.Request.Method == http.MethodPost
+	orphans, err := fsStore.CollectGarbage(remove)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	response := &AdminStoreGCResponse{Orphans: make([]AdminStoreOrphanResponse, 0, len(orphans))}
+	for _, orphan := range orphans {
+		response.Orphans = append(response.Orphans, AdminStoreOrphanResponse{
+			File:      orphan.File,
+			Reason:    orphan.Reason,
+			Size:      orphan.Size,
+			Removable: orphan.Removable,
+			Removed:   orphan.Removed,
+		})
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *server) pprof(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("name"), "/")
+	switch name {
+	case "":
+		pprof.Index(c.Writer, c.Request)
+	case "cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		pprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+	}
+}
@@ -19,7 +19,12 @@ package server
 
 import (
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
 	"time"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
 )
 
 // <- /api/about
@@ -28,36 +33,292 @@ type AboutResponse struct {
 	Timestamp string `json:"timestamp"`
 }
 
+// <- /api/oids/:oid
+type OIDResponse struct {
+	OID         string       `json:"oid"`
+	Name        string       `json:"name,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Arc         *OIDResponse `json:"arc,omitempty"`
+}
+
+// <- /api/admin/diag
+type AdminDiagResponse struct {
+	Version     string                 `json:"version"`
+	GoVersion   string                 `json:"go_version"`
+	Uptime      string                 `json:"uptime"`
+	Goroutines  int                    `json:"goroutines"`
+	HeapAlloc   uint64                 `json:"heap_alloc"`
+	HeapObjects uint64                 `json:"heap_objects"`
+	NumGC       uint32                 `json:"num_gc"`
+	Store       AdminDiagStoreResponse `json:"store"`
+}
+
+type AdminDiagStoreResponse struct {
+	Name         string                            `json:"name"`
+	ReadOnly     bool                              `json:"read_only"`
+	Entries      int                               `json:"entries"`
+	ScanDuration string                            `json:"scan_duration"`
+	WarmDuration string                            `json:"warm_duration,omitempty"`
+	Caches       map[string]AdminDiagCacheResponse `json:"caches"`
+}
+
+// <- /api/admin/store/gc
+type AdminStoreGCResponse struct {
+	Orphans []AdminStoreOrphanResponse `json:"orphans"`
+}
+
+type AdminStoreOrphanResponse struct {
+	File      string `json:"file"`
+	Reason    string `json:"reason"`
+	Size      int64  `json:"size"`
+	Removable bool   `json:"removable"`
+	Removed   bool   `json:"removed"`
+}
+
+type AdminDiagCacheResponse struct {
+	Items      int    `json:"items"`
+	Insertions uint64 `json:"insertions"`
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+	Evictions  uint64 `json:"evictions"`
+}
+
+// -> /api/admin/acme/rollover/:ca
+type AdminACMERolloverRequest struct {
+	KeyType string `json:"key_type"`
+}
+
+// -> /api/admin/store/renew
+type AdminStoreRenewRequest struct {
+	AdminStoreSelector
+}
+
+// -> /api/admin/store/revoke
+type AdminStoreRevokeRequest struct {
+	AdminStoreSelector
+	Reason string `json:"reason"`
+}
+
+type AdminStoreSelector struct {
+	Issuer         string            `json:"issuer"`
+	Labels         map[string]string `json:"labels"`
+	ExpiringBefore time.Time         `json:"expiring_before"`
+}
+
+// <- /api/admin/store/renew
+// <- /api/admin/store/revoke
+// <- /api/admin/jobs/:id
+type AdminJobResponse struct {
+	ID        string                   `json:"id"`
+	Operation string                   `json:"operation"`
+	State     string                   `json:"state"`
+	Started   time.Time                `json:"started"`
+	Finished  time.Time                `json:"finished"`
+	Results   []AdminJobResultResponse `json:"results"`
+}
+
+type AdminJobResultResponse struct {
+	Entry   string `json:"entry"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// -> /api/admin/store/compromise/:name
+type AdminStoreCompromiseRequest struct {
+	DryRun bool   `json:"dry_run"`
+	Author string `json:"author"`
+}
+
+// <- /api/admin/store/compromise/:name
+type AdminStoreCompromiseResponse struct {
+	Entry       string                             `json:"entry"`
+	Issuer      string                             `json:"issuer"`
+	DryRun      bool                               `json:"dry_run"`
+	Revocation  *AdminJobResultResponse            `json:"revocation,omitempty"`
+	FinalCRL    bool                               `json:"final_crl"`
+	Descendants []string                           `json:"descendants"`
+	Leaves      []AdminStoreCompromiseLeafResponse `json:"leaves"`
+}
+
+type AdminStoreCompromiseLeafResponse struct {
+	Entry   string                          `json:"entry"`
+	Issuer  string                          `json:"issuer"`
+	ValidTo time.Time                       `json:"valid_to"`
+	Deploy  *AdminStoreDeployTargetResponse `json:"deploy,omitempty"`
+}
+
+type AdminStoreDeployTargetResponse struct {
+	CertFile string   `json:"cert_file,omitempty"`
+	KeyFile  string   `json:"key_file,omitempty"`
+	Command  []string `json:"command,omitempty"`
+}
+
 // <- /api/store/entries
 type StoreEntriesResponse struct {
-	Entries []StoreEntryResponse `json:"entries"`
+	Entries  []StoreEntryResponse        `json:"entries"`
+	Problems []StoreEntryProblemResponse `json:"problems,omitempty"`
+}
+
+type StoreEntryProblemResponse struct {
+	Name    string `json:"name"`
+	Problem string `json:"problem"`
 }
 
 type StoreEntryResponse struct {
-	Name      string    `json:"name"`
-	DN        string    `json:"dn"`
-	Key       bool      `json:"key"`
-	CRT       bool      `json:"crt"`
-	CSR       bool      `json:"csr"`
-	CRL       bool      `json:"crl"`
-	CA        bool      `json:"ca"`
-	ValidFrom time.Time `json:"valid_from"`
-	ValidTo   time.Time `json:"valid_to"`
+	Name      string            `json:"name"`
+	DN        string            `json:"dn"`
+	Key       bool              `json:"key"`
+	CRT       bool              `json:"crt"`
+	CSR       bool              `json:"csr"`
+	CRL       bool              `json:"crl"`
+	CA        bool              `json:"ca"`
+	ValidFrom time.Time         `json:"valid_from"`
+	ValidTo   time.Time         `json:"valid_to"`
+	Kind      string            `json:"kind,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// <- /api/store/archive
+type StoreArchiveResponse struct {
+	Entries []StoreArchivedEntryResponse `json:"entries"`
+}
+
+type StoreArchivedEntryResponse struct {
+	StoreEntryResponse
+	Archived time.Time `json:"archived"`
 }
 
 // <- /api/store/entry/detail/:name
 type StoreEntryDetailsResponse struct {
 	StoreEntryResponse
 	CRTDetails StoreEntryCRTDetailsResponse `json:"crt_details"`
+	CSRDetails StoreEntryCSRDetailsResponse `json:"csr_details"`
+	CRLDetails StoreEntryCRLDetailsResponse `json:"crl_details"`
+	Notes      []StoreEntryNoteResponse     `json:"notes"`
+}
+
+type StoreEntryNoteResponse struct {
+	Time   time.Time `json:"time"`
+	Author string    `json:"author"`
+	Text   string    `json:"text"`
 }
 
 type StoreEntryCRTDetailsResponse struct {
-	Version    int         `json:"version"`
-	Serial     string      `json:"serial"`
-	KeyType    string      `json:"key_type"`
-	Issuer     string      `json:"issuer"`
-	SigAlg     string      `json:"sig_alg"`
-	Extensions [][2]string `json:"extensions"`
+	Version     int         `json:"version"`
+	Serial      string      `json:"serial"`
+	KeyType     string      `json:"key_type"`
+	Issuer      string      `json:"issuer"`
+	IssuerEntry string      `json:"issuer_entry"`
+	SigAlg      string      `json:"sig_alg"`
+	Extensions  [][2]string `json:"extensions"`
+}
+
+type StoreEntryCSRDetailsResponse struct {
+	KeyType           string      `json:"key_type"`
+	SigAlg            string      `json:"sig_alg"`
+	SANs              []string    `json:"sans"`
+	ChallengePassword bool        `json:"challenge_password"`
+	Extensions        [][2]string `json:"extensions"`
+}
+
+type StoreEntryCRLDetailsResponse struct {
+	Issuer       string                         `json:"issuer"`
+	ThisUpdate   time.Time                      `json:"this_update"`
+	NextUpdate   time.Time                      `json:"next_update"`
+	Number       string                         `json:"number"`
+	SigAlg       string                         `json:"sig_alg"`
+	RevokedCount int                            `json:"revoked_count"`
+	Revoked      []StoreEntryCRLRevokedResponse `json:"revoked"`
+}
+
+type StoreEntryCRLRevokedResponse struct {
+	Serial         string    `json:"serial"`
+	RevocationTime time.Time `json:"revocation_time"`
+	Reason         int       `json:"reason"`
+}
+
+// -> /api/store/entry/export/:name
+type StoreEntryExportRequest struct {
+	Format    string `json:"format"`
+	Recipient string `json:"recipient"`
+	// Fingerprints of the OpenPGP recipient keys to encrypt for (required if the recipient contains multiple keys)
+	Fingerprints []string `json:"fingerprints,omitempty"`
+	Password     string   `json:"password"`
+}
+
+// -> /api/store/entry/labels/:name
+type StoreEntryLabelsRequest struct {
+	Labels map[string]string      `json:"labels"`
+	Note   *StoreEntryNoteRequest `json:"note,omitempty"`
+}
+
+// -> /api/store/entry/notes/:name
+type StoreEntryNoteRequest struct {
+	Author string `json:"author"`
+	Text   string `json:"text"`
+}
+
+// -> /api/store/entry/download-url/:name
+type StoreEntryDownloadURLRequest struct {
+	Format    string `json:"format"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+// -> /api/store/export
+type StoreExportRequest struct {
+	Names    []string `json:"names"`
+	Selector string   `json:"selector"`
+	PKCS12   bool     `json:"pkcs12"`
+	Password string   `json:"password"`
+}
+
+// -> /api/store/entry/sign/:name
+type StoreEntrySignRequest struct {
+	Digest          string `json:"digest"`
+	DigestAlgorithm string `json:"digest_algorithm"`
+	Timestamp       bool   `json:"timestamp"`
+}
+
+// -> /api/store/p7b/import
+type StoreP7BImportRequest struct {
+	Name string `json:"name"`
+	P7B  []byte `json:"p7b"`
+}
+
+// <- /api/store/p7b/import
+type StoreP7BImportResponse struct {
+	Entries []string `json:"entries"`
+}
+
+// <- /api/store/entry/download-url/:name
+type StoreEntryDownloadURLResponse struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// <- /api/store/stats
+type StoreStatsResponse struct {
+	Total      int                           `json:"total"`
+	Providers  map[string]int                `json:"providers"`
+	KeyTypes   map[string]int                `json:"key_types"`
+	CAs        int                           `json:"cas"`
+	Leafs      int                           `json:"leafs"`
+	Expired    int                           `json:"expired"`
+	Expiring   StoreStatsExpiringResponse    `json:"expiring"`
+	Revoked    int                           `json:"revoked"`
+	Problems   int                           `json:"problems"`
+	NextExpiry *StoreStatsNextExpiryResponse `json:"next_expiry,omitempty"`
+}
+
+type StoreStatsExpiringResponse struct {
+	Days7  int `json:"7d"`
+	Days30 int `json:"30d"`
+	Days90 int `json:"90d"`
+}
+
+type StoreStatsNextExpiryResponse struct {
+	Name    string    `json:"name"`
+	ValidTo time.Time `json:"valid_to"`
 }
 
 // <- /api/store/cas
@@ -69,11 +330,27 @@ type StoreCAResponse struct {
 	Name string `json:"name"`
 }
 
+// <- /api/store/acme/providers
+type StoreACMEProvidersResponse struct {
+	Providers []StoreACMEProviderResponse `json:"providers"`
+}
+
+type StoreACMEProviderResponse struct {
+	Name           string `json:"name"`
+	DirectoryURL   string `json:"directory_url"`
+	Reachable      bool   `json:"reachable"`
+	Error          string `json:"error,omitempty"`
+	TermsOfService string `json:"terms_of_service,omitempty"`
+	Registered     bool   `json:"registered"`
+	AccountURL     string `json:"account_url,omitempty"`
+}
+
 // <- /api/store/local/issuers
 type StoreLocalIssuersResponse struct {
 	Issuers []StoreLocalIssuerResponse `json:"issuers"`
 }
 
+// <- /api/store/local/issuers/:name
 type StoreLocalIssuerResponse struct {
 	Name string `json:"name"`
 }
@@ -81,8 +358,31 @@ type StoreLocalIssuerResponse struct {
 // <- /api/store/local/generate
 type StoreGenerateLocalRequest struct {
 	StoreGenerateRequest
-	DN              string                       `json:"dn"`
-	KeyType         string                       `json:"key_type"`
+	DN               string                       `json:"dn"`
+	KeyType          string                       `json:"key_type"`
+	Issuer           string                       `json:"issuer"`
+	ValidFrom        time.Time                    `json:"valid_from"`
+	ValidTo          time.Time                    `json:"valid_to"`
+	KeyUsage         KeyUsageExtensionSpec        `json:"key_usage"`
+	ExtKeyUsage      ExtKeyUsageExtensionSpec     `json:"ext_key_usage"`
+	BasicConstraint  BasicConstraintExtensionSpec `json:"basic_constraint"`
+	NoStoreKey       bool                         `json:"no_store_key"`
+	CustomExtensions []CustomExtensionSpec        `json:"custom_extensions"`
+	Profile          string                       `json:"profile"`
+	Email            string                       `json:"email"`
+	PublicKey        string                       `json:"public_key"`
+}
+
+// <- /api/store/local/generate (if no_store_key is set)
+type StoreGenerateLocalResponse struct {
+	Key string `json:"key"`
+}
+
+// -> /api/store/local/sign
+type StoreSignLocalRequest struct {
+	StoreGenerateRequest
+	CSR             string                       `json:"csr"`
+	Attestation     string                       `json:"attestation"`
 	Issuer          string                       `json:"issuer"`
 	ValidFrom       time.Time                    `json:"valid_from"`
 	ValidTo         time.Time                    `json:"valid_to"`
@@ -236,6 +536,39 @@ func (spec *BasicConstraintExtensionSpec) applyToCertificate(certificate *x509.C
 	certificate.BasicConstraintsValid = spec.Enabled
 }
 
+type CustomExtensionSpec struct {
+	OID      string `json:"oid"`
+	Critical bool   `json:"critical"`
+	Value    []byte `json:"value"`
+}
+
+func toExtraExtensions(specs []CustomExtensionSpec) ([]pkix.Extension, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	extensions := make([]pkix.Extension, 0, len(specs))
+	for _, spec := range specs {
+		oid, err := certs.ParseOID(spec.OID)
+		if err != nil {
+			return nil, err
+		}
+		var value asn1.RawValue
+		rest, err := asn1.Unmarshal(spec.Value, &value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DER value for extension '%s' (cause: %w)", spec.OID, err)
+		}
+		if len(rest) > 0 {
+			return nil, fmt.Errorf("unexpected trailing bytes in value for extension '%s'", spec.OID)
+		}
+		extensions = append(extensions, pkix.Extension{
+			Id:       oid,
+			Critical: spec.Critical,
+			Value:    spec.Value,
+		})
+	}
+	return extensions, nil
+}
+
 // <- /api/store/remote/generate
 type StoreGenerateRemoteRequest struct {
 	StoreGenerateRequest
@@ -250,7 +583,149 @@ type StoreGenerateACMERequest struct {
 	KeyType string   `json:"key_type"`
 }
 
+// -> /api/store/generate
+type StoreGenerateProviderRequest struct {
+	StoreGenerateRequest
+	DN      string            `json:"dn"`
+	Domains []string          `json:"domains"`
+	KeyType string            `json:"key_type"`
+	Params  map[string]string `json:"params"`
+	Renew   bool              `json:"renew"`
+}
+
+// <- /api/store/acme/generate (in case the generation has been scheduled for retry)
+type StoreGenerateScheduledResponse struct {
+	Message string    `json:"message"`
+	RetryAt time.Time `json:"retry_at"`
+}
+
+// <- /api/store/trust
+type StoreTrustResponse struct {
+	Sources []StoreTrustSourceResponse `json:"sources"`
+}
+
+type StoreTrustSourceResponse struct {
+	Name         string `json:"name"`
+	Certificates int    `json:"certificates"`
+}
+
+// -> /api/store/trust/import
+type StoreTrustImportRequest struct {
+	Name   string `json:"name"`
+	System bool   `json:"system"`
+	URL    string `json:"url"`
+}
+
+// -> /api/verify
+type VerifyRequest struct {
+	Entry string `json:"entry"`
+	CRT   string `json:"crt"`
+}
+
+// <- /api/store/entry/spki/:name
+type StoreEntrySPKIResponse struct {
+	Pins                  []StoreEntrySPKIPinResponse `json:"pins"`
+	HPKP                  string                      `json:"hpkp"`
+	NetworkSecurityConfig string                      `json:"network_security_config"`
+}
+
+type StoreEntrySPKIPinResponse struct {
+	DN  string `json:"dn"`
+	CA  bool   `json:"ca"`
+	Pin string `json:"pin"`
+}
+
+// <- /api/store/entry/compare
+type StoreEntryCompareResponse struct {
+	Left        string                                `json:"left"`
+	Right       string                                `json:"right"`
+	Equal       bool                                  `json:"equal"`
+	Differences []StoreEntryCompareDifferenceResponse `json:"differences"`
+}
+
+type StoreEntryCompareDifferenceResponse struct {
+	Field string `json:"field"`
+	Left  string `json:"left"`
+	Right string `json:"right"`
+}
+
+// -> /api/diff
+type DiffRequest struct {
+	Old VerifyRequest `json:"old"`
+	New VerifyRequest `json:"new"`
+}
+
+// <- /api/diff
+type DiffResponse struct {
+	Diffs []DiffElementResponse `json:"diffs"`
+}
+
+type DiffElementResponse struct {
+	Field string `json:"field"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+}
+
+// <- /api/verify
+type VerifyResponse struct {
+	Valid    bool                         `json:"valid"`
+	Chain    []VerifyChainElementResponse `json:"chain"`
+	Failures []string                     `json:"failures"`
+}
+
+type VerifyChainElementResponse struct {
+	DN        string    `json:"dn"`
+	Entry     string    `json:"entry"`
+	ValidFrom time.Time `json:"valid_from"`
+	ValidTo   time.Time `json:"valid_to"`
+}
+
+// <- /api/ct/findings
+type CTFindingsResponse struct {
+	Enabled  bool                `json:"enabled"`
+	Findings []CTFindingResponse `json:"findings"`
+}
+
+type CTFindingResponse struct {
+	Domain     string    `json:"domain"`
+	ID         int64     `json:"id"`
+	Issuer     string    `json:"issuer"`
+	CommonName string    `json:"common_name"`
+	Names      []string  `json:"names"`
+	Serial     string    `json:"serial"`
+	ValidFrom  time.Time `json:"valid_from"`
+	ValidTo    time.Time `json:"valid_to"`
+}
+
 // <- /api/*
 type ServerErrorResponse struct {
-	Message string `json:"message"`
+	Message string               `json:"message"`
+	Details string               `json:"details,omitempty"`
+	Code    string               `json:"code,omitempty"`
+	Hint    string               `json:"hint,omitempty"`
+	Fields  []FieldErrorResponse `json:"fields,omitempty"`
 }
+
+// Field level error of an invalid request (see ServerErrorResponse.Fields)
+type FieldErrorResponse struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// Machine-readable error codes (ServerErrorResponse.Code)
+const (
+	IssuerNotFound         = "issuer_not_found"
+	IssuerHasNoKey         = "issuer_no_key"
+	IssuerExpired          = "issuer_expired"
+	IssuerNotCA            = "issuer_not_ca"
+	IssuerPathLenExhausted = "issuer_path_len_exhausted"
+	IssuerPathLenExceeded  = "issuer_path_len_exceeded"
+	IssuerPolicyViolation  = "issuer_policy_violation"
+	KeyPolicyViolation     = "key_policy_violation"
+	ValidationFailed       = "validation_failed"
+	ChallengeFailed        = string(certs.ProviderChallengeFailed)
+	DNSMissing             = string(certs.ProviderDNSMissing)
+	RateLimited            = string(certs.ProviderRateLimited)
+	CAUnreachable          = string(certs.ProviderCAUnreachable)
+	PolicyViolation        = string(certs.ProviderPolicyViolation)
+)
@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/internal/audit"
+	"github.com/hdecarne-github/certd/internal/ginextra"
+)
+
+const errorEntryExists = "Store entry already exists"
+const errorEntryRetained = "Store entry is retained"
+
+func (s *server) storeEntryArchive(c *gin.Context) {
+	name := c.Param("name")
+	err := s.requestStore(c).ArchiveEntry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return
+	} else if errors.Is(err, fs.ErrExist) {
+		c.AbortWithStatusJSON(http.StatusConflict, &ServerErrorResponse{Message: errorEntryExists})
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	audit.Record(ginextra.RequestID(c), audit.EventArchived, name, nil)
+	c.Status(http.StatusOK)
+}
+
+func (s *server) storeArchive(c *gin.Context) {
+	entries := make([]StoreArchivedEntryResponse, 0)
+	archivedEntries := s.store.ArchivedEntries()
+	for {
+		archivedEntry := archivedEntries.Next()
+		if archivedEntry == nil {
+			break
+		}
+		storeEntryResponse, err := s.newStoreEntryResponse(archivedEntry)
+		if err != nil {
+			s.requestLogger(c).Warn().Err(err).Msgf("Skipping broken archived store entry '%s'", archivedEntry.Name())
+			continue
+		}
+		attributes, err := archivedEntry.Attributes()
+		if err != nil {
+			s.requestLogger(c).Warn().Err(err).Msgf("Skipping broken archived store entry '%s'", archivedEntry.Name())
+			continue
+		}
+		entry := StoreArchivedEntryResponse{StoreEntryResponse: *storeEntryResponse}
+		if attributes.Archived != nil {
+			entry.Archived = *attributes.Archived
+		}
+		entries = append(entries, entry)
+	}
+	c.JSON(http.StatusOK, &StoreArchiveResponse{Entries: entries})
+}
+
+func (s *server) storeArchiveRestore(c *gin.Context) {
+	name := c.Param("name")
+	err := s.requestStore(c).RestoreEntry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return
+	} else if errors.Is(err, fs.ErrExist) {
+		c.AbortWithStatusJSON(http.StatusConflict, &ServerErrorResponse{Message: errorEntryExists})
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	audit.Record(ginextra.RequestID(c), audit.EventRestored, name, nil)
+	c.Status(http.StatusOK)
+}
+
+func (s *server) storeArchivePurge(c *gin.Context) {
+	name := c.Param("name")
+	archivedEntry, err := s.store.ArchivedEntry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	attributes, err := archivedEntry.Attributes()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	var archived time.Time
+	if attributes.Archived != nil {
+		archived = *attributes.Archived
+	}
+	ca := false
+	if archivedEntry.HasCertificate() {
+		certificate, err := archivedEntry.Certificate()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		ca = certificate.IsCA
+	}
+	err = s.config().Retention.CheckPurge(archived, ca, s.clock.Now())
+	if err != nil {
+		s.requestLogger(c).Warn().Err(err).Msgf("Refusing to purge archived store entry '%s'", name)
+		c.AbortWithStatusJSON(http.StatusForbidden, &ServerErrorResponse{Message: errorEntryRetained})
+		return
+	}
+	err = s.requestStore(c).PurgeEntry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	audit.Record(ginextra.RequestID(c), audit.EventPurged, name, nil)
+	c.Status(http.StatusOK)
+}
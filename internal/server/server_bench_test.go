@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/server"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func BenchmarkStoreEntries(b *testing.B) {
+	ts, client := startBenchmarkServer(b)
+	for i := 0; i < 50; i++ {
+		generateBenchmarkEntry(b, client, fmt.Sprintf("entry%d", i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp := doGet(b, client, ts.URL+storeEntriesServiceUrl)
+		_, err := io.Copy(io.Discard, resp.Body)
+		require.NoError(b, err)
+		resp.Body.Close()
+		require.Equal(b, http.StatusOK, resp.StatusCode)
+	}
+}
+
+func BenchmarkStoreLocalGenerate(b *testing.B) {
+	_, client := startBenchmarkServer(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		generateBenchmarkEntry(b, client, fmt.Sprintf("entry%d", i))
+	}
+}
+
+func startBenchmarkServer(b *testing.B) (*server.TestServer, *http.Client) {
+	level := zerolog.GlobalLevel()
+	// suppress request logging as well as the reissue warnings caused by the test configuration
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	workDir, err := os.MkdirTemp("", "certd")
+	require.NoError(b, err)
+	ts := startTestServer(b, filepath.Join(workDir, "store"), filepath.Join(workDir, "state"))
+	b.Cleanup(func() {
+		ts.Close()
+		os.RemoveAll(workDir)
+		zerolog.SetGlobalLevel(level)
+	})
+	return ts, ts.Client()
+}
+
+func generateBenchmarkEntry(b *testing.B, client *http.Client, name string) {
+	generateLocal := &server.StoreGenerateLocalRequest{
+		StoreGenerateRequest: server.StoreGenerateRequest{
+			Name: name,
+			CA:   "Local",
+		},
+		DN:        "CN=" + name,
+		KeyType:   "ECDSA P-256",
+		ValidFrom: time.Now(),
+		ValidTo:   time.Now().Add(24 * time.Hour),
+	}
+	resp := doPut(b, client, storeLocalGenerateServiceUrl, generateLocal)
+	resp.Body.Close()
+	require.Equal(b, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/certs/local"
+)
+
+// Create the configured root CA and server certificate in case bootstrapping is enabled and the store is empty.
+func (s *server) bootstrapStore(ctx context.Context) error {
+	bootstrapConfig := &s.config().Bootstrap
+	if !bootstrapConfig.Enabled || s.store.Entries().Next() != nil {
+		return nil
+	}
+	s.logger.Info().Msgf("Bootstrapping empty store (CA: '%s', server: '%s')...", bootstrapConfig.CAEntry, bootstrapConfig.ServerEntry)
+	caTemplate, err := s.newBootstrapTemplate(ctx, bootstrapConfig.CADN, bootstrapConfig.CAValidity)
+	if err != nil {
+		return err
+	}
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.IsCA = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	_, err = s.createBootstrapEntry(ctx, bootstrapConfig.CAEntry, caTemplate, nil, nil)
+	if err != nil {
+		return err
+	}
+	parent, signer, err := s.resolveIssuer(bootstrapConfig.CAEntry)
+	if err != nil {
+		return err
+	}
+	serverTemplate, err := s.newBootstrapTemplate(ctx, bootstrapConfig.ServerDN, bootstrapConfig.Validity)
+	if err != nil {
+		return err
+	}
+	serverTemplate.BasicConstraintsValid = true
+	serverTemplate.KeyUsage = x509.KeyUsageDigitalSignature
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	serverTemplate.DNSNames = bootstrapConfig.DNSNames
+	_, err = s.createBootstrapEntry(ctx, bootstrapConfig.ServerEntry, serverTemplate, parent, signer)
+	return err
+}
+
+func (s *server) newBootstrapTemplate(ctx context.Context, dnString string, validity time.Duration) (*x509.Certificate, error) {
+	rdns, err := certs.ParseRDNSequence(dnString)
+	var rawSubject []byte
+	if err == nil {
+		rawSubject, err = certs.MarshalRDNSequence(s.config().Local.ApplyDNDefaults(rdns))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid bootstrap DN '%s' (cause: %w)", dnString, err)
+	}
+	serialNumber, err := s.generateSerialNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clk := s.clock
+	notBefore, notAfter, warnings := local.ResolveValidity(clk, s.config().Local.NotBeforeSkew, time.Time{}, clk.Now().Add(validity))
+	for _, warning := range warnings {
+		s.logger.Warn().Msgf("Odd bootstrap validity period configured (%s)", warning)
+	}
+	template := &x509.Certificate{
+		Version:      3,
+		SerialNumber: serialNumber,
+		RawSubject:   rawSubject,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	return template, nil
+}
+
+func (s *server) createBootstrapEntry(ctx context.Context, name string, template *x509.Certificate, parent *x509.Certificate, signer crypto.Signer) (certs.StoreEntry, error) {
+	keyFactory, err := s.getKeyFactory(s.config().Bootstrap.KeyType, false)
+	if err != nil {
+		return nil, err
+	}
+	storeEntry, err := s.store.CreateCertificate(ctx, name, local.NewLocalCertificateFactory(template, keyFactory, parent, signer))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bootstrap entry '%s' (cause: %w)", name, err)
+	}
+	s.logger.Info().Msgf("Created bootstrap entry '%s' (%s)", name, template.Subject)
+	return storeEntry, nil
+}
+
+// Load the TLS server certificate (including the issuer chain) from the configured server entry.
+func (s *server) loadTLSCertificate() (*tls.Certificate, error) {
+	name := s.config().Bootstrap.ServerEntry
+	storeEntry, err := s.store.Entry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("missing TLS server entry '%s' (enable bootstrap or create it manually)", name)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to access TLS server entry '%s' (cause: %w)", name, err)
+	}
+	if !storeEntry.HasCertificate() || !storeEntry.HasKey() {
+		return nil, fmt.Errorf("TLS server entry '%s' lacks certificate or key", name)
+	}
+	certificate, err := storeEntry.Certificate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to access certificate of TLS server entry '%s' (cause: %w)", name, err)
+	}
+	key, err := storeEntry.Key()
+	if err != nil {
+		return nil, fmt.Errorf("failed to access key of TLS server entry '%s' (cause: %w)", name, err)
+	}
+	tlsCertificate := &tls.Certificate{
+		Certificate: [][]byte{certificate.Raw},
+		PrivateKey:  key,
+		Leaf:        certificate,
+	}
+	for _, issuer := range s.resolveIssuerChain(name, certificate) {
+		if issuer.CheckSignatureFrom(issuer) == nil {
+			break
+		}
+		tlsCertificate.Certificate = append(tlsCertificate.Certificate, issuer.Raw)
+	}
+	return tlsCertificate, nil
+}
+
+// Reload the TLS server certificate after the server entry has been updated (e.g. re-issued).
+func (s *server) refreshTLSCertificate(name string) {
+	if s.tlsCertificate.Load() == nil || name != s.config().Bootstrap.ServerEntry {
+		return
+	}
+	tlsCertificate, err := s.loadTLSCertificate()
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to reload TLS server certificate")
+		return
+	}
+	s.tlsCertificate.Store(tlsCertificate)
+}
+
+func (s *server) tlsConfig() (*tls.Config, error) {
+	tlsCertificate, err := s.loadTLSCertificate()
+	if err != nil {
+		return nil, err
+	}
+	s.tlsCertificate.Store(tlsCertificate)
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.tlsCertificate.Load(), nil
+		},
+	}
+	return tlsConfig, nil
+}
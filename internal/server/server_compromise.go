@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/internal/audit"
+	"github.com/hdecarne-github/certd/internal/ginextra"
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+const errorCompromiseNotCA = "Store entry is not a CA"
+
+func (request *AdminStoreCompromiseRequest) validate(s *server, v *fieldValidator) {
+	if len(request.Author) > maxNoteLength {
+		v.fail("author", "must not exceed %d characters", maxNoteLength)
+	}
+}
+
+// Respond to the compromise of a CA store entry.
+//
+// The CA is revoked at its issuer (if the issuer is part of the store), a final revocation list revoking all
+// certificates issued by the CA is generated (if the CA key is still accessible) and all descendants are marked
+// via a note. The response reports the affected leaf certificates including their deploy targets. In dry-run mode
+// only the report is generated.
+func (s *server) adminStoreCompromise(c *gin.Context) {
+	name := c.Param("name")
+	compromiseRequest := &AdminStoreCompromiseRequest{}
+	if !s.decodeValidatedRequest(c, compromiseRequest) {
+		return
+	}
+	storeEntry, err := s.store.Entry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !storeEntry.HasCertificate() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorCompromiseNotCA})
+		return
+	}
+	certificate, err := storeEntry.Certificate()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !certificate.IsCA {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorCompromiseNotCA})
+		return
+	}
+	compromised := selectedEntry{name: name, certificate: certificate, issuerName: s.resolveIssuerEntry(name, certificate)}
+	descendants := s.collectDescendants(compromised)
+	response := &AdminStoreCompromiseResponse{
+		Entry:       name,
+		Issuer:      compromised.issuerName,
+		DryRun:      compromiseRequest.DryRun,
+		Descendants: make([]string, 0),
+		Leaves:      make([]AdminStoreCompromiseLeafResponse, 0),
+	}
+	deployTargets := s.deployTargets()
+	for _, descendant := range descendants {
+		if descendant.certificate.IsCA {
+			response.Descendants = append(response.Descendants, descendant.name)
+			continue
+		}
+		response.Leaves = append(response.Leaves, AdminStoreCompromiseLeafResponse{
+			Entry:   descendant.name,
+			Issuer:  descendant.issuerName,
+			ValidTo: descendant.certificate.NotAfter,
+			Deploy:  deployTargets[descendant.name],
+		})
+	}
+	if compromiseRequest.DryRun {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	job := &adminJob{}
+	s.revokeEntries(job, []selectedEntry{compromised}, certs.ReasonCACompromise)
+	if len(job.results) > 0 {
+		response.Revocation = &job.results[0]
+	}
+	response.FinalCRL, err = s.issueFinalRevocationList(compromised, descendants)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	requestID := ginextra.RequestID(c)
+	note := &certs.StoreEntryNote{
+		Time:   s.clock.Now().UTC(),
+		Author: strings.TrimSpace(compromiseRequest.Author),
+		Text:   fmt.Sprintf("CA '%s' compromised", name),
+	}
+	for _, marked := range append([]selectedEntry{compromised}, descendants...) {
+		err = s.store.UpdateAttributes(marked.name, func(attributes *certs.StoreEntryAttributes) {
+			attributes.Notes = append(attributes.Notes, *note)
+		})
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		audit.Record(requestID, audit.EventCompromised, marked.name, note)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Collect all store entries issued by the given CA (directly or via intermediate CAs).
+func (s *server) collectDescendants(ca selectedEntry) []selectedEntry {
+	candidates := make([]selectedEntry, 0)
+	storeEntries := s.store.Entries()
+	for {
+		storeEntry := storeEntries.Next()
+		if storeEntry == nil {
+			break
+		}
+		if !storeEntry.HasCertificate() || storeEntry.Name() == ca.name {
+			continue
+		}
+		certificate, err := storeEntry.Certificate()
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, selectedEntry{name: storeEntry.Name(), certificate: certificate})
+	}
+	descendants := make([]selectedEntry, 0)
+	visited := map[string]bool{ca.name: true}
+	issuers := []selectedEntry{ca}
+	for len(issuers) > 0 {
+		issuer := issuers[0]
+		issuers = issuers[1:]
+		for _, candidate := range candidates {
+			if visited[candidate.name] || !certs.IsIssuedBy(candidate.certificate, issuer.certificate) || candidate.certificate.CheckSignatureFrom(issuer.certificate) != nil {
+				continue
+			}
+			visited[candidate.name] = true
+			candidate.issuerName = issuer.name
+			descendants = append(descendants, candidate)
+			if candidate.certificate.IsCA {
+				issuers = append(issuers, candidate)
+			}
+		}
+	}
+	return descendants
+}
+
+// Issue the final revocation list of a compromised CA revoking all certificates directly issued by it.
+//
+// The revocation list remains valid until the CA certificate expires. If the CA key is not accessible, no
+// revocation list is issued and false is returned.
+func (s *server) issueFinalRevocationList(ca selectedEntry, descendants []selectedEntry) (bool, error) {
+	issuer, signer, current := s.resolveOCSPIssuer(ca.name)
+	if signer == nil {
+		return false, nil
+	}
+	revoke := make([]*x509.Certificate, 0)
+	for _, descendant := range descendants {
+		if descendant.issuerName == ca.name {
+			revoke = append(revoke, descendant.certificate)
+		}
+	}
+	validity := issuer.NotAfter.Sub(s.clock.Now())
+	if validity < revocationListValidity {
+		validity = revocationListValidity
+	}
+	err := s.updateRevocationList(ca.name, issuer, signer, current, revoke, certs.ReasonCACompromise, validity)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *server) deployTargets() map[string]*AdminStoreDeployTargetResponse {
+	deployTargets := make(map[string]*AdminStoreDeployTargetResponse)
+	for _, target := range s.config().Reissue.Targets {
+		deploy := target.Deploy
+		if deploy.CertFile == "" && deploy.KeyFile == "" && len(deploy.Command) == 0 {
+			continue
+		}
+		deployTargets[target.Entry] = &AdminStoreDeployTargetResponse{
+			CertFile: deploy.CertFile,
+			KeyFile:  deploy.KeyFile,
+			Command:  deploy.Command,
+		}
+	}
+	return deployTargets
+}
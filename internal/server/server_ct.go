@@ -0,0 +1,46 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *server) ctFindings(c *gin.Context) {
+	response := &CTFindingsResponse{
+		Enabled:  s.ctMonitor != nil,
+		Findings: make([]CTFindingResponse, 0),
+	}
+	if s.ctMonitor != nil {
+		for _, finding := range s.ctMonitor.Findings() {
+			response.Findings = append(response.Findings, CTFindingResponse{
+				Domain:     finding.Domain,
+				ID:         finding.ID,
+				Issuer:     finding.Issuer,
+				CommonName: finding.CommonName,
+				Names:      finding.Names,
+				Serial:     finding.Serial,
+				ValidFrom:  finding.NotBefore,
+				ValidTo:    finding.NotAfter,
+			})
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+// Compare two certificates (given by store entry name or PEM/DER data).
+//
+// The differences are returned as JSON or as plain text (if requested via query parameter text=true).
+func (s *server) diff(c *gin.Context) {
+	diffRequest := &DiffRequest{}
+	err := json.NewDecoder(c.Request.Body).Decode(diffRequest)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	oldCertificate := s.requestCertificate(c, diffRequest.Old.Entry, diffRequest.Old.CRT)
+	if oldCertificate == nil {
+		return
+	}
+	newCertificate := s.requestCertificate(c, diffRequest.New.Entry, diffRequest.New.CRT)
+	if newCertificate == nil {
+		return
+	}
+	diffs := certs.DiffCertificates(oldCertificate, newCertificate)
+	if c.Query("text") == "true" {
+		var text strings.Builder
+		err = certs.WriteCertificateDiff(&text, diffs)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(text.String()))
+		return
+	}
+	response := &DiffResponse{
+		Diffs: make([]DiffElementResponse, 0, len(diffs)),
+	}
+	for _, diff := range diffs {
+		response.Diffs = append(response.Diffs, DiffElementResponse{Field: diff.Field, Old: diff.Old, New: diff.New})
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Compare the high-level profile fields of two store entries (e.g. to verify a renewal preserved the intended profile).
+func (s *server) storeEntryCompare(c *gin.Context) {
+	left := c.Query("left")
+	right := c.Query("right")
+	if left == "" || right == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	leftCertificate := s.requestCertificate(c, left, "")
+	if leftCertificate == nil {
+		return
+	}
+	rightCertificate := s.requestCertificate(c, right, "")
+	if rightCertificate == nil {
+		return
+	}
+	diffs := certs.CompareProfiles(leftCertificate, rightCertificate)
+	response := &StoreEntryCompareResponse{
+		Left:        left,
+		Right:       right,
+		Equal:       len(diffs) == 0,
+		Differences: make([]StoreEntryCompareDifferenceResponse, 0, len(diffs)),
+	}
+	for _, diff := range diffs {
+		response.Differences = append(response.Differences, StoreEntryCompareDifferenceResponse{Field: diff.Field, Left: diff.Left, Right: diff.Right})
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,274 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/internal/state"
+	"github.com/hdecarne-github/certd/pkg/certs/export"
+)
+
+const errorInvalidDownloadURL = "Invalid or expired download URL"
+
+const downloadKeyPurpose = "download"
+
+const downloadFormatCRT = "crt"
+const downloadFormatKey = "key"
+const downloadFormatOpenSSH = export.FormatOpenSSH
+const downloadFormatOpenSSHPub = export.FormatOpenSSHPub
+
+const defaultDownloadExpiry = 10 * time.Minute
+const maxDownloadExpiry = 24 * time.Hour
+
+// Signed download URLs are only accepted once; the signatures already used are persisted in the state until they
+// expire (to prevent replays after a restart).
+const downloadClaimsFile = "download-claims.json"
+
+func init() {
+	state.RegisterPath(downloadClaimsFile)
+}
+
+func (s *server) storeEntryDownloadURL(c *gin.Context) {
+	name := c.Param("name")
+	downloadRequest := &StoreEntryDownloadURLRequest{}
+	err := json.NewDecoder(c.Request.Body).Decode(downloadRequest)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	expiry := time.Duration(downloadRequest.ExpiresIn) * time.Second
+	if expiry == 0 {
+		expiry = defaultDownloadExpiry
+	} else if expiry < 0 || expiry > maxDownloadExpiry {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	storeEntry, err := s.store.Entry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	switch downloadRequest.Format {
+	case downloadFormatCRT:
+		if !storeEntry.HasCertificate() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorExportFailure})
+			return
+		}
+	case downloadFormatKey, downloadFormatOpenSSH, downloadFormatOpenSSHPub:
+		if !storeEntry.HasKey() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorEntryHasNoKey})
+			return
+		}
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidExportFormat})
+		return
+	}
+	_, _, prefix, err := s.splitServerURL()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	path := prefix + "/download/" + url.PathEscape(name) + "/" + downloadRequest.Format
+	expires := s.clock.Now().Add(expiry).Truncate(time.Second)
+	signature, err := s.signDownload(path, expires.Unix())
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", signature)
+	serverURL := strings.TrimSuffix(s.config().ServerURL, prefix)
+	response := &StoreEntryDownloadURLResponse{
+		URL:     serverURL + path + "?" + query.Encode(),
+		Expires: expires.UTC(),
+	}
+	s.requestLogger(c).Info().Msgf("Issued download URL for store entry '%s' (format: %s, expires: %s)", name, downloadRequest.Format, response.Expires)
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *server) download(c *gin.Context) {
+	name := c.Param("name")
+	format := c.Param("format")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || s.clock.Now().Unix() > expires {
+		c.AbortWithStatusJSON(http.StatusForbidden, &ServerErrorResponse{Message: errorInvalidDownloadURL})
+		return
+	}
+	signature, err := s.signDownload(c.Request.URL.EscapedPath(), expires)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !hmac.Equal([]byte(signature), []byte(c.Query("signature"))) {
+		c.AbortWithStatusJSON(http.StatusForbidden, &ServerErrorResponse{Message: errorInvalidDownloadURL})
+		return
+	}
+	claimed, err := s.claimDownload(signature, expires)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	} else if !claimed {
+		c.AbortWithStatusJSON(http.StatusForbidden, &ServerErrorResponse{Message: errorInvalidDownloadURL})
+		return
+	}
+	storeEntry, err := s.store.Entry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	var downloaded bytes.Buffer
+	switch format {
+	case downloadFormatCRT:
+		certificate, err := storeEntry.Certificate()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		for _, chainCertificate := range append([]*x509.Certificate{certificate}, s.resolveIssuerChain(name, certificate)...) {
+			_ = pem.Encode(&downloaded, &pem.Block{Type: "CERTIFICATE", Bytes: chainCertificate.Raw})
+		}
+	case downloadFormatKey:
+		key, err := storeEntry.Key()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		_ = pem.Encode(&downloaded, &pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+	case downloadFormatOpenSSH, downloadFormatOpenSSHPub:
+		key, err := storeEntry.Key()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorExportFailure})
+			return
+		}
+		var exported []byte
+		if format == downloadFormatOpenSSH {
+			exported, err = export.KeyOpenSSH(signer, name)
+		} else {
+			exported, err = export.PublicKeyOpenSSH(signer.Public(), name)
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorExportFailure})
+			return
+		}
+		downloaded.Write(exported)
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidExportFormat})
+		return
+	}
+	s.requestLogger(c).Info().Msgf("Store entry '%s' downloaded (format: %s)", name, format)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + format}))
+	c.Data(http.StatusOK, "application/x-pem-file", downloaded.Bytes())
+}
+
+// Sign the given download path and expiry time using a key derived from the store secret.
+func (s *server) signDownload(path string, expires int64) (string, error) {
+	key, err := s.store.DeriveKey(downloadKeyPurpose, 32)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(path))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Mark the given signature as used (returns false if it has already been used before).
+func (s *server) claimDownload(signature string, expires int64) (bool, error) {
+	s.downloadsLock.Lock()
+	defer s.downloadsLock.Unlock()
+	claims, err := loadDownloadClaims()
+	if err != nil {
+		return false, err
+	}
+	now := s.clock.Now().Unix()
+	for claimedSignature, claimExpires := range claims {
+		if now > claimExpires {
+			delete(claims, claimedSignature)
+		}
+	}
+	_, claimed := claims[signature]
+	if claimed {
+		return false, nil
+	}
+	claims[signature] = expires
+	err = saveDownloadClaims(claims)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func saveDownloadClaims(claims map[string]int64) error {
+	claimsBytes, err := json.Marshal(claims)
+	if err != nil {
+		return fmt.Errorf("failed to marshal download claims (cause: %w)", err)
+	}
+	return state.Write(downloadClaimsFile, claimsBytes)
+}
+
+func loadDownloadClaims() (map[string]int64, error) {
+	claimsBytes, err := state.Read(downloadClaimsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read download claims from '%s' (cause: %w)", downloadClaimsFile, err)
+	}
+	claims := make(map[string]int64)
+	if err == nil {
+		err = json.Unmarshal(claimsBytes, &claims)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal download claims file '%s' (cause: %w)", downloadClaimsFile, err)
+		}
+	}
+	return claims, nil
+}
@@ -0,0 +1,255 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/certs/export"
+)
+
+const errorNoEntriesSelected = "No store entries selected"
+
+const storeExportFileName = "certd-export.zip"
+
+func (s *server) storeExport(c *gin.Context) {
+	exportRequest := &StoreExportRequest{}
+	err := json.NewDecoder(c.Request.Body).Decode(exportRequest)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	if exportRequest.PKCS12 && exportRequest.Password == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	storeEntries := s.selectExportEntries(c, exportRequest)
+	if storeEntries == nil {
+		return
+	}
+	var exported bytes.Buffer
+	zipWriter := zip.NewWriter(&exported)
+	for _, storeEntry := range storeEntries {
+		err = s.writeExportEntry(zipWriter, storeEntry, exportRequest)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+	err = zipWriter.Close()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", storeExportFileName))
+	c.Data(http.StatusOK, "application/zip", exported.Bytes())
+}
+
+// Collect the store entries selected by name or label selector (aborting the request and returning nil in case of an error).
+func (s *server) selectExportEntries(c *gin.Context, exportRequest *StoreExportRequest) []certs.StoreEntry {
+	selected := make(map[string]certs.StoreEntry)
+	for _, name := range exportRequest.Names {
+		storeEntry, err := s.store.Entry(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+			return nil
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return nil
+		}
+		selected[name] = storeEntry
+	}
+	if exportRequest.Selector != "" {
+		selector, err := parseLabelSelector(exportRequest.Selector)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidSelector})
+			return nil
+		}
+		storeEntries := s.store.Entries()
+		for {
+			storeEntry := storeEntries.Next()
+			if storeEntry == nil {
+				break
+			}
+			attributes, err := storeEntry.Attributes()
+			if err != nil {
+				c.AbortWithError(http.StatusInternalServerError, err)
+				return nil
+			}
+			if selector.matches(attributes.Labels) {
+				selected[storeEntry.Name()] = storeEntry
+			}
+		}
+	}
+	if len(selected) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorNoEntriesSelected})
+		return nil
+	}
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	storeEntries := make([]certs.StoreEntry, 0, len(names))
+	for _, name := range names {
+		storeEntries = append(storeEntries, selected[name])
+	}
+	return storeEntries
+}
+
+func (s *server) writeExportEntry(zipWriter *zip.Writer, storeEntry certs.StoreEntry, exportRequest *StoreExportRequest) error {
+	name := storeEntry.Name()
+	fileName := exportFileName(name)
+	if storeEntry.HasCertificate() {
+		certificate, err := storeEntry.Certificate()
+		if err != nil {
+			return err
+		}
+		err = writeExportPEM(zipWriter, fileName+".crt", "CERTIFICATE", certificate.Raw)
+		if err != nil {
+			return err
+		}
+		chain := s.resolveIssuerChain(name, certificate)
+		if len(chain) > 0 {
+			err = writeExportChain(zipWriter, fileName+".chain.pem", chain)
+			if err != nil {
+				return err
+			}
+		}
+		if exportRequest.PKCS12 && storeEntry.HasKey() {
+			key, err := storeEntry.Key()
+			if err != nil {
+				return err
+			}
+			exported, err := export.PKCS12(key, certificate, chain, exportRequest.Password)
+			if err != nil {
+				return err
+			}
+			err = writeExportFile(zipWriter, fileName+".p12", exported)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if storeEntry.HasCertificateRequest() {
+		certificateRequest, err := storeEntry.CertificateRequest()
+		if err != nil {
+			return err
+		}
+		err = writeExportPEM(zipWriter, fileName+".csr", "CERTIFICATE REQUEST", certificateRequest.Raw)
+		if err != nil {
+			return err
+		}
+	}
+	if storeEntry.HasRevocationList() {
+		revocationList, err := storeEntry.RevocationList()
+		if err != nil {
+			return err
+		}
+		err = writeExportPEM(zipWriter, fileName+".crl", "X509 CRL", revocationList.Raw)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Derive the ZIP member name (without extension) for the given entry name.
+//
+// Names consisting of portable characters only are used as is. All other names (e.g. names containing path
+// separators or parent directory references) are mapped to a slug made up of the name's portable characters and
+// a hash of the full name, to ensure the exported files are always extracted into the target directory.
+func exportFileName(name string) string {
+	if isPortableExportName(name) {
+		return name
+	}
+	var slug strings.Builder
+	for _, r := range name {
+		if isPortableExportRune(r) {
+			slug.WriteRune(r)
+		} else {
+			slug.WriteRune('_')
+		}
+	}
+	hash := sha256.Sum256([]byte(name))
+	return strings.TrimLeft(slug.String(), ".") + "~" + hex.EncodeToString(hash[:8])
+}
+
+func isPortableExportName(name string) bool {
+	if name == "" || strings.HasPrefix(name, ".") {
+		return false
+	}
+	for _, r := range name {
+		if !isPortableExportRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isPortableExportRune(r rune) bool {
+	return ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || r == '.' || r == '_' || r == '-' || r == '@' || r == '+'
+}
+
+func writeExportChain(zipWriter *zip.Writer, fileName string, chain []*x509.Certificate) error {
+	var encoded bytes.Buffer
+	for _, certificate := range chain {
+		err := pem.Encode(&encoded, &pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+		if err != nil {
+			return fmt.Errorf("failed to encode issuer certificate (cause: %w)", err)
+		}
+	}
+	return writeExportFile(zipWriter, fileName, encoded.Bytes())
+}
+
+func writeExportPEM(zipWriter *zip.Writer, fileName string, blockType string, bytes []byte) error {
+	return writeExportFile(zipWriter, fileName, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes}))
+}
+
+func writeExportFile(zipWriter *zip.Writer, fileName string, data []byte) error {
+	header := &zip.FileHeader{
+		Name:     fileName,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	}
+	header.SetMode(0600)
+	file, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to create ZIP entry '%s' (cause: %w)", fileName, err)
+	}
+	_, err = file.Write(data)
+	if err != nil {
+		return fmt.Errorf("failed to write ZIP entry '%s' (cause: %w)", fileName, err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const errorIssuerNotFound = "Issuer not found"
+const errorIssuerHasNoKey = "Issuer has no key"
+const errorIssuerExpired = "Issuer certificate expired"
+const errorIssuerNotCA = "Issuer is not a CA"
+const errorIssuerPathLenExhausted = "Issuer path length exhausted"
+const errorIssuerPathLenExceeded = "Path length exceeds issuer path length"
+const errorIssuerPolicyViolation = "Issuer violates key policy"
+
+// Reports why a store entry cannot be used as the issuer of a certificate.
+type issuerError struct {
+	issuer  string
+	code    string
+	message string
+}
+
+func (err *issuerError) Error() string {
+	return fmt.Sprintf("invalid issuer '%s' (%s)", err.issuer, err.message)
+}
+
+// Resolve the certificate and signer of the given issuer entry.
+//
+// An *issuerError is returned in case the entry is not usable for signing (unknown, no key, expired or not a CA).
+func (s *server) resolveIssuer(issuer string) (*x509.Certificate, crypto.Signer, error) {
+	issuerStoreEntry, err := s.store.Entry(issuer)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, &issuerError{issuer: issuer, code: IssuerNotFound, message: errorIssuerNotFound}
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if !issuerStoreEntry.HasCertificate() {
+		return nil, nil, &issuerError{issuer: issuer, code: IssuerNotFound, message: errorIssuerNotFound}
+	}
+	parent, err := issuerStoreEntry.Certificate()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !parent.IsCA {
+		return nil, nil, &issuerError{issuer: issuer, code: IssuerNotCA, message: errorIssuerNotCA}
+	}
+	if s.clock.Now().After(parent.NotAfter) {
+		return nil, nil, &issuerError{issuer: issuer, code: IssuerExpired, message: errorIssuerExpired}
+	}
+	err = s.config().KeyPolicy.Policy().CheckCertificate(parent)
+	if err != nil {
+		return nil, nil, &issuerError{issuer: issuer, code: IssuerPolicyViolation, message: errorIssuerPolicyViolation}
+	}
+	signer, err := s.entrySigner(issuerStoreEntry, parent)
+	if err != nil {
+		return nil, nil, err
+	}
+	if signer == nil {
+		return nil, nil, &issuerError{issuer: issuer, code: IssuerHasNoKey, message: errorIssuerHasNoKey}
+	}
+	return parent, signer, nil
+}
+
+// Check whether the issuer's path length constraint permits issuing the given (CA) certificate.
+//
+// If the issuer is constrained, the certificate must be constrained as well and its path length must be strictly
+// below the issuer's one.
+func checkIssuerPathLen(issuer string, parent *x509.Certificate, template *x509.Certificate) error {
+	if !template.IsCA || !parent.BasicConstraintsValid || !pathLenConstrained(parent) {
+		return nil
+	}
+	if parent.MaxPathLen == 0 {
+		return &issuerError{issuer: issuer, code: IssuerPathLenExhausted, message: errorIssuerPathLenExhausted}
+	}
+	if !pathLenConstrained(template) || template.MaxPathLen >= parent.MaxPathLen {
+		return &issuerError{issuer: issuer, code: IssuerPathLenExceeded, message: errorIssuerPathLenExceeded}
+	}
+	return nil
+}
+
+func pathLenConstrained(certificate *x509.Certificate) bool {
+	return certificate.MaxPathLen > 0 || (certificate.MaxPathLen == 0 && certificate.MaxPathLenZero)
+}
+
+func (s *server) abortIssuerError(c *gin.Context, err error) {
+	var issuerErr *issuerError
+	if errors.As(err, &issuerErr) {
+		s.requestLogger(c).Warn().Err(err).Msg("Rejecting issuer")
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, &ServerErrorResponse{Message: issuerErr.message, Code: issuerErr.code})
+		return
+	}
+	c.AbortWithError(http.StatusInternalServerError, err)
+}
+
+// Validate an issuer selection up-front (e.g. while the user is still filling the generate form).
+func (s *server) storeLocalIssuer(c *gin.Context) {
+	name := c.Param("name")
+	_, _, err := s.resolveIssuer(name)
+	if err != nil {
+		s.abortIssuerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, &StoreLocalIssuerResponse{Name: name})
+}
@@ -0,0 +1,357 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/internal/audit"
+	"github.com/hdecarne-github/certd/internal/ginextra"
+	"github.com/hdecarne-github/certd/internal/reissue"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/jellydator/ttlcache/v3"
+)
+
+const errorJobNotFound = "Job not found"
+
+const (
+	jobOperationRenew  = "renew"
+	jobOperationRevoke = "revoke"
+
+	jobStateQueued   = "queued"
+	jobStateRunning  = "running"
+	jobStateFinished = "finished"
+
+	jobResultRenewed = "renewed"
+	jobResultRevoked = "revoked"
+	jobResultSkipped = "skipped"
+	jobResultFailed  = "failed"
+)
+
+// Time finished jobs remain accessible via /api/admin/jobs/:id
+const jobRetention = 24 * time.Hour
+
+// Validity of the revocation lists issued by bulk revocations
+const revocationListValidity = 7 * 24 * time.Hour
+
+var jobCacheOptions []ttlcache.Option[string, *adminJob] = []ttlcache.Option[string, *adminJob]{ttlcache.WithDisableTouchOnHit[string, *adminJob]()}
+
+var revocationReasons = map[string]int{
+	"":                       certs.ReasonUnspecified,
+	"unspecified":            certs.ReasonUnspecified,
+	"key_compromise":         certs.ReasonKeyCompromise,
+	"ca_compromise":          certs.ReasonCACompromise,
+	"affiliation_changed":    certs.ReasonAffiliationChanged,
+	"superseded":             certs.ReasonSuperseded,
+	"cessation_of_operation": certs.ReasonCessationOfOperation,
+}
+
+// A background job operating on multiple store entries.
+type adminJob struct {
+	id        string
+	operation string
+	state     string
+	started   time.Time
+	finished  time.Time
+	results   []AdminJobResultResponse
+	lock      sync.Mutex
+}
+
+func (job *adminJob) result(entry string, status string, message string) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.results = append(job.results, AdminJobResultResponse{Entry: entry, Status: status, Message: message})
+}
+
+func (job *adminJob) run() {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.state = jobStateRunning
+}
+
+func (job *adminJob) finish(finished time.Time) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.state = jobStateFinished
+	job.finished = finished
+}
+
+func (job *adminJob) response() *AdminJobResponse {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	results := make([]AdminJobResultResponse, len(job.results))
+	copy(results, job.results)
+	return &AdminJobResponse{
+		ID:        job.id,
+		Operation: job.operation,
+		State:     job.state,
+		Started:   job.started,
+		Finished:  job.finished,
+		Results:   results,
+	}
+}
+
+// A store entry matched by a selector.
+type selectedEntry struct {
+	name        string
+	certificate *x509.Certificate
+	issuerName  string
+}
+
+func (selector *AdminStoreSelector) validate(v *fieldValidator) {
+	if selector.Issuer == "" && len(selector.Labels) == 0 && selector.ExpiringBefore.IsZero() {
+		v.fail("issuer", "at least one of issuer, labels or expiring_before is required")
+	}
+}
+
+func (request *AdminStoreRenewRequest) validate(s *server, v *fieldValidator) {
+	request.AdminStoreSelector.validate(v)
+}
+
+func (request *AdminStoreRevokeRequest) validate(s *server, v *fieldValidator) {
+	request.AdminStoreSelector.validate(v)
+	_, known := revocationReasons[request.Reason]
+	if !known {
+		v.fail("reason", "unrecognized revocation reason '%s'", request.Reason)
+	}
+}
+
+func (s *server) adminStoreRenew(c *gin.Context) {
+	renewRequest := &AdminStoreRenewRequest{}
+	if !s.decodeValidatedRequest(c, renewRequest) {
+		return
+	}
+	job, err := s.startJob(jobOperationRenew, func(ctx context.Context, job *adminJob) {
+		s.renewEntries(ctx, job, s.selectEntries(&renewRequest.AdminStoreSelector))
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, job.response())
+}
+
+func (s *server) adminStoreRevoke(c *gin.Context) {
+	revokeRequest := &AdminStoreRevokeRequest{}
+	if !s.decodeValidatedRequest(c, revokeRequest) {
+		return
+	}
+	reason := revocationReasons[revokeRequest.Reason]
+	job, err := s.startJob(jobOperationRevoke, func(_ context.Context, job *adminJob) {
+		s.revokeEntries(job, s.selectEntries(&revokeRequest.AdminStoreSelector), reason)
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, job.response())
+}
+
+func (s *server) adminJob(c *gin.Context) {
+	id := c.Param("id")
+	item := s.jobs.Get(id)
+	if item == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorJobNotFound})
+		return
+	}
+	ginextra.ConditionalJSON(c, item.Value().response())
+}
+
+// Start a background job.
+//
+// The job is executed via the scheduler and remains accessible via its id until the retention period is over.
+func (s *server) startJob(operation string, run func(ctx context.Context, job *adminJob)) (*adminJob, error) {
+	idBytes := make([]byte, 8)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate job id (cause: %w)", err)
+	}
+	job := &adminJob{
+		id:        hex.EncodeToString(idBytes),
+		operation: operation,
+		state:     jobStateQueued,
+		started:   s.clock.Now(),
+		results:   make([]AdminJobResultResponse, 0),
+	}
+	s.jobs.DeleteExpired()
+	s.jobs.Set(job.id, job, jobRetention)
+	s.scheduler.ScheduleOnce("job:"+job.id, time.Now(), func(ctx context.Context) {
+		defer func() {
+			job.finish(s.clock.Now())
+		}()
+		release, err := s.jobWorkers.Acquire(ctx)
+		if err != nil {
+			s.logger.Warn().Err(err).Msgf("Dropping queued %s job %s", job.operation, job.id)
+			return
+		}
+		defer release()
+		s.logger.Info().Msgf("Running %s job %s...", job.operation, job.id)
+		job.run()
+		run(ctx, job)
+	})
+	return job, nil
+}
+
+// Select the store entries (with certificate) matching all of the given selector criteria.
+func (s *server) selectEntries(selector *AdminStoreSelector) []selectedEntry {
+	selected := make([]selectedEntry, 0)
+	storeEntries := s.store.Entries()
+	for {
+		storeEntry := storeEntries.Next()
+		if storeEntry == nil {
+			break
+		}
+		if !storeEntry.HasCertificate() {
+			continue
+		}
+		certificate, err := storeEntry.Certificate()
+		if err != nil {
+			continue
+		}
+		if !selector.ExpiringBefore.IsZero() && !certificate.NotAfter.Before(selector.ExpiringBefore) {
+			continue
+		}
+		if len(selector.Labels) > 0 {
+			attributes, err := storeEntry.Attributes()
+			if err != nil || !matchLabels(attributes.Labels, selector.Labels) {
+				continue
+			}
+		}
+		issuerName := s.resolveIssuerEntry(storeEntry.Name(), certificate)
+		if selector.Issuer != "" && (issuerName != selector.Issuer || issuerName == storeEntry.Name()) {
+			continue
+		}
+		selected = append(selected, selectedEntry{name: storeEntry.Name(), certificate: certificate, issuerName: issuerName})
+	}
+	return selected
+}
+
+func matchLabels(labels map[string]string, required map[string]string) bool {
+	for key, value := range required {
+		labelValue, ok := labels[key]
+		if !ok || labelValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *server) renewEntries(ctx context.Context, job *adminJob, entries []selectedEntry) {
+	serverConfig := s.config()
+	reissuer := reissue.NewReissuer(&serverConfig.Reissue, serverConfig.Local.NotBeforeSkew, serverConfig.KeyPolicy.Policy(), s.store, s.notifier)
+	reissuer.ResolveSigner(s.entrySigner)
+	for _, entry := range entries {
+		switch {
+		case entry.certificate.IsCA:
+			job.result(entry.name, jobResultSkipped, "CA certificates are not renewed")
+		case entry.issuerName == "":
+			job.result(entry.name, jobResultSkipped, "issuer not in store")
+		case entry.issuerName == entry.name:
+			job.result(entry.name, jobResultSkipped, "self-signed certificates are not renewed")
+		default:
+			_, err := reissuer.Renew(ctx, entry.name, entry.issuerName, s.clock.Now())
+			if err != nil {
+				job.result(entry.name, jobResultFailed, err.Error())
+				continue
+			}
+			audit.Record("", audit.EventRenewed, entry.name, nil)
+			s.publishEntry(entry.name)
+			job.result(entry.name, jobResultRenewed, "")
+		}
+	}
+}
+
+// Revoke the given store entries by updating the revocation lists of their issuers.
+//
+// The entries are grouped by issuer, hence every affected revocation list is updated only once.
+func (s *server) revokeEntries(job *adminJob, entries []selectedEntry, reason int) {
+	byIssuer := make(map[string][]selectedEntry)
+	for _, entry := range entries {
+		switch {
+		case entry.issuerName == "":
+			job.result(entry.name, jobResultSkipped, "issuer not in store")
+		case entry.issuerName == entry.name:
+			job.result(entry.name, jobResultSkipped, "self-signed certificates cannot be revoked")
+		default:
+			byIssuer[entry.issuerName] = append(byIssuer[entry.issuerName], entry)
+		}
+	}
+	issuerNames := make([]string, 0, len(byIssuer))
+	for issuerName := range byIssuer {
+		issuerNames = append(issuerNames, issuerName)
+	}
+	sort.Strings(issuerNames)
+	for _, issuerName := range issuerNames {
+		s.revokeIssuedEntries(job, issuerName, byIssuer[issuerName], reason)
+	}
+}
+
+func (s *server) revokeIssuedEntries(job *adminJob, issuerName string, entries []selectedEntry, reason int) {
+	issuer, signer, current := s.resolveOCSPIssuer(issuerName)
+	if signer == nil {
+		for _, entry := range entries {
+			job.result(entry.name, jobResultSkipped, fmt.Sprintf("issuer '%s' has no key", issuerName))
+		}
+		return
+	}
+	revoke := make([]*x509.Certificate, 0, len(entries))
+	revoked := make([]selectedEntry, 0, len(entries))
+	for _, entry := range entries {
+		if certs.IsRevoked(current, entry.certificate) {
+			job.result(entry.name, jobResultSkipped, "already revoked")
+			continue
+		}
+		revoke = append(revoke, entry.certificate)
+		revoked = append(revoked, entry)
+	}
+	if len(revoke) == 0 {
+		return
+	}
+	err := s.updateRevocationList(issuerName, issuer, signer, current, revoke, reason, revocationListValidity)
+	for _, entry := range revoked {
+		if err != nil {
+			job.result(entry.name, jobResultFailed, err.Error())
+			continue
+		}
+		audit.Record("", audit.EventRevoked, entry.name, nil)
+		job.result(entry.name, jobResultRevoked, "")
+	}
+}
+
+func (s *server) updateRevocationList(issuerName string, issuer *x509.Certificate, signer crypto.Signer, current *x509.RevocationList, revoke []*x509.Certificate, reason int, validity time.Duration) error {
+	revocationList, err := certs.UpdateRevocationList(issuer, signer, current, revoke, reason, s.clock.Now(), validity)
+	if err != nil {
+		return err
+	}
+	err = s.store.UpdateRevocationList(issuerName, revocationList)
+	if err != nil {
+		return err
+	}
+	s.publishEntry(issuerName)
+	return nil
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/internal/audit"
+	"github.com/hdecarne-github/certd/internal/ginextra"
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+const errorInvalidLabel = "Invalid label"
+const errorInvalidSelector = "Invalid label selector"
+
+func (s *server) storeEntryLabels(c *gin.Context) {
+	name := c.Param("name")
+	labelsRequest := &StoreEntryLabelsRequest{}
+	err := json.NewDecoder(c.Request.Body).Decode(labelsRequest)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	for key, value := range labelsRequest.Labels {
+		if !validLabel(key, value) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidLabel})
+			return
+		}
+	}
+	var note *certs.StoreEntryNote
+	if labelsRequest.Note != nil {
+		note = newStoreEntryNote(labelsRequest.Note, s.clock.Now())
+		if note == nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidNote})
+			return
+		}
+	}
+	err = s.requestStore(c).UpdateAttributes(name, func(attributes *certs.StoreEntryAttributes) {
+		if len(labelsRequest.Labels) > 0 {
+			attributes.Labels = labelsRequest.Labels
+		} else {
+			attributes.Labels = nil
+		}
+		if note != nil {
+			attributes.Notes = append(attributes.Notes, *note)
+		}
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	audit.Record(ginextra.RequestID(c), audit.EventLabelsChanged, name, note)
+	c.Status(http.StatusOK)
+}
+
+func validLabel(key string, value string) bool {
+	return key != "" && !strings.ContainsAny(key, ",=!") && !strings.ContainsAny(value, ",")
+}
+
+// labelSelector matches store entry labels against a comma separated list of label requirements.
+//
+// A requirement is either of the form key=value (label must be set to the given value) or key (label must be set).
+type labelSelector map[string]*string
+
+func parseLabelSelector(selector string) (labelSelector, error) {
+	parsed := make(labelSelector)
+	for _, requirement := range strings.Split(selector, ",") {
+		requirement = strings.TrimSpace(requirement)
+		if requirement == "" {
+			continue
+		}
+		key, value, hasValue := strings.Cut(requirement, "=")
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if !validLabel(key, value) {
+			return nil, fmt.Errorf("invalid label selector requirement '%s'", requirement)
+		}
+		if hasValue {
+			parsed[key] = &value
+		} else {
+			parsed[key] = nil
+		}
+	}
+	if len(parsed) == 0 {
+		return nil, fmt.Errorf("empty label selector '%s'", selector)
+	}
+	return parsed, nil
+}
+
+func (selector labelSelector) matches(labels map[string]string) bool {
+	for key, requiredValue := range selector {
+		value, found := labels[key]
+		if !found || (requiredValue != nil && *requiredValue != value) {
+			return false
+		}
+	}
+	return true
+}
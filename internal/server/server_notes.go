@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/internal/audit"
+	"github.com/hdecarne-github/certd/internal/ginextra"
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+const errorInvalidNote = "Invalid note"
+
+const maxNoteLength = 4096
+
+func (s *server) storeEntryNotes(c *gin.Context) {
+	name := c.Param("name")
+	noteRequest := &StoreEntryNoteRequest{}
+	err := json.NewDecoder(c.Request.Body).Decode(noteRequest)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	note := newStoreEntryNote(noteRequest, s.clock.Now())
+	if note == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidNote})
+		return
+	}
+	err = s.requestStore(c).UpdateAttributes(name, func(attributes *certs.StoreEntryAttributes) {
+		attributes.Notes = append(attributes.Notes, *note)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	audit.Record(ginextra.RequestID(c), audit.EventNoteAdded, name, note)
+	c.Status(http.StatusOK)
+}
+
+// Create a note timestamped with the given time from the given request (nil if the request is invalid).
+func newStoreEntryNote(noteRequest *StoreEntryNoteRequest, now time.Time) *certs.StoreEntryNote {
+	text := strings.TrimSpace(noteRequest.Text)
+	if text == "" || len(text) > maxNoteLength || len(noteRequest.Author) > maxNoteLength {
+		return nil
+	}
+	return &certs.StoreEntryNote{
+		Time:   now.UTC(),
+		Author: strings.TrimSpace(noteRequest.Author),
+		Text:   text,
+	}
+}
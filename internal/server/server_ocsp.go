@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/jellydator/ttlcache/v3"
+	"golang.org/x/crypto/ocsp"
+)
+
+const errorOCSPSelfSigned = "Self-signed certificate has no OCSP status"
+const errorOCSPUnknownIssuer = "Certificate issuer not available"
+const errorOCSPNoServer = "Certificate has no OCSP server"
+const errorOCSPFetchFailure = "OCSP request failed"
+
+const ocspStapleValidity = 24 * time.Hour
+const ocspStapleDefaultTTL = time.Hour
+
+var ocspStapleCacheOptions []ttlcache.Option[string, []byte] = []ttlcache.Option[string, []byte]{ttlcache.WithCapacity[string, []byte](100), ttlcache.WithDisableTouchOnHit[string, []byte]()}
+
+func (s *server) storeEntryOCSPStaple(c *gin.Context) {
+	name := c.Param("name")
+	storeEntry, err := s.store.Entry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !storeEntry.HasCertificate() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorEntryHasNoCertificate})
+		return
+	}
+	certificate, err := storeEntry.Certificate()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	cacheKey := fmt.Sprintf("%s:%x", name, certificate.SerialNumber)
+	cached := s.ocspStaples.Get(cacheKey)
+	if cached != nil {
+		c.Data(http.StatusOK, certs.OCSPResponseContentType, cached.Value())
+		return
+	}
+	issuerName := s.resolveIssuerEntry(name, certificate)
+	if issuerName == name {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorOCSPSelfSigned})
+		return
+	}
+	var staple []byte
+	var response *ocsp.Response
+	issuer, signer, revocationList := s.resolveOCSPIssuer(issuerName)
+	if signer != nil {
+		staple, response, err = certs.CreateOCSPResponse(certificate, issuer, signer, revocationList, time.Now(), ocspStapleValidity)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+	} else {
+		if issuer == nil {
+			issuer = s.fetchOCSPIssuer(certificate)
+		}
+		if issuer == nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorOCSPUnknownIssuer})
+			return
+		}
+		staple, response, err = certs.FetchOCSPResponse(certificate, issuer)
+		if errors.Is(err, certs.ErrNoOCSPServer) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorOCSPNoServer})
+			return
+		} else if err != nil {
+			s.requestLogger(c).Warn().Err(err).Msgf("Failed to fetch OCSP response for entry '%s' (cause: %v)", name, err)
+			c.AbortWithStatusJSON(http.StatusBadGateway, &ServerErrorResponse{Message: errorOCSPFetchFailure})
+			return
+		}
+	}
+	s.ocspStaples.Set(cacheKey, staple, ocspStapleTTL(response, time.Now()))
+	c.Data(http.StatusOK, certs.OCSPResponseContentType, staple)
+}
+
+// Resolve the issuer of an OCSP staple as far as it is available in the store.
+//
+// The issuer key and revocation list are only returned if the issuer is a local CA.
+func (s *server) resolveOCSPIssuer(issuerName string) (*x509.Certificate, crypto.Signer, *x509.RevocationList) {
+	if issuerName == "" {
+		return nil, nil, nil
+	}
+	issuerEntry, err := s.store.Entry(issuerName)
+	if err != nil {
+		return nil, nil, nil
+	}
+	issuer, err := issuerEntry.Certificate()
+	if err != nil {
+		return nil, nil, nil
+	}
+	signer, err := s.entrySigner(issuerEntry, issuer)
+	if err != nil || signer == nil {
+		return issuer, nil, nil
+	}
+	var revocationList *x509.RevocationList
+	if issuerEntry.HasRevocationList() {
+		revocationList, _ = issuerEntry.RevocationList()
+	}
+	return issuer, signer, revocationList
+}
+
+func (s *server) fetchOCSPIssuer(certificate *x509.Certificate) *x509.Certificate {
+	for _, issuingCertificateURL := range certificate.IssuingCertificateURL {
+		issuers, err := certs.FetchCertificates(issuingCertificateURL)
+		if err != nil {
+			s.logger.Warn().Err(err).Msgf("Failed to fetch issuer certificate (cause: %v)", err)
+			continue
+		}
+		for _, issuer := range issuers {
+			if certificate.CheckSignatureFrom(issuer) == nil {
+				return issuer
+			}
+		}
+	}
+	return nil
+}
+
+// Cache OCSP staples for half of their remaining validity to always hand out fresh ones.
+func ocspStapleTTL(response *ocsp.Response, now time.Time) time.Duration {
+	if response.NextUpdate.IsZero() {
+		return ocspStapleDefaultTTL
+	}
+	ttl := response.NextUpdate.Sub(now) / 2
+	if ttl <= 0 {
+		return time.Second
+	}
+	return ttl
+}
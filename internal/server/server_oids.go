@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/pkg/asn1/oids"
+)
+
+const errorInvalidOID = "Invalid OID"
+const errorUnknownOID = "Unknown OID"
+
+// Look up an OID (given in dotted notation or by name).
+func (s *server) oid(c *gin.Context) {
+	oid := c.Param("oid")
+	_, err := oids.Parse(oid)
+	if err != nil {
+		registered := oids.LookupName(oid)
+		if registered == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorInvalidOID})
+			return
+		}
+		oid = registered.OID
+	}
+	registered := oids.Lookup(oid)
+	arc := oids.Arc(oid)
+	if registered == nil && arc == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorUnknownOID})
+		return
+	}
+	response := &OIDResponse{OID: oid}
+	if registered != nil {
+		response.Name = registered.Name
+		response.Description = registered.Description
+	}
+	if arc != nil {
+		response.Arc = &OIDResponse{
+			OID:         arc.OID,
+			Name:        arc.Name,
+			Description: arc.Description,
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
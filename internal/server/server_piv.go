@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"crypto"
+	"crypto/x509"
+	"strings"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/keys"
+	"github.com/hdecarne-github/certd/pkg/keys/piv"
+)
+
+const pivKeyType = piv.ProviderName
+const pivKeyRefPrefix = piv.ProviderName + ":"
+
+func (s *server) pivConfig(slot string) *piv.Config {
+	pivConfig := &s.config().PIV
+	if slot == "" {
+		slot = pivConfig.Slot
+	}
+	return &piv.Config{
+		Serial:        pivConfig.Serial,
+		Slot:          slot,
+		Algorithm:     pivConfig.Algorithm,
+		PINPolicy:     pivConfig.PINPolicy,
+		TouchPolicy:   pivConfig.TouchPolicy,
+		PIN:           pivConfig.PIN,
+		ManagementKey: pivConfig.ManagementKey,
+	}
+}
+
+// Get the key factory generating keys on the configured PIV token.
+//
+// The configured algorithm is subject to the key policy the same way as software generated keys.
+func (s *server) getPIVKeyFactory() (keys.KeyPairFactory, error) {
+	pivConfig := s.pivConfig("")
+	equivalentFactory, err := pivConfig.EquivalentKeyPairFactory()
+	if err != nil {
+		return nil, err
+	}
+	err = s.config().KeyPolicy.Policy().Check(equivalentFactory, false)
+	if err != nil {
+		return nil, err
+	}
+	return piv.NewPIVKeyPairFactory(pivConfig), nil
+}
+
+// Get the reference recorded in the attributes of a store entry whose key is held by a PIV token.
+func (s *server) pivKeyRef() string {
+	return pivKeyRefPrefix + s.config().PIV.Slot
+}
+
+// Get the signing key of a store entry.
+//
+// The key is either held by the store itself or by a PIV token (as referenced by the entry's attributes).
+// If the entry has no key at all, nil is returned.
+func (s *server) entrySigner(storeEntry certs.StoreEntry, certificate *x509.Certificate) (crypto.Signer, error) {
+	if storeEntry.HasKey() {
+		key, err := storeEntry.Key()
+		if err != nil {
+			return nil, err
+		}
+		return certs.KeySigner(key)
+	}
+	attributes, err := storeEntry.Attributes()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(attributes.KeyRef, pivKeyRefPrefix) {
+		return nil, nil
+	}
+	return piv.NewPIVSigner(s.pivConfig(strings.TrimPrefix(attributes.KeyRef, pivKeyRefPrefix)), certificate.PublicKey)
+}
+
+func (s *server) hasSigner(storeEntry certs.StoreEntry) bool {
+	if storeEntry.HasKey() {
+		return true
+	}
+	attributes, err := storeEntry.Attributes()
+	return err == nil && strings.HasPrefix(attributes.KeyRef, pivKeyRefPrefix)
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/internal/ginextra"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/certs/imported"
+)
+
+const errorInvalidP7B = "Invalid PKCS#7 data"
+const errorEntryHasNoCertificate = "Store entry has no certificate"
+const errorImportFailure = "Import failed"
+
+func (s *server) storeEntryP7B(c *gin.Context) {
+	name := c.Param("name")
+	storeEntry, err := s.store.Entry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !storeEntry.HasCertificate() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorEntryHasNoCertificate})
+		return
+	}
+	certificate, err := storeEntry.Certificate()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	certificates := append([]*x509.Certificate{certificate}, s.resolveIssuerChain(name, certificate)...)
+	encoded, err := certs.EncodeCertificatesPKCS7(certificates, nil)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.p7b\"", name))
+	ginextra.ConditionalData(c, "application/x-pkcs7-certificates", encoded)
+}
+
+func (s *server) storeP7BImport(c *gin.Context) {
+	importRequest := &StoreP7BImportRequest{}
+	err := json.NewDecoder(c.Request.Body).Decode(importRequest)
+	if err != nil || importRequest.Name == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	certificates, err := certs.DecodeCertificatesPKCS7(importRequest.P7B)
+	if err != nil || len(certificates) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidP7B})
+		return
+	}
+	trustAnchors, err := s.collectTrustAnchors()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	response := &StoreP7BImportResponse{
+		Entries: make([]string, 0),
+	}
+	for i, certificate := range orderCertificateChain(certificates) {
+		existing, known := trustAnchors.entries[string(certificate.Raw)]
+		if known {
+			s.requestLogger(c).Info().Msgf("Skipping already known certificate '%s' (entry: '%s')", certificate.Subject, existing)
+			continue
+		}
+		name := importRequest.Name
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d", importRequest.Name, i)
+		}
+		_, _, err = s.requestStore(c).CreateCertificateWithoutKey(c.Request.Context(), name, imported.NewImportCertificateFactory(certificate))
+		if err != nil {
+			s.requestLogger(c).Error().Err(err).Msgf("Failed to import certificate '%s'", certificate.Subject)
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorImportFailure})
+			return
+		}
+		response.Entries = append(response.Entries, name)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Order the given certificates starting with the leaf certificate followed by its issuers.
+//
+// Certificates not part of the leaf certificate's chain are appended in their original order.
+func orderCertificateChain(certificates []*x509.Certificate) []*x509.Certificate {
+	ordered := make([]*x509.Certificate, 0, len(certificates))
+	remaining := append([]*x509.Certificate{}, certificates...)
+	var current *x509.Certificate
+	for i, candidate := range remaining {
+		issuesOther := false
+		for _, other := range remaining {
+			if other != candidate && certs.IsIssuedBy(other, candidate) {
+				issuesOther = true
+				break
+			}
+		}
+		if !issuesOther {
+			current = candidate
+			remaining = append(remaining[:i], remaining[i+1:]...)
+			break
+		}
+	}
+	for current != nil {
+		ordered = append(ordered, current)
+		next := current
+		current = nil
+		for i, candidate := range remaining {
+			if certs.IsIssuedBy(next, candidate) {
+				current = candidate
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+		}
+	}
+	return append(ordered, remaining...)
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+const profileSMIME = "smime"
+const profileTSA = "tsa"
+
+var oidExtensionExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+var oidExtKeyUsageTimeStamping = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}
+
+const errorInvalidProfile = "Invalid certificate profile"
+const errorInvalidEmail = "Invalid or disallowed email address"
+const errorNamePolicyViolation = "Name not allowed by issuer policy"
+
+// Apply the requested certificate profile (if any) to the given certificate template.
+//
+// On failure the error message to report to the client is returned alongside the error.
+func (s *server) applyLocalProfile(template *x509.Certificate, generateLocal *StoreGenerateLocalRequest) (string, error) {
+	switch generateLocal.Profile {
+	case "":
+		return "", nil
+	case profileSMIME:
+		return s.applySMIMEProfile(template, generateLocal.Email, generateLocal.KeyType)
+	case profileTSA:
+		return s.applyTSAProfile(template)
+	}
+	return errorInvalidProfile, fmt.Errorf("unrecognized profile '%s'", generateLocal.Profile)
+}
+
+// Check the SANs of the certificate to issue against the name policy of the issuer (if any).
+func (s *server) checkNamePolicy(issuer string, dnsNames []string, ips []net.IP, emails []string, uris []*url.URL) error {
+	policy := s.config().Local.NamePolicy(issuer)
+	if policy == nil {
+		return nil
+	}
+	err := policy.Check(dnsNames, ips, emails, uris)
+	if err != nil {
+		return fmt.Errorf("issuer '%s' rejected certificate (cause: %w)", issuer, err)
+	}
+	return nil
+}
+
+func (s *server) applySMIMEProfile(template *x509.Certificate, email string, keyType string) (string, error) {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return errorInvalidEmail, fmt.Errorf("invalid email address '%s'", email)
+	}
+	if !s.config().Local.SMIME.MatchEmail(email) {
+		return errorInvalidEmail, fmt.Errorf("email address '%s' not allowed by policy", email)
+	}
+	template.EmailAddresses = []string{email}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}
+	switch {
+	case strings.HasPrefix(keyType, "RSA"):
+		template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	case strings.HasPrefix(keyType, "ECDSA"):
+		template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement
+	default:
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+	template.BasicConstraintsValid = true
+	template.IsCA = false
+	return "", nil
+}
+
+func (s *server) applyTSAProfile(template *x509.Certificate) (string, error) {
+	// RFC 3161 requires a critical extended key usage extension containing timeStamping only,
+	// which is not supported by the standard ExtKeyUsage handling.
+	extKeyUsage, err := asn1.Marshal([]asn1.ObjectIdentifier{oidExtKeyUsageTimeStamping})
+	if err != nil {
+		return errorInvalidProfile, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = nil
+	template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{Id: oidExtensionExtKeyUsage, Critical: true, Value: extKeyUsage})
+	template.BasicConstraintsValid = true
+	template.IsCA = false
+	return "", nil
+}
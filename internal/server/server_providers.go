@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/certs/acme"
+	"github.com/hdecarne-github/certd/pkg/certs/imported"
+	"github.com/hdecarne-github/certd/pkg/certs/local"
+	"github.com/hdecarne-github/certd/pkg/certs/remote"
+)
+
+const errorInvalidCA = "Invalid CA"
+const errorGenerateUnsupported = "CA does not support generic generate requests"
+
+// Get the certificate providers currently available for certificate generation.
+func (s *server) certificateProviders() []certs.CertificateProvider {
+	providers := []certs.CertificateProvider{
+		local.NewProvider(),
+		remote.NewProvider(),
+		acme.NewProvider(s.acmeConfig()),
+	}
+	return append(providers, s.configuredProviders()...)
+}
+
+func (s *server) storeGenerate(c *gin.Context) {
+	generate := &StoreGenerateProviderRequest{}
+	if !s.decodeValidatedRequest(c, generate) {
+		return
+	}
+	provider := certs.FindProvider(s.certificateProviders(), generate.CA)
+	if provider == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidCA})
+		return
+	}
+	keyFactory, err := s.getKeyFactory(generate.KeyType, strings.HasPrefix(generate.CA, acme.ProviderPrefix))
+	if err != nil {
+		s.abortKeyTypeError(c, err)
+		return
+	}
+	request := &certs.ProviderRequest{
+		Domains:    generate.Domains,
+		KeyFactory: keyFactory,
+		Params:     generate.Params,
+	}
+	if generate.DN != "" {
+		dn, err := certs.ParseDN(generate.DN)
+		if err == nil {
+			request.RawSubject, err = certs.ParseRawDN(generate.DN)
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidDN})
+			return
+		}
+		request.Subject = *dn
+	}
+	if generate.Renew && !s.prepareRenewal(c, generate.Name, request) {
+		return
+	}
+	factory, err := provider.NewCertificateFactory(c.Request.Context(), generate.CA, request)
+	if errors.Is(err, certs.ErrGenericRequestUnsupported) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorGenerateUnsupported})
+		return
+	} else if err != nil {
+		c.Error(err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	s.createProviderCertificate(c, generate.Name, factory, generate.Renew)
+}
+
+// Attach the current certificate and key of the entry to renew to the provider request.
+//
+// Subject and domains default to the ones of the current certificate.
+func (s *server) prepareRenewal(c *gin.Context, name string, request *certs.ProviderRequest) bool {
+	storeEntry, err := s.store.Entry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return false
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return false
+	}
+	if !storeEntry.HasCertificate() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorEntryHasNoCertificate})
+		return false
+	}
+	if !storeEntry.HasKey() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorEntryHasNoKey})
+		return false
+	}
+	request.Certificate, err = storeEntry.Certificate()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return false
+	}
+	request.Key, err = storeEntry.Key()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return false
+	}
+	if len(request.Subject.Names) == 0 && request.Subject.CommonName == "" {
+		request.Subject = request.Certificate.Subject
+		request.RawSubject = request.Certificate.RawSubject
+	}
+	if len(request.Domains) == 0 {
+		request.Domains = request.Certificate.DNSNames
+	}
+	return true
+}
+
+func (s *server) createProviderCertificate(c *gin.Context, name string, factory certs.CertificateFactory, replace bool) {
+	var err error
+	if replace {
+		_, err = s.requestStore(c).ReplaceCertificate(c.Request.Context(), name, factory)
+	} else {
+		_, err = s.requestStore(c).CreateCertificate(c.Request.Context(), name, factory)
+	}
+	var preflightErr *acme.PreflightError
+	var rateLimitErr *acme.RateLimitError
+	if errors.As(err, &preflightErr) {
+		c.Error(err)
+		response := &ServerErrorResponse{Message: errorACMEPreflightFailure, Details: preflightErr.Error()}
+		providerErr := certs.ClassifyProviderError(err)
+		if providerErr != nil {
+			response.Code = string(providerErr.Kind)
+			response.Hint = providerErr.Hint
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, response)
+		return
+	} else if errors.As(err, &rateLimitErr) && s.acmeConfig().Retry.MaxScheduled > 0 {
+		c.Error(err)
+		s.scheduleACMERetry(name, factory, rateLimitErr.RetryAfter, 1)
+		c.JSON(http.StatusAccepted, &StoreGenerateScheduledResponse{Message: messageACMERetryScheduled, RetryAt: rateLimitErr.RetryAfter})
+		return
+	} else if err != nil {
+		s.abortGenerateError(c, err)
+		return
+	}
+	chainFactory, ok := factory.(certs.CertificateChainFactory)
+	if ok {
+		s.importIssuerChain(c, name, chainFactory.Chain())
+	}
+	s.publishEntry(name)
+	c.Status(http.StatusOK)
+}
+
+// Import the issuer certificates returned by an upstream CA, as long as they are not yet known.
+//
+// Failing imports are only logged, as the issued certificate itself has already been stored.
+func (s *server) importIssuerChain(c *gin.Context, name string, chain []*x509.Certificate) {
+	if len(chain) == 0 {
+		return
+	}
+	trustAnchors, err := s.collectTrustAnchors()
+	if err != nil {
+		s.requestLogger(c).Error().Err(err).Msgf("Failed to import issuer chain of '%s'", name)
+		return
+	}
+	for i, issuer := range chain {
+		existing, known := trustAnchors.entries[string(issuer.Raw)]
+		if known {
+			s.requestLogger(c).Debug().Msgf("Skipping already known issuer certificate '%s' (entry: '%s')", issuer.Subject, existing)
+			continue
+		}
+		issuerName := fmt.Sprintf("%s-ca-%d", name, i+1)
+		_, _, err = s.requestStore(c).CreateCertificateWithoutKey(c.Request.Context(), issuerName, imported.NewImportCertificateFactory(issuer))
+		if err != nil {
+			s.requestLogger(c).Error().Err(err).Msgf("Failed to import issuer certificate '%s'", issuer.Subject)
+			continue
+		}
+		trustAnchors.entries[string(issuer.Raw)] = issuerName
+	}
+}
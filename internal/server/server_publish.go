@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import "github.com/hdecarne-github/certd/internal/deploy"
+
+// Publish the certificate (and revocation list if available) of the given store entry.
+//
+// Afterwards the entry is deployed to the matching secret manager targets (and reloaded if it is the TLS server entry).
+// Publication and deployment failures are logged but do not affect the originating request.
+func (s *server) publishEntry(name string) {
+	defer s.deployEntry(name)
+	defer s.refreshTLSCertificate(name)
+	publisher := s.publisher()
+	if publisher == nil {
+		return
+	}
+	storeEntry, err := s.store.Entry(name)
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to access store entry '%s' for publication", name)
+		return
+	}
+	if storeEntry.HasCertificate() {
+		certificate, err := storeEntry.Certificate()
+		if err == nil {
+			err = publisher.PublishCertificate(name, certificate)
+		}
+		if err != nil {
+			s.logger.Error().Err(err).Msgf("Failed to publish certificate of store entry '%s'", name)
+		}
+	}
+	if storeEntry.HasRevocationList() {
+		revocationList, err := storeEntry.RevocationList()
+		if err == nil {
+			err = publisher.PublishRevocationList(name, revocationList)
+		}
+		if err != nil {
+			s.logger.Error().Err(err).Msgf("Failed to publish revocation list of store entry '%s'", name)
+		}
+	}
+}
+
+// Deploy the certificate (including chain and key) of the given store entry to the matching secret manager targets.
+func (s *server) deployEntry(name string) {
+	storeEntry, err := s.store.Entry(name)
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to access store entry '%s' for deployment", name)
+		return
+	}
+	if !storeEntry.HasCertificate() {
+		return
+	}
+	attributes, err := storeEntry.Attributes()
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to access attributes of store entry '%s' for deployment", name)
+		return
+	}
+	if !s.hasDeployTarget(name, attributes.Labels) {
+		return
+	}
+	certificate, err := storeEntry.Certificate()
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to access certificate of store entry '%s' for deployment", name)
+		return
+	}
+	deployment := &deploy.Deployment{
+		Name:        name,
+		Labels:      attributes.Labels,
+		Certificate: certificate,
+		Chain:       s.resolveIssuerChain(name, certificate),
+	}
+	if storeEntry.HasKey() {
+		deployment.Key, err = storeEntry.Key()
+		if err != nil {
+			s.logger.Error().Err(err).Msgf("Failed to access key of store entry '%s' for deployment", name)
+			return
+		}
+	}
+	err = s.deployer().Deploy(deployment)
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to deploy store entry '%s'", name)
+	}
+}
+
+func (s *server) hasDeployTarget(name string, labels map[string]string) bool {
+	targets := s.config().SecretDeploy.Targets
+	for i := range targets {
+		if targets[i].Matches(name, labels) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/deploy"
+	"github.com/hdecarne-github/certd/internal/publish"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/certs/acme"
+)
+
+// serverRuntime bundles the reloadable part of the server configuration.
+//
+// A runtime instance is never modified after creation. Reloads always create a new instance
+// which is swapped atomically.
+type serverRuntime struct {
+	config     *config.ServerConfig
+	acmeConfig *acme.Config
+	providers  []certs.CertificateProvider
+	publisher  publish.Publisher
+	deployer   deploy.Deployer
+}
+
+func (s *server) config() *config.ServerConfig {
+	return s.runtime.Load().config
+}
+
+func (s *server) acmeConfig() *acme.Config {
+	return s.runtime.Load().acmeConfig
+}
+
+func (s *server) configuredProviders() []certs.CertificateProvider {
+	return s.runtime.Load().providers
+}
+
+func (s *server) publisher() publish.Publisher {
+	return s.runtime.Load().publisher
+}
+
+func (s *server) deployer() deploy.Deployer {
+	return s.runtime.Load().deployer
+}
+
+func newServerRuntime(config *config.ServerConfig) (*serverRuntime, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	acmeConfig, err := acme.Load(config.ResolveACMEConfig())
+	if errors.Is(err, fs.ErrNotExist) {
+		acmeConfig = &acme.Config{}
+	} else if err != nil {
+		return nil, err
+	}
+	providers, err := certs.LoadProviders(config.ResolveProvidersConfig())
+	if errors.Is(err, fs.ErrNotExist) {
+		providers = []certs.CertificateProvider{}
+	} else if err != nil {
+		return nil, err
+	}
+	deployer, err := deploy.NewDeployer(&config.SecretDeploy)
+	if err != nil {
+		return nil, err
+	}
+	return &serverRuntime{
+		config:     config,
+		acmeConfig: acmeConfig,
+		providers:  providers,
+		publisher:  publish.NewPublisher(&config.Publish),
+		deployer:   deployer,
+	}, nil
+}
+
+// Reload the configuration file the server has been started with.
+//
+// The new configuration is only activated if it is valid. Otherwise the current configuration remains active.
+// Options which cannot be changed at runtime (listen URL, store and state path) are taken over from the current
+// configuration.
+func (s *server) reload() error {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	current := s.config()
+	if current.ConfigFile == "" {
+		return fmt.Errorf("server has not been started with a configuration file")
+	}
+	s.logger.Info().Msgf("Reloading configuration '%s'...", current.ConfigFile)
+	loaded, err := config.Load(current.ConfigFile)
+	if err != nil {
+		return err
+	}
+	reloaded := loaded.Server
+	if reloaded.ServerURL != current.ServerURL || reloaded.StorePath != current.StorePath || reloaded.StatePath != current.StatePath {
+		s.logger.Warn().Msg("Changes to server URL, store path or state path require a restart; ignoring")
+	}
+	reloaded.ServerURL = current.ServerURL
+	reloaded.StorePath = current.StorePath
+	reloaded.StatePath = current.StatePath
+	runtime, err := newServerRuntime(&reloaded)
+	if err != nil {
+		return err
+	}
+	s.runtime.Store(runtime)
+	s.logger.Info().Msg("Configuration reloaded")
+	return nil
+}
+
+func (s *server) reloadAndLog() {
+	err := s.reload()
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Configuration reload failed; keeping current configuration (cause: %v)", err)
+	}
+}
+
+// Create a job reloading the configuration, whenever the configuration file, the ACME or the provider configuration
+// file has been modified since the last run.
+func (s *server) configWatcher() func(ctx context.Context) {
+	var lastModTimes []time.Time
+	return func(_ context.Context) {
+		current := s.config()
+		modTimes := make([]time.Time, 0, 3)
+		for _, file := range []string{current.ConfigFile, current.ResolveACMEConfig(), current.ResolveProvidersConfig()} {
+			var modTime time.Time
+			fileInfo, err := os.Stat(file)
+			if err == nil {
+				modTime = fileInfo.ModTime()
+			}
+			modTimes = append(modTimes, modTime)
+		}
+		changed := false
+		for i := range lastModTimes {
+			changed = changed || !lastModTimes[i].Equal(modTimes[i])
+		}
+		lastModTimes = modTimes
+		if changed {
+			s.reloadAndLog()
+		}
+	}
+}
@@ -19,12 +19,15 @@ package server
 
 import (
 	"net/http"
-	"syscall"
+	"os"
 
 	"github.com/gin-gonic/gin"
 )
 
 func (s *server) shutdown(c *gin.Context) {
-	syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	select {
+	case s.sigint <- os.Interrupt:
+	default:
+	}
 	c.Status(http.StatusOK)
 }
@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+const errorNotCodeSigning = "Store entry is not enabled for code signing"
+const errorInvalidDigest = "Invalid digest"
+const errorTimestampNotConfigured = "No timestamp authority configured"
+const errorSignFailure = "Signing failed"
+
+var signDigestAlgorithms = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+func (s *server) storeEntrySign(c *gin.Context) {
+	name := c.Param("name")
+	signRequest := &StoreEntrySignRequest{}
+	err := json.NewDecoder(c.Request.Body).Decode(signRequest)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	digestAlgorithm := strings.ToLower(signRequest.DigestAlgorithm)
+	if digestAlgorithm == "" {
+		digestAlgorithm = "sha256"
+	}
+	hash, ok := signDigestAlgorithms[digestAlgorithm]
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidDigest})
+		return
+	}
+	digest, err := hex.DecodeString(signRequest.Digest)
+	if err != nil || len(digest) != hash.Size() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidDigest})
+		return
+	}
+	var timestamper certs.Timestamper
+	if signRequest.Timestamp {
+		tsaURL := s.config().CodeSigning.TSAURL
+		if tsaURL == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorTimestampNotConfigured})
+			return
+		}
+		timestamper = certs.NewTimestamper(tsaURL)
+	}
+	storeEntry, err := s.store.Entry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !storeEntry.HasKey() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorEntryHasNoKey})
+		return
+	}
+	certificate, err := storeEntry.Certificate()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if certificate == nil || !isCodeSigningCertificate(certificate) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorNotCodeSigning})
+		return
+	}
+	key, err := storeEntry.Key()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	signature, err := certs.SignDigest(digest, hash, certificate, s.resolveIssuerChain(name, certificate), key, timestamper)
+	if err != nil {
+		s.requestLogger(c).Error().Err(err).Msgf("Failed to sign digest using store entry '%s'", name)
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorSignFailure})
+		return
+	}
+	s.requestLogger(c).Info().Msgf("Signed %s digest %s using store entry '%s'", digestAlgorithm, signRequest.Digest, name)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.p7s\"", name))
+	c.Data(http.StatusOK, "application/pkcs7-signature", signature)
+}
+
+func isCodeSigningCertificate(certificate *x509.Certificate) bool {
+	if certificate.KeyUsage != 0 && (certificate.KeyUsage&x509.KeyUsageDigitalSignature) == 0 {
+		return false
+	}
+	for _, extKeyUsage := range certificate.ExtKeyUsage {
+		if extKeyUsage == x509.ExtKeyUsageCodeSigning {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/internal/ginextra"
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+// Report the SPKI pins of a store entry's certificate and its issuer chain (leaf first).
+//
+// Besides the individual pins, the pin set is rendered as HPKP pin directives as well as an Android network
+// security config pin-set.
+func (s *server) storeEntrySPKI(c *gin.Context) {
+	name := c.Param("name")
+	storeEntry, err := s.store.Entry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !storeEntry.HasCertificate() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorEntryHasNoCertificate})
+		return
+	}
+	certificate, err := storeEntry.Certificate()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	certificates := append([]*x509.Certificate{certificate}, s.resolveIssuerChain(name, certificate)...)
+	pins := make([]StoreEntrySPKIPinResponse, 0, len(certificates))
+	hpkp := make([]string, 0, len(certificates))
+	var networkSecurityConfig strings.Builder
+	networkSecurityConfig.WriteString("<pin-set>\n")
+	for _, chainCertificate := range certificates {
+		pin := certs.SPKIPin(chainCertificate)
+		pins = append(pins, StoreEntrySPKIPinResponse{
+			DN:  chainCertificate.Subject.String(),
+			CA:  chainCertificate.IsCA,
+			Pin: pin,
+		})
+		hpkp = append(hpkp, fmt.Sprintf("pin-sha256=\"%s\"", pin))
+		networkSecurityConfig.WriteString(fmt.Sprintf("    <pin digest=\"SHA-256\">%s</pin>\n", pin))
+	}
+	networkSecurityConfig.WriteString("</pin-set>\n")
+	response := &StoreEntrySPKIResponse{
+		Pins:                  pins,
+		HPKP:                  strings.Join(hpkp, "; "),
+		NetworkSecurityConfig: networkSecurityConfig.String(),
+	}
+	ginextra.ConditionalJSON(c, response)
+}
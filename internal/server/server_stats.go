@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const statsDay = 24 * time.Hour
+
+func (s *server) storeStats(c *gin.Context) {
+	now := s.clock.Now()
+	response := &StoreStatsResponse{
+		Providers: make(map[string]int),
+		KeyTypes:  make(map[string]int),
+	}
+	revoked := s.collectRevokedSerials(c)
+	storeEntries := s.store.Entries()
+	for {
+		storeEntry := storeEntries.Next()
+		if storeEntry == nil {
+			break
+		}
+		response.Total++
+		attributes, err := storeEntry.Attributes()
+		if err != nil {
+			response.Problems++
+			continue
+		}
+		response.Providers[attributes.Provider]++
+		if storeEntry.HasCertificate() {
+			certificate, err := storeEntry.Certificate()
+			if err != nil {
+				response.Problems++
+				continue
+			}
+			response.KeyTypes[s.getKeyType(certificate.PublicKey)]++
+			if certificate.IsCA {
+				response.CAs++
+			} else {
+				response.Leafs++
+			}
+			if revoked[revokedSerialKey(certificate.RawIssuer, certificate.SerialNumber)] {
+				response.Revoked++
+			}
+		} else if storeEntry.HasCertificateRequest() {
+			certificateRequest, err := storeEntry.CertificateRequest()
+			if err != nil {
+				response.Problems++
+				continue
+			}
+			response.KeyTypes[s.getKeyType(certificateRequest.PublicKey)]++
+		}
+	}
+	// expiry counters are taken from the store's expiry index
+	response.Expired = len(s.store.Expiries(time.Time{}, now))
+	response.Expiring.Days7 = len(s.store.Expiries(now, now.Add(7*statsDay)))
+	response.Expiring.Days30 = len(s.store.Expiries(now, now.Add(30*statsDay)))
+	response.Expiring.Days90 = len(s.store.Expiries(now, now.Add(90*statsDay)))
+	nextExpiry := s.store.NextExpiry(now)
+	if nextExpiry != nil {
+		response.NextExpiry = &StoreStatsNextExpiryResponse{
+			Name:    nextExpiry.Name,
+			ValidTo: nextExpiry.NotAfter,
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Collect the serials revoked by the revocation lists of all store entries.
+func (s *server) collectRevokedSerials(c *gin.Context) map[string]bool {
+	revoked := make(map[string]bool)
+	storeEntries := s.store.Entries()
+	for {
+		storeEntry := storeEntries.Next()
+		if storeEntry == nil {
+			break
+		}
+		if !storeEntry.HasRevocationList() {
+			continue
+		}
+		revocationList, err := storeEntry.RevocationList()
+		if err != nil || revocationList == nil {
+			s.requestLogger(c).Warn().Err(err).Msgf("Ignoring broken revocation list of store entry '%s'", storeEntry.Name())
+			continue
+		}
+		for _, revokedCertificate := range revocationList.RevokedCertificates {
+			revoked[revokedSerialKey(revocationList.RawIssuer, revokedCertificate.SerialNumber)] = true
+		}
+	}
+	return revoked
+}
+
+func revokedSerialKey(issuer []byte, serial *big.Int) string {
+	return string(issuer) + ":" + serial.String()
+}
@@ -18,60 +18,146 @@
 package server
 
 import (
+	"context"
 	"crypto"
-	cryptoecdsa "crypto/ecdsa"
-	cryptoed25519 "crypto/ed25519"
-	"crypto/elliptic"
-	"crypto/rand"
 	cryptorsa "crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"math/big"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/internal/ginextra"
 	"github.com/hdecarne-github/certd/pkg/certs"
 	"github.com/hdecarne-github/certd/pkg/certs/acme"
+	"github.com/hdecarne-github/certd/pkg/certs/export"
 	x509ext "github.com/hdecarne-github/certd/pkg/certs/extensions"
 	"github.com/hdecarne-github/certd/pkg/certs/local"
 	"github.com/hdecarne-github/certd/pkg/certs/remote"
+	"github.com/hdecarne-github/certd/pkg/entropy"
 	"github.com/hdecarne-github/certd/pkg/keys"
-	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
-	"github.com/hdecarne-github/certd/pkg/keys/ed25519"
-	"github.com/hdecarne-github/certd/pkg/keys/rsa"
+	"github.com/hdecarne-github/certd/pkg/keys/registry"
 )
 
 const errorInvalidRequest = "Invalid reqest"
 const errorInvalidKeyType = "Invalid key type"
-const errorInvalidIssuer = "Invalid issuer"
+const errorKeyPolicyViolation = "Key type violates key policy"
 const errorInvalidDN = "Invalid Distinguished Name"
 const errorInvalidACMECA = "Invalid ACME CA"
 const errorGenerateFailure = "Certificate generation failed"
+const errorACMEPreflightFailure = "ACME preflight check failed"
+const errorChallengeFailed = "Domain validation failed"
+const errorDNSMissing = "Domain DNS record missing"
+const errorRateLimited = "CA rate limit reached"
+const errorCAUnreachable = "CA not reachable"
+const errorCAPolicyViolation = "Request violates CA policy"
+const messageACMERetryScheduled = "ACME rate limit reached; generation scheduled for retry"
 const errorEntryNotFound = "Unknown store entry"
+const errorEntryHasNoKey = "Store entry has no key"
+const errorInvalidExportFormat = "Invalid export format"
+const errorInvalidExtension = "Invalid custom extension"
+const errorInvalidCSR = "Invalid certificate request"
+const errorInvalidPublicKey = "Invalid public key"
+const errorInvalidAttestation = "Invalid attestation"
+const errorExportFailure = "Export failed"
 
+const defaultCRLDetailsLimit = 100
+
+const storeEntriesChunkSize = 100
+
+var oidExtensionReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+// Stream the store entries as JSON.
+//
+// To keep the memory footprint low for large stores, the entries are sent in chunks. The ETag is derived from the
+// store's generation, which changes whenever the store's entries change.
 func (s *server) storeEntries(c *gin.Context) {
-	entries := make([]StoreEntryResponse, 0)
-	storeEntries := s.store.Entries()
-	for {
-		storeEntry := storeEntries.Next()
-		if storeEntry == nil {
-			break
-		}
+	hash := sha256.Sum256([]byte(s.store.Generation()))
+	if ginextra.NotModified(c, ginextra.ContentETag(hash[:])) {
+		return
+	}
+	c.Header("Content-Type", gin.MIMEJSON+"; charset=utf-8")
+	c.Status(http.StatusOK)
+	problems, err := s.writeStoreEntries(c.Request.Context(), c.Writer, c.Writer.Flush)
+	if err != nil {
+		// response is already on its way; only log the failure
+		s.requestLogger(c).Error().Err(err).Msg("Failed to stream store entries")
+		c.Abort()
+		return
+	}
+	for _, problem := range problems {
+		s.requestLogger(c).Warn().Msgf("Skipped broken store entry '%s' (cause: %s)", problem.Name, problem.Problem)
+	}
+}
+
+// Write the store entries in StoreEntriesResponse JSON format to the given writer (invoking flush after each chunk).
+//
+// Broken entries are skipped (to keep the remaining ones accessible) and reported in the problems section. Entries
+// removed while writing are skipped silently.
+func (s *server) writeStoreEntries(ctx context.Context, w io.Writer, flush func()) ([]StoreEntryProblemResponse, error) {
+	_, err := io.WriteString(w, `{"entries":[`)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var problems []StoreEntryProblemResponse
+	count := 0
+	for storeEntry := range certs.StreamEntries(ctx, s.store.Entries()) {
 		storeEntryResponse, err := s.newStoreEntryResponse(storeEntry)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
 		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, err)
-			return
+			problems = append(problems, StoreEntryProblemResponse{Name: storeEntry.Name(), Problem: err.Error()})
+			continue
+		}
+		encoded, err := json.Marshal(storeEntryResponse)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			encoded = append([]byte{','}, encoded...)
+		}
+		_, err = w.Write(encoded)
+		if err != nil {
+			return nil, err
+		}
+		count++
+		if flush != nil && count%storeEntriesChunkSize == 0 {
+			flush()
 		}
-		entries = append(entries, *storeEntryResponse)
 	}
-	response := &StoreEntriesResponse{Entries: entries}
-	c.JSON(http.StatusOK, response)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	_, err = io.WriteString(w, "]")
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		encoded, err := json.Marshal(problems)
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write(append([]byte(`,"problems":`), encoded...))
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = io.WriteString(w, "}")
+	return problems, err
 }
 
 func (s *server) newStoreEntryResponse(storeEntry certs.StoreEntry) (*StoreEntryResponse, error) {
@@ -101,6 +187,15 @@ func (s *server) newStoreEntryResponse(storeEntry certs.StoreEntry) (*StoreEntry
 		ca = false
 		validFrom = time.UnixMilli(0)
 		validTo = validFrom
+	} else if hasRevocationList {
+		revocationList, err := storeEntry.RevocationList()
+		if err != nil {
+			return nil, err
+		}
+		dn = revocationList.Issuer.String()
+		ca = false
+		validFrom = revocationList.ThisUpdate
+		validTo = revocationList.NextUpdate
 	} else {
 		// should never happen
 		return nil, fmt.Errorf("invalid store entry '%s'", storeEntry.Name())
@@ -116,6 +211,12 @@ func (s *server) newStoreEntryResponse(storeEntry certs.StoreEntry) (*StoreEntry
 		ValidFrom: validFrom,
 		ValidTo:   validTo,
 	}
+	attributes, err := storeEntry.Attributes()
+	if err != nil {
+		return nil, err
+	}
+	storeEntryResponse.Kind = string(attributes.Kind)
+	storeEntryResponse.Labels = attributes.Labels
 	return storeEntryResponse, nil
 }
 
@@ -145,38 +246,121 @@ func (s *server) storeEntryDetails(c *gin.Context) {
 		crtDetails.Serial = "0x" + certificate.SerialNumber.Text(16)
 		crtDetails.KeyType = s.getKeyType(certificate.PublicKey)
 		crtDetails.Issuer = certificate.Issuer.String()
+		crtDetails.IssuerEntry = s.resolveIssuerEntry(storeEntry.Name(), certificate)
 		crtDetails.SigAlg = certificate.SignatureAlgorithm.String()
 		crtDetails.Extensions = s.appendExtensionDetails(crtDetails.Extensions, certificate)
 	}
+	csrDetails := StoreEntryCSRDetailsResponse{SANs: make([]string, 0), Extensions: make([][2]string, 0)}
+	if storeEntryResponse.CSR {
+		certificateRequest, err := storeEntry.CertificateRequest()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		csrDetails.KeyType = s.getKeyType(certificateRequest.PublicKey)
+		csrDetails.SigAlg = certificateRequest.SignatureAlgorithm.String()
+		csrDetails.SANs = appendRequestSANs(csrDetails.SANs, certificateRequest)
+		csrDetails.ChallengePassword, err = certs.HasChallengePassword(certificateRequest)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		csrDetails.Extensions = s.appendRequestExtensionDetails(csrDetails.Extensions, certificateRequest)
+	}
+	crlDetails := StoreEntryCRLDetailsResponse{Revoked: make([]StoreEntryCRLRevokedResponse, 0)}
+	if storeEntryResponse.CRL {
+		crlOffset, crlOffsetErr := strconv.Atoi(c.DefaultQuery("crl_offset", "0"))
+		crlLimit, crlLimitErr := strconv.Atoi(c.DefaultQuery("crl_limit", strconv.Itoa(defaultCRLDetailsLimit)))
+		if crlOffsetErr != nil || crlLimitErr != nil || crlOffset < 0 || crlLimit < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+			return
+		}
+		revocationList, err := storeEntry.RevocationList()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		crlDetails.Issuer = revocationList.Issuer.String()
+		crlDetails.ThisUpdate = revocationList.ThisUpdate
+		crlDetails.NextUpdate = revocationList.NextUpdate
+		if revocationList.Number != nil {
+			crlDetails.Number = "0x" + revocationList.Number.Text(16)
+		}
+		crlDetails.SigAlg = revocationList.SignatureAlgorithm.String()
+		crlDetails.RevokedCount = len(revocationList.RevokedCertificates)
+		crlDetails.Revoked = appendRevokedDetails(crlDetails.Revoked, revocationList, crlOffset, crlLimit)
+	}
+	attributes, err := storeEntry.Attributes()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	notes := make([]StoreEntryNoteResponse, 0, len(attributes.Notes))
+	for _, note := range attributes.Notes {
+		notes = append(notes, StoreEntryNoteResponse{Time: note.Time, Author: note.Author, Text: note.Text})
+	}
 	response := &StoreEntryDetailsResponse{
 		StoreEntryResponse: *storeEntryResponse,
 		CRTDetails:         crtDetails,
+		CSRDetails:         csrDetails,
+		CRLDetails:         crlDetails,
+		Notes:              notes,
 	}
-	c.JSON(http.StatusOK, response)
+	ginextra.ConditionalJSON(c, response)
+}
+
+func (s *server) resolveIssuerEntry(name string, certificate *x509.Certificate) string {
+	if certs.IsIssuedBy(certificate, certificate) && certificate.CheckSignatureFrom(certificate) == nil {
+		return name
+	}
+	storeEntries := s.store.Entries()
+	for {
+		storeEntry := storeEntries.Next()
+		if storeEntry == nil {
+			break
+		}
+		if !storeEntry.HasCertificate() {
+			continue
+		}
+		issuerCertificate, err := storeEntry.Certificate()
+		if err != nil || !issuerCertificate.IsCA {
+			continue
+		}
+		if certs.IsIssuedBy(certificate, issuerCertificate) && certificate.CheckSignatureFrom(issuerCertificate) == nil {
+			return storeEntry.Name()
+		}
+	}
+	return ""
+}
+
+// Collect the issuer certificates of the given certificate as far as they are available in the store.
+func (s *server) resolveIssuerChain(name string, certificate *x509.Certificate) []*x509.Certificate {
+	chain := make([]*x509.Certificate, 0)
+	current := certificate
+	for {
+		issuerName := s.resolveIssuerEntry(name, current)
+		if issuerName == "" || issuerName == name {
+			break
+		}
+		issuerEntry, err := s.store.Entry(issuerName)
+		if err != nil {
+			break
+		}
+		issuer, err := issuerEntry.Certificate()
+		if err != nil || issuer == nil || len(chain) >= 10 {
+			break
+		}
+		chain = append(chain, issuer)
+		name = issuerName
+		current = issuer
+	}
+	return chain
 }
 
 func (s *server) appendExtensionDetails(extensions [][2]string, certificate *x509.Certificate) [][2]string {
 	for _, rawExtension := range certificate.Extensions {
-		rawExtensionId := rawExtension.Id.String()
-		switch rawExtensionId {
-		case x509ext.BasicConstraintsExtensionOID:
-			extensions = append(extensions, [2]string{x509ext.BasicConstraintsExtensionName,
-				x509ext.BasicConstraintsString(certificate.IsCA, certificate.MaxPathLen, certificate.MaxPathLenZero)})
-		case x509ext.SubjectKeyIdentifierExtensionOID:
-			extensions = append(extensions, [2]string{x509ext.SubjectKeyIdentifierExtensionName,
-				x509ext.KeyIdentifierString(certificate.SubjectKeyId)})
-		case x509ext.AuthorityKeyIdentifierExtensionOID:
-			extensions = append(extensions, [2]string{x509ext.AuthorityKeyIdentifierExtensionName,
-				x509ext.KeyIdentifierString(certificate.AuthorityKeyId)})
-		case x509ext.KeyUsageExtensionOID:
-			extensions = append(extensions, [2]string{x509ext.KeyUsageExtensionName,
-				x509ext.KeyUsageString(certificate.KeyUsage)})
-		case x509ext.ExtKeyUsageExtensionOID:
-			extensions = append(extensions, [2]string{x509ext.ExtKeyUsageExtensionName,
-				x509ext.ExtKeyUsageString(certificate.ExtKeyUsage, certificate.UnknownExtKeyUsage)})
-		default:
-			extensions = append(extensions, [2]string{rawExtensionId, ""})
-		}
+		name, value := x509ext.Decode(certificate, rawExtension)
+		extensions = append(extensions, [2]string{name, value})
 	}
 	sort.Slice(extensions, func(i, j int) bool {
 		return strings.Compare(extensions[i][0], extensions[j][0]) < 0
@@ -184,26 +368,118 @@ func (s *server) appendExtensionDetails(extensions [][2]string, certificate *x50
 	return extensions
 }
 
-func (s *server) storeCAs(c *gin.Context) {
-	cas := make([]StoreCAResponse, 0)
-	localCA := StoreCAResponse{
-		Name: local.ProviderName,
+func (s *server) appendRequestExtensionDetails(extensions [][2]string, certificateRequest *x509.CertificateRequest) [][2]string {
+	for _, rawExtension := range certificateRequest.Extensions {
+		name, value := x509ext.DecodeRequest(certificateRequest, rawExtension)
+		extensions = append(extensions, [2]string{name, value})
 	}
-	cas = append(cas, localCA)
-	remoteCA := StoreCAResponse{
-		Name: remote.ProviderName,
+	sort.Slice(extensions, func(i, j int) bool {
+		return strings.Compare(extensions[i][0], extensions[j][0]) < 0
+	})
+	return extensions
+}
+
+func appendRevokedDetails(revoked []StoreEntryCRLRevokedResponse, revocationList *x509.RevocationList, offset int, limit int) []StoreEntryCRLRevokedResponse {
+	revokedCertificates := revocationList.RevokedCertificates
+	for i := offset; i < len(revokedCertificates) && i < offset+limit; i++ {
+		revokedCertificate := revokedCertificates[i]
+		revokedDetails := StoreEntryCRLRevokedResponse{
+			Serial:         "0x" + revokedCertificate.SerialNumber.Text(16),
+			RevocationTime: revokedCertificate.RevocationTime,
+		}
+		for _, extension := range revokedCertificate.Extensions {
+			if extension.Id.Equal(oidExtensionReasonCode) {
+				var reason asn1.Enumerated
+				_, err := asn1.Unmarshal(extension.Value, &reason)
+				if err == nil {
+					revokedDetails.Reason = int(reason)
+				}
+			}
+		}
+		revoked = append(revoked, revokedDetails)
 	}
-	cas = append(cas, remoteCA)
-	acmeConfig, err := acme.Load(s.config.ResolveACMEConfig())
+	return revoked
+}
+
+func appendRequestSANs(sans []string, certificateRequest *x509.CertificateRequest) []string {
+	for _, dnsName := range certificateRequest.DNSNames {
+		sans = append(sans, "DNS:"+dnsName)
+	}
+	for _, ipAddress := range certificateRequest.IPAddresses {
+		sans = append(sans, "IP:"+ipAddress.String())
+	}
+	for _, emailAddress := range certificateRequest.EmailAddresses {
+		sans = append(sans, "email:"+emailAddress)
+	}
+	for _, uri := range certificateRequest.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+	return sans
+}
+
+func (s *server) storeEntryExport(c *gin.Context) {
+	name := c.Param("name")
+	exportRequest := &StoreEntryExportRequest{}
+	err := json.NewDecoder(c.Request.Body).Decode(exportRequest)
 	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	storeEntry, err := s.store.Entry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return
+	} else if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
-	for _, acmeProvider := range acmeConfig.Providers {
-		acmeCA := StoreCAResponse{
-			Name: acme.ProviderPrefix + acmeProvider.Name,
+	if !storeEntry.HasKey() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorEntryHasNoKey})
+		return
+	}
+	key, err := storeEntry.Key()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	var exported []byte
+	var exportExtension string
+	switch exportRequest.Format {
+	case export.FormatAge:
+		exported, err = export.KeyAge(key, exportRequest.Recipient)
+		exportExtension = ".key.age"
+	case export.FormatOpenPGP:
+		exported, err = export.KeyOpenPGP(key, exportRequest.Recipient, exportRequest.Fingerprints...)
+		exportExtension = ".key.asc"
+	case export.FormatPKCS8:
+		exported, err = export.KeyPKCS8(key, exportRequest.Password)
+		exportExtension = ".key"
+	case export.FormatPKCS12:
+		certificate, certificateErr := storeEntry.Certificate()
+		if certificateErr != nil || certificate == nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorExportFailure})
+			return
+		}
+		exported, err = export.PKCS12(key, certificate, s.resolveIssuerChain(name, certificate), exportRequest.Password)
+		exportExtension = ".p12"
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidExportFormat})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorExportFailure})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s%s\"", name, exportExtension))
+	c.Data(http.StatusOK, "application/octet-stream", exported)
+}
+
+func (s *server) storeCAs(c *gin.Context) {
+	cas := make([]StoreCAResponse, 0)
+	for _, provider := range s.certificateProviders() {
+		for _, ca := range provider.CAs() {
+			cas = append(cas, StoreCAResponse{Name: ca})
 		}
-		cas = append(cas, acmeCA)
 	}
 	response := &StoreCAsResponse{
 		CAs: cas,
@@ -221,10 +497,10 @@ func (s *server) storeLocalIssuers(c *gin.Context) {
 		}
 		certificate, err := storeEntry.Certificate()
 		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, err)
-			return
+			s.requestLogger(c).Warn().Err(err).Msgf("Skipping broken store entry '%s'", storeEntry.Name())
+			continue
 		}
-		if certificate != nil && certificate.IsCA && storeEntry.HasKey() {
+		if certificate != nil && certificate.IsCA && s.hasSigner(storeEntry) {
 			issuer := StoreLocalIssuerResponse{
 				Name: storeEntry.Name(),
 			}
@@ -239,99 +515,295 @@ func (s *server) storeLocalIssuers(c *gin.Context) {
 
 func (s *server) storeLocalGenerate(c *gin.Context) {
 	generateLocal := &StoreGenerateLocalRequest{}
-	err := json.NewDecoder(c.Request.Body).Decode(generateLocal)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+	if !s.decodeValidatedRequest(c, generateLocal) {
 		return
 	}
-	keyFactory, err := s.getKeyFactory(generateLocal.KeyType)
+	var keyFactory keys.KeyPairFactory
+	var publicKey crypto.PublicKey
+	var err error
+	if generateLocal.PublicKey != "" {
+		publicKey, err = certs.DecodePublicKey([]byte(generateLocal.PublicKey))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidPublicKey})
+			return
+		}
+		err = s.config().KeyPolicy.Policy().CheckPublicKey(publicKey)
+	} else if generateLocal.KeyType == pivKeyType {
+		keyFactory, err = s.getPIVKeyFactory()
+	} else {
+		keyFactory, err = s.getKeyFactory(generateLocal.KeyType, false)
+	}
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidKeyType})
+		s.abortKeyTypeError(c, err)
 		return
 	}
 	issuer := generateLocal.Issuer
 	var parent *x509.Certificate
-	var signer crypto.PrivateKey
+	var signer crypto.Signer
 	if issuer != "" {
 		parent, signer, err = s.resolveIssuer(issuer)
 		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, err)
+			s.abortIssuerError(c, err)
 			return
 		}
-		if parent == nil || signer == nil {
-			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidIssuer})
+	}
+	localConfig := &s.config().Local
+	resolvedDN := generateLocal.DN
+	if resolvedDN == "" && localConfig.DNTemplate != "" {
+		resolvedDN, err = certs.ResolveDNTemplate(localConfig.DNTemplate, generateLocal.Name)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidDN})
 			return
 		}
 	}
-	dn, err := certs.ParseDN(generateLocal.DN)
+	rdns, err := certs.ParseRDNSequence(resolvedDN)
+	var rawSubject []byte
+	if err == nil {
+		rawSubject, err = certs.MarshalRDNSequence(localConfig.ApplyDNDefaults(rdns))
+	}
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidDN})
 		return
 	}
-	serialNumber, err := s.generateSerialNumber()
+	serialNumber, err := s.generateSerialNumber(c.Request.Context())
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	notBefore, notAfter := s.resolveValidity(c, generateLocal.ValidFrom, generateLocal.ValidTo)
 	template := &x509.Certificate{
 		Version:      3,
 		SerialNumber: serialNumber,
-		Subject:      *dn,
-		NotBefore:    generateLocal.ValidFrom,
-		NotAfter:     generateLocal.ValidTo,
+		RawSubject:   rawSubject,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
 	}
 	template.KeyUsage = generateLocal.KeyUsage.toKeyUsage()
 	template.ExtKeyUsage = generateLocal.ExtKeyUsage.toExtKeyUsage()
 	generateLocal.BasicConstraint.applyToCertificate(template)
+	template.ExtraExtensions, err = toExtraExtensions(generateLocal.CustomExtensions)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidExtension})
+		return
+	}
+	if parent != nil {
+		err = checkIssuerPathLen(issuer, parent, template)
+		if err != nil {
+			s.abortIssuerError(c, err)
+			return
+		}
+	}
+	errorMessage, err := s.applyLocalProfile(template, generateLocal)
+	if err != nil {
+		s.requestLogger(c).Warn().Err(err).Msg("Rejecting certificate profile")
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorMessage})
+		return
+	}
+	err = s.checkNamePolicy(issuer, template.DNSNames, template.IPAddresses, template.EmailAddresses, template.URIs)
+	if err != nil {
+		s.requestLogger(c).Warn().Err(err).Msg("Rejecting certificate names")
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorNamePolicyViolation})
+		return
+	}
+	if publicKey != nil {
+		s.storeLocalGeneratePublicKey(c, generateLocal.Name, local.NewLocalPublicKeyCertificateFactory(template, publicKey, parent, signer))
+		return
+	}
 	localFactory := local.NewLocalCertificateFactory(template, keyFactory, parent, signer)
-	_, err = s.store.CreateCertificate(generateLocal.Name, localFactory)
+	if generateLocal.KeyType == pivKeyType {
+		s.storeLocalGeneratePIV(c, generateLocal.Name, localFactory)
+		return
+	}
+	if generateLocal.NoStoreKey {
+		_, key, err := s.requestStore(c).CreateCertificateWithoutKey(c.Request.Context(), generateLocal.Name, localFactory)
+		if err != nil {
+			s.abortGenerateError(c, err)
+			return
+		}
+		keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		response := &StoreGenerateLocalResponse{
+			Key: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})),
+		}
+		s.publishEntry(generateLocal.Name)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	_, err = s.requestStore(c).CreateCertificate(c.Request.Context(), generateLocal.Name, localFactory)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorGenerateFailure})
+		s.abortGenerateError(c, err)
 		return
 	}
+	s.publishEntry(generateLocal.Name)
 	c.Status(http.StatusOK)
 }
 
-func (s *server) resolveIssuer(issuer string) (*x509.Certificate, crypto.PrivateKey, error) {
-	issuerStoreEntry, err := s.store.Entry(issuer)
+// Generate a certificate for an externally generated key (resulting in a certificate only entry).
+func (s *server) storeLocalGeneratePublicKey(c *gin.Context, name string, localFactory certs.CertificateFactory) {
+	_, _, err := s.requestStore(c).CreateCertificateWithoutKey(c.Request.Context(), name, localFactory)
 	if err != nil {
-		return nil, nil, nil
+		s.abortGenerateError(c, err)
+		return
 	}
-	parent, err := issuerStoreEntry.Certificate()
-	if err != nil || parent == nil {
-		return nil, nil, err
+	s.publishEntry(name)
+	c.Status(http.StatusOK)
+}
+
+// Generate a certificate whose key is held by the configured PIV token.
+func (s *server) storeLocalGeneratePIV(c *gin.Context, name string, localFactory certs.CertificateFactory) {
+	store := s.requestStore(c)
+	_, _, err := store.CreateCertificateWithoutKey(c.Request.Context(), name, localFactory)
+	if err != nil {
+		s.abortGenerateError(c, err)
+		return
 	}
-	signer, err := issuerStoreEntry.Key()
-	if err != nil || signer == nil {
-		return nil, nil, err
+	err = store.UpdateAttributes(name, func(attributes *certs.StoreEntryAttributes) {
+		attributes.KeyRef = s.pivKeyRef()
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
 	}
-	return parent, signer, nil
+	s.publishEntry(name)
+	c.Status(http.StatusOK)
+}
+
+func (s *server) storeLocalSign(c *gin.Context) {
+	signLocal := &StoreSignLocalRequest{}
+	if !s.decodeValidatedRequest(c, signLocal) {
+		return
+	}
+	csrBlock, _ := pem.Decode([]byte(signLocal.CSR))
+	if csrBlock == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidCSR})
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(csrBlock.Bytes)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidCSR})
+		return
+	}
+	// proof of possession
+	err = csr.CheckSignature()
+	if err != nil {
+		s.requestLogger(c).Warn().Err(err).Msg("Rejecting certificate request with invalid signature")
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidCSR})
+		return
+	}
+	err = s.config().KeyPolicy.Policy().CheckPublicKey(csr.PublicKey)
+	if err != nil {
+		s.abortKeyTypeError(c, err)
+		return
+	}
+	var attestation *certs.StoreEntryAttestation
+	if signLocal.Attestation != "" {
+		attestation, err = s.verifyAttestation(csr, signLocal.Attestation)
+		if err != nil {
+			s.requestLogger(c).Warn().Err(err).Msg("Rejecting certificate request with invalid attestation")
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidAttestation})
+			return
+		}
+	}
+	err = s.checkNamePolicy(signLocal.Issuer, csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs)
+	if err != nil {
+		s.requestLogger(c).Warn().Err(err).Msg("Rejecting certificate request names")
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorNamePolicyViolation})
+		return
+	}
+	parent, signer, err := s.resolveIssuer(signLocal.Issuer)
+	if err != nil {
+		s.abortIssuerError(c, err)
+		return
+	}
+	serialNumber, err := s.generateSerialNumber(c.Request.Context())
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	notBefore, notAfter := s.resolveValidity(c, signLocal.ValidFrom, signLocal.ValidTo)
+	template := &x509.Certificate{
+		Version:      3,
+		SerialNumber: serialNumber,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	template.KeyUsage = signLocal.KeyUsage.toKeyUsage()
+	template.ExtKeyUsage = signLocal.ExtKeyUsage.toExtKeyUsage()
+	signLocal.BasicConstraint.applyToCertificate(template)
+	err = checkIssuerPathLen(signLocal.Issuer, parent, template)
+	if err != nil {
+		s.abortIssuerError(c, err)
+		return
+	}
+	var localFactory certs.CertificateFactory = local.NewLocalCSRCertificateFactory(template, csr, parent, signer)
+	if attestation != nil {
+		localFactory = &attestedCertificateFactory{CertificateFactory: localFactory, attestation: attestation}
+	}
+	_, _, err = s.requestStore(c).CreateCertificateWithoutKey(c.Request.Context(), signLocal.Name, localFactory)
+	if err != nil {
+		s.abortGenerateError(c, err)
+		return
+	}
+	s.publishEntry(signLocal.Name)
+	c.Status(http.StatusOK)
+}
+
+// Records the verified attestation of the signed certificate request as part of the created entry.
+type attestedCertificateFactory struct {
+	certs.CertificateFactory
+	attestation *certs.StoreEntryAttestation
+}
+
+func (factory *attestedCertificateFactory) ApplyAttributes(attributes *certs.StoreEntryAttributes) {
+	attributes.Attestation = factory.attestation
+}
+
+func (s *server) verifyAttestation(csr *x509.CertificateRequest, attestationPEM string) (*certs.StoreEntryAttestation, error) {
+	attestation, err := certs.DecodeCertificates([]byte(attestationPEM))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode attestation (cause: %w)", err)
+	}
+	var roots *x509.CertPool
+	attestationRootsFile := s.config().ResolveAttestationRoots()
+	if attestationRootsFile != "" {
+		attestationRoots, err := certs.ReadCertificates(attestationRootsFile)
+		if err != nil {
+			return nil, err
+		}
+		roots = x509.NewCertPool()
+		for _, attestationRoot := range attestationRoots {
+			roots.AddCert(attestationRoot)
+		}
+	}
+	return certs.VerifyAttestation(csr.PublicKey, attestation, roots)
 }
 
 func (s *server) storeRemoteGenerate(c *gin.Context) {
 	generateRemote := &StoreGenerateRemoteRequest{}
-	err := json.NewDecoder(c.Request.Body).Decode(generateRemote)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+	if !s.decodeValidatedRequest(c, generateRemote) {
+		return
 	}
-	keyFactory, err := s.getKeyFactory(generateRemote.KeyType)
+	keyFactory, err := s.getKeyFactory(generateRemote.KeyType, false)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidKeyType})
+		s.abortKeyTypeError(c, err)
 		return
 	}
-	dn, err := certs.ParseDN(generateRemote.DN)
+	rawSubject, err := certs.ParseRawDN(generateRemote.DN)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidDN})
 		return
 	}
 	template := &x509.CertificateRequest{
-		Version: 3,
-		Subject: *dn,
+		Version:    3,
+		RawSubject: rawSubject,
 	}
 	remoteFactory := remote.NewLocalCertificateRequestFactory(template, keyFactory)
-	_, err = s.store.CreateCertificateRequest(generateRemote.Name, remoteFactory)
+	_, err = s.requestStore(c).CreateCertificateRequest(c.Request.Context(), generateRemote.Name, remoteFactory)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorGenerateFailure})
+		s.abortGenerateError(c, err)
 		return
 	}
 	c.Status(http.StatusOK)
@@ -339,69 +811,120 @@ func (s *server) storeRemoteGenerate(c *gin.Context) {
 
 func (s *server) storeACMEGenerate(c *gin.Context) {
 	generateACME := &StoreGenerateACMERequest{}
-	err := json.NewDecoder(c.Request.Body).Decode(generateACME)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+	if !s.decodeValidatedRequest(c, generateACME) {
+		return
 	}
-	keyFactory, err := s.getKeyFactory(generateACME.KeyType)
+	keyFactory, err := s.getKeyFactory(generateACME.KeyType, true)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidKeyType})
+		s.abortKeyTypeError(c, err)
 		return
 	}
-	acmeConfig := s.config.ResolveACMEConfig()
-	acmeProvider, err := s.getACMEProvider(generateACME.CA)
+	_, err = s.getACMEProvider(generateACME.CA)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidACMECA})
 		return
 	}
-	acmeFactory := acme.NewACMECertificateFactory(generateACME.Domains, acmeConfig, acmeProvider, keyFactory)
-	_, err = s.store.CreateCertificate(generateACME.Name, acmeFactory)
+	provider := certs.FindProvider(s.certificateProviders(), generateACME.CA)
+	if provider == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidACMECA})
+		return
+	}
+	acmeFactory, err := provider.NewCertificateFactory(c.Request.Context(), generateACME.CA, &certs.ProviderRequest{Domains: generateACME.Domains, KeyFactory: keyFactory})
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorGenerateFailure})
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidACMECA})
 		return
 	}
-	c.Status(http.StatusOK)
+	s.createProviderCertificate(c, generateACME.Name, acmeFactory, false)
+}
+
+func (s *server) scheduleACMERetry(name string, factory certs.CertificateFactory, at time.Time, attempt int) {
+	s.scheduler.ScheduleOnce("acme_retry:"+name, at, func(ctx context.Context) {
+		s.logger.Info().Msgf("Retrying ACME generation of '%s' (attempt: %d)...", name, attempt)
+		_, err := s.store.CreateCertificate(ctx, name, factory)
+		var rateLimitErr *acme.RateLimitError
+		if errors.As(err, &rateLimitErr) && attempt < s.acmeConfig().Retry.MaxScheduled {
+			s.logger.Warn().Err(err).Msgf("ACME generation of '%s' still rate limited", name)
+			s.scheduleACMERetry(name, factory, rateLimitErr.RetryAfter, attempt+1)
+			return
+		} else if err != nil {
+			s.logger.Error().Err(err).Msgf("Scheduled ACME generation of '%s' failed (cause: %v)", name, err)
+			return
+		}
+		s.publishEntry(name)
+	})
 }
 
-func (s *server) generateSerialNumber() (*big.Int, error) {
-	limit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serial, err := rand.Int(rand.Reader, limit)
+func (s *server) generateSerialNumber(ctx context.Context) (*big.Int, error) {
+	serial, err := entropy.SerialNumber(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate serial number (cause: %w)", err)
 	}
 	return serial, nil
 }
 
-func (s *server) getKeyFactory(keyType string) (keys.KeyPairFactory, error) {
-	switch keyType {
-	case "ECDSA P-224":
-		return ecdsa.NewECDSAKeyPairFactory(elliptic.P224()), nil
-	case "ECDSA P-256":
-		return ecdsa.NewECDSAKeyPairFactory(elliptic.P256()), nil
-	case "ECDSA P-384":
-		return ecdsa.NewECDSAKeyPairFactory(elliptic.P384()), nil
-	case "ECDSA P-521":
-		return ecdsa.NewECDSAKeyPairFactory(elliptic.P521()), nil
-	case "ED25519":
-		return ed25519.NewED25519KeyPairFactory(), nil
-	case "RSA 2048":
-		return rsa.NewRSAKeyPairFactory(2048), nil
-	case "RSA 3072":
-		return rsa.NewRSAKeyPairFactory(3072), nil
-	case "RSA 4096":
-		return rsa.NewRSAKeyPairFactory(4092), nil
-	}
-	return nil, fmt.Errorf("unrecognized key type '%s'", keyType)
+func (s *server) resolveValidity(c *gin.Context, validFrom time.Time, validTo time.Time) (time.Time, time.Time) {
+	notBefore, notAfter, warnings := local.ResolveValidity(s.clock, s.config().Local.NotBeforeSkew, validFrom, validTo)
+	for _, warning := range warnings {
+		s.requestLogger(c).Warn().Msgf("Odd validity period requested (%s)", warning)
+	}
+	return notBefore, notAfter
 }
 
-func (s *server) getKeyType(publicKey any) string {
-	ecdsaPublicKey, ok := publicKey.(*cryptoecdsa.PublicKey)
-	if ok {
-		return fmt.Sprintf("ECSDA P-%d", ecdsaPublicKey.Curve.Params().BitSize)
+// Get the key factory for the given key type.
+//
+// The configured key policy is applied to the key type (acme indicates whether the key is requested for an ACME CA).
+func (s *server) getKeyFactory(keyType string, acme bool) (keys.KeyPairFactory, error) {
+	keyFactory, err := registry.FactoryFor(keyType)
+	if err != nil {
+		return nil, err
 	}
-	_, ok = publicKey.(cryptoed25519.PublicKey)
-	if ok {
-		return "ED25519"
+	err = s.config().KeyPolicy.Policy().Check(keyFactory, acme)
+	if err != nil {
+		return nil, err
+	}
+	return keyFactory, nil
+}
+
+func (s *server) abortKeyTypeError(c *gin.Context, err error) {
+	var policyViolation *registry.PolicyViolationError
+	if errors.As(err, &policyViolation) {
+		s.requestLogger(c).Warn().Err(err).Msg("Rejecting key type")
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorKeyPolicyViolation, Details: policyViolation.Reason, Code: KeyPolicyViolation})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidKeyType})
+}
+
+type providerErrorResponse struct {
+	status  int
+	message string
+}
+
+var providerErrorResponses = map[certs.ProviderErrorKind]providerErrorResponse{
+	certs.ProviderChallengeFailed: {status: http.StatusUnprocessableEntity, message: errorChallengeFailed},
+	certs.ProviderDNSMissing:      {status: http.StatusUnprocessableEntity, message: errorDNSMissing},
+	certs.ProviderRateLimited:     {status: http.StatusTooManyRequests, message: errorRateLimited},
+	certs.ProviderCAUnreachable:   {status: http.StatusBadGateway, message: errorCAUnreachable},
+	certs.ProviderPolicyViolation: {status: http.StatusUnprocessableEntity, message: errorCAPolicyViolation},
+}
+
+func (s *server) abortGenerateError(c *gin.Context, err error) {
+	c.Error(err)
+	providerErr := certs.ClassifyProviderError(err)
+	if providerErr != nil {
+		response, ok := providerErrorResponses[providerErr.Kind]
+		if ok {
+			c.AbortWithStatusJSON(response.status, &ServerErrorResponse{Message: response.message, Details: providerErr.Error(), Code: string(providerErr.Kind), Hint: providerErr.Hint})
+			return
+		}
+	}
+	c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorGenerateFailure})
+}
+
+func (s *server) getKeyType(publicKey any) string {
+	keyFactory, err := registry.FactoryForPublicKey(publicKey)
+	if err == nil {
+		return keyFactory.Name()
 	}
 	rsaPublicKey, ok := publicKey.(*cryptorsa.PublicKey)
 	if ok {
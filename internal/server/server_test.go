@@ -18,73 +18,545 @@
 package server_test
 
 import (
+	"archive/zip"
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"math/big"
+	"mime"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
-	"github.com/hdecarne-github/certd/internal/certd"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/hdecarne-github/certd/internal/acmetest"
+	"github.com/hdecarne-github/certd/internal/config"
 	"github.com/hdecarne-github/certd/internal/server"
+	"github.com/hdecarne-github/certd/internal/state"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	_ "github.com/hdecarne-github/certd/pkg/certs/vaultpki"
+	"github.com/hdecarne-github/certd/pkg/clock"
 	"github.com/hdecarne-github/certd/pkg/keys/registry"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
-const aboutServiceUrl = "http://localhost:10509/api/about"
-const storeEntriesServiceUrl = "http://localhost:10509/api/store/entries"
-const storeEntryDetailsServiceUrlPattern = "http://localhost:10509/api/store/entry/details/%s"
-const storeCAsServiceUrl = "http://localhost:10509/api/store/cas"
-const storeLocalIssuersServiceUrl = "http://localhost:10509/api/store/local/issuers"
-const storeLocalGenerateServiceUrl = "http://localhost:10509/api/store/local/generate"
-const storeRemoteGenerateServiceUrl = "http://localhost:10509/api/store/remote/generate"
-const storeACMEGenerateServiceUrl = "http://localhost:10509/api/store/acme/generate"
-const shutdownServiceUrl = "http://localhost:10509/api/shutdown"
+const aboutServiceUrl = "/api/about"
+const storeEntriesServiceUrl = "/api/store/entries"
+const storeEntryDetailsServiceUrlPattern = "/api/store/entry/details/%s"
+const storeEntryExportServiceUrlPattern = "/api/store/entry/export/%s"
+const storeEntryLabelsServiceUrlPattern = "/api/store/entry/labels/%s"
+const storeEntryNotesServiceUrlPattern = "/api/store/entry/notes/%s"
+const storeEntryDownloadURLServiceUrlPattern = "/api/store/entry/download-url/%s"
+const storeExportServiceUrl = "/api/store/export"
+const storeEntrySignServiceUrlPattern = "/api/store/entry/sign/%s"
+const storeEntrySPKIServiceUrlPattern = "/api/store/entry/spki/%s"
+const storeEntryTextServiceUrlPattern = "/api/store/entry/text/%s"
+const storeEntryP7BServiceUrlPattern = "/api/store/entry/p7b/%s"
+const storeEntryOCSPStapleServiceUrlPattern = "/api/store/entry/ocsp-staple/%s"
+const storeP7BImportServiceUrl = "/api/store/p7b/import"
+const storeStatsServiceUrl = "/api/store/stats"
+const storeCAsServiceUrl = "/api/store/cas"
+const storeGenerateServiceUrl = "/api/store/generate"
+const storeLocalIssuersServiceUrl = "/api/store/local/issuers"
+const storeLocalIssuerServiceUrlPattern = "/api/store/local/issuers/%s"
+const storeLocalGenerateServiceUrl = "/api/store/local/generate"
+const storeLocalSignServiceUrl = "/api/store/local/sign"
+const storeRemoteGenerateServiceUrl = "/api/store/remote/generate"
+const storeACMEProvidersServiceUrl = "/api/store/acme/providers"
+const storeACMEGenerateServiceUrl = "/api/store/acme/generate"
+const verifyServiceUrl = "/api/verify"
+const diffServiceUrl = "/api/diff"
+const storeEntryCompareServiceUrlPattern = "/api/store/entry/compare?left=%s&right=%s"
+const oidServiceUrlPattern = "/api/oids/%s"
+const metricsServiceUrl = "/metrics"
+const ctFindingsServiceUrl = "/api/ct/findings"
+const storeTrustServiceUrl = "/api/store/trust"
+const storeTrustImportServiceUrl = "/api/store/trust/import"
+const storeEntryArchiveServiceUrlPattern = "/api/store/entry/archive/%s"
+const storeArchiveServiceUrl = "/api/store/archive"
+const storeArchiveRestoreServiceUrlPattern = "/api/store/archive/restore/%s"
+const storeArchivePurgeServiceUrlPattern = "/api/store/archive/%s"
+const adminDiagServiceUrl = "/api/admin/diag"
+const adminStoreGCServiceUrl = "/api/admin/store/gc"
+const adminStoreRenewServiceUrl = "/api/admin/store/renew"
+const adminStoreRevokeServiceUrl = "/api/admin/store/revoke"
+const adminStoreCompromiseServiceUrlPattern = "/api/admin/store/compromise/%s"
+const adminJobServiceUrlPattern = "/api/admin/jobs/%s"
+const adminACMERolloverServiceUrlPattern = "/api/admin/acme/rollover/%s"
+const adminACMEDeactivateServiceUrlPattern = "/api/admin/acme/deactivate/%s"
+const pprofServiceUrl = "/debug/pprof/cmdline"
+const shutdownServiceUrl = "/api/shutdown"
 
 func TestServer(t *testing.T) {
-	// Accept test CA
-	os.Setenv("LEGO_CA_CERTIFICATES", "../../pkg/certs/acme/testdata/certs/pebble.minica.pem")
+	acmeServer, err := acmetest.Start(&acmetest.Config{HTTPPort: 5004, TLSPort: 5003})
+	require.NoError(t, err)
+	defer acmeServer.Close()
+	t.Setenv("CERTD_TEST_ACME_URL", acmeServer.DirectoryURL())
 
 	workDir, err := os.MkdirTemp("", "certd")
 	require.NoError(t, err)
 	defer os.RemoveAll(workDir)
 	storePath := filepath.Join(workDir, "store")
 	statePath := filepath.Join(workDir, "state")
-	var shutdown sync.WaitGroup
-	runServer(t, storePath, statePath, &shutdown)
-	client := &http.Client{}
+	vault := newTestVault(t)
+	defer vault.Close()
+	t.Setenv("CERTD_TEST_VAULT_ADDRESS", vault.URL)
+	ts := startTestServer(t, storePath, statePath)
+	client := ts.Client()
 	testAbout(t, client)
+	testOIDs(t, client)
+	testRequestID(t, client)
 	testStoreCAs(t, client)
+	testStoreGenerate(t, client)
+	testStoreGenerateValidation(t, client)
 	for i, keyProvider := range registry.KeyProviders() {
 		for j, factory := range registry.StandardKeys(keyProvider) {
 			testStoreGenerateLocal1(t, client, factory.Name(), (i*10)+(2*j))
 			testStoreGenerateLocal2(t, client, factory.Name(), (i*10)+(2*j)+1)
 		}
 	}
+	testStoreGenerateLocalNoStoreKey(t, client)
+	testStoreGenerateLocalPIV(t, client)
+	testStoreSignLocal(t, client)
+	testStoreGenerateLocalPublicKey(t, client)
+	testStoreLocalIssuerErrors(t, client)
+	testStoreGenerateLocalSMIME(t, client)
+	testTSA(t, client)
+	testStoreEntrySign(t, client)
+	testStoreP7B(t, client)
+	testStoreEntryOCSPStaple(t, client)
+	testVerify(t, client)
+	testDiff(t, client)
+	testStoreEntryCompare(t, client)
+	testMetrics(t, client)
+	testCTFindings(t, client)
+	testStoreTrust(t, client)
 	testStoreGenerateRemote(t, client)
+	testStoreEntryText(t, client)
+	testStoreEntrySPKI(t, client)
+	testStoreEntryExport(t, client)
+	testStoreExport(t, client)
+	testStoreEntryNotes(t, client)
+	testStoreEntryDownload(t, client)
+	testConditionalRequests(t, client)
+	testStoreEntryCRLDetails(t, client, storePath)
+	testStoreStats(t, client)
+	testStoreArchive(t, client)
+	testReload(t, ts, client)
+	testAdmin(t, client)
+	testAdminStoreGC(t, client, storePath)
+	testAdminStoreJobs(t, client)
+	testAdminStoreCompromise(t, client)
+	testStoreACMEProviders(t, client)
 	testStoreGenerateACME(t, client)
 	testStoreEntries(t, client)
 	testShutdown(t, client)
-	shutdown.Wait()
-	runServer(t, storePath, statePath, &shutdown)
+	ts.Close()
+	writeBrokenStoreEntry(t, storePath, "broken0")
+	ts = startTestServer(t, storePath, statePath)
+	client = ts.Client()
 	testStoreEntries(t, client)
+	testStoreEntriesProblems(t, client, "broken0")
 	testStoreEntryDetails(t, client)
 	testStoreLocalIssuers(t, client)
 	testShutdown(t, client)
-	shutdown.Wait()
+	ts.Close()
 }
 
-func runServer(t *testing.T, storePath string, statePath string, shutdown *sync.WaitGroup) {
-	shutdown.Add(1)
-	go func() {
-		os.Args = []string{"certd", "server", "--config=testdata/certd-test.yaml", "--store-path=" + storePath, "--state-path=" + statePath}
-		err := certd.Run(nil)
-		require.NoError(t, err)
-		shutdown.Done()
-	}()
+const bootstrapStoreEntriesServiceUrl = "/api/store/entries"
+const bootstrapShutdownServiceUrl = "/api/shutdown"
+
+func TestBootstrap(t *testing.T) {
+	workDir, err := os.MkdirTemp("", "certd")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+	loaded, err := config.Load("testdata/certd-bootstrap.yaml")
+	require.NoError(t, err)
+	loaded.Server.StorePath = filepath.Join(workDir, "store")
+	loaded.Server.StatePath = filepath.Join(workDir, "state")
+	ts := server.NewTestServer()
+	err = ts.Start(&loaded.Server)
+	require.NoError(t, err)
+	defer ts.Close()
+	// the bootstrapped server certificate is issued for localhost and not trusted by the test server's client
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp := doGet(t, client, ts.URL+bootstrapStoreEntriesServiceUrl)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotNil(t, resp.TLS)
+	serverCertificate := resp.TLS.PeerCertificates[0]
+	require.Equal(t, "CN=localhost,O=certd", serverCertificate.Subject.String())
+	require.Equal(t, "CN=certd Root CA", serverCertificate.Issuer.String())
+	require.Equal(t, []string{"localhost"}, serverCertificate.DNSNames)
+	storeEntries := &server.StoreEntriesResponse{}
+	decodeJsonResponse(t, resp, storeEntries)
+	require.Equal(t, 2, len(storeEntries.Entries))
+	require.Equal(t, "certd-ca", storeEntries.Entries[0].Name)
+	require.True(t, storeEntries.Entries[0].CA)
+	require.Equal(t, "certd-server", storeEntries.Entries[1].Name)
+	require.True(t, storeEntries.Entries[1].Key)
+	resp = doGet(t, client, ts.URL+bootstrapShutdownServiceUrl)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestParallelTestServers(t *testing.T) {
+	var serialsLock sync.Mutex
+	serials := make([]string, 0, 2)
+	t.Run("group", func(t *testing.T) {
+		for i, now := range []time.Time{time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)} {
+			now := now
+			t.Run(fmt.Sprintf("instance%d", i), func(t *testing.T) {
+				t.Parallel()
+				serial := testMemStoreTestServer(t, now)
+				serialsLock.Lock()
+				defer serialsLock.Unlock()
+				serials = append(serials, serial)
+			})
+		}
+	})
+	// both instances draw the same serial numbers from their own deterministic random source
+	require.Len(t, serials, 2)
+	require.Equal(t, serials[0], serials[1])
+}
+
+func testMemStoreTestServer(t *testing.T, now time.Time) string {
+	loaded, err := config.Load("testdata/certd-bootstrap.yaml")
+	require.NoError(t, err)
+	loaded.Server.StorePath = ""
+	loaded.Server.StatePath = ""
+	loaded.Server.Admin.Token = testAdminToken
+	ts := server.NewTestServer(server.WithClock(clock.Fixed(now)), server.WithSeed("seed"))
+	err = ts.Start(&loaded.Server)
+	require.NoError(t, err)
+	defer ts.Close()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp := doGet(t, client, ts.URL+fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "certd-ca"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	storeEntryDetails := &server.StoreEntryDetailsResponse{}
+	decodeJsonResponse(t, resp, storeEntryDetails)
+	require.False(t, storeEntryDetails.ValidFrom.After(now))
+	require.True(t, storeEntryDetails.ValidFrom.After(now.Add(-time.Hour)))
+	// FS store features are not available for the in-memory store
+	resp = doAdminGet(t, client, ts.URL+adminStoreGCServiceUrl, testAdminToken)
+	require.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	return storeEntryDetails.CRTDetails.Serial
+}
+
+func TestPIVKeyPolicy(t *testing.T) {
+	loaded, err := config.Load("testdata/certd-bootstrap.yaml")
+	require.NoError(t, err)
+	loaded.Server.StorePath = ""
+	loaded.Server.StatePath = ""
+	loaded.Server.PIV.Slot = "9c"
+	loaded.Server.PIV.Algorithm = "RSA2048"
+	loaded.Server.KeyPolicy.MinRSABits = 3072
+	ts := server.NewTestServer()
+	err = ts.Start(&loaded.Server)
+	require.NoError(t, err)
+	defer ts.Close()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	generateLocal := &server.StoreGenerateLocalRequest{
+		StoreGenerateRequest: server.StoreGenerateRequest{
+			Name: "piv",
+			CA:   "Local",
+		},
+		DN:        fmt.Sprintf(dnFormat, "piv"),
+		KeyType:   "PIV",
+		ValidFrom: time.Now(),
+		ValidTo:   time.Now().Add(24 * time.Hour),
+	}
+	// the configured PIV algorithm is subject to the key policy
+	resp := doPut(t, client, ts.URL+storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorResponse := &server.ServerErrorResponse{}
+	decodeJsonResponse(t, resp, errorResponse)
+	require.Equal(t, server.KeyPolicyViolation, errorResponse.Code)
+}
+
+func TestTestServerStartFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+	loaded, err := config.Load("testdata/certd-bootstrap.yaml")
+	require.NoError(t, err)
+	loaded.Server.StorePath = ""
+	loaded.Server.StatePath = ""
+	loaded.Server.Bootstrap.Enabled = false
+	ts := server.NewTestServer()
+	err = ts.Start(&loaded.Server)
+	require.ErrorContains(t, err, "missing TLS server entry")
+	ts.Close()
+	// the temporary state directory has been removed again
+	tmpEntries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	require.Empty(t, tmpEntries)
+}
+
+func startTestServer(t testing.TB, storePath string, statePath string) *server.TestServer {
+	ts := server.NewTestServer()
+	t.Setenv("CERTD_TEST_TSA_URL", "http://"+ts.Listener.Addr().String()+"/tsa")
+	loaded, err := config.Load("testdata/certd-test.yaml")
+	require.NoError(t, err)
+	loaded.Server.StorePath = storePath
+	loaded.Server.StatePath = statePath
+	err = ts.Start(&loaded.Server)
+	require.NoError(t, err)
+	return ts
+}
+
+func testReload(t *testing.T, ts *server.TestServer, client *http.Client) {
+	err := ts.Reload()
+	require.NoError(t, err)
+	testAbout(t, client)
+	testStoreCAs(t, client)
+}
+
+func testRequestID(t *testing.T, client *http.Client) {
+	resp := doGet(t, client, aboutServiceUrl)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, resp.Header.Get("X-Request-ID"), 32)
+	req, err := http.NewRequest(http.MethodGet, aboutServiceUrl, nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Request-ID", "test-request-1")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "test-request-1", resp.Header.Get("X-Request-ID"))
+	req.Header.Set("X-Request-ID", "invalid request id")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, resp.Header.Get("X-Request-ID"), 32)
+}
+
+const testAdminToken = "test-admin-token"
+
+func testAdmin(t *testing.T, client *http.Client) {
+	resp := doGet(t, client, adminDiagServiceUrl)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = doAdminGet(t, client, adminDiagServiceUrl, "invalid")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = doAdminGet(t, client, adminDiagServiceUrl, testAdminToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	diag := &server.AdminDiagResponse{}
+	decodeJsonResponse(t, resp, diag)
+	require.Greater(t, diag.Goroutines, 0)
+	require.Greater(t, diag.Store.Entries, 0)
+	require.Contains(t, diag.Store.Caches, "certificates")
+	resp = doGet(t, client, pprofServiceUrl)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = doAdminGet(t, client, pprofServiceUrl, testAdminToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doPost(t, client, fmt.Sprintf(adminACMERolloverServiceUrlPattern, "ACME:Test"), &server.AdminACMERolloverRequest{})
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = doAdminPost(t, client, fmt.Sprintf(adminACMERolloverServiceUrlPattern, "ACME:Test"), testAdminToken, &server.AdminACMERolloverRequest{KeyType: "unknown"})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = doAdminPost(t, client, fmt.Sprintf(adminACMERolloverServiceUrlPattern, "ACME:Test"), testAdminToken, &server.AdminACMERolloverRequest{KeyType: "ED25519"})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorResponse := &server.ServerErrorResponse{}
+	decodeJsonResponse(t, resp, errorResponse)
+	require.Equal(t, server.KeyPolicyViolation, errorResponse.Code)
+	resp = doAdminPost(t, client, fmt.Sprintf(adminACMEDeactivateServiceUrlPattern, "ACME:Unknown"), testAdminToken, nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func testAdminStoreGC(t *testing.T, client *http.Client, storePath string) {
+	orphanFile := filepath.Join(storePath, "lost.key")
+	require.NoError(t, os.WriteFile(orphanFile, []byte("lost"), 0600))
+	resp := doGet(t, client, adminStoreGCServiceUrl)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = doAdminGet(t, client, adminStoreGCServiceUrl, testAdminToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	report := &server.AdminStoreGCResponse{}
+	decodeJsonResponse(t, resp, report)
+	require.Contains(t, report.Orphans, server.AdminStoreOrphanResponse{File: "lost.key", Reason: "not part of a valid store entry", Size: 4, Removable: true})
+	require.FileExists(t, orphanFile)
+	resp = doAdminPost(t, client, adminStoreGCServiceUrl, testAdminToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	decodeJsonResponse(t, resp, report)
+	require.Contains(t, report.Orphans, server.AdminStoreOrphanResponse{File: "lost.key", Reason: "not part of a valid store entry", Size: 4, Removable: true, Removed: true})
+	require.NoFileExists(t, orphanFile)
+}
+
+func testAdminStoreJobs(t *testing.T, client *http.Client) {
+	resp := doPost(t, client, adminStoreRenewServiceUrl, &server.AdminStoreRenewRequest{})
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = doAdminPost(t, client, adminStoreRenewServiceUrl, testAdminToken, &server.AdminStoreRenewRequest{})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorResponse := &server.ServerErrorResponse{}
+	decodeJsonResponse(t, resp, errorResponse)
+	require.Equal(t, server.ValidationFailed, errorResponse.Code)
+	resp = doAdminGet(t, client, fmt.Sprintf(adminJobServiceUrlPattern, "unknown"), testAdminToken)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	// renew
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "local3"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	storeEntryDetails := &server.StoreEntryDetailsResponse{}
+	decodeJsonResponse(t, resp, storeEntryDetails)
+	serial := storeEntryDetails.CRTDetails.Serial
+	renewRequest := &server.AdminStoreRenewRequest{
+		AdminStoreSelector: server.AdminStoreSelector{Issuer: "local2"},
+	}
+	job := runAdminJob(t, client, adminStoreRenewServiceUrl, renewRequest)
+	require.Equal(t, "renew", job.Operation)
+	require.Equal(t, []server.AdminJobResultResponse{{Entry: "local3", Status: "renewed"}}, job.Results)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "local3"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	decodeJsonResponse(t, resp, storeEntryDetails)
+	require.NotEqual(t, serial, storeEntryDetails.CRTDetails.Serial)
+	// revoke
+	revokeRequest := &server.AdminStoreRevokeRequest{
+		AdminStoreSelector: server.AdminStoreSelector{Issuer: "local4"},
+		Reason:             "unknown",
+	}
+	resp = doAdminPost(t, client, adminStoreRevokeServiceUrl, testAdminToken, revokeRequest)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	revokeRequest.Reason = "key_compromise"
+	job = runAdminJob(t, client, adminStoreRevokeServiceUrl, revokeRequest)
+	require.Equal(t, "revoke", job.Operation)
+	require.Equal(t, []server.AdminJobResultResponse{{Entry: "local5", Status: "revoked"}}, job.Results)
+	job = runAdminJob(t, client, adminStoreRevokeServiceUrl, revokeRequest)
+	require.Equal(t, []server.AdminJobResultResponse{{Entry: "local5", Status: "skipped", Message: "already revoked"}}, job.Results)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "local4"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	decodeJsonResponse(t, resp, storeEntryDetails)
+	require.NotNil(t, storeEntryDetails.CRLDetails)
+	require.Equal(t, 1, storeEntryDetails.CRLDetails.RevokedCount)
+	require.Equal(t, 1, storeEntryDetails.CRLDetails.Revoked[0].Reason)
+}
+
+func testAdminStoreCompromise(t *testing.T, client *http.Client) {
+	for i, issuer := range []string{"local6", "sub0"} {
+		name := fmt.Sprintf("sub%d", i)
+		generateLocal := &server.StoreGenerateLocalRequest{
+			StoreGenerateRequest: server.StoreGenerateRequest{
+				Name: name,
+				CA:   "Local",
+			},
+			DN:        fmt.Sprintf(dnFormat, name),
+			KeyType:   "ECDSA P-256",
+			Issuer:    issuer,
+			ValidFrom: time.Now(),
+			ValidTo:   time.Now().Add(24 * 60 * time.Minute),
+			KeyUsage: server.KeyUsageExtensionSpec{
+				ExtensionSpec: server.ExtensionSpec{Enabled: true},
+				CertSign:      i == 0,
+				CRLSign:       i == 0,
+			},
+			BasicConstraint: server.BasicConstraintExtensionSpec{
+				ExtensionSpec: server.ExtensionSpec{Enabled: i == 0},
+				CA:            i == 0,
+				PathLen:       -1,
+			},
+		}
+		resp := doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	compromiseRequest := &server.AdminStoreCompromiseRequest{DryRun: true, Author: "admin"}
+	resp := doAdminPost(t, client, fmt.Sprintf(adminStoreCompromiseServiceUrlPattern, "unknown"), testAdminToken, compromiseRequest)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = doAdminPost(t, client, fmt.Sprintf(adminStoreCompromiseServiceUrlPattern, "sub1"), testAdminToken, compromiseRequest)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	// dry-run
+	resp = doAdminPost(t, client, fmt.Sprintf(adminStoreCompromiseServiceUrlPattern, "sub0"), testAdminToken, compromiseRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	report := &server.AdminStoreCompromiseResponse{}
+	decodeJsonResponse(t, resp, report)
+	require.Equal(t, "local6", report.Issuer)
+	require.True(t, report.DryRun)
+	require.Nil(t, report.Revocation)
+	require.False(t, report.FinalCRL)
+	require.Empty(t, report.Descendants)
+	require.Equal(t, 1, len(report.Leaves))
+	require.Equal(t, "sub1", report.Leaves[0].Entry)
+	require.Equal(t, "sub0", report.Leaves[0].Issuer)
+	require.Equal(t, &server.AdminStoreDeployTargetResponse{CertFile: "/etc/sub1/sub1.crt", Command: []string{"systemctl", "reload", "sub1"}}, report.Leaves[0].Deploy)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "local6"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	storeEntryDetails := &server.StoreEntryDetailsResponse{}
+	decodeJsonResponse(t, resp, storeEntryDetails)
+	require.False(t, storeEntryDetails.CRL)
+	// compromise
+	compromiseRequest.DryRun = false
+	resp = doAdminPost(t, client, fmt.Sprintf(adminStoreCompromiseServiceUrlPattern, "sub0"), testAdminToken, compromiseRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	decodeJsonResponse(t, resp, report)
+	require.False(t, report.DryRun)
+	require.Equal(t, &server.AdminJobResultResponse{Entry: "sub0", Status: "revoked"}, report.Revocation)
+	require.True(t, report.FinalCRL)
+	for _, name := range []string{"local6", "sub0"} {
+		resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, name))
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		storeEntryDetails = &server.StoreEntryDetailsResponse{}
+		decodeJsonResponse(t, resp, storeEntryDetails)
+		require.True(t, storeEntryDetails.CRL)
+		require.Equal(t, 1, storeEntryDetails.CRLDetails.RevokedCount)
+		require.Equal(t, 2, storeEntryDetails.CRLDetails.Revoked[0].Reason)
+	}
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "sub1"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	storeEntryDetails = &server.StoreEntryDetailsResponse{}
+	decodeJsonResponse(t, resp, storeEntryDetails)
+	require.Equal(t, 1, len(storeEntryDetails.Notes))
+	require.Equal(t, "admin", storeEntryDetails.Notes[0].Author)
+}
+
+func runAdminJob(t *testing.T, client *http.Client, url string, v any) *server.AdminJobResponse {
+	resp := doAdminPost(t, client, url, testAdminToken, v)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	job := &server.AdminJobResponse{}
+	decodeJsonResponse(t, resp, job)
+	require.NotEmpty(t, job.ID)
+	for i := 0; i < 50 && job.State != "finished"; i++ {
+		time.Sleep(100 * time.Millisecond)
+		resp = doAdminGet(t, client, fmt.Sprintf(adminJobServiceUrlPattern, job.ID), testAdminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		decodeJsonResponse(t, resp, job)
+	}
+	require.Equal(t, "finished", job.State)
+	return job
+}
+
+func doAdminGet(t *testing.T, client *http.Client, url string, token string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func doAdminPost(t *testing.T, client *http.Client, url string, token string, v any) *http.Response {
+	body, err := json.Marshal(v)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	return resp
 }
 
 func testAbout(t *testing.T, client *http.Client) {
@@ -96,16 +568,78 @@ func testAbout(t *testing.T, client *http.Client) {
 	require.NotEmpty(t, about.Timestamp)
 }
 
+func testOIDs(t *testing.T, client *http.Client) {
+	resp := doGet(t, client, fmt.Sprintf(oidServiceUrlPattern, "2.5.4.3"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	oid := &server.OIDResponse{}
+	decodeJsonResponse(t, resp, oid)
+	require.Equal(t, "commonName", oid.Name)
+	require.Equal(t, "id-at", oid.Arc.Name)
+	resp = doGet(t, client, fmt.Sprintf(oidServiceUrlPattern, "serverAuth"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	oid = &server.OIDResponse{}
+	decodeJsonResponse(t, resp, oid)
+	require.Equal(t, "1.3.6.1.5.5.7.3.1", oid.OID)
+	resp = doGet(t, client, fmt.Sprintf(oidServiceUrlPattern, "1.3.6.1.5.5.7.3.42"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	oid = &server.OIDResponse{}
+	decodeJsonResponse(t, resp, oid)
+	require.Empty(t, oid.Name)
+	require.Equal(t, "1.3.6.1.5.5.7.3", oid.Arc.OID)
+	resp = doGet(t, client, fmt.Sprintf(oidServiceUrlPattern, "1.2.3.4"))
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = doGet(t, client, fmt.Sprintf(oidServiceUrlPattern, "unknown"))
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
 func testStoreEntries(t *testing.T, client *http.Client) {
 	resp := doGet(t, client, storeEntriesServiceUrl)
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 	storeEntries := &server.StoreEntriesResponse{}
 	decodeJsonResponse(t, resp, storeEntries)
-	require.Equal(t, 18, len(storeEntries.Entries))
-	require.Equal(t, "acme0", storeEntries.Entries[0].Name)
-	require.Equal(t, "local0", storeEntries.Entries[1].Name)
-	require.Equal(t, "local7", storeEntries.Entries[16].Name)
-	require.Equal(t, "remote0", storeEntries.Entries[17].Name)
+	require.Equal(t, 33, len(storeEntries.Entries))
+	require.Equal(t, "GenericGenerate", storeEntries.Entries[0].Name)
+	require.Equal(t, "GenericGenerate-ca-1", storeEntries.Entries[1].Name)
+	require.False(t, storeEntries.Entries[1].Key)
+	require.Equal(t, "acme0", storeEntries.Entries[2].Name)
+	require.Equal(t, "codesign0", storeEntries.Entries[3].Name)
+	require.Equal(t, "imported0", storeEntries.Entries[4].Name)
+	require.False(t, storeEntries.Entries[4].Key)
+	require.Equal(t, "imported0-1", storeEntries.Entries[5].Name)
+	require.Equal(t, "local0", storeEntries.Entries[6].Name)
+	require.Equal(t, "local7", storeEntries.Entries[21].Name)
+	require.Equal(t, "nokey0", storeEntries.Entries[22].Name)
+	require.False(t, storeEntries.Entries[22].Key)
+	require.Equal(t, "pathlen0", storeEntries.Entries[23].Name)
+	require.Equal(t, "pathlen2", storeEntries.Entries[24].Name)
+	require.Equal(t, "pathlen3", storeEntries.Entries[25].Name)
+	require.Equal(t, "pubkey0", storeEntries.Entries[26].Name)
+	require.False(t, storeEntries.Entries[26].Key)
+	require.Equal(t, "remote0", storeEntries.Entries[27].Name)
+	require.Equal(t, "signed0", storeEntries.Entries[28].Name)
+	require.False(t, storeEntries.Entries[28].Key)
+	require.Equal(t, "smime0", storeEntries.Entries[29].Name)
+	require.True(t, storeEntries.Entries[29].Key)
+	require.Equal(t, "sub0", storeEntries.Entries[30].Name)
+	require.Equal(t, "sub1", storeEntries.Entries[31].Name)
+	require.Equal(t, "tsa0", storeEntries.Entries[32].Name)
+}
+
+func writeBrokenStoreEntry(t *testing.T, storePath string, name string) {
+	err := os.WriteFile(filepath.Join(storePath, name+".crt"), []byte("not a certificate"), 0600)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(storePath, name+".json"), []byte(`{"provider":"Local"}`), 0600)
+	require.NoError(t, err)
+}
+
+func testStoreEntriesProblems(t *testing.T, client *http.Client, name string) {
+	resp := doGet(t, client, storeEntriesServiceUrl)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	storeEntries := &server.StoreEntriesResponse{}
+	decodeJsonResponse(t, resp, storeEntries)
+	require.Equal(t, 1, len(storeEntries.Problems))
+	require.Equal(t, name, storeEntries.Problems[0].Name)
+	require.NotEmpty(t, storeEntries.Problems[0].Problem)
 }
 
 func testStoreEntryDetails(t *testing.T, client *http.Client) {
@@ -115,6 +649,363 @@ func testStoreEntryDetails(t *testing.T, client *http.Client) {
 	storeEntryDetails := &server.StoreEntryDetailsResponse{}
 	decodeJsonResponse(t, resp, storeEntryDetails)
 	require.Equal(t, entryName, storeEntryDetails.Name)
+	require.Equal(t, entryName, storeEntryDetails.CRTDetails.IssuerEntry)
+}
+
+func testStoreEntrySPKI(t *testing.T, client *http.Client) {
+	resp := doGet(t, client, fmt.Sprintf(storeEntrySPKIServiceUrlPattern, "local1"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	spki := &server.StoreEntrySPKIResponse{}
+	decodeJsonResponse(t, resp, spki)
+	require.Equal(t, 2, len(spki.Pins))
+	require.False(t, spki.Pins[0].CA)
+	require.True(t, spki.Pins[1].CA)
+	require.Equal(t, "CN=local0,OU=pki", spki.Pins[1].DN)
+	for _, pin := range spki.Pins {
+		hash, err := base64.StdEncoding.DecodeString(pin.Pin)
+		require.NoError(t, err)
+		require.Equal(t, 32, len(hash))
+		require.Contains(t, spki.HPKP, fmt.Sprintf("pin-sha256=\"%s\"", pin.Pin))
+		require.Contains(t, spki.NetworkSecurityConfig, fmt.Sprintf("<pin digest=\"SHA-256\">%s</pin>", pin.Pin))
+	}
+	resp = doGet(t, client, fmt.Sprintf(storeEntrySPKIServiceUrlPattern, "unknown"))
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func testStoreEntryText(t *testing.T, client *http.Client) {
+	resp := doGet(t, client, fmt.Sprintf(storeEntryTextServiceUrlPattern, "local0")+"?pem=true")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	text, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(text), "Certificate:\n"))
+	require.Contains(t, string(text), "CN = local0\n")
+	require.Contains(t, string(text), "-----BEGIN CERTIFICATE-----\n")
+	resp = doGet(t, client, fmt.Sprintf(storeEntryTextServiceUrlPattern, "remote0"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	text, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(text), "Certificate Request:\n"))
+	require.NotContains(t, string(text), "-----BEGIN")
+	resp = doGet(t, client, fmt.Sprintf(storeEntryTextServiceUrlPattern, "unknown"))
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func testStoreEntryExport(t *testing.T, client *http.Client) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	exportRequest := &server.StoreEntryExportRequest{
+		Format:    "age",
+		Recipient: identity.Recipient().String(),
+	}
+	resp := doPut(t, client, fmt.Sprintf(storeEntryExportServiceUrlPattern, "local0"), exportRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	decrypted, err := age.Decrypt(armor.NewReader(resp.Body), identity)
+	require.NoError(t, err)
+	keyBytes, err := io.ReadAll(decrypted)
+	require.NoError(t, err)
+	require.Contains(t, string(keyBytes), "PRIVATE KEY")
+	exportRequest = &server.StoreEntryExportRequest{
+		Format:   "pkcs8",
+		Password: "secret",
+	}
+	resp = doPut(t, client, fmt.Sprintf(storeEntryExportServiceUrlPattern, "local0"), exportRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	keyBytes, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(keyBytes), "ENCRYPTED PRIVATE KEY")
+	exportRequest.Password = ""
+	resp = doPut(t, client, fmt.Sprintf(storeEntryExportServiceUrlPattern, "local0"), exportRequest)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	exportRequest.Format = "unknown"
+	resp = doPut(t, client, fmt.Sprintf(storeEntryExportServiceUrlPattern, "local0"), exportRequest)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func testStoreEntryNotes(t *testing.T, client *http.Client) {
+	noteRequest := &server.StoreEntryNoteRequest{Author: "alice", Text: "Requested via TICKET-42"}
+	resp := doPost(t, client, fmt.Sprintf(storeEntryNotesServiceUrlPattern, "local1"), noteRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	labelsRequest := &server.StoreEntryLabelsRequest{
+		Labels: map[string]string{"env": "prod", "team": "ops"},
+		Note:   &server.StoreEntryNoteRequest{Author: "bob", Text: "Handed over to ops"},
+	}
+	resp = doPut(t, client, fmt.Sprintf(storeEntryLabelsServiceUrlPattern, "local1"), labelsRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doPost(t, client, fmt.Sprintf(storeEntryNotesServiceUrlPattern, "local1"), &server.StoreEntryNoteRequest{Text: " "})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = doPost(t, client, fmt.Sprintf(storeEntryNotesServiceUrlPattern, "unknown"), noteRequest)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "local1"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	storeEntryDetails := &server.StoreEntryDetailsResponse{}
+	decodeJsonResponse(t, resp, storeEntryDetails)
+	require.Equal(t, labelsRequest.Labels, storeEntryDetails.Labels)
+	require.Equal(t, 2, len(storeEntryDetails.Notes))
+	require.Equal(t, "alice", storeEntryDetails.Notes[0].Author)
+	require.Equal(t, "Requested via TICKET-42", storeEntryDetails.Notes[0].Text)
+	require.Equal(t, "Handed over to ops", storeEntryDetails.Notes[1].Text)
+}
+
+func testStoreEntryDownload(t *testing.T, client *http.Client) {
+	downloadRequest := &server.StoreEntryDownloadURLRequest{Format: "crt", ExpiresIn: 60}
+	resp := doPost(t, client, fmt.Sprintf(storeEntryDownloadURLServiceUrlPattern, "local1"), downloadRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	downloadURL := &server.StoreEntryDownloadURLResponse{}
+	decodeJsonResponse(t, resp, downloadURL)
+	require.True(t, strings.Contains(downloadURL.URL, "/download/local1/crt?"))
+	tampered := strings.Replace(downloadURL.URL, "/local1/", "/local0/", 1)
+	resp = doGet(t, client, tampered)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = doGet(t, client, downloadURL.URL)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	downloaded, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	block, rest := pem.Decode(downloaded)
+	require.NotNil(t, block)
+	require.Equal(t, "CERTIFICATE", block.Type)
+	block, _ = pem.Decode(rest)
+	require.NotNil(t, block)
+	disposition, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	require.NoError(t, err)
+	require.Equal(t, "attachment", disposition)
+	require.Equal(t, "local1.crt", params["filename"])
+	resp = doGet(t, client, downloadURL.URL)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	// claims survive a restart
+	parsedURL, err := url.Parse(downloadURL.URL)
+	require.NoError(t, err)
+	claimsBytes, err := state.Read("download-claims.json")
+	require.NoError(t, err)
+	claims := make(map[string]int64)
+	require.NoError(t, json.Unmarshal(claimsBytes, &claims))
+	require.Contains(t, claims, parsedURL.Query().Get("signature"))
+	downloadRequest = &server.StoreEntryDownloadURLRequest{Format: "openssh-pub"}
+	resp = doPost(t, client, fmt.Sprintf(storeEntryDownloadURLServiceUrlPattern, "local3"), downloadRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	decodeJsonResponse(t, resp, downloadURL)
+	resp = doGet(t, client, downloadURL.URL)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	downloaded, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(downloaded), "ecdsa-sha2-nistp256 "))
+	require.True(t, strings.HasSuffix(string(downloaded), " local3\n"))
+	resp = doPost(t, client, fmt.Sprintf(storeEntryDownloadURLServiceUrlPattern, "local1"), &server.StoreEntryDownloadURLRequest{Format: "p7b"})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = doPost(t, client, fmt.Sprintf(storeEntryDownloadURLServiceUrlPattern, "unknown"), downloadRequest)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func testConditionalRequests(t *testing.T, client *http.Client) {
+	resp := doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "local1"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+	resp = doConditionalGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "local1"), etag)
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+	resp = doPost(t, client, fmt.Sprintf(storeEntryNotesServiceUrlPattern, "local1"), &server.StoreEntryNoteRequest{Text: "Changed"})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doConditionalGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "local1"), etag)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotEqual(t, etag, resp.Header.Get("ETag"))
+	resp = doGet(t, client, storeEntriesServiceUrl)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.True(t, resp.Uncompressed)
+	etag = resp.Header.Get("ETag")
+	// compressed representations are tagged weak
+	require.True(t, strings.HasPrefix(etag, "W/\""))
+	resp = doConditionalGet(t, client, storeEntriesServiceUrl, etag)
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+	require.Equal(t, etag, resp.Header.Get("ETag"))
+	req, err := http.NewRequest(http.MethodGet, storeEntriesServiceUrl, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, strings.TrimPrefix(etag, "W/"), resp.Header.Get("ETag"))
+	resp = doConditionalGet(t, client, storeEntriesServiceUrl, strings.TrimPrefix(etag, "W/"))
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+	labelsRequest := &server.StoreEntryLabelsRequest{
+		Labels: map[string]string{"env": "test"},
+	}
+	resp = doPut(t, client, fmt.Sprintf(storeEntryLabelsServiceUrlPattern, "local1"), labelsRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doConditionalGet(t, client, storeEntriesServiceUrl, etag)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotEqual(t, etag, resp.Header.Get("ETag"))
+}
+
+func doConditionalGet(t *testing.T, client *http.Client, url string, etag string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", etag)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func testStoreExport(t *testing.T, client *http.Client) {
+	labelsRequest := &server.StoreEntryLabelsRequest{
+		Labels: map[string]string{"env": "prod"},
+	}
+	resp := doPut(t, client, fmt.Sprintf(storeEntryLabelsServiceUrlPattern, "local0"), labelsRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doPut(t, client, fmt.Sprintf(storeEntryLabelsServiceUrlPattern, "local1"), labelsRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doPut(t, client, fmt.Sprintf(storeEntryLabelsServiceUrlPattern, "unknown"), labelsRequest)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "local0"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	storeEntryDetails := &server.StoreEntryDetailsResponse{}
+	decodeJsonResponse(t, resp, storeEntryDetails)
+	require.Equal(t, labelsRequest.Labels, storeEntryDetails.Labels)
+	exportRequest := &server.StoreExportRequest{
+		Selector: "env=prod",
+		PKCS12:   true,
+		Password: "secret",
+	}
+	resp = doPost(t, client, storeExportServiceUrl, exportRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+	files := readZipResponse(t, resp)
+	require.Contains(t, files, "local0.crt")
+	require.Contains(t, files, "local0.p12")
+	require.Contains(t, files, "local1.crt")
+	require.Contains(t, files, "local1.p12")
+	_, _, err := pkcs12.Decode(files["local0.p12"], exportRequest.Password)
+	require.NoError(t, err)
+	exportRequest = &server.StoreExportRequest{
+		Names: []string{"local0"},
+	}
+	resp = doPost(t, client, storeExportServiceUrl, exportRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	files = readZipResponse(t, resp)
+	require.Equal(t, 1, len(files))
+	block, _ := pem.Decode(files["local0.crt"])
+	require.NotNil(t, block)
+	require.Equal(t, "CERTIFICATE", block.Type)
+	exportRequest.Names = []string{"unknown"}
+	resp = doPost(t, client, storeExportServiceUrl, exportRequest)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	exportRequest = &server.StoreExportRequest{
+		Selector: "env=test",
+	}
+	resp = doPost(t, client, storeExportServiceUrl, exportRequest)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	exportRequest.Selector = "=test"
+	resp = doPost(t, client, storeExportServiceUrl, exportRequest)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	testStoreExportEscapedName(t, client)
+}
+
+func testStoreExportEscapedName(t *testing.T, client *http.Client) {
+	const name = "..\\escaped"
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "escaped"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	require.NoError(t, err)
+	p7b, err := certs.EncodeCertificatesPKCS7([]*x509.Certificate{certificate}, nil)
+	require.NoError(t, err)
+	resp := doPut(t, client, storeP7BImportServiceUrl, &server.StoreP7BImportRequest{Name: name, P7B: p7b})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doPost(t, client, storeExportServiceUrl, &server.StoreExportRequest{Names: []string{name}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	files := readZipResponse(t, resp)
+	require.Equal(t, 1, len(files))
+	for file := range files {
+		require.NotContains(t, file, "\\")
+		require.NotContains(t, file, "/")
+		require.False(t, strings.HasPrefix(file, "."))
+	}
+	resp = doPost(t, client, fmt.Sprintf(storeEntryArchiveServiceUrlPattern, url.PathEscape(name)), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doDelete(t, client, fmt.Sprintf(storeArchivePurgeServiceUrlPattern, url.PathEscape(name)))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func readZipResponse(t *testing.T, resp *http.Response) map[string][]byte {
+	zipBytes, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	zipReader, err := zip.NewReader(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	require.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, file := range zipReader.File {
+		reader, err := file.Open()
+		require.NoError(t, err)
+		files[file.Name], err = io.ReadAll(reader)
+		require.NoError(t, err)
+		reader.Close()
+	}
+	return files
+}
+
+func testStoreEntryCRLDetails(t *testing.T, client *http.Client, storePath string) {
+	const name = "local0"
+	exportRequest := &server.StoreEntryExportRequest{
+		Format:   "pkcs12",
+		Password: "secret",
+	}
+	resp := doPut(t, client, fmt.Sprintf(storeEntryExportServiceUrlPattern, name), exportRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	pfxData, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	key, certificate, err := pkcs12.Decode(pfxData, exportRequest.Password)
+	require.NoError(t, err)
+	now := time.Now()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(42),
+		ThisUpdate: now,
+		NextUpdate: now.Add(time.Hour),
+		RevokedCertificates: []pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(1), RevocationTime: now},
+			{SerialNumber: big.NewInt(2), RevocationTime: now, Extensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 21}, Value: []byte{0x0a, 0x01, 0x01}}}},
+			{SerialNumber: big.NewInt(3), RevocationTime: now},
+		},
+	}
+	revocationListBytes, err := x509.CreateRevocationList(rand.Reader, template, certificate, key.(crypto.Signer))
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(storePath, name+".crl"), pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: revocationListBytes}), 0600)
+	require.NoError(t, err)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, name)+"?crl_offset=1&crl_limit=1")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	storeEntryDetails := &server.StoreEntryDetailsResponse{}
+	decodeJsonResponse(t, resp, storeEntryDetails)
+	require.True(t, storeEntryDetails.CRL)
+	require.Equal(t, certificate.Subject.String(), storeEntryDetails.CRLDetails.Issuer)
+	require.Equal(t, "0x2a", storeEntryDetails.CRLDetails.Number)
+	require.Equal(t, 3, storeEntryDetails.CRLDetails.RevokedCount)
+	require.Equal(t, 1, len(storeEntryDetails.CRLDetails.Revoked))
+	require.Equal(t, "0x2", storeEntryDetails.CRLDetails.Revoked[0].Serial)
+	require.Equal(t, 1, storeEntryDetails.CRLDetails.Revoked[0].Reason)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, name)+"?crl_limit=x")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func testStoreStats(t *testing.T, client *http.Client) {
+	resp := doGet(t, client, storeStatsServiceUrl)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	storeStats := &server.StoreStatsResponse{}
+	decodeJsonResponse(t, resp, storeStats)
+	require.GreaterOrEqual(t, storeStats.Total, storeStats.CAs+storeStats.Leafs)
+	require.Greater(t, storeStats.Providers["Local"], 0)
+	require.Greater(t, storeStats.CAs, 0)
+	require.Greater(t, storeStats.Leafs, 0)
+	require.Greater(t, storeStats.KeyTypes["ED25519"], 0)
+	require.Greater(t, storeStats.Expiring.Days7, 0)
+	require.GreaterOrEqual(t, storeStats.Expiring.Days30, storeStats.Expiring.Days7)
+	require.GreaterOrEqual(t, storeStats.Expiring.Days90, storeStats.Expiring.Days30)
+	require.NotNil(t, storeStats.NextExpiry)
+	require.NotEmpty(t, storeStats.NextExpiry.Name)
+	require.Equal(t, 0, storeStats.Revoked)
+	require.Equal(t, 0, storeStats.Problems)
 }
 
 func testStoreCAs(t *testing.T, client *http.Client) {
@@ -122,10 +1013,114 @@ func testStoreCAs(t *testing.T, client *http.Client) {
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 	storeCAs := &server.StoreCAsResponse{}
 	decodeJsonResponse(t, resp, storeCAs)
-	require.Equal(t, 3, len(storeCAs.CAs))
+	require.Equal(t, 4, len(storeCAs.CAs))
 	require.Equal(t, "Local", storeCAs.CAs[0].Name)
 	require.Equal(t, "Remote", storeCAs.CAs[1].Name)
 	require.Equal(t, "ACME:Test", storeCAs.CAs[2].Name)
+	require.Equal(t, "Vault:Test", storeCAs.CAs[3].Name)
+}
+
+func testStoreGenerate(t *testing.T, client *http.Client) {
+	generate := &server.StoreGenerateProviderRequest{
+		StoreGenerateRequest: server.StoreGenerateRequest{
+			Name: "GenericGenerate",
+			CA:   "Local",
+		},
+		DN:      "CN=GenericGenerate",
+		KeyType: "ECDSA P-256",
+	}
+	resp := doPut(t, client, storeGenerateServiceUrl, generate)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorResponse := &server.ServerErrorResponse{}
+	decodeJsonResponse(t, resp, errorResponse)
+	require.Equal(t, "CA does not support generic generate requests", errorResponse.Message)
+	generate.CA = "ACME:Unknown"
+	resp = doPut(t, client, storeGenerateServiceUrl, generate)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	decodeJsonResponse(t, resp, errorResponse)
+	require.Equal(t, "Invalid CA", errorResponse.Message)
+	generate.CA = "ACME:Test"
+	generate.KeyType = "unknown"
+	resp = doPut(t, client, storeGenerateServiceUrl, generate)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	generate.CA = "Vault:Test"
+	generate.KeyType = "ECDSA P-256"
+	generate.Domains = []string{"vault.example.org"}
+	resp = doPut(t, client, storeGenerateServiceUrl, generate)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "GenericGenerate"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	details := &server.StoreEntryDetailsResponse{}
+	decodeJsonResponse(t, resp, details)
+	require.True(t, details.Key)
+	require.Equal(t, "CN=GenericGenerate", details.DN)
+	serial := details.CRTDetails.Serial
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "GenericGenerate-ca-1"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	decodeJsonResponse(t, resp, details)
+	require.Equal(t, "CN=Vault Test CA", details.DN)
+	generate.Name = "UnknownGenerate"
+	generate.Renew = true
+	resp = doPut(t, client, storeGenerateServiceUrl, generate)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	generate.Name = "GenericGenerate"
+	generate.DN = ""
+	generate.Domains = nil
+	resp = doPut(t, client, storeGenerateServiceUrl, generate)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "GenericGenerate"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	renewed := &server.StoreEntryDetailsResponse{}
+	decodeJsonResponse(t, resp, renewed)
+	require.Equal(t, "CN=GenericGenerate", renewed.DN)
+	require.NotEqual(t, serial, renewed.CRTDetails.Serial)
+}
+
+func testStoreGenerateValidation(t *testing.T, client *http.Client) {
+	generateLocal := &server.StoreGenerateLocalRequest{
+		StoreGenerateRequest: server.StoreGenerateRequest{
+			Name: "../invalid",
+			CA:   "Local",
+		},
+		DN:        "invalid",
+		KeyType:   "ECDSA P-999",
+		ValidFrom: time.Now(),
+		ValidTo:   time.Now().Add(-time.Hour),
+		BasicConstraint: server.BasicConstraintExtensionSpec{
+			ExtensionSpec: server.ExtensionSpec{Enabled: true},
+			PathLen:       -2,
+		},
+		CustomExtensions: []server.CustomExtensionSpec{{OID: "1.2.3"}, {OID: "invalid"}},
+	}
+	resp := doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorResponse := &server.ServerErrorResponse{}
+	decodeJsonResponse(t, resp, errorResponse)
+	require.Equal(t, server.ValidationFailed, errorResponse.Code)
+	fields := make([]string, 0, len(errorResponse.Fields))
+	for _, field := range errorResponse.Fields {
+		require.NotEmpty(t, field.Error)
+		fields = append(fields, field.Field)
+	}
+	require.Equal(t, []string{"name", "key_type", "dn", "valid_to", "basic_constraint.path_len", "custom_extensions[1].oid"}, fields)
+	generateACME := &server.StoreGenerateACMERequest{
+		StoreGenerateRequest: server.StoreGenerateRequest{
+			Name: "acme-invalid",
+		},
+		KeyType: "ECDSA P-256",
+	}
+	resp = doPut(t, client, storeACMEGenerateServiceUrl, generateACME)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorResponse = &server.ServerErrorResponse{}
+	decodeJsonResponse(t, resp, errorResponse)
+	require.Equal(t, []server.FieldErrorResponse{{Field: "ca", Error: "required"}, {Field: "domains", Error: "required"}}, errorResponse.Fields)
+	resp = doPut(t, client, storeRemoteGenerateServiceUrl, map[string]any{"name": 1})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorResponse = &server.ServerErrorResponse{}
+	decodeJsonResponse(t, resp, errorResponse)
+	require.Equal(t, server.ValidationFailed, errorResponse.Code)
+	require.Len(t, errorResponse.Fields, 1)
+	require.Equal(t, "name", errorResponse.Fields[0].Field)
 }
 
 func testStoreLocalIssuers(t *testing.T, client *http.Client) {
@@ -133,9 +1128,79 @@ func testStoreLocalIssuers(t *testing.T, client *http.Client) {
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 	storeLocalIssuers := &server.StoreLocalIssuersResponse{}
 	decodeJsonResponse(t, resp, storeLocalIssuers)
-	require.Equal(t, 8, len(storeLocalIssuers.Issuers))
+	require.Equal(t, 12, len(storeLocalIssuers.Issuers))
 	require.Equal(t, "local0", storeLocalIssuers.Issuers[0].Name)
 	require.Equal(t, "local6", storeLocalIssuers.Issuers[7].Name)
+	require.Equal(t, "pathlen0", storeLocalIssuers.Issuers[8].Name)
+	require.Equal(t, "pathlen2", storeLocalIssuers.Issuers[9].Name)
+	require.Equal(t, "pathlen3", storeLocalIssuers.Issuers[10].Name)
+	require.Equal(t, "sub0", storeLocalIssuers.Issuers[11].Name)
+}
+
+func testStoreLocalIssuerErrors(t *testing.T, client *http.Client) {
+	resp := doGet(t, client, fmt.Sprintf(storeLocalIssuerServiceUrlPattern, "local0"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	errorResponse := &server.ServerErrorResponse{}
+	resp = doGet(t, client, fmt.Sprintf(storeLocalIssuerServiceUrlPattern, "unknown"))
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	decodeJsonResponse(t, resp, errorResponse)
+	require.Equal(t, server.IssuerNotFound, errorResponse.Code)
+	resp = doGet(t, client, fmt.Sprintf(storeLocalIssuerServiceUrlPattern, "local1"))
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	decodeJsonResponse(t, resp, errorResponse)
+	require.Equal(t, server.IssuerNotCA, errorResponse.Code)
+	generateLocal := &server.StoreGenerateLocalRequest{
+		StoreGenerateRequest: server.StoreGenerateRequest{
+			Name: "pathlen0",
+			CA:   "Local",
+		},
+		DN:        fmt.Sprintf(dnFormat, "pathlen0"),
+		KeyType:   "ECDSA P-256",
+		ValidFrom: time.Now(),
+		ValidTo:   time.Now().Add(24 * 60 * time.Minute),
+		KeyUsage: server.KeyUsageExtensionSpec{
+			ExtensionSpec: server.ExtensionSpec{Enabled: true},
+			CertSign:      true,
+		},
+		BasicConstraint: server.BasicConstraintExtensionSpec{
+			ExtensionSpec: server.ExtensionSpec{Enabled: true},
+			CA:            true,
+			PathLen:       0,
+		},
+	}
+	resp = doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	generateLocal.Name = "pathlen1"
+	generateLocal.DN = fmt.Sprintf(dnFormat, "pathlen1")
+	generateLocal.Issuer = "pathlen0"
+	resp = doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	decodeJsonResponse(t, resp, errorResponse)
+	require.Equal(t, server.IssuerPathLenExhausted, errorResponse.Code)
+	generateLocal.Issuer = "local1"
+	resp = doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	decodeJsonResponse(t, resp, errorResponse)
+	require.Equal(t, server.IssuerNotCA, errorResponse.Code)
+	generateLocal.Name = "pathlen2"
+	generateLocal.DN = fmt.Sprintf(dnFormat, "pathlen2")
+	generateLocal.Issuer = ""
+	generateLocal.BasicConstraint.PathLen = 1
+	resp = doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	generateLocal.Name = "pathlen3"
+	generateLocal.DN = fmt.Sprintf(dnFormat, "pathlen3")
+	generateLocal.Issuer = "pathlen2"
+	for _, pathLen := range []int{1, -1} {
+		generateLocal.BasicConstraint.PathLen = pathLen
+		resp = doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+		require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+		decodeJsonResponse(t, resp, errorResponse)
+		require.Equal(t, server.IssuerPathLenExceeded, errorResponse.Code)
+	}
+	generateLocal.BasicConstraint.PathLen = 0
+	resp = doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
 const dnFormat = "CN=%s,OU=pki"
@@ -193,6 +1258,507 @@ func testStoreGenerateLocal2(t *testing.T, client *http.Client, keyType string,
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
+func testStoreArchive(t *testing.T, client *http.Client) {
+	for _, name := range []string{"archive0", "archive1"} {
+		generateLocal := &server.StoreGenerateLocalRequest{
+			StoreGenerateRequest: server.StoreGenerateRequest{
+				Name: name,
+				CA:   "Local",
+			},
+			DN:        fmt.Sprintf(dnFormat, name),
+			KeyType:   "ECDSA P-256",
+			ValidFrom: time.Now(),
+			ValidTo:   time.Now().Add(time.Hour),
+			BasicConstraint: server.BasicConstraintExtensionSpec{
+				ExtensionSpec: server.ExtensionSpec{Enabled: true},
+				CA:            name == "archive1",
+				PathLen:       -1,
+			},
+		}
+		resp := doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = doPost(t, client, fmt.Sprintf(storeEntryArchiveServiceUrlPattern, name), nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, name))
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+	resp := doPost(t, client, fmt.Sprintf(storeEntryArchiveServiceUrlPattern, "archive0"), nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = doGet(t, client, storeArchiveServiceUrl)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	archive := &server.StoreArchiveResponse{}
+	decodeJsonResponse(t, resp, archive)
+	require.Equal(t, 2, len(archive.Entries))
+	require.Equal(t, "archive0", archive.Entries[0].Name)
+	require.False(t, archive.Entries[0].Archived.IsZero())
+	resp = doPost(t, client, fmt.Sprintf(storeArchiveRestoreServiceUrlPattern, "archive0"), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "archive0"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doPost(t, client, fmt.Sprintf(storeEntryArchiveServiceUrlPattern, "archive0"), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doDelete(t, client, fmt.Sprintf(storeArchivePurgeServiceUrlPattern, "archive0"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doDelete(t, client, fmt.Sprintf(storeArchivePurgeServiceUrlPattern, "archive0"))
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	// CAs are retained
+	resp = doDelete(t, client, fmt.Sprintf(storeArchivePurgeServiceUrlPattern, "archive1"))
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = doGet(t, client, storeArchiveServiceUrl)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	decodeJsonResponse(t, resp, archive)
+	require.Equal(t, 1, len(archive.Entries))
+	require.Equal(t, "archive1", archive.Entries[0].Name)
+}
+
+func doDelete(t *testing.T, client *http.Client, url string) *http.Response {
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func testStoreGenerateLocalPIV(t *testing.T, client *http.Client) {
+	const name = "piv0"
+	generateLocal := &server.StoreGenerateLocalRequest{
+		StoreGenerateRequest: server.StoreGenerateRequest{
+			Name: name,
+			CA:   "Local",
+		},
+		DN:        fmt.Sprintf(dnFormat, name),
+		KeyType:   "PIV",
+		Issuer:    "local0",
+		ValidFrom: time.Now(),
+		ValidTo:   time.Now().Add(24 * 60 * time.Minute),
+	}
+	// test binary is built without PIV support
+	resp := doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, name))
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func testStoreGenerateLocalNoStoreKey(t *testing.T, client *http.Client) {
+	const name = "nokey0"
+	generateLocal := &server.StoreGenerateLocalRequest{
+		StoreGenerateRequest: server.StoreGenerateRequest{
+			Name: name,
+			CA:   "Local",
+		},
+		DN:         fmt.Sprintf(dnFormat, name),
+		KeyType:    "ECDSA P-256",
+		Issuer:     "local0",
+		ValidFrom:  time.Now(),
+		ValidTo:    time.Now().Add(24 * 60 * time.Minute),
+		NoStoreKey: true,
+		CustomExtensions: []server.CustomExtensionSpec{
+			// Netscape comment
+			{OID: "2.16.840.1.113730.1.13", Value: []byte{0x16, 0x04, 't', 'e', 's', 't'}},
+		},
+	}
+	resp := doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	generateLocalResponse := &server.StoreGenerateLocalResponse{}
+	decodeJsonResponse(t, resp, generateLocalResponse)
+	require.Contains(t, generateLocalResponse.Key, "PRIVATE KEY")
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, name))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	storeEntryDetails := &server.StoreEntryDetailsResponse{}
+	decodeJsonResponse(t, resp, storeEntryDetails)
+	require.Contains(t, storeEntryDetails.CRTDetails.Extensions, [2]string{"2.16.840.1.113730.1.13", ""})
+	// start of validity is backdated by the default skew
+	require.True(t, storeEntryDetails.ValidFrom.Before(generateLocal.ValidFrom.Add(-4*time.Minute)))
+	generateLocal.StoreGenerateRequest.Name = "nokey1"
+	generateLocal.CustomExtensions[0].Value = []byte{0x16, 0x04, 't', 'e', 's'}
+	resp = doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func testStoreGenerateLocalSMIME(t *testing.T, client *http.Client) {
+	const name = "smime0"
+	const email = "user@example.org"
+	generateLocal := &server.StoreGenerateLocalRequest{
+		StoreGenerateRequest: server.StoreGenerateRequest{
+			Name: name,
+			CA:   "Local",
+		},
+		DN:        fmt.Sprintf(dnFormat, name),
+		KeyType:   "ECDSA P-256",
+		Issuer:    "local0",
+		ValidFrom: time.Now(),
+		ValidTo:   time.Now().Add(24 * 60 * time.Minute),
+		Profile:   "smime",
+		Email:     email,
+	}
+	resp := doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	exportRequest := &server.StoreEntryExportRequest{
+		Format:   "pkcs12",
+		Password: "secret",
+	}
+	resp = doPut(t, client, fmt.Sprintf(storeEntryExportServiceUrlPattern, name), exportRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	pfxData, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	key, certificate, chain, err := pkcs12.DecodeChain(pfxData, exportRequest.Password)
+	require.NoError(t, err)
+	require.NotNil(t, key)
+	require.Equal(t, []string{email}, certificate.EmailAddresses)
+	require.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}, certificate.ExtKeyUsage)
+	require.Equal(t, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyAgreement, certificate.KeyUsage)
+	require.Equal(t, 1, len(chain))
+	generateLocal.StoreGenerateRequest.Name = "smime1"
+	generateLocal.Email = "user@example.com"
+	resp = doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	generateLocal.Email = "no email"
+	resp = doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	generateLocal.Profile = "unknown"
+	resp = doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func testStoreEntryOCSPStaple(t *testing.T, client *http.Client) {
+	resp := doGet(t, client, fmt.Sprintf(storeEntryOCSPStapleServiceUrlPattern, "local1"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, certs.OCSPResponseContentType, resp.Header.Get("Content-Type"))
+	staple, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	response, err := ocsp.ParseResponse(staple, nil)
+	require.NoError(t, err)
+	require.Equal(t, ocsp.Good, response.Status)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryOCSPStapleServiceUrlPattern, "local1"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	cachedStaple, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, staple, cachedStaple)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryOCSPStapleServiceUrlPattern, "local0"))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryOCSPStapleServiceUrlPattern, "unknown"))
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func testStoreP7B(t *testing.T, client *http.Client) {
+	resp := doGet(t, client, fmt.Sprintf(storeEntryP7BServiceUrlPattern, "codesign0"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	p7b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	certificates, err := certs.DecodeCertificatesPKCS7(p7b)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(certificates))
+	importRequest := &server.StoreP7BImportRequest{
+		Name: "imported0",
+		P7B:  p7b,
+	}
+	resp = doPut(t, client, storeP7BImportServiceUrl, importRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	importResponse := &server.StoreP7BImportResponse{}
+	decodeJsonResponse(t, resp, importResponse)
+	require.Empty(t, importResponse.Entries)
+	importRequest.P7B, err = os.ReadFile("../../pkg/certs/testdata/lets-encrypt-r3.p7b")
+	require.NoError(t, err)
+	resp = doPut(t, client, storeP7BImportServiceUrl, importRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	decodeJsonResponse(t, resp, importResponse)
+	require.Equal(t, []string{"imported0", "imported0-1"}, importResponse.Entries)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, "imported0"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	storeEntryDetails := &server.StoreEntryDetailsResponse{}
+	decodeJsonResponse(t, resp, storeEntryDetails)
+	require.Equal(t, "imported0-1", storeEntryDetails.CRTDetails.IssuerEntry)
+	importRequest.P7B = []byte("test")
+	resp = doPut(t, client, storeP7BImportServiceUrl, importRequest)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func testTSA(t *testing.T, client *http.Client) {
+	const name = "tsa0"
+	digest := sha256.Sum256([]byte("artifact"))
+	_, err := certs.FetchTimestamp(os.Getenv("CERTD_TEST_TSA_URL"), digest[:], crypto.SHA256)
+	require.Error(t, err)
+	generateLocal := &server.StoreGenerateLocalRequest{
+		StoreGenerateRequest: server.StoreGenerateRequest{
+			Name: name,
+			CA:   "Local",
+		},
+		DN:        fmt.Sprintf(dnFormat, name),
+		KeyType:   "ECDSA P-256",
+		Issuer:    "local0",
+		ValidFrom: time.Now(),
+		ValidTo:   time.Now().Add(24 * 60 * time.Minute),
+		Profile:   "tsa",
+	}
+	resp := doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	token, err := certs.FetchTimestamp(os.Getenv("CERTD_TEST_TSA_URL"), digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+}
+
+func testStoreEntrySign(t *testing.T, client *http.Client) {
+	const name = "codesign0"
+	generateLocal := &server.StoreGenerateLocalRequest{
+		StoreGenerateRequest: server.StoreGenerateRequest{
+			Name: name,
+			CA:   "Local",
+		},
+		DN:        fmt.Sprintf(dnFormat, name),
+		KeyType:   "ECDSA P-256",
+		Issuer:    "local0",
+		ValidFrom: time.Now(),
+		ValidTo:   time.Now().Add(24 * 60 * time.Minute),
+		ExtKeyUsage: server.ExtKeyUsageExtensionSpec{
+			ExtensionSpec: server.ExtensionSpec{Enabled: true},
+			CodeSigning:   true,
+		},
+	}
+	resp := doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	digest := sha256.Sum256([]byte("artifact"))
+	signRequest := &server.StoreEntrySignRequest{
+		Digest: hex.EncodeToString(digest[:]),
+	}
+	resp = doPut(t, client, fmt.Sprintf(storeEntrySignServiceUrlPattern, name), signRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	signature, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	signer, err := certs.VerifyDigest(signature, digest[:])
+	require.NoError(t, err)
+	require.Equal(t, name, signer.Subject.CommonName)
+	resp = doPut(t, client, fmt.Sprintf(storeEntrySignServiceUrlPattern, "local0"), signRequest)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	signRequest.Timestamp = true
+	resp = doPut(t, client, fmt.Sprintf(storeEntrySignServiceUrlPattern, name), signRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	signature, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_, err = certs.VerifyDigest(signature, digest[:])
+	require.NoError(t, err)
+	signRequest.Timestamp = false
+	signRequest.DigestAlgorithm = "sha512"
+	resp = doPut(t, client, fmt.Sprintf(storeEntrySignServiceUrlPattern, name), signRequest)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func testStoreSignLocal(t *testing.T, client *http.Client) {
+	const name = "signed0"
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	csrTemplate := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: name},
+		DNSNames: []string{"signed0.example.org"},
+	}
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate, key)
+	require.NoError(t, err)
+	attestationKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	attestationTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Attestation"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	attestationBytes, err := x509.CreateCertificate(rand.Reader, attestationTemplate, attestationTemplate, key.Public(), attestationKey)
+	require.NoError(t, err)
+	signLocal := &server.StoreSignLocalRequest{
+		StoreGenerateRequest: server.StoreGenerateRequest{
+			Name: name,
+			CA:   "Local",
+		},
+		CSR:         string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes})),
+		Attestation: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: attestationBytes})),
+		Issuer:      "local0",
+		ValidFrom:   time.Now(),
+		ValidTo:     time.Now().Add(24 * 60 * time.Minute),
+	}
+	resp := doPut(t, client, storeLocalSignServiceUrl, signLocal)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	validCSRBytes := append([]byte{}, csrBytes...)
+	// tampered request
+	csrBytes[len(csrBytes)-1] ^= 0xff
+	signLocal.StoreGenerateRequest.Name = "signed1"
+	signLocal.CSR = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes}))
+	resp = doPut(t, client, storeLocalSignServiceUrl, signLocal)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	// name policy violation
+	signLocal.StoreGenerateRequest.Name = "signed2"
+	signLocal.CSR = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: validCSRBytes}))
+	signLocal.Attestation = ""
+	signLocal.Issuer = "local2"
+	resp = doPut(t, client, storeLocalSignServiceUrl, signLocal)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	// key policy violation
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	weakCSRBytes, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate, weakKey)
+	require.NoError(t, err)
+	signLocal.StoreGenerateRequest.Name = "signed3"
+	signLocal.CSR = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: weakCSRBytes}))
+	signLocal.Issuer = "local0"
+	resp = doPut(t, client, storeLocalSignServiceUrl, signLocal)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorResponse := &server.ServerErrorResponse{}
+	decodeJsonResponse(t, resp, errorResponse)
+	require.Equal(t, server.KeyPolicyViolation, errorResponse.Code)
+}
+
+func testStoreGenerateLocalPublicKey(t *testing.T, client *http.Client) {
+	const name = "pubkey0"
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	generateLocal := &server.StoreGenerateLocalRequest{
+		StoreGenerateRequest: server.StoreGenerateRequest{
+			Name: name,
+			CA:   "Local",
+		},
+		DN:        fmt.Sprintf(dnFormat, name),
+		ValidFrom: time.Now(),
+		ValidTo:   time.Now().Add(24 * 60 * time.Minute),
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes})),
+	}
+	// self-signed certificates require the key
+	resp := doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorResponse := &server.ServerErrorResponse{}
+	decodeJsonResponse(t, resp, errorResponse)
+	require.Equal(t, []server.FieldErrorResponse{{Field: "issuer", Error: "required"}}, errorResponse.Fields)
+	generateLocal.Issuer = "local0"
+	resp = doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	// weak keys are rejected by the key policy
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	weakPublicKeyBytes, err := x509.MarshalPKIXPublicKey(weakKey.Public())
+	require.NoError(t, err)
+	generateLocal.PublicKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: weakPublicKeyBytes}))
+	resp = doPut(t, client, storeLocalGenerateServiceUrl, generateLocal)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	decodeJsonResponse(t, resp, errorResponse)
+	require.Equal(t, server.KeyPolicyViolation, errorResponse.Code)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, name))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	details := &server.StoreEntryDetailsResponse{}
+	decodeJsonResponse(t, resp, details)
+	require.False(t, details.Key)
+	require.Equal(t, string(certs.KindCertificate), details.Kind)
+	require.Equal(t, fmt.Sprintf(dnFormat, name), details.DN)
+}
+
+func testDiff(t *testing.T, client *http.Client) {
+	diffRequest := &server.DiffRequest{
+		Old: server.VerifyRequest{Entry: "local0"},
+		New: server.VerifyRequest{Entry: "signed0"},
+	}
+	resp := doPost(t, client, diffServiceUrl, diffRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	diffResponse := &server.DiffResponse{}
+	decodeJsonResponse(t, resp, diffResponse)
+	fields := make([]string, 0, len(diffResponse.Diffs))
+	for _, diff := range diffResponse.Diffs {
+		fields = append(fields, diff.Field)
+	}
+	require.Contains(t, fields, "Subject")
+	require.Contains(t, fields, "Public Key")
+	resp = doPost(t, client, diffServiceUrl+"?text=true", diffRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	text, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(text), "Subject:\n    - ")
+	diffRequest.New = server.VerifyRequest{Entry: "unknown"}
+	resp = doPost(t, client, diffServiceUrl, diffRequest)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func testStoreEntryCompare(t *testing.T, client *http.Client) {
+	resp := doGet(t, client, fmt.Sprintf(storeEntryCompareServiceUrlPattern, "local2", "local10"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	compareResponse := &server.StoreEntryCompareResponse{}
+	decodeJsonResponse(t, resp, compareResponse)
+	require.False(t, compareResponse.Equal)
+	fields := make([]string, 0, len(compareResponse.Differences))
+	for _, difference := range compareResponse.Differences {
+		fields = append(fields, difference.Field)
+	}
+	require.Contains(t, fields, "key_type")
+	resp = doGet(t, client, fmt.Sprintf(storeEntryCompareServiceUrlPattern, "local2", "local2"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	compareResponse = &server.StoreEntryCompareResponse{}
+	decodeJsonResponse(t, resp, compareResponse)
+	require.True(t, compareResponse.Equal)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryCompareServiceUrlPattern, "local2", "unknown"))
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryCompareServiceUrlPattern, "local2", ""))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func testVerify(t *testing.T, client *http.Client) {
+	verifyRequest := &server.VerifyRequest{Entry: "signed0"}
+	resp := doPost(t, client, verifyServiceUrl, verifyRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	verifyResponse := &server.VerifyResponse{}
+	decodeJsonResponse(t, resp, verifyResponse)
+	require.True(t, verifyResponse.Valid)
+	require.Equal(t, 2, len(verifyResponse.Chain))
+	require.Equal(t, "signed0", verifyResponse.Chain[0].Entry)
+	require.Equal(t, "local0", verifyResponse.Chain[1].Entry)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "untrusted"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	verifyRequest = &server.VerifyRequest{CRT: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateBytes}))}
+	resp = doPost(t, client, verifyServiceUrl, verifyRequest)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	verifyResponse = &server.VerifyResponse{}
+	decodeJsonResponse(t, resp, verifyResponse)
+	require.False(t, verifyResponse.Valid)
+	require.NotEmpty(t, verifyResponse.Failures)
+	resp = doPost(t, client, verifyServiceUrl, &server.VerifyRequest{CRT: "invalid"})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func testMetrics(t *testing.T, client *http.Client) {
+	resp := doGet(t, client, metricsServiceUrl)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	metrics, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(metrics), "# TYPE certd_tls_check_drift gauge")
+}
+
+func testCTFindings(t *testing.T, client *http.Client) {
+	resp := doGet(t, client, ctFindingsServiceUrl)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	ctFindings := &server.CTFindingsResponse{}
+	decodeJsonResponse(t, resp, ctFindings)
+	require.False(t, ctFindings.Enabled)
+	require.Empty(t, ctFindings.Findings)
+}
+
+func testStoreTrust(t *testing.T, client *http.Client) {
+	t.Setenv("SSL_CERT_FILE", "../../pkg/certs/testdata/isrgrootx1.pem")
+	resp := doPut(t, client, storeTrustImportServiceUrl, &server.StoreTrustImportRequest{Name: "system", System: true})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	trustSource := &server.StoreTrustSourceResponse{}
+	decodeJsonResponse(t, resp, trustSource)
+	require.Equal(t, 1, trustSource.Certificates)
+	resp = doPut(t, client, storeTrustImportServiceUrl, &server.StoreTrustImportRequest{Name: "invalid"})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = doGet(t, client, storeTrustServiceUrl)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	storeTrust := &server.StoreTrustResponse{}
+	decodeJsonResponse(t, resp, storeTrust)
+	require.Equal(t, []server.StoreTrustSourceResponse{{Name: "system", Certificates: 1}}, storeTrust.Sources)
+}
+
 const remoteCertNameFormat = "remote%d"
 
 func testStoreGenerateRemote(t *testing.T, client *http.Client) {
@@ -207,10 +1773,30 @@ func testStoreGenerateRemote(t *testing.T, client *http.Client) {
 	}
 	resp := doPut(t, client, storeRemoteGenerateServiceUrl, generateRemote)
 	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = doGet(t, client, fmt.Sprintf(storeEntryDetailsServiceUrlPattern, name))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	storeEntryDetails := &server.StoreEntryDetailsResponse{}
+	decodeJsonResponse(t, resp, storeEntryDetails)
+	require.True(t, storeEntryDetails.CSR)
+	require.Equal(t, "ED25519", storeEntryDetails.CSRDetails.KeyType)
+	require.Equal(t, "Ed25519", storeEntryDetails.CSRDetails.SigAlg)
+	require.False(t, storeEntryDetails.CSRDetails.ChallengePassword)
+	require.Empty(t, storeEntryDetails.CSRDetails.SANs)
 }
 
 const acmeCertNameFormat = "acme%d"
 
+func testStoreACMEProviders(t *testing.T, client *http.Client) {
+	resp := doGet(t, client, storeACMEProvidersServiceUrl)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	acmeProviders := &server.StoreACMEProvidersResponse{}
+	decodeJsonResponse(t, resp, acmeProviders)
+	require.Equal(t, 1, len(acmeProviders.Providers))
+	require.Equal(t, "ACME:Test", acmeProviders.Providers[0].Name)
+	require.Equal(t, os.Getenv("CERTD_TEST_ACME_URL"), acmeProviders.Providers[0].DirectoryURL)
+	require.True(t, acmeProviders.Providers[0].Reachable)
+}
+
 func testStoreGenerateACME(t *testing.T, client *http.Client) {
 	name := fmt.Sprintf(acmeCertNameFormat, 0)
 	generateACME := &server.StoreGenerateACMERequest{
@@ -230,37 +1816,76 @@ func testShutdown(t *testing.T, client *http.Client) {
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
-func doGet(t *testing.T, client *http.Client, url string) *http.Response {
-	for retryCount := 0; ; retryCount += 1 {
-		time.Sleep(250 * time.Millisecond)
-		resp, err := client.Get(url)
-		if err == nil {
-			return resp
-		}
-		if retryCount >= 5 {
-			require.NoError(t, err)
-		}
-	}
+func doGet(t testing.TB, client *http.Client, url string) *http.Response {
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	return resp
 }
 
-func doPut(t *testing.T, client *http.Client, url string, v any) *http.Response {
+func doPut(t testing.TB, client *http.Client, url string, v any) *http.Response {
 	body, err := json.Marshal(v)
 	require.NoError(t, err)
-	for retryCount := 0; ; retryCount += 1 {
-		time.Sleep(250 * time.Millisecond)
-		req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
-		require.NoError(t, err)
-		resp, err := client.Do(req)
-		if err == nil {
-			return resp
-		}
-		if retryCount >= 5 {
-			require.NoError(t, err)
-		}
-	}
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func doPost(t *testing.T, client *http.Client, url string, v any) *http.Response {
+	body, err := json.Marshal(v)
+	require.NoError(t, err)
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	return resp
 }
 
 func decodeJsonResponse(t *testing.T, resp *http.Response, v any) {
 	err := json.NewDecoder(resp.Body).Decode(v)
 	require.NoError(t, err)
 }
+
+func newTestVault(t *testing.T) *httptest.Server {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Vault Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caBytes)
+	require.NoError(t, err)
+	serial := int64(1)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sign := make(map[string]string)
+		err := json.NewDecoder(r.Body).Decode(&sign)
+		require.NoError(t, err)
+		csrBlock, _ := pem.Decode([]byte(sign["csr"]))
+		require.NotNil(t, csrBlock)
+		csr, err := x509.ParseCertificateRequest(csrBlock.Bytes)
+		require.NoError(t, err)
+		serial++
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		certificateBytes, err := x509.CreateCertificate(rand.Reader, template, ca, csr.PublicKey, caKey)
+		require.NoError(t, err)
+		response := map[string]map[string]any{
+			"data": {
+				"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateBytes})),
+				"ca_chain":    []string{string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caBytes}))},
+			},
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/pkg/certs/memstore"
+	"github.com/hdecarne-github/certd/pkg/clock"
+	"github.com/hdecarne-github/certd/pkg/entropy"
+	"github.com/hdecarne-github/certd/pkg/keys"
+)
+
+// TestServer runs the server router on a httptest.Server listening on a random local port.
+//
+// Unlike Run no signal handlers are installed. Use Reload and Close instead of sending SIGHUP and SIGINT. Clock,
+// random source and key generation limiter are bound to the test server instance, hence multiple test servers can
+// be run in parallel.
+type TestServer struct {
+	*httptest.Server
+	s       *server
+	workDir string
+	release func()
+}
+
+// TestServerOption defines optional test server settings.
+type TestServerOption func(s *server)
+
+// Run the test server using the given clock (instead of the one defined by the testing configuration).
+func WithClock(clk clock.Clock) TestServerOption {
+	return func(s *server) {
+		s.clock = clk
+	}
+}
+
+// Run the test server using a deterministic random source derived from the given seed.
+func WithSeed(seed string) TestServerOption {
+	return func(s *server) {
+		s.random = entropy.NewDeterministicReader([]byte(seed))
+	}
+}
+
+// Run the test server using the given key generation limiter (instead of the one defined by the workers
+// configuration).
+func WithLimiter(limiter keys.Limiter) TestServerOption {
+	return func(s *server) {
+		s.keyLimiter = limiter
+	}
+}
+
+// NewTestServer creates a new unstarted test server.
+//
+// The server's listener is already allocated, so the final server URL can be determined via the Listener field
+// (e.g. to reference the server within the configuration passed to Start).
+func NewTestServer(options ...TestServerOption) *TestServer {
+	s := &server{
+		started: time.Now(),
+		sigint:  make(chan os.Signal, 1),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return &TestServer{Server: httptest.NewUnstartedServer(nil), s: s}
+}
+
+// Start the test server using the given configuration.
+//
+// Host and port of the configured server URL are replaced by the ones of the test listener; the URL's protocol and
+// path prefix are kept. If no store path is configured, an in-memory store is used. If no state path is configured,
+// the state is placed in a temporary directory. All resources allocated so far are released again in case Start
+// fails.
+func (ts *TestServer) Start(serverConfig *config.ServerConfig) error {
+	err := ts.start(serverConfig)
+	if err != nil {
+		ts.Close()
+	}
+	return err
+}
+
+func (ts *TestServer) start(serverConfig *config.ServerConfig) error {
+	testConfig := *serverConfig
+	if testConfig.StatePath == "" {
+		workDir, err := os.MkdirTemp("", "certd")
+		if err != nil {
+			return fmt.Errorf("failed to create test server directory (cause: %w)", err)
+		}
+		ts.workDir = workDir
+		testConfig.StatePath = filepath.Join(workDir, "state")
+	}
+	useTLS, _, prefix, err := splitServerURL(testConfig.ServerURL)
+	if err != nil {
+		return err
+	}
+	if useTLS {
+		testConfig.ServerURL = httpsPrefix + ts.Listener.Addr().String() + prefix
+	} else {
+		testConfig.ServerURL = httpPrefix + ts.Listener.Addr().String() + prefix
+	}
+	runtime, err := newServerRuntime(&testConfig)
+	if err != nil {
+		return err
+	}
+	logger := logging.ModuleLogger(logging.ModuleServer).With().Str("server", testConfig.ServerURL).Logger()
+	ts.s.logger = &logger
+	ts.s.runtime.Store(runtime)
+	if testConfig.StorePath == "" {
+		if ts.s.clock == nil {
+			ts.s.clock = testConfig.Testing.Clock()
+		}
+		ts.s.store = memstore.New("test", memstore.WithClock(ts.s.clock))
+	}
+	ts.release, err = ts.s.prepare()
+	if err != nil {
+		return err
+	}
+	router, err := ts.s.setupRouter(prefix)
+	if err != nil {
+		return err
+	}
+	ts.Config.Handler = router
+	if useTLS {
+		tlsConfig, err := ts.s.tlsConfig()
+		if err != nil {
+			return err
+		}
+		// httptest falls back to its own certificate unless a static one is given
+		tlsConfig.Certificates = []tls.Certificate{*ts.s.tlsCertificate.Load()}
+		ts.TLS = tlsConfig
+		ts.StartTLS()
+	} else {
+		ts.Server.Start()
+	}
+	return nil
+}
+
+// Reload the test server's configuration file (like sending SIGHUP to a server started via Run).
+func (ts *TestServer) Reload() error {
+	return ts.s.reload()
+}
+
+// Client returns a HTTP client for accessing the test server.
+//
+// In addition to the client returned by httptest.Server, relative request URLs are resolved against the test
+// server's URL.
+func (ts *TestServer) Client() *http.Client {
+	client := *ts.Server.Client()
+	client.Transport = &testServerTransport{baseURL: ts.URL, transport: client.Transport}
+	return &client
+}
+
+// Close stops the test server and releases all resources allocated during Start.
+func (ts *TestServer) Close() {
+	ts.Server.Close()
+	if ts.release != nil {
+		ts.release()
+		ts.release = nil
+	}
+	if ts.workDir != "" {
+		os.RemoveAll(ts.workDir)
+		ts.workDir = ""
+	}
+}
+
+type testServerTransport struct {
+	baseURL   string
+	transport http.RoundTripper
+}
+
+func (t *testServerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !req.URL.IsAbs() {
+		base, err := url.Parse(t.baseURL)
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.URL.Scheme = base.Scheme
+		req.URL.Host = base.Host
+		req.Host = base.Host
+	}
+	return t.transport.RoundTrip(req)
+}
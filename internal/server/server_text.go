@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+const errorEntryHasNoCertificateOrRequest = "Store entry has neither certificate nor certificate request"
+
+func (s *server) storeEntryText(c *gin.Context) {
+	name := c.Param("name")
+	storeEntry, err := s.store.Entry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	var text strings.Builder
+	var block *pem.Block
+	if storeEntry.HasCertificate() {
+		certificate, err := storeEntry.Certificate()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		err = certs.WriteCertificateText(&text, certificate)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		block = &pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw}
+	} else if storeEntry.HasCertificateRequest() {
+		request, err := storeEntry.CertificateRequest()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		err = certs.WriteCertificateRequestText(&text, request)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		block = &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: request.Raw}
+	} else {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorEntryHasNoCertificateOrRequest})
+		return
+	}
+	if c.Query("pem") == "true" {
+		text.Write(pem.EncodeToMemory(block))
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(text.String()))
+}
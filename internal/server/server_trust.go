@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/ginextra"
+	"github.com/hdecarne-github/certd/internal/trust"
+)
+
+const errorInvalidTrustSource = "Invalid trust source"
+const errorTrustImportFailure = "Trust import failed"
+
+func (s *server) storeTrust(c *gin.Context) {
+	storeTrust, err := s.store.Trust()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	response := &StoreTrustResponse{Sources: make([]StoreTrustSourceResponse, 0, len(storeTrust))}
+	for source, certificates := range storeTrust {
+		response.Sources = append(response.Sources, StoreTrustSourceResponse{
+			Name:         source,
+			Certificates: len(certificates),
+		})
+	}
+	sort.Slice(response.Sources, func(i, j int) bool {
+		return response.Sources[i].Name < response.Sources[j].Name
+	})
+	ginextra.ConditionalJSON(c, response)
+}
+
+func (s *server) storeTrustImport(c *gin.Context) {
+	importRequest := &StoreTrustImportRequest{}
+	err := json.NewDecoder(c.Request.Body).Decode(importRequest)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	if importRequest.Name == "" || importRequest.System == (importRequest.URL != "") {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidTrustSource})
+		return
+	}
+	source := &config.TrustSourceConfig{
+		Name:   importRequest.Name,
+		System: importRequest.System,
+		URL:    importRequest.URL,
+	}
+	imported, err := trust.Import(s.store, source)
+	if err != nil {
+		s.requestLogger(c).Error().Err(err).Msgf("Failed to import trust source '%s'", source.Name)
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorTrustImportFailure})
+		return
+	}
+	response := &StoreTrustSourceResponse{
+		Name:         source.Name,
+		Certificates: imported,
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+const errorTSANotAvailable = "Timestamp authority not available"
+
+const maxTimestampRequestSize = 64 * 1024
+
+func (s *server) tsa(c *gin.Context) {
+	if c.ContentType() != certs.TimestampQueryContentType {
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	tsa, err := s.timestampAuthority()
+	if err != nil {
+		s.requestLogger(c).Error().Err(err).Msg("Timestamp authority not available")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, &ServerErrorResponse{Message: errorTSANotAvailable})
+		return
+	}
+	requestBytes, err := io.ReadAll(io.LimitReader(c.Request.Body, maxTimestampRequestSize))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	responseBytes, err := tsa.Respond(requestBytes, time.Now())
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Data(http.StatusOK, certs.TimestampReplyContentType, responseBytes)
+}
+
+func (s *server) timestampAuthority() (*certs.TimestampAuthority, error) {
+	tsaConfig := &s.config().TSA
+	name := tsaConfig.Entry
+	if name == "" {
+		return nil, fmt.Errorf("no timestamp authority entry configured")
+	}
+	policy, err := certs.ParseOID(tsaConfig.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp policy '%s' (cause: %w)", tsaConfig.Policy, err)
+	}
+	storeEntry, err := s.store.Entry(name)
+	if err != nil {
+		return nil, err
+	}
+	if !storeEntry.HasKey() || !storeEntry.HasCertificate() {
+		return nil, fmt.Errorf("incomplete timestamp authority entry '%s'", name)
+	}
+	key, err := storeEntry.Key()
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := storeEntry.Certificate()
+	if err != nil {
+		return nil, err
+	}
+	return certs.NewTimestampAuthority(certificate, s.resolveIssuerChain(name, certificate), key, policy)
+}
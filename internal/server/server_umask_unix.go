@@ -0,0 +1,40 @@
+//go:build unix
+
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"syscall"
+)
+
+func (s *server) applyUmask() error {
+	umaskString := s.config().Umask
+	if umaskString == "" {
+		return nil
+	}
+	umask, err := strconv.ParseUint(umaskString, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid umask '%s' (cause: %w)", umaskString, err)
+	}
+	previous := syscall.Umask(int(umask))
+	s.logger.Info().Msgf("Applied umask %04o (previous: %04o)", umask, previous)
+	return nil
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/keys/registry"
+)
+
+// Implemented by requests supporting field level validation.
+type validatedRequest interface {
+	validate(s *server, v *fieldValidator)
+}
+
+// Collects the field level errors of a single request.
+type fieldValidator struct {
+	errors []FieldErrorResponse
+}
+
+func (v *fieldValidator) fail(field string, format string, args ...any) {
+	v.errors = append(v.errors, FieldErrorResponse{Field: field, Error: fmt.Sprintf(format, args...)})
+}
+
+func (v *fieldValidator) required(field string, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.fail(field, "required")
+		return false
+	}
+	return true
+}
+
+func (v *fieldValidator) entryName(field string, name string) {
+	if !v.required(field, name) {
+		return
+	}
+	if strings.ContainsAny(name, "/\\") {
+		v.fail(field, "must not contain path separators")
+	} else if strings.HasPrefix(name, ".") {
+		v.fail(field, "must not start with '.'")
+	}
+}
+
+func (v *fieldValidator) dn(field string, dn string) {
+	if dn == "" {
+		return
+	}
+	_, err := certs.ParseDN(dn)
+	if err != nil {
+		v.fail(field, "invalid DN (%s)", err.Error())
+	}
+}
+
+func (v *fieldValidator) validity(validFrom time.Time, validTo time.Time) {
+	if !validFrom.IsZero() && !validTo.IsZero() && !validTo.After(validFrom) {
+		v.fail("valid_to", "must be after valid_from")
+	}
+}
+
+func (v *fieldValidator) basicConstraint(spec *BasicConstraintExtensionSpec) {
+	if spec.Enabled && spec.PathLen < -1 {
+		v.fail("basic_constraint.path_len", "must be -1 (unlimited) or greater")
+	}
+}
+
+func (v *fieldValidator) domains(field string, domains []string, required bool) {
+	if len(domains) == 0 {
+		if required {
+			v.fail(field, "required")
+		}
+		return
+	}
+	for i, domain := range domains {
+		domainField := fmt.Sprintf("%s[%d]", field, i)
+		if !v.required(domainField, domain) {
+			continue
+		}
+		if strings.ContainsAny(domain, " /\\:") {
+			v.fail(domainField, "invalid domain '%s'", domain)
+		}
+	}
+}
+
+func (v *fieldValidator) keyType(s *server, field string, keyType string, pivAllowed bool) {
+	if !v.required(field, keyType) {
+		return
+	}
+	if pivAllowed && keyType == pivKeyType {
+		return
+	}
+	_, err := registry.FactoryFor(keyType)
+	if err != nil {
+		v.fail(field, "unrecognized key type '%s'", keyType)
+	}
+}
+
+func (request *StoreGenerateLocalRequest) validate(s *server, v *fieldValidator) {
+	v.entryName("name", request.Name)
+	if request.PublicKey != "" {
+		// key is held elsewhere; the certificate must be issued by a store CA
+		v.required("issuer", request.Issuer)
+		_, err := certs.DecodePublicKey([]byte(request.PublicKey))
+		if err != nil {
+			v.fail("public_key", "invalid public key or certificate request (%s)", err.Error())
+		}
+	} else {
+		v.keyType(s, "key_type", request.KeyType, true)
+	}
+	v.dn("dn", request.DN)
+	v.validity(request.ValidFrom, request.ValidTo)
+	v.basicConstraint(&request.BasicConstraint)
+	for i, extension := range request.CustomExtensions {
+		oidField := fmt.Sprintf("custom_extensions[%d].oid", i)
+		if !v.required(oidField, extension.OID) {
+			continue
+		}
+		_, err := certs.ParseOID(extension.OID)
+		if err != nil {
+			v.fail(oidField, "invalid OID '%s'", extension.OID)
+		}
+	}
+}
+
+func (request *StoreSignLocalRequest) validate(s *server, v *fieldValidator) {
+	v.entryName("name", request.Name)
+	v.required("csr", request.CSR)
+	v.validity(request.ValidFrom, request.ValidTo)
+	v.basicConstraint(&request.BasicConstraint)
+}
+
+func (request *StoreGenerateRemoteRequest) validate(s *server, v *fieldValidator) {
+	v.entryName("name", request.Name)
+	v.keyType(s, "key_type", request.KeyType, false)
+	if v.required("dn", request.DN) {
+		v.dn("dn", request.DN)
+	}
+}
+
+func (request *StoreGenerateACMERequest) validate(s *server, v *fieldValidator) {
+	v.entryName("name", request.Name)
+	v.required("ca", request.CA)
+	v.keyType(s, "key_type", request.KeyType, false)
+	v.domains("domains", request.Domains, true)
+}
+
+func (request *StoreGenerateProviderRequest) validate(s *server, v *fieldValidator) {
+	v.entryName("name", request.Name)
+	v.required("ca", request.CA)
+	v.keyType(s, "key_type", request.KeyType, false)
+	v.dn("dn", request.DN)
+	v.domains("domains", request.Domains, false)
+}
+
+// Decode and validate the request body.
+//
+// In case of an invalid request, the request is aborted with the collected field errors and false is returned.
+func (s *server) decodeValidatedRequest(c *gin.Context, request validatedRequest) bool {
+	err := json.NewDecoder(c.Request.Body).Decode(request)
+	if err != nil {
+		var typeError *json.UnmarshalTypeError
+		if errors.As(err, &typeError) && typeError.Field != "" {
+			s.abortValidationFailed(c, []FieldErrorResponse{{Field: typeError.Field, Error: fmt.Sprintf("invalid value type (%s expected)", typeError.Type.String())}})
+		} else {
+			c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest, Details: err.Error()})
+		}
+		return false
+	}
+	v := &fieldValidator{}
+	request.validate(s, v)
+	if len(v.errors) > 0 {
+		s.abortValidationFailed(c, v.errors)
+		return false
+	}
+	return true
+}
+
+func (s *server) abortValidationFailed(c *gin.Context, fieldErrors []FieldErrorResponse) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{
+		Message: errorInvalidRequest,
+		Code:    ValidationFailed,
+		Fields:  fieldErrors,
+	})
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+const errorVerifyInvalidCRT = "Invalid certificate"
+
+func (s *server) verify(c *gin.Context) {
+	verifyRequest := &VerifyRequest{}
+	err := json.NewDecoder(c.Request.Body).Decode(verifyRequest)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorInvalidRequest})
+		return
+	}
+	certificate := s.requestCertificate(c, verifyRequest.Entry, verifyRequest.CRT)
+	if certificate == nil {
+		return
+	}
+	trustAnchors, err := s.collectTrustAnchors()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	response := trustAnchors.verify(certificate, time.Now())
+	c.JSON(http.StatusOK, response)
+}
+
+// Resolve the certificate of a request given either by store entry name or PEM/DER data.
+//
+// Returns nil, if the certificate could not be resolved (in which case the request has been aborted).
+func (s *server) requestCertificate(c *gin.Context, entry string, crt string) *x509.Certificate {
+	var certificate *x509.Certificate
+	if entry != "" {
+		storeEntry, err := s.store.Entry(entry)
+		if errors.Is(err, fs.ErrNotExist) {
+			c.AbortWithStatusJSON(http.StatusNotFound, &ServerErrorResponse{Message: errorEntryNotFound})
+			return nil
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return nil
+		}
+		certificate, err = storeEntry.Certificate()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return nil
+		}
+	} else {
+		decoded, err := certs.DecodeCertificates([]byte(crt))
+		if err == nil && len(decoded) > 0 {
+			certificate = decoded[0]
+		}
+	}
+	if certificate == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ServerErrorResponse{Message: errorVerifyInvalidCRT})
+		return nil
+	}
+	return certificate
+}
+
+type trustAnchors struct {
+	roots           *x509.CertPool
+	intermediates   *x509.CertPool
+	entries         map[string]string
+	revocationLists map[string]*x509.RevocationList
+}
+
+func (s *server) collectTrustAnchors() (*trustAnchors, error) {
+	anchors := &trustAnchors{
+		roots:           x509.NewCertPool(),
+		intermediates:   x509.NewCertPool(),
+		entries:         make(map[string]string),
+		revocationLists: make(map[string]*x509.RevocationList),
+	}
+	trust, err := s.store.Trust()
+	if err != nil {
+		return nil, err
+	}
+	for _, trustedCertificates := range trust {
+		for _, trustedCertificate := range trustedCertificates {
+			anchors.roots.AddCert(trustedCertificate)
+		}
+	}
+	storeEntries := s.store.Entries()
+	for {
+		storeEntry := storeEntries.Next()
+		if storeEntry == nil {
+			break
+		}
+		if !storeEntry.HasCertificate() {
+			continue
+		}
+		certificate, err := storeEntry.Certificate()
+		if err != nil {
+			// broken entries are reported via the store listing
+			continue
+		}
+		anchors.entries[string(certificate.Raw)] = storeEntry.Name()
+		if !certificate.IsCA {
+			continue
+		}
+		if certs.IsIssuedBy(certificate, certificate) {
+			anchors.roots.AddCert(certificate)
+		} else {
+			anchors.intermediates.AddCert(certificate)
+		}
+		if storeEntry.HasRevocationList() {
+			revocationList, err := storeEntry.RevocationList()
+			if err != nil {
+				return nil, err
+			}
+			anchors.revocationLists[string(certificate.Raw)] = revocationList
+		}
+	}
+	return anchors, nil
+}
+
+func (anchors *trustAnchors) verify(certificate *x509.Certificate, now time.Time) *VerifyResponse {
+	response := &VerifyResponse{
+		Chain:    make([]VerifyChainElementResponse, 0),
+		Failures: make([]string, 0),
+	}
+	options := x509.VerifyOptions{
+		Roots:         anchors.roots,
+		Intermediates: anchors.intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	chains, err := certificate.Verify(options)
+	if err != nil {
+		response.Failures = append(response.Failures, err.Error())
+		// retry at the start of the validity period to at least report the chain
+		options.CurrentTime = certificate.NotBefore
+		chains, err = certificate.Verify(options)
+		if err != nil {
+			chains = [][]*x509.Certificate{{certificate}}
+		}
+	}
+	chain := chains[0]
+	for i, chainCertificate := range chain {
+		response.Chain = append(response.Chain, VerifyChainElementResponse{
+			DN:        chainCertificate.Subject.String(),
+			Entry:     anchors.entries[string(chainCertificate.Raw)],
+			ValidFrom: chainCertificate.NotBefore,
+			ValidTo:   chainCertificate.NotAfter,
+		})
+		if i == 0 {
+			continue
+		}
+		if chainCertificate.KeyUsage != 0 && (chainCertificate.KeyUsage&x509.KeyUsageCertSign) == 0 {
+			response.Failures = append(response.Failures, fmt.Sprintf("issuer '%s' is not allowed to sign certificates", chainCertificate.Subject))
+		}
+		revocationList := anchors.revocationLists[string(chainCertificate.Raw)]
+		if revocationList != nil {
+			err = certs.CheckRevocation(chain[i-1], chainCertificate, revocationList, now)
+			if err != nil {
+				response.Failures = append(response.Failures, err.Error())
+			}
+		}
+	}
+	response.Valid = len(response.Failures) == 0
+	return response
+}
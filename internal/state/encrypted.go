@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package state
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"github.com/hdecarne-github/certd/internal/logging"
+)
+
+var encryptedStateMagic = []byte("certd-state-v1:")
+
+// Wrap the given handler, encrypting all state data using AES-GCM with the given key.
+//
+// The key must be 16, 24 or 32 bytes long. State data written before encryption has been enabled is still readable
+// and becomes encrypted as soon as it is written the next time.
+func NewEncryptedHandler(handler Handler, key []byte) (Handler, error) {
+	return newEncryptedHandler(handler, key)
+}
+
+func newEncryptedHandler(handler Handler, key []byte) (*encryptedHandler, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create state cipher (cause: %w)", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create state cipher (cause: %w)", err)
+	}
+	return &encryptedHandler{handler: handler, aead: aead}, nil
+}
+
+type encryptedHandler struct {
+	handler Handler
+	aead    cipher.AEAD
+}
+
+func (handler *encryptedHandler) Write(path string, data []byte) error {
+	encrypted, err := handler.seal(path, data)
+	if err != nil {
+		return err
+	}
+	return handler.handler.Write(path, encrypted)
+}
+
+func (handler *encryptedHandler) Read(path string) ([]byte, error) {
+	encrypted, err := handler.handler.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(encrypted, encryptedStateMagic) {
+		logging.RootLogger().Warn().Msgf("Reading unencrypted state '%s'", path)
+		return encrypted, nil
+	}
+	return handler.open(path, encrypted)
+}
+
+func (handler *encryptedHandler) seal(path string, data []byte) ([]byte, error) {
+	nonce := make([]byte, handler.aead.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce (cause: %w)", err)
+	}
+	encrypted := append([]byte{}, encryptedStateMagic...)
+	encrypted = append(encrypted, nonce...)
+	return handler.aead.Seal(encrypted, nonce, data, []byte(path)), nil
+}
+
+func (handler *encryptedHandler) open(path string, encrypted []byte) ([]byte, error) {
+	encrypted = encrypted[len(encryptedStateMagic):]
+	nonceSize := handler.aead.NonceSize()
+	if len(encrypted) < nonceSize {
+		return nil, fmt.Errorf("invalid encrypted state '%s'", path)
+	}
+	data, err := handler.aead.Open(nil, encrypted[:nonceSize], encrypted[nonceSize:], []byte(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt state '%s' (cause: %w)", path, err)
+	}
+	return data, nil
+}
+
+func (handler *encryptedHandler) String() string {
+	return fmt.Sprintf("encrypted %s", handler.handler)
+}
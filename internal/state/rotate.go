@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package state
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/hdecarne-github/certd/internal/logging"
+)
+
+// Purpose of the store derived key used for state encryption.
+const KeyPurpose = "state"
+
+// Length of the store derived key used for state encryption.
+const KeyLength = 32
+
+var registeredPaths []string
+
+// Register a state path whose data has to be re-encrypted when the state key changes (see Rotation).
+//
+// Packages persisting state register their state paths during initialization.
+func RegisterPath(path string) {
+	registeredPaths = append(registeredPaths, path)
+}
+
+// Rotation re-encrypts the registered state paths of a handler during a store secret rotation.
+//
+// It implements the secret rotation hook of the FS store: Stage reads and re-encrypts the state data in memory, Commit
+// writes it and Rollback restores the original data.
+type Rotation struct {
+	handler Handler
+	staged  []rotatedState
+}
+
+type rotatedState struct {
+	path     string
+	original []byte
+	rotated  []byte
+}
+
+// Create a rotation for the state data stored via the given (unencrypted) handler.
+func NewRotation(handler Handler) *Rotation {
+	return &Rotation{handler: handler}
+}
+
+// Re-encrypt the registered state paths from the previous to the next store derived key (without writing them).
+//
+// Unencrypted state data as well as state data already encrypted with the next key (e.g. by an interrupted rotation)
+// is left untouched. State data not decryptable with either key fails the rotation.
+func (rotation *Rotation) Stage(previousKey func(purpose string, length int) ([]byte, error), nextKey func(purpose string, length int) ([]byte, error)) error {
+	previous, err := rotation.newEncryptedHandler(previousKey)
+	if err != nil {
+		return err
+	}
+	next, err := rotation.newEncryptedHandler(nextKey)
+	if err != nil {
+		return err
+	}
+	staged := make([]rotatedState, 0, len(registeredPaths))
+	for _, path := range registeredPaths {
+		original, err := rotation.handler.Read(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(original, encryptedStateMagic) {
+			continue
+		}
+		data, err := previous.open(path, original)
+		if err != nil {
+			_, nextErr := next.open(path, original)
+			if nextErr == nil {
+				continue
+			}
+			return err
+		}
+		rotated, err := next.seal(path, data)
+		if err != nil {
+			return err
+		}
+		staged = append(staged, rotatedState{path: path, original: original, rotated: rotated})
+	}
+	rotation.staged = staged
+	return nil
+}
+
+func (rotation *Rotation) newEncryptedHandler(deriveKey func(purpose string, length int) ([]byte, error)) (*encryptedHandler, error) {
+	key, err := deriveKey(KeyPurpose, KeyLength)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptedHandler(rotation.handler, key)
+}
+
+// Write the re-encrypted state data (already written state data is restored in case of an error).
+func (rotation *Rotation) Commit() error {
+	for i, state := range rotation.staged {
+		err := rotation.handler.Write(state.path, state.rotated)
+		if err != nil {
+			rotation.restore(rotation.staged[:i])
+			return fmt.Errorf("failed to write re-encrypted state '%s' (cause: %w)", state.path, err)
+		}
+	}
+	if len(rotation.staged) > 0 {
+		logging.RootLogger().Info().Msgf("Re-encrypted %d state entries", len(rotation.staged))
+	}
+	return nil
+}
+
+// Restore the original state data (after a successful Commit).
+func (rotation *Rotation) Rollback() {
+	rotation.restore(rotation.staged)
+}
+
+func (rotation *Rotation) restore(staged []rotatedState) {
+	for _, state := range staged {
+		err := rotation.handler.Write(state.path, state.original)
+		if err != nil {
+			logging.RootLogger().Error().Err(err).Msgf("Failed to restore state '%s' during secret rotation rollback", state.path)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package state
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+
+	// supported SQL drivers
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+var validSQLTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Create a state handler storing state data in a SQL database table.
+//
+// Supported drivers are "sqlite", "postgres" and "mysql". The table is created if it does not already exist.
+func NewSQLHandler(driver string, dsn string, table string) (Handler, error) {
+	if !validSQLTableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid state table name '%s'", table)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state database (driver: '%s'; cause: %w)", driver, err)
+	}
+	if driver == "sqlite" {
+		// SQLite allows a single writer only (concurrent connections fail with SQLITE_BUSY)
+		db.SetMaxOpenConns(1)
+	}
+	handler := &sqlHandler{
+		db:          db,
+		driver:      driver,
+		table:       table,
+		placeholder: sqlPlaceholder(driver),
+	}
+	_, err = db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (path VARCHAR(255) PRIMARY KEY, data %s)", table, sqlDataType(driver)))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create state table '%s' (cause: %w)", table, err)
+	}
+	return handler, nil
+}
+
+type sqlHandler struct {
+	db          *sql.DB
+	driver      string
+	table       string
+	placeholder func(int) string
+}
+
+func sqlPlaceholder(driver string) func(int) string {
+	switch driver {
+	case "postgres", "pgx":
+		return func(n int) string { return fmt.Sprintf("$%d", n) }
+	}
+	return func(int) string { return "?" }
+}
+
+func sqlDataType(driver string) string {
+	switch driver {
+	case "postgres", "pgx":
+		return "BYTEA"
+	case "mysql":
+		return "LONGBLOB"
+	}
+	return "BLOB"
+}
+
+func (handler *sqlHandler) Write(path string, data []byte) error {
+	_, err := handler.db.Exec(sqlUpsert(handler.driver, handler.table, handler.placeholder), path, data)
+	if err != nil {
+		return fmt.Errorf("failed to write state '%s' (cause: %w)", path, err)
+	}
+	return nil
+}
+
+// Build the statement inserting or (in case the path already exists) updating a state row within a single statement.
+func sqlUpsert(driver string, table string, placeholder func(int) string) string {
+	insert := fmt.Sprintf("INSERT INTO %s (path, data) VALUES (%s, %s)", table, placeholder(1), placeholder(2))
+	switch driver {
+	case "mysql":
+		return insert + " ON DUPLICATE KEY UPDATE data = VALUES(data)"
+	}
+	return insert + " ON CONFLICT (path) DO UPDATE SET data = excluded.data"
+}
+
+func (handler *sqlHandler) Read(path string) ([]byte, error) {
+	query := fmt.Sprintf("SELECT data FROM %s WHERE path = %s", handler.table, handler.placeholder(1))
+	var data []byte
+	err := handler.db.QueryRow(query, path).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, os.ErrNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read state '%s' (cause: %w)", path, err)
+	}
+	return data, nil
+}
+
+func (handler *sqlHandler) String() string {
+	return fmt.Sprintf("SQL state handler; driver: '%s'; table: '%s'", handler.driver, handler.table)
+}
@@ -26,6 +26,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/hdecarne-github/certd/internal/config"
 	"github.com/hdecarne-github/certd/internal/logging"
 )
 
@@ -37,6 +38,19 @@ func UpdateHandler(handler Handler) {
 	stateHandler = handler
 }
 
+// Create the (unencrypted) state handler for the given state configuration.
+func NewHandler(config *config.StateConfig, statePath string) (Handler, error) {
+	switch config.Backend {
+	case "", "fs":
+		return NewFSHandler(statePath), nil
+	case "sql":
+		return NewSQLHandler(config.SQL.Driver, config.SQL.DSN, config.SQL.Table)
+	case "vault":
+		return NewVaultHandler(config.Vault.Address, config.Vault.Token, config.Vault.Mount, config.Vault.Prefix), nil
+	}
+	return nil, fmt.Errorf("unrecognized state backend '%s'", config.Backend)
+}
+
 type Handler interface {
 	Write(path string, data []byte) error
 	Read(path string) ([]byte, error)
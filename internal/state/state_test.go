@@ -18,8 +18,14 @@
 package state
 
 import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -60,3 +66,172 @@ func TestFSHandlerChecks(t *testing.T) {
 	err = Write(filepath.Join(os.TempDir(), "test.txt"), []byte("test"))
 	require.Error(t, err)
 }
+
+func TestEncryptedHandler(t *testing.T) {
+	stateDir, err := os.MkdirTemp("", "state")
+	require.NoError(t, err)
+	defer os.RemoveAll(stateDir)
+	fsHandler := NewFSHandler(stateDir)
+	err = fsHandler.Write("legacy.txt", []byte("legacy"))
+	require.NoError(t, err)
+	key := make([]byte, 32)
+	handler, err := NewEncryptedHandler(fsHandler, key)
+	require.NoError(t, err)
+	UpdateHandler(handler)
+	readWriteState(t)
+	raw, err := fsHandler.Read("state.txt")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(raw), string(encryptedStateMagic)))
+	require.NotContains(t, string(raw[len(encryptedStateMagic):]), "state")
+	legacy, err := Read("legacy.txt")
+	require.NoError(t, err)
+	require.Equal(t, "legacy", string(legacy))
+	err = fsHandler.Write("moved.txt", raw)
+	require.NoError(t, err)
+	_, err = Read("moved.txt")
+	require.Error(t, err)
+	_, err = NewEncryptedHandler(fsHandler, []byte("short"))
+	require.Error(t, err)
+}
+
+func TestRotation(t *testing.T) {
+	stateDir, err := os.MkdirTemp("", "state")
+	require.NoError(t, err)
+	defer os.RemoveAll(stateDir)
+	fsHandler := NewFSHandler(stateDir)
+	const rotatedFile = "rotated.txt"
+	const legacyFile = "legacy.txt"
+	defer func(paths []string) { registeredPaths = paths }(registeredPaths)
+	RegisterPath(rotatedFile)
+	RegisterPath(legacyFile)
+	RegisterPath("missing.txt")
+	previousKey := func(purpose string, length int) ([]byte, error) { return make([]byte, length), nil }
+	nextKey := func(purpose string, length int) ([]byte, error) { return []byte(strings.Repeat("k", length)), nil }
+	previous, err := NewEncryptedHandler(fsHandler, make([]byte, KeyLength))
+	require.NoError(t, err)
+	require.NoError(t, previous.Write(rotatedFile, []byte("rotated")))
+	require.NoError(t, fsHandler.Write(legacyFile, []byte("legacy")))
+	original, err := fsHandler.Read(rotatedFile)
+	require.NoError(t, err)
+	rotation := NewRotation(fsHandler)
+	require.NoError(t, rotation.Stage(previousKey, nextKey))
+	require.Len(t, rotation.staged, 1)
+	staged, err := fsHandler.Read(rotatedFile)
+	require.NoError(t, err)
+	require.Equal(t, original, staged)
+	require.NoError(t, rotation.Commit())
+	next, err := NewEncryptedHandler(fsHandler, []byte(strings.Repeat("k", KeyLength)))
+	require.NoError(t, err)
+	data, err := next.Read(rotatedFile)
+	require.NoError(t, err)
+	require.Equal(t, "rotated", string(data))
+	_, err = previous.Read(rotatedFile)
+	require.Error(t, err)
+	legacy, err := next.Read(legacyFile)
+	require.NoError(t, err)
+	require.Equal(t, "legacy", string(legacy))
+	// resuming an interrupted rotation skips already re-encrypted state
+	resumed := NewRotation(fsHandler)
+	require.NoError(t, resumed.Stage(previousKey, nextKey))
+	require.Empty(t, resumed.staged)
+	rotation.Rollback()
+	data, err = previous.Read(rotatedFile)
+	require.NoError(t, err)
+	require.Equal(t, "rotated", string(data))
+	otherKey := func(purpose string, length int) ([]byte, error) { return []byte(strings.Repeat("o", length)), nil }
+	require.Error(t, NewRotation(fsHandler).Stage(otherKey, nextKey))
+}
+
+func TestSQLHandler(t *testing.T) {
+	stateDir, err := os.MkdirTemp("", "state")
+	require.NoError(t, err)
+	defer os.RemoveAll(stateDir)
+	dsn := filepath.Join(stateDir, "state.db")
+	handler, err := NewSQLHandler("sqlite", dsn, "certd_state")
+	require.NoError(t, err)
+	UpdateHandler(handler)
+	readWriteState(t)
+	err = Write("binary.bin", []byte{0x00, 0xff, 0x00})
+	require.NoError(t, err)
+	handler, err = NewSQLHandler("sqlite", dsn, "certd_state")
+	require.NoError(t, err)
+	UpdateHandler(handler)
+	data, err := Read("binary.bin")
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x00, 0xff, 0x00}, data)
+}
+
+func TestSQLHandlerUpsert(t *testing.T) {
+	handler, err := NewSQLHandler("sqlite", filepath.Join(t.TempDir(), "state.db"), "certd_state")
+	require.NoError(t, err)
+	// writing identical data does not change any row
+	require.NoError(t, handler.Write("state.txt", []byte("state")))
+	require.NoError(t, handler.Write("state.txt", []byte("state")))
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- handler.Write("concurrent.txt", []byte("concurrent"))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	data, err := handler.Read("concurrent.txt")
+	require.NoError(t, err)
+	require.Equal(t, "concurrent", string(data))
+	require.Equal(t, "INSERT INTO state (path, data) VALUES (?, ?) ON DUPLICATE KEY UPDATE data = VALUES(data)", sqlUpsert("mysql", "state", sqlPlaceholder("mysql")))
+	require.Equal(t, "INSERT INTO state (path, data) VALUES ($1, $2) ON CONFLICT (path) DO UPDATE SET data = excluded.data", sqlUpsert("postgres", "state", sqlPlaceholder("postgres")))
+}
+
+func TestSQLHandlerChecks(t *testing.T) {
+	_, err := NewSQLHandler("unknown", "", "state")
+	require.Error(t, err)
+	_, err = NewSQLHandler("unknown", "", "state; DROP TABLE state")
+	require.Error(t, err)
+}
+
+func TestVaultHandler(t *testing.T) {
+	secrets := make(map[string]json.RawMessage)
+	var secretsLock sync.Mutex
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		const secretPrefix = "/v1/secret/data/certd/"
+		if !strings.HasPrefix(r.URL.Path, secretPrefix) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		secretPath := strings.TrimPrefix(r.URL.Path, secretPrefix)
+		secretsLock.Lock()
+		defer secretsLock.Unlock()
+		switch r.Method {
+		case http.MethodPost:
+			body, _ := io.ReadAll(r.Body)
+			secrets[secretPath] = body
+			w.WriteHeader(http.StatusOK)
+		case http.MethodGet:
+			secret, found := secrets[secretPath]
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"data":`))
+			w.Write(secret)
+			w.Write([]byte(`}`))
+		}
+	}))
+	defer vault.Close()
+	UpdateHandler(NewVaultHandler(vault.URL, "token", "secret", "certd"))
+	readWriteState(t)
+	UpdateHandler(NewVaultHandler(vault.URL, "invalid", "secret", "certd"))
+	_, err := Read("state.txt")
+	require.Error(t, err)
+}
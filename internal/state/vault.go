@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package state
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Create a state handler storing state data in a Vault KV (version 2) secrets engine.
+//
+// State data is stored base64 encoded in the "value" field of the secret <mount>/<prefix>/<path>.
+func NewVaultHandler(address string, token string, mount string, prefix string) Handler {
+	return &vaultHandler{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		mount:   strings.Trim(mount, "/"),
+		prefix:  strings.Trim(prefix, "/"),
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type vaultHandler struct {
+	address string
+	token   string
+	mount   string
+	prefix  string
+	client  *http.Client
+}
+
+type vaultSecret struct {
+	Data vaultSecretData `json:"data"`
+}
+
+type vaultSecretData struct {
+	Value string `json:"value"`
+}
+
+type vaultReadResponse struct {
+	Data vaultSecret `json:"data"`
+}
+
+func (handler *vaultHandler) Write(path string, data []byte) error {
+	secret := &vaultSecret{Data: vaultSecretData{Value: base64.StdEncoding.EncodeToString(data)}}
+	body, err := json.Marshal(secret)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state '%s' (cause: %w)", path, err)
+	}
+	req, err := http.NewRequest(http.MethodPost, handler.secretURL(path), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to prepare state write '%s' (cause: %w)", path, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := handler.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to write state '%s' (cause: %w)", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to write state '%s' (status: %s)", path, resp.Status)
+	}
+	return nil
+}
+
+func (handler *vaultHandler) Read(path string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, handler.secretURL(path), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare state read '%s' (cause: %w)", path, err)
+	}
+	resp, err := handler.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state '%s' (cause: %w)", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to read state '%s' (status: %s)", path, resp.Status)
+	}
+	secret := &vaultReadResponse{}
+	err = json.NewDecoder(resp.Body).Decode(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode state '%s' (cause: %w)", path, err)
+	}
+	data, err := base64.StdEncoding.DecodeString(secret.Data.Data.Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode state '%s' (cause: %w)", path, err)
+	}
+	return data, nil
+}
+
+func (handler *vaultHandler) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-Vault-Token", handler.token)
+	return handler.client.Do(req)
+}
+
+func (handler *vaultHandler) secretURL(path string) string {
+	secretPath := strings.Trim(path, "/")
+	if handler.prefix != "" {
+		secretPath = handler.prefix + "/" + secretPath
+	}
+	return fmt.Sprintf("%s/v1/%s/data/%s", handler.address, handler.mount, secretPath)
+}
+
+func (handler *vaultHandler) String() string {
+	return fmt.Sprintf("Vault state handler; address: '%s'; mount: '%s'", handler.address, handler.mount)
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package tlscheck
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/internal/metrics"
+	"github.com/hdecarne-github/certd/internal/notify"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/rs/zerolog"
+)
+
+const EventDrift = "tls_check_drift"
+const EventExpiry = "tls_check_expiry"
+const EventFailure = "tls_check_failure"
+
+var driftGauge = metrics.NewGauge("certd_tls_check_drift", "Whether the presented certificate differs from the expected store entry (1) or not (0)", "target", "entry")
+var expiryGauge = metrics.NewGauge("certd_tls_check_expiry_seconds", "Remaining validity of the presented certificate in seconds", "target", "entry")
+var failureGauge = metrics.NewGauge("certd_tls_check_failure", "Whether the last check of the target failed (1) or not (0)", "target", "entry")
+
+// Result of a single TLS endpoint check.
+type Result struct {
+	Target    config.TLSCheckTarget
+	Drift     bool
+	ExpiresIn time.Duration
+	Err       error
+}
+
+type Checker struct {
+	config   *config.TLSChecksConfig
+	store    certs.Store
+	notifier notify.Notifier
+	events   map[string]string
+	lock     sync.Mutex
+	logger   *zerolog.Logger
+}
+
+func NewChecker(config *config.TLSChecksConfig, store certs.Store, notifier notify.Notifier) *Checker {
+	logger := logging.RootLogger().With().Str("checker", "tls").Logger()
+	return &Checker{
+		config:   config,
+		store:    store,
+		notifier: notifier,
+		events:   make(map[string]string),
+		logger:   &logger,
+	}
+}
+
+// Check all configured targets, update the corresponding metrics and send notifications for changed results.
+func (checker *Checker) Run(ctx context.Context) {
+	for _, target := range checker.config.Targets {
+		if ctx.Err() != nil {
+			return
+		}
+		result := checker.Check(target)
+		checker.report(result)
+	}
+}
+
+// Check a single target.
+func (checker *Checker) Check(target config.TLSCheckTarget) *Result {
+	checker.logger.Debug().Msgf("Checking TLS endpoint '%s'...", target.Address)
+	result := &Result{Target: target}
+	presentedCertificates, err := certs.ServerCertificates("tcp", target.Address)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to retrieve certificates from '%s' (cause: %w)", target.Address, err)
+		return result
+	}
+	if len(presentedCertificates) == 0 {
+		result.Err = fmt.Errorf("no certificates presented by '%s'", target.Address)
+		return result
+	}
+	presentedCertificate := presentedCertificates[0]
+	result.ExpiresIn = time.Until(presentedCertificate.NotAfter)
+	storeEntry, err := checker.store.Entry(target.Entry)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to access store entry '%s' (cause: %w)", target.Entry, err)
+		return result
+	}
+	expectedCertificate, err := storeEntry.Certificate()
+	if err != nil || expectedCertificate == nil {
+		result.Err = fmt.Errorf("failed to access certificate of store entry '%s' (cause: %v)", target.Entry, err)
+		return result
+	}
+	result.Drift = !bytes.Equal(presentedCertificate.Raw, expectedCertificate.Raw)
+	return result
+}
+
+func (checker *Checker) report(result *Result) {
+	target := result.Target
+	var event *notify.Event
+	if result.Err != nil {
+		failureGauge.Set(1, target.Address, target.Entry)
+		event = notify.NewEvent(EventFailure, target.Address, result.Err.Error())
+	} else {
+		failureGauge.Set(0, target.Address, target.Entry)
+		expiryGauge.Set(result.ExpiresIn.Seconds(), target.Address, target.Entry)
+		if result.Drift {
+			driftGauge.Set(1, target.Address, target.Entry)
+			event = notify.NewEvent(EventDrift, target.Address, fmt.Sprintf("'%s' does not present the certificate of store entry '%s'", target.Address, target.Entry))
+		} else {
+			driftGauge.Set(0, target.Address, target.Entry)
+			if result.ExpiresIn < checker.config.ExpiryWarning {
+				event = notify.NewEvent(EventExpiry, target.Address, fmt.Sprintf("certificate presented by '%s' expires in %s", target.Address, result.ExpiresIn.Truncate(time.Minute)))
+			}
+		}
+	}
+	checker.lock.Lock()
+	defer checker.lock.Unlock()
+	eventType := ""
+	if event != nil {
+		eventType = event.Type
+	}
+	// only notify about changes to avoid repeated notifications for the same issue
+	if checker.events[target.Address] == eventType {
+		return
+	}
+	checker.events[target.Address] = eventType
+	if event != nil {
+		err := checker.notifier.Notify(event)
+		if err != nil {
+			checker.logger.Error().Err(err).Msgf("Failed to send notification for '%s'", target.Address)
+		}
+	}
+}
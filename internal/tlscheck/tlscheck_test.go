@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package tlscheck
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/notify"
+	"github.com/hdecarne-github/certd/pkg/certs/fsstore"
+	"github.com/hdecarne-github/certd/pkg/certs/local"
+	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker(t *testing.T) {
+	storePath, err := os.MkdirTemp("", "certd")
+	require.NoError(t, err)
+	defer os.RemoveAll(storePath)
+	store, err := fsstore.Init(filepath.Join(storePath, "store"))
+	require.NoError(t, err)
+	serverEntry, err := store.CreateCertificate(context.Background(), "server", local.NewLocalCertificateFactory(newTemplate(1), ecdsa.StandardKeys()[1], nil, nil))
+	require.NoError(t, err)
+	_, err = store.CreateCertificate(context.Background(), "other", local.NewLocalCertificateFactory(newTemplate(2), ecdsa.StandardKeys()[1], nil, nil))
+	require.NoError(t, err)
+	serverKey, err := serverEntry.Key()
+	require.NoError(t, err)
+	serverCertificate, err := serverEntry.Certificate()
+	require.NoError(t, err)
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{serverCertificate.Raw}, PrivateKey: serverKey}}}
+	server.StartTLS()
+	defer server.Close()
+	address := server.Listener.Addr().String()
+	notifier := &testNotifier{}
+	checkConfig := &config.TLSChecksConfig{
+		ExpiryWarning: 24 * time.Hour,
+		Targets: []config.TLSCheckTarget{
+			{Address: address, Entry: "server"},
+		},
+	}
+	checker := NewChecker(checkConfig, store, notifier)
+	result := checker.Check(checkConfig.Targets[0])
+	require.NoError(t, result.Err)
+	require.False(t, result.Drift)
+	require.Greater(t, result.ExpiresIn, time.Duration(0))
+	checker.Run(context.Background())
+	require.Empty(t, notifier.events)
+	checkConfig.Targets[0].Entry = "other"
+	checker.Run(context.Background())
+	checker.Run(context.Background())
+	require.Equal(t, 1, len(notifier.events))
+	require.Equal(t, EventDrift, notifier.events[0].Type)
+	checkConfig.Targets[0].Entry = "server"
+	checkConfig.ExpiryWarning = 48 * time.Hour
+	checker.Run(context.Background())
+	require.Equal(t, 2, len(notifier.events))
+	require.Equal(t, EventExpiry, notifier.events[1].Type)
+	server.Close()
+	checker.Run(context.Background())
+	require.Equal(t, 3, len(notifier.events))
+	require.Equal(t, EventFailure, notifier.events[2].Type)
+}
+
+func newTemplate(serial int64) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(36 * time.Hour),
+	}
+}
+
+type testNotifier struct {
+	events []*notify.Event
+}
+
+func (notifier *testNotifier) Notify(event *notify.Event) error {
+	notifier.events = append(notifier.events, event)
+	return nil
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package trust
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/rs/zerolog"
+)
+
+// Store receiving the imported trusted certificates.
+type Store interface {
+	UpdateTrust(source string, certificates []*x509.Certificate) error
+}
+
+// Fetch the certificates provided by the given trust source.
+func Fetch(source *config.TrustSourceConfig) ([]*x509.Certificate, error) {
+	if source.System {
+		return certs.SystemCertificates()
+	}
+	if source.URL != "" {
+		return certs.FetchCertificates(source.URL)
+	}
+	return nil, fmt.Errorf("trust source '%s' defines neither system nor URL", source.Name)
+}
+
+// Import the certificates provided by the given trust source into the given store.
+func Import(store Store, source *config.TrustSourceConfig) (int, error) {
+	certificates, err := Fetch(source)
+	if err != nil {
+		return 0, err
+	}
+	err = store.UpdateTrust(source.Name, certificates)
+	if err != nil {
+		return 0, err
+	}
+	return len(certificates), nil
+}
+
+type Refresher struct {
+	config *config.TrustConfig
+	store  Store
+	logger *zerolog.Logger
+}
+
+func NewRefresher(config *config.TrustConfig, store Store) *Refresher {
+	logger := logging.RootLogger().With().Str("refresher", "trust").Logger()
+	return &Refresher{
+		config: config,
+		store:  store,
+		logger: &logger,
+	}
+}
+
+// Re-import all configured trust sources.
+func (refresher *Refresher) Run(ctx context.Context) {
+	for i := range refresher.config.Sources {
+		if ctx.Err() != nil {
+			return
+		}
+		source := &refresher.config.Sources[i]
+		imported, err := Import(refresher.store, source)
+		if err != nil {
+			refresher.logger.Error().Err(err).Msgf("Failed to refresh trust source '%s'", source.Name)
+			continue
+		}
+		refresher.logger.Info().Msgf("Refreshed trust source '%s' (%d certificates)", source.Name, imported)
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package trust
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/hdecarne-github/certd/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefresher(t *testing.T) {
+	bundle, err := os.ReadFile("../../pkg/certs/testdata/isrgrootx1.pem")
+	require.NoError(t, err)
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bundle)
+	}))
+	defer remote.Close()
+	t.Setenv("SSL_CERT_FILE", "../../pkg/certs/testdata/lets-encrypt-r3.pem")
+	trustConfig := &config.TrustConfig{
+		Sources: []config.TrustSourceConfig{
+			{Name: "system", System: true},
+			{Name: "remote", URL: remote.URL},
+			{Name: "invalid"},
+		},
+	}
+	store := &testStore{trust: make(map[string][]*x509.Certificate)}
+	NewRefresher(trustConfig, store).Run(context.Background())
+	require.Equal(t, 2, len(store.trust))
+	require.Equal(t, "R3", store.trust["system"][0].Subject.CommonName)
+	require.Equal(t, "ISRG Root X1", store.trust["remote"][0].Subject.CommonName)
+}
+
+type testStore struct {
+	trust map[string][]*x509.Certificate
+}
+
+func (store *testStore) UpdateTrust(source string, certificates []*x509.Certificate) error {
+	store.trust[source] = certificates
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package workpool
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/metrics"
+)
+
+var sizeGauge = metrics.NewGauge("certd_worker_pool_size", "Maximum number of concurrently running tasks", "pool")
+var activeGauge = metrics.NewGauge("certd_worker_pool_active", "Number of currently running tasks", "pool")
+var queuedGauge = metrics.NewGauge("certd_worker_pool_queued", "Number of tasks waiting for a free worker", "pool")
+var waitGauge = metrics.NewGauge("certd_worker_pool_wait_seconds", "Time the most recently started task waited for a free worker", "pool")
+
+// Pool limits the number of concurrently running tasks of a kind.
+//
+// Tasks exceeding the limit are queued until a worker becomes available or
+// their context is done.
+type Pool struct {
+	name   string
+	slots  chan struct{}
+	queued int
+	lock   sync.Mutex
+}
+
+// Create a pool with the given name (used as metrics label) and size.
+//
+// A size less or equal to 0 limits the pool to the number of available CPUs.
+func New(name string, size int) *Pool {
+	if size <= 0 {
+		size = runtime.NumCPU()
+	}
+	pool := &Pool{
+		name:  name,
+		slots: make(chan struct{}, size),
+	}
+	sizeGauge.Set(float64(size), name)
+	activeGauge.Set(0, name)
+	queuedGauge.Set(0, name)
+	waitGauge.Set(0, name)
+	return pool
+}
+
+// Get the name of this pool.
+func (pool *Pool) Name() string {
+	return pool.name
+}
+
+// Get the maximum number of concurrently running tasks.
+func (pool *Pool) Size() int {
+	return cap(pool.slots)
+}
+
+// Wait for a free worker.
+//
+// On success the returned function must be invoked to release the worker
+// once the task is done.
+func (pool *Pool) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case pool.slots <- struct{}{}:
+		pool.started(0)
+		return pool.release, nil
+	default:
+	}
+	queuedSince := time.Now()
+	pool.queue(1)
+	select {
+	case pool.slots <- struct{}{}:
+		pool.queue(-1)
+		pool.started(time.Since(queuedSince))
+		return pool.release, nil
+	case <-ctx.Done():
+		pool.queue(-1)
+		return nil, ctx.Err()
+	}
+}
+
+func (pool *Pool) queue(delta int) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	pool.queued += delta
+	queuedGauge.Set(float64(pool.queued), pool.name)
+}
+
+func (pool *Pool) started(wait time.Duration) {
+	activeGauge.Set(float64(len(pool.slots)), pool.name)
+	waitGauge.Set(wait.Seconds(), pool.name)
+}
+
+func (pool *Pool) release() {
+	<-pool.slots
+	activeGauge.Set(float64(len(pool.slots)), pool.name)
+}
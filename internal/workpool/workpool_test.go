@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package workpool
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool(t *testing.T) {
+	pool := New("test", 1)
+	require.Equal(t, 1, pool.Size())
+	release, err := pool.Acquire(context.Background())
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = pool.Acquire(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	acquired := make(chan func())
+	go func() {
+		release2, err := pool.Acquire(context.Background())
+		require.NoError(t, err)
+		acquired <- release2
+	}()
+	require.Eventually(t, func() bool {
+		var builder strings.Builder
+		require.NoError(t, metrics.Write(&builder))
+		return strings.Contains(builder.String(), "certd_worker_pool_queued{pool=\"test\"} 1\n")
+	}, time.Second, time.Millisecond)
+	release()
+	release2 := <-acquired
+	release2()
+	var builder strings.Builder
+	require.NoError(t, metrics.Write(&builder))
+	require.Contains(t, builder.String(), "certd_worker_pool_active{pool=\"test\"} 0\n")
+	require.Contains(t, builder.String(), "certd_worker_pool_queued{pool=\"test\"} 0\n")
+	require.Contains(t, builder.String(), "certd_worker_pool_size{pool=\"test\"} 1\n")
+}
@@ -18,8 +18,6 @@
 package asn1
 
 import (
-	"bufio"
-	_ "embed"
 	"encoding/asn1"
 	"fmt"
 	"io"
@@ -27,24 +25,9 @@ import (
 	"strconv"
 	"strings"
 	"time"
-)
-
-//go:embed well-known-oids.txt
-var wellKnownOIDS string
-var wellKnownOIDSMap map[string]string = initWellKnownOIDSMap()
 
-func initWellKnownOIDSMap() map[string]string {
-	oidsMap := make(map[string]string, 0)
-	scanner := bufio.NewScanner(strings.NewReader(wellKnownOIDS))
-	for scanner.Scan() {
-		line := scanner.Text()
-		tokens := strings.SplitN(line, ":", 2)
-		if len(tokens) == 2 {
-			oidsMap[strings.TrimSpace(tokens[0])] = fmt.Sprintf(" -- %s", strings.TrimSpace(tokens[1]))
-		}
-	}
-	return oidsMap
-}
+	"github.com/hdecarne-github/certd/pkg/asn1/oids"
+)
 
 func DecodeASN1(out io.Writer, data []byte) error {
 	return decodeASN1(out, data, "")
@@ -147,7 +130,10 @@ func decodeOIDValue(out io.Writer, value *asn1.RawValue, indent string) error {
 		return err
 	}
 	oidString := oidValue.String()
-	oidName := wellKnownOIDSMap[oidString]
+	oidName := oids.Describe(oidString)
+	if oidName != "" {
+		oidName = " -- " + oidName
+	}
 	fmt.Fprintf(out, oidValueFormat, indent, tagName(value.Tag), oidString, oidName)
 	return nil
 }
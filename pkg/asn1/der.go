@@ -0,0 +1,274 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package asn1
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"fmt"
+	"sort"
+)
+
+// Decoded ASN.1 element.
+type Node struct {
+	// Offset of the element within the decoded data
+	Offset int
+	// Tag class (asn1.ClassUniversal, ...)
+	Class int
+	// Tag number
+	Tag int
+	// Whether the element is constructed
+	Constructed bool
+	// Content of a primitive element
+	Bytes []byte
+	// Child elements of a constructed element
+	Children []*Node
+}
+
+// Error returned in case the decoded data is not valid DER.
+type EncodingError struct {
+	Offset int
+	Reason string
+}
+
+func (err *EncodingError) Error() string {
+	return fmt.Sprintf("invalid DER encoding at offset %d (%s)", err.Offset, err.Reason)
+}
+
+// Decode the given data into a tree of ASN.1 elements.
+//
+// BER encodings (e.g. indefinite or non-minimal lengths) are accepted. Use VerifyDER for strict DER checking.
+func DecodeTree(data []byte) ([]*Node, error) {
+	return decodeNodes(data, 0, false)
+}
+
+// Encode the given ASN.1 elements using DER (i.e. definite and minimal length and tag encodings).
+func EncodeDER(nodes []*Node) []byte {
+	var encoded bytes.Buffer
+	for _, node := range nodes {
+		node.encode(&encoded)
+	}
+	return encoded.Bytes()
+}
+
+// Verify that the given data is valid DER.
+//
+// The data is decoded, checked for BER only encodings (e.g. indefinite lengths) and finally re-encoded to
+// verify that the re-encoding is byte-identical to the given data.
+func VerifyDER(data []byte) error {
+	nodes, err := decodeNodes(data, 0, true)
+	if err != nil {
+		return err
+	}
+	encoded := EncodeDER(nodes)
+	if !bytes.Equal(encoded, data) {
+		offset := 0
+		for offset < len(encoded) && offset < len(data) && encoded[offset] == data[offset] {
+			offset++
+		}
+		return &EncodingError{Offset: offset, Reason: "re-encoding differs"}
+	}
+	return nil
+}
+
+func (node *Node) encode(out *bytes.Buffer) {
+	identifier := byte(node.Class << 6)
+	if node.Constructed {
+		identifier |= 0x20
+	}
+	if node.Tag < 0x1f {
+		out.WriteByte(identifier | byte(node.Tag))
+	} else {
+		out.WriteByte(identifier | 0x1f)
+		out.Write(encodeBase128(node.Tag))
+	}
+	content := node.Bytes
+	if node.Constructed {
+		content = EncodeDER(node.Children)
+	}
+	out.Write(encodeLength(len(content)))
+	out.Write(content)
+}
+
+func encodeBase128(value int) []byte {
+	encoded := []byte{byte(value & 0x7f)}
+	for value >>= 7; value > 0; value >>= 7 {
+		encoded = append([]byte{byte(value&0x7f) | 0x80}, encoded...)
+	}
+	return encoded
+}
+
+func encodeLength(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+	encoded := []byte{}
+	for ; length > 0; length >>= 8 {
+		encoded = append([]byte{byte(length)}, encoded...)
+	}
+	return append([]byte{0x80 | byte(len(encoded))}, encoded...)
+}
+
+func decodeNodes(data []byte, offset int, strict bool) ([]*Node, error) {
+	nodes := make([]*Node, 0)
+	for position := 0; position < len(data); {
+		node, length, indefinite, err := decodeNode(data[position:], offset+position, strict)
+		if err != nil {
+			return nil, err
+		}
+		if indefinite {
+			return nil, &EncodingError{Offset: offset + position, Reason: "unexpected end-of-contents"}
+		}
+		nodes = append(nodes, node)
+		position += length
+	}
+	return nodes, nil
+}
+
+// Decode a single element and return the number of consumed bytes (or true, if the end-of-contents marker
+// of an indefinite length element has been decoded).
+func decodeNode(data []byte, offset int, strict bool) (*Node, int, bool, error) {
+	if len(data) < 2 {
+		return nil, 0, false, &EncodingError{Offset: offset, Reason: "truncated element"}
+	}
+	if data[0] == 0x00 && data[1] == 0x00 {
+		return nil, 2, true, nil
+	}
+	node := &Node{
+		Offset:      offset,
+		Class:       int(data[0] >> 6),
+		Constructed: data[0]&0x20 != 0,
+		Tag:         int(data[0] & 0x1f),
+	}
+	position := 1
+	if node.Tag == 0x1f {
+		node.Tag = 0
+		for {
+			if position >= len(data) {
+				return nil, 0, false, &EncodingError{Offset: offset, Reason: "truncated tag"}
+			}
+			if node.Tag == 0 && data[position] == 0x80 && strict {
+				return nil, 0, false, &EncodingError{Offset: offset, Reason: "non-minimal tag encoding"}
+			}
+			if node.Tag > (1<<23)-1 {
+				return nil, 0, false, &EncodingError{Offset: offset, Reason: "tag too large"}
+			}
+			node.Tag = node.Tag<<7 | int(data[position]&0x7f)
+			position++
+			if data[position-1]&0x80 == 0 {
+				break
+			}
+		}
+		if node.Tag < 0x1f && strict {
+			return nil, 0, false, &EncodingError{Offset: offset, Reason: "non-minimal tag encoding"}
+		}
+	}
+	if position >= len(data) {
+		return nil, 0, false, &EncodingError{Offset: offset, Reason: "truncated length"}
+	}
+	lengthByte := data[position]
+	position++
+	if lengthByte == 0x80 {
+		if strict {
+			return nil, 0, false, &EncodingError{Offset: offset, Reason: "indefinite length (BER)"}
+		}
+		if !node.Constructed {
+			return nil, 0, false, &EncodingError{Offset: offset, Reason: "indefinite length of primitive element"}
+		}
+		node.Children = make([]*Node, 0)
+		for {
+			child, length, end, err := decodeNode(data[position:], offset+position, strict)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			position += length
+			if end {
+				return node, position, false, nil
+			}
+			node.Children = append(node.Children, child)
+		}
+	}
+	length := int(lengthByte)
+	if lengthByte > 0x80 {
+		lengthLen := int(lengthByte & 0x7f)
+		if lengthLen == 0x7f || lengthLen > 4 || position+lengthLen > len(data) {
+			return nil, 0, false, &EncodingError{Offset: offset, Reason: "invalid length"}
+		}
+		length = 0
+		for _, lengthByte := range data[position : position+lengthLen] {
+			length = length<<8 | int(lengthByte)
+		}
+		if strict && (data[position] == 0x00 || length < 0x80) {
+			return nil, 0, false, &EncodingError{Offset: offset, Reason: "non-minimal length encoding"}
+		}
+		position += lengthLen
+	}
+	if length < 0 || position+length > len(data) {
+		return nil, 0, false, &EncodingError{Offset: offset, Reason: "truncated content"}
+	}
+	content := data[position : position+length]
+	if node.Constructed {
+		children, err := decodeNodes(content, offset+position, strict)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		node.Children = children
+	} else {
+		node.Bytes = content
+	}
+	if strict {
+		err := node.checkDER()
+		if err != nil {
+			return nil, 0, false, err
+		}
+	}
+	return node, position + length, false, nil
+}
+
+// Check the DER restrictions not covered by the tag and length encoding.
+func (node *Node) checkDER() error {
+	if node.Class != asn1.ClassUniversal {
+		return nil
+	}
+	switch node.Tag {
+	case asn1.TagBoolean:
+		if len(node.Bytes) != 1 || (node.Bytes[0] != 0x00 && node.Bytes[0] != 0xff) {
+			return &EncodingError{Offset: node.Offset, Reason: "invalid boolean encoding"}
+		}
+	case asn1.TagInteger, asn1.TagEnum:
+		if len(node.Bytes) == 0 {
+			return &EncodingError{Offset: node.Offset, Reason: "empty integer"}
+		}
+		if len(node.Bytes) > 1 && ((node.Bytes[0] == 0x00 && node.Bytes[1]&0x80 == 0) || (node.Bytes[0] == 0xff && node.Bytes[1]&0x80 != 0)) {
+			return &EncodingError{Offset: node.Offset, Reason: "non-minimal integer encoding"}
+		}
+	case asn1.TagBitString, asn1.TagOctetString, asn1.TagUTF8String, asn1.TagNumericString, asn1.TagPrintableString, asn1.TagT61String, asn1.TagIA5String, asn1.TagUTCTime, asn1.TagGeneralizedTime, asn1.TagGeneralString, asn1.TagBMPString:
+		if node.Constructed {
+			return &EncodingError{Offset: node.Offset, Reason: "constructed string encoding (BER)"}
+		}
+	case asn1.TagSet:
+		encodedChildren := make([][]byte, len(node.Children))
+		for i, child := range node.Children {
+			encodedChildren[i] = EncodeDER([]*Node{child})
+		}
+		if !sort.SliceIsSorted(encodedChildren, func(i, j int) bool { return bytes.Compare(encodedChildren[i], encodedChildren[j]) < 0 }) {
+			return &EncodingError{Offset: node.Offset, Reason: "unsorted set elements"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package asn1
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyDER(t *testing.T) {
+	certificate, err := os.ReadFile("./testdata/isrgrootx1.der")
+	require.NoError(t, err)
+	require.NoError(t, VerifyDER(certificate))
+	nodes, err := DecodeTree(certificate)
+	require.NoError(t, err)
+	require.Equal(t, certificate, EncodeDER(nodes))
+}
+
+func TestVerifyDERBER(t *testing.T) {
+	certificate, err := os.ReadFile("./testdata/isrgrootx1.der")
+	require.NoError(t, err)
+	nodes, err := DecodeTree(certificate)
+	require.NoError(t, err)
+	var ber bytes.Buffer
+	for _, node := range nodes {
+		encodeIndefinite(&ber, node)
+	}
+	requireEncodingError(t, VerifyDER(ber.Bytes()), "indefinite length (BER)")
+	berNodes, err := DecodeTree(ber.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, certificate, EncodeDER(berNodes))
+}
+
+func TestVerifyDERViolations(t *testing.T) {
+	requireEncodingError(t, VerifyDER([]byte{0x30, 0x81, 0x03, 0x02, 0x01, 0x01}), "non-minimal length encoding")
+	requireEncodingError(t, VerifyDER([]byte{0x01, 0x01, 0x01}), "invalid boolean encoding")
+	requireEncodingError(t, VerifyDER([]byte{0x02, 0x02, 0x00, 0x01}), "non-minimal integer encoding")
+	requireEncodingError(t, VerifyDER([]byte{0x31, 0x06, 0x02, 0x01, 0x02, 0x02, 0x01, 0x01}), "unsorted set elements")
+	requireEncodingError(t, VerifyDER([]byte{0x24, 0x03, 0x04, 0x01, 0x01}), "constructed string encoding (BER)")
+	requireEncodingError(t, VerifyDER([]byte{0x1f, 0x01, 0x00}), "non-minimal tag encoding")
+	requireEncodingError(t, VerifyDER([]byte{0x30, 0x03, 0x02, 0x01}), "truncated content")
+	require.NoError(t, VerifyDER([]byte{0x31, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x02}))
+}
+
+func encodeIndefinite(out *bytes.Buffer, node *Node) {
+	if !node.Constructed {
+		node.encode(out)
+		return
+	}
+	out.WriteByte(byte(node.Class<<6) | 0x20 | byte(node.Tag))
+	out.WriteByte(0x80)
+	for _, child := range node.Children {
+		encodeIndefinite(out, child)
+	}
+	out.Write([]byte{0x00, 0x00})
+}
+
+func requireEncodingError(t *testing.T, err error, reason string) {
+	var encodingErr *EncodingError
+	require.True(t, errors.As(err, &encodingErr))
+	require.Equal(t, reason, encodingErr.Reason)
+}
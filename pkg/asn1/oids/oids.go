@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oids
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/asn1"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Registered OID.
+type OID struct {
+	OID         string
+	Name        string
+	Description string
+}
+
+//go:embed oids.txt
+var oidsTxt string
+var oidsByOID, oidsByName = initOIDs()
+
+func initOIDs() (map[string]*OID, map[string]*OID) {
+	byOID := make(map[string]*OID, 0)
+	byName := make(map[string]*OID, 0)
+	scanner := bufio.NewScanner(strings.NewReader(oidsTxt))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens := strings.SplitN(line, ":", 3)
+		if len(tokens) < 2 {
+			continue
+		}
+		oid := &OID{
+			OID:  strings.TrimSpace(tokens[0]),
+			Name: strings.TrimSpace(tokens[1]),
+		}
+		if len(tokens) == 3 {
+			oid.Description = strings.TrimSpace(tokens[2])
+		}
+		byOID[oid.OID] = oid
+		byName[oid.Name] = oid
+	}
+	return byOID, byName
+}
+
+// Parse an OID in dotted notation (e.g. "2.5.4.3").
+func Parse(oid string) (asn1.ObjectIdentifier, error) {
+	arcs := strings.Split(oid, ".")
+	if len(arcs) < 2 {
+		return nil, fmt.Errorf("invalid OID '%s'", oid)
+	}
+	parsed := make(asn1.ObjectIdentifier, len(arcs))
+	for i, arc := range arcs {
+		value, err := strconv.Atoi(arc)
+		if err != nil || value < 0 || strings.HasPrefix(arc, "+") {
+			return nil, fmt.Errorf("invalid OID '%s'", oid)
+		}
+		parsed[i] = value
+	}
+	return parsed, nil
+}
+
+// Get the registered OID for the given OID in dotted notation (nil if the OID is not registered).
+func Lookup(oid string) *OID {
+	return oidsByOID[oid]
+}
+
+// Get the registered OID with the given name (nil if no OID with this name is registered).
+func LookupName(name string) *OID {
+	return oidsByName[name]
+}
+
+// Get the nearest registered arc the given OID belongs to (nil if the OID is not below any registered arc).
+func Arc(oid string) *OID {
+	for arc := parentArc(oid); arc != ""; arc = parentArc(arc) {
+		registered := oidsByOID[arc]
+		if registered != nil {
+			return registered
+		}
+	}
+	return nil
+}
+
+func parentArc(oid string) string {
+	separator := strings.LastIndex(oid, ".")
+	if separator < 0 {
+		return ""
+	}
+	return oid[:separator]
+}
+
+// Get a human readable name of the given OID.
+//
+// Unregistered OIDs below a registered arc are named relative to the arc (e.g. "id-kp.42").
+// Returns the empty string if neither the OID nor its arc is registered.
+func Describe(oid string) string {
+	registered := Lookup(oid)
+	if registered != nil {
+		return registered.Name
+	}
+	arc := Arc(oid)
+	if arc != nil {
+		return arc.Name + oid[len(arc.OID):]
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oids
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	names := make(map[string]string, 0)
+	for oid, registered := range oidsByOID {
+		_, err := Parse(oid)
+		require.NoError(t, err)
+		require.NotEmpty(t, registered.Name, oid)
+		require.NotEmpty(t, registered.Description, oid)
+		require.NotContains(t, names, registered.Name, oid)
+		names[registered.Name] = oid
+	}
+}
+
+func TestLookup(t *testing.T) {
+	commonName := Lookup("2.5.4.3")
+	require.NotNil(t, commonName)
+	require.Equal(t, "commonName", commonName.Name)
+	require.Equal(t, commonName, LookupName("commonName"))
+	require.Nil(t, Lookup("1.2.3.4"))
+	require.Nil(t, LookupName("unknown"))
+	arc := Arc("1.3.6.1.5.5.7.3.42")
+	require.NotNil(t, arc)
+	require.Equal(t, "id-kp", arc.Name)
+	require.Nil(t, Arc("1.2.3.4"))
+	require.Equal(t, "serverAuth", Describe("1.3.6.1.5.5.7.3.1"))
+	require.Equal(t, "id-kp.42", Describe("1.3.6.1.5.5.7.3.42"))
+	require.Equal(t, "", Describe("1.2.3.4"))
+	_, err := Parse("2.5.4.x")
+	require.Error(t, err)
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	legoacme "github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/hdecarne-github/certd/pkg/keys"
+)
+
+// Roll over the account key of the registration for the given provider (RFC 8555 section 7.3.5).
+//
+// On success the new account key is persisted in the registration state.
+func RolloverAccountKey(ctx context.Context, config *Config, providerName string, keyFactory keys.KeyPairFactory) error {
+	provider, err := config.provider(providerName)
+	if err != nil {
+		return err
+	}
+	providerRegistration, err := findRegistration(provider)
+	if err != nil {
+		return err
+	}
+	oldKey := providerRegistration.GetPrivateKey()
+	if oldKey == nil {
+		return fmt.Errorf("invalid account key for ACME provider '%s'", provider.Name)
+	}
+	newKey, err := keys.NewKeyPair(ctx, keyFactory)
+	if err != nil {
+		return err
+	}
+	legoConfig := lego.NewConfig(providerRegistration)
+	legoConfig.CADirURL = provider.URL
+	directory, err := fetchDirectory(legoConfig.HTTPClient, provider.URL)
+	if err != nil {
+		return err
+	}
+	if directory.KeyChangeURL == "" {
+		return fmt.Errorf("ACME provider '%s' does not support key rollover", provider.Name)
+	}
+	nonce, err := fetchNonce(legoConfig.HTTPClient, directory.NewNonceURL)
+	if err != nil {
+		return err
+	}
+	body, err := keyChangeRequest(providerRegistration.Registration.URI, directory.KeyChangeURL, nonce, oldKey, newKey.Private())
+	if err != nil {
+		return err
+	}
+	rsp, err := legoConfig.HTTPClient.Post(directory.KeyChangeURL, "application/jose+json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send key change request to '%s' (cause: %w)", directory.KeyChangeURL, err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		rspBody, _ := io.ReadAll(io.LimitReader(rsp.Body, 4096))
+		return fmt.Errorf("key change request rejected by ACME provider '%s' (status: %d, response: %s)", provider.Name, rsp.StatusCode, string(rspBody))
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(newKey.Private())
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key (cause: %w)", err)
+	}
+	providerRegistration.Key = base64.StdEncoding.EncodeToString(keyBytes)
+	return updateProviderRegistrations(providerRegistration)
+}
+
+// Deactivate the account registered for the given provider and remove it from the registration state.
+//
+// A new account is registered automatically during the next certificate generation using this provider.
+func DeactivateAccount(config *Config, providerName string) error {
+	provider, err := config.provider(providerName)
+	if err != nil {
+		return err
+	}
+	providerRegistration, err := findRegistration(provider)
+	if err != nil {
+		return err
+	}
+	legoConfig := lego.NewConfig(providerRegistration)
+	legoConfig.CADirURL = provider.URL
+	client, err := lego.NewClient(legoConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create client for provider '%s' (cause: %w)", provider.Name, err)
+	}
+	err = client.Registration.DeleteRegistration()
+	if err != nil {
+		return fmt.Errorf("failed to deactivate account for provider '%s' (cause: %w)", provider.Name, err)
+	}
+	return removeProviderRegistration(providerRegistration)
+}
+
+func (config *Config) provider(name string) (*Provider, error) {
+	provider, found := config.Providers[name]
+	if !found {
+		return nil, fmt.Errorf("unknown ACME provider '%s'", name)
+	}
+	return &provider, nil
+}
+
+func fetchDirectory(client *http.Client, url string) (*legoacme.Directory, error) {
+	rsp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ACME directory '%s' (cause: %w)", url, err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch ACME directory '%s' (status: %d)", url, rsp.StatusCode)
+	}
+	directory := &legoacme.Directory{}
+	err = json.NewDecoder(rsp.Body).Decode(directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode ACME directory '%s' (cause: %w)", url, err)
+	}
+	return directory, nil
+}
+
+func fetchNonce(client *http.Client, url string) (string, error) {
+	rsp, err := client.Head(url)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch nonce from '%s' (cause: %w)", url, err)
+	}
+	rsp.Body.Close()
+	nonce := rsp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", fmt.Errorf("no nonce received from '%s'", url)
+	}
+	return nonce, nil
+}
+
+type staticNonceSource string
+
+func (nonce staticNonceSource) Nonce() (string, error) {
+	return string(nonce), nil
+}
+
+type keyChangePayload struct {
+	Account string          `json:"account"`
+	OldKey  jose.JSONWebKey `json:"oldKey"`
+}
+
+func keyChangeRequest(accountURL string, keyChangeURL string, nonce string, oldKey crypto.PrivateKey, newKey crypto.PrivateKey) ([]byte, error) {
+	oldAlgorithm, err := signatureAlgorithm(oldKey)
+	if err != nil {
+		return nil, err
+	}
+	newAlgorithm, err := signatureAlgorithm(newKey)
+	if err != nil {
+		return nil, err
+	}
+	oldPublic := jose.JSONWebKey{Key: oldKey.(crypto.Signer).Public()}
+	payload, err := json.Marshal(&keyChangePayload{Account: accountURL, OldKey: oldPublic})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key change payload (cause: %w)", err)
+	}
+	innerOptions := (&jose.SignerOptions{EmbedJWK: true}).WithHeader("url", keyChangeURL)
+	innerSigner, err := jose.NewSigner(jose.SigningKey{Algorithm: newAlgorithm, Key: newKey}, innerOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key change signer (cause: %w)", err)
+	}
+	inner, err := innerSigner.Sign(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign key change payload (cause: %w)", err)
+	}
+	outerOptions := (&jose.SignerOptions{NonceSource: staticNonceSource(nonce)}).WithHeader("url", keyChangeURL)
+	outerSigner, err := jose.NewSigner(jose.SigningKey{Algorithm: oldAlgorithm, Key: jose.JSONWebKey{Key: oldKey, KeyID: accountURL}}, outerOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key change signer (cause: %w)", err)
+	}
+	outer, err := outerSigner.Sign([]byte(inner.FullSerialize()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign key change request (cause: %w)", err)
+	}
+	return []byte(outer.FullSerialize()), nil
+}
+
+func signatureAlgorithm(key crypto.PrivateKey) (jose.SignatureAlgorithm, error) {
+	switch typedKey := key.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch typedKey.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported account key type %T", key)
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/go-jose/go-jose/v3"
+	ecdsakeys "github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountManagement(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	var server *httptest.Server
+	var newKey *jose.JSONWebKey
+	deactivated := false
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		switch r.URL.Path {
+		case "/dir":
+			fmt.Fprintf(w, `{"newNonce":"%[1]s/nonce","newAccount":"%[1]s/account","newOrder":"%[1]s/order","revokeCert":"%[1]s/revoke","keyChange":"%[1]s/key-change"}`, server.URL)
+		case "/nonce":
+			w.WriteHeader(http.StatusOK)
+		case "/key-change":
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			outer, err := jose.ParseSigned(string(body))
+			require.NoError(t, err)
+			require.Equal(t, server.URL+"/acct/1", outer.Signatures[0].Protected.KeyID)
+			innerBytes, err := outer.Verify(oldKey.Public())
+			require.NoError(t, err)
+			inner, err := jose.ParseSigned(string(innerBytes))
+			require.NoError(t, err)
+			newKey = inner.Signatures[0].Protected.JSONWebKey
+			require.NotNil(t, newKey)
+			payloadBytes, err := inner.Verify(newKey)
+			require.NoError(t, err)
+			payload := &keyChangePayload{}
+			require.NoError(t, json.Unmarshal(payloadBytes, payload))
+			require.Equal(t, server.URL+"/acct/1", payload.Account)
+			require.Equal(t, oldKey.Public(), payload.OldKey.Key)
+			w.WriteHeader(http.StatusOK)
+		case "/acct/1":
+			deactivated = true
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"status":"deactivated"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	config := defaultConfig()
+	config.Providers["Test"] = Provider{Name: "Test", URL: server.URL + "/dir", RegistrationEmail: "webmaster@localhost"}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(oldKey)
+	require.NoError(t, err)
+	err = updateProviderRegistrations(&ProviderRegistration{
+		Provider:     "Test",
+		Email:        "webmaster@localhost",
+		Key:          base64.StdEncoding.EncodeToString(keyBytes),
+		Registration: &registration.Resource{URI: server.URL + "/acct/1"},
+	})
+	require.NoError(t, err)
+	// roll over account key
+	err = RolloverAccountKey(context.Background(), config, "Test", ecdsakeys.NewECDSAKeyPairFactory(elliptic.P256()))
+	require.NoError(t, err)
+	providerRegistration, err := findRegistration(&Provider{Name: "Test", RegistrationEmail: "webmaster@localhost"})
+	require.NoError(t, err)
+	require.Equal(t, newKey.Key, providerRegistration.GetPrivateKey().(*ecdsa.PrivateKey).Public())
+	// deactivate account
+	err = DeactivateAccount(config, "Test")
+	require.NoError(t, err)
+	require.True(t, deactivated)
+	_, err = findRegistration(&Provider{Name: "Test", RegistrationEmail: "webmaster@localhost"})
+	require.Error(t, err)
+	// unknown provider
+	require.Error(t, DeactivateAccount(config, "Unknown"))
+}
@@ -18,10 +18,13 @@
 package acme
 
 import (
+	"context"
 	"crypto"
+	"crypto/elliptic"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
 
@@ -33,6 +36,7 @@ import (
 	"github.com/hdecarne-github/certd/internal/logging"
 	"github.com/hdecarne-github/certd/pkg/certs"
 	"github.com/hdecarne-github/certd/pkg/keys"
+	"github.com/hdecarne-github/certd/pkg/keys/registry"
 	"github.com/rs/zerolog"
 )
 
@@ -42,6 +46,7 @@ type ACMECertificateFactory struct {
 	name         string
 	domains      []string
 	configPath   string
+	config       *Config
 	providerName string
 	keyFactory   keys.KeyPairFactory
 	logger       *zerolog.Logger
@@ -49,7 +54,7 @@ type ACMECertificateFactory struct {
 
 func NewACMECertificateFactory(domains []string, configPath string, providerName string, keyFactory keys.KeyPairFactory) certs.CertificateFactory {
 	name := ProviderPrefix + providerName
-	logger := logging.RootLogger().With().Str("Provider", name).Logger()
+	logger := logging.ModuleLogger(logging.ModuleACME).With().Str("Provider", name).Logger()
 	return &ACMECertificateFactory{
 		name:         name,
 		domains:      domains,
@@ -60,21 +65,39 @@ func NewACMECertificateFactory(domains []string, configPath string, providerName
 	}
 }
 
+// Create an ACME certificate factory using an already loaded ACME configuration
+// (instead of reading the configuration file on certificate creation).
+func NewACMECertificateFactoryWithConfig(domains []string, config *Config, providerName string, keyFactory keys.KeyPairFactory) certs.CertificateFactory {
+	factory := NewACMECertificateFactory(domains, "", providerName, keyFactory).(*ACMECertificateFactory)
+	factory.config = config
+	return factory
+}
+
 func (factory *ACMECertificateFactory) Name() string {
 	return factory.name
 }
 
-func (factory *ACMECertificateFactory) New() (crypto.PrivateKey, *x509.Certificate, error) {
-	provider, domainConfig, err := factory.evalConfig()
+// Obtain the certificate, stopping as soon as the context is done.
+//
+// As the ACME client itself does not support cancellation, all its requests are checked against the context before
+// they are sent. A cancelled order therefore fails with its next request (e.g. while polling the authorizations).
+func (factory *ACMECertificateFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error) {
+	acmeConfig, provider, domainConfig, err := factory.evalConfig()
 	if err != nil {
 		return nil, nil, err
 	}
-	registration, err := getRegistration(provider, factory.keyFactory)
+	err = Preflight(&acmeConfig.Preflight, factory.domains, domainConfig)
+	if err != nil {
+		factory.logger.Error().Err(err).Msg("ACME preflight check failed")
+		return nil, nil, classifyError(err)
+	}
+	registration, err := getRegistration(ctx, provider, factory.keyFactory)
 	if err != nil {
 		return nil, nil, err
 	}
 	config := lego.NewConfig(registration)
 	config.CADirURL = provider.URL
+	config.HTTPClient = contextClient(ctx, config.HTTPClient)
 	keyType, err := factory.keyType()
 	if err != nil {
 		return nil, nil, err
@@ -85,7 +108,7 @@ func (factory *ACMECertificateFactory) New() (crypto.PrivateKey, *x509.Certifica
 		return nil, nil, fmt.Errorf("failed to create client for provider '%s' (cause: %w)", factory.name, err)
 	}
 	if !registration.isValid(client) {
-		err = registration.refresh(client, factory.keyFactory)
+		err = registration.refresh(client, provider)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -96,7 +119,13 @@ func (factory *ACMECertificateFactory) New() (crypto.PrivateKey, *x509.Certifica
 	if domainConfig.TLSAPN01Challenge.Enabled {
 		client.Challenge.SetTLSALPN01Provider(tlsalpn01.NewProviderServer(domainConfig.TLSAPN01Challenge.Iface, strconv.Itoa(domainConfig.TLSAPN01Challenge.Port)))
 	}
-	key, err := factory.keyFactory.New()
+	if domainConfig.DNS01Challenge.Enabled {
+		err = client.Challenge.SetDNS01Provider(newACMEDNSProvider(&domainConfig.DNS01Challenge.ACMEDNS))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to setup DNS-01 challenge for provider '%s' (cause: %w)", factory.name, err)
+		}
+	}
+	key, err := keys.NewKeyPair(ctx, factory.keyFactory)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -105,9 +134,15 @@ func (factory *ACMECertificateFactory) New() (crypto.PrivateKey, *x509.Certifica
 		PrivateKey: key.Private(),
 		Bundle:     false,
 	}
-	certificates, err := client.Certificate.Obtain(request)
+	certificates, err := obtainWithRetry(ctx, &acmeConfig.Retry, factory.logger, func() (*certificate.Resource, error) {
+		err := ctx.Err()
+		if err != nil {
+			return nil, err
+		}
+		return client.Certificate.Obtain(request)
+	})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, classifyError(err)
 	}
 	obtainedKey, err := factory.decodePrivateKey(certificates.PrivateKey)
 	if err != nil {
@@ -120,6 +155,30 @@ func (factory *ACMECertificateFactory) New() (crypto.PrivateKey, *x509.Certifica
 	return obtainedKey, obtainedCertificate, nil
 }
 
+// Derive a HTTP client failing all requests once the given context is done.
+func contextClient(ctx context.Context, client *http.Client) *http.Client {
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	derived := *client
+	derived.Transport = &contextTransport{ctx: ctx, transport: transport}
+	return &derived
+}
+
+type contextTransport struct {
+	ctx       context.Context
+	transport http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+	return t.transport.RoundTrip(req)
+}
+
 func (factory *ACMECertificateFactory) decodePrivateKey(keyBytes []byte) (crypto.PrivateKey, error) {
 	pemBlock, rest := pem.Decode(keyBytes)
 	if pemBlock == nil {
@@ -163,10 +222,14 @@ func (factory *ACMECertificateFactory) decodeCertificate(certificateBytes []byte
 	return certificate, nil
 }
 
-func (factory *ACMECertificateFactory) evalConfig() (*Provider, *DomainConfig, error) {
-	config, err := Load(factory.configPath)
-	if err != nil {
-		return nil, nil, err
+func (factory *ACMECertificateFactory) evalConfig() (*Config, *Provider, *DomainConfig, error) {
+	config := factory.config
+	if config == nil {
+		loaded, err := Load(factory.configPath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		config = loaded
 	}
 	var provider *Provider
 	for _, configProvider := range config.Providers {
@@ -176,39 +239,56 @@ func (factory *ACMECertificateFactory) evalConfig() (*Provider, *DomainConfig, e
 		}
 	}
 	if provider == nil {
-		return nil, nil, fmt.Errorf("unknown ACME provider '%s'", factory.providerName)
+		return nil, nil, nil, fmt.Errorf("unknown ACME provider '%s'", factory.providerName)
 	}
-	if len(factory.domains) == 0 {
-		return nil, nil, fmt.Errorf("missing domain information")
+	domainConfig, err := config.ResolveDomainConfig(factory.domains)
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	domain := factory.domains[0] + "."
-	var domainConfig *DomainConfig
-	for _, configDomainConfig := range config.Domains {
-		if strings.HasSuffix(domain, configDomainConfig.Domain) {
-			if domainConfig == nil || len(domainConfig.Domain) < len(configDomainConfig.Domain) {
-				domainConfig = &configDomainConfig
+	return config, provider, domainConfig, nil
+}
+
+func (factory *ACMECertificateFactory) keyType() (certcrypto.KeyType, error) {
+	keyType, ok := acmeKeyType(factory.keyFactory)
+	if !ok {
+		return "", fmt.Errorf("unsupported key type '%s' (supported key types: %s)", factory.keyFactory.Name(), strings.Join(SupportedKeyTypes(), ", "))
+	}
+	return keyType, nil
+}
+
+// Get the names of the standard key types usable for ACME certificates.
+func SupportedKeyTypes() []string {
+	supported := make([]string, 0)
+	for _, providerName := range registry.KeyProviders() {
+		for _, keyFactory := range registry.StandardKeys(providerName) {
+			_, ok := acmeKeyType(keyFactory)
+			if ok {
+				supported = append(supported, keyFactory.Name())
 			}
 		}
 	}
-	if domainConfig == nil {
-		return nil, nil, fmt.Errorf("missing Domain configuration for domain '%s'", domain)
-	}
-	return provider, domainConfig, nil
+	return supported
 }
 
-func (factory *ACMECertificateFactory) keyType() (certcrypto.KeyType, error) {
-	keyProvider := factory.keyFactory.Name()
-	switch keyProvider {
-	case "ECDSA P-256":
-		return certcrypto.EC256, nil
-	case "ECDSA P-384":
-		return certcrypto.EC384, nil
-	case "RSA 2048":
-		return certcrypto.RSA2048, nil
-	case "RSA 4096":
-		return certcrypto.RSA4096, nil
-	case "RSA 8192":
-		return certcrypto.RSA8192, nil
-	}
-	return "", fmt.Errorf("unsupported key provider '%s'", keyProvider)
+// Derive the lego key type from the key parameters (curve or modulus size) offered by the key factory.
+func acmeKeyType(keyFactory keys.KeyPairFactory) (certcrypto.KeyType, bool) {
+	switch keyFactory := keyFactory.(type) {
+	case interface{ Curve() elliptic.Curve }:
+		switch keyFactory.Curve() {
+		case elliptic.P256():
+			return certcrypto.EC256, true
+		case elliptic.P384():
+			return certcrypto.EC384, true
+		}
+	case interface{ Bits() int }:
+		switch keyFactory.Bits() {
+		case 2048:
+			return certcrypto.RSA2048, true
+		case 4096:
+			return certcrypto.RSA4096, true
+		case 8192:
+			return certcrypto.RSA8192, true
+		}
+	}
+	return "", false
 }
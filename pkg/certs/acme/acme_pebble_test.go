@@ -0,0 +1,56 @@
+//go:build pebble
+
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"context"
+	"crypto/elliptic"
+	"os"
+	"testing"
+
+	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/stretchr/testify/require"
+)
+
+const pebbleDirectoryURL = "https://localhost:14000/dir"
+
+// Issue a certificate via an externally started Pebble server (see the interop workflow).
+//
+// The Pebble directory URL defaults to Pebble's standard listen address and can be overridden via
+// PEBBLE_DIRECTORY_URL. Pebble's TLS certificate has to be trusted via LEGO_CA_CERTIFICATES.
+func TestACMECertificateFactoryPebble(t *testing.T) {
+	directoryURL := os.Getenv("PEBBLE_DIRECTORY_URL")
+	if directoryURL == "" {
+		directoryURL = pebbleDirectoryURL
+	}
+	config, err := Load("testdata/acme-test.yaml")
+	require.NoError(t, err)
+	provider := config.Providers["Test"]
+	provider.URL = directoryURL
+	config.Providers["Test"] = provider
+
+	keyFactory := ecdsa.NewECDSAKeyPairFactory(elliptic.P256())
+	certificateFactory := NewACMECertificateFactoryWithConfig([]string{"localhost"}, config, "Test", keyFactory)
+	key, certificate, err := certificateFactory.New(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, key)
+	require.NotNil(t, certificate)
+	require.Equal(t, []string{"localhost"}, certificate.DNSNames)
+}
@@ -18,22 +18,52 @@
 package acme
 
 import (
+	"context"
 	"crypto/elliptic"
-	"os"
 	"testing"
 
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/hdecarne-github/certd/internal/acmetest"
 	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/hdecarne-github/certd/pkg/keys/ed25519"
+	"github.com/hdecarne-github/certd/pkg/keys/rsa"
 	"github.com/stretchr/testify/require"
 )
 
 func TestACMECertificateFactory(t *testing.T) {
-	// Accept test CA
-	os.Setenv("LEGO_CA_CERTIFICATES", "./testdata/certs/pebble.minica.pem")
+	acmeServer, err := acmetest.Start(&acmetest.Config{HTTPPort: 5002, TLSPort: 5001})
+	require.NoError(t, err)
+	defer acmeServer.Close()
+	config, err := Load("testdata/acme-test.yaml")
+	require.NoError(t, err)
+	provider := config.Providers["Test"]
+	provider.URL = acmeServer.DirectoryURL()
+	config.Providers["Test"] = provider
 
 	keyFactory := ecdsa.NewECDSAKeyPairFactory(elliptic.P256())
-	certificateFactory := NewACMECertificateFactory([]string{"localhost"}, "testdata/acme-test.yaml", "Test", keyFactory)
-	key, certificate, err := certificateFactory.New()
+	certificateFactory := NewACMECertificateFactoryWithConfig([]string{"localhost"}, config, "Test", keyFactory)
+	key, certificate, err := certificateFactory.New(context.Background())
 	require.NoError(t, err)
 	require.NotNil(t, key)
 	require.NotNil(t, certificate)
+	require.NoError(t, certificate.CheckSignatureFrom(acmeServer.Issuer()))
+	// the test server offers renewal information for the issued certificate
+	info, err := FetchRenewalInfo(config, "Test", certificate)
+	require.NoError(t, err)
+	require.True(t, info.SuggestedWindow.Start.After(certificate.NotBefore))
+	require.True(t, info.SuggestedWindow.End.Before(certificate.NotAfter))
+}
+
+func TestACMEKeyType(t *testing.T) {
+	factory := NewACMECertificateFactory([]string{"localhost"}, "", "Test", ecdsa.NewECDSAKeyPairFactory(elliptic.P384())).(*ACMECertificateFactory)
+	keyType, err := factory.keyType()
+	require.NoError(t, err)
+	require.Equal(t, certcrypto.EC384, keyType)
+	factory = NewACMECertificateFactory([]string{"localhost"}, "", "Test", rsa.NewRSAKeyPairFactory(4096)).(*ACMECertificateFactory)
+	keyType, err = factory.keyType()
+	require.NoError(t, err)
+	require.Equal(t, certcrypto.RSA4096, keyType)
+	factory = NewACMECertificateFactory([]string{"localhost"}, "", "Test", ed25519.NewED25519KeyPairFactory()).(*ACMECertificateFactory)
+	_, err = factory.keyType()
+	require.ErrorContains(t, err, "unsupported key type 'ED25519' (supported key types: ECDSA P-256, ECDSA P-384, RSA 2048, RSA 4096)")
 }
@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/internal/state"
+)
+
+const acmeDNSAccountsFile = "acme-dns-accounts.json"
+
+const acmeDNSTimeout = 30 * time.Second
+
+var acmeDNSAccountsFileMutex sync.Mutex
+
+func init() {
+	state.RegisterPath(acmeDNSAccountsFile)
+}
+
+// ACMEDNSConfig defines the acme-dns service used to answer DNS-01 challenges.
+type ACMEDNSConfig struct {
+	URL string `yaml:"url"`
+}
+
+// ACMEDNSAccount contains the credentials of an acme-dns subdomain registered for a certificate domain.
+type ACMEDNSAccount struct {
+	Server     string `json:"server"`
+	Domain     string `json:"domain"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	FullDomain string `json:"fulldomain"`
+	SubDomain  string `json:"subdomain"`
+}
+
+// Get the CNAME record to create in the certificate domain's zone, delegating the challenge to acme-dns.
+func (account *ACMEDNSAccount) CNAMERecord() string {
+	return fmt.Sprintf("%s CNAME %s", acmeDNSChallengeDomain(account.Domain), dns01.ToFqdn(account.FullDomain))
+}
+
+// ACMEDNSCNAMERequiredError reports a freshly registered acme-dns account, whose CNAME record still has to be created.
+type ACMEDNSCNAMERequiredError struct {
+	Account *ACMEDNSAccount
+}
+
+func (err *ACMEDNSCNAMERequiredError) Error() string {
+	return fmt.Sprintf("acme-dns account registered for domain '%s'; create DNS record '%s' before retrying", err.Account.Domain, err.Account.CNAMERecord())
+}
+
+// Get the acme-dns account for the given domain (registering a new one, if none has been registered yet).
+//
+// The returned flag indicates whether the account has been newly registered (hence the corresponding CNAME record
+// most likely does not exist yet).
+func RegisterACMEDNSAccount(config *ACMEDNSConfig, domain string) (*ACMEDNSAccount, bool, error) {
+	if config.URL == "" {
+		return nil, false, fmt.Errorf("missing acme-dns URL for domain '%s'", domain)
+	}
+	domain = acmeDNSAccountDomain(domain)
+	acmeDNSAccountsFileMutex.Lock()
+	defer acmeDNSAccountsFileMutex.Unlock()
+	accounts, err := loadACMEDNSAccounts()
+	if err != nil {
+		return nil, false, err
+	}
+	for _, account := range accounts {
+		if account.Server == config.URL && account.Domain == domain {
+			return &account, false, nil
+		}
+	}
+	logger := logging.ModuleLogger(logging.ModuleACME)
+	logger.Info().Msgf("Registering acme-dns account for domain '%s' at '%s'...", domain, config.URL)
+	account := &ACMEDNSAccount{}
+	err = acmeDNSPost(config.URL, "/register", nil, []byte("{}"), http.StatusCreated, account)
+	if err != nil {
+		return nil, false, err
+	}
+	if account.Username == "" || account.Password == "" || account.FullDomain == "" || account.SubDomain == "" {
+		return nil, false, fmt.Errorf("incomplete acme-dns registration response from '%s'", config.URL)
+	}
+	account.Server = config.URL
+	account.Domain = domain
+	accounts = append(accounts, *account)
+	err = writeACMEDNSAccounts(accounts)
+	if err != nil {
+		return nil, false, err
+	}
+	logger.Warn().Msgf("Create DNS record '%s' to delegate the DNS-01 challenge of domain '%s' to acme-dns", account.CNAMERecord(), domain)
+	return account, true, nil
+}
+
+// Update the TXT record of the given acme-dns account.
+func UpdateACMEDNSRecord(account *ACMEDNSAccount, txt string) error {
+	headers := map[string]string{
+		"X-Api-User": account.Username,
+		"X-Api-Key":  account.Password,
+	}
+	request := struct {
+		SubDomain string `json:"subdomain"`
+		TXT       string `json:"txt"`
+	}{
+		SubDomain: account.SubDomain,
+		TXT:       txt,
+	}
+	requestBytes, err := json.Marshal(&request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal acme-dns update request (cause: %w)", err)
+	}
+	return acmeDNSPost(account.Server, "/update", headers, requestBytes, http.StatusOK, nil)
+}
+
+// acmeDNSProvider answers DNS-01 challenges by updating the TXT records of the registered acme-dns accounts.
+type acmeDNSProvider struct {
+	config *ACMEDNSConfig
+}
+
+func newACMEDNSProvider(config *ACMEDNSConfig) *acmeDNSProvider {
+	return &acmeDNSProvider{config: config}
+}
+
+func (provider *acmeDNSProvider) Present(domain, token, keyAuth string) error {
+	account, registered, err := RegisterACMEDNSAccount(provider.config, domain)
+	if err != nil {
+		return err
+	}
+	if registered {
+		return &ACMEDNSCNAMERequiredError{Account: account}
+	}
+	_, value := dns01.GetRecord(domain, keyAuth)
+	return UpdateACMEDNSRecord(account, value)
+}
+
+func (provider *acmeDNSProvider) CleanUp(domain, token, keyAuth string) error {
+	// acme-dns only keeps the most recent TXT records; nothing to clean up
+	return nil
+}
+
+func acmeDNSPost(server string, path string, headers map[string]string, body []byte, expectedStatus int, response any) error {
+	url := strings.TrimSuffix(server, "/") + path
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to prepare acme-dns request '%s' (cause: %w)", url, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	client := &http.Client{Timeout: acmeDNSTimeout}
+	rsp, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to access acme-dns '%s' (cause: %w)", url, err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != expectedStatus {
+		return fmt.Errorf("failed to access acme-dns '%s' (status: %d)", url, rsp.StatusCode)
+	}
+	if response != nil {
+		err = json.NewDecoder(rsp.Body).Decode(response)
+		if err != nil {
+			return fmt.Errorf("failed to decode acme-dns response '%s' (cause: %w)", url, err)
+		}
+	}
+	return nil
+}
+
+// Wildcard domains share the challenge record (and therefore the acme-dns account) with their base domain.
+func acmeDNSAccountDomain(domain string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSuffix(domain, ".")), "*.")
+}
+
+func acmeDNSChallengeDomain(domain string) string {
+	return dns01.ToFqdn("_acme-challenge." + acmeDNSAccountDomain(domain))
+}
+
+func writeACMEDNSAccounts(accounts []ACMEDNSAccount) error {
+	accountsBytes, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal acme-dns accounts (cause: %w)", err)
+	}
+	return state.Write(acmeDNSAccountsFile, accountsBytes)
+}
+
+func loadACMEDNSAccounts() ([]ACMEDNSAccount, error) {
+	accountsBytes, err := state.Read(acmeDNSAccountsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read acme-dns accounts from '%s' (cause: %w)", acmeDNSAccountsFile, err)
+	}
+	accounts := make([]ACMEDNSAccount, 0)
+	if err == nil {
+		err = json.Unmarshal(accountsBytes, &accounts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal acme-dns accounts file '%s' (cause: %w)", acmeDNSAccountsFile, err)
+		}
+	}
+	return accounts, nil
+}
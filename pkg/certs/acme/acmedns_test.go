@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/stretchr/testify/require"
+)
+
+func TestACMEDNS(t *testing.T) {
+	registrations := 0
+	updates := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/register":
+			registrations++
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"username":"user%[1]d","password":"secret%[1]d","fulldomain":"sub%[1]d.auth.example.org","subdomain":"sub%[1]d","allowfrom":[]}`, registrations)
+		case "/update":
+			if r.Header.Get("X-Api-User") != "user1" || r.Header.Get("X-Api-Key") != "secret1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			update := make(map[string]string)
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&update))
+			updates[update["subdomain"]] = update["txt"]
+			fmt.Fprintf(w, `{"txt":"%s"}`, update["txt"])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	challengeConfig := &DNS01ChallengeConfig{Enabled: true, ACMEDNS: ACMEDNSConfig{URL: server.URL}}
+	// preflight registers the account and instructs to create the CNAME record
+	err := Preflight(&PreflightConfig{Enabled: true}, []string{"*.example.org"}, &DomainConfig{DNS01Challenge: *challengeConfig})
+	var preflightErr *PreflightError
+	require.True(t, errors.As(err, &preflightErr))
+	require.Equal(t, PreflightCheckDNS01, preflightErr.Check)
+	var cnameRequiredErr *ACMEDNSCNAMERequiredError
+	require.True(t, errors.As(err, &cnameRequiredErr))
+	require.Equal(t, "_acme-challenge.example.org. CNAME sub1.auth.example.org.", cnameRequiredErr.Account.CNAMERecord())
+	// the wildcard and base domain share the account, which is re-used on subsequent runs
+	err = Preflight(&PreflightConfig{Enabled: true}, []string{"*.example.org", "example.org"}, &DomainConfig{DNS01Challenge: *challengeConfig})
+	require.NoError(t, err)
+	require.Equal(t, 1, registrations)
+	// challenges update the account's TXT record
+	provider := newACMEDNSProvider(&challengeConfig.ACMEDNS)
+	require.NoError(t, provider.Present("example.org", "token", "keyAuth"))
+	_, value := dns01.GetRecord("example.org", "keyAuth")
+	require.Equal(t, value, updates["sub1"])
+	require.NoError(t, provider.CleanUp("example.org", "token", "keyAuth"))
+	// challenges for unregistered domains register an account first
+	err = provider.Present("www.example.com", "token", "keyAuth")
+	require.True(t, errors.As(err, &cnameRequiredErr))
+	require.Equal(t, 2, registrations)
+}
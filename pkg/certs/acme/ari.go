@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/lego"
+)
+
+const defaultRenewalInterval = 6 * time.Hour
+const defaultRenewalRenewBefore = 720 * time.Hour
+
+// RenewalConfig controls the automatic renewal of ACME issued store entries.
+type RenewalConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Interval    time.Duration `yaml:"interval"`
+	ARI         bool          `yaml:"ari"`
+	RenewBefore time.Duration `yaml:"renew_before"`
+}
+
+// ErrRenewalInfoUnsupported indicates an ACME CA not offering renewal information.
+var ErrRenewalInfoUnsupported = errors.New("ACME CA does not offer renewal information")
+
+// RenewalInfo contains the ACME Renewal Information (ARI, RFC 9773) of a certificate as suggested by the CA.
+type RenewalInfo struct {
+	SuggestedWindow RenewalWindow `json:"suggestedWindow"`
+	ExplanationURL  string        `json:"explanationURL,omitempty"`
+	// Time before which the renewal information should not be queried again (zero if not indicated)
+	RetryAfter time.Time `json:"-"`
+}
+
+// RenewalWindow is the time window during which a certificate should be renewed.
+type RenewalWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Get the time to renew the given certificate at.
+//
+// The time is selected from within the suggested window, derived from the certificate itself, so repeated queries
+// result in the same time (as long as the window does not change) and renewals of different certificates spread
+// across the window.
+func (info *RenewalInfo) RenewalTime(certificate *x509.Certificate) time.Time {
+	window := info.SuggestedWindow.End.Sub(info.SuggestedWindow.Start)
+	if window <= 0 {
+		return info.SuggestedWindow.Start
+	}
+	digest := sha256.Sum256(certificate.Raw)
+	offset := time.Duration(binary.BigEndian.Uint64(digest[:8]) % uint64(window))
+	return info.SuggestedWindow.Start.Add(offset)
+}
+
+// Get the ARI certificate identifier of the given certificate (authority key identifier and serial number).
+func RenewalCertID(certificate *x509.Certificate) (string, error) {
+	if len(certificate.AuthorityKeyId) == 0 {
+		return "", fmt.Errorf("certificate '%s' lacks authority key identifier", certificate.Subject)
+	}
+	serialBytes := certificate.SerialNumber.Bytes()
+	if len(serialBytes) == 0 || serialBytes[0]&0x80 != 0 {
+		serialBytes = append([]byte{0}, serialBytes...)
+	}
+	return base64.RawURLEncoding.EncodeToString(certificate.AuthorityKeyId) + "." + base64.RawURLEncoding.EncodeToString(serialBytes), nil
+}
+
+// Query the renewal information of the given certificate from the given ACME provider.
+//
+// ErrRenewalInfoUnsupported is returned if the provider's directory does not announce renewal information.
+func FetchRenewalInfo(config *Config, providerName string, certificate *x509.Certificate) (*RenewalInfo, error) {
+	provider, found := config.Providers[providerName]
+	if !found {
+		return nil, fmt.Errorf("unknown ACME provider '%s'", providerName)
+	}
+	certID, err := RenewalCertID(certificate)
+	if err != nil {
+		return nil, err
+	}
+	client := *lego.NewConfig(&ProviderRegistration{}).HTTPClient
+	client.Timeout = probeTimeout
+	renewalInfoURL, err := fetchRenewalInfoURL(&client, provider.URL)
+	if err != nil {
+		return nil, err
+	}
+	infoURL := strings.TrimSuffix(renewalInfoURL, "/") + "/" + certID
+	rsp, err := client.Get(infoURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch renewal information '%s' (cause: %w)", infoURL, err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch renewal information '%s' (status: %d)", infoURL, rsp.StatusCode)
+	}
+	info := &RenewalInfo{}
+	err = json.NewDecoder(rsp.Body).Decode(info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode renewal information '%s' (cause: %w)", infoURL, err)
+	}
+	if info.SuggestedWindow.Start.IsZero() || info.SuggestedWindow.End.Before(info.SuggestedWindow.Start) {
+		return nil, fmt.Errorf("invalid renewal window in renewal information '%s'", infoURL)
+	}
+	info.RetryAfter = parseRetryAfter(rsp.Header.Get("Retry-After"), time.Now())
+	return info, nil
+}
+
+func fetchRenewalInfoURL(client *http.Client, url string) (string, error) {
+	rsp, err := client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch ACME directory '%s' (cause: %w)", url, err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch ACME directory '%s' (status: %d)", url, rsp.StatusCode)
+	}
+	// the lego directory type does not cover the renewalInfo resource
+	directory := &struct {
+		RenewalInfo string `json:"renewalInfo"`
+	}{}
+	err = json.NewDecoder(rsp.Body).Decode(directory)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode ACME directory '%s' (cause: %w)", url, err)
+	}
+	if directory.RenewalInfo == "" {
+		return "", ErrRenewalInfoUnsupported
+	}
+	return directory.RenewalInfo, nil
+}
+
+func parseRetryAfter(retryAfter string, now time.Time) time.Time {
+	if retryAfter == "" {
+		return time.Time{}
+	}
+	seconds, err := strconv.Atoi(retryAfter)
+	if err == nil {
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+	at, err := http.ParseTime(retryAfter)
+	if err == nil {
+		return at
+	}
+	return time.Time{}
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenewalCertID(t *testing.T) {
+	certificate := &x509.Certificate{
+		AuthorityKeyId: []byte{0x69, 0x88, 0x5b, 0x6b, 0x87, 0x46, 0x40, 0x41, 0xe1, 0xb3, 0x7b, 0x84, 0x7b, 0xa0, 0xae, 0x2c, 0xde, 0x01, 0xc8, 0xd4},
+		SerialNumber:   big.NewInt(0).SetBytes([]byte{0x00, 0x87, 0x65, 0x43, 0x21}),
+	}
+	certID, err := RenewalCertID(certificate)
+	require.NoError(t, err)
+	require.Equal(t, "aYhba4dGQEHhs3uEe6CuLN4ByNQ.AIdlQyE", certID)
+	_, err = RenewalCertID(&x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test"}})
+	require.Error(t, err)
+}
+
+func TestFetchRenewalInfo(t *testing.T) {
+	certificate := &x509.Certificate{
+		Raw:            []byte("certificate"),
+		AuthorityKeyId: []byte{0x01, 0x02, 0x03},
+		SerialNumber:   big.NewInt(42),
+	}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(48 * time.Hour)
+	var server *httptest.Server
+	withRenewalInfo := true
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dir":
+			if withRenewalInfo {
+				fmt.Fprintf(w, `{"newNonce":"%[1]s/nonce","renewalInfo":"%[1]s/renewal-info/"}`, server.URL)
+			} else {
+				fmt.Fprintf(w, `{"newNonce":"%[1]s/nonce"}`, server.URL)
+			}
+		case "/renewal-info/AQID.Kg":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", "21600")
+			fmt.Fprintf(w, `{"suggestedWindow":{"start":"%s","end":"%s"},"explanationURL":"https://example.org/incident"}`, start.Format(time.RFC3339), end.Format(time.RFC3339))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	config := defaultConfig()
+	config.Providers["Test"] = Provider{Name: "Test", URL: server.URL + "/dir"}
+	info, err := FetchRenewalInfo(config, "Test", certificate)
+	require.NoError(t, err)
+	require.True(t, start.Equal(info.SuggestedWindow.Start))
+	require.True(t, end.Equal(info.SuggestedWindow.End))
+	require.Equal(t, "https://example.org/incident", info.ExplanationURL)
+	require.False(t, info.RetryAfter.IsZero())
+	renewalTime := info.RenewalTime(certificate)
+	require.False(t, renewalTime.Before(start))
+	require.True(t, renewalTime.Before(end))
+	require.Equal(t, renewalTime, info.RenewalTime(certificate))
+	// CA without renewal information
+	withRenewalInfo = false
+	_, err = FetchRenewalInfo(config, "Test", certificate)
+	require.ErrorIs(t, err, ErrRenewalInfoUnsupported)
+	// unknown provider
+	_, err = FetchRenewalInfo(config, "Unknown", certificate)
+	require.Error(t, err)
+}
@@ -20,8 +20,11 @@ package acme
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
-	"gopkg.in/yaml.v3"
+	certdconfig "github.com/hdecarne-github/certd/internal/config"
 )
 
 func Load(path string) (*Config, error) {
@@ -30,12 +33,16 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read configuration file '%s' (cause: %w)", path, err)
 	}
 	config := defaultConfig()
-	err = yaml.Unmarshal(configBytes, config)
+	err = certdconfig.UnmarshalExpanded(configBytes, filepath.Dir(path), config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal configuration file '%s' (cause: %w)", path, err)
 	}
 	for name, provider := range config.Providers {
 		provider.Name = name
+		err = provider.applyPreset()
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration file '%s' (cause: %w)", path, err)
+		}
 		config.Providers[name] = provider
 	}
 	for domain, domainConfig := range config.Domains {
@@ -49,24 +56,119 @@ func defaultConfig() *Config {
 	return &Config{
 		Providers: make(map[string]Provider, 0),
 		Domains:   make(map[string]DomainConfig, 0),
+		Preflight: PreflightConfig{
+			Enabled: true,
+			Timeout: defaultPreflightTimeout,
+		},
+		Retry: RetryConfig{
+			MaxAttempts:    defaultRetryMaxAttempts,
+			InitialBackoff: defaultRetryInitialBackoff,
+			MaxBackoff:     defaultRetryMaxBackoff,
+			MaxScheduled:   defaultRetryMaxScheduled,
+		},
+		Renewal: RenewalConfig{
+			Interval:    defaultRenewalInterval,
+			ARI:         true,
+			RenewBefore: defaultRenewalRenewBefore,
+		},
 	}
 }
 
 type Config struct {
 	Providers map[string]Provider     `yaml:"providers"`
 	Domains   map[string]DomainConfig `yaml:"domains"`
+	Preflight PreflightConfig         `yaml:"preflight"`
+	Retry     RetryConfig             `yaml:"retry"`
+	Renewal   RenewalConfig           `yaml:"renewal"`
+}
+
+// PreflightConfig controls the checks performed before submitting an ACME order.
+type PreflightConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	HttpReachability bool          `yaml:"http_reachability"`
+	Timeout          time.Duration `yaml:"timeout"`
 }
 
 type Provider struct {
-	Name              string `yaml:"-"`
-	URL               string `yaml:"url"`
-	RegistrationEmail string `yaml:"registration_email"`
+	Name              string    `yaml:"-"`
+	Preset            string    `yaml:"preset"`
+	URL               string    `yaml:"url"`
+	RegistrationEmail string    `yaml:"registration_email"`
+	EAB               EABConfig `yaml:"eab"`
+}
+
+// EABConfig contains the external account binding credentials required by some ACME services (e.g. ZeroSSL).
+type EABConfig struct {
+	KID  string `yaml:"kid"`
+	HMAC string `yaml:"hmac"`
 }
 
 type DomainConfig struct {
 	Domain            string                  `yaml:"-"`
 	Http01Challenge   Http01ChallengeConfig   `yaml:"http-01"`
 	TLSAPN01Challenge TLSAPN01ChallengeConfig `yaml:"tls-apn-01"`
+	DNS01Challenge    DNS01ChallengeConfig    `yaml:"dns-01"`
+}
+
+// Resolve the domain configuration to use for the given (certificate) domains.
+//
+// Every domain is matched against the configured domain names on label boundaries (the longest match wins).
+// Wildcard domains (e.g. "*.example.org") prefer an explicitly configured wildcard domain name with the same
+// base domain. As all challenges of an order are handled by the same client, all domains must resolve to
+// the same challenge settings.
+func (config *Config) ResolveDomainConfig(domains []string) (*DomainConfig, error) {
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("missing domain information")
+	}
+	var resolved *DomainConfig
+	for _, domain := range domains {
+		domainConfig := config.matchDomainConfig(domain)
+		if domainConfig == nil {
+			return nil, fmt.Errorf("missing domain configuration for domain '%s'", domain)
+		}
+		if resolved == nil {
+			resolved = domainConfig
+		} else if resolved.Http01Challenge != domainConfig.Http01Challenge || resolved.TLSAPN01Challenge != domainConfig.TLSAPN01Challenge || resolved.DNS01Challenge != domainConfig.DNS01Challenge {
+			return nil, fmt.Errorf("conflicting domain configurations '%s' and '%s' for domain '%s'", resolved.Domain, domainConfig.Domain, domain)
+		}
+	}
+	return resolved, nil
+}
+
+func (config *Config) matchDomainConfig(domain string) *DomainConfig {
+	domain = normalizeDomain(domain)
+	var match *DomainConfig
+	matchLen := -1
+	for configDomain, domainConfig := range config.Domains {
+		pattern := normalizeDomain(configDomain)
+		var patternLen int
+		if strings.HasPrefix(pattern, "*.") {
+			// explicit wildcard configurations only apply to the identical wildcard domain and take precedence
+			if pattern != domain {
+				continue
+			}
+			patternLen = len(domain) + 1
+		} else if pattern == "." || pattern == domain || strings.HasSuffix(domain, "."+pattern) {
+			patternLen = len(pattern)
+		} else {
+			continue
+		}
+		if patternLen > matchLen || (patternLen == matchLen && configDomain < match.Domain) {
+			matched := domainConfig
+			matched.Domain = configDomain
+			match = &matched
+			matchLen = patternLen
+		}
+	}
+	return match
+}
+
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if !strings.HasSuffix(domain, ".") {
+		domain += "."
+	}
+	return domain
 }
 
 type Http01ChallengeConfig struct {
@@ -80,3 +182,9 @@ type TLSAPN01ChallengeConfig struct {
 	Iface   string `yaml:"iface"`
 	Port    int    `ymal:"port"`
 }
+
+// DNS01ChallengeConfig enables the DNS-01 challenge (delegated to an acme-dns service via a CNAME record).
+type DNS01ChallengeConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	ACMEDNS ACMEDNSConfig `yaml:"acme-dns"`
+}
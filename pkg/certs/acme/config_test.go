@@ -18,8 +18,12 @@
 package acme
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/hdecarne-github/certd/pkg/certs"
 	"github.com/stretchr/testify/require"
 )
 
@@ -27,4 +31,71 @@ func TestLoad(t *testing.T) {
 	config, err := Load("./testdata/acme-test.yaml")
 	require.NoError(t, err)
 	require.NotNil(t, config)
+	require.Equal(t, "https://localhost:14000/dir", config.Providers["Test"].URL)
+	require.Equal(t, "https://acme-staging-v02.api.letsencrypt.org/directory", config.Providers["Preset"].URL)
+	require.Equal(t, "https://acme.zerossl.com/v2/DV90", config.Providers["EAB"].URL)
+	require.Equal(t, "kid", config.Providers["EAB"].EAB.KID)
+}
+
+func TestLoadInvalidPreset(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "acme.yaml")
+	err := os.WriteFile(configPath, []byte("providers:\n  \"Unknown\":\n    preset: \"unknown\"\n"), 0600)
+	require.NoError(t, err)
+	_, err = Load(configPath)
+	require.Error(t, err)
+	err = os.WriteFile(configPath, []byte("providers:\n  \"ZeroSSL\":\n    preset: \"zerossl\"\n"), 0600)
+	require.NoError(t, err)
+	_, err = Load(configPath)
+	require.Error(t, err)
+}
+
+func TestPresets(t *testing.T) {
+	require.Equal(t, []string{"buypass", "letsencrypt", "letsencrypt-staging", "zerossl"}, Presets())
+	preset, found := LookupPreset("letsencrypt")
+	require.True(t, found)
+	require.Equal(t, "https://acme-v02.api.letsencrypt.org/directory", preset.URL)
+	_, found = LookupPreset("unknown")
+	require.False(t, found)
+}
+
+func TestResolveDomainConfig(t *testing.T) {
+	config := defaultConfig()
+	config.Domains["example.org"] = DomainConfig{Http01Challenge: Http01ChallengeConfig{Enabled: true, Port: 5003}}
+	config.Domains["www.example.org."] = DomainConfig{Http01Challenge: Http01ChallengeConfig{Enabled: true, Port: 5003}}
+	config.Domains["*.example.org"] = DomainConfig{TLSAPN01Challenge: TLSAPN01ChallengeConfig{Enabled: true, Port: 5001}}
+	config.Domains["ample.org"] = DomainConfig{Http01Challenge: Http01ChallengeConfig{Enabled: true, Port: 5004}}
+	domainConfig, err := config.ResolveDomainConfig([]string{"www.example.org"})
+	require.NoError(t, err)
+	require.Equal(t, "www.example.org.", domainConfig.Domain)
+	domainConfig, err = config.ResolveDomainConfig([]string{"Mail.Example.org", "www.example.org"})
+	require.NoError(t, err)
+	require.Equal(t, "example.org", domainConfig.Domain)
+	domainConfig, err = config.ResolveDomainConfig([]string{"*.example.org"})
+	require.NoError(t, err)
+	require.Equal(t, "*.example.org", domainConfig.Domain)
+	_, err = config.ResolveDomainConfig([]string{"www.example.org", "*.example.org"})
+	require.ErrorContains(t, err, "'*.example.org'")
+	_, err = config.ResolveDomainConfig([]string{"www.example.org", "www.sample.org"})
+	require.EqualError(t, err, "missing domain configuration for domain 'www.sample.org'")
+	_, err = config.ResolveDomainConfig(nil)
+	require.Error(t, err)
+	config.Domains["."] = DomainConfig{Http01Challenge: Http01ChallengeConfig{Enabled: true, Port: 5003}}
+	domainConfig, err = config.ResolveDomainConfig([]string{"www.example.org", "www.sample.org"})
+	require.NoError(t, err)
+	require.Equal(t, "www.example.org.", domainConfig.Domain)
+}
+
+func TestProvider(t *testing.T) {
+	config, err := Load("./testdata/acme-test.yaml")
+	require.NoError(t, err)
+	provider := NewProvider(config)
+	require.Equal(t, "ACME", provider.Name())
+	require.Equal(t, []string{"ACME:EAB", "ACME:Preset", "ACME:Test"}, provider.CAs())
+	factory, err := provider.NewCertificateFactory(context.Background(), "ACME:Test", &certs.ProviderRequest{Domains: []string{"localhost"}})
+	require.NoError(t, err)
+	require.Equal(t, "ACME:Test", factory.Name())
+	_, err = provider.NewCertificateFactory(context.Background(), "ACME:Unknown", &certs.ProviderRequest{})
+	require.Error(t, err)
+	_, err = provider.NewCertificateFactory(context.Background(), "Local", &certs.ProviderRequest{})
+	require.Error(t, err)
 }
@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	legoacme "github.com/go-acme/lego/v4/acme"
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+const acmeErrorTypePrefix = "urn:ietf:params:acme:error:"
+
+var acmeErrorTypePattern = regexp.MustCompile(regexp.QuoteMeta(acmeErrorTypePrefix) + `[A-Za-z]+`)
+
+// Provider error kinds of the ACME error types (RFC 8555 section 6.7) denoting an actionable failure.
+var acmeErrorKinds = map[string]certs.ProviderErrorKind{
+	"dns":                   certs.ProviderDNSMissing,
+	"connection":            certs.ProviderChallengeFailed,
+	"incorrectResponse":     certs.ProviderChallengeFailed,
+	"tls":                   certs.ProviderChallengeFailed,
+	"unauthorized":          certs.ProviderChallengeFailed,
+	"caa":                   certs.ProviderPolicyViolation,
+	"rejectedIdentifier":    certs.ProviderPolicyViolation,
+	"badCSR":                certs.ProviderPolicyViolation,
+	"badSignatureAlgorithm": certs.ProviderPolicyViolation,
+	"rateLimited":           certs.ProviderRateLimited,
+}
+
+// Classify an ACME failure as a provider error (see certs.ProviderError).
+//
+// Unrecognized errors are passed through as is.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var preflightErr *PreflightError
+	if errors.As(err, &preflightErr) {
+		switch preflightErr.Check {
+		case PreflightCheckDNS, PreflightCheckDNS01:
+			return certs.NewProviderError(certs.ProviderDNSMissing, err)
+		default:
+			return certs.NewProviderError(certs.ProviderChallengeFailed, err)
+		}
+	}
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		providerErr := certs.NewProviderError(certs.ProviderRateLimited, err)
+		providerErr.Hint = fmt.Sprintf("Wait until %s before retrying (the CA's rate limit is in effect until then).", rateLimitErr.RetryAfter.Format(time.RFC3339))
+		return providerErr
+	}
+	// challenge failures are reported per domain and are therefore only available in text form
+	errorType := ""
+	var problem *legoacme.ProblemDetails
+	if errors.As(err, &problem) {
+		errorType = problem.Type
+	} else {
+		match := acmeErrorTypePattern.FindStringSubmatch(err.Error())
+		if match != nil {
+			errorType = match[0]
+		}
+	}
+	kind, found := acmeErrorKinds[strings.TrimPrefix(errorType, acmeErrorTypePrefix)]
+	if found {
+		return certs.NewProviderError(kind, err)
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	legoacme "github.com/go-acme/lego/v4/acme"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyError(t *testing.T) {
+	classify := func(err error) *certs.ProviderError {
+		var providerErr *certs.ProviderError
+		if !errors.As(classifyError(err), &providerErr) {
+			return nil
+		}
+		return providerErr
+	}
+	// preflight failures
+	preflightErr := &PreflightError{Check: PreflightCheckDNS, Domain: "example.org", Err: errors.New("no such host")}
+	providerErr := classify(preflightErr)
+	require.NotNil(t, providerErr)
+	require.Equal(t, certs.ProviderDNSMissing, providerErr.Kind)
+	require.ErrorAs(t, providerErr, &preflightErr)
+	// rate limits
+	retryAfter := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	providerErr = classify(&RateLimitError{RetryAfter: retryAfter, Err: errors.New("too many certificates")})
+	require.NotNil(t, providerErr)
+	require.Equal(t, certs.ProviderRateLimited, providerErr.Kind)
+	require.Contains(t, providerErr.Hint, retryAfter.Format(time.RFC3339))
+	// problem details
+	var problem error = &legoacme.ProblemDetails{HTTPStatus: 403, Type: "urn:ietf:params:acme:error:caa"}
+	providerErr = classify(fmt.Errorf("failed to obtain certificate (cause: %w)", problem))
+	require.NotNil(t, providerErr)
+	require.Equal(t, certs.ProviderPolicyViolation, providerErr.Kind)
+	// per domain challenge failures
+	providerErr = classify(errors.New("error: one or more domains had a problem:\n[example.org] acme: error: 400 :: urn:ietf:params:acme:error:dns :: DNS problem: NXDOMAIN"))
+	require.NotNil(t, providerErr)
+	require.Equal(t, certs.ProviderDNSMissing, providerErr.Kind)
+	// unrecognized errors
+	err := errors.New("unknown")
+	require.Same(t, err, classifyError(err))
+	require.Nil(t, classifyError(nil))
+}
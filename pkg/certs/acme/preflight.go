@@ -0,0 +1,192 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const PreflightCheckDNS = "dns"
+const PreflightCheckHttp01 = "http-01"
+const PreflightCheckTLSAPN01 = "tls-apn-01"
+const PreflightCheckDNS01 = "dns-01"
+
+const defaultPreflightTimeout = 10 * time.Second
+
+// PreflightError reports a failed preflight check performed before submitting an ACME order.
+type PreflightError struct {
+	Check  string
+	Domain string
+	Err    error
+}
+
+func (err *PreflightError) Error() string {
+	return fmt.Sprintf("%s preflight check failed for domain '%s' (cause: %v)", err.Check, err.Domain, err.Err)
+}
+
+func (err *PreflightError) Unwrap() error {
+	return err.Err
+}
+
+// Run the enabled preflight checks for the given domains and domain configuration.
+func Preflight(config *PreflightConfig, domains []string, domainConfig *DomainConfig) error {
+	if !config.Enabled {
+		return nil
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultPreflightTimeout
+	}
+	// domains validated via DNS-01 only need not resolve to an address
+	if domainConfig.Http01Challenge.Enabled || domainConfig.TLSAPN01Challenge.Enabled || !domainConfig.DNS01Challenge.Enabled {
+		for _, domain := range domains {
+			err := preflightDNS(domain, timeout)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if domainConfig.Http01Challenge.Enabled {
+		err := preflightHttp01(domains, &domainConfig.Http01Challenge, config.HttpReachability, timeout)
+		if err != nil {
+			return err
+		}
+	}
+	if domainConfig.TLSAPN01Challenge.Enabled {
+		err := preflightPort(domains[0], domainConfig.TLSAPN01Challenge.Iface, domainConfig.TLSAPN01Challenge.Port, PreflightCheckTLSAPN01)
+		if err != nil {
+			return err
+		}
+	}
+	if domainConfig.DNS01Challenge.Enabled {
+		err := preflightDNS01(domains, &domainConfig.DNS01Challenge)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Make sure all domains have an acme-dns account (newly registered accounts require the operator to create the
+// corresponding CNAME record first).
+func preflightDNS01(domains []string, challengeConfig *DNS01ChallengeConfig) error {
+	var cnameRequiredDomain string
+	var cnameRequiredErrs []error
+	for _, domain := range domains {
+		account, registered, err := RegisterACMEDNSAccount(&challengeConfig.ACMEDNS, domain)
+		if err != nil {
+			return &PreflightError{Check: PreflightCheckDNS01, Domain: domain, Err: err}
+		}
+		if registered {
+			if cnameRequiredDomain == "" {
+				cnameRequiredDomain = domain
+			}
+			cnameRequiredErrs = append(cnameRequiredErrs, &ACMEDNSCNAMERequiredError{Account: account})
+		}
+	}
+	if len(cnameRequiredErrs) > 0 {
+		return &PreflightError{Check: PreflightCheckDNS01, Domain: cnameRequiredDomain, Err: errors.Join(cnameRequiredErrs...)}
+	}
+	return nil
+}
+
+func preflightDNS(domain string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	lookupDomain := strings.TrimPrefix(domain, "*.")
+	addresses, err := net.DefaultResolver.LookupHost(ctx, lookupDomain)
+	if err != nil {
+		return &PreflightError{Check: PreflightCheckDNS, Domain: domain, Err: fmt.Errorf("domain does not resolve (cause: %w)", err)}
+	}
+	if len(addresses) == 0 {
+		return &PreflightError{Check: PreflightCheckDNS, Domain: domain, Err: errors.New("domain resolves to no address")}
+	}
+	return nil
+}
+
+func preflightPort(domain string, iface string, port int, check string) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(iface, strconv.Itoa(port)))
+	if err != nil {
+		return &PreflightError{Check: check, Domain: domain, Err: fmt.Errorf("challenge port %d is not available (cause: %w)", port, err)}
+	}
+	listener.Close()
+	return nil
+}
+
+func preflightHttp01(domains []string, challengeConfig *Http01ChallengeConfig, reachability bool, timeout time.Duration) error {
+	if !reachability {
+		return preflightPort(domains[0], challengeConfig.Iface, challengeConfig.Port, PreflightCheckHttp01)
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(challengeConfig.Iface, strconv.Itoa(challengeConfig.Port)))
+	if err != nil {
+		return &PreflightError{Check: PreflightCheckHttp01, Domain: domains[0], Err: fmt.Errorf("challenge port %d is not available (cause: %w)", challengeConfig.Port, err)}
+	}
+	tokenBytes := make([]byte, 16)
+	_, err = rand.Read(tokenBytes)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to generate preflight token (cause: %w)", err)
+	}
+	token := hex.EncodeToString(tokenBytes)
+	tokenPath := "/.well-known/acme-challenge/certd-preflight-" + token
+	mux := http.NewServeMux()
+	mux.HandleFunc(tokenPath, func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(token))
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: timeout}
+	go server.Serve(listener)
+	defer server.Close()
+	client := &http.Client{Timeout: timeout}
+	for _, domain := range domains {
+		if strings.HasPrefix(domain, "*.") {
+			continue
+		}
+		err = preflightHttp01Reachability(client, domain, tokenPath, token)
+		if err != nil {
+			return &PreflightError{Check: PreflightCheckHttp01, Domain: domain, Err: err}
+		}
+	}
+	return nil
+}
+
+func preflightHttp01Reachability(client *http.Client, domain string, tokenPath string, token string) error {
+	url := "http://" + domain + tokenPath
+	rsp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("challenge URL '%s' is not reachable (cause: %w)", url, err)
+	}
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(rsp.Body, 1024))
+	if err != nil {
+		return fmt.Errorf("failed to read challenge URL '%s' response (cause: %w)", url, err)
+	}
+	if rsp.StatusCode != http.StatusOK || string(body) != token {
+		return fmt.Errorf("challenge URL '%s' is not served by this instance (status: %d)", url, rsp.StatusCode)
+	}
+	return nil
+}
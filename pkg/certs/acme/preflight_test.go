@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreflight(t *testing.T) {
+	config := &PreflightConfig{Enabled: true}
+	domainConfig := &DomainConfig{
+		Http01Challenge: Http01ChallengeConfig{Enabled: true, Iface: "localhost", Port: 0},
+	}
+	err := Preflight(config, []string{"localhost"}, domainConfig)
+	require.NoError(t, err)
+	var preflightErr *PreflightError
+	err = Preflight(config, []string{"certd.invalid"}, domainConfig)
+	require.True(t, errors.As(err, &preflightErr))
+	require.Equal(t, PreflightCheckDNS, preflightErr.Check)
+	require.Equal(t, "certd.invalid", preflightErr.Domain)
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	domainConfig.Http01Challenge.Port = listener.Addr().(*net.TCPAddr).Port
+	err = Preflight(config, []string{"localhost"}, domainConfig)
+	require.True(t, errors.As(err, &preflightErr))
+	require.Equal(t, PreflightCheckHttp01, preflightErr.Check)
+	config.Enabled = false
+	err = Preflight(config, []string{"certd.invalid"}, domainConfig)
+	require.NoError(t, err)
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Preset describes a well-known ACME service selectable by name.
+type Preset struct {
+	URL         string
+	EABRequired bool
+}
+
+var presets = map[string]Preset{
+	"letsencrypt": {
+		URL: "https://acme-v02.api.letsencrypt.org/directory",
+	},
+	"letsencrypt-staging": {
+		URL: "https://acme-staging-v02.api.letsencrypt.org/directory",
+	},
+	"zerossl": {
+		URL:         "https://acme.zerossl.com/v2/DV90",
+		EABRequired: true,
+	},
+	"buypass": {
+		URL: "https://api.buypass.com/acme/directory",
+	},
+}
+
+// Get the names of all built-in ACME provider presets.
+func Presets() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get the built-in ACME provider preset with the given name.
+func LookupPreset(name string) (Preset, bool) {
+	preset, found := presets[name]
+	return preset, found
+}
+
+func (provider *Provider) applyPreset() error {
+	if provider.Preset == "" {
+		return nil
+	}
+	preset, found := LookupPreset(provider.Preset)
+	if !found {
+		return fmt.Errorf("unknown preset '%s' for ACME provider '%s'", provider.Preset, provider.Name)
+	}
+	if provider.URL == "" {
+		provider.URL = preset.URL
+	}
+	if preset.EABRequired && (provider.EAB.KID == "" || provider.EAB.HMAC == "") {
+		return fmt.Errorf("ACME provider '%s' requires external account binding (eab) credentials", provider.Name)
+	}
+	return nil
+}
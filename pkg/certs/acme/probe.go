@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/lego"
+)
+
+const probeTimeout = 10 * time.Second
+
+// ProviderStatus describes the outcome of probing an ACME provider's directory.
+type ProviderStatus struct {
+	Name           string
+	DirectoryURL   string
+	Reachable      bool
+	Error          string
+	TermsOfService string
+	Registered     bool
+	AccountURL     string
+}
+
+// Probe the directories of all configured providers and look up their account registrations.
+//
+// The returned status list is sorted by provider name.
+func ProbeProviders(config *Config) []ProviderStatus {
+	names := make([]string, 0, len(config.Providers))
+	for name := range config.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	statuses := make([]ProviderStatus, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		provider := config.Providers[name]
+		wg.Add(1)
+		go func(status *ProviderStatus) {
+			defer wg.Done()
+			*status = probeProvider(&provider)
+		}(&statuses[i])
+	}
+	wg.Wait()
+	return statuses
+}
+
+func probeProvider(provider *Provider) ProviderStatus {
+	status := ProviderStatus{
+		Name:         provider.Name,
+		DirectoryURL: provider.URL,
+	}
+	providerRegistration, err := findRegistration(provider)
+	if err == nil {
+		status.Registered = true
+		status.AccountURL = providerRegistration.Registration.URI
+	}
+	client := *lego.NewConfig(&ProviderRegistration{}).HTTPClient
+	client.Timeout = probeTimeout
+	directory, err := fetchDirectory(&client, provider.URL)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Reachable = true
+	status.TermsOfService = directory.Meta.TermsOfService
+	return status
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbeProviders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dir" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"newNonce":"/nonce","meta":{"termsOfService":"https://example.org/tos"}}`)
+	}))
+	defer server.Close()
+	config := defaultConfig()
+	config.Providers["Reachable"] = Provider{Name: "Reachable", URL: server.URL + "/dir", RegistrationEmail: "probe@localhost"}
+	config.Providers["Unreachable"] = Provider{Name: "Unreachable", URL: server.URL + "/unknown", RegistrationEmail: "probe@localhost"}
+	err := updateProviderRegistrations(&ProviderRegistration{
+		Provider:     "Reachable",
+		Email:        "probe@localhost",
+		Registration: &registration.Resource{URI: server.URL + "/acct/1"},
+	})
+	require.NoError(t, err)
+	defer removeProviderRegistration(&ProviderRegistration{Provider: "Reachable", Email: "probe@localhost"})
+	statuses := ProbeProviders(config)
+	require.Equal(t, 2, len(statuses))
+	require.Equal(t, "Reachable", statuses[0].Name)
+	require.True(t, statuses[0].Reachable)
+	require.Equal(t, "https://example.org/tos", statuses[0].TermsOfService)
+	require.True(t, statuses[0].Registered)
+	require.Equal(t, server.URL+"/acct/1", statuses[0].AccountURL)
+	require.Equal(t, "Unreachable", statuses[1].Name)
+	require.False(t, statuses[1].Reachable)
+	require.NotEmpty(t, statuses[1].Error)
+	require.False(t, statuses[1].Registered)
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+type acmeProvider struct {
+	config *Config
+}
+
+// Create the certificate provider offering a CA for each provider defined in the given ACME configuration.
+func NewProvider(config *Config) certs.CertificateProvider {
+	return &acmeProvider{config: config}
+}
+
+func (provider *acmeProvider) Name() string {
+	return strings.TrimSuffix(ProviderPrefix, ":")
+}
+
+func (provider *acmeProvider) CAs() []string {
+	cas := make([]string, 0, len(provider.config.Providers))
+	for name := range provider.config.Providers {
+		cas = append(cas, ProviderPrefix+name)
+	}
+	sort.Strings(cas)
+	return cas
+}
+
+func (provider *acmeProvider) NewCertificateFactory(ctx context.Context, ca string, request *certs.ProviderRequest) (certs.CertificateFactory, error) {
+	providerName, found := strings.CutPrefix(ca, ProviderPrefix)
+	if !found {
+		return nil, fmt.Errorf("unrecognized ACME CA '%s'", ca)
+	}
+	if _, found = provider.config.Providers[providerName]; !found {
+		return nil, fmt.Errorf("unknown ACME provider '%s'", providerName)
+	}
+	return NewACMECertificateFactoryWithConfig(request.Domains, provider.config, providerName, request.KeyFactory), nil
+}
@@ -18,6 +18,7 @@
 package acme
 
 import (
+	"context"
 	"crypto"
 	"crypto/x509"
 	"encoding/base64"
@@ -36,6 +37,10 @@ const providerRegistrationsFile = "acme-registrations.json"
 
 var providerRegistrationsFileMutex sync.RWMutex
 
+func init() {
+	state.RegisterPath(providerRegistrationsFile)
+}
+
 type ProviderRegistration struct {
 	Provider     string `json:"provider"`
 	Email        string `json:"email"`
@@ -74,17 +79,24 @@ func (providerRegistration *ProviderRegistration) isValid(client *lego.Client) b
 	return err == nil
 }
 
-func (providerRegistration *ProviderRegistration) refresh(client *lego.Client, keyFactory keys.KeyPairFactory) error {
-	options := registration.RegisterOptions{TermsOfServiceAgreed: true}
-	registration, err := client.Registration.Register(options)
+func (providerRegistration *ProviderRegistration) refresh(client *lego.Client, provider *Provider) error {
+	var resource *registration.Resource
+	var err error
+	if provider.EAB.KID != "" {
+		options := registration.RegisterEABOptions{TermsOfServiceAgreed: true, Kid: provider.EAB.KID, HmacEncoded: provider.EAB.HMAC}
+		resource, err = client.Registration.RegisterWithExternalAccountBinding(options)
+	} else {
+		options := registration.RegisterOptions{TermsOfServiceAgreed: true}
+		resource, err = client.Registration.Register(options)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to register at ACME provider '%s' (cause: %w)", providerRegistration.Provider, err)
 	}
-	providerRegistration.Registration = registration
+	providerRegistration.Registration = resource
 	return updateProviderRegistrations(providerRegistration)
 }
 
-func getRegistration(provider *Provider, keyFactory keys.KeyPairFactory) (*ProviderRegistration, error) {
+func getRegistration(ctx context.Context, provider *Provider, keyFactory keys.KeyPairFactory) (*ProviderRegistration, error) {
 	providerRegistrationsFileMutex.RLock()
 	defer providerRegistrationsFileMutex.RUnlock()
 	providerRegistrations, err := loadProviderRegistrations()
@@ -96,7 +108,7 @@ func getRegistration(provider *Provider, keyFactory keys.KeyPairFactory) (*Provi
 			return &providerRegistration, nil
 		}
 	}
-	key, err := keyFactory.New()
+	key, err := keys.NewKeyPair(ctx, keyFactory)
 	if err != nil {
 		return nil, err
 	}
@@ -112,6 +124,37 @@ func getRegistration(provider *Provider, keyFactory keys.KeyPairFactory) (*Provi
 	return defaultProviderRegistration, nil
 }
 
+func findRegistration(provider *Provider) (*ProviderRegistration, error) {
+	providerRegistrationsFileMutex.RLock()
+	defer providerRegistrationsFileMutex.RUnlock()
+	providerRegistrations, err := loadProviderRegistrations()
+	if err != nil {
+		return nil, err
+	}
+	for _, providerRegistration := range providerRegistrations {
+		if providerRegistration.Provider == provider.Name && providerRegistration.Email == provider.RegistrationEmail && providerRegistration.Registration != nil {
+			return &providerRegistration, nil
+		}
+	}
+	return nil, fmt.Errorf("no account registered for ACME provider '%s'", provider.Name)
+}
+
+func removeProviderRegistration(remove *ProviderRegistration) error {
+	providerRegistrationsFileMutex.Lock()
+	defer providerRegistrationsFileMutex.Unlock()
+	providerRegistrations, err := loadProviderRegistrations()
+	if err != nil {
+		return err
+	}
+	remaining := make([]ProviderRegistration, 0, len(providerRegistrations))
+	for _, providerRegistration := range providerRegistrations {
+		if providerRegistration.Provider != remove.Provider || providerRegistration.Email != remove.Email {
+			remaining = append(remaining, providerRegistration)
+		}
+	}
+	return writeProviderRegistrations(remaining)
+}
+
 func updateProviderRegistrations(update *ProviderRegistration) error {
 	providerRegistrationsFileMutex.Lock()
 	defer providerRegistrationsFileMutex.Unlock()
@@ -131,6 +174,10 @@ func updateProviderRegistrations(update *ProviderRegistration) error {
 	} else {
 		providerRegistrations = append(providerRegistrations, *update)
 	}
+	return writeProviderRegistrations(providerRegistrations)
+}
+
+func writeProviderRegistrations(providerRegistrations []ProviderRegistration) error {
 	providerRegistrationBytes, err := json.MarshalIndent(providerRegistrations, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal registrations (cause: %w)", err)
@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	legoacme "github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/rs/zerolog"
+)
+
+const defaultRetryMaxAttempts = 3
+const defaultRetryInitialBackoff = 5 * time.Second
+const defaultRetryMaxBackoff = time.Minute
+const defaultRetryMaxScheduled = 3
+
+const rateLimitedErrorType = "urn:ietf:params:acme:error:rateLimited"
+
+// RetryConfig controls how failed ACME orders are retried.
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	MaxScheduled   int           `yaml:"max_scheduled"`
+}
+
+// RateLimitError reports an ACME rate limit which can not be waited out within the configured backoff.
+//
+// The caller may schedule a new attempt not before RetryAfter.
+type RateLimitError struct {
+	RetryAfter time.Time
+	Err        error
+}
+
+func (err *RateLimitError) Error() string {
+	return fmt.Sprintf("ACME rate limit reached; retry after %s (cause: %v)", err.RetryAfter.Format(time.RFC3339), err.Err)
+}
+
+func (err *RateLimitError) Unwrap() error {
+	return err.Err
+}
+
+var retryAfterPattern = regexp.MustCompile(`(?i)retry after (\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2})(?:\.\d+)?(?: ?UTC|Z)?`)
+
+var sleep = sleepContext
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func obtainWithRetry(ctx context.Context, config *RetryConfig, logger *zerolog.Logger, obtain func() (*certificate.Resource, error)) (*certificate.Resource, error) {
+	maxAttempts := config.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	backoff := config.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		resource, err := obtain()
+		if err == nil {
+			return resource, nil
+		}
+		rateLimited, retryAfter := evalRateLimit(err)
+		if !rateLimited && !isTransient(err) {
+			return nil, err
+		}
+		delay := backoff
+		if !retryAfter.IsZero() {
+			delay = time.Until(retryAfter)
+		}
+		if attempt >= maxAttempts || delay > config.MaxBackoff {
+			if rateLimited {
+				if retryAfter.IsZero() {
+					retryAfter = time.Now().Add(config.MaxBackoff)
+				}
+				return nil, &RateLimitError{RetryAfter: retryAfter, Err: err}
+			}
+			return nil, err
+		}
+		logger.Warn().Err(err).Msgf("ACME order attempt %d failed; retrying in %s...", attempt, delay.Round(time.Second))
+		err = sleep(ctx, delay)
+		if err != nil {
+			return nil, err
+		}
+		backoff *= 2
+		if backoff > config.MaxBackoff {
+			backoff = config.MaxBackoff
+		}
+	}
+}
+
+func evalRateLimit(err error) (bool, time.Time) {
+	var problem *legoacme.ProblemDetails
+	var detail string
+	if errors.As(err, &problem) {
+		if problem.Type != rateLimitedErrorType && problem.HTTPStatus != 429 {
+			return false, time.Time{}
+		}
+		detail = problem.Detail
+	} else {
+		detail = err.Error()
+		if !strings.Contains(detail, rateLimitedErrorType) {
+			return false, time.Time{}
+		}
+	}
+	match := retryAfterPattern.FindStringSubmatch(detail)
+	if match == nil {
+		return true, time.Time{}
+	}
+	retryAfter, parseErr := time.Parse("2006-01-02 15:04:05", strings.Replace(match[1], "T", " ", 1))
+	if parseErr != nil {
+		return true, time.Time{}
+	}
+	return true, retryAfter
+}
+
+func isTransient(err error) bool {
+	// context errors satisfy net.Error, but are final
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var problem *legoacme.ProblemDetails
+	if errors.As(err, &problem) {
+		return problem.HTTPStatus >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package acme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	legoacme "github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObtainWithRetry(t *testing.T) {
+	var delays []time.Duration
+	sleep = func(ctx context.Context, delay time.Duration) error {
+		delays = append(delays, delay)
+		return nil
+	}
+	defer func() {
+		sleep = sleepContext
+	}()
+	config := &RetryConfig{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: time.Minute}
+	logger := logging.ModuleLogger(logging.ModuleACME)
+	// transient errors are retried with exponential backoff
+	attempts := 0
+	resource, err := obtainWithRetry(context.Background(), config, logger, func() (*certificate.Resource, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, &legoacme.ProblemDetails{HTTPStatus: 503}
+		}
+		return &certificate.Resource{}, nil
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resource)
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second}, delays)
+	// permanent errors are not retried
+	attempts = 0
+	_, err = obtainWithRetry(context.Background(), config, logger, func() (*certificate.Resource, error) {
+		attempts++
+		return nil, &legoacme.ProblemDetails{HTTPStatus: 403, Type: "urn:ietf:params:acme:error:unauthorized"}
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+	// long lasting rate limits are reported for scheduling
+	retryAfter := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+	_, err = obtainWithRetry(context.Background(), config, logger, func() (*certificate.Resource, error) {
+		var problem error = &legoacme.ProblemDetails{
+			HTTPStatus: 429,
+			Type:       rateLimitedErrorType,
+			Detail:     fmt.Sprintf("too many certificates already issued: see https://letsencrypt.org/docs/rate-limits/, retry after %s", retryAfter.Format("2006-01-02 15:04:05 MST")),
+		}
+		return nil, fmt.Errorf("failed to obtain (cause: %w)", problem)
+	})
+	var rateLimitErr *RateLimitError
+	require.True(t, errors.As(err, &rateLimitErr))
+	require.Equal(t, retryAfter, rateLimitErr.RetryAfter)
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package adcs
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/rs/zerolog"
+)
+
+// ProviderType is the type name used to define AD CS providers in the provider configuration.
+const ProviderType = "adcs"
+
+// ProviderPrefix is prepended to the provider name to build the CA name.
+const ProviderPrefix = "ADCS:"
+
+// ProtocolCertsrv selects the certsrv web enrollment pages (e.g. https://ca.example.org/certsrv).
+const ProtocolCertsrv = "certsrv"
+
+// ProtocolWSTEP selects the MS-WSTEP protocol of a certificate enrollment web service (CES).
+const ProtocolWSTEP = "wstep"
+
+// Config defines the AD CS enrollment endpoint and the certificate template to enroll for.
+type Config struct {
+	URL      string        `yaml:"url"`
+	Protocol string        `yaml:"protocol"`
+	Template string        `yaml:"template"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+func init() {
+	certs.RegisterProviderType(&certs.ProviderType{
+		Name: ProviderType,
+		NewConfig: func() any {
+			return &Config{
+				Protocol: ProtocolCertsrv,
+				Timeout:  30 * time.Second,
+			}
+		},
+		New: func(name string, config any) (certs.CertificateProvider, error) {
+			return NewProvider(name, config.(*Config))
+		},
+	})
+}
+
+// enroller submits a certificate request and returns the issued certificate and its issuer chain.
+type enroller interface {
+	enroll(ctx context.Context, request *x509.CertificateRequest, template string) (*x509.Certificate, []*x509.Certificate, error)
+}
+
+type adcsProvider struct {
+	name     string
+	config   *Config
+	enroller enroller
+}
+
+// Create a provider enrolling certificates via Active Directory Certificate Services.
+func NewProvider(name string, config *Config) (certs.CertificateProvider, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("missing AD CS URL for provider '%s'", name)
+	}
+	if config.Template == "" {
+		return nil, fmt.Errorf("missing AD CS certificate template for provider '%s'", name)
+	}
+	client := &http.Client{Timeout: config.Timeout}
+	var enroller enroller
+	switch config.Protocol {
+	case "", ProtocolCertsrv:
+		enroller = &certsrvEnroller{url: strings.TrimSuffix(config.URL, "/"), config: config, client: client}
+	case ProtocolWSTEP:
+		enroller = &wstepEnroller{url: config.URL, config: config, client: client}
+	default:
+		return nil, fmt.Errorf("unrecognized AD CS protocol '%s' for provider '%s'", config.Protocol, name)
+	}
+	return &adcsProvider{
+		name:     name,
+		config:   config,
+		enroller: enroller,
+	}, nil
+}
+
+func (provider *adcsProvider) Name() string {
+	return provider.name
+}
+
+func (provider *adcsProvider) CAs() []string {
+	return []string{ProviderPrefix + provider.name}
+}
+
+func (provider *adcsProvider) NewCertificateFactory(ctx context.Context, ca string, request *certs.ProviderRequest) (certs.CertificateFactory, error) {
+	if ca != ProviderPrefix+provider.name {
+		return nil, fmt.Errorf("unrecognized AD CS CA '%s'", ca)
+	}
+	template := provider.config.Template
+	if request.Params["template"] != "" {
+		template = request.Params["template"]
+	}
+	logger := logging.RootLogger().With().Str("Provider", ca).Logger()
+	return &ADCSCertificateFactory{
+		provider: provider,
+		name:     ca,
+		request:  request,
+		template: template,
+		logger:   &logger,
+	}, nil
+}
+
+type ADCSCertificateFactory struct {
+	provider *adcsProvider
+	name     string
+	request  *certs.ProviderRequest
+	template string
+	chain    []*x509.Certificate
+	logger   *zerolog.Logger
+}
+
+func (factory *ADCSCertificateFactory) Name() string {
+	return factory.name
+}
+
+func (factory *ADCSCertificateFactory) Chain() []*x509.Certificate {
+	return factory.chain
+}
+
+func (factory *ADCSCertificateFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error) {
+	key, certificateRequest, err := factory.request.NewCertificateRequest(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	factory.logger.Info().Msgf("Enrolling certificate '%s' using template '%s'...", certificateRequest.Subject, factory.template)
+	certificate, chain, err := factory.provider.enroller.enroll(ctx, certificateRequest, factory.template)
+	if err != nil {
+		return nil, nil, err
+	}
+	factory.chain = chain
+	return key, certificate, nil
+}
+
+// Split the certificates returned by the CA into the issued certificate and its issuer chain.
+func splitChain(request *x509.CertificateRequest, certificates []*x509.Certificate) (*x509.Certificate, []*x509.Certificate, error) {
+	var issued *x509.Certificate
+	chain := make([]*x509.Certificate, 0, len(certificates))
+	for _, certificate := range certificates {
+		if issued == nil && certificate.PublicKeyAlgorithm == request.PublicKeyAlgorithm && publicKeyEqual(certificate.PublicKey, request.PublicKey) {
+			issued = certificate
+			continue
+		}
+		chain = append(chain, certificate)
+	}
+	if issued == nil {
+		return nil, nil, fmt.Errorf("issued certificate missing in CA response")
+	}
+	return issued, chain, nil
+}
+
+func publicKeyEqual(key1 crypto.PublicKey, key2 crypto.PublicKey) bool {
+	equalKey, ok := key1.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && equalKey.Equal(key2)
+}
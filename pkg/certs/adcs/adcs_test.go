@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package adcs
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/keys/registry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertsrvEnrollment(t *testing.T) {
+	ca := newTestCA(t)
+	issued := make(map[string][]byte)
+	adcs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		if username != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/certsrv/certfnsh.asp":
+			require.NoError(t, r.ParseForm())
+			if r.Form.Get("CertAttrib") != "CertificateTemplate:WebServer" {
+				fmt.Fprint(w, `<html>The disposition message is "Denied by Policy Module".</html>`)
+				return
+			}
+			csrBlock, _ := pem.Decode([]byte(r.Form.Get("CertRequest")))
+			require.NotNil(t, csrBlock)
+			reqID := fmt.Sprint(len(issued) + 1)
+			issued[reqID] = ca.sign(t, csrBlock.Bytes)
+			fmt.Fprintf(w, `<html><a href="certnew.cer?ReqID=%s&amp;Enc=b64">Download certificate</a></html>`, reqID)
+		case "/certsrv/certnew.p7b":
+			certificate, err := x509.ParseCertificate(issued[r.URL.Query().Get("ReqID")])
+			require.NoError(t, err)
+			p7b, err := certs.EncodeCertificatesPKCS7([]*x509.Certificate{ca.certificate, certificate}, nil)
+			require.NoError(t, err)
+			_ = pem.Encode(w, &pem.Block{Type: "PKCS7", Bytes: p7b})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer adcs.Close()
+	provider, err := certs.NewProvider(ProviderType, "Test", func(config any) error {
+		adcsConfig := config.(*Config)
+		adcsConfig.URL = adcs.URL + "/certsrv/"
+		adcsConfig.Template = "WebServer"
+		adcsConfig.Username = "user"
+		adcsConfig.Password = "secret"
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ADCS:Test"}, provider.CAs())
+	testEnrollment(t, provider, ca)
+	request := &certs.ProviderRequest{
+		Domains:    []string{"www.example.org"},
+		KeyFactory: registry.StandardKey("ECDSA P-256"),
+		Params:     map[string]string{"template": "User"},
+	}
+	factory, err := provider.NewCertificateFactory(context.Background(), "ADCS:Test", request)
+	require.NoError(t, err)
+	_, _, err = factory.New(context.Background())
+	require.ErrorContains(t, err, "Denied by Policy Module")
+}
+
+var wstepTokenPattern = regexp.MustCompile(`#PKCS10"[^>]*>([^<]*)<`)
+
+func TestWSTEPEnrollment(t *testing.T) {
+	ca := newTestCA(t)
+	adcs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		envelope, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+		if !regexp.MustCompile(`<o:Username>user</o:Username>`).Match(envelope) {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body><s:Fault><s:Reason><s:Text xml:lang="en-US">Access denied</s:Text></s:Reason></s:Fault></s:Body></s:Envelope>`)
+			return
+		}
+		token := wstepTokenPattern.FindSubmatch(envelope)
+		require.NotNil(t, token)
+		csr, err := base64.StdEncoding.DecodeString(string(token[1]))
+		require.NoError(t, err)
+		certificateBytes := ca.sign(t, csr)
+		certificate, err := x509.ParseCertificate(certificateBytes)
+		require.NoError(t, err)
+		p7b, err := certs.EncodeCertificatesPKCS7([]*x509.Certificate{certificate, ca.certificate}, nil)
+		require.NoError(t, err)
+		fmt.Fprintf(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body>
+<RequestSecurityTokenResponseCollection xmlns="http://docs.oasis-open.org/ws-sx/ws-trust/200512">
+<RequestSecurityTokenResponse>
+<DispositionMessage xmlns="http://schemas.microsoft.com/windows/pki/2009/01/enrollment">Issued</DispositionMessage>
+<BinarySecurityToken xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">%s</BinarySecurityToken>
+<RequestedSecurityToken><BinarySecurityToken xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">%s</BinarySecurityToken></RequestedSecurityToken>
+</RequestSecurityTokenResponse>
+</RequestSecurityTokenResponseCollection>
+</s:Body></s:Envelope>`, base64.StdEncoding.EncodeToString(p7b), base64.StdEncoding.EncodeToString(certificateBytes))
+	}))
+	defer adcs.Close()
+	provider, err := NewProvider("Test", &Config{URL: adcs.URL, Protocol: ProtocolWSTEP, Template: "WebServer", Username: "user", Password: "secret"})
+	require.NoError(t, err)
+	testEnrollment(t, provider, ca)
+	provider, err = NewProvider("Test", &Config{URL: adcs.URL, Protocol: ProtocolWSTEP, Template: "WebServer"})
+	require.NoError(t, err)
+	factory, err := provider.NewCertificateFactory(context.Background(), "ADCS:Test", &certs.ProviderRequest{Domains: []string{"www.example.org"}, KeyFactory: registry.StandardKey("ECDSA P-256")})
+	require.NoError(t, err)
+	_, _, err = factory.New(context.Background())
+	require.ErrorContains(t, err, "Access denied")
+}
+
+func TestNewProviderInvalidConfig(t *testing.T) {
+	_, err := NewProvider("Test", &Config{Template: "WebServer"})
+	require.Error(t, err)
+	_, err = NewProvider("Test", &Config{URL: "https://ca.example.org/certsrv"})
+	require.Error(t, err)
+	_, err = NewProvider("Test", &Config{URL: "https://ca.example.org/certsrv", Template: "WebServer", Protocol: "unknown"})
+	require.Error(t, err)
+}
+
+func testEnrollment(t *testing.T, provider certs.CertificateProvider, ca *testCA) {
+	request := &certs.ProviderRequest{
+		Domains:    []string{"www.example.org"},
+		KeyFactory: registry.StandardKey("ECDSA P-256"),
+	}
+	_, err := provider.NewCertificateFactory(context.Background(), "ADCS:Other", request)
+	require.Error(t, err)
+	factory, err := provider.NewCertificateFactory(context.Background(), "ADCS:Test", request)
+	require.NoError(t, err)
+	key, certificate, err := factory.New(context.Background())
+	require.NoError(t, err)
+	require.True(t, key.(crypto.Signer).Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(certificate.PublicKey))
+	require.Equal(t, "www.example.org", certificate.Subject.CommonName)
+	chain := factory.(certs.CertificateChainFactory).Chain()
+	require.Equal(t, []*x509.Certificate{ca.certificate}, chain)
+}
+
+type testCA struct {
+	key         crypto.Signer
+	certificate *x509.Certificate
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "AD CS Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	require.NoError(t, err)
+	return &testCA{key: key, certificate: certificate}
+}
+
+func (ca *testCA) sign(t *testing.T, csrBytes []byte) []byte {
+	csr, err := x509.ParseCertificateRequest(csrBytes)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, csr.PublicKey, ca.key)
+	require.NoError(t, err)
+	return certificateBytes
+}
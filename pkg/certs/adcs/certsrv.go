@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package adcs
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+// certsrvEnroller uses the certsrv web enrollment pages.
+//
+// The request is submitted via certfnsh.asp and the issued certificate including its chain is fetched (as p7b) via
+// the request id contained in the result page.
+type certsrvEnroller struct {
+	url    string
+	config *Config
+	client *http.Client
+}
+
+var certsrvReqIDPattern = regexp.MustCompile(`certnew\.cer\?ReqID=(\d+)`)
+var certsrvDispositionPattern = regexp.MustCompile(`The disposition message is "([^"]*)"`)
+var certsrvPendingPattern = regexp.MustCompile(`(?i)certificate (request )?(is )?pending`)
+
+func (enroller *certsrvEnroller) enroll(ctx context.Context, request *x509.CertificateRequest, template string) (*x509.Certificate, []*x509.Certificate, error) {
+	form := url.Values{}
+	form.Set("Mode", "newreq")
+	form.Set("CertRequest", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: request.Raw})))
+	form.Set("CertAttrib", "CertificateTemplate:"+template)
+	form.Set("TargetStoreFlags", "0")
+	form.Set("SaveCert", "yes")
+	page, err := enroller.do(ctx, http.MethodPost, "/certfnsh.asp", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, nil, err
+	}
+	reqID := certsrvReqIDPattern.FindSubmatch(page)
+	if reqID == nil {
+		disposition := certsrvDispositionPattern.FindSubmatch(page)
+		if disposition != nil {
+			return nil, nil, fmt.Errorf("certificate request denied by AD CS (disposition: %s)", disposition[1])
+		}
+		if certsrvPendingPattern.Match(page) {
+			return nil, nil, fmt.Errorf("certificate request is pending approval by AD CS")
+		}
+		return nil, nil, fmt.Errorf("unexpected AD CS enrollment response")
+	}
+	chainBytes, err := enroller.do(ctx, http.MethodGet, "/certnew.p7b?ReqID="+string(reqID[1])+"&Enc=b64", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	certificates, err := certs.DecodeCertificatesPKCS7(chainBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode issued certificate (cause: %w)", err)
+	}
+	return splitChain(request, certificates)
+}
+
+func (enroller *certsrvEnroller) do(ctx context.Context, method string, path string, body io.Reader) ([]byte, error) {
+	target := enroller.url + path
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare AD CS request '%s' (cause: %w)", target, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if enroller.config.Username != "" {
+		req.SetBasicAuth(enroller.config.Username, enroller.config.Password)
+	}
+	resp, err := enroller.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send AD CS request '%s' (cause: %w)", target, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("AD CS request '%s' failed (status: %s)", target, resp.Status)
+	}
+	responseBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read AD CS response '%s' (cause: %w)", target, err)
+	}
+	return responseBytes, nil
+}
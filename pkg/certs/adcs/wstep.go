@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package adcs
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/entropy"
+)
+
+// wstepEnroller uses the MS-WSTEP (WS-Trust X.509v3 token enrollment) protocol of a certificate enrollment
+// web service.
+//
+// Credentials are passed via a WS-Security username token.
+type wstepEnroller struct {
+	url    string
+	config *Config
+	client *http.Client
+}
+
+const wstepAction = "http://schemas.microsoft.com/windows/pki/2009/01/enrollment/RST/wstep"
+
+const wstepRequestTemplate = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://www.w3.org/2005/08/addressing">
+<s:Header>
+<a:Action s:mustUnderstand="1">%s</a:Action>
+<a:MessageID>urn:uuid:%s</a:MessageID>
+<a:To s:mustUnderstand="1">%s</a:To>%s
+</s:Header>
+<s:Body>
+<RequestSecurityToken PreferredLanguage="en-US" xmlns="http://docs.oasis-open.org/ws-sx/ws-trust/200512">
+<TokenType>http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-x509-token-profile-1.0#X509v3</TokenType>
+<RequestType>http://docs.oasis-open.org/ws-sx/ws-trust/200512/Issue</RequestType>
+<BinarySecurityToken ValueType="http://schemas.microsoft.com/windows/pki/2009/01/enrollment#PKCS10" EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd#base64binary" xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">%s</BinarySecurityToken>
+<AdditionalContext xmlns="http://schemas.xmlsoap.org/ws/2006/12/authorization">
+<ContextItem Name="CertificateTemplate"><Value>%s</Value></ContextItem>
+</AdditionalContext>
+</RequestSecurityToken>
+</s:Body>
+</s:Envelope>`
+
+const wstepSecurityTemplate = `
+<o:Security s:mustUnderstand="1" xmlns:o="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">
+<o:UsernameToken><o:Username>%s</o:Username><o:Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordText">%s</o:Password></o:UsernameToken>
+</o:Security>`
+
+type wstepEnvelope struct {
+	Body wstepBody `xml:"Body"`
+}
+
+type wstepBody struct {
+	Fault      *wstepFault      `xml:"Fault"`
+	Collection *wstepCollection `xml:"RequestSecurityTokenResponseCollection"`
+}
+
+type wstepFault struct {
+	Reason string `xml:"Reason>Text"`
+}
+
+type wstepCollection struct {
+	Response wstepResponse `xml:"RequestSecurityTokenResponse"`
+}
+
+type wstepResponse struct {
+	DispositionMessage string `xml:"DispositionMessage"`
+	// The PKCS#7 encoded certificate chain (including the issued certificate)
+	BinarySecurityToken string `xml:"BinarySecurityToken"`
+	// The issued certificate
+	RequestedSecurityToken string `xml:"RequestedSecurityToken>BinarySecurityToken"`
+}
+
+func (enroller *wstepEnroller) enroll(ctx context.Context, request *x509.CertificateRequest, template string) (*x509.Certificate, []*x509.Certificate, error) {
+	messageID, err := newMessageID()
+	if err != nil {
+		return nil, nil, err
+	}
+	security := ""
+	if enroller.config.Username != "" {
+		security = fmt.Sprintf(wstepSecurityTemplate, xmlEscape(enroller.config.Username), xmlEscape(enroller.config.Password))
+	}
+	envelope := fmt.Sprintf(wstepRequestTemplate, wstepAction, messageID, xmlEscape(enroller.url), security, base64.StdEncoding.EncodeToString(request.Raw), xmlEscape(template))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, enroller.url, strings.NewReader(envelope))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to prepare AD CS request '%s' (cause: %w)", enroller.url, err)
+	}
+	req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+	resp, err := enroller.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send AD CS request '%s' (cause: %w)", enroller.url, err)
+	}
+	defer resp.Body.Close()
+	responseBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read AD CS response '%s' (cause: %w)", enroller.url, err)
+	}
+	response := &wstepEnvelope{}
+	err = xml.Unmarshal(responseBytes, response)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode AD CS response '%s' (status: %s; cause: %w)", enroller.url, resp.Status, err)
+	}
+	if response.Body.Fault != nil {
+		return nil, nil, fmt.Errorf("certificate request denied by AD CS (fault: %s)", strings.TrimSpace(response.Body.Fault.Reason))
+	}
+	if response.Body.Collection == nil || strings.TrimSpace(response.Body.Collection.Response.RequestedSecurityToken) == "" {
+		if response.Body.Collection != nil && response.Body.Collection.Response.DispositionMessage != "" {
+			return nil, nil, fmt.Errorf("certificate not issued by AD CS (disposition: %s)", response.Body.Collection.Response.DispositionMessage)
+		}
+		return nil, nil, fmt.Errorf("unexpected AD CS enrollment response (status: %s)", resp.Status)
+	}
+	certificates := make([]*x509.Certificate, 0)
+	chainBytes, err := base64.StdEncoding.DecodeString(strings.TrimSpace(response.Body.Collection.Response.BinarySecurityToken))
+	if err == nil && len(chainBytes) > 0 {
+		certificates, err = certs.DecodeCertificatesPKCS7(chainBytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode certificate chain (cause: %w)", err)
+		}
+	}
+	certificateBytes, err := base64.StdEncoding.DecodeString(strings.TrimSpace(response.Body.Collection.Response.RequestedSecurityToken))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode issued certificate (cause: %w)", err)
+	}
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse issued certificate (cause: %w)", err)
+	}
+	_, chain, err := splitChain(request, append([]*x509.Certificate{certificate}, certificates...))
+	if err != nil {
+		return nil, nil, err
+	}
+	return certificate, withoutCertificate(chain, certificate), nil
+}
+
+func withoutCertificate(certificates []*x509.Certificate, certificate *x509.Certificate) []*x509.Certificate {
+	filtered := make([]*x509.Certificate, 0, len(certificates))
+	for _, candidate := range certificates {
+		if !candidate.Equal(certificate) {
+			filtered = append(filtered, candidate)
+		}
+	}
+	return filtered
+}
+
+func newMessageID() (string, error) {
+	id := make([]byte, 16)
+	_, err := io.ReadFull(entropy.Reader(), id)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate message id (cause: %w)", err)
+	}
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	encoded := hex.EncodeToString(id)
+	return encoded[0:8] + "-" + encoded[8:12] + "-" + encoded[12:16] + "-" + encoded[16:20] + "-" + encoded[20:], nil
+}
+
+func xmlEscape(value string) string {
+	var escaped bytes.Buffer
+	_ = xml.EscapeText(&escaped, []byte(value))
+	return escaped.String()
+}
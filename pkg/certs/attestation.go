@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+)
+
+// Verify an attestation statement for the given public key.
+//
+// The attestation statement consists of the attestation certificate (certifying the attested public key)
+// followed by any intermediate certificates required to verify it. If roots are given, the attestation
+// is only accepted if it can be verified against these roots. Without roots the attestation chain is only
+// checked for consistency and the resulting attestation is marked as unverified.
+func VerifyAttestation(publicKey crypto.PublicKey, attestation []*x509.Certificate, roots *x509.CertPool) (*StoreEntryAttestation, error) {
+	if len(attestation) == 0 {
+		return nil, fmt.Errorf("empty attestation statement")
+	}
+	attestationCertificate := attestation[0]
+	attestedKey, ok := attestationCertificate.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !attestedKey.Equal(publicKey) {
+		return nil, fmt.Errorf("attestation certificate does not match public key")
+	}
+	for i := 1; i < len(attestation); i++ {
+		err := attestation[i].CheckSignature(attestation[i-1].SignatureAlgorithm, attestation[i-1].RawTBSCertificate, attestation[i-1].Signature)
+		if err != nil {
+			return nil, fmt.Errorf("inconsistent attestation chain (cause: %w)", err)
+		}
+	}
+	verified := false
+	if roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, intermediate := range attestation[1:] {
+			intermediates.AddCert(intermediate)
+		}
+		options := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   attestationCertificate.NotBefore,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}
+		_, err := attestationCertificate.Verify(options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify attestation (cause: %w)", err)
+		}
+		verified = true
+	}
+	return &StoreEntryAttestation{
+		Verified: verified,
+		Subject:  attestationCertificate.Subject.String(),
+		Issuer:   attestationCertificate.Issuer.String(),
+	}, nil
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyAttestation(t *testing.T) {
+	rootKey, root := newTestCertificate(t, "Attestation Root", nil, nil, true)
+	attestedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, attestationCertificate := newTestCertificate(t, "Attestation", attestedKey, root, false, rootKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	verified, err := VerifyAttestation(attestedKey.Public(), []*x509.Certificate{attestationCertificate, root}, roots)
+	require.NoError(t, err)
+	require.True(t, verified.Verified)
+	require.Equal(t, "CN=Attestation", verified.Subject)
+	require.Equal(t, "CN=Attestation Root", verified.Issuer)
+	unverified, err := VerifyAttestation(attestedKey.Public(), []*x509.Certificate{attestationCertificate, root}, nil)
+	require.NoError(t, err)
+	require.False(t, unverified.Verified)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, err = VerifyAttestation(otherKey.Public(), []*x509.Certificate{attestationCertificate}, roots)
+	require.Error(t, err)
+	_, otherRoot := newTestCertificate(t, "Other Root", nil, nil, true)
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherRoot)
+	_, err = VerifyAttestation(attestedKey.Public(), []*x509.Certificate{attestationCertificate}, otherRoots)
+	require.Error(t, err)
+}
+
+func newTestCertificate(t *testing.T, cn string, key *ecdsa.PrivateKey, parent *x509.Certificate, ca bool, signer ...crypto.Signer) (*ecdsa.PrivateKey, *x509.Certificate) {
+	if key == nil {
+		generated, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		key = generated
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(now.UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(time.Hour),
+		BasicConstraintsValid: ca,
+		IsCA:                  ca,
+	}
+	if ca {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	var signingKey crypto.Signer = key
+	if parent == nil {
+		parent = template
+	} else {
+		signingKey = signer[0]
+	}
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signingKey)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	require.NoError(t, err)
+	return key, certificate
+}
@@ -0,0 +1,340 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package awspca
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/rs/zerolog"
+)
+
+// ProviderType is the type name used to define AWS Private CA providers in the provider configuration.
+const ProviderType = "aws-pca"
+
+// ProviderPrefix is prepended to the provider name to build the CA name.
+const ProviderPrefix = "AWS:"
+
+const serviceName = "acm-pca"
+const targetPrefix = "ACMPrivateCA."
+
+// Interval to poll for an issued certificate.
+var pollInterval = 2 * time.Second
+
+// Config defines the AWS Private CA to submit certificate requests to.
+//
+// Credentials not defined in the config are resolved via the AWS SDK's default credential chain (environment, shared
+// configuration and credentials files, instance roles, ...).
+type Config struct {
+	CAARN            string        `yaml:"ca_arn"`
+	TemplateARN      string        `yaml:"template_arn"`
+	SigningAlgorithm string        `yaml:"signing_algorithm"`
+	Validity         time.Duration `yaml:"validity"`
+	Region           string        `yaml:"region"`
+	Endpoint         string        `yaml:"endpoint"`
+	AccessKeyID      string        `yaml:"access_key_id"`
+	SecretAccessKey  string        `yaml:"secret_access_key"`
+	SessionToken     string        `yaml:"session_token"`
+	Profile          string        `yaml:"profile"`
+	Timeout          time.Duration `yaml:"timeout"`
+}
+
+func init() {
+	certs.RegisterProviderType(&certs.ProviderType{
+		Name: ProviderType,
+		NewConfig: func() any {
+			return &Config{
+				Validity: 90 * 24 * time.Hour,
+				Timeout:  30 * time.Second,
+			}
+		},
+		New: func(name string, config any) (certs.CertificateProvider, error) {
+			return NewProvider(name, config.(*Config))
+		},
+	})
+}
+
+type pcaProvider struct {
+	name     string
+	config   *Config
+	endpoint string
+	signer   *Signer
+	client   *http.Client
+}
+
+// Create a provider submitting certificate requests to the given AWS Private CA.
+func NewProvider(name string, config *Config) (certs.CertificateProvider, error) {
+	if config.CAARN == "" {
+		return nil, fmt.Errorf("missing CA ARN for provider '%s'", name)
+	}
+	region := config.Region
+	if region == "" {
+		// arn:aws:acm-pca:<region>:<account>:certificate-authority/<id>
+		arnParts := strings.Split(config.CAARN, ":")
+		if len(arnParts) < 6 || arnParts[3] == "" {
+			return nil, fmt.Errorf("invalid CA ARN '%s' for provider '%s'", config.CAARN, name)
+		}
+		region = arnParts[3]
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", serviceName, region)
+	}
+	signer, err := NewSigner(config, region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up provider '%s' (cause: %w)", name, err)
+	}
+	return &pcaProvider{
+		name:     name,
+		config:   config,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		signer:   signer,
+		client:   &http.Client{Timeout: config.Timeout},
+	}, nil
+}
+
+func (provider *pcaProvider) Name() string {
+	return provider.name
+}
+
+func (provider *pcaProvider) CAs() []string {
+	return []string{ProviderPrefix + provider.name}
+}
+
+func (provider *pcaProvider) NewCertificateFactory(ctx context.Context, ca string, request *certs.ProviderRequest) (certs.CertificateFactory, error) {
+	if ca != ProviderPrefix+provider.name {
+		return nil, fmt.Errorf("unrecognized AWS Private CA '%s'", ca)
+	}
+	validity := provider.config.Validity
+	if request.Params["validity"] != "" {
+		parsed, err := time.ParseDuration(request.Params["validity"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid validity '%s' (cause: %w)", request.Params["validity"], err)
+		}
+		validity = parsed
+	}
+	if validity < 24*time.Hour {
+		return nil, fmt.Errorf("invalid validity '%s' (at least one day required)", validity)
+	}
+	templateARN := provider.config.TemplateARN
+	if request.Params["template_arn"] != "" {
+		templateARN = request.Params["template_arn"]
+	}
+	logger := logging.RootLogger().With().Str("Provider", ca).Logger()
+	return &PCACertificateFactory{
+		provider:    provider,
+		name:        ca,
+		request:     request,
+		validity:    validity,
+		templateARN: templateARN,
+		logger:      &logger,
+	}, nil
+}
+
+type PCACertificateFactory struct {
+	provider    *pcaProvider
+	name        string
+	request     *certs.ProviderRequest
+	validity    time.Duration
+	templateARN string
+	chain       []*x509.Certificate
+	logger      *zerolog.Logger
+}
+
+func (factory *PCACertificateFactory) Name() string {
+	return factory.name
+}
+
+func (factory *PCACertificateFactory) Chain() []*x509.Certificate {
+	return factory.chain
+}
+
+type describeRequest struct {
+	CertificateAuthorityArn string `json:"CertificateAuthorityArn"`
+}
+
+type describeResponse struct {
+	CertificateAuthority struct {
+		CertificateAuthorityConfiguration struct {
+			SigningAlgorithm string `json:"SigningAlgorithm"`
+		} `json:"CertificateAuthorityConfiguration"`
+	} `json:"CertificateAuthority"`
+}
+
+type validity struct {
+	Type  string `json:"Type"`
+	Value int64  `json:"Value"`
+}
+
+type issueRequest struct {
+	CertificateAuthorityArn string   `json:"CertificateAuthorityArn"`
+	Csr                     []byte   `json:"Csr"`
+	SigningAlgorithm        string   `json:"SigningAlgorithm"`
+	TemplateArn             string   `json:"TemplateArn,omitempty"`
+	Validity                validity `json:"Validity"`
+	IdempotencyToken        string   `json:"IdempotencyToken"`
+}
+
+type issueResponse struct {
+	CertificateArn string `json:"CertificateArn"`
+}
+
+type getRequest struct {
+	CertificateAuthorityArn string `json:"CertificateAuthorityArn"`
+	CertificateArn          string `json:"CertificateArn"`
+}
+
+type getResponse struct {
+	Certificate      string `json:"Certificate"`
+	CertificateChain string `json:"CertificateChain"`
+}
+
+type errorResponse struct {
+	Type         string `json:"__type"`
+	Message      string `json:"message"`
+	MessageUpper string `json:"Message"`
+}
+
+func (err *errorResponse) exception() string {
+	return err.Type[strings.LastIndex(err.Type, "#")+1:]
+}
+
+func (err *errorResponse) Error() string {
+	message := err.Message
+	if message == "" {
+		message = err.MessageUpper
+	}
+	return fmt.Sprintf("%s: %s", err.exception(), message)
+}
+
+func (factory *PCACertificateFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error) {
+	key, certificateRequest, err := factory.request.NewCertificateRequest(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	caARN := factory.provider.config.CAARN
+	signingAlgorithm := factory.provider.config.SigningAlgorithm
+	if signingAlgorithm == "" {
+		describe := &describeResponse{}
+		err = factory.provider.call(ctx, "DescribeCertificateAuthority", &describeRequest{CertificateAuthorityArn: caARN}, describe)
+		if err != nil {
+			return nil, nil, err
+		}
+		signingAlgorithm = describe.CertificateAuthority.CertificateAuthorityConfiguration.SigningAlgorithm
+	}
+	idempotencyToken := make([]byte, 16)
+	_, err = rand.Read(idempotencyToken)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate idempotency token (cause: %w)", err)
+	}
+	factory.logger.Info().Msgf("Submitting certificate request '%s' to AWS Private CA '%s'...", certificateRequest.Subject, caARN)
+	issue := &issueRequest{
+		CertificateAuthorityArn: caARN,
+		Csr:                     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: certificateRequest.Raw}),
+		SigningAlgorithm:        signingAlgorithm,
+		TemplateArn:             factory.templateARN,
+		Validity:                validity{Type: "DAYS", Value: int64(factory.validity / (24 * time.Hour))},
+		IdempotencyToken:        hex.EncodeToString(idempotencyToken),
+	}
+	issued := &issueResponse{}
+	err = factory.provider.call(ctx, "IssueCertificate", issue, issued)
+	if err != nil {
+		return nil, nil, err
+	}
+	get := &getRequest{CertificateAuthorityArn: caARN, CertificateArn: issued.CertificateArn}
+	retrieved := &getResponse{}
+	deadline := time.Now().Add(factory.provider.config.Timeout)
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	for {
+		err = factory.provider.call(ctx, "GetCertificate", get, retrieved)
+		errResponse, ok := err.(*errorResponse)
+		if !ok || errResponse.exception() != "RequestInProgressException" {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, nil, fmt.Errorf("certificate '%s' not issued in time", issued.CertificateArn)
+		}
+		factory.logger.Debug().Msgf("Waiting for certificate '%s' to be issued...", issued.CertificateArn)
+		select {
+		case <-poll.C:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	certificates, err := certs.DecodeCertificates([]byte(retrieved.Certificate))
+	if err != nil || len(certificates) == 0 {
+		return nil, nil, fmt.Errorf("failed to decode issued certificate (cause: %v)", err)
+	}
+	chain, err := certs.DecodeCertificates([]byte(retrieved.CertificateChain))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode certificate chain (cause: %w)", err)
+	}
+	factory.chain = chain
+	return key, certificates[0], nil
+}
+
+func (provider *pcaProvider) call(ctx context.Context, operation string, input any, output any) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request (cause: %w)", operation, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to prepare %s request (cause: %w)", operation, err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", targetPrefix+operation)
+	err = provider.signer.Sign(ctx, req, body, serviceName, time.Now())
+	if err != nil {
+		return err
+	}
+	resp, err := provider.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send %s request (cause: %w)", operation, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		errResponse := &errorResponse{}
+		err = json.NewDecoder(resp.Body).Decode(errResponse)
+		if err == nil && errResponse.Type != "" {
+			return errResponse
+		}
+		return fmt.Errorf("%s request failed (status: %s)", operation, resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(output)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s response (cause: %w)", operation, err)
+	}
+	return nil
+}
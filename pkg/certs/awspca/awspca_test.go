@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package awspca
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/keys/registry"
+	"github.com/stretchr/testify/require"
+)
+
+const testCAARN = "arn:aws:acm-pca:eu-central-1:123456789012:certificate-authority/11111111-2222-3333-4444-555555555555"
+const testCertificateARN = testCAARN + "/certificate/1"
+
+func TestProvider(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+	pca := newTestPCA(t)
+	defer pca.Close()
+	provider, err := certs.NewProvider(ProviderType, "Test", func(config any) error {
+		pcaConfig := config.(*Config)
+		pcaConfig.CAARN = testCAARN
+		pcaConfig.Endpoint = pca.URL
+		pcaConfig.AccessKeyID = "AKIDEXAMPLE"
+		pcaConfig.SecretAccessKey = "secret"
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"AWS:Test"}, provider.CAs())
+	request := &certs.ProviderRequest{
+		Domains:    []string{"www.example.org"},
+		KeyFactory: registry.StandardKey("ECDSA P-256"),
+		Params:     map[string]string{"validity": "48h"},
+	}
+	factory, err := provider.NewCertificateFactory(context.Background(), "AWS:Test", request)
+	require.NoError(t, err)
+	key, certificate, err := factory.New(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, key)
+	require.Equal(t, "www.example.org", certificate.Subject.CommonName)
+	require.Equal(t, []*x509.Certificate{pca.ca}, factory.(certs.CertificateChainFactory).Chain())
+	require.Equal(t, []string{"DescribeCertificateAuthority", "IssueCertificate", "GetCertificate", "GetCertificate"}, pca.operations)
+	request.Params["validity"] = "1h"
+	_, err = provider.NewCertificateFactory(context.Background(), "AWS:Test", request)
+	require.Error(t, err)
+}
+
+func TestSignerCredentials(t *testing.T) {
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	err := os.WriteFile(credentialsFile, []byte("[default]\naws_access_key_id = AKID1\naws_secret_access_key = secret1\n\n[certd]\naws_access_key_id=AKID2\naws_secret_access_key=secret2\naws_session_token=token2\n"), 0600)
+	require.NoError(t, err)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+	requireSignerCredentials(t, &Config{}, "AKID1", "secret1", "")
+	requireSignerCredentials(t, &Config{Profile: "certd"}, "AKID2", "secret2", "token2")
+	requireSignerCredentials(t, &Config{AccessKeyID: "AKID3", SecretAccessKey: "secret3"}, "AKID3", "secret3", "")
+	// the profile takes precedence over the environment
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID4")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret4")
+	requireSignerCredentials(t, &Config{}, "AKID4", "secret4", "")
+	requireSignerCredentials(t, &Config{Profile: "certd"}, "AKID2", "secret2", "token2")
+	_, err = NewSigner(&Config{Profile: "unknown"}, "us-east-1")
+	require.Error(t, err)
+}
+
+func requireSignerCredentials(t *testing.T, config *Config, accessKeyID string, secretAccessKey string, sessionToken string) {
+	signer, err := NewSigner(config, "us-east-1")
+	require.NoError(t, err)
+	credentials, err := signer.credentials.Retrieve(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, accessKeyID, credentials.AccessKeyID)
+	require.Equal(t, secretAccessKey, credentials.SecretAccessKey)
+	require.Equal(t, sessionToken, credentials.SessionToken)
+}
+
+func TestSign(t *testing.T) {
+	// AWS Signature Version 4 test suite (get-vanilla)
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	require.NoError(t, err)
+	signer, err := NewSigner(&Config{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}, "us-east-1")
+	require.NoError(t, err)
+	err = signer.Sign(context.Background(), req, nil, "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31", req.Header.Get("Authorization"))
+}
+
+type testPCA struct {
+	*httptest.Server
+	ca         *x509.Certificate
+	operations []string
+}
+
+func newTestPCA(t *testing.T) *testPCA {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "AWS Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caBytes)
+	require.NoError(t, err)
+	pca := &testPCA{ca: ca}
+	var issued []byte
+	pca.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), targetPrefix)
+		pca.operations = append(pca.operations, operation)
+		var response any
+		switch operation {
+		case "DescribeCertificateAuthority":
+			describe := &describeResponse{}
+			describe.CertificateAuthority.CertificateAuthorityConfiguration.SigningAlgorithm = "SHA256WITHECDSA"
+			response = describe
+		case "IssueCertificate":
+			issue := &issueRequest{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(issue))
+			require.Equal(t, testCAARN, issue.CertificateAuthorityArn)
+			require.Equal(t, "SHA256WITHECDSA", issue.SigningAlgorithm)
+			require.Equal(t, validity{Type: "DAYS", Value: 2}, issue.Validity)
+			csrBlock, _ := pem.Decode(issue.Csr)
+			require.NotNil(t, csrBlock)
+			csr, err := x509.ParseCertificateRequest(csrBlock.Bytes)
+			require.NoError(t, err)
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(2),
+				Subject:      csr.Subject,
+				DNSNames:     csr.DNSNames,
+				NotBefore:    time.Now(),
+				NotAfter:     time.Now().Add(48 * time.Hour),
+			}
+			issued, err = x509.CreateCertificate(rand.Reader, template, ca, csr.PublicKey, caKey)
+			require.NoError(t, err)
+			response = &issueResponse{CertificateArn: testCertificateARN}
+		case "GetCertificate":
+			get := &getRequest{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(get))
+			require.Equal(t, testCertificateARN, get.CertificateArn)
+			if len(pca.operations) == 3 {
+				w.WriteHeader(http.StatusBadRequest)
+				response = &errorResponse{Type: "com.amazonaws.acmpca#RequestInProgressException", Message: "in progress"}
+			} else {
+				response = &getResponse{
+					Certificate:      string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issued})),
+					CertificateChain: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caBytes})),
+				}
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			response = &errorResponse{Type: "UnknownOperationException"}
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(response))
+	}))
+	return pca
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package awspca
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// Signer signs requests to arbitrary AWS services (e.g. Secrets Manager) using the credentials resolved for a config.
+type Signer struct {
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	region      string
+}
+
+// Create a request signer for the given region.
+//
+// Only the credential related options of the given config are evaluated.
+func NewSigner(config *Config, region string) (*Signer, error) {
+	awsConfig, err := loadAWSConfig(config, region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve AWS credentials (cause: %w)", err)
+	}
+	return &Signer{credentials: awsConfig.Credentials, signer: v4.NewSigner(), region: awsConfig.Region}, nil
+}
+
+// Sign the given request for the given service using AWS Signature Version 4.
+//
+// The credentials are retrieved (and refreshed, if they are temporary ones) on demand.
+func (signer *Signer) Sign(ctx context.Context, req *http.Request, body []byte, service string, now time.Time) error {
+	if signer.credentials == nil {
+		return fmt.Errorf("no AWS credentials available")
+	}
+	credentials, err := signer.credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve AWS credentials (cause: %w)", err)
+	}
+	payloadHash := sha256.Sum256(body)
+	err = signer.signer.SignHTTP(ctx, credentials, req, hex.EncodeToString(payloadHash[:]), service, signer.region, now)
+	if err != nil {
+		return fmt.Errorf("failed to sign %s request (cause: %w)", service, err)
+	}
+	return nil
+}
+
+// Load the AWS SDK configuration for the given config and region.
+//
+// Credentials defined in the config take precedence. Otherwise the SDK's default credential chain applies
+// (environment, shared configuration and credentials files, web identity, container and instance roles). A profile
+// defined in the config takes precedence over the environment.
+func loadAWSConfig(config *Config, region string) (aws.Config, error) {
+	options := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(region)}
+	if config.AccessKeyID != "" {
+		options = append(options, awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(config.AccessKeyID, config.SecretAccessKey, config.SessionToken)))
+	} else if config.Profile != "" {
+		options = append(options, awsconfig.WithSharedConfigProfile(config.Profile))
+	}
+	return awsconfig.LoadDefaultConfig(context.Background(), options...)
+}
@@ -18,16 +18,50 @@
 package certs
 
 import (
+	"context"
 	"crypto"
 	"crypto/x509"
+	"fmt"
 )
 
 type CertificateFactory interface {
 	Name() string
-	New() (crypto.PrivateKey, *x509.Certificate, error)
+	// Create the certificate (and its key, if generated), aborting as soon as the context is done.
+	New(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error)
 }
 
 type CertificateRequestFactory interface {
 	Name() string
-	New() (crypto.PrivateKey, *x509.CertificateRequest, error)
+	// Create the certificate request and its key, aborting as soon as the context is done.
+	New(ctx context.Context) (crypto.PrivateKey, *x509.CertificateRequest, error)
+}
+
+// KeyMismatchError reports a private key not belonging to the certificate or certificate request it is stored with.
+type KeyMismatchError struct {
+	Name string
+}
+
+func (err *KeyMismatchError) Error() string {
+	return fmt.Sprintf("key does not match the public key of store entry '%s'", err.Name)
+}
+
+// Check whether the given private key belongs to the given public key.
+func KeyMatches(key crypto.PrivateKey, publicKey crypto.PublicKey) bool {
+	signer, ok := key.(interface{ Public() crypto.PublicKey })
+	if !ok {
+		return false
+	}
+	public, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && public.Equal(publicKey)
+}
+
+// Get the crypto.Signer interface of the given private key.
+//
+// Keys held outside the store (e.g. by a HSM or KMS) are typically only available as crypto.Signer anyway.
+func KeySigner(key crypto.PrivateKey) (crypto.Signer, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T (not a signer)", key)
+	}
+	return signer, nil
 }
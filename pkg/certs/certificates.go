@@ -18,15 +18,20 @@
 package certs
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"encoding/base64"
 	"encoding/pem"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/go-ldap/ldap/v3"
 )
@@ -37,14 +42,15 @@ func ReadCertificates(filename string) ([]*x509.Certificate, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read certificates from file '%s' (cause: %w)", filename, err)
 	}
-	decoded, err := decodeCertificates(bytes)
+	decoded, err := DecodeCertificates(bytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode certificates from file '%s' (cause: %w)", filename, err)
 	}
 	return decoded, nil
 }
 
-func decodeCertificates(bytes []byte) ([]*x509.Certificate, error) {
+// Decode X.509 certificates from the given PEM or DER encoded bytes.
+func DecodeCertificates(bytes []byte) ([]*x509.Certificate, error) {
 	decoded := make([]*x509.Certificate, 0)
 	block, rest := pem.Decode(bytes)
 	for block != nil {
@@ -71,7 +77,7 @@ func FetchCertificates(url string) ([]*x509.Certificate, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch certificates from url '%s' (cause: %w)", url, err)
 	}
-	decoded, err := decodeCertificates(bytes)
+	decoded, err := DecodeCertificates(bytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode certificates from url '%s' (cause: %w)", url, err)
 	}
@@ -117,7 +123,7 @@ func verifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certifica
 	err := tls.CertificateVerificationError{}
 	err.UnverifiedCertificates = make([]*x509.Certificate, 0)
 	for _, rawCert := range rawCerts {
-		decodedCerts, _ := decodeCertificates(rawCert)
+		decodedCerts, _ := DecodeCertificates(rawCert)
 		if decodedCerts != nil {
 			err.UnverifiedCertificates = append(err.UnverifiedCertificates, decodedCerts...)
 		}
@@ -126,14 +132,57 @@ func verifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certifica
 	return &err
 }
 
+// Get the SPKI pin (base64 encoded SHA-256 hash of the subject public key info) of the given certificate.
+//
+// The pin is suitable for HPKP (pin-sha256) as well as for Android's network security config (digest SHA-256).
+func SPKIPin(certificate *x509.Certificate) string {
+	hash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
 // Parse a Distinguished Name (DN) string.
+//
+// Attributes not covered by the standard pkix.Name fields (e.g. emailAddress, title, DC) are
+// retained as ExtraNames. As pkix.Name neither retains the RDN order nor multi-valued RDNs, use
+// ParseRDNSequence and MarshalRDNSequence to issue a DN exactly as given (see x509.Certificate.RawSubject).
 func ParseDN(dn string) (*pkix.Name, error) {
+	rdns, err := ParseRDNSequence(dn)
+	if err != nil {
+		return nil, err
+	}
+	parsedDN := &pkix.Name{}
+	parsedDN.FillFromRDNSequence(&rdns)
+	for _, rdn := range rdns {
+		for _, atv := range rdn {
+			if !isStandardNameAttribute(atv.Type) {
+				parsedDN.ExtraNames = append(parsedDN.ExtraNames, atv)
+			}
+		}
+	}
+	return parsedDN, nil
+}
+
+// Parse a Distinguished Name (DN) string into its DER encoding (see MarshalRDNSequence).
+func ParseRawDN(dn string) ([]byte, error) {
+	rdns, err := ParseRDNSequence(dn)
+	if err != nil {
+		return nil, err
+	}
+	return MarshalRDNSequence(rdns)
+}
+
+// Parse a Distinguished Name (DN) string into the corresponding RDN sequence.
+//
+// Unlike ParseDN, the resulting RDN sequence retains multi-valued RDNs as well as the
+// RDN order (most significant RDN first, as in the ASN.1 encoding).
+func ParseRDNSequence(dn string) (pkix.RDNSequence, error) {
 	ldapDN, err := ldap.ParseDN(dn)
 	if err != nil {
 		return nil, fmt.Errorf("invalid DN '%s' (cause: %w)", dn, err)
 	}
-	rdns := make([]pkix.RelativeDistinguishedNameSET, 0)
-	for _, ldapRDN := range ldapDN.RDNs {
+	rdnCount := len(ldapDN.RDNs)
+	rdns := make(pkix.RDNSequence, rdnCount)
+	for i, ldapRDN := range ldapDN.RDNs {
 		rdn := make([]pkix.AttributeTypeAndValue, 0)
 		for _, ldapRDNAttribute := range ldapRDN.Attributes {
 			rdnType, err := parseLdapRDNType(ldapRDNAttribute.Type)
@@ -142,37 +191,136 @@ func ParseDN(dn string) (*pkix.Name, error) {
 			}
 			rdn = append(rdn, pkix.AttributeTypeAndValue{Type: rdnType, Value: ldapRDNAttribute.Value})
 		}
-		rdns = append(rdns, rdn)
+		// string representation lists the most significant RDN last
+		rdns[rdnCount-1-i] = rdn
 	}
-	parsedDN := &pkix.Name{}
-	parsedDN.FillFromRDNSequence((*pkix.RDNSequence)(&rdns))
-	return parsedDN, nil
+	return rdns, nil
+}
+
+// Attributes defined as IA5String (emailAddress according to PKCS #9 and domainComponent according to RFC 4519).
+var ia5StringRDNTypes = []asn1.ObjectIdentifier{
+	{1, 2, 840, 113549, 1, 9, 1},
+	{0, 9, 2342, 19200300, 100, 1, 25},
+}
+
+// Marshal a RDN sequence into its DER encoding (e.g. for use as x509.Certificate.RawSubject).
+//
+// In contrast to the encoding of a pkix.Name, the RDN order as well as multi-valued RDNs are retained. String
+// values of attributes defined as IA5String (emailAddress, DC) are encoded accordingly.
+func MarshalRDNSequence(rdns pkix.RDNSequence) ([]byte, error) {
+	encodedRDNs := make(pkix.RDNSequence, len(rdns))
+	for i, rdn := range rdns {
+		encodedRDN := make([]pkix.AttributeTypeAndValue, len(rdn))
+		for j, atv := range rdn {
+			encodedRDN[j] = atv
+			value, ok := atv.Value.(string)
+			if !ok || !isIA5StringRDNType(atv.Type) {
+				continue
+			}
+			for _, char := range value {
+				if char > unicode.MaxASCII {
+					return nil, fmt.Errorf("invalid value '%s' for RDN type %s (IA5String required)", value, atv.Type)
+				}
+			}
+			encodedRDN[j].Value = asn1.RawValue{Tag: asn1.TagIA5String, Bytes: []byte(value)}
+		}
+		encodedRDNs[i] = encodedRDN
+	}
+	encoded, err := asn1.Marshal(encodedRDNs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal RDN sequence '%s' (cause: %w)", rdns, err)
+	}
+	return encoded, nil
+}
+
+func isIA5StringRDNType(rdnType asn1.ObjectIdentifier) bool {
+	for _, ia5StringRDNType := range ia5StringRDNTypes {
+		if rdnType.Equal(ia5StringRDNType) {
+			return true
+		}
+	}
+	return false
+}
+
+var ldapRDNTypes = map[string]asn1.ObjectIdentifier{
+	"CN":                     {2, 5, 4, 3},
+	"COMMONNAME":             {2, 5, 4, 3},
+	"SURNAME":                {2, 5, 4, 4},
+	"SERIALNUMBER":           {2, 5, 4, 5},
+	"C":                      {2, 5, 4, 6},
+	"COUNTRYNAME":            {2, 5, 4, 6},
+	"L":                      {2, 5, 4, 7},
+	"LOCALITYNAME":           {2, 5, 4, 7},
+	"ST":                     {2, 5, 4, 8},
+	"S":                      {2, 5, 4, 8},
+	"STATEORPROVINCENAME":    {2, 5, 4, 8},
+	"STREET":                 {2, 5, 4, 9},
+	"STREETADDRESS":          {2, 5, 4, 9},
+	"O":                      {2, 5, 4, 10},
+	"ORGANIZATIONNAME":       {2, 5, 4, 10},
+	"OU":                     {2, 5, 4, 11},
+	"ORGANIZATIONALUNITNAME": {2, 5, 4, 11},
+	"TITLE":                  {2, 5, 4, 12},
+	"DESCRIPTION":            {2, 5, 4, 13},
+	"BUSINESSCATEGORY":       {2, 5, 4, 15},
+	"POSTALCODE":             {2, 5, 4, 17},
+	"POSTOFFICEBOX":          {2, 5, 4, 18},
+	"NAME":                   {2, 5, 4, 41},
+	"GIVENNAME":              {2, 5, 4, 42},
+	"GN":                     {2, 5, 4, 42},
+	"INITIALS":               {2, 5, 4, 43},
+	"GENERATIONQUALIFIER":    {2, 5, 4, 44},
+	"DNQUALIFIER":            {2, 5, 4, 46},
+	"PSEUDONYM":              {2, 5, 4, 65},
+	"ORGANIZATIONIDENTIFIER": {2, 5, 4, 97},
+	"UID":                    {0, 9, 2342, 19200300, 100, 1, 1},
+	"USERID":                 {0, 9, 2342, 19200300, 100, 1, 1},
+	"DC":                     {0, 9, 2342, 19200300, 100, 1, 25},
+	"DOMAINCOMPONENT":        {0, 9, 2342, 19200300, 100, 1, 25},
+	"E":                      {1, 2, 840, 113549, 1, 9, 1},
+	"EMAIL":                  {1, 2, 840, 113549, 1, 9, 1},
+	"EMAILADDRESS":           {1, 2, 840, 113549, 1, 9, 1},
 }
 
 func parseLdapRDNType(ldapRDNType string) (asn1.ObjectIdentifier, error) {
-	switch ldapRDNType {
-	case "CN":
-		return []int{2, 5, 4, 3}, nil
-	case "SERIALNUMBER":
-		return []int{2, 5, 4, 5}, nil
-	case "C":
-		return []int{2, 5, 4, 6}, nil
-	case "L":
-		return []int{2, 5, 4, 7}, nil
-	case "ST":
-		return []int{2, 5, 4, 8}, nil
-	case "STREET":
-		return []int{2, 5, 4, 9}, nil
-	case "O":
-		return []int{2, 5, 4, 10}, nil
-	case "OU":
-		return []int{2, 5, 4, 11}, nil
-	case "POSTALCODE":
-		return []int{2, 5, 4, 17}, nil
-	case "UID":
-		return []int{0, 9, 2342, 19200300, 100, 1, 1}, nil
-	case "DC":
-		return []int{0, 9, 2342, 19200300, 100, 1, 25}, nil
-	}
-	return nil, fmt.Errorf("unrecognized RDN type '%s'", ldapRDNType)
+	rdnType := ldapRDNTypes[strings.ToUpper(ldapRDNType)]
+	if rdnType != nil {
+		return rdnType, nil
+	}
+	oidType, err := ParseOID(strings.TrimPrefix(strings.ToUpper(ldapRDNType), "OID."))
+	if err != nil {
+		return nil, fmt.Errorf("unrecognized RDN type '%s'", ldapRDNType)
+	}
+	return oidType, nil
+}
+
+// Parse an OID given in dotted notation (e.g. 1.2.3.4).
+func ParseOID(oid string) (asn1.ObjectIdentifier, error) {
+	arcs := strings.Split(oid, ".")
+	if len(arcs) < 2 {
+		return nil, fmt.Errorf("invalid OID '%s'", oid)
+	}
+	parsed := make(asn1.ObjectIdentifier, len(arcs))
+	for i, arc := range arcs {
+		arcValue, err := strconv.Atoi(arc)
+		if err != nil || arcValue < 0 || (i > 0 && len(arc) > 1 && arc[0] == '0') {
+			return nil, fmt.Errorf("invalid OID '%s'", oid)
+		}
+		parsed[i] = arcValue
+	}
+	if parsed[0] > 2 || (parsed[0] < 2 && parsed[1] > 39) {
+		return nil, fmt.Errorf("invalid OID '%s'", oid)
+	}
+	return parsed, nil
+}
+
+func isStandardNameAttribute(oid asn1.ObjectIdentifier) bool {
+	if len(oid) != 4 || oid[0] != 2 || oid[1] != 5 || oid[2] != 4 {
+		return false
+	}
+	switch oid[3] {
+	case 3, 5, 6, 7, 8, 9, 10, 11, 17:
+		return true
+	}
+	return false
 }
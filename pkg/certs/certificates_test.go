@@ -19,6 +19,7 @@ package certs
 
 import (
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -38,6 +39,12 @@ func TestReadDERCertificates(t *testing.T) {
 	require.Equal(t, 1, len(certs))
 }
 
+func TestSPKIPin(t *testing.T) {
+	certs, err := ReadCertificates("./testdata/isrgrootx1.pem")
+	require.NoError(t, err)
+	require.Equal(t, "C5+lpZ7tcVwmwQIMcRtPbsQtWLABXhQzejna0wHFr8M=", SPKIPin(certs[0]))
+}
+
 func TestFetchPEMCertificates(t *testing.T) {
 	certs, err := FetchCertificates("https://letsencrypt.org/certs/isrgrootx1.pem")
 	require.NoError(t, err)
@@ -76,3 +83,55 @@ func TestParseDN(t *testing.T) {
 	require.NotNil(t, parsed)
 	require.Equal(t, dn.String(), parsed.String())
 }
+
+func TestParseExtendedDN(t *testing.T) {
+	const extendedDN = "E=webmaster@mydomain.org,TITLE=Title,CN=CommonName,OU=OrganizationUnit,DC=my\\,domain,DC=org,2.5.4.97=Identifier"
+	parsed, err := ParseDN(extendedDN)
+	require.NoError(t, err)
+	require.NotNil(t, parsed)
+	require.Equal(t, "CommonName", parsed.CommonName)
+	require.Equal(t, []string{"OrganizationUnit"}, parsed.OrganizationalUnit)
+	require.Equal(t, 5, len(parsed.ExtraNames))
+	// the raw encoding retains the RDN order and encodes emailAddress and DC as IA5String
+	rawDN, err := ParseRawDN(extendedDN)
+	require.NoError(t, err)
+	var rdns pkix.RDNSequence
+	_, err = asn1.Unmarshal(rawDN, &rdns)
+	require.NoError(t, err)
+	require.Equal(t, "1.2.840.113549.1.9.1=webmaster@mydomain.org,2.5.4.12=Title,CN=CommonName,OU=OrganizationUnit,0.9.2342.19200300.100.1.25=my\\,domain,0.9.2342.19200300.100.1.25=org,2.5.4.97=Identifier", rdns.String())
+	type rawRDNSET []struct {
+		Type  asn1.ObjectIdentifier
+		Value asn1.RawValue
+	}
+	var rawRDNs []rawRDNSET
+	_, err = asn1.Unmarshal(rawDN, &rawRDNs)
+	require.NoError(t, err)
+	require.Equal(t, asn1.TagIA5String, rawRDNs[len(rawRDNs)-1][0].Value.Tag)
+	require.Equal(t, asn1.TagIA5String, rawRDNs[1][0].Value.Tag)
+	require.Equal(t, asn1.TagPrintableString, rawRDNs[len(rawRDNs)-3][0].Value.Tag)
+	_, err = ParseRawDN("E=webm\\C3\\A4ster@mydomain.org")
+	require.Error(t, err)
+	_, err = ParseDN("UNKNOWN=Value")
+	require.Error(t, err)
+	_, err = ParseDN("1.2.a=Value")
+	require.Error(t, err)
+}
+
+func TestParseRDNSequence(t *testing.T) {
+	const multiValuedDN = "CN=CommonName+UID=uid,OU=OrganizationUnit,DC=mydomain,DC=org"
+	const normalizedMultiValuedDN = "CN=CommonName+0.9.2342.19200300.100.1.1=uid,OU=OrganizationUnit,0.9.2342.19200300.100.1.25=mydomain,0.9.2342.19200300.100.1.25=org"
+	rdns, err := ParseRDNSequence(multiValuedDN)
+	require.NoError(t, err)
+	require.Equal(t, 4, len(rdns))
+	require.Equal(t, 2, len(rdns[3]))
+	require.Equal(t, normalizedMultiValuedDN, rdns.String())
+	rdns, err = ParseRDNSequence(rdns.String())
+	require.NoError(t, err)
+	require.Equal(t, normalizedMultiValuedDN, rdns.String())
+	rawDN, err := MarshalRDNSequence(rdns)
+	require.NoError(t, err)
+	var unmarshalledRDNs pkix.RDNSequence
+	_, err = asn1.Unmarshal(rawDN, &unmarshalledRDNs)
+	require.NoError(t, err)
+	require.Equal(t, normalizedMultiValuedDN, unmarshalledRDNs.String())
+}
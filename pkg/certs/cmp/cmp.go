@@ -0,0 +1,520 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmp
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/clock"
+	"github.com/rs/zerolog"
+)
+
+// ProviderType is the type name used to define CMP providers in the provider configuration.
+const ProviderType = "cmp"
+
+// ProviderPrefix is prepended to the provider name to build the CA name.
+const ProviderPrefix = "CMP:"
+
+// ExchangeIR selects the initialization request (ir) message exchange for new certificates.
+const ExchangeIR = "ir"
+
+// ExchangeCR selects the certification request (cr) message exchange for new certificates.
+const ExchangeCR = "cr"
+
+const contentTypePKIXCMP = "application/pkixcmp"
+
+// Config defines the CMP server (RFC 6712 HTTP transfer) and the credentials used to protect the requests.
+//
+// Requests are protected via a password based MAC in case a secret is configured, or signed using the configured
+// client certificate otherwise. Key update requests (kur) are always signed using the certificate to update.
+type Config struct {
+	URL               string        `yaml:"url"`
+	Exchange          string        `yaml:"exchange"`
+	Recipient         string        `yaml:"recipient"`
+	Sender            string        `yaml:"sender"`
+	Reference         string        `yaml:"reference"`
+	Secret            string        `yaml:"secret"`
+	ClientCertificate string        `yaml:"client_certificate"`
+	ClientKey         string        `yaml:"client_key"`
+	CACertificate     string        `yaml:"ca_certificate"`
+	Timeout           time.Duration `yaml:"timeout"`
+}
+
+func init() {
+	certs.RegisterProviderType(&certs.ProviderType{
+		Name: ProviderType,
+		NewConfig: func() any {
+			return &Config{
+				Exchange: ExchangeIR,
+				Timeout:  30 * time.Second,
+			}
+		},
+		New: func(name string, config any) (certs.CertificateProvider, error) {
+			return NewProvider(name, config.(*Config))
+		},
+	})
+}
+
+type cmpProvider struct {
+	name        string
+	config      *Config
+	recipient   *pkix.Name
+	sender      *pkix.Name
+	protection  *protection
+	trustAnchor *x509.Certificate
+	client      *http.Client
+}
+
+// Create a provider requesting certificates from a CMP (RFC 4210) server.
+func NewProvider(name string, config *Config) (certs.CertificateProvider, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("missing CMP URL for provider '%s'", name)
+	}
+	if config.Exchange != ExchangeIR && config.Exchange != ExchangeCR {
+		return nil, fmt.Errorf("unrecognized CMP exchange '%s' for provider '%s'", config.Exchange, name)
+	}
+	provider := &cmpProvider{
+		name:       name,
+		config:     config,
+		recipient:  &pkix.Name{},
+		protection: &protection{},
+		client:     &http.Client{Timeout: config.Timeout},
+	}
+	var err error
+	if config.Recipient != "" {
+		provider.recipient, err = certs.ParseDN(config.Recipient)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CMP recipient for provider '%s' (cause: %w)", name, err)
+		}
+	}
+	if config.Sender != "" {
+		provider.sender, err = certs.ParseDN(config.Sender)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CMP sender for provider '%s' (cause: %w)", name, err)
+		}
+	}
+	if config.Secret != "" {
+		provider.protection.reference = []byte(config.Reference)
+		provider.protection.secret = []byte(config.Secret)
+	} else if config.ClientCertificate != "" && config.ClientKey != "" {
+		provider.protection.certificate, provider.protection.key, err = readClientCredentials(config.ClientCertificate, config.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("missing CMP secret or client certificate for provider '%s'", name)
+	}
+	if config.CACertificate != "" {
+		caCertificates, err := certs.ReadCertificates(config.CACertificate)
+		if err != nil {
+			return nil, err
+		}
+		if len(caCertificates) == 0 {
+			return nil, fmt.Errorf("missing CA certificate in file '%s'", config.CACertificate)
+		}
+		provider.trustAnchor = caCertificates[0]
+	}
+	return provider, nil
+}
+
+func (provider *cmpProvider) Name() string {
+	return provider.name
+}
+
+func (provider *cmpProvider) CAs() []string {
+	return []string{ProviderPrefix + provider.name}
+}
+
+func (provider *cmpProvider) NewCertificateFactory(ctx context.Context, ca string, request *certs.ProviderRequest) (certs.CertificateFactory, error) {
+	if ca != ProviderPrefix+provider.name {
+		return nil, fmt.Errorf("unrecognized CMP CA '%s'", ca)
+	}
+	logger := logging.RootLogger().With().Str("Provider", ca).Logger()
+	return &CMPCertificateFactory{
+		provider: provider,
+		name:     ca,
+		request:  request,
+		logger:   &logger,
+	}, nil
+}
+
+type CMPCertificateFactory struct {
+	provider *cmpProvider
+	name     string
+	request  *certs.ProviderRequest
+	chain    []*x509.Certificate
+	logger   *zerolog.Logger
+}
+
+func (factory *CMPCertificateFactory) Name() string {
+	return factory.name
+}
+
+func (factory *CMPCertificateFactory) Chain() []*x509.Certificate {
+	return factory.chain
+}
+
+func (factory *CMPCertificateFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error) {
+	key, certificateRequest, err := factory.request.NewCertificateRequest(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	bodyType := bodyIR
+	if factory.provider.config.Exchange == ExchangeCR {
+		bodyType = bodyCR
+	}
+	requestProtection := factory.provider.protection
+	sender := factory.provider.sender
+	var controls []attributeTypeAndValue
+	if factory.request.Certificate != nil {
+		bodyType = bodyKUR
+		oldSigner, ok := factory.request.Key.(crypto.Signer)
+		if !ok {
+			return nil, nil, fmt.Errorf("missing key of certificate to update")
+		}
+		requestProtection = &protection{certificate: factory.request.Certificate, key: oldSigner}
+		controls, err = oldCertIDControl(factory.request.Certificate)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if requestProtection.certificate != nil {
+		sender = &requestProtection.certificate.Subject
+	} else if sender == nil {
+		sender = &certificateRequest.Subject
+	}
+	reqMsg, err := newCertReqMsg(certificateRequest, signer, controls)
+	if err != nil {
+		return nil, nil, err
+	}
+	reqMsgsBytes, err := asn1.Marshal([]certReqMsg{*reqMsg})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal certificate request message (cause: %w)", err)
+	}
+	factory.logger.Info().Msgf("Requesting certificate '%s' via CMP (body: %d)...", certificateRequest.Subject, bodyType)
+	transaction, err := factory.provider.newTransaction(sender, requestProtection)
+	if err != nil {
+		return nil, nil, err
+	}
+	response, err := transaction.exchange(ctx, contextValue(bodyType, reqMsgsBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+	certificate, chain, err := factory.decodeCertRep(response, bodyType+1, certificateRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+	confirmBytes, err := asn1.Marshal([]certStatus{{CertHash: certificateHash(certificate), CertReqID: 0}})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal certificate confirmation (cause: %w)", err)
+	}
+	confirmation, err := transaction.exchange(ctx, contextValue(bodyCertConf, confirmBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+	if confirmation.Body.Tag != bodyPKIConf {
+		return nil, nil, fmt.Errorf("unexpected CMP confirmation response (body: %d)", confirmation.Body.Tag)
+	}
+	factory.chain = chain
+	return key, certificate, nil
+}
+
+func (factory *CMPCertificateFactory) decodeCertRep(response *pkiMessage, expectedBodyType int, request *x509.CertificateRequest) (*x509.Certificate, []*x509.Certificate, error) {
+	if response.Body.Tag != expectedBodyType {
+		return nil, nil, fmt.Errorf("unexpected CMP response (body: %d)", response.Body.Tag)
+	}
+	certRep := &certRepMessage{}
+	_, err := asn1.Unmarshal(response.Body.Bytes, certRep)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode CMP response (cause: %w)", err)
+	}
+	if len(certRep.Response) != 1 {
+		return nil, nil, fmt.Errorf("unexpected number of certificate responses (%d)", len(certRep.Response))
+	}
+	certResponse := &certRep.Response[0]
+	if certResponse.Status.Status != statusAccepted && certResponse.Status.Status != statusGrantedWithMods {
+		return nil, nil, fmt.Errorf("certificate request rejected by CMP server (%s)", certResponse.Status.String())
+	}
+	certOrEncCert := certResponse.CertifiedKeyPair.CertOrEncCert
+	if certOrEncCert.Class != asn1.ClassContextSpecific || certOrEncCert.Tag != 0 {
+		return nil, nil, fmt.Errorf("unsupported CMP certificate response (encrypted certificates are not supported)")
+	}
+	certificate, err := x509.ParseCertificate(certOrEncCert.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse issued certificate (cause: %w)", err)
+	}
+	if !publicKeyEqual(certificate.PublicKey, request.PublicKey) {
+		return nil, nil, fmt.Errorf("issued certificate does not match requested key")
+	}
+	candidates := make([]*x509.Certificate, 0)
+	for _, raw := range append(certRep.CAPubs, response.ExtraCerts...) {
+		candidate, err := x509.ParseCertificate(raw.FullBytes)
+		if err == nil {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return certificate, issuerChain(certificate, candidates), nil
+}
+
+type transaction struct {
+	provider   *cmpProvider
+	sender     asn1.RawValue
+	recipient  asn1.RawValue
+	protection *protection
+	id         []byte
+	recipNonce []byte
+}
+
+func (provider *cmpProvider) newTransaction(sender *pkix.Name, protection *protection) (*transaction, error) {
+	senderName, err := directoryName(sender)
+	if err != nil {
+		return nil, err
+	}
+	recipientName, err := directoryName(provider.recipient)
+	if err != nil {
+		return nil, err
+	}
+	id, err := randomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{
+		provider:   provider,
+		sender:     senderName,
+		recipient:  recipientName,
+		protection: protection,
+		id:         id,
+	}, nil
+}
+
+// Send a request message within the transaction and receive and verify the corresponding response message.
+func (transaction *transaction) exchange(ctx context.Context, body asn1.RawValue) (*pkiMessage, error) {
+	senderNonce, err := randomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	request := &pkiMessage{
+		Header: pkiHeader{
+			PVNO:          pvnoCMP2000,
+			Sender:        transaction.sender,
+			Recipient:     transaction.recipient,
+			MessageTime:   clock.FromContext(ctx).Now().UTC().Truncate(time.Second),
+			TransactionID: transaction.id,
+			SenderNonce:   senderNonce,
+			RecipNonce:    transaction.recipNonce,
+		},
+		Body: body,
+	}
+	err = transaction.protection.prepare(&request.Header)
+	if err != nil {
+		return nil, err
+	}
+	err = transaction.protection.protect(request)
+	if err != nil {
+		return nil, err
+	}
+	requestBytes, err := asn1.Marshal(*request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal CMP request (cause: %w)", err)
+	}
+	responseBytes, err := transaction.provider.post(ctx, requestBytes)
+	if err != nil {
+		return nil, err
+	}
+	response := &pkiMessage{}
+	_, err = asn1.Unmarshal(responseBytes, response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode CMP response (cause: %w)", err)
+	}
+	if response.Body.Tag == bodyError {
+		errorMsg := &errorMsgContent{}
+		_, err = asn1.Unmarshal(response.Body.Bytes, errorMsg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode CMP error response (cause: %w)", err)
+		}
+		return nil, fmt.Errorf("CMP server reported an error (%s)", errorMsg.PKIStatusInfo.String())
+	}
+	err = transaction.protection.verify(response, transaction.provider.trustAnchor)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(response.Header.TransactionID, transaction.id) || !bytes.Equal(response.Header.RecipNonce, senderNonce) {
+		return nil, fmt.Errorf("CMP response does not match request")
+	}
+	transaction.recipNonce = response.Header.SenderNonce
+	return response, nil
+}
+
+func (provider *cmpProvider) post(ctx context.Context, requestBytes []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.config.URL, bytes.NewReader(requestBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare CMP request '%s' (cause: %w)", provider.config.URL, err)
+	}
+	req.Header.Set("Content-Type", contentTypePKIXCMP)
+	resp, err := provider.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send CMP request '%s' (cause: %w)", provider.config.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != contentTypePKIXCMP {
+		return nil, fmt.Errorf("CMP request '%s' failed (status: %s)", provider.config.URL, resp.Status)
+	}
+	responseBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CMP response '%s' (cause: %w)", provider.config.URL, err)
+	}
+	return responseBytes, nil
+}
+
+func newCertReqMsg(request *x509.CertificateRequest, signer crypto.Signer, controls []attributeTypeAndValue) (*certReqMsg, error) {
+	publicKeyBytes, err := innerBytes(request.RawSubjectPublicKeyInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key (cause: %w)", err)
+	}
+	template := certTemplate{
+		Subject:   contextValue(5, request.RawSubject),
+		PublicKey: contextValue(6, publicKeyBytes),
+	}
+	if len(request.Extensions) > 0 {
+		extensionsBytes, err := asn1.Marshal(request.Extensions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal extensions (cause: %w)", err)
+		}
+		extensionsBytes, err = innerBytes(extensionsBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal extensions (cause: %w)", err)
+		}
+		template.Extensions = contextValue(9, extensionsBytes)
+	}
+	certReq := certRequest{CertReqID: 0, CertTemplate: template, Controls: controls}
+	certReqBytes, err := asn1.Marshal(certReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal certificate request (cause: %w)", err)
+	}
+	algorithm, hash, err := signatureAlgorithm(signer)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := sign(signer, hash, certReqBytes)
+	if err != nil {
+		return nil, err
+	}
+	popoBytes, err := asn1.Marshal(poposigningKey{AlgorithmIdentifier: algorithm, Signature: asn1.BitString{Bytes: signature, BitLength: len(signature) * 8}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal proof of possession (cause: %w)", err)
+	}
+	popoBytes, err = innerBytes(popoBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal proof of possession (cause: %w)", err)
+	}
+	return &certReqMsg{CertReq: certReq, POPO: contextValue(1, popoBytes)}, nil
+}
+
+func oldCertIDControl(certificate *x509.Certificate) ([]attributeTypeAndValue, error) {
+	issuer, err := directoryName(&certificate.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	serialBytes, err := asn1.Marshal(certificate.SerialNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal serial number (cause: %w)", err)
+	}
+	oldCertIDBytes, err := asn1.Marshal(certID{Issuer: issuer, SerialNumber: asn1.RawValue{FullBytes: serialBytes}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal old certificate id (cause: %w)", err)
+	}
+	return []attributeTypeAndValue{{Type: oidRegCtrlOldCertID, Value: asn1.RawValue{FullBytes: oldCertIDBytes}}}, nil
+}
+
+// Build the issuer chain of the given certificate from the given candidate certificates.
+func issuerChain(certificate *x509.Certificate, candidates []*x509.Certificate) []*x509.Certificate {
+	chain := make([]*x509.Certificate, 0)
+	current := certificate
+	for len(chain) < 10 && !certs.IsIssuedBy(current, current) {
+		var issuer *x509.Certificate
+		for _, candidate := range candidates {
+			if certs.IsIssuedBy(current, candidate) && current.CheckSignatureFrom(candidate) == nil {
+				issuer = candidate
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		chain = append(chain, issuer)
+		current = issuer
+	}
+	return chain
+}
+
+func readClientCredentials(certificateFile string, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	certificates, err := certs.ReadCertificates(certificateFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(certificates) == 0 {
+		return nil, nil, fmt.Errorf("missing client certificate in file '%s'", certificateFile)
+	}
+	keyBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read client key file '%s' (cause: %w)", keyFile, err)
+	}
+	keyBlock, _ := pem.Decode(keyBytes)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("failed to decode client key file '%s'", keyFile)
+	}
+	var key any
+	switch keyBlock.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(keyBlock.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse client key file '%s' (cause: %w)", keyFile, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok || !publicKeyEqual(signer.Public(), certificates[0].PublicKey) {
+		return nil, nil, fmt.Errorf("client key file '%s' does not match client certificate", keyFile)
+	}
+	return certificates[0], signer, nil
+}
+
+func publicKeyEqual(key1 crypto.PublicKey, key2 crypto.PublicKey) bool {
+	equalKey, ok := key1.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && equalKey.Equal(key2)
+}
@@ -0,0 +1,236 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmp
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/keys/registry"
+	"github.com/stretchr/testify/require"
+)
+
+const testReference = "reference"
+const testSecret = "secret"
+
+func TestInitializationRequest(t *testing.T) {
+	server := newTestCMPServer(t)
+	defer server.Close()
+	provider, err := certs.NewProvider(ProviderType, "Test", func(config any) error {
+		cmpConfig := config.(*Config)
+		cmpConfig.URL = server.URL
+		cmpConfig.Recipient = "CN=CMP Test CA"
+		cmpConfig.Reference = testReference
+		cmpConfig.Secret = testSecret
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"CMP:Test"}, provider.CAs())
+	request := &certs.ProviderRequest{
+		Domains:    []string{"www.example.org"},
+		KeyFactory: registry.StandardKey("ECDSA P-256"),
+	}
+	factory, err := provider.NewCertificateFactory(context.Background(), "CMP:Test", request)
+	require.NoError(t, err)
+	key, certificate, err := factory.New(context.Background())
+	require.NoError(t, err)
+	require.True(t, publicKeyEqual(key.(crypto.Signer).Public(), certificate.PublicKey))
+	require.Equal(t, "www.example.org", certificate.Subject.CommonName)
+	require.Equal(t, []string{"www.example.org"}, certificate.DNSNames)
+	require.Equal(t, []*x509.Certificate{server.ca}, factory.(certs.CertificateChainFactory).Chain())
+	require.Equal(t, []int{bodyIR, bodyCertConf}, server.received)
+	invalidProvider, err := NewProvider("Invalid", &Config{URL: server.URL, Exchange: ExchangeIR, Reference: testReference, Secret: "invalid"})
+	require.NoError(t, err)
+	factory, err = invalidProvider.NewCertificateFactory(context.Background(), "CMP:Invalid", request)
+	require.NoError(t, err)
+	_, _, err = factory.New(context.Background())
+	require.ErrorContains(t, err, "bad message check")
+}
+
+func TestKeyUpdateRequest(t *testing.T) {
+	server := newTestCMPServer(t)
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.ca.Raw}), 0600)
+	require.NoError(t, err)
+	provider, err := NewProvider("Test", &Config{URL: server.URL, Exchange: ExchangeCR, Reference: testReference, Secret: testSecret, CACertificate: caFile})
+	require.NoError(t, err)
+	request := &certs.ProviderRequest{
+		Domains:    []string{"www.example.org"},
+		KeyFactory: registry.StandardKey("ECDSA P-384"),
+	}
+	factory, err := provider.NewCertificateFactory(context.Background(), "CMP:Test", request)
+	require.NoError(t, err)
+	key, certificate, err := factory.New(context.Background())
+	require.NoError(t, err)
+	request.Certificate = certificate
+	request.Key = key
+	factory, err = provider.NewCertificateFactory(context.Background(), "CMP:Test", request)
+	require.NoError(t, err)
+	updatedKey, updatedCertificate, err := factory.New(context.Background())
+	require.NoError(t, err)
+	require.True(t, publicKeyEqual(updatedKey.(crypto.Signer).Public(), updatedCertificate.PublicKey))
+	require.NotEqual(t, certificate.SerialNumber, updatedCertificate.SerialNumber)
+	require.Equal(t, []int{bodyCR, bodyCertConf, bodyKUR, bodyCertConf}, server.received)
+}
+
+func TestNewProviderInvalidConfig(t *testing.T) {
+	_, err := NewProvider("Test", &Config{Exchange: ExchangeIR, Secret: testSecret})
+	require.Error(t, err)
+	_, err = NewProvider("Test", &Config{URL: "http://localhost/cmp", Exchange: "p10cr", Secret: testSecret})
+	require.Error(t, err)
+	_, err = NewProvider("Test", &Config{URL: "http://localhost/cmp", Exchange: ExchangeIR})
+	require.Error(t, err)
+}
+
+type testCMPServer struct {
+	*httptest.Server
+	ca       *x509.Certificate
+	caKey    crypto.Signer
+	received []int
+}
+
+func newTestCMPServer(t *testing.T) *testCMPServer {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CMP Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		SubjectKeyId:          []byte{1, 2, 3, 4},
+	}
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caBytes)
+	require.NoError(t, err)
+	server := &testCMPServer{ca: ca, caKey: caKey}
+	var issued *x509.Certificate
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestBytes, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		request := &pkiMessage{}
+		_, err = asn1.Unmarshal(requestBytes, request)
+		require.NoError(t, err)
+		server.received = append(server.received, request.Body.Tag)
+		responseProtection := &protection{reference: []byte(testReference), secret: []byte(testSecret)}
+		if request.Header.ProtectionAlg.Algorithm.Equal(oidPasswordBasedMac) {
+			err = responseProtection.verify(request, nil)
+		} else {
+			responseProtection = &protection{certificate: ca, key: caKey}
+			err = responseProtection.verify(request, ca)
+		}
+		var body asn1.RawValue
+		if err != nil {
+			errorBytes, err := asn1.Marshal(errorMsgContent{PKIStatusInfo: pkiStatusInfo{Status: statusRejection, StatusString: []string{"bad message check"}}})
+			require.NoError(t, err)
+			body = contextValue(bodyError, errorBytes)
+		} else {
+			switch request.Body.Tag {
+			case bodyIR, bodyCR, bodyKUR:
+				reqMsgs := make([]certReqMsg, 0)
+				_, err = asn1.Unmarshal(request.Body.Bytes, &reqMsgs)
+				require.NoError(t, err)
+				require.Equal(t, 1, len(reqMsgs))
+				issued = server.issue(t, &reqMsgs[0])
+				repBytes, err := asn1.Marshal(certRepMessage{
+					CAPubs: []asn1.RawValue{{FullBytes: ca.Raw}},
+					Response: []certResponse{{
+						Status:           pkiStatusInfo{Status: statusAccepted},
+						CertifiedKeyPair: certifiedKeyPair{CertOrEncCert: contextValue(0, issued.Raw)},
+					}},
+				})
+				require.NoError(t, err)
+				body = contextValue(request.Body.Tag+1, repBytes)
+			case bodyCertConf:
+				confirmations := make([]certStatus, 0)
+				_, err = asn1.Unmarshal(request.Body.Bytes, &confirmations)
+				require.NoError(t, err)
+				require.Equal(t, certificateHash(issued), confirmations[0].CertHash)
+				body = contextValue(bodyPKIConf, asn1.NullBytes)
+			default:
+				require.Fail(t, "unexpected request body")
+			}
+		}
+		senderNonce, err := randomBytes(16)
+		require.NoError(t, err)
+		response := &pkiMessage{
+			Header: pkiHeader{
+				PVNO:          pvnoCMP2000,
+				Sender:        request.Header.Recipient,
+				Recipient:     request.Header.Sender,
+				TransactionID: request.Header.TransactionID,
+				SenderNonce:   senderNonce,
+				RecipNonce:    request.Header.SenderNonce,
+			},
+			Body: body,
+		}
+		require.NoError(t, responseProtection.prepare(&response.Header))
+		require.NoError(t, responseProtection.protect(response))
+		responseBytes, err := asn1.Marshal(*response)
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", contentTypePKIXCMP)
+		_, _ = io.Copy(w, bytes.NewReader(responseBytes))
+	}))
+	return server
+}
+
+func (server *testCMPServer) issue(t *testing.T, reqMsg *certReqMsg) *x509.Certificate {
+	publicKeyBytes, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: reqMsg.CertReq.CertTemplate.PublicKey.Bytes})
+	require.NoError(t, err)
+	publicKey, err := x509.ParsePKIXPublicKey(publicKeyBytes)
+	require.NoError(t, err)
+	var subject pkix.RDNSequence
+	_, err = asn1.Unmarshal(reqMsg.CertReq.CertTemplate.Subject.Bytes, &subject)
+	require.NoError(t, err)
+	var extensions []pkix.Extension
+	_, err = asn1.Unmarshal(append([]byte{0x30}, reqMsg.CertReq.CertTemplate.Extensions.FullBytes[1:]...), &extensions)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(time.Now().UnixNano()),
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: extensions,
+	}
+	template.Subject.FillFromRDNSequence(&subject)
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, server.ca, publicKey, server.caKey)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	require.NoError(t, err)
+	return certificate
+}
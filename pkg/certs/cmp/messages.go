@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmp
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PKIBody choices (RFC 4210 section 5.1.2)
+const (
+	bodyIR       = 0
+	bodyIP       = 1
+	bodyCR       = 2
+	bodyCP       = 3
+	bodyKUR      = 7
+	bodyKUP      = 8
+	bodyPKIConf  = 19
+	bodyError    = 23
+	bodyCertConf = 24
+)
+
+// PKIStatus values (RFC 4210 section 5.2.3)
+const (
+	statusAccepted        = 0
+	statusGrantedWithMods = 1
+	statusRejection       = 2
+)
+
+const pvnoCMP2000 = 2
+
+var oidPasswordBasedMac = asn1.ObjectIdentifier{1, 2, 840, 113533, 7, 66, 13}
+var oidRegCtrlOldCertID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 5, 1, 5}
+
+type pkiMessage struct {
+	Header     pkiHeader
+	Body       asn1.RawValue
+	Protection asn1.BitString  `asn1:"optional,explicit,tag:0"`
+	ExtraCerts []asn1.RawValue `asn1:"optional,explicit,tag:1"`
+}
+
+type pkiHeader struct {
+	PVNO          int
+	Sender        asn1.RawValue
+	Recipient     asn1.RawValue
+	MessageTime   time.Time                `asn1:"optional,explicit,tag:0,generalized"`
+	ProtectionAlg pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	SenderKID     []byte                   `asn1:"optional,explicit,tag:2"`
+	RecipKID      []byte                   `asn1:"optional,explicit,tag:3"`
+	TransactionID []byte                   `asn1:"optional,explicit,tag:4"`
+	SenderNonce   []byte                   `asn1:"optional,explicit,tag:5"`
+	RecipNonce    []byte                   `asn1:"optional,explicit,tag:6"`
+	FreeText      []string                 `asn1:"optional,explicit,tag:7"`
+	GeneralInfo   []asn1.RawValue          `asn1:"optional,explicit,tag:8"`
+}
+
+// The part of a PKIMessage covered by the protection.
+type protectedPart struct {
+	Header pkiHeader
+	Body   asn1.RawValue
+}
+
+type pbmParameter struct {
+	Salt           []byte
+	OWF            pkix.AlgorithmIdentifier
+	IterationCount int
+	MAC            pkix.AlgorithmIdentifier
+}
+
+type certReqMsg struct {
+	CertReq certRequest
+	POPO    asn1.RawValue `asn1:"optional"`
+}
+
+type certRequest struct {
+	CertReqID    int
+	CertTemplate certTemplate
+	Controls     []attributeTypeAndValue `asn1:"optional"`
+}
+
+// The certificate template fields are implicitly tagged (RFC 4211), hence they are encoded as raw values.
+type certTemplate struct {
+	Subject    asn1.RawValue `asn1:"optional,explicit,tag:5"`
+	PublicKey  asn1.RawValue `asn1:"optional,tag:6"`
+	Extensions asn1.RawValue `asn1:"optional,tag:9"`
+}
+
+type attributeTypeAndValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type certID struct {
+	Issuer       asn1.RawValue
+	SerialNumber asn1.RawValue
+}
+
+type poposigningKey struct {
+	AlgorithmIdentifier pkix.AlgorithmIdentifier
+	Signature           asn1.BitString
+}
+
+type certRepMessage struct {
+	CAPubs   []asn1.RawValue `asn1:"optional,explicit,tag:1"`
+	Response []certResponse
+}
+
+type certResponse struct {
+	CertReqID        int
+	Status           pkiStatusInfo
+	CertifiedKeyPair certifiedKeyPair `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+func (info *pkiStatusInfo) String() string {
+	description := fmt.Sprintf("status: %d", info.Status)
+	if len(info.StatusString) > 0 {
+		description += "; " + strings.Join(info.StatusString, "; ")
+	}
+	return description
+}
+
+type certifiedKeyPair struct {
+	CertOrEncCert asn1.RawValue
+}
+
+type certStatus struct {
+	CertHash  []byte
+	CertReqID int
+}
+
+type errorMsgContent struct {
+	PKIStatusInfo pkiStatusInfo
+	ErrorCode     int      `asn1:"optional"`
+	ErrorDetails  []string `asn1:"optional"`
+}
+
+// Encode a context specific, constructed element (as used for explicitly tagged values and CHOICE alternatives).
+func contextValue(tag int, content []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: content}
+}
+
+// Encode a GeneralName of type directoryName.
+func directoryName(name *pkix.Name) (asn1.RawValue, error) {
+	nameBytes, err := asn1.Marshal(name.ToRDNSequence())
+	if err != nil {
+		return asn1.RawValue{}, fmt.Errorf("failed to marshal name '%s' (cause: %w)", name, err)
+	}
+	return contextValue(4, nameBytes), nil
+}
+
+// Strip the outer tag and length of the given DER encoded element.
+func innerBytes(der []byte) ([]byte, error) {
+	var raw asn1.RawValue
+	_, err := asn1.Unmarshal(der, &raw)
+	if err != nil {
+		return nil, err
+	}
+	return raw.Bytes, nil
+}
@@ -0,0 +1,282 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/hdecarne-github/certd/pkg/entropy"
+)
+
+const pbmIterationCount = 500
+
+var oidSHA1 = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+var oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+var oidHMACSHA1 = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 8, 1, 2}
+var oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+
+var signatureAlgorithms = []struct {
+	oid       asn1.ObjectIdentifier
+	algorithm x509.SignatureAlgorithm
+	hash      crypto.Hash
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, x509.ECDSAWithSHA256, crypto.SHA256},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, x509.ECDSAWithSHA384, crypto.SHA384},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, x509.ECDSAWithSHA512, crypto.SHA512},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, x509.SHA256WithRSA, crypto.SHA256},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, x509.SHA384WithRSA, crypto.SHA384},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, x509.SHA512WithRSA, crypto.SHA512},
+	{asn1.ObjectIdentifier{1, 3, 101, 112}, x509.PureEd25519, crypto.Hash(0)},
+}
+
+// protection protects outgoing messages either via a password based MAC (shared secret) or a signature.
+type protection struct {
+	reference   []byte
+	secret      []byte
+	certificate *x509.Certificate
+	key         crypto.Signer
+}
+
+func (p *protection) prepare(header *pkiHeader) error {
+	if p.key != nil {
+		algorithm, _, err := signatureAlgorithm(p.key)
+		if err != nil {
+			return err
+		}
+		header.ProtectionAlg = algorithm
+		header.SenderKID = p.certificate.SubjectKeyId
+		return nil
+	}
+	salt, err := randomBytes(16)
+	if err != nil {
+		return err
+	}
+	parameterBytes, err := asn1.Marshal(pbmParameter{
+		Salt:           salt,
+		OWF:            pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+		IterationCount: pbmIterationCount,
+		MAC:            pkix.AlgorithmIdentifier{Algorithm: oidHMACSHA256},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal PBM parameters (cause: %w)", err)
+	}
+	header.ProtectionAlg = pkix.AlgorithmIdentifier{Algorithm: oidPasswordBasedMac, Parameters: asn1.RawValue{FullBytes: parameterBytes}}
+	header.SenderKID = p.reference
+	return nil
+}
+
+func (p *protection) protect(message *pkiMessage) error {
+	protectedBytes, err := asn1.Marshal(protectedPart{Header: message.Header, Body: message.Body})
+	if err != nil {
+		return fmt.Errorf("failed to marshal protected part (cause: %w)", err)
+	}
+	var protectionBytes []byte
+	if p.key != nil {
+		_, hash, err := signatureAlgorithm(p.key)
+		if err != nil {
+			return err
+		}
+		protectionBytes, err = sign(p.key, hash, protectedBytes)
+		if err != nil {
+			return err
+		}
+		message.ExtraCerts = []asn1.RawValue{{FullBytes: p.certificate.Raw}}
+	} else {
+		protectionBytes, err = pbmMAC(&message.Header.ProtectionAlg, p.secret, protectedBytes)
+		if err != nil {
+			return err
+		}
+	}
+	message.Protection = asn1.BitString{Bytes: protectionBytes, BitLength: len(protectionBytes) * 8}
+	return nil
+}
+
+// Verify the protection of a received message.
+//
+// MAC based protection is verified using the shared secret. Signature based protection is verified using the
+// signer certificate contained in the message's extra certificates, which must be issued by (or be identical to)
+// the given trust anchor.
+func (p *protection) verify(message *pkiMessage, trustAnchor *x509.Certificate) error {
+	if len(message.Protection.Bytes) == 0 {
+		return fmt.Errorf("unprotected response message")
+	}
+	protectedBytes, err := asn1.Marshal(protectedPart{Header: message.Header, Body: message.Body})
+	if err != nil {
+		return fmt.Errorf("failed to marshal protected part (cause: %w)", err)
+	}
+	if message.Header.ProtectionAlg.Algorithm.Equal(oidPasswordBasedMac) {
+		if p.secret == nil {
+			return fmt.Errorf("unexpected MAC protected response message")
+		}
+		mac, err := pbmMAC(&message.Header.ProtectionAlg, p.secret, protectedBytes)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal(mac, message.Protection.Bytes) {
+			return fmt.Errorf("invalid response message protection")
+		}
+		return nil
+	}
+	if trustAnchor == nil {
+		return fmt.Errorf("missing CA certificate to verify signature protected response message")
+	}
+	algorithm := x509.UnknownSignatureAlgorithm
+	for _, candidate := range signatureAlgorithms {
+		if candidate.oid.Equal(message.Header.ProtectionAlg.Algorithm) {
+			algorithm = candidate.algorithm
+		}
+	}
+	if algorithm == x509.UnknownSignatureAlgorithm {
+		return fmt.Errorf("unsupported response protection algorithm '%s'", message.Header.ProtectionAlg.Algorithm)
+	}
+	for _, extraCert := range message.ExtraCerts {
+		signer, err := x509.ParseCertificate(extraCert.FullBytes)
+		if err != nil {
+			continue
+		}
+		if signer.CheckSignature(algorithm, protectedBytes, message.Protection.Bytes) != nil {
+			continue
+		}
+		if signer.Equal(trustAnchor) || signer.CheckSignatureFrom(trustAnchor) == nil {
+			return nil
+		}
+		return fmt.Errorf("untrusted response message signer '%s'", signer.Subject)
+	}
+	return fmt.Errorf("invalid response message protection")
+}
+
+func pbmMAC(algorithm *pkix.AlgorithmIdentifier, secret []byte, data []byte) ([]byte, error) {
+	parameter := &pbmParameter{}
+	_, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, parameter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode PBM parameters (cause: %w)", err)
+	}
+	var owf func() hash.Hash
+	switch {
+	case parameter.OWF.Algorithm.Equal(oidSHA256):
+		owf = sha256.New
+	case parameter.OWF.Algorithm.Equal(oidSHA1):
+		owf = sha1.New
+	default:
+		return nil, fmt.Errorf("unsupported PBM one-way function '%s'", parameter.OWF.Algorithm)
+	}
+	var mac func() hash.Hash
+	switch {
+	case parameter.MAC.Algorithm.Equal(oidHMACSHA256):
+		mac = sha256.New
+	case parameter.MAC.Algorithm.Equal(oidHMACSHA1):
+		mac = sha1.New
+	default:
+		return nil, fmt.Errorf("unsupported PBM MAC algorithm '%s'", parameter.MAC.Algorithm)
+	}
+	if parameter.IterationCount < 1 || parameter.IterationCount > 100000 {
+		return nil, fmt.Errorf("invalid PBM iteration count %d", parameter.IterationCount)
+	}
+	key := append(append([]byte{}, secret...), parameter.Salt...)
+	for i := 0; i < parameter.IterationCount; i++ {
+		digest := owf()
+		digest.Write(key)
+		key = digest.Sum(nil)
+	}
+	authenticator := hmac.New(mac, key)
+	authenticator.Write(data)
+	return authenticator.Sum(nil), nil
+}
+
+func signatureAlgorithm(key crypto.Signer) (pkix.AlgorithmIdentifier, crypto.Hash, error) {
+	var algorithm x509.SignatureAlgorithm
+	switch publicKey := key.Public().(type) {
+	case *ecdsa.PublicKey:
+		switch publicKey.Curve {
+		case elliptic.P384():
+			algorithm = x509.ECDSAWithSHA384
+		case elliptic.P521():
+			algorithm = x509.ECDSAWithSHA512
+		default:
+			algorithm = x509.ECDSAWithSHA256
+		}
+	case *rsa.PublicKey:
+		algorithm = x509.SHA256WithRSA
+	case ed25519.PublicKey:
+		algorithm = x509.PureEd25519
+	default:
+		return pkix.AlgorithmIdentifier{}, 0, fmt.Errorf("unsupported key type %T", publicKey)
+	}
+	for _, candidate := range signatureAlgorithms {
+		if candidate.algorithm == algorithm {
+			identifier := pkix.AlgorithmIdentifier{Algorithm: candidate.oid}
+			if _, ok := key.Public().(*rsa.PublicKey); ok {
+				identifier.Parameters = asn1.NullRawValue
+			}
+			return identifier, candidate.hash, nil
+		}
+	}
+	return pkix.AlgorithmIdentifier{}, 0, fmt.Errorf("unsupported signature algorithm %s", algorithm)
+}
+
+func sign(key crypto.Signer, hash crypto.Hash, data []byte) ([]byte, error) {
+	digest := data
+	if hash != 0 {
+		digester := hash.New()
+		digester.Write(data)
+		digest = digester.Sum(nil)
+	}
+	signature, err := key.Sign(entropy.Reader(), digest, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign message (cause: %w)", err)
+	}
+	return signature, nil
+}
+
+// Compute the certificate hash for the certificate confirmation (using the hash of the certificate's signature).
+func certificateHash(certificate *x509.Certificate) []byte {
+	var digest hash.Hash
+	switch certificate.SignatureAlgorithm {
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384, x509.SHA384WithRSAPSS:
+		digest = sha512.New384()
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512, x509.SHA512WithRSAPSS, x509.PureEd25519:
+		digest = sha512.New()
+	default:
+		digest = sha256.New()
+	}
+	digest.Write(certificate.Raw)
+	return digest.Sum(nil)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	random := make([]byte, n)
+	_, err := io.ReadFull(entropy.Reader(), random)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate random bytes (cause: %w)", err)
+	}
+	return random, nil
+}
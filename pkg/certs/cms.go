@@ -0,0 +1,551 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+var oidContentTypeData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+var oidContentTypeSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+var oidAttributeContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+var oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+var oidAttributeSigningTime = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+var oidAttributeTimeStampToken = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+
+var oidDigestAlgorithms = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA256: {2, 16, 840, 1, 101, 3, 4, 2, 1},
+	crypto.SHA384: {2, 16, 840, 1, 101, 3, 4, 2, 2},
+	crypto.SHA512: {2, 16, 840, 1, 101, 3, 4, 2, 3},
+}
+
+var oidSignatureRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+var oidSignatureEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+var oidSignatureRSAAlgorithms = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA256: {1, 2, 840, 113549, 1, 1, 11},
+	crypto.SHA384: {1, 2, 840, 113549, 1, 1, 12},
+	crypto.SHA512: {1, 2, 840, 113549, 1, 1, 13},
+}
+
+var oidSignatureECDSAAlgorithms = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA256: {1, 2, 840, 10045, 4, 3, 2},
+	crypto.SHA384: {1, 2, 840, 10045, 4, 3, 3},
+	crypto.SHA512: {1, 2, 840, 10045, 4, 3, 4},
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// SignedData represents a decoded CMS/PKCS#7 signed data structure.
+type SignedData struct {
+	// The encapsulated content type
+	ContentType asn1.ObjectIdentifier
+	// The encapsulated content (nil for detached signatures and certificate bundles)
+	Content []byte
+	// The embedded certificates
+	Certificates []*x509.Certificate
+	// The embedded revocation lists
+	RevocationLists []*x509.RevocationList
+	signed          *signedData
+}
+
+// Check whether the signed data contains any signatures (certificate bundles do not).
+func (data *SignedData) Signed() bool {
+	return len(data.signed.SignerInfos) > 0
+}
+
+// Verify the signature of the signed data's encapsulated content.
+//
+// Only the signature itself is verified (not the trust state of the signer's certificate). On success
+// the signer's certificate is returned.
+func (data *SignedData) Verify() (*x509.Certificate, error) {
+	if data.Content == nil {
+		return nil, errors.New("missing encapsulated content")
+	}
+	hash, err := data.signed.digestHash()
+	if err != nil {
+		return nil, err
+	}
+	hasher := hash.New()
+	hasher.Write(data.Content)
+	return data.signed.verify(hasher.Sum(nil))
+}
+
+// Verify the signature of the signed data for the given message digest (detached signature).
+//
+// Only the signature itself is verified (not the trust state of the signer's certificate). On success
+// the signer's certificate is returned.
+func (data *SignedData) VerifyDigest(digest []byte) (*x509.Certificate, error) {
+	return data.signed.verify(digest)
+}
+
+// Decode a CMS/PKCS#7 signed data structure from the given PEM or DER encoded bytes.
+func DecodeSignedData(encoded []byte) (*SignedData, error) {
+	block, _ := pem.Decode(encoded)
+	if block != nil {
+		encoded = block.Bytes
+	}
+	signed, err := parseSignedData(encoded)
+	if err != nil {
+		return nil, err
+	}
+	certificates, err := signed.certificates()
+	if err != nil {
+		return nil, err
+	}
+	revocationLists, err := signed.revocationLists()
+	if err != nil {
+		return nil, err
+	}
+	data := &SignedData{
+		ContentType:     signed.EncapContentInfo.EContentType,
+		Content:         signed.EncapContentInfo.EContent,
+		Certificates:    certificates,
+		RevocationLists: revocationLists,
+		signed:          signed,
+	}
+	return data, nil
+}
+
+// Encode the given certificates and revocation lists as a certificates-only CMS/PKCS#7 structure (p7b).
+func EncodeCertificatesPKCS7(certificates []*x509.Certificate, revocationLists []*x509.RevocationList) ([]byte, error) {
+	certificatesBytes := make([]byte, 0)
+	for _, certificate := range certificates {
+		certificatesBytes = append(certificatesBytes, certificate.Raw...)
+	}
+	revocationListsBytes := make([]byte, 0)
+	for _, revocationList := range revocationLists {
+		revocationListsBytes = append(revocationListsBytes, revocationList.Raw...)
+	}
+	signed := &signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{},
+		EncapContentInfo: encapsulatedContentInfo{EContentType: oidContentTypeData},
+		SignerInfos:      []signerInfo{},
+	}
+	if len(certificatesBytes) > 0 {
+		signed.Certificates = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certificatesBytes}
+	}
+	if len(revocationListsBytes) > 0 {
+		signed.CRLs = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: revocationListsBytes}
+	}
+	return marshalSignedData(signed)
+}
+
+// Decode the certificates contained in a CMS/PKCS#7 structure (p7b) from the given PEM or DER encoded bytes.
+func DecodeCertificatesPKCS7(encoded []byte) ([]*x509.Certificate, error) {
+	data, err := DecodeSignedData(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return data.Certificates, nil
+}
+
+// Create a CMS signature encapsulating the given content.
+//
+// The signer's certificate as well as the given chain certificates are embedded into the signature.
+func SignData(content []byte, hash crypto.Hash, certificate *x509.Certificate, chain []*x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	_, supported := oidDigestAlgorithms[hash]
+	if !supported {
+		return nil, fmt.Errorf("unsupported digest algorithm %s", hash)
+	}
+	hasher := hash.New()
+	hasher.Write(content)
+	signed, err := newSignedData(oidContentTypeData, content, hasher.Sum(nil), hash, certificate, append([]*x509.Certificate{certificate}, chain...), key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSignedData(signed)
+}
+
+// Timestamper is used to retrieve a RFC 3161 timestamp token for a CMS signature value.
+type Timestamper func(signature []byte, hash crypto.Hash) ([]byte, error)
+
+// Create a detached CMS signature (signed data without encapsulated content) for the given message digest.
+//
+// The signer's certificate as well as the given chain certificates are embedded into the signature.
+// If a timestamper is given, the signature is timestamped by adding the retrieved timestamp token
+// as an unsigned attribute.
+func SignDigest(digest []byte, hash crypto.Hash, certificate *x509.Certificate, chain []*x509.Certificate, key crypto.PrivateKey, timestamper Timestamper) ([]byte, error) {
+	if len(digest) != hash.Size() {
+		return nil, fmt.Errorf("invalid %s digest length %d", hash, len(digest))
+	}
+	signed, err := newSignedData(oidContentTypeData, nil, digest, hash, certificate, append([]*x509.Certificate{certificate}, chain...), key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if timestamper != nil {
+		token, err := timestamper(signed.SignerInfos[0].Signature, hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to timestamp signature (cause: %w)", err)
+		}
+		unsignedAttrs, err := marshalAttributes(map[string]asn1.RawValue{oidAttributeTimeStampToken.String(): {FullBytes: token}})
+		if err != nil {
+			return nil, err
+		}
+		signed.SignerInfos[0].UnsignedAttrs = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: unsignedAttrs}
+	}
+	return marshalSignedData(signed)
+}
+
+// Verify a detached CMS signature for the given message digest.
+//
+// Only the signature itself is verified (not the trust state of the signer's certificate). On success
+// the signer's certificate is returned.
+func VerifyDigest(signature []byte, digest []byte) (*x509.Certificate, error) {
+	data, err := DecodeSignedData(signature)
+	if err != nil {
+		return nil, err
+	}
+	return data.VerifyDigest(digest)
+}
+
+func newSignedData(contentType asn1.ObjectIdentifier, content []byte, digest []byte, hash crypto.Hash, certificate *x509.Certificate, certificates []*x509.Certificate, key crypto.PrivateKey, extraAttrs map[string]asn1.RawValue) (*signedData, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	digestAlgorithm, signatureAlgorithm, err := cmsAlgorithms(signer.Public(), hash)
+	if err != nil {
+		return nil, err
+	}
+	contentTypeValue, err := asn1.Marshal(contentType)
+	if err != nil {
+		return nil, err
+	}
+	messageDigestValue, err := asn1.Marshal(digest)
+	if err != nil {
+		return nil, err
+	}
+	signingTimeValue, err := asn1.Marshal(time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	signedAttrsMap := map[string]asn1.RawValue{
+		oidAttributeContentType.String():   {FullBytes: contentTypeValue},
+		oidAttributeMessageDigest.String(): {FullBytes: messageDigestValue},
+		oidAttributeSigningTime.String():   {FullBytes: signingTimeValue},
+	}
+	for extraAttrType, extraAttrValue := range extraAttrs {
+		signedAttrsMap[extraAttrType] = extraAttrValue
+	}
+	signedAttrs, err := marshalAttributes(signedAttrsMap)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signAttributes(signer, hash, signedAttrs)
+	if err != nil {
+		return nil, err
+	}
+	certificatesBytes := make([]byte, 0)
+	for _, embeddedCertificate := range certificates {
+		certificatesBytes = append(certificatesBytes, embeddedCertificate.Raw...)
+	}
+	signed := &signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlgorithm},
+		EncapContentInfo: encapsulatedContentInfo{
+			EContentType: contentType,
+			EContent:     content,
+		},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			SID: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: certificate.RawIssuer},
+				SerialNumber: certificate.SerialNumber,
+			},
+			DigestAlgorithm:    digestAlgorithm,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedAttrs},
+			SignatureAlgorithm: signatureAlgorithm,
+			Signature:          signature,
+		}},
+	}
+	if len(certificatesBytes) > 0 {
+		signed.Certificates = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certificatesBytes}
+	}
+	return signed, nil
+}
+
+func cmsAlgorithms(publicKey crypto.PublicKey, hash crypto.Hash) (pkix.AlgorithmIdentifier, pkix.AlgorithmIdentifier, error) {
+	oidDigestAlgorithm, ok := oidDigestAlgorithms[hash]
+	if !ok {
+		return pkix.AlgorithmIdentifier{}, pkix.AlgorithmIdentifier{}, fmt.Errorf("unsupported digest algorithm %s", hash)
+	}
+	digestAlgorithm := pkix.AlgorithmIdentifier{Algorithm: oidDigestAlgorithm}
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		return digestAlgorithm, pkix.AlgorithmIdentifier{Algorithm: oidSignatureRSAAlgorithms[hash], Parameters: asn1.NullRawValue}, nil
+	case *ecdsa.PublicKey:
+		return digestAlgorithm, pkix.AlgorithmIdentifier{Algorithm: oidSignatureECDSAAlgorithms[hash]}, nil
+	case ed25519.PublicKey:
+		// RFC 8419 requires SHA-512 as digest algorithm for Ed25519 signed attributes
+		if hash != crypto.SHA512 {
+			return pkix.AlgorithmIdentifier{}, pkix.AlgorithmIdentifier{}, fmt.Errorf("unsupported digest algorithm %s for Ed25519 signature", hash)
+		}
+		return digestAlgorithm, pkix.AlgorithmIdentifier{Algorithm: oidSignatureEd25519}, nil
+	}
+	return pkix.AlgorithmIdentifier{}, pkix.AlgorithmIdentifier{}, fmt.Errorf("unsupported key type %T", publicKey)
+}
+
+func marshalAttributes(attrs map[string]asn1.RawValue) ([]byte, error) {
+	encodedAttrs := make([][]byte, 0, len(attrs))
+	for attrType, attrValue := range attrs {
+		oid, err := ParseOID(attrType)
+		if err != nil {
+			return nil, err
+		}
+		encodedAttr, err := asn1.Marshal(attribute{Type: oid, Values: []asn1.RawValue{attrValue}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode attribute '%s' (cause: %w)", attrType, err)
+		}
+		encodedAttrs = append(encodedAttrs, encodedAttr)
+	}
+	// DER requires SET OF elements to be sorted by their encoding
+	sort.Slice(encodedAttrs, func(i, j int) bool { return bytes.Compare(encodedAttrs[i], encodedAttrs[j]) < 0 })
+	return bytes.Join(encodedAttrs, nil), nil
+}
+
+func signAttributes(signer crypto.Signer, hash crypto.Hash, signedAttrs []byte) ([]byte, error) {
+	// The signature is calculated over the attributes' SET OF encoding (not the implicitly tagged one)
+	encoded, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: signedAttrs})
+	if err != nil {
+		return nil, err
+	}
+	var signature []byte
+	_, ed25519Key := signer.Public().(ed25519.PublicKey)
+	if ed25519Key {
+		signature, err = signer.Sign(rand.Reader, encoded, crypto.Hash(0))
+	} else {
+		hasher := hash.New()
+		hasher.Write(encoded)
+		signature, err = signer.Sign(rand.Reader, hasher.Sum(nil), hash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign attributes (cause: %w)", err)
+	}
+	return signature, nil
+}
+
+func marshalSignedData(signed *signedData) ([]byte, error) {
+	signedBytes, err := asn1.Marshal(*signed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signed data (cause: %w)", err)
+	}
+	encoded, err := asn1.Marshal(contentInfo{
+		ContentType: oidContentTypeSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedBytes},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode content info (cause: %w)", err)
+	}
+	return encoded, nil
+}
+
+func parseSignedData(encoded []byte) (*signedData, error) {
+	info := &contentInfo{}
+	rest, err := asn1.Unmarshal(encoded, info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode content info (cause: %w)", err)
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data after content info")
+	}
+	if !info.ContentType.Equal(oidContentTypeSignedData) {
+		return nil, fmt.Errorf("unexpected content type %s", info.ContentType)
+	}
+	signed := &signedData{}
+	_, err = asn1.Unmarshal(info.Content.Bytes, signed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signed data (cause: %w)", err)
+	}
+	return signed, nil
+}
+
+func (signed *signedData) certificates() ([]*x509.Certificate, error) {
+	if len(signed.Certificates.Bytes) == 0 {
+		return []*x509.Certificate{}, nil
+	}
+	certificates, err := x509.ParseCertificates(signed.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode embedded certificates (cause: %w)", err)
+	}
+	return certificates, nil
+}
+
+func (signed *signedData) revocationLists() ([]*x509.RevocationList, error) {
+	revocationLists := make([]*x509.RevocationList, 0)
+	rest := signed.CRLs.Bytes
+	for len(rest) > 0 {
+		var revocationListBytes asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &revocationListBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode embedded revocation lists (cause: %w)", err)
+		}
+		revocationList, err := x509.ParseRevocationList(revocationListBytes.FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode embedded revocation list (cause: %w)", err)
+		}
+		revocationLists = append(revocationLists, revocationList)
+	}
+	return revocationLists, nil
+}
+
+func (signed *signedData) digestHash() (crypto.Hash, error) {
+	if len(signed.SignerInfos) != 1 {
+		return 0, fmt.Errorf("unexpected number of signer infos %d", len(signed.SignerInfos))
+	}
+	digestAlgorithm := signed.SignerInfos[0].DigestAlgorithm.Algorithm
+	for hash, oid := range oidDigestAlgorithms {
+		if oid.Equal(digestAlgorithm) {
+			return hash, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported digest algorithm %s", digestAlgorithm)
+}
+
+func (signed *signedData) verify(digest []byte) (*x509.Certificate, error) {
+	if len(signed.SignerInfos) != 1 {
+		return nil, fmt.Errorf("unexpected number of signer infos %d", len(signed.SignerInfos))
+	}
+	signer := &signed.SignerInfos[0]
+	certificates, err := signed.certificates()
+	if err != nil {
+		return nil, err
+	}
+	var certificate *x509.Certificate
+	for _, candidate := range certificates {
+		if EqualRawDN(candidate.RawIssuer, signer.SID.Issuer.FullBytes) && candidate.SerialNumber.Cmp(signer.SID.SerialNumber) == 0 {
+			certificate = candidate
+			break
+		}
+	}
+	if certificate == nil {
+		return nil, errors.New("signer certificate not found")
+	}
+	attrs := make([]attribute, 0)
+	rest, err := asn1.UnmarshalWithParams(signer.SignedAttrs.FullBytes, &attrs, "set,tag:0")
+	if err != nil || len(rest) > 0 {
+		return nil, errors.New("failed to decode signed attributes")
+	}
+	var messageDigest []byte
+	for _, attr := range attrs {
+		if attr.Type.Equal(oidAttributeMessageDigest) && len(attr.Values) == 1 {
+			_, err = asn1.Unmarshal(attr.Values[0].FullBytes, &messageDigest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode message digest (cause: %w)", err)
+			}
+		}
+	}
+	if !bytes.Equal(messageDigest, digest) {
+		return nil, errors.New("message digest mismatch")
+	}
+	signatureAlgorithm, err := x509SignatureAlgorithm(signer.DigestAlgorithm.Algorithm, signer.SignatureAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: signer.SignedAttrs.Bytes})
+	if err != nil {
+		return nil, err
+	}
+	err = certificate.CheckSignature(signatureAlgorithm, encoded, signer.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature (cause: %w)", err)
+	}
+	return certificate, nil
+}
+
+var x509SignatureAlgorithms = map[crypto.Hash][2]x509.SignatureAlgorithm{
+	crypto.SHA256: {x509.SHA256WithRSA, x509.ECDSAWithSHA256},
+	crypto.SHA384: {x509.SHA384WithRSA, x509.ECDSAWithSHA384},
+	crypto.SHA512: {x509.SHA512WithRSA, x509.ECDSAWithSHA512},
+}
+
+func x509SignatureAlgorithm(digestAlgorithm asn1.ObjectIdentifier, signatureAlgorithm asn1.ObjectIdentifier) (x509.SignatureAlgorithm, error) {
+	if signatureAlgorithm.Equal(oidSignatureEd25519) {
+		return x509.PureEd25519, nil
+	}
+	var hash crypto.Hash
+	for candidate, oid := range oidDigestAlgorithms {
+		if oid.Equal(digestAlgorithm) {
+			hash = candidate
+		}
+	}
+	candidates, ok := x509SignatureAlgorithms[hash]
+	if ok {
+		if signatureAlgorithm.Equal(oidSignatureRSA) || signatureAlgorithm.Equal(oidSignatureRSAAlgorithms[hash]) {
+			return candidates[0], nil
+		}
+		if signatureAlgorithm.Equal(oidSignatureECDSAAlgorithms[hash]) {
+			return candidates[1], nil
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported signature algorithm %s (digest algorithm %s)", signatureAlgorithm, digestAlgorithm)
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignDigest(t *testing.T) {
+	issuerKey, issuer := newTestCertificate(t, "Issuer", nil, nil, true)
+	key, certificate := newTestCertificate(t, "Signer", nil, issuer, false, issuerKey)
+	digest := sha256.Sum256([]byte("test"))
+	signature, err := SignDigest(digest[:], crypto.SHA256, certificate, nil, key, nil)
+	require.NoError(t, err)
+	signer, err := VerifyDigest(signature, digest[:])
+	require.NoError(t, err)
+	require.Equal(t, certificate.Raw, signer.Raw)
+	otherDigest := sha256.Sum256([]byte("other"))
+	_, err = VerifyDigest(signature, otherDigest[:])
+	require.Error(t, err)
+	_, err = SignDigest(digest[:], crypto.SHA512, certificate, nil, key, nil)
+	require.Error(t, err)
+}
+
+func TestSignDigestEd25519(t *testing.T) {
+	issuerKey, issuer := newTestCertificate(t, "Issuer", nil, nil, true)
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	digest := sha512.Sum512([]byte("test"))
+	_, certificate := newTestCertificate(t, "Signer", nil, issuer, false, issuerKey)
+	// the certificate's key does not matter for signing; verification must fail
+	signature, err := SignDigest(digest[:], crypto.SHA512, certificate, nil, key, nil)
+	require.NoError(t, err)
+	_, err = VerifyDigest(signature, digest[:])
+	require.Error(t, err)
+	sha256Digest := sha256.Sum256([]byte("test"))
+	_, err = SignDigest(sha256Digest[:], crypto.SHA256, certificate, nil, key, nil)
+	require.Error(t, err)
+}
+
+func TestSignDigestTimestamp(t *testing.T) {
+	tsa := newTestTSA(t)
+	defer tsa.Close()
+	key, certificate := newTestCertificate(t, "Signer", nil, nil, false)
+	digest := sha256.Sum256([]byte("test"))
+	signature, err := SignDigest(digest[:], crypto.SHA256, certificate, nil, key, NewTimestamper(tsa.URL))
+	require.NoError(t, err)
+	signed, err := parseSignedData(signature)
+	require.NoError(t, err)
+	require.NotEmpty(t, signed.SignerInfos[0].UnsignedAttrs.Bytes)
+	_, err = VerifyDigest(signature, digest[:])
+	require.NoError(t, err)
+}
+
+func TestSignData(t *testing.T) {
+	key, certificate := newTestCertificate(t, "Signer", nil, nil, false)
+	signature, err := SignData([]byte("test"), crypto.SHA256, certificate, nil, key)
+	require.NoError(t, err)
+	data, err := DecodeSignedData(signature)
+	require.NoError(t, err)
+	require.True(t, data.Signed())
+	require.Equal(t, []byte("test"), data.Content)
+	signer, err := data.Verify()
+	require.NoError(t, err)
+	require.Equal(t, certificate.Raw, signer.Raw)
+	data.Content = []byte("other")
+	_, err = data.Verify()
+	require.Error(t, err)
+}
+
+func TestEncodeCertificatesPKCS7(t *testing.T) {
+	issuerKey, issuer := newTestCertificate(t, "Issuer", nil, nil, true)
+	issuer.KeyUsage |= x509.KeyUsageCRLSign
+	_, certificate := newTestCertificate(t, "Certificate", nil, issuer, false, issuerKey)
+	revocationListBytes, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{Number: big.NewInt(1), ThisUpdate: time.Now(), NextUpdate: time.Now().Add(time.Hour)}, issuer, issuerKey)
+	require.NoError(t, err)
+	revocationList, err := x509.ParseRevocationList(revocationListBytes)
+	require.NoError(t, err)
+	encoded, err := EncodeCertificatesPKCS7([]*x509.Certificate{certificate, issuer}, []*x509.RevocationList{revocationList})
+	require.NoError(t, err)
+	data, err := DecodeSignedData(encoded)
+	require.NoError(t, err)
+	require.False(t, data.Signed())
+	require.Equal(t, 2, len(data.Certificates))
+	require.Equal(t, certificate.Raw, data.Certificates[0].Raw)
+	require.Equal(t, issuer.Raw, data.Certificates[1].Raw)
+	require.Equal(t, 1, len(data.RevocationLists))
+	require.Equal(t, revocationList.Raw, data.RevocationLists[0].Raw)
+}
+
+func TestDecodeCertificatesPKCS7(t *testing.T) {
+	for _, file := range []string{"./testdata/lets-encrypt-r3.p7b", "./testdata/lets-encrypt-r3.p7b.pem"} {
+		encoded, err := os.ReadFile(file)
+		require.NoError(t, err)
+		certificates, err := DecodeCertificatesPKCS7(encoded)
+		require.NoError(t, err)
+		require.Equal(t, 2, len(certificates))
+	}
+	_, err := DecodeCertificatesPKCS7([]byte("test"))
+	require.Error(t, err)
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/hdecarne-github/certd/pkg/asn1"
+	"github.com/hdecarne-github/certd/pkg/asn1/oids"
+	x509ext "github.com/hdecarne-github/certd/pkg/certs/extensions"
+)
+
+// Difference of a single certificate field or extension.
+//
+// Old (New) is empty, if the field or extension is only present in the new (old) certificate.
+type CertificateDiff struct {
+	Field string
+	Old   string
+	New   string
+}
+
+type diffField struct {
+	name  string
+	value string
+}
+
+// Compare the fields and extensions of the given certificates.
+//
+// Extensions are compared by their decoded value if known or their ASN.1 structure otherwise.
+func DiffCertificates(oldCertificate *x509.Certificate, newCertificate *x509.Certificate) []CertificateDiff {
+	oldFields := diffFields(oldCertificate)
+	newFields := diffFields(newCertificate)
+	newValues := make(map[string]string, len(newFields))
+	for _, field := range newFields {
+		newValues[field.name] = field.value
+	}
+	diffs := make([]CertificateDiff, 0)
+	oldNames := make(map[string]bool, len(oldFields))
+	for _, field := range oldFields {
+		oldNames[field.name] = true
+		newValue := newValues[field.name]
+		if field.value != newValue {
+			diffs = append(diffs, CertificateDiff{Field: field.name, Old: field.value, New: newValue})
+		}
+	}
+	for _, field := range newFields {
+		if !oldNames[field.name] {
+			diffs = append(diffs, CertificateDiff{Field: field.name, New: field.value})
+		}
+	}
+	return diffs
+}
+
+// Write the given certificate differences in a diff like text representation.
+func WriteCertificateDiff(out io.Writer, diffs []CertificateDiff) error {
+	text := &textWriter{}
+	for _, diff := range diffs {
+		text.line(0, "%s:", diff.Field)
+		for _, line := range diffLines(diff.Old) {
+			text.line(4, "- %s", line)
+		}
+		for _, line := range diffLines(diff.New) {
+			text.line(4, "+ %s", line)
+		}
+	}
+	_, err := io.WriteString(out, text.String())
+	return err
+}
+
+func diffLines(value string) []string {
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(value, "\n"), "\n")
+}
+
+// Difference of a high-level certificate profile field.
+type ProfileDiff struct {
+	Field string
+	Left  string
+	Right string
+}
+
+const ProfileFieldSubject = "subject"
+const ProfileFieldSANs = "sans"
+const ProfileFieldExtKeyUsage = "ext_key_usage"
+const ProfileFieldKeyType = "key_type"
+const ProfileFieldPolicies = "policies"
+const ProfileFieldValidity = "validity"
+
+// Compare the high-level profile fields (subject, SANs, extended key usages, key type, policies and validity
+// period) of the given certificates.
+//
+// In contrast to DiffCertificates, values expected to change during a renewal (e.g. serial number or
+// validity dates) are ignored and multi-valued fields are compared regardless of their order.
+func CompareProfiles(left *x509.Certificate, right *x509.Certificate) []ProfileDiff {
+	leftFields := profileFields(left)
+	rightFields := profileFields(right)
+	diffs := make([]ProfileDiff, 0)
+	for i, field := range leftFields {
+		if field.value != rightFields[i].value {
+			diffs = append(diffs, ProfileDiff{Field: field.name, Left: field.value, Right: rightFields[i].value})
+		}
+	}
+	return diffs
+}
+
+func profileFields(certificate *x509.Certificate) []diffField {
+	sans := make([]string, 0)
+	for _, dnsName := range certificate.DNSNames {
+		sans = append(sans, "DNS:"+dnsName)
+	}
+	for _, ipAddress := range certificate.IPAddresses {
+		sans = append(sans, "IP:"+ipAddress.String())
+	}
+	for _, emailAddress := range certificate.EmailAddresses {
+		sans = append(sans, "email:"+emailAddress)
+	}
+	for _, uri := range certificate.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+	sort.Strings(sans)
+	policies := make([]string, 0, len(certificate.PolicyIdentifiers))
+	for _, policy := range certificate.PolicyIdentifiers {
+		policies = append(policies, policy.String())
+	}
+	sort.Strings(policies)
+	return []diffField{
+		{ProfileFieldSubject, textName(certificate.RawSubject)},
+		{ProfileFieldSANs, strings.Join(sans, ", ")},
+		{ProfileFieldExtKeyUsage, x509ext.ExtKeyUsageString(certificate.ExtKeyUsage, certificate.UnknownExtKeyUsage)},
+		{ProfileFieldKeyType, diffKeyType(certificate.PublicKey)},
+		{ProfileFieldPolicies, strings.Join(policies, ", ")},
+		{ProfileFieldValidity, certificate.NotAfter.Sub(certificate.NotBefore).String()},
+	}
+}
+
+func diffKeyType(publicKey any) string {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + key.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "ED25519"
+	}
+	return fmt.Sprintf("%T", publicKey)
+}
+
+func diffFields(certificate *x509.Certificate) []diffField {
+	publicKeyFingerprint := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	fields := []diffField{
+		{"Version", fmt.Sprintf("%d", certificate.Version)},
+		{"Serial Number", fmt.Sprintf("0x%x", certificate.SerialNumber)},
+		{"Signature Algorithm", textSignatureAlgorithm(certificate.SignatureAlgorithm)},
+		{"Issuer", textName(certificate.RawIssuer)},
+		{"Not Before", certificate.NotBefore.UTC().Format(textTimeLayout)},
+		{"Not After", certificate.NotAfter.UTC().Format(textTimeLayout)},
+		{"Validity Period", certificate.NotAfter.Sub(certificate.NotBefore).String()},
+		{"Subject", textName(certificate.RawSubject)},
+		{"Public Key", fmt.Sprintf("%s (SHA256: %x)", diffKeyType(certificate.PublicKey), publicKeyFingerprint)},
+	}
+	for _, extension := range certificate.Extensions {
+		name, value := x509ext.Decode(certificate, extension)
+		if name == extension.Id.String() {
+			oidName := oids.Describe(name)
+			if oidName != "" {
+				name = oidName + " (" + name + ")"
+			}
+		}
+		if value == "" {
+			var dump bytes.Buffer
+			err := asn1.DecodeASN1(&dump, extension.Value)
+			if err != nil {
+				value = fmt.Sprintf("%x", extension.Value)
+			} else {
+				value = dump.String()
+			}
+		}
+		if extension.Critical {
+			value = "critical\n" + value
+		}
+		fields = append(fields, diffField{"Extension " + name, value})
+	}
+	return fields
+}
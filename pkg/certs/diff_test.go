@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffCertificates(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, oldCertificate := newTestCertificate(t, "Test", key, nil, false)
+	_, newCertificate := newTestCertificate(t, "Test", key, nil, true)
+	require.Empty(t, DiffCertificates(oldCertificate, oldCertificate))
+	diffs := DiffCertificates(oldCertificate, newCertificate)
+	fields := make(map[string]CertificateDiff, len(diffs))
+	for _, diff := range diffs {
+		fields[diff.Field] = diff
+	}
+	require.Contains(t, fields, "Serial Number")
+	require.NotContains(t, fields, "Subject")
+	require.NotContains(t, fields, "Public Key")
+	require.Contains(t, fields, "Extension BasicConstraints")
+	require.Empty(t, fields["Extension BasicConstraints"].Old)
+	require.Contains(t, fields["Extension BasicConstraints"].New, "critical")
+	var text strings.Builder
+	err = WriteCertificateDiff(&text, diffs)
+	require.NoError(t, err)
+	require.Contains(t, text.String(), "Extension BasicConstraints:\n    + critical\n")
+}
+
+func TestCompareProfiles(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, left := newTestCertificate(t, "Test", key, nil, false)
+	_, right := newTestCertificate(t, "Test", nil, nil, false)
+	require.Empty(t, CompareProfiles(left, right))
+	_, other := newTestCertificate(t, "Other", nil, nil, false)
+	diffs := CompareProfiles(left, other)
+	require.Equal(t, 1, len(diffs))
+	require.Equal(t, ProfileFieldSubject, diffs[0].Field)
+	require.Equal(t, "CN = Test", diffs[0].Left)
+	require.Equal(t, "CN = Other", diffs[0].Right)
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	openpgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+const FormatAge = "age"
+const FormatOpenPGP = "openpgp"
+
+// Export a private key (PKCS#8 PEM encoded) encrypted for the given age recipient.
+//
+// The recipient may be either a native age (X25519) recipient or a SSH public key.
+// The result is ASCII armored.
+func KeyAge(key crypto.PrivateKey, recipient string) ([]byte, error) {
+	ageRecipient, err := parseAgeRecipient(recipient)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var exported bytes.Buffer
+	armorWriter := armor.NewWriter(&exported)
+	err = encryptAge(armorWriter, keyBytes, ageRecipient)
+	if err != nil {
+		return nil, err
+	}
+	err = armorWriter.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to armor encrypted key (cause: %w)", err)
+	}
+	return exported.Bytes(), nil
+}
+
+func parseAgeRecipient(recipient string) (age.Recipient, error) {
+	trimmedRecipient := strings.TrimSpace(recipient)
+	if strings.HasPrefix(trimmedRecipient, "age1") {
+		ageRecipient, err := age.ParseX25519Recipient(trimmedRecipient)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient (cause: %w)", err)
+		}
+		return ageRecipient, nil
+	}
+	ageRecipient, err := agessh.ParseRecipient(trimmedRecipient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH recipient (cause: %w)", err)
+	}
+	return ageRecipient, nil
+}
+
+func encryptAge(out io.Writer, data []byte, recipient age.Recipient) error {
+	encryptWriter, err := age.Encrypt(out, recipient)
+	if err != nil {
+		return fmt.Errorf("failed to setup age encryption (cause: %w)", err)
+	}
+	_, err = encryptWriter.Write(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt key (cause: %w)", err)
+	}
+	err = encryptWriter.Close()
+	if err != nil {
+		return fmt.Errorf("failed to encrypt key (cause: %w)", err)
+	}
+	return nil
+}
+
+// Export a private key (PKCS#8 PEM encoded) encrypted for the given OpenPGP recipient.
+//
+// The recipient must be an ASCII armored OpenPGP public key (block). The key is only encrypted for the public keys
+// selected by the given (hex encoded) fingerprints. If no fingerprint is given, the recipient must contain exactly
+// one public key. The result is ASCII armored.
+func KeyOpenPGP(key crypto.PrivateKey, recipient string, fingerprints ...string) ([]byte, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(recipient))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenPGP recipient (cause: %w)", err)
+	}
+	selected, err := selectOpenPGPRecipients(entities, fingerprints)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var exported bytes.Buffer
+	armorWriter, err := openpgparmor.Encode(&exported, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup OpenPGP armor (cause: %w)", err)
+	}
+	encryptWriter, err := openpgp.Encrypt(armorWriter, selected, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup OpenPGP encryption (cause: %w)", err)
+	}
+	_, err = encryptWriter.Write(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt key (cause: %w)", err)
+	}
+	err = encryptWriter.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt key (cause: %w)", err)
+	}
+	err = armorWriter.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to armor encrypted key (cause: %w)", err)
+	}
+	return exported.Bytes(), nil
+}
+
+func selectOpenPGPRecipients(entities openpgp.EntityList, fingerprints []string) ([]*openpgp.Entity, error) {
+	if len(fingerprints) == 0 {
+		if len(entities) != 1 {
+			return nil, fmt.Errorf("invalid OpenPGP recipient (cause: %d public keys found; select the recipient keys by fingerprint)", len(entities))
+		}
+		return entities, nil
+	}
+	selected := make([]*openpgp.Entity, 0, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		normalized := strings.ToUpper(strings.ReplaceAll(fingerprint, " ", ""))
+		var match *openpgp.Entity
+		for _, entity := range entities {
+			if fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint) == normalized {
+				match = entity
+				break
+			}
+		}
+		if match == nil {
+			return nil, fmt.Errorf("invalid OpenPGP recipient (cause: no public key with fingerprint '%s')", fingerprint)
+		}
+		selected = append(selected, match)
+	}
+	return selected, nil
+}
+
+func encodeKey(key crypto.PrivateKey) ([]byte, error) {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key (cause: %w)", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}), nil
+}
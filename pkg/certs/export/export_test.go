@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	openpgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyAge(t *testing.T) {
+	keyPair, err := ecdsa.NewECDSAKeyPair(context.Background(), elliptic.P256())
+	require.NoError(t, err)
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	exported, err := KeyAge(keyPair.Private(), identity.Recipient().String())
+	require.NoError(t, err)
+	decrypted, err := age.Decrypt(armor.NewReader(bytes.NewReader(exported)), identity)
+	require.NoError(t, err)
+	keyBytes, err := io.ReadAll(decrypted)
+	require.NoError(t, err)
+	requireKey(t, keyBytes)
+	_, err = KeyAge(keyPair.Private(), "age1invalid")
+	require.Error(t, err)
+}
+
+func TestKeyOpenPGP(t *testing.T) {
+	keyPair, err := ecdsa.NewECDSAKeyPair(context.Background(), elliptic.P256())
+	require.NoError(t, err)
+	entity, err := openpgp.NewEntity("certd", "test", "certd@localhost", nil)
+	require.NoError(t, err)
+	other, err := openpgp.NewEntity("other", "test", "other@localhost", nil)
+	require.NoError(t, err)
+	recipient := armorOpenPGPRecipient(t, entity)
+	exported, err := KeyOpenPGP(keyPair.Private(), recipient)
+	require.NoError(t, err)
+	requireOpenPGPKey(t, exported, entity)
+	keyRing := armorOpenPGPRecipient(t, entity, other)
+	_, err = KeyOpenPGP(keyPair.Private(), keyRing)
+	require.Error(t, err)
+	exported, err = KeyOpenPGP(keyPair.Private(), keyRing, fmt.Sprintf("%x", entity.PrimaryKey.Fingerprint))
+	require.NoError(t, err)
+	requireOpenPGPKey(t, exported, entity)
+	block, err := openpgparmor.Decode(bytes.NewReader(exported))
+	require.NoError(t, err)
+	_, err = openpgp.ReadMessage(block.Body, openpgp.EntityList{other}, nil, nil)
+	require.Error(t, err)
+	_, err = KeyOpenPGP(keyPair.Private(), keyRing, "0123456789ABCDEF")
+	require.Error(t, err)
+}
+
+func armorOpenPGPRecipient(t *testing.T, entities ...*openpgp.Entity) string {
+	var recipient bytes.Buffer
+	recipientWriter, err := openpgparmor.Encode(&recipient, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	for _, entity := range entities {
+		err = entity.Serialize(recipientWriter)
+		require.NoError(t, err)
+	}
+	err = recipientWriter.Close()
+	require.NoError(t, err)
+	return recipient.String()
+}
+
+func requireOpenPGPKey(t *testing.T, exported []byte, entity *openpgp.Entity) {
+	block, err := openpgparmor.Decode(bytes.NewReader(exported))
+	require.NoError(t, err)
+	message, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{entity}, nil, nil)
+	require.NoError(t, err)
+	keyBytes, err := io.ReadAll(message.UnverifiedBody)
+	require.NoError(t, err)
+	requireKey(t, keyBytes)
+}
+
+func requireKey(t *testing.T, keyBytes []byte) {
+	block, rest := pem.Decode(keyBytes)
+	require.NotNil(t, block)
+	require.Empty(t, rest)
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	require.NoError(t, err)
+	require.NotNil(t, key)
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const FormatOpenSSH = "openssh"
+const FormatOpenSSHPub = "openssh-pub"
+
+const openSSHKeyMagic = "openssh-key-v1\x00"
+const openSSHBlockSize = 8
+
+// Export a public key as an OpenSSH authorized_keys line.
+func PublicKeyOpenSSH(publicKey crypto.PublicKey, comment string) ([]byte, error) {
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported SSH public key (cause: %w)", err)
+	}
+	authorizedKey := ssh.MarshalAuthorizedKey(sshPublicKey)
+	if comment != "" {
+		authorizedKey = append(authorizedKey[:len(authorizedKey)-1], []byte(" "+comment+"\n")...)
+	}
+	return authorizedKey, nil
+}
+
+// Export a private key in OpenSSH format (openssh-key-v1; PEM encoded).
+//
+// The key is exported unencrypted as expected by sshd for host keys.
+func KeyOpenSSH(key crypto.PrivateKey, comment string) ([]byte, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported SSH private key type %T", key)
+	}
+	sshPublicKey, err := ssh.NewPublicKey(signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("unsupported SSH private key (cause: %w)", err)
+	}
+	keyFields, err := openSSHKeyFields(key)
+	if err != nil {
+		return nil, err
+	}
+	checkBytes := make([]byte, 4)
+	_, err = io.ReadFull(rand.Reader, checkBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate check value (cause: %w)", err)
+	}
+	check := binary.BigEndian.Uint32(checkBytes)
+	privateSection := ssh.Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		KeyType string
+	}{check, check, sshPublicKey.Type()})
+	privateSection = append(privateSection, keyFields...)
+	privateSection = append(privateSection, ssh.Marshal(struct{ Comment string }{comment})...)
+	for i := 1; len(privateSection)%openSSHBlockSize != 0; i++ {
+		privateSection = append(privateSection, byte(i))
+	}
+	keyBytes := []byte(openSSHKeyMagic)
+	keyBytes = append(keyBytes, ssh.Marshal(struct {
+		CipherName  string
+		KDFName     string
+		KDFOptions  string
+		NumKeys     uint32
+		PublicKey   []byte
+		PrivateKeys []byte
+	}{"none", "none", "", 1, sshPublicKey.Marshal(), privateSection})...)
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: keyBytes}), nil
+}
+
+func openSSHKeyFields(key crypto.PrivateKey) ([]byte, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if len(key.Primes) != 2 {
+			return nil, fmt.Errorf("unsupported multi-prime RSA key")
+		}
+		key.Precompute()
+		return ssh.Marshal(struct {
+			N    *big.Int
+			E    *big.Int
+			D    *big.Int
+			IQMP *big.Int
+			P    *big.Int
+			Q    *big.Int
+		}{key.N, big.NewInt(int64(key.E)), key.D, key.Precomputed.Qinv, key.Primes[0], key.Primes[1]}), nil
+	case *ecdsa.PrivateKey:
+		curveName, err := openSSHCurveName(key.Curve)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.Marshal(struct {
+			Curve string
+			Q     []byte
+			D     *big.Int
+		}{curveName, elliptic.Marshal(key.Curve, key.X, key.Y), key.D}), nil
+	case ed25519.PrivateKey:
+		return ssh.Marshal(struct {
+			Pub  []byte
+			Priv []byte
+		}{key.Public().(ed25519.PublicKey), key}), nil
+	}
+	return nil, fmt.Errorf("unsupported SSH private key type %T", key)
+}
+
+func openSSHCurveName(curve elliptic.Curve) (string, error) {
+	switch curve {
+	case elliptic.P256():
+		return "nistp256", nil
+	case elliptic.P384():
+		return "nistp384", nil
+	case elliptic.P521():
+		return "nistp521", nil
+	}
+	return "", fmt.Errorf("unsupported SSH key curve %s", curve.Params().Name)
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"context"
+	"crypto"
+	"testing"
+
+	"github.com/hdecarne-github/certd/pkg/keys/registry"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestOpenSSH(t *testing.T) {
+	for _, keyType := range []string{"ECDSA P-256", "ECDSA P-521", "ED25519", "RSA 2048"} {
+		keyPair, err := registry.StandardKey(keyType).New(context.Background())
+		require.NoError(t, err)
+		exportedKey, err := KeyOpenSSH(keyPair.Private(), "test@certd")
+		require.NoError(t, err)
+		key, err := ssh.ParseRawPrivateKey(exportedKey)
+		require.NoError(t, err, keyType)
+		require.True(t, key.(interface{ Equal(crypto.PrivateKey) bool }).Equal(keyPair.Private()), keyType)
+		exportedPublicKey, err := PublicKeyOpenSSH(keyPair.Public(), "test@certd")
+		require.NoError(t, err)
+		publicKey, comment, _, _, err := ssh.ParseAuthorizedKey(exportedPublicKey)
+		require.NoError(t, err)
+		require.Equal(t, "test@certd", comment)
+		signer, err := ssh.NewSignerFromKey(key)
+		require.NoError(t, err)
+		require.Equal(t, publicKey.Marshal(), signer.PublicKey().Marshal())
+	}
+	keyPair, err := registry.StandardKey("ECDSA P-224").New(context.Background())
+	require.NoError(t, err)
+	_, err = KeyOpenSSH(keyPair.Private(), "")
+	require.Error(t, err)
+}
@@ -0,0 +1,42 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const FormatPKCS12 = "pkcs12"
+
+// Export a private key together with its certificate and issuer chain as a password protected PKCS#12 archive.
+//
+// The archive is encrypted using AES-256 (PBES2) as supported by current mail clients and browsers.
+func PKCS12(key crypto.PrivateKey, certificate *x509.Certificate, chain []*x509.Certificate, password string) ([]byte, error) {
+	if password == "" {
+		return nil, fmt.Errorf("missing PKCS#12 password")
+	}
+	exported, err := pkcs12.Modern.Encode(key, certificate, chain, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode PKCS#12 archive (cause: %w)", err)
+	}
+	return exported, nil
+}
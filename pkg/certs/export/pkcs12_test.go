@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/stretchr/testify/require"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestPKCS12(t *testing.T) {
+	keyPair, err := ecdsa.StandardKeys()[1].New(context.Background())
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, template, keyPair.Public(), keyPair.Private())
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	require.NoError(t, err)
+	exported, err := PKCS12(keyPair.Private(), certificate, nil, "secret")
+	require.NoError(t, err)
+	key, decodedCertificate, _, err := pkcs12.DecodeChain(exported, "secret")
+	require.NoError(t, err)
+	require.Equal(t, keyPair.Private(), key)
+	require.Equal(t, certificate.Raw, decodedCertificate.Raw)
+	_, err = PKCS12(keyPair.Private(), certificate, nil, "")
+	require.Error(t, err)
+}
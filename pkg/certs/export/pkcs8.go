@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const FormatPKCS8 = "pkcs8"
+
+var oidPBES2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+var oidScrypt = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+var oidAES256GCM = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+
+const scryptCost = 1 << 15
+const scryptBlockSize = 8
+const scryptParallelization = 1
+const scryptSaltSize = 16
+const aes256KeySize = 32
+
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+type gcmParams struct {
+	Nonce  []byte
+	ICVLen int `asn1:"default:12"`
+}
+
+// Export a private key as a password protected PKCS#8 structure (PEM encoded).
+//
+// The key is encrypted using PBES2 with scrypt as key derivation function and AES-256-GCM as
+// encryption scheme (in contrast to the legacy PEM encryption, which uses unauthenticated
+// encryption and a weak key derivation).
+func KeyPKCS8(key crypto.PrivateKey, password string) ([]byte, error) {
+	if password == "" {
+		return nil, fmt.Errorf("missing PKCS#8 password")
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key (cause: %w)", err)
+	}
+	kdfParams := &scryptParams{
+		Salt:                     make([]byte, scryptSaltSize),
+		CostParameter:            scryptCost,
+		BlockSize:                scryptBlockSize,
+		ParallelizationParameter: scryptParallelization,
+		KeyLength:                aes256KeySize,
+	}
+	_, err = io.ReadFull(rand.Reader, kdfParams.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate salt (cause: %w)", err)
+	}
+	encryptionKey, err := scrypt.Key([]byte(password), kdfParams.Salt, kdfParams.CostParameter, kdfParams.BlockSize, kdfParams.ParallelizationParameter, kdfParams.KeyLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive encryption key (cause: %w)", err)
+	}
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup cipher (cause: %w)", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup cipher (cause: %w)", err)
+	}
+	encryptionParams := &gcmParams{
+		Nonce:  make([]byte, gcm.NonceSize()),
+		ICVLen: gcm.Overhead(),
+	}
+	_, err = io.ReadFull(rand.Reader, encryptionParams.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce (cause: %w)", err)
+	}
+	kdfParamsBytes, err := asn1.Marshal(*kdfParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key derivation parameters (cause: %w)", err)
+	}
+	encryptionParamsBytes, err := asn1.Marshal(*encryptionParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal encryption parameters (cause: %w)", err)
+	}
+	pbes2ParamsBytes, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidScrypt, Parameters: asn1.RawValue{FullBytes: kdfParamsBytes}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256GCM, Parameters: asn1.RawValue{FullBytes: encryptionParamsBytes}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal encryption algorithm (cause: %w)", err)
+	}
+	encryptedKeyBytes, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		EncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: pbes2ParamsBytes}},
+		EncryptedData:       gcm.Seal(nil, encryptionParams.Nonce, keyBytes, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal encrypted private key (cause: %w)", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encryptedKeyBytes}), nil
+}
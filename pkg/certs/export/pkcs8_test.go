@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"testing"
+
+	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/scrypt"
+)
+
+func TestKeyPKCS8(t *testing.T) {
+	keyPair, err := ecdsa.StandardKeys()[1].New(context.Background())
+	require.NoError(t, err)
+	exported, err := KeyPKCS8(keyPair.Private(), "secret")
+	require.NoError(t, err)
+	key, err := decryptPKCS8(t, exported, "secret")
+	require.NoError(t, err)
+	require.Equal(t, keyPair.Private(), key)
+	_, err = decryptPKCS8(t, exported, "wrong")
+	require.Error(t, err)
+	_, err = KeyPKCS8(keyPair.Private(), "")
+	require.Error(t, err)
+}
+
+func decryptPKCS8(t *testing.T, exported []byte, password string) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(exported)
+	require.NotNil(t, block)
+	require.Equal(t, "ENCRYPTED PRIVATE KEY", block.Type)
+	encryptedKey := &encryptedPrivateKeyInfo{}
+	_, err := asn1.Unmarshal(block.Bytes, encryptedKey)
+	require.NoError(t, err)
+	require.True(t, oidPBES2.Equal(encryptedKey.EncryptionAlgorithm.Algorithm))
+	params := &pbes2Params{}
+	_, err = asn1.Unmarshal(encryptedKey.EncryptionAlgorithm.Parameters.FullBytes, params)
+	require.NoError(t, err)
+	require.True(t, oidScrypt.Equal(params.KeyDerivationFunc.Algorithm))
+	require.True(t, oidAES256GCM.Equal(params.EncryptionScheme.Algorithm))
+	kdfParams := &scryptParams{}
+	_, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, kdfParams)
+	require.NoError(t, err)
+	encryptionParams := &gcmParams{}
+	_, err = asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, encryptionParams)
+	require.NoError(t, err)
+	encryptionKey, err := scrypt.Key([]byte(password), kdfParams.Salt, kdfParams.CostParameter, kdfParams.BlockSize, kdfParams.ParallelizationParameter, kdfParams.KeyLength)
+	require.NoError(t, err)
+	aesCipher, err := aes.NewCipher(encryptionKey)
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCMWithTagSize(aesCipher, encryptionParams.ICVLen)
+	require.NoError(t, err)
+	keyBytes, err := gcm.Open(nil, encryptionParams.Nonce, encryptedKey.EncryptedData, nil)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKCS8PrivateKey(keyBytes)
+}
@@ -17,12 +17,20 @@
 package extensions
 
 import (
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
 )
 
 const BasicConstraintsExtensionName = "BasicConstraints"
 const BasicConstraintsExtensionOID = "2.5.29.19"
 
+func init() {
+	Register(BasicConstraintsExtensionOID, BasicConstraintsExtensionName, func(certificate *x509.Certificate, _ pkix.Extension) (string, error) {
+		return BasicConstraintsString(certificate.IsCA, certificate.MaxPathLen, certificate.MaxPathLenZero), nil
+	})
+}
+
 func BasicConstraintsString(isCA bool, maxPathLen int, maxPathLenZero bool) string {
 	if !isCA {
 		return "CA = false"
@@ -18,6 +18,7 @@ package extensions
 
 import (
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/asn1"
 	"sort"
 	"strings"
@@ -26,6 +27,12 @@ import (
 const ExtKeyUsageExtensionName = "ExtKeyUsage"
 const ExtKeyUsageExtensionOID = "2.5.29.37"
 
+func init() {
+	Register(ExtKeyUsageExtensionOID, ExtKeyUsageExtensionName, func(certificate *x509.Certificate, _ pkix.Extension) (string, error) {
+		return ExtKeyUsageString(certificate.ExtKeyUsage, certificate.UnknownExtKeyUsage), nil
+	})
+}
+
 var extKeyUsageStrings = map[x509.ExtKeyUsage]string{
 	x509.ExtKeyUsageAny:                            "Any",
 	x509.ExtKeyUsageServerAuth:                     "ServerAuth",
@@ -17,6 +17,8 @@
 package extensions
 
 import (
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/hex"
 	"strings"
 )
@@ -27,6 +29,15 @@ const SubjectKeyIdentifierExtensionOID = "2.5.29.14"
 const AuthorityKeyIdentifierExtensionName = "AuthorityKeyIdentifier"
 const AuthorityKeyIdentifierExtensionOID = "2.5.29.35"
 
+func init() {
+	Register(SubjectKeyIdentifierExtensionOID, SubjectKeyIdentifierExtensionName, func(certificate *x509.Certificate, _ pkix.Extension) (string, error) {
+		return KeyIdentifierString(certificate.SubjectKeyId), nil
+	})
+	Register(AuthorityKeyIdentifierExtensionOID, AuthorityKeyIdentifierExtensionName, func(certificate *x509.Certificate, _ pkix.Extension) (string, error) {
+		return KeyIdentifierString(certificate.AuthorityKeyId), nil
+	})
+}
+
 const stringLimit = 32
 
 func KeyIdentifierString(keyId []byte) string {
@@ -18,6 +18,7 @@ package extensions
 
 import (
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"sort"
 	"strconv"
 	"strings"
@@ -26,6 +27,12 @@ import (
 const KeyUsageExtensionName = "KeyUsage"
 const KeyUsageExtensionOID = "2.5.29.15"
 
+func init() {
+	Register(KeyUsageExtensionOID, KeyUsageExtensionName, func(certificate *x509.Certificate, _ pkix.Extension) (string, error) {
+		return KeyUsageString(certificate.KeyUsage), nil
+	})
+}
+
 var keyUsageStrings = map[x509.KeyUsage]string{
 	x509.KeyUsageDigitalSignature:  "DigitalSignature",
 	x509.KeyUsageContentCommitment: "ContentCommitment",
@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package extensions
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+const CertificateTemplateNameExtensionName = "MSCertificateTemplateName"
+const CertificateTemplateNameExtensionOID = "1.3.6.1.4.1.311.20.2"
+
+const CertificateTemplateExtensionName = "MSCertificateTemplate"
+const CertificateTemplateExtensionOID = "1.3.6.1.4.1.311.21.7"
+
+const ApplicationPoliciesExtensionName = "MSApplicationPolicies"
+const ApplicationPoliciesExtensionOID = "1.3.6.1.4.1.311.21.10"
+
+const SIDExtensionName = "MSSID"
+const SIDExtensionOID = "1.3.6.1.4.1.311.25.2"
+
+func init() {
+	Register(CertificateTemplateNameExtensionOID, CertificateTemplateNameExtensionName, func(_ *x509.Certificate, extension pkix.Extension) (string, error) {
+		return CertificateTemplateNameString(extension.Value)
+	})
+	Register(CertificateTemplateExtensionOID, CertificateTemplateExtensionName, func(_ *x509.Certificate, extension pkix.Extension) (string, error) {
+		return CertificateTemplateString(extension.Value)
+	})
+	Register(ApplicationPoliciesExtensionOID, ApplicationPoliciesExtensionName, func(_ *x509.Certificate, extension pkix.Extension) (string, error) {
+		return ApplicationPoliciesString(extension.Value)
+	})
+	Register(SIDExtensionOID, SIDExtensionName, func(_ *x509.Certificate, extension pkix.Extension) (string, error) {
+		return SIDString(extension.Value)
+	})
+}
+
+var sidOtherNameOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 25, 2, 1}
+
+var applicationPolicyStrings = map[string]string{
+	"2.5.29.37.0":             "Any",
+	"1.3.6.1.5.5.7.3.1":       "ServerAuth",
+	"1.3.6.1.5.5.7.3.2":       "ClientAuth",
+	"1.3.6.1.5.5.7.3.3":       "CodeSigning",
+	"1.3.6.1.5.5.7.3.4":       "EmailProtection",
+	"1.3.6.1.5.5.7.3.8":       "TimeStamping",
+	"1.3.6.1.5.5.7.3.9":       "OCSPSigning",
+	"1.3.6.1.4.1.311.10.3.4":  "EncryptingFileSystem",
+	"1.3.6.1.4.1.311.20.2.1":  "CertificateRequestAgent",
+	"1.3.6.1.4.1.311.20.2.2":  "SmartcardLogon",
+	"1.3.6.1.4.1.311.10.3.12": "DocumentSigning",
+}
+
+// Decode the Microsoft certificate template name extension (szOID_ENROLL_CERTTYPE_EXTENSION).
+func CertificateTemplateNameString(value []byte) (string, error) {
+	var raw asn1.RawValue
+	_, err := asn1.Unmarshal(value, &raw)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode certificate template name (cause: %w)", err)
+	}
+	switch raw.Tag {
+	case asn1.TagBMPString:
+		if len(raw.Bytes)%2 != 0 {
+			return "", fmt.Errorf("invalid BMPString length %d", len(raw.Bytes))
+		}
+		chars := make([]uint16, len(raw.Bytes)/2)
+		for i := range chars {
+			chars[i] = uint16(raw.Bytes[2*i])<<8 | uint16(raw.Bytes[2*i+1])
+		}
+		return string(utf16.Decode(chars)), nil
+	case asn1.TagUTF8String, asn1.TagPrintableString, asn1.TagIA5String:
+		return string(raw.Bytes), nil
+	}
+	return "", fmt.Errorf("unexpected certificate template name tag %d", raw.Tag)
+}
+
+type certificateTemplate struct {
+	TemplateID   asn1.ObjectIdentifier
+	MajorVersion int `asn1:"optional"`
+	MinorVersion int `asn1:"optional"`
+}
+
+// Decode the Microsoft certificate template extension (szOID_CERTIFICATE_TEMPLATE).
+func CertificateTemplateString(value []byte) (string, error) {
+	template := &certificateTemplate{}
+	_, err := asn1.Unmarshal(value, template)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode certificate template (cause: %w)", err)
+	}
+	return fmt.Sprintf("%s (version: %d.%d)", template.TemplateID, template.MajorVersion, template.MinorVersion), nil
+}
+
+type applicationPolicy struct {
+	PolicyID   asn1.ObjectIdentifier
+	Qualifiers asn1.RawValue `asn1:"optional"`
+}
+
+// Decode the Microsoft application policies extension (szOID_APPLICATION_CERT_POLICIES).
+func ApplicationPoliciesString(value []byte) (string, error) {
+	policies := make([]applicationPolicy, 0)
+	_, err := asn1.Unmarshal(value, &policies)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode application policies (cause: %w)", err)
+	}
+	if len(policies) == 0 {
+		return "-", nil
+	}
+	policyStrings := make([]string, 0, len(policies))
+	for _, policy := range policies {
+		policyString := applicationPolicyStrings[policy.PolicyID.String()]
+		if policyString == "" {
+			policyString = policy.PolicyID.String()
+		}
+		policyStrings = append(policyStrings, policyString)
+	}
+	return strings.Join(policyStrings, ", "), nil
+}
+
+type sidOtherName struct {
+	TypeID asn1.ObjectIdentifier
+	Value  []byte `asn1:"explicit,tag:0"`
+}
+
+// Decode the Microsoft SID extension (szOID_NTDS_CA_SECURITY_EXT).
+func SIDString(value []byte) (string, error) {
+	otherNames := make([]asn1.RawValue, 0)
+	_, err := asn1.Unmarshal(value, &otherNames)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode SID (cause: %w)", err)
+	}
+	for _, rawOtherName := range otherNames {
+		if rawOtherName.Class != asn1.ClassContextSpecific || rawOtherName.Tag != 0 {
+			continue
+		}
+		otherName := &sidOtherName{}
+		_, err = asn1.UnmarshalWithParams(rawOtherName.FullBytes, otherName, "tag:0")
+		if err != nil {
+			return "", fmt.Errorf("failed to decode SID (cause: %w)", err)
+		}
+		if otherName.TypeID.Equal(sidOtherNameOID) {
+			return string(otherName.Value), nil
+		}
+	}
+	return "", fmt.Errorf("no SID found")
+}
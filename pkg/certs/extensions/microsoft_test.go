@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package extensions
+
+import (
+	"encoding/asn1"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificateTemplateNameString(t *testing.T) {
+	bmpValue := []byte{0x1e, 0x0a, 0x00, 'W', 0x00, 'e', 0x00, 'b', 0x00, 'S', 0x00, 'v'}
+	name, err := CertificateTemplateNameString(bmpValue)
+	require.NoError(t, err)
+	require.Equal(t, "WebSv", name)
+	utf8Value, err := asn1.MarshalWithParams("User", "utf8")
+	require.NoError(t, err)
+	name, err = CertificateTemplateNameString(utf8Value)
+	require.NoError(t, err)
+	require.Equal(t, "User", name)
+	_, err = CertificateTemplateNameString([]byte{0x1e, 0x01, 0x00})
+	require.Error(t, err)
+}
+
+func TestCertificateTemplateString(t *testing.T) {
+	value, err := asn1.Marshal(certificateTemplate{
+		TemplateID:   asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 21, 8, 1},
+		MajorVersion: 100,
+		MinorVersion: 4,
+	})
+	require.NoError(t, err)
+	template, err := CertificateTemplateString(value)
+	require.NoError(t, err)
+	require.Equal(t, "1.3.6.1.4.1.311.21.8.1 (version: 100.4)", template)
+}
+
+func TestApplicationPoliciesString(t *testing.T) {
+	value, err := asn1.Marshal([]struct{ PolicyID asn1.ObjectIdentifier }{
+		{PolicyID: asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 2}},
+		{PolicyID: asn1.ObjectIdentifier{1, 2, 3, 4}},
+	})
+	require.NoError(t, err)
+	policies, err := ApplicationPoliciesString(value)
+	require.NoError(t, err)
+	require.Equal(t, "ClientAuth, 1.2.3.4", policies)
+}
+
+func TestSIDString(t *testing.T) {
+	otherName, err := asn1.MarshalWithParams(sidOtherName{
+		TypeID: sidOtherNameOID,
+		Value:  []byte("S-1-5-21-1004336348-1177238915-682003330-512"),
+	}, "tag:0")
+	require.NoError(t, err)
+	value, err := asn1.Marshal([]asn1.RawValue{{FullBytes: otherName}})
+	require.NoError(t, err)
+	sid, err := SIDString(value)
+	require.NoError(t, err)
+	require.Equal(t, "S-1-5-21-1004336348-1177238915-682003330-512", sid)
+}
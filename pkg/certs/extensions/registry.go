@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package extensions
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"sync"
+)
+
+// Formatter functions render the value of a certificate extension.
+type Formatter func(certificate *x509.Certificate, extension pkix.Extension) (string, error)
+
+type decoder struct {
+	name      string
+	formatter Formatter
+}
+
+var decoders = make(map[string]*decoder)
+var decodersLock sync.RWMutex
+
+// Register a decoder for the extension with the given OID.
+//
+// A previously registered decoder for the same OID is replaced.
+func Register(oid string, name string, formatter Formatter) {
+	decodersLock.Lock()
+	defer decodersLock.Unlock()
+	decoders[oid] = &decoder{name: name, formatter: formatter}
+}
+
+// Decode the given certificate extension into its name and formatted value.
+//
+// Extensions without registered decoder are reported with their OID as name and an empty value.
+func Decode(certificate *x509.Certificate, extension pkix.Extension) (string, string) {
+	oid := extension.Id.String()
+	registered := lookup(oid)
+	if registered == nil {
+		return oid, ""
+	}
+	formatted, err := registered.formatter(certificate, extension)
+	if err != nil {
+		return registered.name, "?"
+	}
+	return registered.name, formatted
+}
+
+func lookup(oid string) *decoder {
+	decodersLock.RLock()
+	defer decodersLock.RUnlock()
+	return decoders[oid]
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package extensions
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecode(t *testing.T) {
+	certificate := &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+	name, value := Decode(certificate, pkix.Extension{Id: asn1.ObjectIdentifier{2, 5, 29, 37}})
+	require.Equal(t, ExtKeyUsageExtensionName, name)
+	require.Equal(t, "ServerAuth", value)
+	unknown := pkix.Extension{Id: asn1.ObjectIdentifier{1, 2, 3, 4}, Value: []byte{0x05, 0x00}}
+	name, value = Decode(certificate, unknown)
+	require.Equal(t, "1.2.3.4", name)
+	require.Equal(t, "", value)
+	Register("1.2.3.4", "Test", func(_ *x509.Certificate, extension pkix.Extension) (string, error) {
+		return fmt.Sprintf("%x", extension.Value), nil
+	})
+	name, value = Decode(certificate, unknown)
+	require.Equal(t, "Test", name)
+	require.Equal(t, "0500", value)
+	Register("1.2.3.4", "Test", func(_ *x509.Certificate, _ pkix.Extension) (string, error) {
+		return "", fmt.Errorf("decode failure")
+	})
+	name, value = Decode(certificate, unknown)
+	require.Equal(t, "Test", name)
+	require.Equal(t, "?", value)
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package extensions
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+)
+
+type requestCertificate struct {
+	TBSCertificate     requestTBSCertificate
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	SignatureValue     asn1.BitString
+}
+
+type requestTBSCertificate struct {
+	Version            int `asn1:"optional,explicit,default:0,tag:0"`
+	SerialNumber       *big.Int
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Issuer             asn1.RawValue
+	Validity           requestValidity
+	Subject            asn1.RawValue
+	PublicKey          asn1.RawValue
+	Extensions         []pkix.Extension `asn1:"omitempty,optional,explicit,tag:3"`
+}
+
+type requestValidity struct {
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// Decode the given extension requested by a certificate request into its name and formatted value.
+//
+// As the registered formatters operate on certificates, the requested extensions are decoded by means
+// of an (unsigned) pseudo certificate carrying the request's subject, public key and extensions.
+func DecodeRequest(request *x509.CertificateRequest, extension pkix.Extension) (string, string) {
+	certificate, err := newRequestCertificate(request)
+	if err != nil {
+		oid := extension.Id.String()
+		registered := lookup(oid)
+		if registered == nil {
+			return oid, ""
+		}
+		return registered.name, "?"
+	}
+	return Decode(certificate, extension)
+}
+
+func newRequestCertificate(request *x509.CertificateRequest) (*x509.Certificate, error) {
+	// the algorithm is irrelevant as the pseudo certificate is never verified
+	signatureAlgorithm := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}}
+	now := time.Now().UTC().Truncate(time.Second)
+	pseudo := requestCertificate{
+		TBSCertificate: requestTBSCertificate{
+			Version:            2,
+			SerialNumber:       big.NewInt(1),
+			SignatureAlgorithm: signatureAlgorithm,
+			Issuer:             asn1.RawValue{FullBytes: request.RawSubject},
+			Validity:           requestValidity{NotBefore: now, NotAfter: now},
+			Subject:            asn1.RawValue{FullBytes: request.RawSubject},
+			PublicKey:          asn1.RawValue{FullBytes: request.RawSubjectPublicKeyInfo},
+			Extensions:         request.Extensions,
+		},
+		SignatureAlgorithm: signatureAlgorithm,
+	}
+	encoded, err := asn1.Marshal(pseudo)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(encoded)
+}
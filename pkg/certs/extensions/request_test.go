@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package extensions
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeRequest(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	basicConstraints, err := asn1.Marshal(struct {
+		IsCA bool `asn1:"optional"`
+	}{IsCA: true})
+	require.NoError(t, err)
+	template := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "test"},
+		DNSNames: []string{"test.example.org"},
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{2, 5, 29, 19}, Critical: true, Value: basicConstraints},
+			{Id: asn1.ObjectIdentifier{1, 2, 3, 5}, Value: []byte{0x05, 0x00}},
+		},
+	}
+	requestBytes, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	require.NoError(t, err)
+	request, err := x509.ParseCertificateRequest(requestBytes)
+	require.NoError(t, err)
+	decoded := make(map[string]string)
+	for _, extension := range request.Extensions {
+		name, value := DecodeRequest(request, extension)
+		decoded[name] = value
+	}
+	require.Equal(t, "CA = true", decoded[BasicConstraintsExtensionName])
+	require.Equal(t, "DNS:test.example.org", decoded[SubjectAltNameExtensionName])
+	require.Contains(t, decoded, "1.2.3.5")
+}
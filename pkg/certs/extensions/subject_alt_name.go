@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package extensions
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const SubjectAltNameExtensionName = "SubjectAltName"
+const SubjectAltNameExtensionOID = "2.5.29.17"
+
+func init() {
+	Register(SubjectAltNameExtensionOID, SubjectAltNameExtensionName, func(certificate *x509.Certificate, _ pkix.Extension) (string, error) {
+		return SubjectAltNameString(certificate.DNSNames, certificate.EmailAddresses, certificate.IPAddresses, certificate.URIs), nil
+	})
+}
+
+func SubjectAltNameString(dnsNames []string, emailAddresses []string, ipAddresses []net.IP, uris []*url.URL) string {
+	names := make([]string, 0)
+	for _, dnsName := range dnsNames {
+		names = append(names, "DNS:"+dnsName)
+	}
+	for _, emailAddress := range emailAddresses {
+		names = append(names, "email:"+emailAddress)
+	}
+	for _, ipAddress := range ipAddresses {
+		names = append(names, "IP Address:"+ipAddress.String())
+	}
+	for _, uri := range uris {
+		names = append(names, "URI:"+uri.String())
+	}
+	if len(names) == 0 {
+		return "-"
+	}
+	return strings.Join(names, ", ")
+}
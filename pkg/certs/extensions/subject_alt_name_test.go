@@ -0,0 +1,32 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package extensions
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubjectAltNameString(t *testing.T) {
+	uri, err := url.Parse("https://www.example.org/")
+	require.NoError(t, err)
+	require.Equal(t, "-", SubjectAltNameString(nil, nil, nil, nil))
+	require.Equal(t, "DNS:www.example.org, email:user@example.org, IP Address:127.0.0.1, URI:https://www.example.org/", SubjectAltNameString([]string{"www.example.org"}, []string{"user@example.org"}, []net.IP{net.ParseIP("127.0.0.1")}, []*url.URL{uri}))
+}
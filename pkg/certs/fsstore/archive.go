@@ -0,0 +1,252 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fsstore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/clock"
+	"github.com/jellydator/ttlcache/v3"
+)
+
+const archiveDir = "archive"
+
+// Record the time of archival using the given clock (instead of the active clock).
+func WithClock(clk clock.Clock) Option {
+	return func(store *FSStore) {
+		store.clock = clk
+	}
+}
+
+func (store *FSStore) now() time.Time {
+	if store.clock == nil {
+		return clock.Now()
+	}
+	return store.clock.Now()
+}
+
+// Set up the archive namespace holding the archived store entries.
+//
+// The archive is accessed via a derived store instance using the archive directory as its path (the directory is
+// created on demand during the first archive operation).
+func (store *FSStore) openArchive() error {
+	logger := store.logger.With().Str("namespace", archiveDir).Logger()
+	archive := *store
+	archive.path = filepath.Join(store.path, archiveDir)
+	archive.index = &fsStoreIndex{entries: make([]string, 0), pending: make(map[string]struct{}), opened: time.Now()}
+	archive.certificateCache = ttlcache.New(certificateCacheOptions...)
+	archive.certificateRequestCache = ttlcache.New(certificateRequestCacheOptions...)
+	archive.revocationListCache = ttlcache.New(revocationListCacheOptions...)
+	archive.attributesCache = ttlcache.New(attributesCacheOptions...)
+	archive.storeLock = nil
+	archive.archive = nil
+	archive.warmer = nil
+	archive.logger = &logger
+	store.archive = &archive
+	_, err := os.Stat(archive.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to stat archive path '%s' (cause: %w)", archive.path, err)
+	}
+	return archive.scan()
+}
+
+// Get the archived store entries.
+func (store *FSStore) ArchivedEntries() certs.StoreEntries {
+	return store.archive.Entries()
+}
+
+// Get an archived store entry.
+func (store *FSStore) ArchivedEntry(name string) (certs.StoreEntry, error) {
+	return store.archive.Entry(name)
+}
+
+// Move a store entry into the archive namespace.
+//
+// Archived entries are hidden from the store's entry listing but retained until they are purged. The time of
+// archival is recorded in the entry's attributes.
+func (store *FSStore) ArchiveEntry(name string) error {
+	err := store.checkWritable()
+	if err != nil {
+		return err
+	}
+	store.index.lock.Lock()
+	defer store.index.lock.Unlock()
+	store.archive.index.lock.Lock()
+	defer store.archive.index.lock.Unlock()
+	store.index.generation++
+	store.archive.index.generation++
+	err = os.MkdirAll(store.archive.path, storeDirPerm)
+	if err != nil {
+		return fmt.Errorf("failed to create archive directory '%s' (cause: %w)", store.archive.path, err)
+	}
+	store.logger.Info().Msgf("Archiving store entry '%s'...", name)
+	return store.moveEntry(name, store.archive, func(attributes *certs.StoreEntryAttributes) {
+		archived := store.now().UTC()
+		attributes.Archived = &archived
+	})
+}
+
+// Move an archived store entry back into the store.
+func (store *FSStore) RestoreEntry(name string) error {
+	err := store.checkWritable()
+	if err != nil {
+		return err
+	}
+	store.index.lock.Lock()
+	defer store.index.lock.Unlock()
+	store.archive.index.lock.Lock()
+	defer store.archive.index.lock.Unlock()
+	store.index.generation++
+	store.archive.index.generation++
+	store.logger.Info().Msgf("Restoring store entry '%s'...", name)
+	return store.archive.moveEntry(name, store, func(attributes *certs.StoreEntryAttributes) {
+		attributes.Archived = nil
+	})
+}
+
+// Permanently delete an archived store entry.
+//
+// Whether an entry may be purged (e.g. with respect to a retention policy) is up to the caller.
+func (store *FSStore) PurgeEntry(name string) error {
+	err := store.checkWritable()
+	if err != nil {
+		return err
+	}
+	store.archive.index.lock.Lock()
+	defer store.archive.index.lock.Unlock()
+	store.archive.index.generation++
+	archive := store.archive
+	if !archive.hasAttributes(name) {
+		return fmt.Errorf("failed to purge archived store entry '%s' (cause: %w)", name, fs.ErrNotExist)
+	}
+	store.logger.Info().Msgf("Purging archived store entry '%s'...", name)
+	for _, extension := range []string{keyExtension, crtExtension, csrExtension, crlExtension, attributesExtension} {
+		filePath := archive.entryPath(name, extension)
+		err = os.Remove(filePath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove file '%s' (cause: %w)", filePath, err)
+		}
+	}
+	archive.dropEntry(name)
+	return nil
+}
+
+// Move the files of the given entry to the target store instance (the caller has to hold both index locks).
+//
+// The target attributes are written first and the source attributes are removed last. If any step fails, the
+// already moved files are moved back, leaving the entry unchanged at its source location.
+func (store *FSStore) moveEntry(name string, target *FSStore, update func(attributes *certs.StoreEntryAttributes)) error {
+	if !store.hasAttributes(name) {
+		return fmt.Errorf("failed to move store entry '%s' (cause: %w)", name, fs.ErrNotExist)
+	}
+	if target.hasAttributes(name) {
+		return fmt.Errorf("failed to move store entry '%s' (cause: %w)", name, fs.ErrExist)
+	}
+	attributes, err := store.readAttributes(name)
+	if err != nil {
+		return err
+	}
+	file := store.entryFile(name)
+	if file != entryFileName(name) {
+		target.index.files.Store(name, file)
+	}
+	updatedAttributes := *attributes
+	update(&updatedAttributes)
+	attributeBytes, err := target.encodeAttributes(name, &updatedAttributes)
+	if err != nil {
+		target.index.files.Delete(name)
+		return err
+	}
+	targetAttributesPath := target.entryPath(name, attributesExtension)
+	err = os.WriteFile(targetAttributesPath, attributeBytes, storeFilePerm)
+	if err != nil {
+		os.Remove(targetAttributesPath)
+		target.index.files.Delete(name)
+		return fmt.Errorf("failed to write attributes file '%s' (cause: %w)", targetAttributesPath, err)
+	}
+	moved := make([]string, 0)
+	rollback := func() {
+		for i := len(moved) - 1; i >= 0; i-- {
+			sourcePath := store.entryPath(name, moved[i])
+			targetPath := target.entryPath(name, moved[i])
+			rollbackErr := os.Rename(targetPath, sourcePath)
+			if rollbackErr != nil {
+				store.logger.Error().Err(rollbackErr).Msgf("Failed to move back file '%s' during entry move rollback", targetPath)
+			}
+		}
+		os.Remove(targetAttributesPath)
+		target.index.files.Delete(name)
+	}
+	for _, extension := range []string{keyExtension, crtExtension, csrExtension, crlExtension} {
+		sourcePath := store.entryPath(name, extension)
+		_, err = os.Stat(sourcePath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		targetPath := target.entryPath(name, extension)
+		err = os.Rename(sourcePath, targetPath)
+		if err != nil {
+			rollback()
+			return fmt.Errorf("failed to move file '%s' to '%s' (cause: %w)", sourcePath, targetPath, err)
+		}
+		moved = append(moved, extension)
+	}
+	sourceAttributesPath := store.entryPath(name, attributesExtension)
+	err = os.Remove(sourceAttributesPath)
+	if err != nil {
+		rollback()
+		return fmt.Errorf("failed to remove attributes file '%s' (cause: %w)", sourceAttributesPath, err)
+	}
+	store.dropEntry(name)
+	target.index.entries = append(target.index.entries, name)
+	sort.Strings(target.index.entries)
+	if target.index.trackExpiries && target.hasCertificate(name) {
+		certificate, err := target.readCertificate(name)
+		if err != nil {
+			return err
+		}
+		target.updateExpiry(name, certificate.NotAfter)
+	}
+	return nil
+}
+
+// Remove an entry from the index and caches (the caller has to hold the index lock).
+func (store *FSStore) dropEntry(name string) {
+	entries := make([]string, 0, len(store.index.entries))
+	for _, entry := range store.index.entries {
+		if entry != name {
+			entries = append(entries, entry)
+		}
+	}
+	store.index.entries = entries
+	store.removeExpiry(name)
+	store.index.files.Delete(name)
+	store.certificateCache.Delete(name)
+	store.certificateRequestCache.Delete(name)
+	store.revocationListCache.Delete(name)
+	store.attributesCache.Delete(name)
+}
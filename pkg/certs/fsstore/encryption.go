@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fsstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+var encryptedEntryMagic = []byte("certd-entry-v1:")
+
+const entryKeyPurpose = "entry"
+
+// Encrypt attributes and certificate request files (in addition to key files) using AES-GCM with a key derived
+// from the store secret.
+//
+// Unencrypted files written before encryption has been enabled are still readable and become encrypted as soon as
+// they are written the next time.
+func WithEntryEncryption() Option {
+	return func(store *FSStore) {
+		store.entryEncryption = true
+	}
+}
+
+func (store *FSStore) entryCipher() (cipher.AEAD, error) {
+	key, err := store.DeriveKey(entryKeyPurpose, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create entry cipher (cause: %w)", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create entry cipher (cause: %w)", err)
+	}
+	return aead, nil
+}
+
+// Encrypt the given entry file data (if entry encryption is enabled).
+//
+// The file name is bound to the encrypted data to prevent swapping of files between entries.
+func (store *FSStore) sealEntryFile(fileName string, data []byte) ([]byte, error) {
+	if !store.entryEncryption {
+		return data, nil
+	}
+	aead, err := store.entryCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce (cause: %w)", err)
+	}
+	encrypted := append([]byte{}, encryptedEntryMagic...)
+	encrypted = append(encrypted, nonce...)
+	return aead.Seal(encrypted, nonce, data, []byte(fileName)), nil
+}
+
+// Decrypt the given entry file data (if it is encrypted).
+func (store *FSStore) openEntryFile(fileName string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedEntryMagic) {
+		return data, nil
+	}
+	aead, err := store.entryCipher()
+	if err != nil {
+		return nil, err
+	}
+	encrypted := data[len(encryptedEntryMagic):]
+	nonceSize := aead.NonceSize()
+	if len(encrypted) < nonceSize {
+		return nil, fmt.Errorf("invalid encrypted file '%s'", fileName)
+	}
+	decrypted, err := aead.Open(nil, encrypted[:nonceSize], encrypted[nonceSize:], []byte(fileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt file '%s' (cause: %w)", fileName, err)
+	}
+	return decrypted, nil
+}
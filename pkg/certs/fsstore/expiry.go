@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fsstore
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+const expiryFile = ".expiry"
+
+// FSStoreExpiry records the expiry of a single store entry's certificate.
+type FSStoreExpiry = certs.StoreExpiry
+
+// Set up the expiry index for the scanned store entries.
+//
+// The persisted index is taken over for all known entries. Entries missing in the persisted index (e.g. due to
+// a crash or files copied into the store) are added by reading their certificate.
+func (store *FSStore) loadExpiryIndex() {
+	persisted := make(map[string]time.Time)
+	expiryFilePath := filepath.Join(store.path, expiryFile)
+	expiryBytes, err := os.ReadFile(expiryFilePath)
+	if err == nil {
+		var expiries []FSStoreExpiry
+		err = json.Unmarshal(expiryBytes, &expiries)
+		if err != nil {
+			store.logger.Warn().Err(err).Msgf("Rebuilding broken expiry index '%s'", expiryFilePath)
+		}
+		for _, expiry := range expiries {
+			persisted[expiry.Name] = expiry.NotAfter
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		store.logger.Warn().Err(err).Msgf("Rebuilding unreadable expiry index '%s'", expiryFilePath)
+	}
+	expiries := make([]FSStoreExpiry, 0, len(store.index.entries))
+	dirty := false
+	for _, name := range store.index.entries {
+		notAfter, found := persisted[name]
+		if found {
+			delete(persisted, name)
+		} else {
+			dirty = true
+			if !store.hasCertificate(name) {
+				continue
+			}
+			certificate, err := store.readCertificate(name)
+			if err != nil {
+				store.logger.Warn().Err(err).Msgf("Ignoring expiry of store entry '%s'", name)
+				continue
+			}
+			notAfter = certificate.NotAfter
+		}
+		expiries = append(expiries, FSStoreExpiry{Name: name, NotAfter: notAfter})
+	}
+	sort.Slice(expiries, func(i, j int) bool {
+		return expiryLess(&expiries[i], &expiries[j])
+	})
+	store.index.expiries = expiries
+	store.index.trackExpiries = true
+	// remaining persisted entries are stale
+	if dirty || len(persisted) > 0 {
+		store.writeExpiryIndex()
+	}
+}
+
+// Get the store entries expiring within the given time range (from exclusive, until inclusive) ordered by expiry.
+func (store *FSStore) Expiries(from time.Time, until time.Time) []FSStoreExpiry {
+	store.index.lock.RLock()
+	defer store.index.lock.RUnlock()
+	expiries := store.index.expiries
+	start := sort.Search(len(expiries), func(i int) bool {
+		return expiries[i].NotAfter.After(from)
+	})
+	end := sort.Search(len(expiries), func(i int) bool {
+		return expiries[i].NotAfter.After(until)
+	})
+	if end <= start {
+		return []FSStoreExpiry{}
+	}
+	return append([]FSStoreExpiry{}, expiries[start:end]...)
+}
+
+// Get the store entry expiring next after the given time (nil if there is none).
+func (store *FSStore) NextExpiry(after time.Time) *FSStoreExpiry {
+	store.index.lock.RLock()
+	defer store.index.lock.RUnlock()
+	expiries := store.index.expiries
+	next := sort.Search(len(expiries), func(i int) bool {
+		return expiries[i].NotAfter.After(after)
+	})
+	if next >= len(expiries) {
+		return nil
+	}
+	expiry := expiries[next]
+	return &expiry
+}
+
+// Record the expiry of the given entry (the caller has to hold the index lock).
+func (store *FSStore) updateExpiry(name string, notAfter time.Time) {
+	if !store.index.trackExpiries {
+		return
+	}
+	store.deleteExpiry(name)
+	expiry := FSStoreExpiry{Name: name, NotAfter: notAfter}
+	expiries := store.index.expiries
+	insert := sort.Search(len(expiries), func(i int) bool {
+		return expiryLess(&expiry, &expiries[i])
+	})
+	expiries = append(expiries, FSStoreExpiry{})
+	copy(expiries[insert+1:], expiries[insert:])
+	expiries[insert] = expiry
+	store.index.expiries = expiries
+	store.writeExpiryIndex()
+}
+
+// Remove the expiry of the given entry (the caller has to hold the index lock).
+func (store *FSStore) removeExpiry(name string) {
+	if !store.index.trackExpiries {
+		return
+	}
+	if store.deleteExpiry(name) {
+		store.writeExpiryIndex()
+	}
+}
+
+func (store *FSStore) deleteExpiry(name string) bool {
+	for i, expiry := range store.index.expiries {
+		if expiry.Name == name {
+			store.index.expiries = append(store.index.expiries[:i], store.index.expiries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Persist the expiry index.
+//
+// As the index can always be rebuilt from the store entries, failures are only logged.
+func (store *FSStore) writeExpiryIndex() {
+	if store.readOnly {
+		return
+	}
+	expiryFilePath := filepath.Join(store.path, expiryFile)
+	expiryBytes, err := json.Marshal(store.index.expiries)
+	if err == nil {
+		updateFilePath := expiryFilePath + updateExtension
+		err = os.WriteFile(updateFilePath, expiryBytes, storeFilePerm)
+		if err == nil {
+			err = os.Rename(updateFilePath, expiryFilePath)
+		}
+		if err != nil {
+			os.Remove(updateFilePath)
+		}
+	}
+	if err != nil {
+		store.logger.Warn().Err(err).Msgf("Failed to write expiry index '%s'", expiryFilePath)
+	}
+}
+
+func expiryLess(expiry1 *FSStoreExpiry, expiry2 *FSStoreExpiry) bool {
+	if expiry1.NotAfter.Equal(expiry2.NotAfter) {
+		return expiry1.Name < expiry2.Name
+	}
+	return expiry1.NotAfter.Before(expiry2.NotAfter)
+}
@@ -18,34 +18,45 @@
 package fsstore
 
 import (
+	"bytes"
+	"context"
 	"crypto"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hdecarne-github/certd/internal/logging"
 	"github.com/hdecarne-github/certd/internal/security"
 	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/certs/imported"
+	"github.com/hdecarne-github/certd/pkg/clock"
 	"github.com/jellydator/ttlcache/v3"
 	"github.com/rs/zerolog"
+	"golang.org/x/crypto/hkdf"
 )
 
 const settingsFile = ".store"
+const trustDir = "trust"
+const trustExtension = ".pem"
 const keyExtension = ".key"
 const crtExtension = ".crt"
 const csrExtension = ".csr"
 const crlExtension = ".crl"
 const attributesExtension = ".json"
+const updateExtension = ".update"
 
 var certificateCacheOptions []ttlcache.Option[string, *x509.Certificate] = []ttlcache.Option[string, *x509.Certificate]{ttlcache.WithCapacity[string, *x509.Certificate](100)}
 var certificateRequestCacheOptions []ttlcache.Option[string, *x509.CertificateRequest] = []ttlcache.Option[string, *x509.CertificateRequest]{ttlcache.WithCapacity[string, *x509.CertificateRequest](100)}
@@ -59,34 +70,88 @@ type FSStore struct {
 	name                    string
 	path                    string
 	secret                  *security.Secret
-	entries                 []string
+	index                   *fsStoreIndex
+	archive                 *FSStore
 	certificateCache        *ttlcache.Cache[string, *x509.Certificate]
 	certificateRequestCache *ttlcache.Cache[string, *x509.CertificateRequest]
 	revocationListCache     *ttlcache.Cache[string, *x509.RevocationList]
 	attributesCache         *ttlcache.Cache[string, *certs.StoreEntryAttributes]
-	lock                    sync.RWMutex
+	permissionPolicy        PermissionPolicy
+	storeLock               *fsStoreLock
+	readOnlyFallback        bool
+	forceUnlock             bool
+	readOnly                bool
+	entryEncryption         bool
+	warmCaches              bool
+	warmer                  *fsStoreWarmer
+	clock                   clock.Clock
+	rotationHooks           []SecretRotationHook
 	logger                  *zerolog.Logger
 }
 
+type fsStoreIndex struct {
+	entries       []string
+	files         sync.Map
+	expiries      []FSStoreExpiry
+	trackExpiries bool
+	scanDuration  time.Duration
+	opened        time.Time
+	generation    uint64
+	// Entry names reserved by a generation running outside the index lock
+	pending map[string]struct{}
+	lock    sync.RWMutex
+}
+
+// FSStoreDiagnostics contains runtime information about a FS store.
+type FSStoreDiagnostics struct {
+	Entries      int
+	ScanDuration time.Duration
+	// Duration of the cache warming (0 if disabled or still running)
+	WarmDuration time.Duration
+	Caches       map[string]FSStoreCacheDiagnostics
+}
+
+// FSStoreCacheDiagnostics contains runtime information about a single FS store cache.
+type FSStoreCacheDiagnostics struct {
+	Items      int
+	Insertions uint64
+	Hits       uint64
+	Misses     uint64
+	Evictions  uint64
+}
+
+func newFSStoreCacheDiagnostics(items int, metrics ttlcache.Metrics) FSStoreCacheDiagnostics {
+	return FSStoreCacheDiagnostics{
+		Items:      items,
+		Insertions: metrics.Insertions,
+		Hits:       metrics.Hits,
+		Misses:     metrics.Misses,
+		Evictions:  metrics.Evictions,
+	}
+}
+
 type fsStoreSettings struct {
-	Secret string `json:"secret"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	Secret        string `json:"secret"`
+	// Secret of a not yet completed secret rotation (see RotateSecret)
+	NextSecret string `json:"next_secret,omitempty"`
 }
 
-func Init(path string) (*FSStore, error) {
-	return newFSStore(path, true)
+func Init(path string, options ...Option) (*FSStore, error) {
+	return newFSStore(path, true, options)
 }
 
-func Open(path string) (*FSStore, error) {
-	return newFSStore(path, false)
+func Open(path string, options ...Option) (*FSStore, error) {
+	return newFSStore(path, false, options)
 }
 
-func newFSStore(path string, init bool) (*FSStore, error) {
+func newFSStore(path string, init bool, options []Option) (*FSStore, error) {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to determine absolute path for '%s' (cause: %w)", path, err)
 	}
 	name := "fs:" + absPath
-	logger := logging.RootLogger().With().Str("store", name).Logger()
+	logger := logging.ModuleLogger(logging.ModuleStore).With().Str("store", name).Logger()
 	if init {
 		logger.Info().Msg("Creating FS certificate store")
 		err := initFSStore(path)
@@ -99,6 +164,10 @@ func newFSStore(path string, init bool) (*FSStore, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to load FS certificate store (cause: %w)", err)
 	}
+	_, err = checkSchemaVersion(settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load FS certificate store (cause: %w)", err)
+	}
 	secret, err := security.Wrap(settings.Secret)
 	if err != nil {
 		return nil, fmt.Errorf("failed to wrap secret (cause: %w)", err)
@@ -107,29 +176,60 @@ func newFSStore(path string, init bool) (*FSStore, error) {
 		name:                    name,
 		path:                    absPath,
 		secret:                  secret,
-		entries:                 make([]string, 0),
+		index:                   &fsStoreIndex{entries: make([]string, 0), pending: make(map[string]struct{}), opened: time.Now()},
 		certificateCache:        ttlcache.New(certificateCacheOptions...),
 		certificateRequestCache: ttlcache.New(certificateRequestCacheOptions...),
 		revocationListCache:     ttlcache.New(revocationListCacheOptions...),
 		attributesCache:         ttlcache.New(attributesCacheOptions...),
 		logger:                  &logger,
 	}
-	err = store.scan()
+	for _, option := range options {
+		option(store)
+	}
+	err = store.lock()
+	if err != nil {
+		return nil, err
+	}
+	err = store.checkPermissions()
+	if err == nil {
+		err = store.scan()
+	}
+	if err == nil {
+		store.loadExpiryIndex()
+		err = store.openArchive()
+	}
+	if err == nil {
+		err = store.migrate(settings)
+	}
+	if err == nil {
+		err = store.resumeSecretRotation(settings)
+	}
 	if err != nil {
+		store.Close()
 		return nil, err
 	}
+	if store.warmCaches {
+		store.startWarming()
+	}
 	return store, nil
 }
 
 func initFSStore(path string) error {
-	settings := &fsStoreSettings{}
+	secret, err := generateSecret()
+	if err != nil {
+		return err
+	}
+	settings := &fsStoreSettings{SchemaVersion: storeSchemaVersion, Secret: secret}
+	return writeFSStoreSettings(path, settings)
+}
+
+func generateSecret() (string, error) {
 	secretBytes := make([]byte, 32)
 	_, err := rand.Read(secretBytes)
 	if err != nil {
-		return fmt.Errorf("failed to generate random secret (cause: %w)", err)
+		return "", fmt.Errorf("failed to generate random secret (cause: %w)", err)
 	}
-	settings.Secret = base64.StdEncoding.EncodeToString(secretBytes)
-	return writeFSStoreSettings(path, settings)
+	return base64.StdEncoding.EncodeToString(secretBytes), nil
 }
 
 func writeFSStoreSettings(path string, settings *fsStoreSettings) error {
@@ -141,6 +241,10 @@ func writeFSStoreSettings(path string, settings *fsStoreSettings) error {
 	if err != nil {
 		return fmt.Errorf("failed to create store directory '%s' (cause: %w)", path, err)
 	}
+	err = secureStoreDir(path)
+	if err != nil {
+		return err
+	}
 	file := filepath.Join(path, settingsFile)
 	err = os.WriteFile(file, settingsBytes, storeFilePerm)
 	if err != nil {
@@ -149,6 +253,25 @@ func writeFSStoreSettings(path string, settings *fsStoreSettings) error {
 	return nil
 }
 
+func updateFSStoreSettings(path string, settings *fsStoreSettings) error {
+	settingsBytes, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal store settings (cause: %w)", err)
+	}
+	file := filepath.Join(path, settingsFile)
+	updateFile := file + updateExtension
+	err = os.WriteFile(updateFile, settingsBytes, storeFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to write store settings file '%s' (cause: %w)", updateFile, err)
+	}
+	err = os.Rename(updateFile, file)
+	if err != nil {
+		os.Remove(updateFile)
+		return fmt.Errorf("failed to replace store settings file '%s' (cause: %w)", file, err)
+	}
+	return nil
+}
+
 func loadFSStoreSettings(path string) (*fsStoreSettings, error) {
 	file := filepath.Join(path, settingsFile)
 	settingsBytes, err := os.ReadFile(file)
@@ -163,14 +286,27 @@ func loadFSStoreSettings(path string) (*fsStoreSettings, error) {
 	return settings, nil
 }
 
+// Derive a store instance tagging all its log entries with the given correlation ID.
+//
+// The derived instance shares its entries and caches with the originating store. Only the originating
+// store holds the store lock and runs the cache warming; closing a derived instance has no effect.
+func (store *FSStore) WithCorrelationID(correlationID string) *FSStore {
+	logger := store.logger.With().Str(logging.CorrelationIDKey, correlationID).Logger()
+	derived := *store
+	derived.logger = &logger
+	derived.storeLock = nil
+	derived.warmer = nil
+	return &derived
+}
+
 func (store *FSStore) Name() string {
 	return store.name
 }
 
 func (store *FSStore) Entries() certs.StoreEntries {
-	store.lock.RLock()
-	defer store.lock.RUnlock()
-	entries := store.entries
+	store.index.lock.RLock()
+	defer store.index.lock.RUnlock()
+	entries := append([]string{}, store.index.entries...)
 	return &fsStoreEntries{
 		store:   store,
 		entries: entries,
@@ -198,8 +334,8 @@ func (storeEntries *fsStoreEntries) Next() certs.StoreEntry {
 }
 
 func (store *FSStore) Entry(name string) (certs.StoreEntry, error) {
-	store.lock.RLock()
-	defer store.lock.RUnlock()
+	store.index.lock.RLock()
+	defer store.index.lock.RUnlock()
 	exists := store.hasAttributes(name)
 	if !exists {
 		return nil, fs.ErrNotExist
@@ -207,51 +343,295 @@ func (store *FSStore) Entry(name string) (certs.StoreEntry, error) {
 	return store.newFSStoreEntry(name), nil
 }
 
-func (store *FSStore) CreateCertificate(name string, factory certs.CertificateFactory) (certs.StoreEntry, error) {
-	store.lock.Lock()
-	defer store.lock.Unlock()
-	files := store.newFileGroup(name, keyExtension, crtExtension, attributesExtension)
-	defer files.close()
-	keyFile, err := files.create(keyExtension)
+func (store *FSStore) CreateCertificate(ctx context.Context, name string, factory certs.CertificateFactory) (certs.StoreEntry, error) {
+	storeEntry, _, err := store.createCertificate(ctx, name, factory, true)
+	return storeEntry, err
+}
+
+// Create a certificate entry without persisting the generated key.
+//
+// The generated key is only returned to the caller and can not be retrieved from the store afterwards.
+func (store *FSStore) CreateCertificateWithoutKey(ctx context.Context, name string, factory certs.CertificateFactory) (certs.StoreEntry, crypto.PrivateKey, error) {
+	return store.createCertificate(ctx, name, factory, false)
+}
+
+// Replace the key and certificate of an existing store entry with newly generated ones.
+//
+// The entry's attributes are retained. Key and certificate files are replaced atomically one after the other.
+func (store *FSStore) ReplaceCertificate(ctx context.Context, name string, factory certs.CertificateFactory) (certs.StoreEntry, error) {
+	err := store.checkWritable()
 	if err != nil {
 		return nil, err
 	}
-	crtFile, err := files.create(crtExtension)
+	release, err := store.reserveEntry(name, func() error {
+		if !store.hasCertificate(name) {
+			return fmt.Errorf("failed to replace certificate of store entry '%s' (cause: %w)", name, fs.ErrNotExist)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	attributesFiles, err := files.create(attributesExtension)
+	defer release()
+	key, certificate, err := factory.New(ctx)
 	if err != nil {
 		return nil, err
 	}
-	attributes := &certs.StoreEntryAttributes{
-		Provider: factory.Name(),
+	if key != nil {
+		err = checkKeyMatch(name, key, certificate.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	store.index.lock.Lock()
+	defer store.index.lock.Unlock()
+	store.index.generation++
+	if !store.hasCertificate(name) {
+		return nil, fmt.Errorf("failed to replace certificate of store entry '%s' (cause: %w)", name, fs.ErrNotExist)
+	}
+	if key == nil && store.hasKey(name) {
+		var currentKey crypto.PrivateKey
+		currentKey, err = store.readKey(name)
+		if err == nil {
+			err = checkKeyMatch(name, currentKey, certificate.PublicKey)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if key != nil {
+		keyFilePath := store.entryPath(name, keyExtension)
+		err = store.replaceFile(keyFilePath, func(file *os.File) error {
+			return store.writeKey(name, file, key)
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
-	key, certificate, err := factory.New()
+	crtFilePath := store.entryPath(name, crtExtension)
+	err = store.replaceFile(crtFilePath, func(file *os.File) error {
+		return store.writeCertificate(name, file, certificate)
+	})
 	if err != nil {
 		return nil, err
 	}
-	err = store.writeKey(name, keyFile, key)
+	store.updateExpiry(name, certificate.NotAfter)
+	return store.newFSStoreEntry(name), nil
+}
+
+// Reserve the given entry name for a key and certificate generation running outside the index lock.
+//
+// Generating keys and contacting providers may take a long time and must not block concurrent readers and writers.
+// The check function is invoked with the index lock held. The returned function releases the reservation.
+func (store *FSStore) reserveEntry(name string, check func() error) (func(), error) {
+	store.index.lock.Lock()
+	defer store.index.lock.Unlock()
+	_, pending := store.index.pending[name]
+	if pending {
+		return nil, fmt.Errorf("failed to update store entry '%s' (cause: %w)", name, certs.ErrGenerationPending)
+	}
+	err := check()
 	if err != nil {
 		return nil, err
 	}
+	store.index.pending[name] = struct{}{}
+	return func() {
+		store.index.lock.Lock()
+		defer store.index.lock.Unlock()
+		delete(store.index.pending, name)
+	}, nil
+}
+
+// Replace (or add) the revocation list of an existing store entry.
+func (store *FSStore) UpdateRevocationList(name string, revocationList *x509.RevocationList) error {
+	err := store.checkWritable()
+	if err != nil {
+		return err
+	}
+	store.index.lock.Lock()
+	defer store.index.lock.Unlock()
+	store.index.generation++
+	if !store.hasAttributes(name) {
+		return fmt.Errorf("failed to update revocation list of store entry '%s' (cause: %w)", name, fs.ErrNotExist)
+	}
+	crlFilePath := store.entryPath(name, crlExtension)
+	return store.replaceFile(crlFilePath, func(file *os.File) error {
+		return store.writeRevocationList(name, file, revocationList)
+	})
+}
+
+func (store *FSStore) replaceFile(filePath string, write func(file *os.File) error) error {
+	updateFilePath := filePath + updateExtension
+	updateFile, err := os.OpenFile(updateFilePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, storeFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create file '%s' (cause: %w)", updateFilePath, err)
+	}
+	err = write(updateFile)
+	closeErr := updateFile.Close()
+	if err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close file '%s' (cause: %w)", updateFilePath, closeErr)
+	}
+	if err != nil {
+		os.Remove(updateFilePath)
+		return err
+	}
+	err = os.Rename(updateFilePath, filePath)
+	if err != nil {
+		os.Remove(updateFilePath)
+		return fmt.Errorf("failed to replace file '%s' (cause: %w)", filePath, err)
+	}
+	return nil
+}
+
+func (store *FSStore) createCertificate(ctx context.Context, name string, factory certs.CertificateFactory, storeKey bool) (certs.StoreEntry, crypto.PrivateKey, error) {
+	err := store.checkWritable()
+	if err != nil {
+		return nil, nil, err
+	}
+	release, err := store.reserveEntry(name, func() error {
+		return store.checkNewEntry(name)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+	attributes := newEntryAttributes(name, factory.Name())
+	key, certificate, err := factory.New(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key != nil {
+		err = checkKeyMatch(name, key, certificate.PublicKey)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if attributesFactory, ok := factory.(certs.CertificateAttributesFactory); ok {
+		attributesFactory.ApplyAttributes(attributes)
+	}
+	if storeKey {
+		attributes.Kind = certs.KindKeyPair
+	} else {
+		attributes.Kind = certificateKind(certificate)
+	}
+	store.index.lock.Lock()
+	defer store.index.lock.Unlock()
+	store.index.generation++
+	var files *fileGroup
+	if storeKey {
+		files = store.newFileGroup(name, keyExtension, crtExtension, attributesExtension)
+	} else {
+		files = store.newFileGroup(name, crtExtension, attributesExtension)
+	}
+	defer files.close()
+	var keyFile *os.File
+	if storeKey {
+		keyFile, err = files.create(keyExtension)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	crtFile, err := files.create(crtExtension)
+	if err != nil {
+		return nil, nil, err
+	}
+	attributesFiles, err := files.create(attributesExtension)
+	if err != nil {
+		return nil, nil, err
+	}
+	if storeKey {
+		err = store.writeKey(name, keyFile, key)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 	err = store.writeCertificate(name, crtFile, certificate)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	err = store.writeAttributes(name, attributesFiles, attributes)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	files.keep()
-	store.entries = append(store.entries, name)
-	sort.Strings(store.entries)
-	return store.newFSStoreEntry(name), nil
+	store.index.entries = append(store.index.entries, name)
+	sort.Strings(store.index.entries)
+	store.updateExpiry(name, certificate.NotAfter)
+	return store.newFSStoreEntry(name), key, nil
+}
+
+// Check whether a new entry with the given name can be created (the caller has to hold the index lock).
+func (store *FSStore) checkNewEntry(name string) error {
+	if store.hasAttributes(name) {
+		return fmt.Errorf("failed to create store entry '%s' (cause: %w)", name, fs.ErrExist)
+	}
+	return nil
+}
+
+// Update the attributes of an existing store entry.
+func (store *FSStore) UpdateAttributes(name string, update func(attributes *certs.StoreEntryAttributes)) error {
+	err := store.checkWritable()
+	if err != nil {
+		return err
+	}
+	store.index.lock.Lock()
+	defer store.index.lock.Unlock()
+	store.index.generation++
+	attributes, err := store.readAttributes(name)
+	if err != nil {
+		return err
+	}
+	updatedAttributes := *attributes
+	update(&updatedAttributes)
+	attributesFilePath := store.entryPath(name, attributesExtension)
+	store.logger.Info().Msgf("Updating attributes file '%s'...", attributesFilePath)
+	attributeBytes, err := store.encodeAttributes(name, &updatedAttributes)
+	if err != nil {
+		return err
+	}
+	updateFilePath := attributesFilePath + updateExtension
+	err = os.WriteFile(updateFilePath, attributeBytes, storeFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to write attributes file '%s' (cause: %w)", updateFilePath, err)
+	}
+	err = os.Rename(updateFilePath, attributesFilePath)
+	if err != nil {
+		os.Remove(updateFilePath)
+		return fmt.Errorf("failed to replace attributes file '%s' (cause: %w)", attributesFilePath, err)
+	}
+	store.attributesCache.Set(name, &updatedAttributes, ttlcache.NoTTL)
+	return nil
 }
 
-func (store *FSStore) CreateCertificateRequest(name string, factory certs.CertificateRequestFactory) (certs.StoreEntry, error) {
-	store.lock.Lock()
-	defer store.lock.Unlock()
+func (store *FSStore) CreateCertificateRequest(ctx context.Context, name string, factory certs.CertificateRequestFactory) (certs.StoreEntry, error) {
+	err := store.checkWritable()
+	if err != nil {
+		return nil, err
+	}
+	release, err := store.reserveEntry(name, func() error {
+		return store.checkNewEntry(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	attributes := newEntryAttributes(name, factory.Name())
+	attributes.Kind = certs.KindRequest
+	key, certificateRequest, err := factory.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = certificateRequest.CheckSignature()
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature for store entry '%s' (cause: %w)", name, err)
+	}
+	err = checkKeyMatch(name, key, certificateRequest.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	store.index.lock.Lock()
+	defer store.index.lock.Unlock()
+	store.index.generation++
 	files := store.newFileGroup(name, keyExtension, csrExtension, attributesExtension)
 	defer files.close()
 	keyFile, err := files.create(keyExtension)
@@ -266,13 +646,6 @@ func (store *FSStore) CreateCertificateRequest(name string, factory certs.Certif
 	if err != nil {
 		return nil, err
 	}
-	attributes := &certs.StoreEntryAttributes{
-		Provider: factory.Name(),
-	}
-	key, certificateRequest, err := factory.New()
-	if err != nil {
-		return nil, err
-	}
 	err = store.writeKey(name, keyFile, key)
 	if err != nil {
 		return nil, err
@@ -286,13 +659,126 @@ func (store *FSStore) CreateCertificateRequest(name string, factory certs.Certif
 		return nil, err
 	}
 	files.keep()
-	store.entries = append(store.entries, name)
-	sort.Strings(store.entries)
+	store.index.entries = append(store.index.entries, name)
+	sort.Strings(store.index.entries)
 	return store.newFSStoreEntry(name), nil
 }
 
+// Replace the trusted certificates imported from the given trust source.
+//
+// Trusted certificates are kept in a dedicated namespace separate from the store entries.
+func (store *FSStore) UpdateTrust(source string, certificates []*x509.Certificate) error {
+	if source == "" || strings.ContainsAny(source, "/\\") || strings.HasPrefix(source, ".") {
+		return fmt.Errorf("invalid trust source name '%s'", source)
+	}
+	err := store.checkWritable()
+	if err != nil {
+		return err
+	}
+	store.index.lock.Lock()
+	defer store.index.lock.Unlock()
+	store.index.generation++
+	trustPath := filepath.Join(store.path, trustDir)
+	err = os.MkdirAll(trustPath, storeDirPerm)
+	if err != nil {
+		return fmt.Errorf("failed to create trust directory '%s' (cause: %w)", trustPath, err)
+	}
+	var trustBytes bytes.Buffer
+	for _, certificate := range certificates {
+		err = pem.Encode(&trustBytes, &pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+		if err != nil {
+			return fmt.Errorf("failed to encode trusted certificate (cause: %w)", err)
+		}
+	}
+	trustFilePath := filepath.Join(trustPath, source+trustExtension)
+	store.logger.Info().Msgf("Writing trust file '%s' (%d certificates)...", trustFilePath, len(certificates))
+	updateFilePath := trustFilePath + updateExtension
+	err = os.WriteFile(updateFilePath, trustBytes.Bytes(), storeFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to write trust file '%s' (cause: %w)", updateFilePath, err)
+	}
+	err = os.Rename(updateFilePath, trustFilePath)
+	if err != nil {
+		os.Remove(updateFilePath)
+		return fmt.Errorf("failed to replace trust file '%s' (cause: %w)", trustFilePath, err)
+	}
+	return nil
+}
+
+// Get the trusted certificates of all trust sources (mapped by trust source name).
+func (store *FSStore) Trust() (map[string][]*x509.Certificate, error) {
+	store.index.lock.RLock()
+	defer store.index.lock.RUnlock()
+	trust := make(map[string][]*x509.Certificate)
+	trustPath := filepath.Join(store.path, trustDir)
+	trustFiles, err := os.ReadDir(trustPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return trust, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read trust directory '%s' (cause: %w)", trustPath, err)
+	}
+	for _, trustFile := range trustFiles {
+		if trustFile.IsDir() || filepath.Ext(trustFile.Name()) != trustExtension {
+			continue
+		}
+		certificates, err := certs.ReadCertificates(filepath.Join(trustPath, trustFile.Name()))
+		if err != nil {
+			return nil, err
+		}
+		trust[strings.TrimSuffix(trustFile.Name(), trustExtension)] = certificates
+	}
+	return trust, nil
+}
+
+// Derive a key of the given length for the given purpose from the store secret.
+//
+// The same purpose always results in the same key (as long as the store secret is not changed).
+func (store *FSStore) DeriveKey(purpose string, length int) ([]byte, error) {
+	return deriveKey(store.secret, purpose, length)
+}
+
+func deriveKey(secret *security.Secret, purpose string, length int) ([]byte, error) {
+	key := make([]byte, length)
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret.UnwrapBytes(), nil, []byte("certd:"+purpose)), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key for purpose '%s' (cause: %w)", purpose, err)
+	}
+	return key, nil
+}
+
+// Get the store's runtime diagnostics (entry count, scan timing and cache statistics).
+func (store *FSStore) Diagnostics() *FSStoreDiagnostics {
+	store.index.lock.RLock()
+	defer store.index.lock.RUnlock()
+	return &FSStoreDiagnostics{
+		Entries:      len(store.index.entries),
+		ScanDuration: store.index.scanDuration,
+		WarmDuration: store.warmDuration(),
+		Caches: map[string]FSStoreCacheDiagnostics{
+			"certificates":         newFSStoreCacheDiagnostics(store.certificateCache.Len(), store.certificateCache.Metrics()),
+			"certificate_requests": newFSStoreCacheDiagnostics(store.certificateRequestCache.Len(), store.certificateRequestCache.Metrics()),
+			"revocation_lists":     newFSStoreCacheDiagnostics(store.revocationListCache.Len(), store.revocationListCache.Metrics()),
+			"attributes":           newFSStoreCacheDiagnostics(store.attributesCache.Len(), store.attributesCache.Metrics()),
+		},
+	}
+}
+
+// Get the store's generation.
+//
+// The generation changes whenever entries are created, updated or removed as well as every time the store is
+// opened, hence it identifies the current state of the store's entries (e.g. to derive an ETag for the entry listing).
+func (store *FSStore) Generation() string {
+	store.index.lock.RLock()
+	defer store.index.lock.RUnlock()
+	return fmt.Sprintf("%x-%d", store.index.opened.UnixNano(), store.index.generation)
+}
+
 func (store *FSStore) scan() error {
 	store.logger.Info().Msg("Scanning...")
+	start := time.Now()
+	defer func() {
+		store.index.scanDuration = time.Since(start)
+	}()
 	pathInfo, err := os.Stat(store.path)
 	if err != nil {
 		return fmt.Errorf("failed to stat store path '%s' (cause: %w)", store.path, err)
@@ -300,51 +786,61 @@ func (store *FSStore) scan() error {
 	if !pathInfo.IsDir() {
 		return fmt.Errorf("store path '%s' is not a directory", store.path)
 	}
-	pathPerm := pathInfo.Mode().Perm()
-	if (pathPerm | storeDirPerm) != storeDirPerm {
-		store.logger.Warn().Msgf("Insecure permissions store path permissions %s", pathInfo.Mode())
-	}
 	err = fs.WalkDir(os.DirFS(store.path), ".", store.scanPath)
 	if err != nil {
 		return fmt.Errorf("failed to scan store path '%s' (cause: %w)", store.path, err)
 	}
+	// slugs may not reflect the order of the entry names
+	sort.Strings(store.index.entries)
 	return nil
 }
 
 func (store *FSStore) scanPath(current string, d fs.DirEntry, err error) error {
-	if current == "." || current == settingsFile {
+	if current == "." || current == settingsFile || current == lockFile || current == expiryFile || current == parseCacheFile {
 		return nil
 	}
+	if (current == trustDir || current == archiveDir) && d.IsDir() {
+		return fs.SkipDir
+	}
 	if d.IsDir() {
 		store.logger.Info().Msgf("Ignoring unrecognized directory '%s'", current)
 		return fs.SkipDir
 	}
-	var storeEntryName string
+	var storeEntryFile string
 	switch filepath.Ext(current) {
 	case keyExtension:
 		store.logger.Debug().Msgf("Found key file '%s'", current)
-		storeEntryName = strings.TrimSuffix(current, keyExtension)
+		storeEntryFile = strings.TrimSuffix(current, keyExtension)
 	case crtExtension:
 		store.logger.Debug().Msgf("Found certificate file '%s'", current)
-		storeEntryName = strings.TrimSuffix(current, crtExtension)
+		storeEntryFile = strings.TrimSuffix(current, crtExtension)
 	case csrExtension:
 		store.logger.Debug().Msgf("Found certificate request file '%s'", current)
-		storeEntryName = strings.TrimSuffix(current, csrExtension)
+		storeEntryFile = strings.TrimSuffix(current, csrExtension)
 	case crlExtension:
 		store.logger.Debug().Msgf("Found revocation list file '%s'", current)
-		storeEntryName = strings.TrimSuffix(current, crlExtension)
+		storeEntryFile = strings.TrimSuffix(current, crlExtension)
 	case attributesExtension:
 		store.logger.Debug().Msgf("Found attributes file '%s'", current)
-		storeEntryName = strings.TrimSuffix(current, attributesExtension)
+		storeEntryFile = strings.TrimSuffix(current, attributesExtension)
 	default:
 		store.logger.Info().Msgf("Ignoring unrecognized file '%s'", current)
 		return nil
 	}
-	last := len(store.entries) - 1
-	if last < 0 || store.entries[last] != storeEntryName {
-		if store.validateStoreEntry(storeEntryName) {
-			store.logger.Debug().Msgf("Adding store entry '%s'", storeEntryName)
-			store.entries = append(store.entries, storeEntryName)
+	last := len(store.index.entries) - 1
+	if last < 0 || store.entryFile(store.index.entries[last]) != storeEntryFile {
+		storeEntryName := store.resolveEntryName(storeEntryFile)
+		kind, valid := store.validateStoreEntry(storeEntryName)
+		if valid && !store.hasAttributes(storeEntryName) {
+			adoptErr := store.adoptStoreEntry(storeEntryName, kind)
+			if adoptErr != nil {
+				store.logger.Warn().Err(adoptErr).Msgf("Failed to adopt store entry '%s'", storeEntryName)
+				valid = false
+			}
+		}
+		if valid {
+			store.logger.Debug().Msgf("Adding store entry '%s' (%s)", storeEntryName, kind)
+			store.index.entries = append(store.index.entries, storeEntryName)
 		} else {
 			store.logger.Warn().Msgf("Ignoring unrelated file '%s'", current)
 		}
@@ -352,12 +848,94 @@ func (store *FSStore) scanPath(current string, d fs.DirEntry, err error) error {
 	return err
 }
 
-func (store *FSStore) validateStoreEntry(name string) bool {
+// Validate the files of the given store entry and determine its kind.
+//
+// Entries with attributes must provide the files required by the recorded kind (or any valid combination, if no
+// kind has been recorded). The kind of entries without attributes (e.g. a certificate or certificate request put
+// into the store path by an external tool) is derived from the files present.
+func (store *FSStore) validateStoreEntry(name string) (certs.StoreEntryKind, bool) {
 	hasKey := store.hasKey(name)
 	hasCertificate := store.hasCertificate(name)
 	hasCertificateRequest := store.hasCertificateRequest(name)
-	hasAttributes := store.hasAttributes(name)
-	return hasAttributes && (hasCertificate || (hasKey && hasCertificateRequest))
+	hasRevocationList := store.hasRevocationList(name)
+	var kind certs.StoreEntryKind
+	if store.hasAttributes(name) {
+		attributes, err := store.readAttributes(name)
+		if err == nil {
+			kind = attributes.Kind
+		}
+	} else {
+		kind = store.deriveEntryKind(name, hasKey, hasCertificate, hasCertificateRequest, hasRevocationList)
+	}
+	switch kind {
+	case certs.KindKeyPair:
+		return kind, hasKey && hasCertificate
+	case certs.KindCertificate, certs.KindTrustAnchor:
+		return kind, hasCertificate
+	case certs.KindRequest:
+		return kind, hasCertificateRequest
+	case certs.KindCRL:
+		return kind, hasRevocationList
+	case "":
+		return kind, store.hasAttributes(name) && (hasCertificate || (hasKey && hasCertificateRequest))
+	}
+	return kind, false
+}
+
+func (store *FSStore) deriveEntryKind(name string, hasKey bool, hasCertificate bool, hasCertificateRequest bool, hasRevocationList bool) certs.StoreEntryKind {
+	switch {
+	case hasCertificate && hasKey:
+		return certs.KindKeyPair
+	case hasCertificate:
+		certificate, err := store.readCertificate(name)
+		if err != nil {
+			return ""
+		}
+		return certificateKind(certificate)
+	case hasCertificateRequest:
+		return certs.KindRequest
+	case hasRevocationList && !hasKey:
+		return certs.KindCRL
+	}
+	return ""
+}
+
+// Record the attributes of a store entry found without attributes during the store scan.
+func (store *FSStore) adoptStoreEntry(name string, kind certs.StoreEntryKind) error {
+	err := store.checkWritable()
+	if err != nil {
+		return err
+	}
+	attributes := newEntryAttributes(name, imported.ProviderName)
+	attributes.Kind = kind
+	attributeBytes, err := store.encodeAttributes(name, attributes)
+	if err != nil {
+		return err
+	}
+	attributesFilePath := store.entryPath(name, attributesExtension)
+	store.logger.Info().Msgf("Adopting store entry '%s' (%s)...", name, kind)
+	err = os.WriteFile(attributesFilePath, attributeBytes, storeFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to write attributes file '%s' (cause: %w)", attributesFilePath, err)
+	}
+	store.attributesCache.Set(name, attributes, ttlcache.NoTTL)
+	return nil
+}
+
+// Determine the kind of a certificate entry without key.
+func certificateKind(certificate *x509.Certificate) certs.StoreEntryKind {
+	if certificate.IsCA && bytes.Equal(certificate.RawSubject, certificate.RawIssuer) && certificate.CheckSignatureFrom(certificate) == nil {
+		return certs.KindTrustAnchor
+	}
+	return certs.KindCertificate
+}
+
+// Verify the key to store belongs to the certificate (request) it is stored with.
+func checkKeyMatch(name string, key crypto.PrivateKey, publicKey crypto.PublicKey) error {
+	if !certs.KeyMatches(key, publicKey) {
+		return &certs.KeyMismatchError{Name: name}
+	}
+	return nil
 }
 
 func (store *FSStore) writeKey(name string, file *os.File, key crypto.PrivateKey) error {
@@ -378,13 +956,13 @@ func (store *FSStore) writeKey(name string, file *os.File, key crypto.PrivateKey
 }
 
 func (store *FSStore) hasKey(name string) bool {
-	keyFilePath := filepath.Join(store.path, name+keyExtension)
+	keyFilePath := store.entryPath(name, keyExtension)
 	_, err := os.Stat(keyFilePath)
 	return err == nil
 }
 
 func (store *FSStore) readKey(name string) (crypto.PrivateKey, error) {
-	keyFilePath := filepath.Join(store.path, name+keyExtension)
+	keyFilePath := store.entryPath(name, keyExtension)
 	store.logger.Info().Msgf("Reading key file '%s'...", keyFilePath)
 	keyFileBytes, err := os.ReadFile(keyFilePath)
 	if err != nil {
@@ -426,13 +1004,13 @@ func (store *FSStore) writeCertificate(name string, file *os.File, certificate *
 }
 
 func (store *FSStore) hasCertificate(name string) bool {
-	crtFilePath := filepath.Join(store.path, name+crtExtension)
+	crtFilePath := store.entryPath(name, crtExtension)
 	_, err := os.Stat(crtFilePath)
 	return err == nil
 }
 
 func (store *FSStore) readCertificate(name string) (*x509.Certificate, error) {
-	crtFilePath := filepath.Join(store.path, name+crtExtension)
+	crtFilePath := store.entryPath(name, crtExtension)
 	cached := store.certificateCache.Get(name)
 	if cached != nil {
 		store.logger.Debug().Msgf("Using cached certificate file '%s'...", crtFilePath)
@@ -467,7 +1045,11 @@ func (store *FSStore) writeCertificateRequest(name string, file *os.File, certif
 		Type:  "CERTIFICATE REQUEST",
 		Bytes: certificateRequest.Raw,
 	}
-	err := pem.Encode(file, pemBlock)
+	csrBytes, err := store.sealEntryFile(store.entryFile(name)+csrExtension, pem.EncodeToMemory(pemBlock))
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(csrBytes)
 	if err != nil {
 		return fmt.Errorf("failed to encode or write certificate request (cause: %w)", err)
 	}
@@ -476,13 +1058,13 @@ func (store *FSStore) writeCertificateRequest(name string, file *os.File, certif
 }
 
 func (store *FSStore) hasCertificateRequest(name string) bool {
-	csrFilePath := filepath.Join(store.path, name+csrExtension)
+	csrFilePath := store.entryPath(name, csrExtension)
 	_, err := os.Stat(csrFilePath)
 	return err == nil
 }
 
 func (store *FSStore) readCertificateRequest(name string) (*x509.CertificateRequest, error) {
-	csrFilePath := filepath.Join(store.path, name+csrExtension)
+	csrFilePath := store.entryPath(name, csrExtension)
 	cached := store.certificateRequestCache.Get(name)
 	if cached != nil {
 		store.logger.Debug().Msgf("Using cached certificate request file '%s'...", csrFilePath)
@@ -496,6 +1078,10 @@ func (store *FSStore) readCertificateRequest(name string) (*x509.CertificateRequ
 		}
 		return nil, fmt.Errorf("failed to read certificate request file '%s' (cause: %w)", csrFilePath, err)
 	}
+	csrFileBytes, err = store.openEntryFile(store.entryFile(name)+csrExtension, csrFileBytes)
+	if err != nil {
+		return nil, err
+	}
 	pemBlock, rest := pem.Decode(csrFileBytes)
 	if pemBlock == nil {
 		return nil, fmt.Errorf("failed to decode certificate request file '%s'", csrFilePath)
@@ -526,13 +1112,13 @@ func (store *FSStore) writeRevocationList(name string, file *os.File, revocation
 }
 
 func (store *FSStore) hasRevocationList(name string) bool {
-	crlFilePath := filepath.Join(store.path, name+crlExtension)
+	crlFilePath := store.entryPath(name, crlExtension)
 	_, err := os.Stat(crlFilePath)
 	return err == nil
 }
 
 func (store *FSStore) readRevocationList(name string) (*x509.RevocationList, error) {
-	crlFilePath := filepath.Join(store.path, name+crlExtension)
+	crlFilePath := store.entryPath(name, crlExtension)
 	cached := store.revocationListCache.Get(name)
 	if cached != nil {
 		store.logger.Debug().Msgf("Using cached revocation list file '%s'...", crlFilePath)
@@ -563,9 +1149,9 @@ func (store *FSStore) readRevocationList(name string) (*x509.RevocationList, err
 
 func (store *FSStore) writeAttributes(name string, file *os.File, attributes *certs.StoreEntryAttributes) error {
 	store.logger.Info().Msgf("Writing attributes file '%s'...", file.Name())
-	attributeBytes, err := json.MarshalIndent(attributes, "", "  ")
+	attributeBytes, err := store.encodeAttributes(name, attributes)
 	if err != nil {
-		return fmt.Errorf("failed to marshal attributes (cause: %w)", err)
+		return err
 	}
 	_, err = file.Write(attributeBytes)
 	if err != nil {
@@ -575,14 +1161,22 @@ func (store *FSStore) writeAttributes(name string, file *os.File, attributes *ce
 	return nil
 }
 
+func (store *FSStore) encodeAttributes(name string, attributes *certs.StoreEntryAttributes) ([]byte, error) {
+	attributeBytes, err := json.MarshalIndent(attributes, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal attributes (cause: %w)", err)
+	}
+	return store.sealEntryFile(store.entryFile(name)+attributesExtension, attributeBytes)
+}
+
 func (store *FSStore) hasAttributes(name string) bool {
-	attributesFilePath := filepath.Join(store.path, name+attributesExtension)
+	attributesFilePath := store.entryPath(name, attributesExtension)
 	_, err := os.Stat(attributesFilePath)
 	return err == nil
 }
 
 func (store *FSStore) readAttributes(name string) (*certs.StoreEntryAttributes, error) {
-	attributesFilePath := filepath.Join(store.path, name+attributesExtension)
+	attributesFilePath := store.entryPath(name, attributesExtension)
 	cached := store.attributesCache.Get(name)
 	if cached != nil {
 		store.logger.Debug().Msgf("Using cached attributes file '%s'...", attributesFilePath)
@@ -593,6 +1187,10 @@ func (store *FSStore) readAttributes(name string) (*certs.StoreEntryAttributes,
 	if err != nil {
 		return nil, fmt.Errorf("failed to read attributes file '%s' (cause: %w)", attributesFilePath, err)
 	}
+	attributesBytes, err = store.openEntryFile(store.entryFile(name)+attributesExtension, attributesBytes)
+	if err != nil {
+		return nil, err
+	}
 	attributes := &certs.StoreEntryAttributes{}
 	err = json.Unmarshal(attributesBytes, attributes)
 	if err != nil {
@@ -661,7 +1259,7 @@ func (storeEntry *fsStoreEntry) Attributes() (*certs.StoreEntryAttributes, error
 func (store *FSStore) newFileGroup(name string, extensions ...string) *fileGroup {
 	files := make(map[string]*os.File, len(extensions))
 	for _, extension := range extensions {
-		file := filepath.Join(store.path, name+extension)
+		file := store.entryPath(name, extension)
 		files[file] = nil
 	}
 	return &fileGroup{
@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fsstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hdecarne-github/certd/pkg/certs/local"
+	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+// Store sizes used by the benchmarks (below and above the certificate cache capacity)
+var benchmarkEntryCounts = []int{50, 500}
+
+func BenchmarkOpen(b *testing.B) {
+	for _, entryCount := range benchmarkEntryCounts {
+		b.Run(fmt.Sprintf("entries=%d", entryCount), func(b *testing.B) {
+			storePath := prepareBenchmarkStore(b, entryCount)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				store, err := Open(storePath)
+				require.NoError(b, err)
+				require.NoError(b, store.Close())
+			}
+		})
+	}
+}
+
+func BenchmarkEntries(b *testing.B) {
+	for _, entryCount := range benchmarkEntryCounts {
+		b.Run(fmt.Sprintf("entries=%d", entryCount), func(b *testing.B) {
+			store := openBenchmarkStore(b, prepareBenchmarkStore(b, entryCount))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				storeEntries := store.Entries()
+				for {
+					storeEntry := storeEntries.Next()
+					if storeEntry == nil {
+						break
+					}
+					_, err := storeEntry.Certificate()
+					require.NoError(b, err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCertificate(b *testing.B) {
+	store := openBenchmarkStore(b, prepareBenchmarkStore(b, 1))
+	entry, err := store.Entry(benchmarkEntryName(0))
+	require.NoError(b, err)
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := entry.Certificate()
+			require.NoError(b, err)
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			store.certificateCache.DeleteAll()
+			_, err := entry.Certificate()
+			require.NoError(b, err)
+		}
+	})
+}
+
+func BenchmarkCreateCertificate(b *testing.B) {
+	store := openBenchmarkStore(b, prepareBenchmarkStore(b, 0))
+	lcf := local.NewLocalCertificateFactory(localCATemplate, ecdsa.StandardKeys()[0], nil, nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := store.CreateCertificate(context.Background(), benchmarkEntryName(i), lcf)
+		require.NoError(b, err)
+	}
+}
+
+func prepareBenchmarkStore(b *testing.B, entryCount int) string {
+	quietBenchmark(b)
+	home, err := os.MkdirTemp("", "store*")
+	require.NoError(b, err)
+	b.Cleanup(func() {
+		os.RemoveAll(home)
+	})
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(b, err)
+	lcf := local.NewLocalCertificateFactory(localCATemplate, ecdsa.StandardKeys()[0], nil, nil)
+	for i := 0; i < entryCount; i++ {
+		_, err = store.CreateCertificate(context.Background(), benchmarkEntryName(i), lcf)
+		require.NoError(b, err)
+	}
+	require.NoError(b, store.Close())
+	return storePath
+}
+
+func openBenchmarkStore(b *testing.B, storePath string) *FSStore {
+	store, err := Open(storePath)
+	require.NoError(b, err)
+	b.Cleanup(func() {
+		store.Close()
+	})
+	return store
+}
+
+func benchmarkEntryName(i int) string {
+	return fmt.Sprintf("entry%d", i)
+}
+
+// Suppress the per operation logging (dominating the measurements otherwise).
+func quietBenchmark(b *testing.B) {
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	b.Cleanup(func() {
+		zerolog.SetGlobalLevel(level)
+	})
+}
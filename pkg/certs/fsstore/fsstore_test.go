@@ -18,8 +18,16 @@
 package fsstore
 
 import (
+	"bytes"
+	"context"
+	"crypto"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
 	"math/big"
 	"os"
 	"path/filepath"
@@ -27,7 +35,11 @@ import (
 	"time"
 
 	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/internal/security"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/certs/imported"
 	"github.com/hdecarne-github/certd/pkg/certs/local"
+	"github.com/hdecarne-github/certd/pkg/certs/remote"
 	"github.com/hdecarne-github/certd/pkg/keys"
 	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
 	"github.com/hdecarne-github/certd/pkg/keys/ed25519"
@@ -54,10 +66,16 @@ func TestCreateAndOpenFSStore(t *testing.T) {
 	store3, err := Init(storePath)
 	require.Error(t, err)
 	require.Nil(t, store3)
-	// open existing store
+	// try to open locked store
 	store4, err := Open(storePath)
+	require.ErrorIs(t, err, ErrStoreLocked)
+	require.Nil(t, store4)
+	require.NoError(t, store2.Close())
+	// open existing store
+	store5, err := Open(storePath)
 	require.NoError(t, err)
-	require.NotNil(t, store4)
+	require.NotNil(t, store5)
+	require.NoError(t, store5.Close())
 }
 
 func TestCreateLocalCertificateRSA(t *testing.T) {
@@ -90,6 +108,992 @@ func TestCreateLocalCertificateED25519(t *testing.T) {
 	require.Equal(t, 2, entryCount)
 }
 
+func TestCreateCertificateWithoutKey(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	kpf := ecdsa.StandardKeys()[0]
+	lcf := local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil)
+	entry, key, err := store.CreateCertificateWithoutKey(context.Background(), kpf.Name(), lcf)
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	require.NotNil(t, key)
+	require.False(t, entry.HasKey())
+	require.True(t, entry.HasCertificate())
+	require.NoError(t, store.Close())
+	store = openStore(t, storePath)
+	entryCount := traverseStoreEntries(t, store)
+	require.Equal(t, 1, entryCount)
+}
+
+type attestedCertificateFactory struct {
+	certs.CertificateFactory
+}
+
+func (factory *attestedCertificateFactory) ApplyAttributes(attributes *certs.StoreEntryAttributes) {
+	attributes.Attestation = &certs.StoreEntryAttestation{Verified: true, Subject: "CN=Attestation"}
+	attributes.Kind = certs.KindRequest
+}
+
+func TestCreateCertificateWithAttributes(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	kpf := ecdsa.StandardKeys()[0]
+	lcf := &attestedCertificateFactory{CertificateFactory: local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil)}
+	entry, _, err := store.CreateCertificateWithoutKey(context.Background(), kpf.Name(), lcf)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	store = openStore(t, storePath)
+	entry, err = store.Entry(entry.Name())
+	require.NoError(t, err)
+	attributes, err := entry.Attributes()
+	require.NoError(t, err)
+	require.Equal(t, &certs.StoreEntryAttestation{Verified: true, Subject: "CN=Attestation"}, attributes.Attestation)
+	// the entry kind is determined by the store
+	require.NotEqual(t, certs.KindRequest, attributes.Kind)
+}
+
+func TestCreateCertificateCancelled(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	defer store.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	lcf := local.NewLocalCertificateFactory(localCATemplate, rsa.StandardKeys()[0], nil, nil)
+	_, err = store.CreateCertificate(ctx, "cancelled", lcf)
+	require.ErrorIs(t, err, context.Canceled)
+	_, err = store.Entry("cancelled")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.Equal(t, 0, traverseStoreEntries(t, store))
+}
+
+func TestEntryKinds(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	kpf := ecdsa.StandardKeys()[0]
+	_, err = store.CreateCertificate(context.Background(), "keypair", local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+	require.NoError(t, err)
+	_, _, err = store.CreateCertificateWithoutKey(context.Background(), "anchor", local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+	require.NoError(t, err)
+	_, err = store.CreateCertificateRequest(context.Background(), "request", remote.NewLocalCertificateRequestFactory(&x509.CertificateRequest{Subject: pkix.Name{CommonName: "request"}}, kpf))
+	require.NoError(t, err)
+	requireEntryKind(t, store, "keypair", certs.KindKeyPair)
+	requireEntryKind(t, store, "anchor", certs.KindTrustAnchor)
+	requireEntryKind(t, store, "request", certs.KindRequest)
+	require.NoError(t, store.Close())
+	// files put into the store by external tools
+	for source, target := range map[string]string{"anchor" + crtExtension: "external" + crtExtension, "request" + csrExtension: "external-request" + csrExtension, "keypair" + keyExtension: "lost" + keyExtension} {
+		fileBytes, err := os.ReadFile(filepath.Join(storePath, source))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(storePath, target), fileBytes, storeFilePerm))
+	}
+	store = openStore(t, storePath)
+	require.Equal(t, 5, traverseStoreEntries(t, store))
+	requireEntryKind(t, store, "external", certs.KindTrustAnchor)
+	requireEntryKind(t, store, "external-request", certs.KindRequest)
+	entry, err := store.Entry("external-request")
+	require.NoError(t, err)
+	require.False(t, entry.HasKey())
+	attributes, err := entry.Attributes()
+	require.NoError(t, err)
+	require.Equal(t, imported.ProviderName, attributes.Provider)
+	_, err = store.Entry("lost")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.NoError(t, store.Close())
+	// recorded kind requires the corresponding files
+	require.NoError(t, os.Remove(filepath.Join(storePath, "keypair"+keyExtension)))
+	store = openStore(t, storePath)
+	defer store.Close()
+	require.Equal(t, 4, traverseStoreEntries(t, store))
+}
+
+func requireEntryKind(t *testing.T, store *FSStore, name string, kind certs.StoreEntryKind) {
+	entry, err := store.Entry(name)
+	require.NoError(t, err)
+	attributes, err := entry.Attributes()
+	require.NoError(t, err)
+	require.Equal(t, kind, attributes.Kind)
+}
+
+func TestMigration(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	kpf := ecdsa.StandardKeys()[0]
+	_, err = store.CreateCertificate(context.Background(), "entry", local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	// downgrade to schema version 1
+	settings, err := loadFSStoreSettings(storePath)
+	require.NoError(t, err)
+	require.Equal(t, storeSchemaVersion, settings.SchemaVersion)
+	settings.SchemaVersion = 0
+	require.NoError(t, updateFSStoreSettings(storePath, settings))
+	attributesBytes, err := json.Marshal(&certs.StoreEntryAttributes{Provider: local.ProviderName})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(storePath, "entry"+attributesExtension), attributesBytes, storeFilePerm))
+	store = openStore(t, storePath)
+	require.Equal(t, 1, traverseStoreEntries(t, store))
+	entry, err := store.Entry("entry")
+	require.NoError(t, err)
+	attributes, err := entry.Attributes()
+	require.NoError(t, err)
+	require.Equal(t, storeSchemaVersion, attributes.SchemaVersion)
+	require.Equal(t, certs.KindKeyPair, attributes.Kind)
+	require.NoError(t, store.Close())
+	settings, err = loadFSStoreSettings(storePath)
+	require.NoError(t, err)
+	require.Equal(t, storeSchemaVersion, settings.SchemaVersion)
+	// reject stores written by newer versions
+	settings.SchemaVersion = storeSchemaVersion + 1
+	require.NoError(t, updateFSStoreSettings(storePath, settings))
+	_, err = Open(storePath)
+	require.Error(t, err)
+}
+
+func TestUpdateAttributes(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	kpf := ecdsa.StandardKeys()[0]
+	lcf := local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil)
+	_, err = store.CreateCertificate(context.Background(), kpf.Name(), lcf)
+	require.NoError(t, err)
+	generation := store.Generation()
+	err = store.UpdateAttributes(kpf.Name(), func(attributes *certs.StoreEntryAttributes) {
+		attributes.Attestation = &certs.StoreEntryAttestation{Verified: true}
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, generation, store.Generation())
+	generation = store.Generation()
+	require.NoError(t, store.Close())
+	store = openStore(t, storePath)
+	require.NotEqual(t, generation, store.Generation())
+	entry, err := store.Entry(kpf.Name())
+	require.NoError(t, err)
+	attributes, err := entry.Attributes()
+	require.NoError(t, err)
+	require.Equal(t, local.ProviderName, attributes.Provider)
+	require.NotNil(t, attributes.Attestation)
+	require.True(t, attributes.Attestation.Verified)
+	require.Error(t, store.UpdateAttributes("unknown", func(attributes *certs.StoreEntryAttributes) {}))
+}
+
+func TestEntryNames(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath, WithEntryEncryption())
+	require.NoError(t, err)
+	kpf := ed25519.StandardKeys()[0]
+	names := []string{"CN=Test CA/O=Example:Org", "zertifikat-äöü", "..", "plain.example.org", "Plain.example.org", "trailing.", "con", "Lpt1.example"}
+	for _, name := range names {
+		_, err = store.CreateCertificate(context.Background(), name, local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+		require.NoError(t, err)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(storePath, "légacy"+crtExtension), []byte{}, storeFilePerm))
+	require.NoError(t, os.WriteFile(filepath.Join(storePath, "légacy"+attributesExtension), []byte("{}"), storeFilePerm))
+	_, err = os.Stat(filepath.Join(storePath, "plain.example.org"+crtExtension))
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	store = openStore(t, storePath)
+	files, err := os.ReadDir(storePath)
+	require.NoError(t, err)
+	for _, file := range files {
+		require.NotContains(t, file.Name(), ":")
+		require.NotContains(t, file.Name(), "ä")
+		require.NotContains(t, file.Name(), "P")
+		require.False(t, isReservedName(file.Name()))
+		require.NotEqual(t, "trailing."+crtExtension, file.Name())
+	}
+	require.Equal(t, len(names)+1, traverseStoreEntries(t, store))
+	for _, name := range names {
+		entry, err := store.Entry(name)
+		require.NoError(t, err)
+		require.Equal(t, name, entry.Name())
+		certificate, err := entry.Certificate()
+		require.NoError(t, err)
+		require.NotNil(t, certificate)
+	}
+	entry, err := store.Entry("légacy")
+	require.NoError(t, err)
+	require.True(t, entry.HasCertificate())
+	_, err = store.Entry("../" + storeHome + "/plain.example.org")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestCollectGarbage(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	defer store.Close()
+	kpf := ed25519.StandardKeys()[0]
+	_, err = store.CreateCertificate(context.Background(), "entry", local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+	require.NoError(t, err)
+	for _, file := range []string{"lost.key", "entry.json" + updateExtension, "README"} {
+		require.NoError(t, os.WriteFile(filepath.Join(storePath, file), []byte{}, storeFilePerm))
+	}
+	orphans, err := store.CollectGarbage(false)
+	require.NoError(t, err)
+	require.Equal(t, []FSStoreOrphan{
+		{File: "README", Reason: OrphanUnrecognized},
+		{File: "entry.json" + updateExtension, Reason: OrphanTemporary, Removable: true},
+		{File: "lost.key", Reason: OrphanUnrelated, Removable: true},
+	}, orphans)
+	orphans, err = store.CollectGarbage(true)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(orphans))
+	require.False(t, orphans[0].Removed)
+	require.True(t, orphans[1].Removed)
+	require.True(t, orphans[2].Removed)
+	require.FileExists(t, filepath.Join(storePath, "README"))
+	require.NoFileExists(t, filepath.Join(storePath, "lost.key"))
+	orphans, err = store.CollectGarbage(false)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(orphans))
+	require.Equal(t, 1, traverseStoreEntries(t, store))
+}
+
+func TestArchive(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath, WithEntryEncryption())
+	require.NoError(t, err)
+	kpf := ed25519.StandardKeys()[0]
+	for _, name := range []string{"entry", "CN=Archived/O=Example"} {
+		_, err = store.CreateCertificate(context.Background(), name, local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+		require.NoError(t, err)
+		require.NoError(t, store.ArchiveEntry(name))
+	}
+	require.ErrorIs(t, store.ArchiveEntry("entry"), fs.ErrNotExist)
+	require.Equal(t, 0, traverseStoreEntries(t, store))
+	require.NoError(t, store.Close())
+	store = openStore(t, storePath)
+	require.Equal(t, 0, traverseStoreEntries(t, store))
+	archivedEntry, err := store.ArchivedEntry("CN=Archived/O=Example")
+	require.NoError(t, err)
+	attributes, err := archivedEntry.Attributes()
+	require.NoError(t, err)
+	require.NotNil(t, attributes.Archived)
+	require.NoError(t, store.RestoreEntry("CN=Archived/O=Example"))
+	entry, err := store.Entry("CN=Archived/O=Example")
+	require.NoError(t, err)
+	attributes, err = entry.Attributes()
+	require.NoError(t, err)
+	require.Nil(t, attributes.Archived)
+	require.True(t, entry.HasKey())
+	require.NoError(t, store.PurgeEntry("entry"))
+	require.ErrorIs(t, store.PurgeEntry("entry"), fs.ErrNotExist)
+	archivedEntries := store.ArchivedEntries()
+	require.Nil(t, archivedEntries.Next())
+	orphans, err := store.CollectGarbage(false)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(orphans))
+}
+
+func TestArchiveRollback(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	defer store.Close()
+	kpf := ed25519.StandardKeys()[0]
+	_, err = store.CreateCertificate(context.Background(), "entry", local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+	require.NoError(t, err)
+	// a non-empty directory in place of the archived certificate file fails the move after the key has been moved
+	blocker := store.archive.entryPath("entry", crtExtension)
+	require.NoError(t, os.MkdirAll(blocker, storeDirPerm))
+	require.NoError(t, os.WriteFile(filepath.Join(blocker, "blocker"), []byte{}, storeFilePerm))
+	require.Error(t, store.ArchiveEntry("entry"))
+	for _, extension := range []string{keyExtension, crtExtension, attributesExtension} {
+		require.FileExists(t, store.entryPath("entry", extension))
+	}
+	require.NoFileExists(t, store.archive.entryPath("entry", keyExtension))
+	require.NoFileExists(t, store.archive.entryPath("entry", attributesExtension))
+	entry, err := store.Entry("entry")
+	require.NoError(t, err)
+	require.True(t, entry.HasKey())
+	require.Nil(t, store.ArchivedEntries().Next())
+	require.NoError(t, os.RemoveAll(blocker))
+	require.NoError(t, store.ArchiveEntry("entry"))
+	require.Equal(t, 0, traverseStoreEntries(t, store))
+}
+
+func TestExpiryIndex(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	kpf := ed25519.StandardKeys()[0]
+	now := time.Now().Truncate(time.Second)
+	for i, name := range []string{"entry3", "entry1", "entry2"} {
+		template := *localCATemplate
+		template.NotAfter = now.AddDate(0, 0, 3-i)
+		_, err = store.CreateCertificate(context.Background(), name, local.NewLocalCertificateFactory(&template, kpf, nil, nil))
+		require.NoError(t, err)
+	}
+	requireExpiries(t, []string{"entry2", "entry1", "entry3"}, store.Expiries(time.Time{}, now.AddDate(1, 0, 0)))
+	requireExpiries(t, []string{"entry2"}, store.Expiries(now, now.AddDate(0, 0, 1)))
+	require.Equal(t, "entry1", store.NextExpiry(now.AddDate(0, 0, 1)).Name)
+	require.Nil(t, store.NextExpiry(now.AddDate(0, 0, 3)))
+	require.NoError(t, store.ArchiveEntry("entry2"))
+	requireExpiries(t, []string{"entry1", "entry3"}, store.Expiries(time.Time{}, now.AddDate(1, 0, 0)))
+	require.NoError(t, store.Close())
+	// persisted index
+	store = openStore(t, storePath)
+	requireExpiries(t, []string{"entry1", "entry3"}, store.Expiries(time.Time{}, now.AddDate(1, 0, 0)))
+	require.NoError(t, store.RestoreEntry("entry2"))
+	requireExpiries(t, []string{"entry2", "entry1", "entry3"}, store.Expiries(time.Time{}, now.AddDate(1, 0, 0)))
+	require.NoError(t, store.Close())
+	// rebuilt index
+	require.NoError(t, os.Remove(filepath.Join(storePath, expiryFile)))
+	store = openStore(t, storePath)
+	defer store.Close()
+	requireExpiries(t, []string{"entry2", "entry1", "entry3"}, store.Expiries(time.Time{}, now.AddDate(1, 0, 0)))
+	require.FileExists(t, filepath.Join(storePath, expiryFile))
+}
+
+func requireExpiries(t *testing.T, expected []string, expiries []FSStoreExpiry) {
+	names := make([]string, 0, len(expiries))
+	for _, expiry := range expiries {
+		names = append(names, expiry.Name)
+	}
+	require.Equal(t, expected, names)
+}
+
+func TestCacheWarming(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	createLocalCertficate(t, storePath, ecdsa.StandardKeys())
+	// initial warming creates parse cache
+	store := openWarmedStore(t, storePath)
+	require.Equal(t, 8, store.certificateCache.Len())
+	require.Equal(t, 8, store.attributesCache.Len())
+	require.NoError(t, store.Close())
+	parsed := readTestParseCache(t, storePath)
+	require.Len(t, parsed, 8)
+	// unchanged certificate files are taken from the parse cache
+	swapped := parsed[1].Certificate
+	parsed[1].Certificate = parsed[0].Certificate
+	writeTestParseCache(t, storePath, parsed)
+	store = openWarmedStore(t, storePath)
+	entry, err := store.Entry(parsed[1].Name)
+	require.NoError(t, err)
+	certificate, err := entry.Certificate()
+	require.NoError(t, err)
+	require.Equal(t, parsed[0].Certificate, certificate.Raw)
+	require.NoError(t, store.Close())
+	// changed certificate files are read again
+	modTime := time.Unix(0, parsed[1].ModTime).Add(time.Second)
+	require.NoError(t, os.Chtimes(store.entryPath(parsed[1].Name, crtExtension), modTime, modTime))
+	store = openWarmedStore(t, storePath)
+	entry, err = store.Entry(parsed[1].Name)
+	require.NoError(t, err)
+	certificate, err = entry.Certificate()
+	require.NoError(t, err)
+	require.Equal(t, swapped, certificate.Raw)
+}
+
+func TestCacheWarmingDerivedStore(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	createLocalCertficate(t, storePath, ecdsa.StandardKeys())
+	store, err := Open(storePath, WithCacheCapacity(1000), WithCacheWarming())
+	require.NoError(t, err)
+	defer store.Close()
+	warmer := store.warmer
+	// closing a derived store must not stop the originating store's warming
+	require.NoError(t, store.WithCorrelationID("derived").Close())
+	select {
+	case <-warmer.stop:
+		t.Fatal("cache warming stopped by derived store")
+	default:
+	}
+	require.Eventually(t, func() bool {
+		return store.Diagnostics().WarmDuration > 0
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestCacheWarmingConcurrentUpdates(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	createLocalCertficate(t, storePath, ecdsa.StandardKeys())
+	store, err := Open(storePath, WithCacheCapacity(1000), WithCacheWarming())
+	require.NoError(t, err)
+	diagnostics := make(chan struct{})
+	go func() {
+		defer close(diagnostics)
+		for i := 0; i < 100; i++ {
+			store.Diagnostics()
+		}
+	}()
+	kpf := ed25519.StandardKeys()[0]
+	for i := 0; i < 8; i++ {
+		_, err = store.CreateCertificate(context.Background(), fmt.Sprintf("concurrent%d", i), local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+		require.NoError(t, err)
+	}
+	require.NoError(t, store.Close())
+	<-diagnostics
+	require.Zero(t, store.Diagnostics().WarmDuration)
+}
+
+func openWarmedStore(t *testing.T, path string) *FSStore {
+	store, err := Open(path, WithCacheCapacity(1000), WithCacheWarming())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		store.Close()
+	})
+	require.Eventually(t, func() bool {
+		return store.Diagnostics().WarmDuration > 0
+	}, 10*time.Second, 10*time.Millisecond)
+	return store
+}
+
+func readTestParseCache(t *testing.T, path string) []fsStoreParsedCertificate {
+	parseCacheBytes, err := os.ReadFile(filepath.Join(path, parseCacheFile))
+	require.NoError(t, err)
+	var parsed []fsStoreParsedCertificate
+	require.NoError(t, json.Unmarshal(parseCacheBytes, &parsed))
+	return parsed
+}
+
+func writeTestParseCache(t *testing.T, path string, parsed []fsStoreParsedCertificate) {
+	parseCacheBytes, err := json.Marshal(parsed)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, parseCacheFile), parseCacheBytes, storeFilePerm))
+}
+
+func TestReplaceCertificate(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	kpf := ecdsa.StandardKeys()[0]
+	lcf := local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil)
+	entry, err := store.CreateCertificate(context.Background(), kpf.Name(), lcf)
+	require.NoError(t, err)
+	err = store.UpdateAttributes(kpf.Name(), func(attributes *certs.StoreEntryAttributes) {
+		attributes.Labels = map[string]string{"env": "test"}
+	})
+	require.NoError(t, err)
+	certificate, err := entry.Certificate()
+	require.NoError(t, err)
+	_, err = store.ReplaceCertificate(context.Background(), kpf.Name(), lcf)
+	require.NoError(t, err)
+	_, err = store.ReplaceCertificate(context.Background(), "unknown", lcf)
+	require.Error(t, err)
+	require.NoError(t, store.Close())
+	store = openStore(t, storePath)
+	require.Equal(t, 1, traverseStoreEntries(t, store))
+	entry, err = store.Entry(kpf.Name())
+	require.NoError(t, err)
+	replacedCertificate, err := entry.Certificate()
+	require.NoError(t, err)
+	require.NotEqual(t, certificate.Raw, replacedCertificate.Raw)
+	key, err := entry.Key()
+	require.NoError(t, err)
+	require.Equal(t, replacedCertificate.PublicKey, key.(interface{ Public() crypto.PublicKey }).Public())
+	attributes, err := entry.Attributes()
+	require.NoError(t, err)
+	require.Equal(t, "test", attributes.Labels["env"])
+}
+
+func TestUpdateRevocationList(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	kpf := ecdsa.StandardKeys()[0]
+	entry, err := store.CreateCertificate(context.Background(), kpf.Name(), local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+	require.NoError(t, err)
+	require.False(t, entry.HasRevocationList())
+	certificate, err := entry.Certificate()
+	require.NoError(t, err)
+	key, err := entry.Key()
+	require.NoError(t, err)
+	revocationList, err := certs.UpdateRevocationList(certificate, key.(crypto.Signer), nil, []*x509.Certificate{certificate}, certs.ReasonUnspecified, time.Now(), time.Hour)
+	require.NoError(t, err)
+	err = store.UpdateRevocationList(kpf.Name(), revocationList)
+	require.NoError(t, err)
+	err = store.UpdateRevocationList("unknown", revocationList)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.NoError(t, store.Close())
+	store = openStore(t, storePath)
+	entry, err = store.Entry(kpf.Name())
+	require.NoError(t, err)
+	require.True(t, entry.HasRevocationList())
+	storedRevocationList, err := entry.RevocationList()
+	require.NoError(t, err)
+	require.Equal(t, revocationList.Raw, storedRevocationList.Raw)
+}
+
+func TestKeyMismatch(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	kpf := ecdsa.StandardKeys()[0]
+	_, certificate, err := local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil).New(context.Background())
+	require.NoError(t, err)
+	otherKeyPair, err := kpf.New(context.Background())
+	require.NoError(t, err)
+	mismatchFactory := &staticCertificateFactory{key: otherKeyPair.Private(), certificate: certificate}
+	var mismatchErr *certs.KeyMismatchError
+	_, err = store.CreateCertificate(context.Background(), "mismatch", mismatchFactory)
+	require.ErrorAs(t, err, &mismatchErr)
+	require.Equal(t, "mismatch", mismatchErr.Name)
+	_, err = store.Entry("mismatch")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = store.CreateCertificate(context.Background(), "entry", local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+	require.NoError(t, err)
+	_, err = store.ReplaceCertificate(context.Background(), "entry", mismatchFactory)
+	require.ErrorAs(t, err, &mismatchErr)
+	_, err = store.ReplaceCertificate(context.Background(), "entry", &staticCertificateFactory{certificate: certificate})
+	require.ErrorAs(t, err, &mismatchErr)
+	_, err = store.CreateCertificateRequest(context.Background(), "request", &staticCertificateRequestFactory{key: otherKeyPair.Private(), factory: remote.NewLocalCertificateRequestFactory(&x509.CertificateRequest{Subject: pkix.Name{CommonName: "request"}}, kpf)})
+	require.ErrorAs(t, err, &mismatchErr)
+	require.Equal(t, 1, traverseStoreEntries(t, store))
+}
+
+type staticCertificateFactory struct {
+	key         crypto.PrivateKey
+	certificate *x509.Certificate
+}
+
+func (factory *staticCertificateFactory) Name() string {
+	return "Static"
+}
+
+func (factory *staticCertificateFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error) {
+	return factory.key, factory.certificate, nil
+}
+
+type staticCertificateRequestFactory struct {
+	key     crypto.PrivateKey
+	factory certs.CertificateRequestFactory
+}
+
+func (factory *staticCertificateRequestFactory) Name() string {
+	return "Static"
+}
+
+func (factory *staticCertificateRequestFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.CertificateRequest, error) {
+	_, certificateRequest, err := factory.factory.New(context.Background())
+	return factory.key, certificateRequest, err
+}
+
+func TestGenerationOutsideIndexLock(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	kpf := ecdsa.StandardKeys()[0]
+	_, err = store.CreateCertificate(context.Background(), "entry", local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+	require.NoError(t, err)
+	factory := &blockingCertificateFactory{
+		factory: local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	created := make(chan error)
+	go func() {
+		_, err := store.CreateCertificate(context.Background(), "pending", factory)
+		created <- err
+	}()
+	<-factory.started
+	// readers and writers of other entries are not blocked by the pending generation
+	require.Equal(t, 1, traverseStoreEntries(t, store))
+	_, err = store.Entry("entry")
+	require.NoError(t, err)
+	_, err = store.ReplaceCertificate(context.Background(), "entry", local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+	require.NoError(t, err)
+	_, err = store.CreateCertificateRequest(context.Background(), "request", remote.NewLocalCertificateRequestFactory(&x509.CertificateRequest{Subject: pkix.Name{CommonName: "request"}}, kpf))
+	require.NoError(t, err)
+	_, err = store.CreateCertificateRequest(context.Background(), "pending", remote.NewLocalCertificateRequestFactory(&x509.CertificateRequest{Subject: pkix.Name{CommonName: "pending"}}, kpf))
+	require.ErrorIs(t, err, certs.ErrGenerationPending)
+	// the pending entry name is reserved
+	_, err = store.CreateCertificate(context.Background(), "pending", local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+	require.ErrorIs(t, err, certs.ErrGenerationPending)
+	close(factory.release)
+	require.NoError(t, <-created)
+	require.Equal(t, 3, traverseStoreEntries(t, store))
+	_, err = store.CreateCertificate(context.Background(), "pending", local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+	require.ErrorIs(t, err, fs.ErrExist)
+}
+
+type blockingCertificateFactory struct {
+	factory certs.CertificateFactory
+	started chan struct{}
+	release chan struct{}
+}
+
+func (factory *blockingCertificateFactory) Name() string {
+	return factory.factory.Name()
+}
+
+func (factory *blockingCertificateFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error) {
+	close(factory.started)
+	<-factory.release
+	return factory.factory.New(ctx)
+}
+
+func TestEntryEncryption(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	kpf := ecdsa.StandardKeys()[0]
+	_, err = store.CreateCertificate(context.Background(), "legacy", local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	legacyAttributesBytes, err := os.ReadFile(filepath.Join(storePath, "legacy"+attributesExtension))
+	require.NoError(t, err)
+	require.False(t, bytes.HasPrefix(legacyAttributesBytes, encryptedEntryMagic))
+	store, err = Open(storePath, WithEntryEncryption())
+	require.NoError(t, err)
+	entry, err := store.Entry("legacy")
+	require.NoError(t, err)
+	attributes, err := entry.Attributes()
+	require.NoError(t, err)
+	require.Equal(t, local.ProviderName, attributes.Provider)
+	err = store.UpdateAttributes("legacy", func(attributes *certs.StoreEntryAttributes) {
+		attributes.Labels = map[string]string{"owner": "test@example.org"}
+	})
+	require.NoError(t, err)
+	csrTemplate := &x509.CertificateRequest{Subject: pkix.Name{CommonName: "request"}}
+	_, err = store.CreateCertificateRequest(context.Background(), "request", remote.NewLocalCertificateRequestFactory(csrTemplate, kpf))
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	for _, file := range []string{"legacy" + attributesExtension, "request" + attributesExtension, "request" + csrExtension} {
+		fileBytes, err := os.ReadFile(filepath.Join(storePath, file))
+		require.NoError(t, err)
+		require.True(t, bytes.HasPrefix(fileBytes, encryptedEntryMagic), file)
+	}
+	store = openStore(t, storePath)
+	entry, err = store.Entry("legacy")
+	require.NoError(t, err)
+	attributes, err = entry.Attributes()
+	require.NoError(t, err)
+	require.Equal(t, "test@example.org", attributes.Labels["owner"])
+	entry, err = store.Entry("request")
+	require.NoError(t, err)
+	certificateRequest, err := entry.CertificateRequest()
+	require.NoError(t, err)
+	require.Equal(t, "request", certificateRequest.Subject.CommonName)
+	// encrypted files are bound to their entry
+	err = os.Rename(filepath.Join(storePath, "request"+attributesExtension), filepath.Join(storePath, "legacy"+attributesExtension))
+	require.NoError(t, err)
+	store.attributesCache.DeleteAll()
+	_, err = store.readAttributes("legacy")
+	require.Error(t, err)
+}
+
+func TestTrust(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	trust, err := store.Trust()
+	require.NoError(t, err)
+	require.Empty(t, trust)
+	kpf := ecdsa.StandardKeys()[0]
+	lcf := local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil)
+	_, certificate, err := lcf.New(context.Background())
+	require.NoError(t, err)
+	err = store.UpdateTrust("test", []*x509.Certificate{certificate})
+	require.NoError(t, err)
+	require.Error(t, store.UpdateTrust("../test", []*x509.Certificate{certificate}))
+	require.NoError(t, store.Close())
+	store = openStore(t, storePath)
+	require.Equal(t, 0, traverseStoreEntries(t, store))
+	trust, err = store.Trust()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(trust["test"]))
+	require.Equal(t, certificate.Raw, trust["test"][0].Raw)
+}
+
+func TestPermissionPolicy(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	kpf := ecdsa.StandardKeys()[0]
+	lcf := local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil)
+	_, err = store.CreateCertificate(context.Background(), kpf.Name(), lcf)
+	require.NoError(t, err)
+	crtFile := filepath.Join(storePath, entryFileName(kpf.Name())+crtExtension)
+	require.NoError(t, os.Chmod(crtFile, 0644))
+	require.NoError(t, os.Chmod(storePath, 0755))
+	require.NoError(t, store.Close())
+	_, err = Open(storePath)
+	require.Error(t, err)
+	store, err = Open(storePath, WithPermissionPolicy(PermissionPolicyWarn))
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	store, err = Open(storePath, WithPermissionPolicy(PermissionPolicyRepair))
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	crtFileInfo, err := os.Stat(crtFile)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), crtFileInfo.Mode().Perm())
+	storePathInfo, err := os.Stat(storePath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0700), storePathInfo.Mode().Perm())
+	store, err = Open(storePath)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	policy, err := ParsePermissionPolicy("repair")
+	require.NoError(t, err)
+	require.Equal(t, PermissionPolicyRepair, policy)
+	_, err = ParsePermissionPolicy("unknown")
+	require.Error(t, err)
+}
+
+func TestStoreLock(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store1, err := Init(storePath)
+	require.NoError(t, err)
+	lockInfo, err := readLockInfo(filepath.Join(storePath, lockFile))
+	require.NoError(t, err)
+	require.Equal(t, os.Getpid(), lockInfo.PID)
+	_, err = Open(storePath)
+	require.ErrorIs(t, err, ErrStoreLocked)
+	require.ErrorContains(t, err, fmt.Sprintf("pid: %d", os.Getpid()))
+	store2, err := Open(storePath, WithReadOnlyFallback())
+	require.NoError(t, err)
+	require.True(t, store2.ReadOnly())
+	kpf := ecdsa.StandardKeys()[0]
+	lcf := local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil)
+	_, err = store2.CreateCertificate(context.Background(), kpf.Name(), lcf)
+	require.ErrorIs(t, err, ErrStoreReadOnly)
+	require.NoError(t, store2.Close())
+	// locks of running processes are never broken
+	_, err = Open(storePath, WithForceUnlock())
+	require.ErrorIs(t, err, ErrStoreLocked)
+	// simulate a stale lock of a no longer running process
+	host, err := os.Hostname()
+	require.NoError(t, err)
+	staleInfo, err := json.Marshal(&fsStoreLockInfo{PID: math.MaxInt32, Host: host, Heartbeat: time.Now().Add(-2 * lockStaleTimeout)})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(storePath, lockFile), staleInfo, 0600))
+	_, err = Open(storePath)
+	require.ErrorIs(t, err, ErrStoreLocked)
+	store3, err := Open(storePath, WithForceUnlock())
+	require.NoError(t, err)
+	require.False(t, store3.ReadOnly())
+	_, err = store3.CreateCertificate(context.Background(), kpf.Name(), lcf)
+	require.NoError(t, err)
+	require.NoError(t, store3.Close())
+	require.NoError(t, store1.Close())
+}
+
+func TestDeriveKey(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath)
+	require.NoError(t, err)
+	key1, err := store.DeriveKey("test", 32)
+	require.NoError(t, err)
+	require.Equal(t, 32, len(key1))
+	require.NoError(t, store.Close())
+	store = openStore(t, storePath)
+	key2, err := store.DeriveKey("test", 32)
+	require.NoError(t, err)
+	require.Equal(t, key1, key2)
+	key3, err := store.DeriveKey("other", 32)
+	require.NoError(t, err)
+	require.NotEqual(t, key1, key3)
+}
+
+func TestRotateSecret(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath, WithEntryEncryption())
+	require.NoError(t, err)
+	kpf := ecdsa.StandardKeys()[0]
+	_, err = store.CreateCertificate(context.Background(), "ca", local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+	require.NoError(t, err)
+	_, err = store.CreateCertificate(context.Background(), "archived", local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+	require.NoError(t, err)
+	require.NoError(t, store.ArchiveEntry("archived"))
+	caEntry, err := store.Entry("ca")
+	require.NoError(t, err)
+	caKey, err := caEntry.Key()
+	require.NoError(t, err)
+	key1, err := store.DeriveKey("test", 32)
+	require.NoError(t, err)
+	settings1, err := loadFSStoreSettings(storePath)
+	require.NoError(t, err)
+	rotated, err := store.RotateSecret()
+	require.NoError(t, err)
+	require.Equal(t, 4, rotated)
+	key2, err := store.DeriveKey("test", 32)
+	require.NoError(t, err)
+	require.NotEqual(t, key1, key2)
+	require.NoError(t, store.Close())
+	settings2, err := loadFSStoreSettings(storePath)
+	require.NoError(t, err)
+	require.NotEqual(t, settings1.Secret, settings2.Secret)
+	require.Equal(t, settings1.SchemaVersion, settings2.SchemaVersion)
+	store, err = Open(storePath, WithEntryEncryption())
+	require.NoError(t, err)
+	defer store.Close()
+	orphans, err := store.CollectGarbage(false)
+	require.NoError(t, err)
+	require.Empty(t, orphans)
+	caEntry, err = store.Entry("ca")
+	require.NoError(t, err)
+	attributes, err := caEntry.Attributes()
+	require.NoError(t, err)
+	require.Equal(t, local.ProviderName, attributes.Provider)
+	rotatedKey, err := caEntry.Key()
+	require.NoError(t, err)
+	require.True(t, certs.KeyMatches(rotatedKey, caKey.(crypto.Signer).Public()))
+	archivedEntry, err := store.ArchivedEntry("archived")
+	require.NoError(t, err)
+	_, err = archivedEntry.Key()
+	require.NoError(t, err)
+}
+
+func TestResumeSecretRotation(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	store, err := Init(storePath, WithEntryEncryption())
+	require.NoError(t, err)
+	kpf := ecdsa.StandardKeys()[0]
+	for _, name := range []string{"ca1", "ca2"} {
+		_, err = store.CreateCertificate(context.Background(), name, local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+		require.NoError(t, err)
+	}
+	// simulate a rotation interrupted after the first key file has been replaced
+	settings, err := loadFSStoreSettings(storePath)
+	require.NoError(t, err)
+	settings.NextSecret, err = generateSecret()
+	require.NoError(t, err)
+	require.NoError(t, updateFSStoreSettings(storePath, settings))
+	nextSecret, err := security.Wrap(settings.NextSecret)
+	require.NoError(t, err)
+	keyFile, err := store.reencryptKeyFile("ca1", nextSecret)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile.path, keyFile.rotated, 0600))
+	require.NoError(t, store.Close())
+	// opening the store completes the rotation
+	store, err = Open(storePath, WithEntryEncryption())
+	require.NoError(t, err)
+	defer store.Close()
+	for _, name := range []string{"ca1", "ca2"} {
+		entry, err := store.Entry(name)
+		require.NoError(t, err)
+		_, err = entry.Key()
+		require.NoError(t, err)
+		_, err = entry.Attributes()
+		require.NoError(t, err)
+	}
+	resumedSettings, err := loadFSStoreSettings(storePath)
+	require.NoError(t, err)
+	require.Equal(t, settings.NextSecret, resumedSettings.Secret)
+	require.Empty(t, resumedSettings.NextSecret)
+}
+
+func TestSecretRotationHook(t *testing.T) {
+	home := mkhome(t)
+	defer os.RemoveAll(home)
+	storePath := filepath.Join(home, storeHome)
+	failingHook := &testRotationHook{commitErr: errors.New("commit failed")}
+	store, err := Init(storePath, WithSecretRotationHook(failingHook))
+	require.NoError(t, err)
+	kpf := ecdsa.StandardKeys()[0]
+	_, err = store.CreateCertificate(context.Background(), "ca", local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil))
+	require.NoError(t, err)
+	previousKey, err := store.DeriveKey("test", 32)
+	require.NoError(t, err)
+	// a failing hook rolls back the rotation
+	_, err = store.RotateSecret()
+	require.ErrorIs(t, err, failingHook.commitErr)
+	require.Equal(t, previousKey, failingHook.previousKey)
+	require.NotEqual(t, previousKey, failingHook.nextKey)
+	require.NoError(t, store.Close())
+	hook := &testRotationHook{}
+	store, err = Open(storePath, WithSecretRotationHook(hook))
+	require.NoError(t, err)
+	defer store.Close()
+	// opening the store completes the interrupted rotation including the hook
+	require.True(t, hook.committed)
+	require.Equal(t, previousKey, hook.previousKey)
+	require.Equal(t, failingHook.nextKey, hook.nextKey)
+	nextKey, err := store.DeriveKey("test", 32)
+	require.NoError(t, err)
+	require.Equal(t, hook.nextKey, nextKey)
+	entry, err := store.Entry("ca")
+	require.NoError(t, err)
+	_, err = entry.Key()
+	require.NoError(t, err)
+}
+
+type testRotationHook struct {
+	previousKey []byte
+	nextKey     []byte
+	commitErr   error
+	committed   bool
+}
+
+func (hook *testRotationHook) Stage(previousKey func(purpose string, length int) ([]byte, error), nextKey func(purpose string, length int) ([]byte, error)) error {
+	var err error
+	hook.previousKey, err = previousKey("test", 32)
+	if err != nil {
+		return err
+	}
+	hook.nextKey, err = nextKey("test", 32)
+	return err
+}
+
+func (hook *testRotationHook) Commit() error {
+	if hook.commitErr != nil {
+		return hook.commitErr
+	}
+	hook.committed = true
+	return nil
+}
+
+func (hook *testRotationHook) Rollback() {
+	hook.committed = false
+}
+
 var localCATemplate = &x509.Certificate{
 	SerialNumber: big.NewInt(1),
 	Subject: pkix.Name{
@@ -104,7 +1108,7 @@ var localCATemplate = &x509.Certificate{
 	NotAfter:              time.Now().AddDate(1, 0, 0),
 	IsCA:                  true,
 	ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-	KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 	BasicConstraintsValid: true,
 }
 
@@ -132,7 +1136,7 @@ func createLocalCertficate(t *testing.T, path string, kpfs []keys.KeyPairFactory
 	for _, kpf := range kpfs {
 		// create self-signed root certificate
 		lcf1 := local.NewLocalCertificateFactory(localCATemplate, kpf, nil, nil)
-		entry1, err := store.CreateCertificate(kpf.Name()+"-1", lcf1)
+		entry1, err := store.CreateCertificate(context.Background(), kpf.Name()+"-1", lcf1)
 		require.NoError(t, err)
 		require.NotNil(t, entry1)
 		// create signed certificate
@@ -142,17 +1146,21 @@ func createLocalCertficate(t *testing.T, path string, kpfs []keys.KeyPairFactory
 		entry1Key, err := entry1.Key()
 		require.NoError(t, err)
 		require.NotNil(t, entry1Key)
-		lcf2 := local.NewLocalCertificateFactory(localServerTemplate, kpf, entry1Certificate, entry1Key)
-		entry2, err := store.CreateCertificate(kpf.Name()+"-2", lcf2)
+		lcf2 := local.NewLocalCertificateFactory(localServerTemplate, kpf, entry1Certificate, entry1Key.(crypto.Signer))
+		entry2, err := store.CreateCertificate(context.Background(), kpf.Name()+"-2", lcf2)
 		require.NoError(t, err)
 		require.NotNil(t, entry2)
 	}
+	require.NoError(t, store.Close())
 }
 
 func openStore(t *testing.T, path string) *FSStore {
 	store, err := Open(path)
 	require.NoError(t, err)
 	require.NotNil(t, store)
+	t.Cleanup(func() {
+		store.Close()
+	})
 	return store
 }
 
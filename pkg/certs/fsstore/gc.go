@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fsstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Reasons reported for orphaned store files.
+const (
+	OrphanTemporary    = "temporary file"
+	OrphanUnrelated    = "not part of a valid store entry"
+	OrphanUnrecognized = "unrecognized file"
+)
+
+// FSStoreOrphan describes a store file not belonging to any valid store entry (e.g. left over after a crash).
+type FSStoreOrphan struct {
+	File      string
+	Reason    string
+	Size      int64
+	Removable bool
+	Removed   bool
+}
+
+// Collect the orphaned files of the store and remove them if requested.
+//
+// Only temporary files and files unrelated to a valid store entry are removed. Unrecognized files and
+// directories are reported but always retained.
+func (store *FSStore) CollectGarbage(remove bool) ([]FSStoreOrphan, error) {
+	if remove {
+		err := store.checkWritable()
+		if err != nil {
+			return nil, err
+		}
+	}
+	store.index.lock.Lock()
+	defer store.index.lock.Unlock()
+	entryFiles := make(map[string]bool, len(store.index.entries))
+	for _, name := range store.index.entries {
+		entryFiles[store.entryFile(name)] = true
+	}
+	dirEntries, err := os.ReadDir(store.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store path '%s' (cause: %w)", store.path, err)
+	}
+	orphans := make([]FSStoreOrphan, 0)
+	for _, dirEntry := range dirEntries {
+		file := dirEntry.Name()
+		if file == settingsFile || file == lockFile || file == expiryFile || file == parseCacheFile || ((file == trustDir || file == archiveDir) && dirEntry.IsDir()) {
+			continue
+		}
+		orphan := FSStoreOrphan{File: file}
+		info, err := dirEntry.Info()
+		if err == nil && !info.IsDir() {
+			orphan.Size = info.Size()
+		}
+		switch extension := filepath.Ext(file); {
+		case dirEntry.IsDir():
+			orphan.Reason = OrphanUnrecognized
+		case extension == updateExtension:
+			orphan.Reason = OrphanTemporary
+			orphan.Removable = true
+		case extension == keyExtension || extension == crtExtension || extension == csrExtension || extension == crlExtension || extension == attributesExtension:
+			if entryFiles[strings.TrimSuffix(file, extension)] {
+				continue
+			}
+			orphan.Reason = OrphanUnrelated
+			orphan.Removable = true
+		default:
+			orphan.Reason = OrphanUnrecognized
+		}
+		if remove && orphan.Removable {
+			filePath := filepath.Join(store.path, file)
+			store.logger.Info().Msgf("Removing orphaned file '%s' (%s)...", filePath, orphan.Reason)
+			err = os.Remove(filePath)
+			if err != nil {
+				return orphans, fmt.Errorf("failed to remove orphaned file '%s' (cause: %w)", filePath, err)
+			}
+			orphan.Removed = true
+		}
+		orphans = append(orphans, orphan)
+	}
+	return orphans, nil
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fsstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const lockFile = ".lock"
+const lockHeartbeatInterval = 30 * time.Second
+
+// Store locks without heartbeat update for this duration are considered stale.
+const lockStaleTimeout = 3 * lockHeartbeatInterval
+
+// ErrStoreLocked indicates that a store is already in use by another process.
+var ErrStoreLocked = errors.New("store is locked by another process")
+
+// ErrStoreReadOnly indicates a write attempt to a store opened read-only.
+var ErrStoreReadOnly = errors.New("store is opened read-only")
+
+// Open the store read-only in case it is locked by another process (instead of failing).
+func WithReadOnlyFallback() Option {
+	return func(store *FSStore) {
+		store.readOnlyFallback = true
+	}
+}
+
+// Break a stale store lock while opening the store.
+//
+// A lock is only broken, if it has been recorded on this host, its heartbeat is stale and the recording process is no
+// longer running. Locks of running (e.g. hung) processes are never broken, as this would result in concurrent writers.
+func WithForceUnlock() Option {
+	return func(store *FSStore) {
+		store.forceUnlock = true
+	}
+}
+
+type fsStoreLock struct {
+	file *os.File
+	stop chan struct{}
+	done chan struct{}
+}
+
+type fsStoreLockInfo struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	Heartbeat time.Time `json:"heartbeat"`
+}
+
+func (info *fsStoreLockInfo) String() string {
+	return fmt.Sprintf("pid: %d, host: %s, heartbeat: %s", info.PID, info.Host, info.Heartbeat.Format(time.RFC3339))
+}
+
+func (store *FSStore) lock() error {
+	file := filepath.Join(store.path, lockFile)
+	err := store.tryLock(file)
+	if errors.Is(err, ErrStoreLocked) && store.forceUnlock {
+		err = store.breakLock(file)
+		if err == nil {
+			err = store.tryLock(file)
+		}
+	}
+	if errors.Is(err, ErrStoreLocked) && store.readOnlyFallback {
+		store.logger.Warn().Msgf("Opening store read-only (cause: %v)", err)
+		store.readOnly = true
+		if store.permissionPolicy == PermissionPolicyRepair {
+			store.permissionPolicy = PermissionPolicyWarn
+		}
+		return nil
+	}
+	return err
+}
+
+func (store *FSStore) tryLock(file string) error {
+	lock, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, storeFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to open store lock file '%s' (cause: %w)", file, err)
+	}
+	err = lockFileExclusive(lock)
+	if err != nil {
+		lock.Close()
+		if errors.Is(err, ErrStoreLocked) {
+			info, infoErr := readLockInfo(file)
+			if infoErr == nil {
+				return fmt.Errorf("%w (%s)", ErrStoreLocked, info)
+			}
+		}
+		return fmt.Errorf("failed to lock store '%s' (cause: %w)", store.path, err)
+	}
+	info := &fsStoreLockInfo{PID: os.Getpid()}
+	info.Host, _ = os.Hostname()
+	err = writeLockInfo(lock, info)
+	if err != nil {
+		unlockFile(lock)
+		lock.Close()
+		return err
+	}
+	store.storeLock = &fsStoreLock{
+		file: lock,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go store.heartbeat(store.storeLock, info)
+	return nil
+}
+
+func (store *FSStore) breakLock(file string) error {
+	info, err := readLockInfo(file)
+	if err != nil {
+		return fmt.Errorf("%w (cannot break lock; cause: %v)", ErrStoreLocked, err)
+	}
+	host, _ := os.Hostname()
+	if info.Host != host {
+		return fmt.Errorf("%w (cannot break lock of another host; %s)", ErrStoreLocked, info)
+	}
+	if time.Since(info.Heartbeat) < lockStaleTimeout {
+		return fmt.Errorf("%w (cannot break lock with active heartbeat; %s)", ErrStoreLocked, info)
+	}
+	if processRunning(info.PID) {
+		return fmt.Errorf("%w (cannot break lock of running process; %s)", ErrStoreLocked, info)
+	}
+	store.logger.Warn().Msgf("Forcing unlock of store (%s)", info)
+	err = os.Remove(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove store lock file '%s' (cause: %w)", file, err)
+	}
+	return nil
+}
+
+func (store *FSStore) heartbeat(lock *fsStoreLock, info *fsStoreLockInfo) {
+	defer close(lock.done)
+	ticker := time.NewTicker(lockHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := writeLockInfo(lock.file, info)
+			if err != nil {
+				store.logger.Error().Err(err).Msg("Failed to update store lock heartbeat")
+			}
+		case <-lock.stop:
+			return
+		}
+	}
+}
+
+func readLockInfo(file string) (*fsStoreLockInfo, error) {
+	infoBytes, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	info := &fsStoreLockInfo{}
+	err = json.Unmarshal(infoBytes, info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal store lock info (cause: %w)", err)
+	}
+	return info, nil
+}
+
+func writeLockInfo(lock *os.File, info *fsStoreLockInfo) error {
+	info.Heartbeat = time.Now()
+	infoBytes, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to marshal store lock info (cause: %w)", err)
+	}
+	err = lock.Truncate(0)
+	if err == nil {
+		_, err = lock.WriteAt(infoBytes, 0)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write store lock file '%s' (cause: %w)", lock.Name(), err)
+	}
+	return nil
+}
+
+// Check whether the store has been opened read-only (because it is locked by another process).
+func (store *FSStore) ReadOnly() bool {
+	return store.readOnly
+}
+
+func (store *FSStore) checkWritable() error {
+	if store.readOnly {
+		return ErrStoreReadOnly
+	}
+	return nil
+}
+
+// Close the store and release the store lock.
+//
+// Derived store instances (see WithCorrelationID) must not be used after the originating store has been closed.
+func (store *FSStore) Close() error {
+	store.stopWarming()
+	lock := store.storeLock
+	if lock == nil {
+		return nil
+	}
+	store.logger.Info().Msg("Closing FS certificate store")
+	store.storeLock = nil
+	close(lock.stop)
+	<-lock.done
+	lock.file.Truncate(0)
+	err := unlockFile(lock.file)
+	closeErr := lock.file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to unlock store '%s' (cause: %w)", store.path, err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to close store lock file (cause: %w)", closeErr)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fsstore
+
+import (
+	"fmt"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+// Current schema version of the store settings and entry attributes.
+//
+// Version history:
+//
+//	1: initial version (not recorded)
+//	2: entry kinds recorded in the entry attributes
+const storeSchemaVersion = 2
+
+// A migration upgrading a store to the given schema version.
+type storeMigration struct {
+	version     int
+	description string
+	migrate     func(store *FSStore) error
+}
+
+// Migrations in ascending version order.
+var storeMigrations = []storeMigration{
+	{version: 2, description: "record entry kinds", migrate: migrateEntryKinds},
+}
+
+// Determine the schema version of the given store settings (rejecting stores written by newer versions).
+func checkSchemaVersion(settings *fsStoreSettings) (int, error) {
+	version := settings.SchemaVersion
+	if version == 0 {
+		version = 1
+	}
+	if version > storeSchemaVersion {
+		return version, fmt.Errorf("unsupported store schema version %d (supported up to version %d)", version, storeSchemaVersion)
+	}
+	return version, nil
+}
+
+// Upgrade the store to the current schema version by applying all pending migrations.
+//
+// The store settings are updated after each successful migration. Read-only stores are used as is.
+func (store *FSStore) migrate(settings *fsStoreSettings) error {
+	version, err := checkSchemaVersion(settings)
+	if err != nil || version == storeSchemaVersion {
+		return err
+	}
+	if store.readOnly {
+		store.logger.Warn().Msgf("Skipping migration of store schema version %d (store is read-only)", version)
+		return nil
+	}
+	for _, migration := range storeMigrations {
+		if migration.version <= version {
+			continue
+		}
+		store.logger.Info().Msgf("Migrating store from schema version %d to %d (%s)...", version, migration.version, migration.description)
+		err = migration.migrate(store)
+		if err != nil {
+			return fmt.Errorf("failed to migrate store to schema version %d (cause: %w)", migration.version, err)
+		}
+		version = migration.version
+		settings.SchemaVersion = version
+		err = updateFSStoreSettings(store.path, settings)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateEntryKinds(store *FSStore) error {
+	for _, namespace := range []*FSStore{store, store.archive} {
+		names := append([]string{}, namespace.index.entries...)
+		for _, name := range names {
+			kind := namespace.deriveEntryKind(name, namespace.hasKey(name), namespace.hasCertificate(name), namespace.hasCertificateRequest(name), namespace.hasRevocationList(name))
+			err := namespace.UpdateAttributes(name, func(attributes *certs.StoreEntryAttributes) {
+				if attributes.Kind == "" {
+					attributes.Kind = kind
+				}
+				attributes.SchemaVersion = 2
+			})
+			if err != nil {
+				return err
+			}
+			namespace.logger.Debug().Msgf("Recorded kind '%s' for store entry '%s'", kind, name)
+		}
+	}
+	return nil
+}
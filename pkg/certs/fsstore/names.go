@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fsstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+const slugSeparator = "~"
+const maxSlugPrefix = 64
+const maxPortableNameLength = 128
+
+// Derive the file name (without extension) used to store the entry with the given name.
+//
+// Names consisting of portable characters only are used as is. All other names (e.g. DNs containing slashes
+// and colons, unicode names, names containing uppercase characters which may collide on case-insensitive file
+// systems or names reserved on Windows) are mapped to a slug made up of the name's portable characters and a hash
+// of the full name. The full name is recorded in the entry's attributes and restored during the store scan.
+func entryFileName(name string) string {
+	if isPortableName(name) {
+		return name
+	}
+	var slug strings.Builder
+	for _, r := range name {
+		if slug.Len() >= maxSlugPrefix {
+			break
+		}
+		if 'A' <= r && r <= 'Z' {
+			slug.WriteRune(r + ('a' - 'A'))
+		} else if isPortableRune(r) {
+			slug.WriteRune(r)
+		} else {
+			slug.WriteRune('_')
+		}
+	}
+	hash := sha256.Sum256([]byte(name))
+	file := strings.TrimLeft(slug.String(), ".") + slugSeparator + hex.EncodeToString(hash[:8])
+	if isReservedName(file) {
+		file = "_" + file
+	}
+	return file
+}
+
+func isPortableName(name string) bool {
+	if name == "" || len(name) > maxPortableNameLength || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.TrimSpace(name) != name {
+		return false
+	}
+	for _, r := range name {
+		if !isPortableRune(r) {
+			return false
+		}
+	}
+	return !isReservedName(name)
+}
+
+func isPortableRune(r rune) bool {
+	return ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') || r == '.' || r == '_' || r == '-' || r == '@' || r == '+' || r == ' '
+}
+
+// Device names reserved on Windows (regardless of case and extension).
+var reservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+func isReservedName(name string) bool {
+	base, _, _ := strings.Cut(name, ".")
+	return reservedNames[strings.ToLower(strings.TrimRight(base, " "))]
+}
+
+// Get the file name (without extension) of the given entry.
+//
+// Entries created before the introduction of slugs keep their original file names.
+func (store *FSStore) entryFile(name string) string {
+	file, mapped := store.index.files.Load(name)
+	if mapped {
+		return file.(string)
+	}
+	return entryFileName(name)
+}
+
+func (store *FSStore) entryPath(name string, extension string) string {
+	return filepath.Join(store.path, store.entryFile(name)+extension)
+}
+
+// Attributes to record for a newly created entry (the name is only recorded if the entry is stored using a slug).
+func newEntryAttributes(name string, provider string) *certs.StoreEntryAttributes {
+	attributes := &certs.StoreEntryAttributes{
+		SchemaVersion: storeSchemaVersion,
+		Provider:      provider,
+	}
+	if entryFileName(name) != name {
+		attributes.Name = name
+	}
+	return attributes
+}
+
+// Determine the entry name for the given file name found during the store scan.
+func (store *FSStore) resolveEntryName(file string) string {
+	if strings.Contains(file, slugSeparator) {
+		name := store.readEntryName(file)
+		if name != "" && entryFileName(name) == file {
+			return name
+		}
+	}
+	if entryFileName(file) != file {
+		// entry created before the introduction of slugs
+		store.index.files.Store(file, file)
+	}
+	return file
+}
+
+func (store *FSStore) readEntryName(file string) string {
+	attributesFilePath := filepath.Join(store.path, file+attributesExtension)
+	attributesBytes, err := os.ReadFile(attributesFilePath)
+	if err != nil {
+		return ""
+	}
+	attributesBytes, err = store.openEntryFile(file+attributesExtension, attributesBytes)
+	if err != nil {
+		store.logger.Warn().Err(err).Msgf("Failed to read entry name from attributes file '%s'", attributesFilePath)
+		return ""
+	}
+	attributes := &certs.StoreEntryAttributes{}
+	err = json.Unmarshal(attributesBytes, attributes)
+	if err != nil {
+		store.logger.Warn().Err(err).Msgf("Failed to read entry name from attributes file '%s'", attributesFilePath)
+		return ""
+	}
+	return attributes.Name
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fsstore
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// PermissionPolicy defines how insecure store file permissions are handled when opening a store.
+type PermissionPolicy int
+
+const (
+	// Refuse to open a store with group/world accessible files or directories (default).
+	PermissionPolicyEnforce PermissionPolicy = iota
+	// Repair insecure permissions while opening the store.
+	PermissionPolicyRepair
+	// Only warn about insecure permissions.
+	PermissionPolicyWarn
+)
+
+var permissionPolicyNames = map[string]PermissionPolicy{
+	"enforce": PermissionPolicyEnforce,
+	"repair":  PermissionPolicyRepair,
+	"warn":    PermissionPolicyWarn,
+}
+
+// Parse a permission policy name ("enforce", "repair" or "warn").
+func ParsePermissionPolicy(name string) (PermissionPolicy, error) {
+	if name == "" {
+		return PermissionPolicyEnforce, nil
+	}
+	policy, found := permissionPolicyNames[name]
+	if !found {
+		return PermissionPolicyEnforce, fmt.Errorf("unrecognized permission policy '%s'", name)
+	}
+	return policy, nil
+}
+
+// Option functions are used to customize a store during Init or Open.
+type Option func(store *FSStore)
+
+// Set the policy to apply in case of insecure store file permissions.
+func WithPermissionPolicy(policy PermissionPolicy) Option {
+	return func(store *FSStore) {
+		store.permissionPolicy = policy
+	}
+}
+
+func (store *FSStore) checkPermissions() error {
+	return filepath.WalkDir(store.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat store file '%s' (cause: %w)", path, err)
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("unexpected symbolic link '%s' in store", path)
+		}
+		return store.checkAccess(path, info)
+	})
+}
+
+func (store *FSStore) checkPermission(path string, info fs.FileInfo) error {
+	var expectedPerm fs.FileMode = storeFilePerm
+	if info.IsDir() {
+		expectedPerm = storeDirPerm
+	}
+	perm := info.Mode().Perm()
+	if perm&^expectedPerm == 0 {
+		return nil
+	}
+	repairedPerm := perm & expectedPerm
+	return store.applyPermissionPolicy(path, fmt.Sprintf("insecure permissions %s (expected: %s)", perm, expectedPerm), func() error {
+		return os.Chmod(path, repairedPerm)
+	})
+}
+
+func (store *FSStore) applyPermissionPolicy(path string, issue string, repair func() error) error {
+	switch store.permissionPolicy {
+	case PermissionPolicyRepair:
+		store.logger.Warn().Msgf("Repairing %s of store file '%s'", issue, path)
+		err := repair()
+		if err != nil {
+			return fmt.Errorf("failed to repair %s of store file '%s' (cause: %w)", issue, path, err)
+		}
+	case PermissionPolicyWarn:
+		store.logger.Warn().Msgf("Detected %s of store file '%s'", issue, path)
+	default:
+		return fmt.Errorf("detected %s of store file '%s'", issue, path)
+	}
+	return nil
+}
+
+func (store *FSStore) reportInsecureOwner(path string, owner string) error {
+	if store.permissionPolicy == PermissionPolicyWarn {
+		store.logger.Warn().Msgf("Store file '%s' is owned by a different user (owner: %s)", path, owner)
+		return nil
+	}
+	return fmt.Errorf("store file '%s' is owned by a different user (owner: %s)", path, owner)
+}
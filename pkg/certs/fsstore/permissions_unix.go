@@ -0,0 +1,46 @@
+//go:build unix
+
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fsstore
+
+import (
+	"io/fs"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+func (store *FSStore) checkAccess(path string, info fs.FileInfo) error {
+	err := store.checkPermission(path, info)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if int(stat.Uid) != os.Geteuid() {
+		return store.reportInsecureOwner(path, strconv.FormatUint(uint64(stat.Uid), 10))
+	}
+	return nil
+}
+
+func secureStoreDir(path string) error {
+	return nil
+}
@@ -0,0 +1,156 @@
+//go:build windows
+
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fsstore
+
+import (
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// SDDL ACE format: ace_type;ace_flags;rights;object_guid;inherit_object_guid;account_sid[;resource_attribute]
+var sddlACEPattern = regexp.MustCompile(`\(([^;()]*);[^;()]*;[^;()]*;[^;()]*;[^;()]*;([^;()]*)[^()]*\)`)
+
+// SIDs (or their SDDL aliases) which are always accepted to have access to the store.
+var trustedSIDs = map[string]bool{
+	"SY":           true, // Local System
+	"S-1-5-18":     true,
+	"BA":           true, // Builtin Administrators
+	"S-1-5-32-544": true,
+	"OW":           true, // Owner Rights
+	"S-1-3-4":      true,
+	"CO":           true, // Creator Owner (inherit only)
+	"S-1-3-0":      true,
+}
+
+func (store *FSStore) checkAccess(path string, info fs.FileInfo) error {
+	user, err := currentUserSID()
+	if err != nil {
+		return err
+	}
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return fmt.Errorf("failed to get security info of store file '%s' (cause: %w)", path, err)
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return fmt.Errorf("failed to get owner of store file '%s' (cause: %w)", path, err)
+	}
+	if !owner.Equals(user) && !owner.IsWellKnown(windows.WinBuiltinAdministratorsSid) {
+		err = store.reportInsecureOwner(path, owner.String())
+		if err != nil {
+			return err
+		}
+	}
+	untrusted := untrustedSIDs(sd.String(), user.String())
+	if len(untrusted) == 0 {
+		return nil
+	}
+	issue := fmt.Sprintf("insecure access rights for %s", strings.Join(untrusted, ", "))
+	return store.applyPermissionPolicy(path, issue, func() error {
+		return setOwnerOnlyACL(path, info.IsDir())
+	})
+}
+
+func untrustedSIDs(sddl string, user string) []string {
+	dacl := sddl
+	daclStart := strings.Index(dacl, "D:")
+	if daclStart >= 0 {
+		dacl = dacl[daclStart:]
+	}
+	saclStart := strings.Index(dacl, "S:")
+	if saclStart >= 0 {
+		dacl = dacl[:saclStart]
+	}
+	untrusted := make([]string, 0)
+	for _, ace := range sddlACEPattern.FindAllStringSubmatch(dacl, -1) {
+		aceType := ace[1]
+		sid := ace[2]
+		if aceType != "A" && aceType != "OA" && aceType != "XA" && aceType != "ZA" {
+			continue
+		}
+		if sid == user || trustedSIDs[sid] {
+			continue
+		}
+		untrusted = append(untrusted, sid)
+	}
+	return untrusted
+}
+
+func secureStoreDir(path string) error {
+	err := setOwnerOnlyACL(path, true)
+	if err != nil {
+		return fmt.Errorf("failed to secure store directory '%s' (cause: %w)", path, err)
+	}
+	return nil
+}
+
+func setOwnerOnlyACL(path string, dir bool) error {
+	user, err := currentUserSID()
+	if err != nil {
+		return err
+	}
+	system, err := windows.CreateWellKnownSid(windows.WinLocalSystemSid)
+	if err != nil {
+		return fmt.Errorf("failed to create system SID (cause: %w)", err)
+	}
+	admins, err := windows.CreateWellKnownSid(windows.WinBuiltinAdministratorsSid)
+	if err != nil {
+		return fmt.Errorf("failed to create administrators SID (cause: %w)", err)
+	}
+	var inheritance uint32 = windows.NO_INHERITANCE
+	if dir {
+		inheritance = windows.SUB_CONTAINERS_AND_OBJECTS_INHERIT
+	}
+	entries := []windows.EXPLICIT_ACCESS{
+		fullAccess(user, windows.TRUSTEE_IS_USER, inheritance),
+		fullAccess(system, windows.TRUSTEE_IS_WELL_KNOWN_GROUP, inheritance),
+		fullAccess(admins, windows.TRUSTEE_IS_WELL_KNOWN_GROUP, inheritance),
+	}
+	acl, err := windows.ACLFromEntries(entries, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create ACL (cause: %w)", err)
+	}
+	return windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION, nil, nil, acl, nil)
+}
+
+func fullAccess(sid *windows.SID, trusteeType windows.TRUSTEE_TYPE, inheritance uint32) windows.EXPLICIT_ACCESS {
+	return windows.EXPLICIT_ACCESS{
+		AccessPermissions: windows.GENERIC_ALL,
+		AccessMode:        windows.GRANT_ACCESS,
+		Inheritance:       inheritance,
+		Trustee: windows.TRUSTEE{
+			TrusteeForm:  windows.TRUSTEE_IS_SID,
+			TrusteeType:  trusteeType,
+			TrusteeValue: windows.TrusteeValueFromSID(sid),
+		},
+	}
+}
+
+func currentUserSID() (*windows.SID, error) {
+	tokenUser, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine current user (cause: %w)", err)
+	}
+	return tokenUser.User.Sid, nil
+}
@@ -0,0 +1,302 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fsstore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/hdecarne-github/certd/internal/security"
+)
+
+// SecretRotationHook re-encrypts data kept outside the store, which is protected by a key derived from the store
+// secret (see DeriveKey), as part of a secret rotation.
+type SecretRotationHook interface {
+	// Re-encrypt the data from the key derived from the previous secret to the key derived from the next secret
+	// (without writing anything yet).
+	Stage(previousKey func(purpose string, length int) ([]byte, error), nextKey func(purpose string, length int) ([]byte, error)) error
+	// Write the re-encrypted data.
+	Commit() error
+	// Restore the original data (after a successful Commit).
+	Rollback()
+}
+
+// Re-encrypt data protected by a store derived key during secret rotation (including the completion of an
+// interrupted rotation while opening the store).
+func WithSecretRotationHook(hook SecretRotationHook) Option {
+	return func(store *FSStore) {
+		store.rotationHooks = append(store.rotationHooks, hook)
+	}
+}
+
+// A store file re-encrypted during secret rotation.
+type rotatedFile struct {
+	path     string
+	original []byte
+	rotated  []byte
+}
+
+// Replace the store secret with a newly generated one and re-encrypt all key files (as well as all encrypted
+// attributes and certificate request files) of the store and its archive accordingly.
+//
+// The new secret is recorded in the store settings before any file is replaced and the previous secret is kept until
+// all files have been replaced. Hence every file remains decryptable with one of the two recorded secrets at any time.
+// In case the rotation fails or is interrupted, already replaced files are restored (as far as possible) and the
+// rotation is completed the next time it is run or the store is opened. Keys derived from the store secret (see
+// DeriveKey) change as well. Data protected by derived keys is re-encrypted by the registered rotation hooks (see
+// WithSecretRotationHook) following the same stage and rollback steps. The number of re-encrypted files is returned.
+func (store *FSStore) RotateSecret() (int, error) {
+	err := store.checkWritable()
+	if err != nil {
+		return 0, err
+	}
+	namespaces := []*FSStore{store, store.archive}
+	for _, namespace := range namespaces {
+		namespace.index.lock.Lock()
+		defer namespace.index.lock.Unlock()
+	}
+	settings, err := loadFSStoreSettings(store.path)
+	if err != nil {
+		return 0, err
+	}
+	if settings.NextSecret == "" {
+		settings.NextSecret, err = generateSecret()
+		if err != nil {
+			return 0, err
+		}
+		err = updateFSStoreSettings(store.path, settings)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		store.logger.Warn().Msg("Resuming interrupted secret rotation")
+	}
+	secret, err := security.Wrap(settings.NextSecret)
+	if err != nil {
+		return 0, fmt.Errorf("failed to wrap secret (cause: %w)", err)
+	}
+	files := make([]rotatedFile, 0)
+	for _, namespace := range namespaces {
+		namespaceFiles, err := namespace.reencryptFiles(secret)
+		if err != nil {
+			return 0, err
+		}
+		files = append(files, namespaceFiles...)
+	}
+	for _, hook := range store.rotationHooks {
+		err = hook.Stage(secretKeyDerivation(store.secret), secretKeyDerivation(secret))
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = stageRotatedFiles(files)
+	if err != nil {
+		return 0, err
+	}
+	for i, file := range files {
+		err = os.Rename(file.path+updateExtension, file.path)
+		if err != nil {
+			err = fmt.Errorf("failed to replace file '%s' (cause: %w)", file.path, err)
+			store.rollbackRotatedFiles(files[:i])
+			removeStagedFiles(files[i:])
+			return 0, err
+		}
+	}
+	for i, hook := range store.rotationHooks {
+		err = hook.Commit()
+		if err != nil {
+			for _, committed := range store.rotationHooks[:i] {
+				committed.Rollback()
+			}
+			store.rollbackRotatedFiles(files)
+			return 0, err
+		}
+	}
+	// all files are encrypted with the new secret from now on
+	for _, namespace := range namespaces {
+		namespace.secret = secret
+	}
+	settings.Secret = settings.NextSecret
+	settings.NextSecret = ""
+	err = updateFSStoreSettings(store.path, settings)
+	if err != nil {
+		return 0, err
+	}
+	store.logger.Info().Msgf("Rotated store secret (%d files re-encrypted)", len(files))
+	return len(files), nil
+}
+
+func secretKeyDerivation(secret *security.Secret) func(purpose string, length int) ([]byte, error) {
+	return func(purpose string, length int) ([]byte, error) {
+		return deriveKey(secret, purpose, length)
+	}
+}
+
+// Complete an interrupted secret rotation (see RotateSecret).
+func (store *FSStore) resumeSecretRotation(settings *fsStoreSettings) error {
+	if settings.NextSecret == "" {
+		return nil
+	}
+	if store.readOnly {
+		store.logger.Warn().Msg("Skipping completion of interrupted secret rotation (store is read-only)")
+		return nil
+	}
+	_, err := store.RotateSecret()
+	if err != nil {
+		return fmt.Errorf("failed to complete interrupted secret rotation (cause: %w)", err)
+	}
+	return nil
+}
+
+// Re-encrypt the key files and the encrypted entry files of this namespace using the given secret.
+func (store *FSStore) reencryptFiles(secret *security.Secret) ([]rotatedFile, error) {
+	rotated := *store
+	rotated.secret = secret
+	rotated.entryEncryption = true
+	files := make([]rotatedFile, 0)
+	for _, name := range store.index.entries {
+		keyFile, err := store.reencryptKeyFile(name, secret)
+		if err != nil {
+			return nil, err
+		}
+		if keyFile != nil {
+			files = append(files, *keyFile)
+		}
+		for _, extension := range []string{attributesExtension, csrExtension} {
+			entryFile, err := store.reencryptEntryFile(name, extension, &rotated)
+			if err != nil {
+				return nil, err
+			}
+			if entryFile != nil {
+				files = append(files, *entryFile)
+			}
+		}
+	}
+	return files, nil
+}
+
+// Re-encrypt the given entry's key file (nil is returned, if there is no key file or it is already encrypted with
+// the given secret).
+func (store *FSStore) reencryptKeyFile(name string, secret *security.Secret) (*rotatedFile, error) {
+	keyFilePath := store.entryPath(name, keyExtension)
+	keyFileBytes, err := os.ReadFile(keyFilePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read key file '%s' (cause: %w)", keyFilePath, err)
+	}
+	pemBlock, _ := pem.Decode(keyFileBytes)
+	if pemBlock == nil {
+		return nil, fmt.Errorf("failed to decode key file '%s'", keyFilePath)
+	}
+	keyBytes, err := decryptKeyBlock(pemBlock, store.secret)
+	if err != nil {
+		_, rotatedErr := decryptKeyBlock(pemBlock, secret)
+		if rotatedErr == nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to decrypt key from file '%s' (cause: %w)", keyFilePath, err)
+	}
+	rotatedBlock, err := x509.EncryptPEMBlock(rand.Reader, pemBlock.Type, keyBytes, secret.UnwrapBytes(), x509.PEMCipherAES256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt private key (cause: %w)", err)
+	}
+	return &rotatedFile{path: keyFilePath, original: keyFileBytes, rotated: pem.EncodeToMemory(rotatedBlock)}, nil
+}
+
+// Decrypt the given key block and verify the result (as legacy PEM encryption does not reliably detect a wrong
+// secret).
+func decryptKeyBlock(pemBlock *pem.Block, secret *security.Secret) ([]byte, error) {
+	keyBytes, err := x509.DecryptPEMBlock(pemBlock, secret.UnwrapBytes())
+	if err != nil {
+		return nil, err
+	}
+	_, err = x509.ParsePKCS8PrivateKey(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return keyBytes, nil
+}
+
+// Re-encrypt the given encrypted entry file (nil is returned, if the file does not exist, is not encrypted or is
+// already encrypted with the rotated store's secret).
+func (store *FSStore) reencryptEntryFile(name string, extension string, rotated *FSStore) (*rotatedFile, error) {
+	filePath := store.entryPath(name, extension)
+	fileBytes, err := os.ReadFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read file '%s' (cause: %w)", filePath, err)
+	}
+	if !bytes.HasPrefix(fileBytes, encryptedEntryMagic) {
+		return nil, nil
+	}
+	fileName := store.entryFile(name) + extension
+	data, err := store.openEntryFile(fileName, fileBytes)
+	if err != nil {
+		_, rotatedErr := rotated.openEntryFile(fileName, fileBytes)
+		if rotatedErr == nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	rotatedBytes, err := rotated.sealEntryFile(fileName, data)
+	if err != nil {
+		return nil, err
+	}
+	return &rotatedFile{path: filePath, original: fileBytes, rotated: rotatedBytes}, nil
+}
+
+func stageRotatedFiles(files []rotatedFile) error {
+	for i, file := range files {
+		updateFilePath := file.path + updateExtension
+		err := os.WriteFile(updateFilePath, file.rotated, storeFilePerm)
+		if err != nil {
+			removeStagedFiles(files[:i+1])
+			return fmt.Errorf("failed to write file '%s' (cause: %w)", updateFilePath, err)
+		}
+	}
+	return nil
+}
+
+func removeStagedFiles(files []rotatedFile) {
+	for _, file := range files {
+		os.Remove(file.path + updateExtension)
+	}
+}
+
+// Restore the original content of the given (already replaced) files.
+func (store *FSStore) rollbackRotatedFiles(files []rotatedFile) {
+	for _, file := range files {
+		updateFilePath := file.path + updateExtension
+		err := os.WriteFile(updateFilePath, file.original, storeFilePerm)
+		if err == nil {
+			err = os.Rename(updateFilePath, file.path)
+		}
+		if err != nil {
+			os.Remove(updateFilePath)
+			store.logger.Error().Err(err).Msgf("Failed to restore file '%s' during secret rotation rollback", file.path)
+		}
+	}
+}
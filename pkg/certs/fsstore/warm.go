@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fsstore
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/jellydator/ttlcache/v3"
+)
+
+const parseCacheFile = ".parsecache"
+
+// Set the capacity of the certificate and attributes caches (default: 100).
+//
+// To benefit from cache warming (see WithCacheWarming), the capacity should exceed the number of store entries.
+func WithCacheCapacity(capacity uint64) Option {
+	return func(store *FSStore) {
+		store.certificateCache = ttlcache.New(ttlcache.WithCapacity[string, *x509.Certificate](capacity))
+		store.attributesCache = ttlcache.New(ttlcache.WithCapacity[string, *certs.StoreEntryAttributes](capacity))
+	}
+}
+
+// Pre-load the certificate and attributes caches in the background after the store has been opened.
+//
+// To speed up warming, the parsed certificates are persisted in a parse cache file. Cached certificates are taken
+// over as long as the modification time and size of the corresponding certificate file are unchanged.
+func WithCacheWarming() Option {
+	return func(store *FSStore) {
+		store.warmCaches = true
+	}
+}
+
+type fsStoreWarmer struct {
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	duration time.Duration
+}
+
+// A certificate recorded in the parse cache file.
+type fsStoreParsedCertificate struct {
+	Name        string `json:"name"`
+	ModTime     int64  `json:"mod_time"`
+	Size        int64  `json:"size"`
+	Certificate []byte `json:"certificate"`
+}
+
+func (store *FSStore) startWarming() {
+	warmer := &fsStoreWarmer{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	store.index.lock.Lock()
+	store.warmer = warmer
+	store.index.lock.Unlock()
+	go func() {
+		defer close(warmer.done)
+		start := time.Now()
+		warmed, completed := store.warm(warmer.stop)
+		if !completed {
+			store.logger.Info().Msgf("Cache warming stopped (%d entries warmed)", warmed)
+			return
+		}
+		store.index.lock.Lock()
+		warmer.duration = time.Since(start)
+		store.index.lock.Unlock()
+		store.logger.Info().Msgf("Cache warming completed (%d entries warmed in %s)", warmed, warmer.duration)
+	}()
+}
+
+func (store *FSStore) stopWarming() {
+	// the warmer itself requires the index lock to complete; therefore do not hold it while waiting
+	store.index.lock.Lock()
+	warmer := store.warmer
+	store.warmer = nil
+	store.index.lock.Unlock()
+	if warmer == nil {
+		return
+	}
+	warmer.stopOnce.Do(func() {
+		close(warmer.stop)
+	})
+	<-warmer.done
+}
+
+// Get the duration of the completed cache warming (requires the index lock to be held by the caller).
+func (store *FSStore) warmDuration() time.Duration {
+	if store.warmer == nil {
+		return 0
+	}
+	return store.warmer.duration
+}
+
+func (store *FSStore) warm(stop chan struct{}) (int, bool) {
+	store.logger.Info().Msg("Warming caches...")
+	persisted := store.readParseCache()
+	store.index.lock.RLock()
+	entries := append([]string{}, store.index.entries...)
+	store.index.lock.RUnlock()
+	parsed := make([]fsStoreParsedCertificate, 0, len(entries))
+	for warmed, name := range entries {
+		select {
+		case <-stop:
+			return warmed, false
+		default:
+		}
+		parsedCertificate := store.warmEntry(name, persisted[name])
+		if parsedCertificate != nil {
+			parsed = append(parsed, *parsedCertificate)
+		}
+	}
+	store.writeParseCache(parsed)
+	return len(entries), true
+}
+
+// Load the given entry into the caches (holding the index lock to not interfere with concurrent updates).
+func (store *FSStore) warmEntry(name string, persisted *fsStoreParsedCertificate) *fsStoreParsedCertificate {
+	store.index.lock.RLock()
+	defer store.index.lock.RUnlock()
+	if store.hasAttributes(name) {
+		_, err := store.readAttributes(name)
+		if err != nil {
+			store.logger.Warn().Err(err).Msgf("Failed to warm attributes of store entry '%s'", name)
+		}
+	}
+	crtFileInfo, err := os.Stat(store.entryPath(name, crtExtension))
+	if err != nil {
+		return nil
+	}
+	if persisted != nil && persisted.ModTime == crtFileInfo.ModTime().UnixNano() && persisted.Size == crtFileInfo.Size() && store.certificateCache.Get(name) == nil {
+		certificate, err := x509.ParseCertificate(persisted.Certificate)
+		if err == nil {
+			store.certificateCache.Set(name, certificate, ttlcache.NoTTL)
+			return persisted
+		}
+		store.logger.Warn().Err(err).Msgf("Ignoring broken parse cache record of store entry '%s'", name)
+	}
+	certificate, err := store.readCertificate(name)
+	if err != nil || certificate == nil {
+		if err != nil {
+			store.logger.Warn().Err(err).Msgf("Failed to warm certificate of store entry '%s'", name)
+		}
+		return nil
+	}
+	return &fsStoreParsedCertificate{
+		Name:        name,
+		ModTime:     crtFileInfo.ModTime().UnixNano(),
+		Size:        crtFileInfo.Size(),
+		Certificate: certificate.Raw,
+	}
+}
+
+func (store *FSStore) readParseCache() map[string]*fsStoreParsedCertificate {
+	persisted := make(map[string]*fsStoreParsedCertificate)
+	parseCacheFilePath := filepath.Join(store.path, parseCacheFile)
+	parseCacheBytes, err := os.ReadFile(parseCacheFilePath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			store.logger.Warn().Err(err).Msgf("Ignoring unreadable parse cache '%s'", parseCacheFilePath)
+		}
+		return persisted
+	}
+	var parsed []fsStoreParsedCertificate
+	err = json.Unmarshal(parseCacheBytes, &parsed)
+	if err != nil {
+		store.logger.Warn().Err(err).Msgf("Ignoring broken parse cache '%s'", parseCacheFilePath)
+		return persisted
+	}
+	for i := range parsed {
+		persisted[parsed[i].Name] = &parsed[i]
+	}
+	return persisted
+}
+
+// Persist the parse cache.
+//
+// As the cache is validated against the certificate files during warming, failures are only logged.
+func (store *FSStore) writeParseCache(parsed []fsStoreParsedCertificate) {
+	if store.readOnly {
+		return
+	}
+	parseCacheFilePath := filepath.Join(store.path, parseCacheFile)
+	parseCacheBytes, err := json.Marshal(parsed)
+	if err == nil {
+		updateFilePath := parseCacheFilePath + updateExtension
+		err = os.WriteFile(updateFilePath, parseCacheBytes, storeFilePerm)
+		if err == nil {
+			err = os.Rename(updateFilePath, parseCacheFilePath)
+		}
+		if err != nil {
+			os.Remove(updateFilePath)
+		}
+	}
+	if err != nil {
+		store.logger.Warn().Err(err).Msgf("Failed to write parse cache '%s'", parseCacheFilePath)
+	}
+}
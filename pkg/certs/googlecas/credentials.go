@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package googlecas
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+const defaultTokenURI = "https://oauth2.googleapis.com/token"
+
+var metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+type tokenSource interface {
+	token() (string, error)
+}
+
+// Credentials file as written by gcloud (service account key or application default credentials).
+type credentialsFile struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type scopeClaims struct {
+	Scope string `json:"scope"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+	TokenType   string `json:"token_type"`
+}
+
+// Create an access token source for Google Cloud APIs (e.g. Secret Manager).
+//
+// The credentials are resolved like the provider's credentials (see Config). The returned function caches the
+// access token until it is about to expire.
+func NewTokenSource(credentialsPath string, client *http.Client) (func() (string, error), error) {
+	source, err := resolveTokenSource(credentialsPath, client)
+	if err != nil {
+		return nil, err
+	}
+	return source.token, nil
+}
+
+func resolveTokenSource(credentialsPath string, client *http.Client) (tokenSource, error) {
+	if credentialsPath == "" {
+		credentialsPath = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if credentialsPath == "" {
+		configDir, err := os.UserConfigDir()
+		if err == nil {
+			defaultPath := filepath.Join(configDir, "gcloud", "application_default_credentials.json")
+			_, err = os.Stat(defaultPath)
+			if err == nil {
+				credentialsPath = defaultPath
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+	if credentialsPath == "" {
+		return &cachingTokenSource{fetch: func() (*tokenResponse, error) {
+			return fetchMetadataToken(client)
+		}}, nil
+	}
+	credentialsBytes, err := os.ReadFile(credentialsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file '%s' (cause: %w)", credentialsPath, err)
+	}
+	credentials := &credentialsFile{}
+	err = json.Unmarshal(credentialsBytes, credentials)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode credentials file '%s' (cause: %w)", credentialsPath, err)
+	}
+	if credentials.TokenURI == "" {
+		credentials.TokenURI = defaultTokenURI
+	}
+	switch credentials.Type {
+	case "service_account":
+		keyBlock, _ := pem.Decode([]byte(credentials.PrivateKey))
+		if keyBlock == nil {
+			return nil, fmt.Errorf("missing service account key in credentials file '%s'", credentialsPath)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode service account key in credentials file '%s' (cause: %w)", credentialsPath, err)
+		}
+		return &cachingTokenSource{fetch: func() (*tokenResponse, error) {
+			return fetchServiceAccountToken(client, credentials, key)
+		}}, nil
+	case "authorized_user":
+		return &cachingTokenSource{fetch: func() (*tokenResponse, error) {
+			return fetchToken(client, credentials.TokenURI, url.Values{
+				"grant_type":    {"refresh_token"},
+				"client_id":     {credentials.ClientID},
+				"client_secret": {credentials.ClientSecret},
+				"refresh_token": {credentials.RefreshToken},
+			})
+		}}, nil
+	}
+	return nil, fmt.Errorf("unsupported credentials type '%s' in credentials file '%s'", credentials.Type, credentialsPath)
+}
+
+type cachingTokenSource struct {
+	fetch       func() (*tokenResponse, error)
+	lock        sync.Mutex
+	accessToken string
+	expiry      time.Time
+}
+
+func (source *cachingTokenSource) token() (string, error) {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	if source.accessToken != "" && time.Now().Before(source.expiry) {
+		return source.accessToken, nil
+	}
+	response, err := source.fetch()
+	if err != nil {
+		return "", err
+	}
+	source.accessToken = response.AccessToken
+	// refresh tokens a minute ahead of their expiry
+	source.expiry = time.Now().Add(time.Duration(response.ExpiresIn)*time.Second - time.Minute)
+	return source.accessToken, nil
+}
+
+func fetchServiceAccountToken(client *http.Client, credentials *credentialsFile, key any) (*tokenResponse, error) {
+	options := (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", credentials.PrivateKeyID)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create service account token signer (cause: %w)", err)
+	}
+	now := time.Now()
+	claims := &jwt.Claims{
+		Issuer:   credentials.ClientEmail,
+		Audience: jwt.Audience{credentials.TokenURI},
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+	assertion, err := jwt.Signed(signer).Claims(claims).Claims(&scopeClaims{Scope: cloudPlatformScope}).CompactSerialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign service account token (cause: %w)", err)
+	}
+	return fetchToken(client, credentials.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+}
+
+func fetchToken(client *http.Client, tokenURI string, form url.Values) (*tokenResponse, error) {
+	resp, err := client.PostForm(tokenURI, form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send token request '%s' (cause: %w)", tokenURI, err)
+	}
+	return decodeTokenResponse(resp, tokenURI)
+}
+
+func fetchMetadataToken(client *http.Client) (*tokenResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, metadataTokenURL+"?"+url.Values{"scopes": {cloudPlatformScope}}.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare token request '%s' (cause: %w)", metadataTokenURL, err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send token request '%s' (cause: %w)", metadataTokenURL, err)
+	}
+	return decodeTokenResponse(resp, metadataTokenURL)
+}
+
+func decodeTokenResponse(resp *http.Response, tokenURI string) (*tokenResponse, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request '%s' failed (status: %s)", tokenURI, resp.Status)
+	}
+	response := &tokenResponse{}
+	err := json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token response '%s' (cause: %w)", tokenURI, err)
+	}
+	if !strings.EqualFold(response.TokenType, "Bearer") || response.AccessToken == "" {
+		return nil, fmt.Errorf("unexpected token response '%s' (type: '%s')", tokenURI, response.TokenType)
+	}
+	return response, nil
+}
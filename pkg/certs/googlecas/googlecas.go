@@ -0,0 +1,272 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package googlecas
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/rs/zerolog"
+)
+
+// ProviderType is the type name used to define Google Certificate Authority Service providers in the provider configuration.
+const ProviderType = "google-cas"
+
+// ProviderPrefix is prepended to the provider name to build the CA name.
+const ProviderPrefix = "GCP:"
+
+const defaultEndpoint = "https://privateca.googleapis.com"
+
+// Config defines the Google Certificate Authority Service CA pool to submit certificate requests to.
+//
+// Credentials are taken from the given service account key file, the application default credentials or
+// the metadata server (in that order).
+type Config struct {
+	Project     string        `yaml:"project"`
+	Location    string        `yaml:"location"`
+	Pool        string        `yaml:"pool"`
+	CA          string        `yaml:"ca"`
+	Template    string        `yaml:"template"`
+	Validity    time.Duration `yaml:"validity"`
+	Credentials string        `yaml:"credentials"`
+	Endpoint    string        `yaml:"endpoint"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+func init() {
+	certs.RegisterProviderType(&certs.ProviderType{
+		Name: ProviderType,
+		NewConfig: func() any {
+			return &Config{
+				Validity: 90 * 24 * time.Hour,
+				Timeout:  30 * time.Second,
+			}
+		},
+		New: func(name string, config any) (certs.CertificateProvider, error) {
+			return NewProvider(name, config.(*Config))
+		},
+	})
+}
+
+type casProvider struct {
+	name        string
+	config      *Config
+	endpoint    string
+	tokenSource tokenSource
+	client      *http.Client
+}
+
+// Create a provider submitting certificate requests to the given Google Certificate Authority Service CA pool.
+func NewProvider(name string, config *Config) (certs.CertificateProvider, error) {
+	if config.Project == "" || config.Location == "" || config.Pool == "" {
+		return nil, fmt.Errorf("missing project, location or CA pool for provider '%s'", name)
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+	client := &http.Client{Timeout: config.Timeout}
+	tokenSource, err := resolveTokenSource(config.Credentials, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve Google credentials for provider '%s' (cause: %w)", name, err)
+	}
+	return &casProvider{
+		name:        name,
+		config:      config,
+		endpoint:    strings.TrimSuffix(endpoint, "/"),
+		tokenSource: tokenSource,
+		client:      client,
+	}, nil
+}
+
+func (provider *casProvider) Name() string {
+	return provider.name
+}
+
+func (provider *casProvider) CAs() []string {
+	return []string{ProviderPrefix + provider.name}
+}
+
+func (provider *casProvider) NewCertificateFactory(ctx context.Context, ca string, request *certs.ProviderRequest) (certs.CertificateFactory, error) {
+	if ca != ProviderPrefix+provider.name {
+		return nil, fmt.Errorf("unrecognized Google CAS CA '%s'", ca)
+	}
+	validity := provider.config.Validity
+	if request.Params["validity"] != "" {
+		parsed, err := time.ParseDuration(request.Params["validity"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid validity '%s' (cause: %w)", request.Params["validity"], err)
+		}
+		validity = parsed
+	}
+	template := provider.config.Template
+	if request.Params["template"] != "" {
+		template = request.Params["template"]
+	}
+	if template != "" && !strings.Contains(template, "/") {
+		template = fmt.Sprintf("projects/%s/locations/%s/certificateTemplates/%s", provider.config.Project, provider.config.Location, template)
+	}
+	logger := logging.RootLogger().With().Str("Provider", ca).Logger()
+	return &CASCertificateFactory{
+		provider: provider,
+		name:     ca,
+		request:  request,
+		validity: validity,
+		template: template,
+		logger:   &logger,
+	}, nil
+}
+
+type CASCertificateFactory struct {
+	provider *casProvider
+	name     string
+	request  *certs.ProviderRequest
+	validity time.Duration
+	template string
+	chain    []*x509.Certificate
+	logger   *zerolog.Logger
+}
+
+func (factory *CASCertificateFactory) Name() string {
+	return factory.name
+}
+
+func (factory *CASCertificateFactory) Chain() []*x509.Certificate {
+	return factory.chain
+}
+
+type certificateRequest struct {
+	PEMCSR              string `json:"pemCsr"`
+	Lifetime            string `json:"lifetime"`
+	CertificateTemplate string `json:"certificateTemplate,omitempty"`
+}
+
+type certificateResponse struct {
+	Name                string   `json:"name"`
+	PEMCertificate      string   `json:"pemCertificate"`
+	PEMCertificateChain []string `json:"pemCertificateChain"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+func (factory *CASCertificateFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error) {
+	key, csr, err := factory.request.NewCertificateRequest(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	idBytes := make([]byte, 16)
+	_, err = rand.Read(idBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate certificate id (cause: %w)", err)
+	}
+	config := factory.provider.config
+	query := url.Values{}
+	query.Set("certificateId", "certd-"+hex.EncodeToString(idBytes))
+	query.Set("requestId", formatUUID(idBytes))
+	if config.CA != "" {
+		query.Set("issuingCertificateAuthorityId", config.CA)
+	}
+	createURL := fmt.Sprintf("%s/v1/projects/%s/locations/%s/caPools/%s/certificates?%s", factory.provider.endpoint, url.PathEscape(config.Project), url.PathEscape(config.Location), url.PathEscape(config.Pool), query.Encode())
+	factory.logger.Info().Msgf("Submitting certificate request '%s' to Google CAS pool '%s'...", csr.Subject, config.Pool)
+	create := &certificateRequest{
+		PEMCSR:              string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw})),
+		Lifetime:            fmt.Sprintf("%ds", int64(factory.validity.Seconds())),
+		CertificateTemplate: factory.template,
+	}
+	response, err := factory.provider.create(ctx, createURL, create)
+	if err != nil {
+		return nil, nil, err
+	}
+	certificates, err := certs.DecodeCertificates([]byte(response.PEMCertificate))
+	if err != nil || len(certificates) == 0 {
+		return nil, nil, fmt.Errorf("failed to decode issued certificate (cause: %v)", err)
+	}
+	chain := make([]*x509.Certificate, 0, len(response.PEMCertificateChain))
+	for _, issuerPEM := range response.PEMCertificateChain {
+		issuers, err := certs.DecodeCertificates([]byte(issuerPEM))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode certificate chain (cause: %w)", err)
+		}
+		chain = append(chain, issuers...)
+	}
+	factory.chain = chain
+	return key, certificates[0], nil
+}
+
+func (provider *casProvider) create(ctx context.Context, createURL string, create *certificateRequest) (*certificateResponse, error) {
+	body, err := json.Marshal(create)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal certificate request (cause: %w)", err)
+	}
+	token, err := provider.tokenSource.token()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, createURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare certificate request '%s' (cause: %w)", createURL, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := provider.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send certificate request '%s' (cause: %w)", createURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		errResponse := &errorResponse{}
+		err = json.NewDecoder(resp.Body).Decode(errResponse)
+		if err == nil && errResponse.Error.Message != "" {
+			return nil, fmt.Errorf("certificate request '%s' failed (status: %s; message: %s)", createURL, resp.Status, errResponse.Error.Message)
+		}
+		return nil, fmt.Errorf("certificate request '%s' failed (status: %s)", createURL, resp.Status)
+	}
+	response := &certificateResponse{}
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode certificate response '%s' (cause: %w)", createURL, err)
+	}
+	return response, nil
+}
+
+func formatUUID(id []byte) string {
+	uuid := make([]byte, 16)
+	copy(uuid, id)
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package googlecas
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/keys/registry"
+	"github.com/stretchr/testify/require"
+)
+
+const testAccessToken = "access-token"
+
+func TestServiceAccountCredentials(t *testing.T) {
+	cas := newTestCAS(t)
+	defer cas.Close()
+	serviceAccountKeyBytes, err := x509.MarshalPKCS8PrivateKey(cas.serviceAccountKey)
+	require.NoError(t, err)
+	credentialsPath := writeCredentials(t, &credentialsFile{
+		Type:         "service_account",
+		ClientEmail:  "certd@test.iam.gserviceaccount.com",
+		PrivateKeyID: "test",
+		PrivateKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: serviceAccountKeyBytes})),
+		TokenURI:     cas.URL + "/token",
+	})
+	provider, err := certs.NewProvider(ProviderType, "Test", func(config any) error {
+		casConfig := config.(*Config)
+		casConfig.Project = "test"
+		casConfig.Location = "europe-west3"
+		casConfig.Pool = "pool"
+		casConfig.Template = "server"
+		casConfig.Credentials = credentialsPath
+		casConfig.Endpoint = cas.URL
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"GCP:Test"}, provider.CAs())
+	request := &certs.ProviderRequest{
+		Domains:    []string{"www.example.org"},
+		KeyFactory: registry.StandardKey("ECDSA P-256"),
+		Params:     map[string]string{"validity": "24h"},
+	}
+	for i := 0; i < 2; i++ {
+		factory, err := provider.NewCertificateFactory(context.Background(), "GCP:Test", request)
+		require.NoError(t, err)
+		key, certificate, err := factory.New(context.Background())
+		require.NoError(t, err)
+		require.NotNil(t, key)
+		require.Equal(t, "www.example.org", certificate.Subject.CommonName)
+		require.Equal(t, []*x509.Certificate{cas.ca}, factory.(certs.CertificateChainFactory).Chain())
+	}
+	require.Equal(t, 1, cas.tokenRequests)
+}
+
+func TestAuthorizedUserCredentials(t *testing.T) {
+	cas := newTestCAS(t)
+	defer cas.Close()
+	credentialsPath := writeCredentials(t, &credentialsFile{
+		Type:         "authorized_user",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RefreshToken: "refresh",
+		TokenURI:     cas.URL + "/token",
+	})
+	source, err := resolveTokenSource(credentialsPath, http.DefaultClient)
+	require.NoError(t, err)
+	token, err := source.token()
+	require.NoError(t, err)
+	require.Equal(t, testAccessToken, token)
+}
+
+func TestMetadataCredentials(t *testing.T) {
+	cas := newTestCAS(t)
+	defer cas.Close()
+	metadataTokenURL = cas.URL + "/metadata/token"
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	source, err := resolveTokenSource("", http.DefaultClient)
+	require.NoError(t, err)
+	token, err := source.token()
+	require.NoError(t, err)
+	require.Equal(t, testAccessToken, token)
+}
+
+func TestNewProviderInvalidConfig(t *testing.T) {
+	_, err := NewProvider("Test", &Config{Project: "test", Location: "europe-west3"})
+	require.Error(t, err)
+	_, err = NewProvider("Test", &Config{Project: "test", Location: "europe-west3", Pool: "pool", Credentials: writeCredentials(t, &credentialsFile{Type: "external_account"})})
+	require.Error(t, err)
+}
+
+func writeCredentials(t *testing.T, credentials *credentialsFile) string {
+	credentialsBytes, err := json.Marshal(credentials)
+	require.NoError(t, err)
+	credentialsPath := filepath.Join(t.TempDir(), "credentials.json")
+	err = os.WriteFile(credentialsPath, credentialsBytes, 0600)
+	require.NoError(t, err)
+	return credentialsPath
+}
+
+type testCAS struct {
+	*httptest.Server
+	ca                *x509.Certificate
+	serviceAccountKey *rsa.PrivateKey
+	tokenRequests     int
+}
+
+func newTestCAS(t *testing.T) *testCAS {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CAS Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caBytes)
+	require.NoError(t, err)
+	serviceAccountKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	cas := &testCAS{ca: ca, serviceAccountKey: serviceAccountKey}
+	cas.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			cas.tokenRequests++
+			require.NoError(t, r.ParseForm())
+			switch r.PostForm.Get("grant_type") {
+			case "urn:ietf:params:oauth:grant-type:jwt-bearer":
+				assertion, err := jwt.ParseSigned(r.PostForm.Get("assertion"))
+				require.NoError(t, err)
+				claims := &jwt.Claims{}
+				scope := &scopeClaims{}
+				require.NoError(t, assertion.Claims(serviceAccountKey.Public(), claims, scope))
+				require.NoError(t, claims.Validate(jwt.Expected{Issuer: "certd@test.iam.gserviceaccount.com", Audience: jwt.Audience{cas.URL + "/token"}}))
+				require.Equal(t, cloudPlatformScope, scope.Scope)
+			case "refresh_token":
+				require.Equal(t, "refresh", r.PostForm.Get("refresh_token"))
+			default:
+				require.Fail(t, "unexpected grant type")
+			}
+		case r.URL.Path == "/metadata/token":
+			require.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+		case r.URL.Path == "/v1/projects/test/locations/europe-west3/caPools/pool/certificates":
+			require.Equal(t, "Bearer "+testAccessToken, r.Header.Get("Authorization"))
+			require.True(t, strings.HasPrefix(r.URL.Query().Get("certificateId"), "certd-"))
+			create := &certificateRequest{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(create))
+			require.Equal(t, "86400s", create.Lifetime)
+			require.Equal(t, "projects/test/locations/europe-west3/certificateTemplates/server", create.CertificateTemplate)
+			csrBlock, _ := pem.Decode([]byte(create.PEMCSR))
+			require.NotNil(t, csrBlock)
+			csr, err := x509.ParseCertificateRequest(csrBlock.Bytes)
+			require.NoError(t, err)
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(2),
+				Subject:      csr.Subject,
+				DNSNames:     csr.DNSNames,
+				NotBefore:    time.Now(),
+				NotAfter:     time.Now().Add(24 * time.Hour),
+			}
+			certificateBytes, err := x509.CreateCertificate(rand.Reader, template, ca, csr.PublicKey, caKey)
+			require.NoError(t, err)
+			require.NoError(t, json.NewEncoder(w).Encode(&certificateResponse{
+				PEMCertificate:      string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateBytes})),
+				PEMCertificateChain: []string{string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caBytes}))},
+			}))
+			return
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(&tokenResponse{AccessToken: testAccessToken, ExpiresIn: 3600, TokenType: "Bearer"}))
+	}))
+	return cas
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package imported
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/rs/zerolog"
+)
+
+const ProviderName = "Import"
+
+type ImportCertificateFactory struct {
+	certificate *x509.Certificate
+	logger      *zerolog.Logger
+}
+
+// Create a CertificateFactory for an externally issued certificate (without key).
+func NewImportCertificateFactory(certificate *x509.Certificate) certs.CertificateFactory {
+	logger := logging.RootLogger().With().Str("Provider", ProviderName).Logger()
+	return &ImportCertificateFactory{
+		certificate: certificate,
+		logger:      &logger,
+	}
+}
+
+func (factory *ImportCertificateFactory) Name() string {
+	return ProviderName
+}
+
+func (factory *ImportCertificateFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error) {
+	factory.logger.Info().Msgf("Importing X.509 certificate '%s'...", factory.certificate.Subject)
+	return nil, factory.certificate, nil
+}
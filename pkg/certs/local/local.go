@@ -18,13 +18,14 @@
 package local
 
 import (
+	"context"
 	"crypto"
-	"crypto/rand"
 	"crypto/x509"
 	"fmt"
 
 	"github.com/hdecarne-github/certd/internal/logging"
 	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/entropy"
 	"github.com/hdecarne-github/certd/pkg/keys"
 	"github.com/rs/zerolog"
 )
@@ -35,11 +36,11 @@ type LocalCertificateFactory struct {
 	template   *x509.Certificate
 	keyFactory keys.KeyPairFactory
 	parent     *x509.Certificate
-	signer     crypto.PrivateKey
+	signer     crypto.Signer
 	logger     *zerolog.Logger
 }
 
-func NewLocalCertificateFactory(template *x509.Certificate, keyFactory keys.KeyPairFactory, parent *x509.Certificate, signer crypto.PrivateKey) certs.CertificateFactory {
+func NewLocalCertificateFactory(template *x509.Certificate, keyFactory keys.KeyPairFactory, parent *x509.Certificate, signer crypto.Signer) certs.CertificateFactory {
 	logger := logging.RootLogger().With().Str("Provider", ProviderName).Logger()
 	return &LocalCertificateFactory{
 		template:   template,
@@ -54,19 +55,22 @@ func (factory *LocalCertificateFactory) Name() string {
 	return ProviderName
 }
 
-func (factory *LocalCertificateFactory) New() (crypto.PrivateKey, *x509.Certificate, error) {
-	keyPair, err := factory.keyFactory.New()
+func (factory *LocalCertificateFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error) {
+	keyPair, err := keys.NewKeyPair(ctx, factory.keyFactory)
 	if err != nil {
 		return nil, nil, err
 	}
-	var certificateBytes []byte
-	if factory.parent != nil {
-		// parent signed
-		certificateBytes, err = x509.CreateCertificate(rand.Reader, factory.template, factory.parent, keyPair.Public(), factory.signer)
-	} else {
+	parent := factory.parent
+	signer := factory.signer
+	if parent == nil {
 		// self-signed
-		certificateBytes, err = x509.CreateCertificate(rand.Reader, factory.template, factory.template, keyPair.Public(), keyPair.Private())
+		parent = factory.template
+		signer, err = certs.KeySigner(keyPair.Private())
+		if err != nil {
+			return nil, nil, err
+		}
 	}
+	certificateBytes, err := x509.CreateCertificate(entropy.ContextReader(ctx), factory.template, parent, keyPair.Public(), signer)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create certificate (cause: %w)", err)
 	}
@@ -76,3 +80,110 @@ func (factory *LocalCertificateFactory) New() (crypto.PrivateKey, *x509.Certific
 	}
 	return keyPair.Private(), certificate, nil
 }
+
+type LocalCSRCertificateFactory struct {
+	template           *x509.Certificate
+	certificateRequest *x509.CertificateRequest
+	parent             *x509.Certificate
+	signer             crypto.Signer
+	logger             *zerolog.Logger
+}
+
+// Create a certificate factory signing the given certificate request.
+//
+// As the key is held by the requestor, the factory does not return a key.
+func NewLocalCSRCertificateFactory(template *x509.Certificate, certificateRequest *x509.CertificateRequest, parent *x509.Certificate, signer crypto.Signer) certs.CertificateFactory {
+	logger := logging.RootLogger().With().Str("Provider", ProviderName).Logger()
+	return &LocalCSRCertificateFactory{
+		template:           template,
+		certificateRequest: certificateRequest,
+		parent:             parent,
+		signer:             signer,
+		logger:             &logger,
+	}
+}
+
+func (factory *LocalCSRCertificateFactory) Name() string {
+	return ProviderName
+}
+
+func (factory *LocalCSRCertificateFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error) {
+	err := factory.certificateRequest.CheckSignature()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate request signature (cause: %w)", err)
+	}
+	template := *factory.template
+	template.RawSubject = factory.certificateRequest.RawSubject
+	template.DNSNames = factory.certificateRequest.DNSNames
+	template.EmailAddresses = factory.certificateRequest.EmailAddresses
+	template.IPAddresses = factory.certificateRequest.IPAddresses
+	template.URIs = factory.certificateRequest.URIs
+	certificateBytes, err := x509.CreateCertificate(entropy.ContextReader(ctx), &template, factory.parent, factory.certificateRequest.PublicKey, factory.signer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate (cause: %w)", err)
+	}
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed parse certificate bytes (cause: %w)", err)
+	}
+	return nil, certificate, nil
+}
+
+type LocalPublicKeyCertificateFactory struct {
+	template  *x509.Certificate
+	publicKey crypto.PublicKey
+	parent    *x509.Certificate
+	signer    crypto.Signer
+	logger    *zerolog.Logger
+}
+
+// Create a certificate factory issuing a certificate for an externally generated key.
+//
+// As the key is held elsewhere (e.g. in a HSM), the factory does not return a key.
+func NewLocalPublicKeyCertificateFactory(template *x509.Certificate, publicKey crypto.PublicKey, parent *x509.Certificate, signer crypto.Signer) certs.CertificateFactory {
+	logger := logging.RootLogger().With().Str("Provider", ProviderName).Logger()
+	return &LocalPublicKeyCertificateFactory{
+		template:  template,
+		publicKey: publicKey,
+		parent:    parent,
+		signer:    signer,
+		logger:    &logger,
+	}
+}
+
+func (factory *LocalPublicKeyCertificateFactory) Name() string {
+	return ProviderName
+}
+
+func (factory *LocalPublicKeyCertificateFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error) {
+	certificateBytes, err := x509.CreateCertificate(entropy.ContextReader(ctx), factory.template, factory.parent, factory.publicKey, factory.signer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate (cause: %w)", err)
+	}
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed parse certificate bytes (cause: %w)", err)
+	}
+	return nil, certificate, nil
+}
+
+type localProvider struct{}
+
+// Create the certificate provider for locally generated certificates.
+//
+// The provider only supports its dedicated generate request (/api/store/local/generate).
+func NewProvider() certs.CertificateProvider {
+	return &localProvider{}
+}
+
+func (provider *localProvider) Name() string {
+	return ProviderName
+}
+
+func (provider *localProvider) CAs() []string {
+	return []string{ProviderName}
+}
+
+func (provider *localProvider) NewCertificateFactory(ctx context.Context, ca string, request *certs.ProviderRequest) (certs.CertificateFactory, error) {
+	return nil, certs.ErrGenericRequestUnsupported
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package local
+
+import (
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/clock"
+)
+
+const DefaultNotBeforeSkew = 5 * time.Minute
+
+// Resolve the validity period of a certificate to be issued.
+//
+// A zero start defaults to the current time of the given clock. Starts within the given skew around the
+// current time are backdated by the skew, so that certificates are accepted by hosts with lagging clocks.
+// Odd periods are not rejected, but reported via the returned warnings.
+func ResolveValidity(clk clock.Clock, skew time.Duration, notBefore time.Time, notAfter time.Time) (time.Time, time.Time, []string) {
+	now := clk.Now()
+	if notBefore.IsZero() {
+		notBefore = now
+	}
+	if skew > 0 && notBefore.After(now.Add(-skew)) && !notBefore.After(now.Add(skew)) {
+		notBefore = now.Add(-skew)
+	}
+	warnings := make([]string, 0)
+	if !notAfter.After(notBefore) {
+		warnings = append(warnings, "validity period ends before it starts")
+	} else if !notAfter.After(now) {
+		warnings = append(warnings, "validity period has already ended")
+	}
+	if notBefore.After(now.Add(skew)) {
+		warnings = append(warnings, "validity period starts in the future")
+	}
+	return notBefore, notAfter, warnings
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package local
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveValidity(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.Fixed(now)
+	skew := DefaultNotBeforeSkew
+	// unset start is backdated
+	notBefore, notAfter, warnings := ResolveValidity(clk, skew, time.Time{}, now.Add(time.Hour))
+	require.Equal(t, now.Add(-skew), notBefore)
+	require.Equal(t, now.Add(time.Hour), notAfter)
+	require.Empty(t, warnings)
+	// start close to now is backdated
+	notBefore, _, warnings = ResolveValidity(clk, skew, now.Add(time.Minute), now.Add(time.Hour))
+	require.Equal(t, now.Add(-skew), notBefore)
+	require.Empty(t, warnings)
+	// explicit start in the past is kept
+	notBefore, _, warnings = ResolveValidity(clk, skew, now.Add(-time.Hour), now.Add(time.Hour))
+	require.Equal(t, now.Add(-time.Hour), notBefore)
+	require.Empty(t, warnings)
+	// no skew
+	notBefore, _, _ = ResolveValidity(clk, 0, time.Time{}, now.Add(time.Hour))
+	require.Equal(t, now, notBefore)
+	// odd periods
+	_, _, warnings = ResolveValidity(clk, skew, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	require.Equal(t, []string{"validity period has already ended"}, warnings)
+	_, _, warnings = ResolveValidity(clk, skew, now.Add(time.Hour), now)
+	require.Equal(t, []string{"validity period ends before it starts", "validity period starts in the future"}, warnings)
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memstore
+
+import (
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/clock"
+)
+
+// Get the archived store entries.
+func (store *MemStore) ArchivedEntries() certs.StoreEntries {
+	return store.archive.Entries()
+}
+
+// Get an archived store entry.
+func (store *MemStore) ArchivedEntry(name string) (certs.StoreEntry, error) {
+	return store.archive.Entry(name)
+}
+
+// Move a store entry into the archive namespace.
+//
+// Archived entries are hidden from the store's entry listing but retained until they are purged. The time of
+// archival is recorded in the entry's attributes.
+func (store *MemStore) ArchiveEntry(name string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.archive.lock.Lock()
+	defer store.archive.lock.Unlock()
+	return store.moveEntry(name, store.archive, func(attributes *certs.StoreEntryAttributes) {
+		archived := store.now().UTC()
+		attributes.Archived = &archived
+	})
+}
+
+// Move an archived store entry back into the store.
+func (store *MemStore) RestoreEntry(name string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.archive.lock.Lock()
+	defer store.archive.lock.Unlock()
+	return store.archive.moveEntry(name, store, func(attributes *certs.StoreEntryAttributes) {
+		attributes.Archived = nil
+	})
+}
+
+// Permanently delete an archived store entry.
+//
+// Whether an entry may be purged (e.g. with respect to a retention policy) is up to the caller.
+func (store *MemStore) PurgeEntry(name string) error {
+	archive := store.archive
+	archive.lock.Lock()
+	defer archive.lock.Unlock()
+	if archive.entries[name] == nil {
+		return fmt.Errorf("failed to purge archived store entry '%s' (cause: %w)", name, fs.ErrNotExist)
+	}
+	archive.remove(name)
+	return nil
+}
+
+// Move the given entry to the target store instance (the caller has to hold both locks).
+func (store *MemStore) moveEntry(name string, target *MemStore, update func(attributes *certs.StoreEntryAttributes)) error {
+	data := store.entries[name]
+	if data == nil {
+		return fmt.Errorf("failed to move store entry '%s' (cause: %w)", name, fs.ErrNotExist)
+	}
+	// entry data may still be accessed by readers of the source entry, hence update a copy
+	moved := *data
+	moved.attributes = copyAttributes(&data.attributes)
+	update(&moved.attributes)
+	err := target.add(name, &moved)
+	if err != nil {
+		return err
+	}
+	store.remove(name)
+	return nil
+}
+
+func (store *MemStore) now() time.Time {
+	if store.clock == nil {
+		return clock.Now()
+	}
+	return store.clock.Now()
+}
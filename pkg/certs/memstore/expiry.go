@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memstore
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+// Get the store entries expiring within the given time range (from exclusive, until inclusive) ordered by expiry.
+func (store *MemStore) Expiries(from time.Time, until time.Time) []certs.StoreExpiry {
+	expiries := make([]certs.StoreExpiry, 0)
+	for _, expiry := range store.expiries() {
+		if expiry.NotAfter.After(from) && !expiry.NotAfter.After(until) {
+			expiries = append(expiries, expiry)
+		}
+	}
+	return expiries
+}
+
+// Get the store entry expiring next after the given time (nil if there is none).
+func (store *MemStore) NextExpiry(after time.Time) *certs.StoreExpiry {
+	for _, expiry := range store.expiries() {
+		if expiry.NotAfter.After(after) {
+			return &expiry
+		}
+	}
+	return nil
+}
+
+// Collect the expiries of all entries with certificate (there is no index to maintain, as all entries are in memory).
+func (store *MemStore) expiries() []certs.StoreExpiry {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	expiries := make([]certs.StoreExpiry, 0, len(store.names))
+	for _, name := range store.names {
+		certificate := store.entries[name].certificate
+		if certificate != nil {
+			expiries = append(expiries, certs.StoreExpiry{Name: name, NotAfter: certificate.NotAfter})
+		}
+	}
+	sort.SliceStable(expiries, func(i, j int) bool {
+		return expiries[i].NotAfter.Before(expiries[j].NotAfter)
+	})
+	return expiries
+}
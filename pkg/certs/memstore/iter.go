@@ -0,0 +1,36 @@
+//go:build go1.23
+
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memstore
+
+import (
+	"iter"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+)
+
+// Get an iterator over all store entries (see certs.All).
+func (store *MemStore) All() iter.Seq[certs.StoreEntry] {
+	return certs.All(store)
+}
+
+// Get an iterator over the store entries matched by the given function (see certs.Filtered).
+func (store *MemStore) Filtered(match func(certs.StoreEntry) bool) iter.Seq[certs.StoreEntry] {
+	return certs.Filtered(store, match)
+}
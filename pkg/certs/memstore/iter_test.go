@@ -0,0 +1,54 @@
+//go:build go1.23
+
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/certs/local"
+	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterators(t *testing.T) {
+	store := New("test")
+	_, err := store.CreateCertificate(context.Background(), "ca", local.NewLocalCertificateFactory(newTemplate("ca", true), ecdsa.StandardKeys()[0], nil, nil))
+	require.NoError(t, err)
+	_, _, err = store.CreateCertificateWithoutKey(context.Background(), "anchor", local.NewLocalCertificateFactory(newTemplate("anchor", true), ecdsa.StandardKeys()[0], nil, nil))
+	require.NoError(t, err)
+	names := make([]string, 0)
+	for storeEntry := range store.All() {
+		names = append(names, storeEntry.Name())
+	}
+	require.Equal(t, []string{"anchor", "ca"}, names)
+	names = names[:0]
+	for storeEntry := range store.Filtered(certs.HasKey) {
+		names = append(names, storeEntry.Name())
+	}
+	require.Equal(t, []string{"ca"}, names)
+	names = names[:0]
+	for storeEntry := range certs.Seq(store.Entries()) {
+		names = append(names, storeEntry.Name())
+		break
+	}
+	require.Equal(t, []string{"anchor"}, names)
+}
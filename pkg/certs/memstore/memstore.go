@@ -0,0 +1,600 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memstore
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/clock"
+	"golang.org/x/crypto/hkdf"
+)
+
+// MemStore is a store keeping all entries in memory.
+//
+// It offers the same entry operations as the file system based store, but nothing is persisted. This makes it suitable
+// for tests as well as for library consumers that only need a transient store.
+type MemStore struct {
+	name       string
+	lock       sync.RWMutex
+	names      []string
+	entries    map[string]*memStoreEntryData
+	pending    map[string]struct{}
+	archive    *MemStore
+	trust      map[string][]*x509.Certificate
+	secret     []byte
+	opened     time.Time
+	generation uint64
+	clock      clock.Clock
+}
+
+// Option defines optional store settings.
+type Option func(store *MemStore)
+
+// Record the time of archival using the given clock (instead of the active clock).
+func WithClock(clk clock.Clock) Option {
+	return func(store *MemStore) {
+		store.clock = clk
+	}
+}
+
+type memStoreEntryData struct {
+	key                crypto.PrivateKey
+	certificate        *x509.Certificate
+	certificateRequest *x509.CertificateRequest
+	revocationList     *x509.RevocationList
+	attributes         certs.StoreEntryAttributes
+}
+
+// Create a new empty store with the given name.
+func New(name string, options ...Option) *MemStore {
+	store := &MemStore{
+		name:    name,
+		entries: make(map[string]*memStoreEntryData),
+		pending: make(map[string]struct{}),
+		trust:   make(map[string][]*x509.Certificate),
+		opened:  time.Now(),
+	}
+	for _, option := range options {
+		option(store)
+	}
+	store.archive = &MemStore{
+		name:    name,
+		entries: make(map[string]*memStoreEntryData),
+		pending: make(map[string]struct{}),
+		opened:  store.opened,
+	}
+	return store
+}
+
+// Create a new store pre-seeded with copies of all entries of the given store.
+func NewFrom(name string, source certs.Store, options ...Option) (*MemStore, error) {
+	store := New(name, options...)
+	sourceEntries := source.Entries()
+	for {
+		sourceEntry := sourceEntries.Next()
+		if sourceEntry == nil {
+			break
+		}
+		err := store.Import(sourceEntry)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+func (store *MemStore) Name() string {
+	return store.name
+}
+
+func (store *MemStore) Entries() certs.StoreEntries {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return &memStoreEntries{
+		store: store,
+		names: append([]string{}, store.names...),
+	}
+}
+
+type memStoreEntries struct {
+	store *MemStore
+	names []string
+	next  int
+}
+
+func (storeEntries *memStoreEntries) Reset() {
+	storeEntries.next = 0
+}
+
+func (storeEntries *memStoreEntries) Next() certs.StoreEntry {
+	if storeEntries.next >= len(storeEntries.names) {
+		return nil
+	}
+	storeEntry := &memStoreEntry{store: storeEntries.store, name: storeEntries.names[storeEntries.next]}
+	storeEntries.next++
+	return storeEntry
+}
+
+func (store *MemStore) Entry(name string) (certs.StoreEntry, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	if store.entries[name] == nil {
+		return nil, fs.ErrNotExist
+	}
+	return &memStoreEntry{store: store, name: name}, nil
+}
+
+// Add an entry consisting of the given key, certificate and attributes (all optional).
+func (store *MemStore) Add(name string, key crypto.PrivateKey, certificate *x509.Certificate, attributes *certs.StoreEntryAttributes) error {
+	data := &memStoreEntryData{
+		key:         key,
+		certificate: certificate,
+	}
+	if attributes != nil {
+		data.attributes = copyAttributes(attributes)
+	}
+	if certificate != nil && key != nil && !certs.KeyMatches(key, certificate.PublicKey) {
+		return &certs.KeyMismatchError{Name: name}
+	}
+	if data.attributes.Kind == "" {
+		data.attributes.Kind = entryKind(data)
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.add(name, data)
+}
+
+// Add a copy of the given entry (e.g. taken from another store).
+func (store *MemStore) Import(storeEntry certs.StoreEntry) error {
+	name := storeEntry.Name()
+	attributes, err := storeEntry.Attributes()
+	if err != nil {
+		return fmt.Errorf("failed to import store entry '%s' (cause: %w)", name, err)
+	}
+	data := &memStoreEntryData{
+		attributes: copyAttributes(attributes),
+	}
+	if storeEntry.HasKey() {
+		data.key, err = storeEntry.Key()
+		if err != nil {
+			return fmt.Errorf("failed to import key of store entry '%s' (cause: %w)", name, err)
+		}
+	}
+	if storeEntry.HasCertificate() {
+		data.certificate, err = storeEntry.Certificate()
+		if err != nil {
+			return fmt.Errorf("failed to import certificate of store entry '%s' (cause: %w)", name, err)
+		}
+	}
+	if storeEntry.HasCertificateRequest() {
+		data.certificateRequest, err = storeEntry.CertificateRequest()
+		if err != nil {
+			return fmt.Errorf("failed to import certificate request of store entry '%s' (cause: %w)", name, err)
+		}
+	}
+	if storeEntry.HasRevocationList() {
+		data.revocationList, err = storeEntry.RevocationList()
+		if err != nil {
+			return fmt.Errorf("failed to import revocation list of store entry '%s' (cause: %w)", name, err)
+		}
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.add(name, data)
+}
+
+func (store *MemStore) CreateCertificate(ctx context.Context, name string, factory certs.CertificateFactory) (certs.StoreEntry, error) {
+	storeEntry, _, err := store.createCertificate(ctx, name, factory, true)
+	return storeEntry, err
+}
+
+// Create a certificate entry without storing the generated key.
+//
+// The generated key is only returned to the caller and can not be retrieved from the store afterwards.
+func (store *MemStore) CreateCertificateWithoutKey(ctx context.Context, name string, factory certs.CertificateFactory) (certs.StoreEntry, crypto.PrivateKey, error) {
+	return store.createCertificate(ctx, name, factory, false)
+}
+
+func (store *MemStore) createCertificate(ctx context.Context, name string, factory certs.CertificateFactory, storeKey bool) (certs.StoreEntry, crypto.PrivateKey, error) {
+	release, err := store.reserveEntry(name, func() error {
+		return store.checkNewEntry(name)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+	key, certificate, err := factory.New(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key != nil && !certs.KeyMatches(key, certificate.PublicKey) {
+		return nil, nil, &certs.KeyMismatchError{Name: name}
+	}
+	data := &memStoreEntryData{
+		certificate: certificate,
+		attributes:  certs.StoreEntryAttributes{Provider: factory.Name()},
+	}
+	if attributesFactory, ok := factory.(certs.CertificateAttributesFactory); ok {
+		attributesFactory.ApplyAttributes(&data.attributes)
+	}
+	if storeKey {
+		data.key = key
+		data.attributes.Kind = certs.KindKeyPair
+	} else {
+		data.attributes.Kind = entryKind(data)
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	err = store.add(name, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &memStoreEntry{store: store, name: name}, key, nil
+}
+
+// Replace the key and certificate of an existing store entry with newly generated ones.
+//
+// The entry's attributes are retained.
+func (store *MemStore) ReplaceCertificate(ctx context.Context, name string, factory certs.CertificateFactory) (certs.StoreEntry, error) {
+	release, err := store.reserveEntry(name, func() error {
+		data := store.entries[name]
+		if data == nil || data.certificate == nil {
+			return fmt.Errorf("failed to replace certificate of store entry '%s' (cause: %w)", name, fs.ErrNotExist)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	key, certificate, err := factory.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	data := store.entries[name]
+	if data == nil || data.certificate == nil {
+		return nil, fmt.Errorf("failed to replace certificate of store entry '%s' (cause: %w)", name, fs.ErrNotExist)
+	}
+	matchKey := key
+	if matchKey == nil {
+		matchKey = data.key
+	}
+	if matchKey != nil && !certs.KeyMatches(matchKey, certificate.PublicKey) {
+		return nil, &certs.KeyMismatchError{Name: name}
+	}
+	if key != nil {
+		data.key = key
+	}
+	data.certificate = certificate
+	store.generation++
+	return &memStoreEntry{store: store, name: name}, nil
+}
+
+func (store *MemStore) CreateCertificateRequest(ctx context.Context, name string, factory certs.CertificateRequestFactory) (certs.StoreEntry, error) {
+	release, err := store.reserveEntry(name, func() error {
+		return store.checkNewEntry(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	key, certificateRequest, err := factory.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = certificateRequest.CheckSignature()
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature for store entry '%s' (cause: %w)", name, err)
+	}
+	if !certs.KeyMatches(key, certificateRequest.PublicKey) {
+		return nil, &certs.KeyMismatchError{Name: name}
+	}
+	data := &memStoreEntryData{
+		key:                key,
+		certificateRequest: certificateRequest,
+		attributes:         certs.StoreEntryAttributes{Provider: factory.Name(), Kind: certs.KindRequest},
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	err = store.add(name, data)
+	if err != nil {
+		return nil, err
+	}
+	return &memStoreEntry{store: store, name: name}, nil
+}
+
+// Reserve the given entry name while its key and certificate are generated without holding the store lock.
+//
+// The check function is invoked with the store lock held. The returned function releases the reservation.
+func (store *MemStore) reserveEntry(name string, check func() error) (func(), error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	_, pending := store.pending[name]
+	if pending {
+		return nil, fmt.Errorf("failed to update store entry '%s' (cause: %w)", name, certs.ErrGenerationPending)
+	}
+	err := check()
+	if err != nil {
+		return nil, err
+	}
+	store.pending[name] = struct{}{}
+	return func() {
+		store.lock.Lock()
+		defer store.lock.Unlock()
+		delete(store.pending, name)
+	}, nil
+}
+
+// Check whether a new entry with the given name can be created (the caller has to hold the store lock).
+func (store *MemStore) checkNewEntry(name string) error {
+	if store.entries[name] != nil {
+		return fmt.Errorf("failed to create store entry '%s' (cause: %w)", name, fs.ErrExist)
+	}
+	return nil
+}
+
+// Replace (or add) the revocation list of an existing store entry.
+func (store *MemStore) UpdateRevocationList(name string, revocationList *x509.RevocationList) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	data := store.entries[name]
+	if data == nil {
+		return fmt.Errorf("failed to update revocation list of store entry '%s' (cause: %w)", name, fs.ErrNotExist)
+	}
+	data.revocationList = revocationList
+	store.generation++
+	return nil
+}
+
+// Update the attributes of an existing store entry.
+func (store *MemStore) UpdateAttributes(name string, update func(attributes *certs.StoreEntryAttributes)) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	data := store.entries[name]
+	if data == nil {
+		return fmt.Errorf("failed to update attributes of store entry '%s' (cause: %w)", name, fs.ErrNotExist)
+	}
+	updatedAttributes := copyAttributes(&data.attributes)
+	update(&updatedAttributes)
+	data.attributes = updatedAttributes
+	store.generation++
+	return nil
+}
+
+// Remove the given store entry.
+func (store *MemStore) Remove(name string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.entries[name] == nil {
+		return fmt.Errorf("failed to remove store entry '%s' (cause: %w)", name, fs.ErrNotExist)
+	}
+	store.remove(name)
+	return nil
+}
+
+// Replace the trusted certificates provided by the given trust source.
+func (store *MemStore) UpdateTrust(source string, certificates []*x509.Certificate) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.trust[source] = append([]*x509.Certificate{}, certificates...)
+	return nil
+}
+
+// Get the trusted certificates of all trust sources.
+func (store *MemStore) Trust() (map[string][]*x509.Certificate, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	trust := make(map[string][]*x509.Certificate, len(store.trust))
+	for source, certificates := range store.trust {
+		trust[source] = append([]*x509.Certificate{}, certificates...)
+	}
+	return trust, nil
+}
+
+// Derive a key of the given length for the given purpose from the store secret.
+//
+// The store secret is generated randomly on first use, hence derived keys are only stable for the lifetime of the
+// store.
+func (store *MemStore) DeriveKey(purpose string, length int) ([]byte, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.secret == nil {
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate random secret (cause: %w)", err)
+		}
+		store.secret = secret
+	}
+	key := make([]byte, length)
+	_, err := io.ReadFull(hkdf.New(sha256.New, store.secret, nil, []byte("certd:"+purpose)), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key for purpose '%s' (cause: %w)", purpose, err)
+	}
+	return key, nil
+}
+
+// Get the store's generation.
+//
+// The generation changes whenever entries are created, updated or removed (see fsstore.FSStore.Generation).
+func (store *MemStore) Generation() string {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return fmt.Sprintf("%x-%d", store.opened.UnixNano(), store.generation)
+}
+
+// Close the store (a no-op, as there are no resources to release).
+func (store *MemStore) Close() error {
+	return nil
+}
+
+func (store *MemStore) remove(name string) {
+	delete(store.entries, name)
+	names := make([]string, 0, len(store.names))
+	for _, entryName := range store.names {
+		if entryName != name {
+			names = append(names, entryName)
+		}
+	}
+	store.names = names
+	store.generation++
+}
+
+func (store *MemStore) add(name string, data *memStoreEntryData) error {
+	if name == "" {
+		return fmt.Errorf("invalid store entry name '%s'", name)
+	}
+	if store.entries[name] != nil {
+		return fmt.Errorf("failed to create store entry '%s' (cause: %w)", name, fs.ErrExist)
+	}
+	store.entries[name] = data
+	// entry iterators keep the previous slice, hence always build a new one
+	names := make([]string, 0, len(store.names)+1)
+	names = append(names, store.names...)
+	names = append(names, name)
+	sort.Strings(names)
+	store.names = names
+	store.generation++
+	return nil
+}
+
+func (store *MemStore) entryData(name string) (*memStoreEntryData, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	data := store.entries[name]
+	if data == nil {
+		return nil, fs.ErrNotExist
+	}
+	return data, nil
+}
+
+type memStoreEntry struct {
+	store *MemStore
+	name  string
+}
+
+func (storeEntry *memStoreEntry) Name() string {
+	return storeEntry.name
+}
+
+func (storeEntry *memStoreEntry) Store() certs.Store {
+	return storeEntry.store
+}
+
+func (storeEntry *memStoreEntry) HasKey() bool {
+	data, err := storeEntry.store.entryData(storeEntry.name)
+	return err == nil && data.key != nil
+}
+
+func (storeEntry *memStoreEntry) Key() (crypto.PrivateKey, error) {
+	data, err := storeEntry.store.entryData(storeEntry.name)
+	if err != nil {
+		return nil, err
+	}
+	return data.key, nil
+}
+
+func (storeEntry *memStoreEntry) HasCertificate() bool {
+	data, err := storeEntry.store.entryData(storeEntry.name)
+	return err == nil && data.certificate != nil
+}
+
+func (storeEntry *memStoreEntry) Certificate() (*x509.Certificate, error) {
+	data, err := storeEntry.store.entryData(storeEntry.name)
+	if err != nil {
+		return nil, err
+	}
+	return data.certificate, nil
+}
+
+func (storeEntry *memStoreEntry) HasCertificateRequest() bool {
+	data, err := storeEntry.store.entryData(storeEntry.name)
+	return err == nil && data.certificateRequest != nil
+}
+
+func (storeEntry *memStoreEntry) CertificateRequest() (*x509.CertificateRequest, error) {
+	data, err := storeEntry.store.entryData(storeEntry.name)
+	if err != nil {
+		return nil, err
+	}
+	return data.certificateRequest, nil
+}
+
+func (storeEntry *memStoreEntry) HasRevocationList() bool {
+	data, err := storeEntry.store.entryData(storeEntry.name)
+	return err == nil && data.revocationList != nil
+}
+
+func (storeEntry *memStoreEntry) RevocationList() (*x509.RevocationList, error) {
+	data, err := storeEntry.store.entryData(storeEntry.name)
+	if err != nil {
+		return nil, err
+	}
+	return data.revocationList, nil
+}
+
+func (storeEntry *memStoreEntry) Attributes() (*certs.StoreEntryAttributes, error) {
+	data, err := storeEntry.store.entryData(storeEntry.name)
+	if err != nil {
+		return nil, err
+	}
+	attributes := copyAttributes(&data.attributes)
+	return &attributes, nil
+}
+
+func copyAttributes(attributes *certs.StoreEntryAttributes) certs.StoreEntryAttributes {
+	copied := *attributes
+	if attributes.Labels != nil {
+		copied.Labels = make(map[string]string, len(attributes.Labels))
+		for label, value := range attributes.Labels {
+			copied.Labels[label] = value
+		}
+	}
+	copied.Notes = append([]certs.StoreEntryNote(nil), attributes.Notes...)
+	return copied
+}
+
+func entryKind(data *memStoreEntryData) certs.StoreEntryKind {
+	switch {
+	case data.certificateRequest != nil:
+		return certs.KindRequest
+	case data.certificate != nil && data.key != nil:
+		return certs.KindKeyPair
+	case data.certificate != nil && data.certificate.IsCA && bytes.Equal(data.certificate.RawSubject, data.certificate.RawIssuer) && data.certificate.CheckSignatureFrom(data.certificate) == nil:
+		return certs.KindTrustAnchor
+	case data.certificate != nil:
+		return certs.KindCertificate
+	case data.revocationList != nil:
+		return certs.KindCRL
+	}
+	return ""
+}
@@ -0,0 +1,285 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/certs/local"
+	"github.com/hdecarne-github/certd/pkg/clock"
+	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemStore(t *testing.T) {
+	store := New("test")
+	require.Equal(t, "test", store.Name())
+	caEntry, err := store.CreateCertificate(context.Background(), "ca", local.NewLocalCertificateFactory(newTemplate("ca", true), ecdsa.StandardKeys()[0], nil, nil))
+	require.NoError(t, err)
+	require.True(t, caEntry.HasKey())
+	require.True(t, caEntry.HasCertificate())
+	require.False(t, caEntry.HasCertificateRequest())
+	require.Equal(t, store, caEntry.Store())
+	ca, err := caEntry.Certificate()
+	require.NoError(t, err)
+	caKey, err := caEntry.Key()
+	require.NoError(t, err)
+	_, err = store.CreateCertificate(context.Background(), "ca", local.NewLocalCertificateFactory(newTemplate("ca", true), ecdsa.StandardKeys()[0], nil, nil))
+	require.ErrorIs(t, err, fs.ErrExist)
+	_, key, err := store.CreateCertificateWithoutKey(context.Background(), "leaf", local.NewLocalCertificateFactory(newTemplate("leaf", false), ecdsa.StandardKeys()[0], ca, caKey.(crypto.Signer)))
+	require.NoError(t, err)
+	require.NotNil(t, key)
+	leafEntry, err := store.Entry("leaf")
+	require.NoError(t, err)
+	require.False(t, leafEntry.HasKey())
+	attributes, err := leafEntry.Attributes()
+	require.NoError(t, err)
+	require.Equal(t, certs.KindCertificate, attributes.Kind)
+	require.Equal(t, "Local", attributes.Provider)
+	_, err = store.Entry("unknown")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	// entries are iterated in name order; iterators are not affected by subsequent changes
+	storeEntries := store.Entries()
+	require.NoError(t, store.Add("anchor", nil, ca, nil))
+	require.Equal(t, []string{"ca", "leaf"}, entryNames(storeEntries))
+	require.Equal(t, []string{"anchor", "ca", "leaf"}, entryNames(store.Entries()))
+	anchorEntry, err := store.Entry("anchor")
+	require.NoError(t, err)
+	attributes, err = anchorEntry.Attributes()
+	require.NoError(t, err)
+	require.Equal(t, certs.KindTrustAnchor, attributes.Kind)
+	// replace certificate (retaining attributes)
+	require.NoError(t, store.UpdateAttributes("leaf", func(attributes *certs.StoreEntryAttributes) {
+		attributes.Labels = map[string]string{"env": "test"}
+	}))
+	_, err = store.ReplaceCertificate(context.Background(), "leaf", local.NewLocalCertificateFactory(newTemplate("leaf", false), ecdsa.StandardKeys()[0], ca, caKey.(crypto.Signer)))
+	require.NoError(t, err)
+	attributes, err = leafEntry.Attributes()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"env": "test"}, attributes.Labels)
+	attributes.Labels["env"] = "modified"
+	attributes, err = leafEntry.Attributes()
+	require.NoError(t, err)
+	require.Equal(t, "test", attributes.Labels["env"])
+	_, err = store.ReplaceCertificate(context.Background(), "unknown", local.NewLocalCertificateFactory(newTemplate("leaf", false), ecdsa.StandardKeys()[0], ca, caKey.(crypto.Signer)))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	// key mismatch
+	var keyMismatchErr *certs.KeyMismatchError
+	err = store.Add("mismatch", key, ca, nil)
+	require.True(t, errors.As(err, &keyMismatchErr))
+	// revocation list
+	revocationList := &x509.RevocationList{Number: big.NewInt(1)}
+	require.NoError(t, store.UpdateRevocationList("ca", revocationList))
+	require.True(t, caEntry.HasRevocationList())
+	require.Error(t, store.UpdateRevocationList("unknown", revocationList))
+	// remove
+	require.NoError(t, store.Remove("anchor"))
+	require.ErrorIs(t, store.Remove("anchor"), fs.ErrNotExist)
+	require.False(t, anchorEntry.HasCertificate())
+	// copy
+	copied, err := NewFrom("copy", store)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ca", "leaf"}, entryNames(copied.Entries()))
+	copiedEntry, err := copied.Entry("ca")
+	require.NoError(t, err)
+	require.True(t, copiedEntry.HasKey())
+	require.True(t, copiedEntry.HasRevocationList())
+}
+
+func TestMemStoreArchive(t *testing.T) {
+	archived := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := New("test", WithClock(clock.Fixed(archived)))
+	_, err := store.CreateCertificate(context.Background(), "entry", local.NewLocalCertificateFactory(newTemplate("entry", true), ecdsa.StandardKeys()[0], nil, nil))
+	require.NoError(t, err)
+	generation := store.Generation()
+	require.NoError(t, store.ArchiveEntry("entry"))
+	require.NotEqual(t, generation, store.Generation())
+	_, err = store.Entry("entry")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.Equal(t, []string{"entry"}, entryNames(store.ArchivedEntries()))
+	archivedEntry, err := store.ArchivedEntry("entry")
+	require.NoError(t, err)
+	attributes, err := archivedEntry.Attributes()
+	require.NoError(t, err)
+	require.Equal(t, archived, *attributes.Archived)
+	require.NoError(t, store.RestoreEntry("entry"))
+	restoredEntry, err := store.Entry("entry")
+	require.NoError(t, err)
+	require.True(t, restoredEntry.HasKey())
+	attributes, err = restoredEntry.Attributes()
+	require.NoError(t, err)
+	require.Nil(t, attributes.Archived)
+	require.ErrorIs(t, store.RestoreEntry("entry"), fs.ErrNotExist)
+	require.NoError(t, store.ArchiveEntry("entry"))
+	require.NoError(t, store.PurgeEntry("entry"))
+	require.ErrorIs(t, store.PurgeEntry("entry"), fs.ErrNotExist)
+	require.Empty(t, entryNames(store.ArchivedEntries()))
+}
+
+func TestMemStoreExpiries(t *testing.T) {
+	store := New("test")
+	now := time.Now()
+	for i, name := range []string{"entry1", "entry2", "entry3"} {
+		template := newTemplate(name, true)
+		template.NotAfter = now.AddDate(0, 0, 3-i)
+		_, err := store.CreateCertificate(context.Background(), name, local.NewLocalCertificateFactory(template, ecdsa.StandardKeys()[0], nil, nil))
+		require.NoError(t, err)
+	}
+	expiries := store.Expiries(now, now.AddDate(0, 0, 2))
+	require.Len(t, expiries, 2)
+	require.Equal(t, "entry3", expiries[0].Name)
+	require.Equal(t, "entry2", expiries[1].Name)
+	require.Equal(t, "entry1", store.NextExpiry(now.AddDate(0, 0, 2)).Name)
+	require.Nil(t, store.NextExpiry(now.AddDate(0, 0, 3)))
+}
+
+func TestMemStoreDeriveKey(t *testing.T) {
+	store := New("test")
+	key1, err := store.DeriveKey("purpose", 32)
+	require.NoError(t, err)
+	key2, err := store.DeriveKey("purpose", 32)
+	require.NoError(t, err)
+	require.Equal(t, key1, key2)
+	key3, err := store.DeriveKey("other", 32)
+	require.NoError(t, err)
+	require.NotEqual(t, key1, key3)
+}
+
+func TestMemStoreConcurrentGenerations(t *testing.T) {
+	store := New("test")
+	kpf := ecdsa.StandardKeys()[0]
+	factories := []*blockingCertificateFactory{
+		newBlockingCertificateFactory(local.NewLocalCertificateFactory(newTemplate("entry1", true), kpf, nil, nil)),
+		newBlockingCertificateFactory(local.NewLocalCertificateFactory(newTemplate("entry2", true), kpf, nil, nil)),
+	}
+	created := make(chan error, len(factories))
+	for i, factory := range factories {
+		go func(name string, factory *blockingCertificateFactory) {
+			_, err := store.CreateCertificate(context.Background(), name, factory)
+			created <- err
+		}(fmt.Sprintf("entry%d", i+1), factory)
+	}
+	// both generations run concurrently without blocking readers
+	for _, factory := range factories {
+		<-factory.started
+	}
+	require.Equal(t, 0, countEntries(store))
+	_, err := store.CreateCertificate(context.Background(), "entry1", local.NewLocalCertificateFactory(newTemplate("entry1", true), kpf, nil, nil))
+	require.ErrorIs(t, err, certs.ErrGenerationPending)
+	for _, factory := range factories {
+		close(factory.release)
+	}
+	for range factories {
+		require.NoError(t, <-created)
+	}
+	require.Equal(t, 2, countEntries(store))
+}
+
+func countEntries(store *MemStore) int {
+	count := 0
+	entries := store.Entries()
+	for entries.Next() != nil {
+		count++
+	}
+	return count
+}
+
+type blockingCertificateFactory struct {
+	factory certs.CertificateFactory
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingCertificateFactory(factory certs.CertificateFactory) *blockingCertificateFactory {
+	return &blockingCertificateFactory{factory: factory, started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (factory *blockingCertificateFactory) Name() string {
+	return factory.factory.Name()
+}
+
+func (factory *blockingCertificateFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error) {
+	close(factory.started)
+	<-factory.release
+	return factory.factory.New(ctx)
+}
+
+func TestStreamEntries(t *testing.T) {
+	store := New("test")
+	for _, name := range []string{"entry1", "entry2", "entry3"} {
+		_, err := store.CreateCertificate(context.Background(), name, local.NewLocalCertificateFactory(newTemplate(name, true), ecdsa.StandardKeys()[0], nil, nil))
+		require.NoError(t, err)
+	}
+	// snapshot semantics: removed entries are still delivered, but their data is gone
+	storeEntries := store.Entries()
+	require.NoError(t, store.Remove("entry2"))
+	names := make([]string, 0)
+	for storeEntry := range certs.StreamEntries(context.Background(), storeEntries) {
+		names = append(names, storeEntry.Name())
+		if storeEntry.Name() == "entry2" {
+			_, err := storeEntry.Attributes()
+			require.ErrorIs(t, err, fs.ErrNotExist)
+		}
+	}
+	require.Equal(t, []string{"entry1", "entry2", "entry3"}, names)
+	// cancellation ends the stream
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := certs.StreamEntries(ctx, store.Entries())
+	require.Equal(t, "entry1", (<-stream).Name())
+	cancel()
+	for range stream {
+	}
+}
+
+func entryNames(storeEntries certs.StoreEntries) []string {
+	names := make([]string, 0)
+	for {
+		storeEntry := storeEntries.Next()
+		if storeEntry == nil {
+			break
+		}
+		names = append(names, storeEntry.Name())
+	}
+	return names
+}
+
+func newTemplate(cn string, ca bool) *x509.Certificate {
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(now.UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             now,
+		NotAfter:              now.Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+	if ca {
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	}
+	return template
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// Get the canonical string representation of a Distinguished Name.
+//
+// The canonical form follows the comparison rules of RFC 5280 (section 7.1): RDNs are compared
+// in sequence, attributes within a multi-valued RDN are compared regardless of their order,
+// and string values are compared case-insensitive with insignificant whitespace removed. String
+// values are escaped according to RFC 4514, hence different DNs never share the same canonical form.
+func CanonicalDN(name *pkix.Name) string {
+	return CanonicalRDNSequence(nameRDNSequence(name))
+}
+
+// Get the canonical string representation of a RDN sequence (see CanonicalDN).
+func CanonicalRDNSequence(rdns pkix.RDNSequence) string {
+	var builder strings.Builder
+	for i, rdn := range rdns {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		atvs := make([]string, len(rdn))
+		for j, atv := range rdn {
+			atvs[j] = atv.Type.String() + "=" + canonicalValue(atv.Value)
+		}
+		sort.Strings(atvs)
+		builder.WriteString(strings.Join(atvs, "+"))
+	}
+	return builder.String()
+}
+
+// Check whether two Distinguished Names are equal according to the comparison rules of RFC 5280.
+func EqualDN(name1 *pkix.Name, name2 *pkix.Name) bool {
+	return CanonicalDN(name1) == CanonicalDN(name2)
+}
+
+// Check whether two DER encoded Distinguished Names are equal according to the comparison rules of RFC 5280.
+func EqualRawDN(raw1 []byte, raw2 []byte) bool {
+	if bytes.Equal(raw1, raw2) {
+		return true
+	}
+	var rdns1 pkix.RDNSequence
+	rest, err := asn1.Unmarshal(raw1, &rdns1)
+	if err != nil || len(rest) > 0 {
+		return false
+	}
+	var rdns2 pkix.RDNSequence
+	rest, err = asn1.Unmarshal(raw2, &rdns2)
+	if err != nil || len(rest) > 0 {
+		return false
+	}
+	return CanonicalRDNSequence(rdns1) == CanonicalRDNSequence(rdns2)
+}
+
+// Check whether the given certificate has been issued by the given issuer certificate.
+//
+// The check is based on the certificates' DNs as well as the key identifiers (if available).
+// The certificate's signature is not verified.
+func IsIssuedBy(certificate *x509.Certificate, issuer *x509.Certificate) bool {
+	if !EqualRawDN(certificate.RawIssuer, issuer.RawSubject) {
+		return false
+	}
+	if len(certificate.AuthorityKeyId) > 0 && len(issuer.SubjectKeyId) > 0 {
+		return bytes.Equal(certificate.AuthorityKeyId, issuer.SubjectKeyId)
+	}
+	return true
+}
+
+func nameRDNSequence(name *pkix.Name) pkix.RDNSequence {
+	if len(name.Names) == 0 {
+		return name.ToRDNSequence()
+	}
+	// parsed names contain all attributes (flattened) in their original order
+	rdns := make(pkix.RDNSequence, 0, len(name.Names))
+	for _, atv := range name.Names {
+		rdns = append(rdns, []pkix.AttributeTypeAndValue{atv})
+	}
+	return rdns
+}
+
+func canonicalValue(value any) string {
+	stringValue, ok := value.(string)
+	if !ok {
+		valueBytes, err := asn1.Marshal(value)
+		if err != nil {
+			return fmt.Sprint(value)
+		}
+		return "#" + hex.EncodeToString(valueBytes)
+	}
+	var builder strings.Builder
+	space := false
+	for _, r := range strings.TrimSpace(stringValue) {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			builder.WriteByte(' ')
+			space = false
+		}
+		builder.WriteRune(unicode.ToLower(r))
+	}
+	return EscapeDNValue(builder.String())
+}
+
+// Escape a DN attribute value according to RFC 4514.
+func EscapeDNValue(value string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		char := value[i]
+		switch {
+		case strings.IndexByte(",+\"\\<>;=", char) >= 0:
+			escaped.WriteByte('\\')
+		case i == 0 && (char == ' ' || char == '#'):
+			escaped.WriteByte('\\')
+		case i == len(value)-1 && char == ' ':
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteByte(char)
+	}
+	return escaped.String()
+}
+
+type dnTemplateData struct {
+	Name string
+}
+
+// Evaluate the given DN template (e.g. "CN={{.Name}},OU=Example") for the given name.
+//
+// The name is escaped according to RFC 4514 before it is substituted, hence it always makes up a single attribute
+// value.
+func ResolveDNTemplate(dnTemplate string, name string) (string, error) {
+	parsed, err := template.New("dn_template").Parse(dnTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid DN template '%s' (cause: %w)", dnTemplate, err)
+	}
+	var resolved bytes.Buffer
+	err = parsed.Execute(&resolved, &dnTemplateData{Name: EscapeDNValue(name)})
+	if err != nil {
+		return "", fmt.Errorf("failed to evaluate DN template '%s' (cause: %w)", dnTemplate, err)
+	}
+	return resolved.String(), nil
+}
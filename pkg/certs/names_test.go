@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalDN(t *testing.T) {
+	dn1, err := ParseDN("CN=Common  Name, O=Organization")
+	require.NoError(t, err)
+	dn2 := &pkix.Name{CommonName: " common name ", Organization: []string{"ORGANIZATION"}}
+	require.Equal(t, "2.5.4.10=organization,2.5.4.3=common name", CanonicalDN(dn1))
+	require.True(t, EqualDN(dn1, dn2))
+	dn3 := &pkix.Name{CommonName: "Common Name", Organization: []string{"Other Organization"}}
+	require.False(t, EqualDN(dn1, dn3))
+}
+
+func TestCanonicalRDNSequence(t *testing.T) {
+	rdns1, err := ParseRDNSequence("CN=CommonName+UID=uid,DC=org")
+	require.NoError(t, err)
+	rdns2, err := ParseRDNSequence("uid=UID+cn=commonname,dc=ORG")
+	require.NoError(t, err)
+	require.Equal(t, CanonicalRDNSequence(rdns1), CanonicalRDNSequence(rdns2))
+	raw1, err := asn1.Marshal(rdns1)
+	require.NoError(t, err)
+	raw2, err := asn1.Marshal(rdns2)
+	require.NoError(t, err)
+	require.True(t, EqualRawDN(raw1, raw2))
+	rdns3, err := ParseRDNSequence("DC=org,CN=CommonName+UID=uid")
+	require.NoError(t, err)
+	raw3, err := asn1.Marshal(rdns3)
+	require.NoError(t, err)
+	require.False(t, EqualRawDN(raw1, raw3))
+}
+
+func TestCanonicalRDNSequenceEscaping(t *testing.T) {
+	cn := asn1.ObjectIdentifier{2, 5, 4, 3}
+	ou := asn1.ObjectIdentifier{2, 5, 4, 11}
+	rdns1 := pkix.RDNSequence{{{Type: cn, Value: "x,2.5.4.11=y"}}}
+	rdns2 := pkix.RDNSequence{{{Type: cn, Value: "x"}}, {{Type: ou, Value: "y"}}}
+	require.NotEqual(t, CanonicalRDNSequence(rdns1), CanonicalRDNSequence(rdns2))
+	rdns3 := pkix.RDNSequence{{{Type: cn, Value: "x+2.5.4.11=y"}}}
+	rdns4 := pkix.RDNSequence{{{Type: cn, Value: "x"}, {Type: ou, Value: "y"}}}
+	require.NotEqual(t, CanonicalRDNSequence(rdns3), CanonicalRDNSequence(rdns4))
+	raw1, err := asn1.Marshal(rdns1)
+	require.NoError(t, err)
+	raw2, err := asn1.Marshal(rdns2)
+	require.NoError(t, err)
+	require.False(t, EqualRawDN(raw1, raw2))
+	require.Equal(t, "2.5.4.3=x\\,2.5.4.11\\=y", CanonicalRDNSequence(rdns1))
+	require.Equal(t, "\\#value", EscapeDNValue("#value"))
+}
+
+func TestIsIssuedBy(t *testing.T) {
+	root, err := ReadCertificates("./testdata/isrgrootx1.pem")
+	require.NoError(t, err)
+	intermediate, err := ReadCertificates("./testdata/lets-encrypt-r3.pem")
+	require.NoError(t, err)
+	require.True(t, IsIssuedBy(root[0], root[0]))
+	require.True(t, IsIssuedBy(intermediate[0], root[0]))
+	require.False(t, IsIssuedBy(root[0], intermediate[0]))
+}
+
+func TestResolveDNTemplate(t *testing.T) {
+	dn, err := ResolveDNTemplate("CN={{.Name}},OU=Test", "local")
+	require.NoError(t, err)
+	require.Equal(t, "CN=local,OU=Test", dn)
+	dn, err = ResolveDNTemplate("CN={{.Name}},OU=Test", "evil,O=Injected")
+	require.NoError(t, err)
+	require.Equal(t, `CN=evil\,O\=Injected,OU=Test`, dn)
+	rdns, err := ParseRDNSequence(dn)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(rdns))
+	_, err = ResolveDNTemplate("CN={{.Name", "local")
+	require.Error(t, err)
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const OCSPRequestContentType = "application/ocsp-request"
+const OCSPResponseContentType = "application/ocsp-response"
+
+// ErrNoOCSPServer indicates that a certificate does not reference any OCSP responder.
+var ErrNoOCSPServer = errors.New("no OCSP server")
+
+// Fetch the current OCSP response for the given certificate from the OCSP responders referenced by the certificate.
+//
+// The responders are queried in the order they are listed in the certificate until a valid response is received.
+func FetchOCSPResponse(certificate *x509.Certificate, issuer *x509.Certificate) ([]byte, *ocsp.Response, error) {
+	if len(certificate.OCSPServer) == 0 {
+		return nil, nil, fmt.Errorf("failed to fetch OCSP response for certificate '%s' (cause: %w)", certificate.Subject, ErrNoOCSPServer)
+	}
+	request, err := ocsp.CreateRequest(certificate, issuer, &ocsp.RequestOptions{Hash: crypto.SHA256})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create OCSP request for certificate '%s' (cause: %w)", certificate.Subject, err)
+	}
+	var lastErr error
+	for _, server := range certificate.OCSPServer {
+		responseBytes, err := postOCSPRequest(server, request)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		response, err := ocsp.ParseResponseForCert(responseBytes, certificate, issuer)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return responseBytes, response, nil
+	}
+	return nil, nil, fmt.Errorf("failed to fetch OCSP response for certificate '%s' (cause: %w)", certificate.Subject, lastErr)
+}
+
+func postOCSPRequest(server string, request []byte) ([]byte, error) {
+	rsp, err := http.Post(server, OCSPRequestContentType, bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status: %s", rsp.Status)
+	}
+	return io.ReadAll(rsp.Body)
+}
+
+// Create an OCSP response for the given certificate signed directly by the certificate's issuer.
+//
+// The certificate status is derived from the issuer's revocation list (if any). The response is valid until the
+// revocation list's next update or for the given validity period, whatever comes first.
+func CreateOCSPResponse(certificate *x509.Certificate, issuer *x509.Certificate, signer crypto.Signer, revocationList *x509.RevocationList, now time.Time, validity time.Duration) ([]byte, *ocsp.Response, error) {
+	template := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: certificate.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(validity),
+	}
+	if revocationList != nil {
+		if !revocationList.NextUpdate.IsZero() && revocationList.NextUpdate.Before(template.NextUpdate) {
+			template.NextUpdate = revocationList.NextUpdate
+		}
+		for _, revokedCertificate := range revocationList.RevokedCertificates {
+			if revokedCertificate.SerialNumber.Cmp(certificate.SerialNumber) == 0 {
+				template.Status = ocsp.Revoked
+				template.RevokedAt = revokedCertificate.RevocationTime
+				break
+			}
+		}
+	}
+	responseBytes, err := ocsp.CreateResponse(issuer, issuer, template, signer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create OCSP response for certificate '%s' (cause: %w)", certificate.Subject, err)
+	}
+	response, err := ocsp.ParseResponseForCert(responseBytes, certificate, issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse OCSP response for certificate '%s' (cause: %w)", certificate.Subject, err)
+	}
+	return responseBytes, response, nil
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestCreateOCSPResponse(t *testing.T) {
+	issuerKey, issuer := newTestCertificate(t, "Issuer", nil, nil, true)
+	issuer.KeyUsage |= x509.KeyUsageCRLSign
+	_, certificate := newTestCertificate(t, "Certificate", nil, issuer, false, issuerKey)
+	_, otherCertificate := newTestCertificate(t, "Other", nil, issuer, false, issuerKey)
+	now := time.Now()
+	_, response, err := CreateOCSPResponse(certificate, issuer, issuerKey, nil, now, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, ocsp.Good, response.Status)
+	require.WithinDuration(t, now.Add(time.Hour), response.NextUpdate, time.Second)
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: now.Add(-time.Minute),
+		NextUpdate: now.Add(30 * time.Minute),
+		RevokedCertificates: []pkix.RevokedCertificate{
+			{SerialNumber: certificate.SerialNumber, RevocationTime: now.Add(-time.Minute)},
+		},
+	}
+	revocationListBytes, err := x509.CreateRevocationList(rand.Reader, template, issuer, issuerKey)
+	require.NoError(t, err)
+	revocationList, err := x509.ParseRevocationList(revocationListBytes)
+	require.NoError(t, err)
+	_, response, err = CreateOCSPResponse(certificate, issuer, issuerKey, revocationList, now, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, ocsp.Revoked, response.Status)
+	require.WithinDuration(t, revocationList.NextUpdate, response.NextUpdate, time.Second)
+	_, response, err = CreateOCSPResponse(otherCertificate, issuer, issuerKey, revocationList, now, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, ocsp.Good, response.Status)
+}
+
+func TestFetchOCSPResponseNoServer(t *testing.T) {
+	issuerKey, issuer := newTestCertificate(t, "Issuer", nil, nil, true)
+	_, certificate := newTestCertificate(t, "Certificate", nil, issuer, false, issuerKey)
+	_, _, err := FetchOCSPResponse(certificate, issuer)
+	require.ErrorIs(t, err, ErrNoOCSPServer)
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+)
+
+// ProviderErrorKind classifies a certificate provider failure.
+type ProviderErrorKind string
+
+const (
+	// Domain validation failed (e.g. the challenge endpoint is not reachable by the CA)
+	ProviderChallengeFailed ProviderErrorKind = "challenge_failed"
+	// A DNS record required for validation is missing
+	ProviderDNSMissing ProviderErrorKind = "dns_missing"
+	// The CA refused the request due to a rate limit
+	ProviderRateLimited ProviderErrorKind = "rate_limited"
+	// The CA is not reachable
+	ProviderCAUnreachable ProviderErrorKind = "ca_unreachable"
+	// The CA refused the request due to its issuance policy
+	ProviderPolicyViolation ProviderErrorKind = "policy_violation"
+)
+
+var providerErrorHints = map[ProviderErrorKind]string{
+	ProviderChallengeFailed: "Make sure the challenge endpoint is reachable by the CA (e.g. firewall rules and port forwardings).",
+	ProviderDNSMissing:      "Make sure the DNS records of all requested domains exist and have propagated.",
+	ProviderRateLimited:     "Wait until the CA's rate limit has expired before retrying.",
+	ProviderCAUnreachable:   "Check the network connectivity to the CA as well as the configured CA URL.",
+	ProviderPolicyViolation: "Check the request against the CA's issuance policy (e.g. permitted domains, key types and CAA records).",
+}
+
+// ProviderError reports a classified certificate provider failure together with a remediation hint.
+type ProviderError struct {
+	Kind ProviderErrorKind
+	Hint string
+	Err  error
+}
+
+// Wrap the given error into a provider error of the given kind (using the kind's standard remediation hint).
+func NewProviderError(kind ProviderErrorKind, err error) *ProviderError {
+	return &ProviderError{Kind: kind, Hint: providerErrorHints[kind], Err: err}
+}
+
+func (err *ProviderError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *ProviderError) Unwrap() error {
+	return err.Err
+}
+
+// Classify the given certificate provider failure.
+//
+// A provider error carried by the given error is returned as is. Network failures are classified as
+// ProviderCAUnreachable. nil is returned for all other errors (including canceled requests).
+func ClassifyProviderError(err error) *ProviderError {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr
+	}
+	// canceled requests (e.g. due to a client disconnect) say nothing about the CA
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	var urlErr *url.Error
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &urlErr) || errors.As(err, &opErr) || errors.As(err, &dnsErr) {
+		return NewProviderError(ProviderCAUnreachable, err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyProviderError(t *testing.T) {
+	// provider errors are passed through
+	providerErr := NewProviderError(ProviderPolicyViolation, errors.New("rejected"))
+	require.Same(t, providerErr, ClassifyProviderError(fmt.Errorf("failed to create certificate (cause: %w)", providerErr)))
+	require.NotEmpty(t, providerErr.Hint)
+	require.Equal(t, "rejected", providerErr.Error())
+	// network failures denote an unreachable CA
+	opErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	classified := ClassifyProviderError(fmt.Errorf("failed to obtain certificate (cause: %w)", opErr))
+	require.NotNil(t, classified)
+	require.Equal(t, ProviderCAUnreachable, classified.Kind)
+	require.ErrorIs(t, classified, opErr)
+	// canceled requests and unknown errors are not classified
+	require.Nil(t, ClassifyProviderError(fmt.Errorf("failed to obtain certificate (cause: %w)", context.Canceled)))
+	require.Nil(t, ClassifyProviderError(errors.New("unknown")))
+}
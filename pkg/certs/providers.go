@@ -0,0 +1,254 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	certdconfig "github.com/hdecarne-github/certd/internal/config"
+	"github.com/hdecarne-github/certd/pkg/entropy"
+	"github.com/hdecarne-github/certd/pkg/keys"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrGenericRequestUnsupported is returned by certificate providers only supporting their dedicated generate requests.
+var ErrGenericRequestUnsupported = errors.New("provider does not support generic certificate requests")
+
+// CertificateProvider offers one or more CAs for certificate generation.
+type CertificateProvider interface {
+	// Name of the provider.
+	Name() string
+	// CAs offered by the provider (as listed via /api/store/cas).
+	CAs() []string
+	// Create a certificate factory issuing a certificate via the given CA.
+	//
+	// The context is only used while creating the factory; the issuance itself is bound to the context passed to
+	// the factory's New method.
+	NewCertificateFactory(ctx context.Context, ca string, request *ProviderRequest) (CertificateFactory, error)
+}
+
+// ProviderRequest contains the provider independent parameters of a certificate generation request.
+type ProviderRequest struct {
+	Subject pkix.Name
+	// DER encoded subject (takes precedence over Subject, if set; see MarshalRDNSequence)
+	RawSubject []byte
+	Domains    []string
+	KeyFactory keys.KeyPairFactory
+	// Provider specific parameters.
+	Params map[string]string
+	// Certificate and key of the store entry to renew (only set for renew requests).
+	Certificate *x509.Certificate
+	Key         crypto.PrivateKey
+}
+
+// Generate a new key and a certificate request for the requested subject and domains.
+//
+// In case the request does not define a common name, the first domain is used as the common name. The key
+// generation is aborted as soon as the context is done.
+func (request *ProviderRequest) NewCertificateRequest(ctx context.Context) (crypto.PrivateKey, *x509.CertificateRequest, error) {
+	if request.KeyFactory == nil {
+		return nil, nil, fmt.Errorf("missing key type")
+	}
+	keyPair, err := keys.NewKeyPair(ctx, request.KeyFactory)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.CertificateRequest{
+		Subject:    request.Subject,
+		RawSubject: request.RawSubject,
+		DNSNames:   request.Domains,
+	}
+	if template.Subject.CommonName == "" && len(request.Domains) > 0 {
+		template.Subject.CommonName = request.Domains[0]
+		if len(template.RawSubject) > 0 {
+			template.RawSubject, err = appendCommonName(template.RawSubject, request.Domains[0])
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	certificateRequestBytes, err := x509.CreateCertificateRequest(entropy.ContextReader(ctx), template, keyPair.Private())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate request (cause: %w)", err)
+	}
+	certificateRequest, err := x509.ParseCertificateRequest(certificateRequestBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed parse certificate request bytes (cause: %w)", err)
+	}
+	return keyPair.Private(), certificateRequest, nil
+}
+
+var oidCommonName = asn1.ObjectIdentifier{2, 5, 4, 3}
+
+func appendCommonName(rawSubject []byte, commonName string) ([]byte, error) {
+	var rdns pkix.RDNSequence
+	rest, err := asn1.Unmarshal(rawSubject, &rdns)
+	if err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("invalid subject (cause: %v)", err)
+	}
+	for _, rdn := range rdns {
+		for _, atv := range rdn {
+			if atv.Type.Equal(oidCommonName) {
+				return rawSubject, nil
+			}
+		}
+	}
+	rdns = append(rdns, []pkix.AttributeTypeAndValue{{Type: oidCommonName, Value: commonName}})
+	return MarshalRDNSequence(rdns)
+}
+
+// CertificateChainFactory is implemented by certificate factories also providing the issuer chain of the
+// certificate created during the last New invocation.
+type CertificateChainFactory interface {
+	CertificateFactory
+	Chain() []*x509.Certificate
+}
+
+// CertificateAttributesFactory is implemented by certificate factories contributing attributes (e.g. a verified key
+// attestation) to the store entry created from the certificate. The attributes are applied after New succeeded and are
+// written together with the entry's files.
+type CertificateAttributesFactory interface {
+	CertificateFactory
+	ApplyAttributes(attributes *StoreEntryAttributes)
+}
+
+// ProviderType describes a pluggable certificate provider type.
+type ProviderType struct {
+	// Name used to reference the provider type in the provider configuration.
+	Name string
+	// Create a new (default) configuration value the provider configuration is decoded into. The returned
+	// value defines the configuration schema of the provider type.
+	NewConfig func() any
+	// Create a provider instance using the decoded configuration.
+	New func(name string, config any) (CertificateProvider, error)
+}
+
+var providerTypesLock sync.RWMutex
+var providerTypes = make(map[string]*ProviderType, 0)
+
+// Register a certificate provider type.
+//
+// Provider types are typically registered during package initialization. Registering a type name twice panics.
+func RegisterProviderType(providerType *ProviderType) {
+	providerTypesLock.Lock()
+	defer providerTypesLock.Unlock()
+	if providerType.Name == "" || providerType.NewConfig == nil || providerType.New == nil {
+		panic("incomplete certificate provider type")
+	}
+	if providerTypes[providerType.Name] != nil {
+		panic("duplicate certificate provider type " + providerType.Name)
+	}
+	providerTypes[providerType.Name] = providerType
+}
+
+// Get the names of all registered certificate provider types (sorted).
+func ProviderTypes() []string {
+	providerTypesLock.RLock()
+	defer providerTypesLock.RUnlock()
+	names := make([]string, 0, len(providerTypes))
+	for name := range providerTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Create a certificate provider of the given type.
+//
+// The decode function is invoked to decode the provider configuration into the type's configuration value.
+func NewProvider(typeName string, name string, decode func(config any) error) (CertificateProvider, error) {
+	providerTypesLock.RLock()
+	providerType := providerTypes[typeName]
+	providerTypesLock.RUnlock()
+	if providerType == nil {
+		return nil, fmt.Errorf("unrecognized certificate provider type '%s'", typeName)
+	}
+	config := providerType.NewConfig()
+	err := decode(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration for certificate provider '%s' (cause: %w)", name, err)
+	}
+	return providerType.New(name, config)
+}
+
+// Find the provider offering the given CA.
+func FindProvider(providers []CertificateProvider, ca string) CertificateProvider {
+	for _, provider := range providers {
+		for _, providerCA := range provider.CAs() {
+			if providerCA == ca {
+				return provider
+			}
+		}
+	}
+	return nil
+}
+
+type providersConfig struct {
+	Providers map[string]yaml.Node `yaml:"providers"`
+}
+
+type providerTypeConfig struct {
+	Type string `yaml:"type"`
+}
+
+// Load the certificate providers defined in the given provider configuration file (sorted by name).
+//
+// Each provider is defined by its name and its type, which determines the remaining configuration options.
+func LoadProviders(path string) ([]CertificateProvider, error) {
+	configBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provider configuration file '%s' (cause: %w)", path, err)
+	}
+	config := &providersConfig{}
+	err = certdconfig.UnmarshalExpanded(configBytes, filepath.Dir(path), config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal provider configuration file '%s' (cause: %w)", path, err)
+	}
+	names := make([]string, 0, len(config.Providers))
+	for name := range config.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	providers := make([]CertificateProvider, 0, len(names))
+	for _, name := range names {
+		node := config.Providers[name]
+		typeConfig := &providerTypeConfig{}
+		err = node.Decode(typeConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration for certificate provider '%s' (cause: %w)", name, err)
+		}
+		provider, err := NewProvider(typeConfig.Type, name, func(config any) error {
+			return node.Decode(config)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid provider configuration file '%s' (cause: %w)", path, err)
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
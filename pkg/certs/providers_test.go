@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"context"
+	"crypto/elliptic"
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/stretchr/testify/require"
+)
+
+type testProviderConfig struct {
+	CA string `yaml:"ca"`
+}
+
+type testProvider struct {
+	name   string
+	config *testProviderConfig
+}
+
+func (provider *testProvider) Name() string {
+	return provider.name
+}
+
+func (provider *testProvider) CAs() []string {
+	return []string{provider.config.CA}
+}
+
+func (provider *testProvider) NewCertificateFactory(ctx context.Context, ca string, request *ProviderRequest) (CertificateFactory, error) {
+	return nil, ErrGenericRequestUnsupported
+}
+
+func init() {
+	RegisterProviderType(&ProviderType{
+		Name:      "test",
+		NewConfig: func() any { return &testProviderConfig{} },
+		New: func(name string, config any) (CertificateProvider, error) {
+			return &testProvider{name: name, config: config.(*testProviderConfig)}, nil
+		},
+	})
+}
+
+func TestProviderTypes(t *testing.T) {
+	require.Contains(t, ProviderTypes(), "test")
+	require.Panics(t, func() {
+		RegisterProviderType(&ProviderType{Name: "test"})
+	})
+	provider, err := NewProvider("test", "Test", func(config any) error {
+		config.(*testProviderConfig).CA = "Test CA"
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Test", provider.Name())
+	_, err = NewProvider("test", "Invalid", func(config any) error {
+		return errors.New("invalid")
+	})
+	require.Error(t, err)
+	_, err = NewProvider("unknown", "Unknown", func(config any) error {
+		return nil
+	})
+	require.Error(t, err)
+	providers := []CertificateProvider{provider}
+	require.Equal(t, provider, FindProvider(providers, "Test CA"))
+	require.Nil(t, FindProvider(providers, "Unknown CA"))
+}
+
+func TestLoadProviders(t *testing.T) {
+	providers, err := LoadProviders("./testdata/providers-test.yaml")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(providers))
+	require.Equal(t, "Test1", providers[0].Name())
+	require.Equal(t, []string{"Test CA 1"}, providers[0].CAs())
+	require.Equal(t, "Test2", providers[1].Name())
+	require.Equal(t, []string{"Test CA 2"}, providers[1].CAs())
+	_, err = LoadProviders("./testdata/unknown.yaml")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestNewCertificateRequestRawSubject(t *testing.T) {
+	rawSubject, err := ParseRawDN("OU=Test,O=Organization,C=DE")
+	require.NoError(t, err)
+	request := &ProviderRequest{
+		KeyFactory: ecdsa.NewECDSAKeyPairFactory(elliptic.P256()),
+		RawSubject: rawSubject,
+		Domains:    []string{"localhost"},
+	}
+	_, certificateRequest, err := request.NewCertificateRequest(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "CN=localhost,OU=Test,O=Organization,C=DE", certificateRequest.Subject.String())
+}
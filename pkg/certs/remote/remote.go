@@ -18,13 +18,14 @@
 package remote
 
 import (
+	"context"
 	"crypto"
-	"crypto/rand"
 	"crypto/x509"
 	"fmt"
 
 	"github.com/hdecarne-github/certd/internal/logging"
 	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/entropy"
 	"github.com/hdecarne-github/certd/pkg/keys"
 	"github.com/rs/zerolog"
 )
@@ -50,12 +51,12 @@ func (factory *LocalCertificateRequestFactory) Name() string {
 	return ProviderName
 }
 
-func (factory *LocalCertificateRequestFactory) New() (crypto.PrivateKey, *x509.CertificateRequest, error) {
-	keyPair, err := factory.keyFactory.New()
+func (factory *LocalCertificateRequestFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.CertificateRequest, error) {
+	keyPair, err := keys.NewKeyPair(ctx, factory.keyFactory)
 	if err != nil {
 		return nil, nil, err
 	}
-	certificateRequestBytes, err := x509.CreateCertificateRequest(rand.Reader, factory.template, keyPair.Private())
+	certificateRequestBytes, err := x509.CreateCertificateRequest(entropy.ContextReader(ctx), factory.template, keyPair.Private())
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create certificate request (cause: %w)", err)
 	}
@@ -65,3 +66,24 @@ func (factory *LocalCertificateRequestFactory) New() (crypto.PrivateKey, *x509.C
 	}
 	return keyPair.Private(), certificateRequest, nil
 }
+
+type remoteProvider struct{}
+
+// Create the certificate provider for certificate requests to be signed by a remote CA.
+//
+// The provider only supports its dedicated generate request (/api/store/remote/generate).
+func NewProvider() certs.CertificateProvider {
+	return &remoteProvider{}
+}
+
+func (provider *remoteProvider) Name() string {
+	return ProviderName
+}
+
+func (provider *remoteProvider) CAs() []string {
+	return []string{ProviderName}
+}
+
+func (provider *remoteProvider) NewCertificateFactory(ctx context.Context, ca string, request *certs.ProviderRequest) (certs.CertificateFactory, error) {
+	return nil, certs.ErrGenericRequestUnsupported
+}
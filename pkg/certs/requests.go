@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+)
+
+var oidAttributeChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+
+type certificationRequestInfo struct {
+	Version    int
+	Subject    asn1.RawValue
+	PublicKey  asn1.RawValue
+	Attributes []attribute `asn1:"tag:0"`
+}
+
+// Check whether the given certificate request contains a challenge password attribute.
+func HasChallengePassword(request *x509.CertificateRequest) (bool, error) {
+	info := &certificationRequestInfo{}
+	_, err := asn1.Unmarshal(request.RawTBSCertificateRequest, info)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode certificate request info (cause: %w)", err)
+	}
+	for _, attr := range info.Attributes {
+		if attr.Type.Equal(oidAttributeChallengePassword) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Decode a public key from the given PEM encoded public key or certificate request.
+//
+// In case of a certificate request, its signature is checked to ensure the requestor is in possession
+// of the corresponding private key.
+func DecodePublicKey(bytes []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(bytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key (cause: %w)", err)
+		}
+		return publicKey, nil
+	case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+		request, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate request (cause: %w)", err)
+		}
+		err = request.CheckSignature()
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate request signature (cause: %w)", err)
+		}
+		return request.PublicKey, nil
+	}
+	return nil, fmt.Errorf("unexpected PEM block type '%s'", block.Type)
+}
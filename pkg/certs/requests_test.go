@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasChallengePassword(t *testing.T) {
+	requestPEM, err := os.ReadFile("./testdata/challenge.csr")
+	require.NoError(t, err)
+	block, _ := pem.Decode(requestPEM)
+	require.NotNil(t, block)
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	require.NoError(t, err)
+	challengePassword, err := HasChallengePassword(request)
+	require.NoError(t, err)
+	require.True(t, challengePassword)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	requestBytes, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "test"}}, key)
+	require.NoError(t, err)
+	request, err = x509.ParseCertificateRequest(requestBytes)
+	require.NoError(t, err)
+	challengePassword, err = HasChallengePassword(request)
+	require.NoError(t, err)
+	require.False(t, challengePassword)
+}
+
+func TestDecodePublicKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	publicKey, err := DecodePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes}))
+	require.NoError(t, err)
+	require.True(t, key.PublicKey.Equal(publicKey))
+	requestBytes, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "test"}}, key)
+	require.NoError(t, err)
+	publicKey, err = DecodePublicKey(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: requestBytes}))
+	require.NoError(t, err)
+	require.True(t, key.PublicKey.Equal(publicKey))
+	requestBytes[len(requestBytes)-1] ^= 0xff
+	_, err = DecodePublicKey(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: requestBytes}))
+	require.Error(t, err)
+	_, err = DecodePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: publicKeyBytes}))
+	require.Error(t, err)
+	_, err = DecodePublicKey([]byte("invalid"))
+	require.Error(t, err)
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/entropy"
+)
+
+// Revocation reason codes (RFC 5280, section 5.3.1).
+const (
+	ReasonUnspecified          = 0
+	ReasonKeyCompromise        = 1
+	ReasonCACompromise         = 2
+	ReasonAffiliationChanged   = 3
+	ReasonSuperseded           = 4
+	ReasonCessationOfOperation = 5
+)
+
+var oidExtensionReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+// Check the revocation state of a certificate using the given revocation list of the certificate's issuer.
+func CheckRevocation(certificate *x509.Certificate, issuer *x509.Certificate, revocationList *x509.RevocationList, now time.Time) error {
+	err := revocationList.CheckSignatureFrom(issuer)
+	if err != nil {
+		return fmt.Errorf("invalid revocation list signature for issuer '%s' (cause: %w)", issuer.Subject, err)
+	}
+	if !revocationList.NextUpdate.IsZero() && now.After(revocationList.NextUpdate) {
+		return fmt.Errorf("outdated revocation list for issuer '%s' (next update: %s)", issuer.Subject, revocationList.NextUpdate)
+	}
+	for _, revokedCertificate := range revocationList.RevokedCertificates {
+		if revokedCertificate.SerialNumber.Cmp(certificate.SerialNumber) == 0 {
+			return fmt.Errorf("certificate '%s' has been revoked at %s", certificate.Subject, revokedCertificate.RevocationTime)
+		}
+	}
+	return nil
+}
+
+// Create an updated revocation list for the given issuer.
+//
+// The given certificates are added to the entries of the current revocation list (which may be nil). Certificates
+// already listed are not added again. The CRL number is incremented with every update.
+func UpdateRevocationList(issuer *x509.Certificate, signer crypto.Signer, current *x509.RevocationList, revoke []*x509.Certificate, reason int, now time.Time, validity time.Duration) (*x509.RevocationList, error) {
+	revokedCertificates := make([]pkix.RevokedCertificate, 0)
+	number := big.NewInt(1)
+	if current != nil {
+		revokedCertificates = append(revokedCertificates, current.RevokedCertificates...)
+		if current.Number != nil {
+			number = number.Add(current.Number, number)
+		}
+	}
+	var extensions []pkix.Extension
+	if reason != ReasonUnspecified {
+		reasonBytes, err := asn1.Marshal(asn1.Enumerated(reason))
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal revocation reason (cause: %w)", err)
+		}
+		extensions = []pkix.Extension{{Id: oidExtensionReasonCode, Value: reasonBytes}}
+	}
+	for _, certificate := range revoke {
+		if IsRevoked(current, certificate) {
+			continue
+		}
+		revokedCertificates = append(revokedCertificates, pkix.RevokedCertificate{
+			SerialNumber:   certificate.SerialNumber,
+			RevocationTime: now.UTC(),
+			Extensions:     extensions,
+		})
+	}
+	template := &x509.RevocationList{
+		RevokedCertificates: revokedCertificates,
+		Number:              number,
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(validity),
+	}
+	revocationListBytes, err := x509.CreateRevocationList(entropy.Reader(), template, issuer, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create revocation list for issuer '%s' (cause: %w)", issuer.Subject, err)
+	}
+	revocationList, err := x509.ParseRevocationList(revocationListBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse revocation list bytes (cause: %w)", err)
+	}
+	return revocationList, nil
+}
+
+// Check whether the given certificate is listed in the given revocation list (which may be nil).
+func IsRevoked(revocationList *x509.RevocationList, certificate *x509.Certificate) bool {
+	if revocationList == nil {
+		return false
+	}
+	for _, revokedCertificate := range revocationList.RevokedCertificates {
+		if revokedCertificate.SerialNumber.Cmp(certificate.SerialNumber) == 0 {
+			return true
+		}
+	}
+	return false
+}
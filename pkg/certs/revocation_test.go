@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRevocation(t *testing.T) {
+	issuerKey, issuer := newTestCertificate(t, "Issuer", nil, nil, true)
+	issuer.KeyUsage |= x509.KeyUsageCRLSign
+	_, certificate := newTestCertificate(t, "Certificate", nil, issuer, false, issuerKey)
+	_, otherCertificate := newTestCertificate(t, "Other", nil, issuer, false, issuerKey)
+	now := time.Now()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: now.Add(-time.Minute),
+		NextUpdate: now.Add(time.Hour),
+		RevokedCertificates: []pkix.RevokedCertificate{
+			{SerialNumber: certificate.SerialNumber, RevocationTime: now.Add(-time.Minute)},
+		},
+	}
+	revocationListBytes, err := x509.CreateRevocationList(rand.Reader, template, issuer, issuerKey)
+	require.NoError(t, err)
+	revocationList, err := x509.ParseRevocationList(revocationListBytes)
+	require.NoError(t, err)
+	require.Error(t, CheckRevocation(certificate, issuer, revocationList, now))
+	require.NoError(t, CheckRevocation(otherCertificate, issuer, revocationList, now))
+	require.Error(t, CheckRevocation(otherCertificate, issuer, revocationList, now.Add(2*time.Hour)))
+	require.Error(t, CheckRevocation(otherCertificate, otherCertificate, revocationList, now))
+}
+
+func TestUpdateRevocationList(t *testing.T) {
+	issuerKey, issuer := newTestCertificate(t, "Issuer", nil, nil, true)
+	issuer.KeyUsage |= x509.KeyUsageCRLSign
+	_, certificate := newTestCertificate(t, "Certificate", nil, issuer, false, issuerKey)
+	_, otherCertificate := newTestCertificate(t, "Other", nil, issuer, false, issuerKey)
+	now := time.Now()
+	revocationList, err := UpdateRevocationList(issuer, issuerKey, nil, []*x509.Certificate{certificate}, ReasonKeyCompromise, now, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), revocationList.Number.Int64())
+	require.Len(t, revocationList.RevokedCertificates, 1)
+	require.True(t, IsRevoked(revocationList, certificate))
+	require.False(t, IsRevoked(revocationList, otherCertificate))
+	require.Error(t, CheckRevocation(certificate, issuer, revocationList, now))
+	require.NoError(t, CheckRevocation(otherCertificate, issuer, revocationList, now))
+	revocationList, err = UpdateRevocationList(issuer, issuerKey, revocationList, []*x509.Certificate{certificate, otherCertificate}, ReasonUnspecified, now, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), revocationList.Number.Int64())
+	require.Len(t, revocationList.RevokedCertificates, 2)
+	require.Len(t, revocationList.RevokedCertificates[0].Extensions, 1)
+	require.Len(t, revocationList.RevokedCertificates[1].Extensions, 0)
+	require.Error(t, CheckRevocation(otherCertificate, issuer, revocationList, now))
+}
@@ -0,0 +1,470 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package stepca
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/rs/zerolog"
+)
+
+// ProviderType is the type name used to define step-ca providers in the provider configuration.
+const ProviderType = "step-ca"
+
+// ProviderPrefix is prepended to the provider name to build the CA name.
+const ProviderPrefix = "Step:"
+
+const (
+	// ProvisionerJWK authenticates sign requests via tokens signed with the provisioner's JWK.
+	ProvisionerJWK = "jwk"
+	// ProvisionerOIDC authenticates sign requests via OIDC ID tokens.
+	ProvisionerOIDC = "oidc"
+)
+
+const tokenLifetime = 5 * time.Minute
+
+// Config defines the step-ca instance and provisioner to submit certificate requests to.
+type Config struct {
+	URL         string        `yaml:"url"`
+	Root        string        `yaml:"root"`
+	Provisioner string        `yaml:"provisioner"`
+	Type        string        `yaml:"provisioner_type"`
+	Key         string        `yaml:"key"`
+	Password    string        `yaml:"password"`
+	Token       string        `yaml:"token"`
+	Validity    time.Duration `yaml:"validity"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+func init() {
+	certs.RegisterProviderType(&certs.ProviderType{
+		Name: ProviderType,
+		NewConfig: func() any {
+			return &Config{
+				Type:    ProvisionerJWK,
+				Timeout: 30 * time.Second,
+			}
+		},
+		New: func(name string, config any) (certs.CertificateProvider, error) {
+			return NewProvider(name, config.(*Config))
+		},
+	})
+}
+
+type stepProvider struct {
+	name   string
+	config *Config
+	roots  *x509.CertPool
+	key    *jose.JSONWebKey
+	client *http.Client
+}
+
+// Create a provider submitting certificate requests to the given step-ca instance.
+//
+// Depending on the provisioner type, sign requests are authenticated via tokens signed with the provisioner key
+// (JWK) or via a given ID token (OIDC). Renew requests are authenticated via the certificate to renew.
+func NewProvider(name string, config *Config) (certs.CertificateProvider, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("missing step-ca URL for provider '%s'", name)
+	}
+	provider := &stepProvider{
+		name:   name,
+		config: config,
+	}
+	switch config.Type {
+	case ProvisionerJWK:
+		if config.Provisioner == "" {
+			return nil, fmt.Errorf("missing provisioner name for provider '%s'", name)
+		}
+		if config.Key == "" {
+			return nil, fmt.Errorf("missing provisioner key for provider '%s'", name)
+		}
+		key, err := readProvisionerKey(config.Key, config.Password)
+		if err != nil {
+			return nil, err
+		}
+		provider.key = key
+	case ProvisionerOIDC:
+	default:
+		return nil, fmt.Errorf("unrecognized provisioner type '%s' for provider '%s'", config.Type, name)
+	}
+	if config.Root != "" {
+		roots, err := readRoots(config.Root)
+		if err != nil {
+			return nil, err
+		}
+		provider.roots = roots
+	}
+	provider.client = provider.newClient(nil)
+	return provider, nil
+}
+
+func (provider *stepProvider) newClient(clientCertificate *tls.Certificate) *http.Client {
+	tlsConfig := &tls.Config{
+		RootCAs: provider.roots,
+	}
+	if clientCertificate != nil {
+		tlsConfig.Certificates = []tls.Certificate{*clientCertificate}
+	}
+	return &http.Client{
+		Timeout:   provider.config.Timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+}
+
+func (provider *stepProvider) Name() string {
+	return provider.name
+}
+
+func (provider *stepProvider) CAs() []string {
+	return []string{ProviderPrefix + provider.name}
+}
+
+func (provider *stepProvider) NewCertificateFactory(ctx context.Context, ca string, request *certs.ProviderRequest) (certs.CertificateFactory, error) {
+	if ca != ProviderPrefix+provider.name {
+		return nil, fmt.Errorf("unrecognized step-ca CA '%s'", ca)
+	}
+	validity := provider.config.Validity
+	if request.Params["validity"] != "" {
+		parsed, err := time.ParseDuration(request.Params["validity"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid validity '%s' (cause: %w)", request.Params["validity"], err)
+		}
+		validity = parsed
+	}
+	token := provider.config.Token
+	if request.Params["token"] != "" {
+		token = request.Params["token"]
+	}
+	if request.Certificate == nil && provider.config.Type == ProvisionerOIDC && token == "" {
+		return nil, fmt.Errorf("missing OIDC token for provider '%s'", provider.name)
+	}
+	logger := logging.RootLogger().With().Str("Provider", ca).Logger()
+	return &StepCertificateFactory{
+		provider: provider,
+		name:     ca,
+		request:  request,
+		validity: validity,
+		token:    token,
+		logger:   &logger,
+	}, nil
+}
+
+type StepCertificateFactory struct {
+	provider *stepProvider
+	name     string
+	request  *certs.ProviderRequest
+	validity time.Duration
+	token    string
+	chain    []*x509.Certificate
+	logger   *zerolog.Logger
+}
+
+func (factory *StepCertificateFactory) Name() string {
+	return factory.name
+}
+
+func (factory *StepCertificateFactory) Chain() []*x509.Certificate {
+	return factory.chain
+}
+
+type signRequest struct {
+	CSR      string `json:"csr"`
+	OTT      string `json:"ott"`
+	NotAfter string `json:"notAfter,omitempty"`
+}
+
+type signResponse struct {
+	CRT       string   `json:"crt"`
+	CA        string   `json:"ca"`
+	CertChain []string `json:"certChain"`
+}
+
+type errorResponse struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+type tokenClaims struct {
+	SANs []string `json:"sans,omitempty"`
+}
+
+func (factory *StepCertificateFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error) {
+	if factory.request.Certificate != nil {
+		return factory.renew(ctx)
+	}
+	key, certificateRequest, err := factory.request.NewCertificateRequest(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	token := factory.token
+	if factory.provider.config.Type == ProvisionerJWK {
+		token, err = factory.provider.newToken(certificateRequest)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	factory.logger.Info().Msgf("Submitting certificate request '%s' to step-ca provisioner '%s'...", certificateRequest.Subject, factory.provider.config.Provisioner)
+	sign := &signRequest{
+		CSR: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: certificateRequest.Raw})),
+		OTT: token,
+	}
+	if factory.validity > 0 {
+		sign.NotAfter = time.Now().Add(factory.validity).UTC().Format(time.RFC3339)
+	}
+	body, err := json.Marshal(sign)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal sign request (cause: %w)", err)
+	}
+	response, err := factory.provider.post(ctx, factory.provider.client, "/1.0/sign", body)
+	if err != nil {
+		return nil, nil, err
+	}
+	certificate, err := factory.evalResponse(response)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, certificate, nil
+}
+
+// Renew the request's certificate (authenticated via the certificate and key to renew).
+//
+// step-ca renews certificates for the existing key, hence the key is returned unchanged.
+func (factory *StepCertificateFactory) renew(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error) {
+	factory.logger.Info().Msgf("Submitting renew request for '%s' to step-ca...", factory.request.Certificate.Subject)
+	clientCertificate := &tls.Certificate{
+		Certificate: [][]byte{factory.request.Certificate.Raw},
+		PrivateKey:  factory.request.Key,
+		Leaf:        factory.request.Certificate,
+	}
+	response, err := factory.provider.post(ctx, factory.provider.newClient(clientCertificate), "/1.0/renew", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	certificate, err := factory.evalResponse(response)
+	if err != nil {
+		return nil, nil, err
+	}
+	return factory.request.Key, certificate, nil
+}
+
+func (factory *StepCertificateFactory) evalResponse(response *signResponse) (*x509.Certificate, error) {
+	certificates, err := certs.DecodeCertificates([]byte(response.CRT))
+	if err != nil || len(certificates) == 0 {
+		return nil, fmt.Errorf("failed to decode issued certificate (cause: %v)", err)
+	}
+	chainPEM := response.CertChain
+	if len(chainPEM) == 0 && response.CA != "" {
+		chainPEM = []string{response.CRT, response.CA}
+	}
+	chain := make([]*x509.Certificate, 0, len(chainPEM))
+	for _, certificatePEM := range chainPEM {
+		chainCertificates, err := certs.DecodeCertificates([]byte(certificatePEM))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode certificate chain (cause: %w)", err)
+		}
+		for _, chainCertificate := range chainCertificates {
+			// certChain starts with the issued certificate itself
+			if !chainCertificate.Equal(certificates[0]) {
+				chain = append(chain, chainCertificate)
+			}
+		}
+	}
+	factory.chain = chain
+	return certificates[0], nil
+}
+
+// Create a one-time token authorizing the given certificate request (signed with the provisioner key).
+func (provider *stepProvider) newToken(certificateRequest *x509.CertificateRequest) (string, error) {
+	algorithm, err := signatureAlgorithm(provider.key)
+	if err != nil {
+		return "", err
+	}
+	signingKey := jose.SigningKey{Algorithm: algorithm, Key: provider.key}
+	signer, err := jose.NewSigner(signingKey, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token signer (cause: %w)", err)
+	}
+	jti := make([]byte, 16)
+	_, err = rand.Read(jti)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token ID (cause: %w)", err)
+	}
+	subject := certificateRequest.Subject.CommonName
+	sans := append([]string{}, certificateRequest.DNSNames...)
+	for _, ipAddress := range certificateRequest.IPAddresses {
+		sans = append(sans, ipAddress.String())
+	}
+	sans = append(sans, certificateRequest.EmailAddresses...)
+	if subject == "" && len(sans) > 0 {
+		subject = sans[0]
+	}
+	now := time.Now()
+	claims := &jwt.Claims{
+		ID:        hex.EncodeToString(jti),
+		Issuer:    provider.config.Provisioner,
+		Subject:   subject,
+		Audience:  jwt.Audience{provider.url("/1.0/sign")},
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(now.Add(tokenLifetime)),
+	}
+	token, err := jwt.Signed(signer).Claims(claims).Claims(&tokenClaims{SANs: sans}).CompactSerialize()
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token (cause: %w)", err)
+	}
+	return token, nil
+}
+
+func (provider *stepProvider) url(path string) string {
+	return strings.TrimSuffix(provider.config.URL, "/") + path
+}
+
+func (provider *stepProvider) post(ctx context.Context, client *http.Client, path string, body []byte) (*signResponse, error) {
+	url := provider.url(path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare request '%s' (cause: %w)", url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request '%s' (cause: %w)", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		response := &errorResponse{}
+		err = json.NewDecoder(resp.Body).Decode(response)
+		if err == nil && response.Message != "" {
+			return nil, fmt.Errorf("request '%s' failed (status: %s; message: %s)", url, resp.Status, response.Message)
+		}
+		return nil, fmt.Errorf("request '%s' failed (status: %s)", url, resp.Status)
+	}
+	response := &signResponse{}
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response '%s' (cause: %w)", url, err)
+	}
+	return response, nil
+}
+
+// Read the provisioner key from the given JWK file.
+//
+// The file may either contain the plain JWK or the JWE encrypted one (as created by step crypto jwk create).
+func readProvisionerKey(keyFile string, password string) (*jose.JSONWebKey, error) {
+	keyBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provisioner key file '%s' (cause: %w)", keyFile, err)
+	}
+	keyBytes = bytes.TrimSpace(keyBytes)
+	if !isPlainJWK(keyBytes) {
+		encrypted, err := jose.ParseEncrypted(string(keyBytes))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse provisioner key file '%s' (cause: %w)", keyFile, err)
+		}
+		keyBytes, err = encrypted.Decrypt([]byte(password))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt provisioner key file '%s' (cause: %w)", keyFile, err)
+		}
+	}
+	key := &jose.JSONWebKey{}
+	err = key.UnmarshalJSON(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode provisioner key file '%s' (cause: %w)", keyFile, err)
+	}
+	if key.IsPublic() {
+		return nil, fmt.Errorf("provisioner key file '%s' contains no private key", keyFile)
+	}
+	if key.KeyID == "" {
+		thumbprint, err := key.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive provisioner key id (cause: %w)", err)
+		}
+		key.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	}
+	return key, nil
+}
+
+func isPlainJWK(keyBytes []byte) bool {
+	if len(keyBytes) == 0 || keyBytes[0] != '{' {
+		return false
+	}
+	probe := make(map[string]any)
+	err := json.Unmarshal(keyBytes, &probe)
+	if err != nil {
+		return false
+	}
+	_, hasKeyType := probe["kty"]
+	return hasKeyType
+}
+
+func signatureAlgorithm(key *jose.JSONWebKey) (jose.SignatureAlgorithm, error) {
+	if key.Algorithm != "" {
+		return jose.SignatureAlgorithm(key.Algorithm), nil
+	}
+	switch typedKey := key.Key.(type) {
+	case *ecdsa.PrivateKey:
+		switch typedKey.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		}
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case ed25519.PrivateKey:
+		return jose.EdDSA, nil
+	}
+	return "", fmt.Errorf("unsupported provisioner key type %T", key.Key)
+}
+
+func readRoots(rootFile string) (*x509.CertPool, error) {
+	rootBytes, err := os.ReadFile(rootFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read root certificate file '%s' (cause: %w)", rootFile, err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(rootBytes) {
+		return nil, fmt.Errorf("no root certificates found in file '%s'", rootFile)
+	}
+	return roots, nil
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package stepca
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/keys/registry"
+	"github.com/stretchr/testify/require"
+)
+
+const testProvisioner = "certd@example.org"
+const testPassword = "secret"
+const testOIDCToken = "oidc-token"
+
+func TestJWKProvisioner(t *testing.T) {
+	stepCA := newTestStepCA(t)
+	defer stepCA.Close()
+	provider, err := certs.NewProvider(ProviderType, "Test", func(config any) error {
+		stepConfig := config.(*Config)
+		stepConfig.URL = stepCA.URL
+		stepConfig.Root = stepCA.rootFile
+		stepConfig.Provisioner = testProvisioner
+		stepConfig.Key = stepCA.keyFile
+		stepConfig.Password = testPassword
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Step:Test"}, provider.CAs())
+	request := &certs.ProviderRequest{
+		Domains:    []string{"www.example.org", "example.org"},
+		KeyFactory: registry.StandardKey("ECDSA P-256"),
+	}
+	factory, err := provider.NewCertificateFactory(context.Background(), "Step:Test", request)
+	require.NoError(t, err)
+	key, certificate, err := factory.New(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, key)
+	require.Equal(t, "www.example.org", certificate.Subject.CommonName)
+	require.Equal(t, []string{"www.example.org", "example.org"}, certificate.DNSNames)
+	require.Equal(t, []*x509.Certificate{stepCA.ca}, factory.(certs.CertificateChainFactory).Chain())
+	request.Certificate = certificate
+	request.Key = key
+	factory, err = provider.NewCertificateFactory(context.Background(), "Step:Test", request)
+	require.NoError(t, err)
+	renewedKey, renewedCertificate, err := factory.New(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, key, renewedKey)
+	require.NotEqual(t, certificate.SerialNumber, renewedCertificate.SerialNumber)
+}
+
+func TestOIDCProvisioner(t *testing.T) {
+	stepCA := newTestStepCA(t)
+	defer stepCA.Close()
+	provider, err := NewProvider("Test", &Config{URL: stepCA.URL, Root: stepCA.rootFile, Type: ProvisionerOIDC})
+	require.NoError(t, err)
+	request := &certs.ProviderRequest{
+		Domains:    []string{"www.example.org"},
+		KeyFactory: registry.StandardKey("ECDSA P-256"),
+	}
+	_, err = provider.NewCertificateFactory(context.Background(), "Step:Test", request)
+	require.Error(t, err)
+	request.Params = map[string]string{"token": "invalid"}
+	factory, err := provider.NewCertificateFactory(context.Background(), "Step:Test", request)
+	require.NoError(t, err)
+	_, _, err = factory.New(context.Background())
+	require.ErrorContains(t, err, "invalid token")
+	request.Params["token"] = testOIDCToken
+	factory, err = provider.NewCertificateFactory(context.Background(), "Step:Test", request)
+	require.NoError(t, err)
+	_, certificate, err := factory.New(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"www.example.org"}, certificate.DNSNames)
+}
+
+func TestNewProviderInvalidConfig(t *testing.T) {
+	_, err := NewProvider("Test", &Config{Type: ProvisionerOIDC})
+	require.Error(t, err)
+	_, err = NewProvider("Test", &Config{URL: "https://localhost", Type: "x5c"})
+	require.Error(t, err)
+	_, err = NewProvider("Test", &Config{URL: "https://localhost", Type: ProvisionerJWK, Provisioner: testProvisioner})
+	require.Error(t, err)
+}
+
+type testStepCA struct {
+	*httptest.Server
+	ca       *x509.Certificate
+	rootFile string
+	keyFile  string
+}
+
+func newTestStepCA(t *testing.T) *testStepCA {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Step Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caBytes)
+	require.NoError(t, err)
+	provisionerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial := int64(1)
+	issue := func(publicKey crypto.PublicKey, subject pkix.Name, dnsNames []string) string {
+		serial++
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      subject,
+			DNSNames:     dnsNames,
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		certificateBytes, err := x509.CreateCertificate(rand.Reader, template, ca, publicKey, caKey)
+		require.NoError(t, err)
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateBytes}))
+	}
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caBytes}))
+	stepCA := &testStepCA{ca: ca}
+	stepCA.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var crt string
+		switch r.URL.Path {
+		case "/1.0/sign":
+			sign := &signRequest{}
+			err := json.NewDecoder(r.Body).Decode(sign)
+			require.NoError(t, err)
+			csrBlock, _ := pem.Decode([]byte(sign.CSR))
+			require.NotNil(t, csrBlock)
+			csr, err := x509.ParseCertificateRequest(csrBlock.Bytes)
+			require.NoError(t, err)
+			if sign.OTT != testOIDCToken {
+				token, err := jwt.ParseSigned(sign.OTT)
+				if err != nil {
+					w.WriteHeader(http.StatusUnauthorized)
+					_ = json.NewEncoder(w).Encode(&errorResponse{Status: http.StatusUnauthorized, Message: "invalid token"})
+					return
+				}
+				claims := &jwt.Claims{}
+				sans := &tokenClaims{}
+				require.NoError(t, token.Claims(provisionerKey.Public(), claims, sans))
+				require.NoError(t, claims.Validate(jwt.Expected{Issuer: testProvisioner, Subject: csr.Subject.CommonName, Audience: jwt.Audience{stepCA.URL + "/1.0/sign"}}))
+				require.Equal(t, csr.DNSNames, sans.SANs)
+			}
+			crt = issue(csr.PublicKey, csr.Subject, csr.DNSNames)
+		case "/1.0/renew":
+			require.Equal(t, 1, len(r.TLS.PeerCertificates))
+			current := r.TLS.PeerCertificates[0]
+			require.NoError(t, current.CheckSignatureFrom(ca))
+			crt = issue(current.PublicKey, current.Subject, current.DNSNames)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(&signResponse{CRT: crt, CA: caPEM, CertChain: []string{crt, caPEM}})
+	}))
+	stepCA.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	stepCA.StartTLS()
+	tempDir := t.TempDir()
+	stepCA.rootFile = filepath.Join(tempDir, "root.pem")
+	err = os.WriteFile(stepCA.rootFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: stepCA.Certificate().Raw}), 0600)
+	require.NoError(t, err)
+	jwk := &jose.JSONWebKey{Key: provisionerKey, KeyID: "test", Algorithm: string(jose.ES256)}
+	jwkBytes, err := jwk.MarshalJSON()
+	require.NoError(t, err)
+	encrypter, err := jose.NewEncrypter(jose.A256GCM, jose.Recipient{Algorithm: jose.PBES2_HS256_A128KW, Key: []byte(testPassword)}, nil)
+	require.NoError(t, err)
+	encrypted, err := encrypter.Encrypt(jwkBytes)
+	require.NoError(t, err)
+	encryptedJWK, err := encrypted.CompactSerialize()
+	require.NoError(t, err)
+	stepCA.keyFile = filepath.Join(tempDir, "provisioner.jwk")
+	err = os.WriteFile(stepCA.keyFile, []byte(encryptedJWK), 0600)
+	require.NoError(t, err)
+	return stepCA
+}
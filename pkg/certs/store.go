@@ -18,10 +18,18 @@
 package certs
 
 import (
+	"context"
 	"crypto"
 	"crypto/x509"
+	"errors"
+	"time"
 )
 
+// ErrGenerationPending indicates an update of a store entry whose key or certificate is currently being generated.
+//
+// Stores generate keys and certificates without blocking other store operations and reserve the entry name meanwhile.
+var ErrGenerationPending = errors.New("store entry generation already in progress")
+
 type Store interface {
 	Name() string
 	Entries() StoreEntries
@@ -42,11 +50,82 @@ type StoreEntry interface {
 	Attributes() (*StoreEntryAttributes, error)
 }
 
+// Kind of a store entry (determining the files an entry consists of)
+type StoreEntryKind string
+
+const (
+	// Certificate without key (e.g. an imported certificate or a certificate whose key is held elsewhere)
+	KindCertificate StoreEntryKind = "certificate"
+	// Certificate and key
+	KindKeyPair StoreEntryKind = "key-pair"
+	// Certificate request (with or without key)
+	KindRequest StoreEntryKind = "request"
+	// Self-signed CA certificate without key
+	KindTrustAnchor StoreEntryKind = "trust-anchor"
+	// Revocation list only
+	KindCRL StoreEntryKind = "crl"
+)
+
 type StoreEntryAttributes struct {
-	Provider string `json:"provider"`
+	// Entry name (only recorded if it can not be used as a file name as is)
+	Name          string                 `json:"name,omitempty"`
+	SchemaVersion int                    `json:"schema_version,omitempty"`
+	Provider      string                 `json:"provider"`
+	Kind          StoreEntryKind         `json:"kind,omitempty"`
+	Attestation   *StoreEntryAttestation `json:"attestation,omitempty"`
+	Labels        map[string]string      `json:"labels,omitempty"`
+	KeyRef        string                 `json:"key_ref,omitempty"`
+	Notes         []StoreEntryNote       `json:"notes,omitempty"`
+	Archived      *time.Time             `json:"archived,omitempty"`
+}
+
+type StoreEntryNote struct {
+	Time   time.Time `json:"time"`
+	Author string    `json:"author,omitempty"`
+	Text   string    `json:"text"`
 }
 
+type StoreEntryAttestation struct {
+	Verified bool   `json:"verified"`
+	Subject  string `json:"subject"`
+	Issuer   string `json:"issuer"`
+}
+
+// StoreExpiry records the expiry of a single store entry's certificate.
+type StoreExpiry struct {
+	Name     string    `json:"name"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// StoreEntries iterates over a snapshot of a store's entries.
+//
+// The snapshot is taken by Store.Entries; entries added afterwards are not returned. Entries removed afterwards are
+// still returned, but accessing their data fails with fs.ErrNotExist. Reset restarts the iteration on the same
+// snapshot.
 type StoreEntries interface {
 	Reset()
 	Next() StoreEntry
 }
+
+// Deliver the given store entries via a channel.
+//
+// The channel is closed as soon as all entries have been delivered or the context is done. Consumers stopping
+// early must cancel the context to release the delivering goroutine.
+func StreamEntries(ctx context.Context, storeEntries StoreEntries) <-chan StoreEntry {
+	stream := make(chan StoreEntry)
+	go func() {
+		defer close(stream)
+		for {
+			storeEntry := storeEntries.Next()
+			if storeEntry == nil {
+				return
+			}
+			select {
+			case stream <- storeEntry:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return stream
+}
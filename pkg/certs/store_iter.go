@@ -0,0 +1,68 @@
+//go:build go1.23
+
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import "iter"
+
+// Get an iterator over the given store entries (see StoreEntries for the snapshot semantics).
+func Seq(storeEntries StoreEntries) iter.Seq[StoreEntry] {
+	return func(yield func(StoreEntry) bool) {
+		for {
+			storeEntry := storeEntries.Next()
+			if storeEntry == nil || !yield(storeEntry) {
+				return
+			}
+		}
+	}
+}
+
+// Get an iterator over all entries of the given store.
+//
+// Each iteration takes a new snapshot of the store's entries.
+func All(store Store) iter.Seq[StoreEntry] {
+	return func(yield func(StoreEntry) bool) {
+		for storeEntry := range Seq(store.Entries()) {
+			if !yield(storeEntry) {
+				return
+			}
+		}
+	}
+}
+
+// Get an iterator over the entries of the given store matched by the given function.
+func Filtered(store Store, match func(StoreEntry) bool) iter.Seq[StoreEntry] {
+	return func(yield func(StoreEntry) bool) {
+		for storeEntry := range All(store) {
+			if match(storeEntry) && !yield(storeEntry) {
+				return
+			}
+		}
+	}
+}
+
+// Match store entries with a certificate.
+func HasCertificate(storeEntry StoreEntry) bool {
+	return storeEntry.HasCertificate()
+}
+
+// Match store entries with a key.
+func HasKey(storeEntry StoreEntry) bool {
+	return storeEntry.HasKey()
+}
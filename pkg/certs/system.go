@@ -0,0 +1,53 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// Well-known locations of the system's trusted root certificates bundle.
+var systemCertificateFiles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",                // Debian/Ubuntu/Gentoo etc.
+	"/etc/pki/tls/certs/ca-bundle.crt",                  // Fedora/RHEL 6
+	"/etc/ssl/ca-bundle.pem",                            // OpenSUSE
+	"/etc/pki/tls/cacert.pem",                           // OpenELEC
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem", // CentOS/RHEL 7
+	"/etc/ssl/cert.pem",                                 // Alpine Linux, macOS, BSD
+}
+
+// Read the system's trusted root certificates.
+//
+// The SSL_CERT_FILE environment variable takes precedence over the well-known bundle locations.
+func SystemCertificates() ([]*x509.Certificate, error) {
+	certificateFiles := systemCertificateFiles
+	sslCertFile := os.Getenv("SSL_CERT_FILE")
+	if sslCertFile != "" {
+		certificateFiles = []string{sslCertFile}
+	}
+	for _, certificateFile := range certificateFiles {
+		_, err := os.Stat(certificateFile)
+		if err != nil {
+			continue
+		}
+		return ReadCertificates(certificateFile)
+	}
+	return nil, fmt.Errorf("no system certificates found")
+}
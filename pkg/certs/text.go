@@ -0,0 +1,257 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	x509ext "github.com/hdecarne-github/certd/pkg/certs/extensions"
+)
+
+var textRDNTypes = map[string]string{
+	"2.5.4.3":                    "CN",
+	"2.5.4.4":                    "SN",
+	"2.5.4.5":                    "serialNumber",
+	"2.5.4.6":                    "C",
+	"2.5.4.7":                    "L",
+	"2.5.4.8":                    "ST",
+	"2.5.4.9":                    "street",
+	"2.5.4.10":                   "O",
+	"2.5.4.11":                   "OU",
+	"2.5.4.12":                   "title",
+	"2.5.4.13":                   "description",
+	"2.5.4.15":                   "businessCategory",
+	"2.5.4.17":                   "postalCode",
+	"2.5.4.18":                   "postOfficeBox",
+	"2.5.4.41":                   "name",
+	"2.5.4.42":                   "GN",
+	"2.5.4.43":                   "initials",
+	"2.5.4.44":                   "generationQualifier",
+	"2.5.4.46":                   "dnQualifier",
+	"2.5.4.65":                   "pseudonym",
+	"2.5.4.97":                   "organizationIdentifier",
+	"0.9.2342.19200300.100.1.1":  "UID",
+	"0.9.2342.19200300.100.1.25": "DC",
+	"1.2.840.113549.1.9.1":       "emailAddress",
+}
+
+var textSignatureAlgorithms = map[x509.SignatureAlgorithm]string{
+	x509.MD5WithRSA:       "md5WithRSAEncryption",
+	x509.SHA1WithRSA:      "sha1WithRSAEncryption",
+	x509.SHA256WithRSA:    "sha256WithRSAEncryption",
+	x509.SHA384WithRSA:    "sha384WithRSAEncryption",
+	x509.SHA512WithRSA:    "sha512WithRSAEncryption",
+	x509.SHA256WithRSAPSS: "rsassaPss",
+	x509.SHA384WithRSAPSS: "rsassaPss",
+	x509.SHA512WithRSAPSS: "rsassaPss",
+	x509.ECDSAWithSHA1:    "ecdsa-with-SHA1",
+	x509.ECDSAWithSHA256:  "ecdsa-with-SHA256",
+	x509.ECDSAWithSHA384:  "ecdsa-with-SHA384",
+	x509.ECDSAWithSHA512:  "ecdsa-with-SHA512",
+	x509.PureEd25519:      "ED25519",
+}
+
+var textCurveNames = map[elliptic.Curve][2]string{
+	elliptic.P224(): {"secp224r1", "P-224"},
+	elliptic.P256(): {"prime256v1", "P-256"},
+	elliptic.P384(): {"secp384r1", "P-384"},
+	elliptic.P521(): {"secp521r1", "P-521"},
+}
+
+const textTimeLayout = "Jan _2 15:04:05 2006 GMT"
+
+// Write the OpenSSL style text representation (as generated by "openssl x509 -text") of the given certificate.
+func WriteCertificateText(out io.Writer, certificate *x509.Certificate) error {
+	text := &textWriter{}
+	text.line(0, "Certificate:")
+	text.line(4, "Data:")
+	text.line(8, "Version: %d (0x%x)", certificate.Version, certificate.Version-1)
+	text.serialNumber(8, certificate.SerialNumber)
+	text.line(8, "Signature Algorithm: %s", textSignatureAlgorithm(certificate.SignatureAlgorithm))
+	text.line(8, "Issuer: %s", textName(certificate.RawIssuer))
+	text.line(8, "Validity")
+	text.line(12, "Not Before: %s", certificate.NotBefore.UTC().Format(textTimeLayout))
+	text.line(12, "Not After : %s", certificate.NotAfter.UTC().Format(textTimeLayout))
+	text.line(8, "Subject: %s", textName(certificate.RawSubject))
+	text.publicKey(8, certificate.PublicKey)
+	if len(certificate.Extensions) > 0 {
+		text.line(8, "X509v3 extensions:")
+		for _, extension := range certificate.Extensions {
+			name, value := x509ext.Decode(certificate, extension)
+			text.extension(12, name, value, extension)
+		}
+	}
+	text.line(4, "Signature Algorithm: %s", textSignatureAlgorithm(certificate.SignatureAlgorithm))
+	text.line(4, "Signature Value:")
+	text.hex(8, certificate.Signature, 18)
+	_, err := io.WriteString(out, text.String())
+	return err
+}
+
+// Write the OpenSSL style text representation (as generated by "openssl req -text") of the given certificate request.
+func WriteCertificateRequestText(out io.Writer, request *x509.CertificateRequest) error {
+	text := &textWriter{}
+	text.line(0, "Certificate Request:")
+	text.line(4, "Data:")
+	text.line(8, "Version: %d (0x%x)", request.Version+1, request.Version)
+	text.line(8, "Subject: %s", textName(request.RawSubject))
+	text.publicKey(8, request.PublicKey)
+	text.line(8, "Attributes:")
+	challengePassword, err := HasChallengePassword(request)
+	if err != nil {
+		return err
+	}
+	if challengePassword {
+		text.line(12, "challengePassword        :<hidden>")
+	}
+	if len(request.Extensions) > 0 {
+		text.line(12, "Requested Extensions:")
+		for _, extension := range request.Extensions {
+			name, value := x509ext.DecodeRequest(request, extension)
+			text.extension(16, name, value, extension)
+		}
+	}
+	text.line(4, "Signature Algorithm: %s", textSignatureAlgorithm(request.SignatureAlgorithm))
+	text.line(4, "Signature Value:")
+	text.hex(8, request.Signature, 18)
+	_, err = io.WriteString(out, text.String())
+	return err
+}
+
+type textWriter struct {
+	strings.Builder
+}
+
+func (text *textWriter) line(indent int, format string, args ...any) {
+	text.WriteString(strings.Repeat(" ", indent))
+	fmt.Fprintf(text, format, args...)
+	text.WriteByte('\n')
+}
+
+func (text *textWriter) hex(indent int, data []byte, perLine int) {
+	for offset := 0; offset < len(data); offset += perLine {
+		end := offset + perLine
+		if end > len(data) {
+			end = len(data)
+		}
+		hexBytes := make([]string, 0, perLine)
+		for _, b := range data[offset:end] {
+			hexBytes = append(hexBytes, fmt.Sprintf("%02x", b))
+		}
+		separator := ":"
+		if end == len(data) {
+			separator = ""
+		}
+		text.line(indent, "%s%s", strings.Join(hexBytes, ":"), separator)
+	}
+}
+
+func (text *textWriter) serialNumber(indent int, serialNumber *big.Int) {
+	if serialNumber.Sign() >= 0 && serialNumber.BitLen() < 64 {
+		text.line(indent, "Serial Number: %d (0x%x)", serialNumber, serialNumber)
+		return
+	}
+	text.line(indent, "Serial Number:")
+	text.hex(indent+4, serialNumber.Bytes(), len(serialNumber.Bytes()))
+}
+
+func (text *textWriter) publicKey(indent int, publicKey any) {
+	text.line(indent, "Subject Public Key Info:")
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		text.line(indent+4, "Public Key Algorithm: rsaEncryption")
+		text.line(indent+8, "Public-Key: (%d bit)", key.N.BitLen())
+		text.line(indent+8, "Modulus:")
+		// a leading zero byte is added by the DER encoding, if the high bit is set
+		modulus := key.N.Bytes()
+		if modulus[0]&0x80 != 0 {
+			modulus = append([]byte{0}, modulus...)
+		}
+		text.hex(indent+12, modulus, 15)
+		text.line(indent+8, "Exponent: %d (0x%x)", key.E, key.E)
+	case *ecdsa.PublicKey:
+		text.line(indent+4, "Public Key Algorithm: id-ecPublicKey")
+		text.line(indent+8, "Public-Key: (%d bit)", key.Curve.Params().BitSize)
+		text.line(indent+8, "pub:")
+		text.hex(indent+12, elliptic.Marshal(key.Curve, key.X, key.Y), 15)
+		curveNames, known := textCurveNames[key.Curve]
+		if known {
+			text.line(indent+8, "ASN1 OID: %s", curveNames[0])
+			text.line(indent+8, "NIST CURVE: %s", curveNames[1])
+		}
+	case ed25519.PublicKey:
+		text.line(indent+4, "Public Key Algorithm: ED25519")
+		text.line(indent+8, "ED25519 Public-Key:")
+		text.line(indent+8, "pub:")
+		text.hex(indent+12, key, 15)
+	default:
+		text.line(indent+4, "Public Key Algorithm: %T", publicKey)
+	}
+}
+
+func (text *textWriter) extension(indent int, name string, value string, extension pkix.Extension) {
+	if extension.Critical {
+		text.line(indent, "%s: critical", name)
+	} else {
+		text.line(indent, "%s: ", name)
+	}
+	if value != "" {
+		text.line(indent+4, "%s", value)
+	} else {
+		text.hex(indent+4, extension.Value, 18)
+	}
+}
+
+func textSignatureAlgorithm(signatureAlgorithm x509.SignatureAlgorithm) string {
+	name, known := textSignatureAlgorithms[signatureAlgorithm]
+	if !known {
+		return signatureAlgorithm.String()
+	}
+	return name
+}
+
+func textName(raw []byte) string {
+	var rdns pkix.RDNSequence
+	_, err := asn1.Unmarshal(raw, &rdns)
+	if err != nil {
+		return "?"
+	}
+	rdnStrings := make([]string, 0, len(rdns))
+	for _, rdn := range rdns {
+		atvStrings := make([]string, 0, len(rdn))
+		for _, atv := range rdn {
+			atvType, known := textRDNTypes[atv.Type.String()]
+			if !known {
+				atvType = atv.Type.String()
+			}
+			atvStrings = append(atvStrings, fmt.Sprintf("%s = %v", atvType, atv.Value))
+		}
+		rdnStrings = append(rdnStrings, strings.Join(atvStrings, " + "))
+	}
+	return strings.Join(rdnStrings, ", ")
+}
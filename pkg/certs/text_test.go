@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCertificateText(t *testing.T) {
+	certificateBytes, err := os.ReadFile("./testdata/isrgrootx1.der")
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	require.NoError(t, err)
+	var text strings.Builder
+	err = WriteCertificateText(&text, certificate)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(text.String(), "Certificate:\n    Data:\n        Version: 3 (0x2)\n"))
+	require.Contains(t, text.String(), "\n            82:10:cf:b0:d2:40:e3:59:44:63:e0:bb:63:82:8b:00\n")
+	require.Contains(t, text.String(), "\n        Signature Algorithm: sha256WithRSAEncryption\n")
+	require.Contains(t, text.String(), "\n        Issuer: C = US, O = Internet Security Research Group, CN = ISRG Root X1\n")
+	require.Contains(t, text.String(), "\n            Not Before: Jun  4 11:04:38 2015 GMT\n")
+	require.Contains(t, text.String(), "\n            Not After : Jun  4 11:04:38 2035 GMT\n")
+	require.Contains(t, text.String(), "\n                Public-Key: (4096 bit)\n")
+	require.Contains(t, text.String(), "\n                    00:ad:e8:24:73:f4:14:37:f3:9b:9e:2b:57:28:1c:\n")
+	require.Contains(t, text.String(), "\n                Exponent: 65537 (0x10001)\n")
+	require.Contains(t, text.String(), "\n            BasicConstraints: critical\n")
+	require.Contains(t, text.String(), "\n    Signature Value:\n")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, ecCertificate := newTestCertificate(t, "Test", key, nil, false)
+	text.Reset()
+	err = WriteCertificateText(&text, ecCertificate)
+	require.NoError(t, err)
+	require.Contains(t, text.String(), "\n        Subject: CN = Test\n")
+	require.Contains(t, text.String(), "\n            Public Key Algorithm: id-ecPublicKey\n")
+	require.Contains(t, text.String(), "\n                ASN1 OID: prime256v1\n")
+}
+
+func TestWriteCertificateRequestText(t *testing.T) {
+	requestPEM, err := os.ReadFile("./testdata/challenge.csr")
+	require.NoError(t, err)
+	block, _ := pem.Decode(requestPEM)
+	require.NotNil(t, block)
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	require.NoError(t, err)
+	var text strings.Builder
+	err = WriteCertificateRequestText(&text, request)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(text.String(), "Certificate Request:\n    Data:\n        Version: 1 (0x0)\n"))
+	require.Contains(t, text.String(), "\n            challengePassword        :<hidden>\n")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	requestBytes, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "Test"}, DNSNames: []string{"test.example.org"}}, key)
+	require.NoError(t, err)
+	request, err = x509.ParseCertificateRequest(requestBytes)
+	require.NoError(t, err)
+	text.Reset()
+	err = WriteCertificateRequestText(&text, request)
+	require.NoError(t, err)
+	require.Contains(t, text.String(), "\n        Subject: CN = Test\n")
+	require.Contains(t, text.String(), "\n            Requested Extensions:\n")
+	require.Contains(t, text.String(), "\n                    DNS:test.example.org\n")
+}
@@ -0,0 +1,326 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+const TimestampQueryContentType = "application/timestamp-query"
+const TimestampReplyContentType = "application/timestamp-reply"
+
+var oidContentTypeTSTInfo = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+var oidAttributeSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+
+// PKI status values (RFC 3161)
+const (
+	pkiStatusGranted   = 0
+	pkiStatusRejection = 2
+)
+
+// PKI failure info bits (RFC 3161)
+const (
+	pkiFailureBadAlg           = 0
+	pkiFailureBadRequest       = 2
+	pkiFailureBadDataFormat    = 5
+	pkiFailureUnacceptedPolicy = 15
+	pkiFailureSystemFailure    = 25
+)
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+	Extensions     []pkix.Extension      `asn1:"optional,tag:0"`
+}
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []asn1.RawValue `asn1:"optional"`
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        asn1.RawValue
+	Accuracy       tstAccuracy      `asn1:"optional"`
+	Ordering       bool             `asn1:"optional,default:false"`
+	Nonce          *big.Int         `asn1:"optional"`
+	TSA            asn1.RawValue    `asn1:"optional,tag:0"`
+	Extensions     []pkix.Extension `asn1:"optional,tag:1"`
+}
+
+type tstAccuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type signingCertificateV2 struct {
+	Certs []essCertIDv2
+}
+
+// ESSCertIDv2 with the default hash algorithm (SHA-256)
+type essCertIDv2 struct {
+	CertHash []byte
+}
+
+// TimestampAuthority creates RFC 3161 timestamp responses.
+type TimestampAuthority struct {
+	certificate *x509.Certificate
+	chain       []*x509.Certificate
+	key         crypto.PrivateKey
+	policy      asn1.ObjectIdentifier
+}
+
+// Create a TimestampAuthority signing its timestamp tokens with the given certificate and key.
+//
+// The certificate must be enabled for timestamping (as the only extended key usage).
+func NewTimestampAuthority(certificate *x509.Certificate, chain []*x509.Certificate, key crypto.PrivateKey, policy asn1.ObjectIdentifier) (*TimestampAuthority, error) {
+	if len(certificate.ExtKeyUsage) != 1 || certificate.ExtKeyUsage[0] != x509.ExtKeyUsageTimeStamping {
+		return nil, fmt.Errorf("certificate '%s' is not enabled for timestamping", certificate.Subject)
+	}
+	if len(policy) == 0 {
+		return nil, errors.New("missing timestamp policy")
+	}
+	tsa := &TimestampAuthority{
+		certificate: certificate,
+		chain:       chain,
+		key:         key,
+		policy:      policy,
+	}
+	return tsa, nil
+}
+
+// Create the timestamp response for the given (DER encoded) timestamp request.
+//
+// Invalid or unsupported requests are answered with a rejection response. An error is only
+// returned if no response at all could be created.
+func (tsa *TimestampAuthority) Respond(requestBytes []byte, now time.Time) ([]byte, error) {
+	request := &timeStampReq{}
+	rest, err := asn1.Unmarshal(requestBytes, request)
+	if err != nil || len(rest) > 0 || request.Version != 1 {
+		return rejectTimestampRequest(pkiFailureBadDataFormat)
+	}
+	var hash crypto.Hash
+	for candidate, oid := range oidDigestAlgorithms {
+		if oid.Equal(request.MessageImprint.HashAlgorithm.Algorithm) {
+			hash = candidate
+		}
+	}
+	if hash == 0 {
+		return rejectTimestampRequest(pkiFailureBadAlg)
+	}
+	if len(request.MessageImprint.HashedMessage) != hash.Size() {
+		return rejectTimestampRequest(pkiFailureBadRequest)
+	}
+	if len(request.ReqPolicy) > 0 && !request.ReqPolicy.Equal(tsa.policy) {
+		return rejectTimestampRequest(pkiFailureUnacceptedPolicy)
+	}
+	token, err := tsa.createToken(request, now)
+	if err != nil {
+		return rejectTimestampRequest(pkiFailureSystemFailure)
+	}
+	return asn1.Marshal(timeStampResp{
+		Status:         pkiStatusInfo{Status: pkiStatusGranted},
+		TimeStampToken: asn1.RawValue{FullBytes: token},
+	})
+}
+
+func (tsa *TimestampAuthority) createToken(request *timeStampReq, now time.Time) ([]byte, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number (cause: %w)", err)
+	}
+	genTime, err := asn1.MarshalWithParams(now.UTC().Truncate(time.Second), "generalized")
+	if err != nil {
+		return nil, err
+	}
+	info := &tstInfo{
+		Version:        1,
+		Policy:         tsa.policy,
+		MessageImprint: request.MessageImprint,
+		SerialNumber:   serialNumber,
+		GenTime:        asn1.RawValue{FullBytes: genTime},
+		Accuracy:       tstAccuracy{Seconds: 1},
+		Nonce:          request.Nonce,
+	}
+	infoBytes, err := asn1.Marshal(*info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode timestamp token info (cause: %w)", err)
+	}
+	certificateHash := sha256.Sum256(tsa.certificate.Raw)
+	signingCertificate, err := asn1.Marshal(signingCertificateV2{Certs: []essCertIDv2{{CertHash: certificateHash[:]}}})
+	if err != nil {
+		return nil, err
+	}
+	hash := crypto.SHA256
+	_, ed25519Key := tsa.certificate.PublicKey.(ed25519.PublicKey)
+	if ed25519Key {
+		hash = crypto.SHA512
+	}
+	hasher := hash.New()
+	hasher.Write(infoBytes)
+	var certificates []*x509.Certificate
+	if request.CertReq {
+		certificates = append([]*x509.Certificate{tsa.certificate}, tsa.chain...)
+	}
+	extraAttrs := map[string]asn1.RawValue{oidAttributeSigningCertificateV2.String(): {FullBytes: signingCertificate}}
+	signed, err := newSignedData(oidContentTypeTSTInfo, infoBytes, hasher.Sum(nil), hash, tsa.certificate, certificates, tsa.key, extraAttrs)
+	if err != nil {
+		return nil, err
+	}
+	// RFC 3161 requires version 3 for the encapsulated content type id-ct-TSTInfo
+	signed.Version = 3
+	return marshalSignedData(signed)
+}
+
+func rejectTimestampRequest(failure int) ([]byte, error) {
+	failInfo := asn1.BitString{Bytes: make([]byte, failure/8+1), BitLength: failure + 1}
+	failInfo.Bytes[failure/8] |= 0x80 >> (failure % 8)
+	return asn1.Marshal(timeStampResp{
+		Status: pkiStatusInfo{Status: pkiStatusRejection, FailInfo: failInfo},
+	})
+}
+
+// Create a Timestamper retrieving the timestamp tokens from the given RFC 3161 timestamp authority.
+func NewTimestamper(url string) Timestamper {
+	return func(signature []byte, hash crypto.Hash) ([]byte, error) {
+		hasher := hash.New()
+		hasher.Write(signature)
+		return FetchTimestamp(url, hasher.Sum(nil), hash)
+	}
+}
+
+// Fetch a RFC 3161 timestamp token for the given message digest from the given timestamp authority.
+//
+// The returned timestamp token is checked for matching the request (message imprint and nonce) and
+// for a valid signature.
+func FetchTimestamp(url string, digest []byte, hash crypto.Hash) ([]byte, error) {
+	oidDigestAlgorithm, ok := oidDigestAlgorithms[hash]
+	if !ok {
+		return nil, fmt.Errorf("unsupported digest algorithm %s", hash)
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce (cause: %w)", err)
+	}
+	request := &timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidDigestAlgorithm},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	}
+	requestBytes, err := asn1.Marshal(*request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode timestamp request (cause: %w)", err)
+	}
+	rsp, err := http.Post(url, TimestampQueryContentType, bytes.NewReader(requestBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to send timestamp request to '%s' (cause: %w)", url, err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to send timestamp request to '%s' (http status: %s)", url, rsp.Status)
+	}
+	responseBytes, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive timestamp response from '%s' (cause: %w)", url, err)
+	}
+	token, err := parseTimestampResponse(responseBytes, request)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp response from '%s' (cause: %w)", url, err)
+	}
+	return token, nil
+}
+
+func parseTimestampResponse(responseBytes []byte, request *timeStampReq) ([]byte, error) {
+	response := &timeStampResp{}
+	_, err := asn1.Unmarshal(responseBytes, response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode timestamp response (cause: %w)", err)
+	}
+	// 0: granted, 1: grantedWithMods
+	if response.Status.Status > 1 {
+		return nil, fmt.Errorf("timestamp request rejected (status: %d)", response.Status.Status)
+	}
+	if len(response.TimeStampToken.FullBytes) == 0 {
+		return nil, errors.New("missing timestamp token")
+	}
+	token := response.TimeStampToken.FullBytes
+	signed, err := parseSignedData(token)
+	if err != nil {
+		return nil, err
+	}
+	if !signed.EncapContentInfo.EContentType.Equal(oidContentTypeTSTInfo) {
+		return nil, fmt.Errorf("unexpected timestamp token content type %s", signed.EncapContentInfo.EContentType)
+	}
+	info := &tstInfo{}
+	_, err = asn1.Unmarshal(signed.EncapContentInfo.EContent, info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode timestamp token info (cause: %w)", err)
+	}
+	if !info.MessageImprint.HashAlgorithm.Algorithm.Equal(request.MessageImprint.HashAlgorithm.Algorithm) || !bytes.Equal(info.MessageImprint.HashedMessage, request.MessageImprint.HashedMessage) {
+		return nil, errors.New("timestamp token message imprint mismatch")
+	}
+	if info.Nonce == nil || info.Nonce.Cmp(request.Nonce) != 0 {
+		return nil, errors.New("timestamp token nonce mismatch")
+	}
+	hash, err := signed.digestHash()
+	if err != nil {
+		return nil, err
+	}
+	hasher := hash.New()
+	hasher.Write(signed.EncapContentInfo.EContent)
+	_, err = signed.verify(hasher.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testTimestampPolicy = asn1.ObjectIdentifier{1, 2, 3, 4}
+
+func TestFetchTimestamp(t *testing.T) {
+	tsa := newTestTSA(t)
+	defer tsa.Close()
+	digest := sha256.Sum256([]byte("test"))
+	token, err := FetchTimestamp(tsa.URL, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+	_, err = FetchTimestamp(tsa.URL, digest[:], crypto.SHA1)
+	require.Error(t, err)
+}
+
+func TestTimestampAuthority(t *testing.T) {
+	tsa := newTestTimestampAuthority(t)
+	digest := sha256.Sum256([]byte("test"))
+	request := &timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidDigestAlgorithms[crypto.SHA256]},
+			HashedMessage: digest[:],
+		},
+		Nonce:   big.NewInt(42),
+		CertReq: true,
+	}
+	requestBytes, err := asn1.Marshal(*request)
+	require.NoError(t, err)
+	responseBytes, err := tsa.Respond(requestBytes, time.Now())
+	require.NoError(t, err)
+	token, err := parseTimestampResponse(responseBytes, request)
+	require.NoError(t, err)
+	signed, err := parseSignedData(token)
+	require.NoError(t, err)
+	require.Equal(t, 3, signed.Version)
+	// invalid digest length
+	request.MessageImprint.HashedMessage = digest[1:]
+	requestBytes, err = asn1.Marshal(*request)
+	require.NoError(t, err)
+	requireTimestampRejection(t, tsa, requestBytes, pkiFailureBadRequest)
+	// unsupported digest algorithm
+	request.MessageImprint.HashAlgorithm.Algorithm = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	requestBytes, err = asn1.Marshal(*request)
+	require.NoError(t, err)
+	requireTimestampRejection(t, tsa, requestBytes, pkiFailureBadAlg)
+	// unaccepted policy
+	request.MessageImprint.HashAlgorithm.Algorithm = oidDigestAlgorithms[crypto.SHA256]
+	request.MessageImprint.HashedMessage = digest[:]
+	request.ReqPolicy = asn1.ObjectIdentifier{1, 2, 3, 5}
+	requestBytes, err = asn1.Marshal(*request)
+	require.NoError(t, err)
+	requireTimestampRejection(t, tsa, requestBytes, pkiFailureUnacceptedPolicy)
+	// garbage
+	requireTimestampRejection(t, tsa, []byte("test"), pkiFailureBadDataFormat)
+}
+
+func TestNewTimestampAuthority(t *testing.T) {
+	key, certificate := newTestCertificate(t, "TSA", nil, nil, false)
+	_, err := NewTimestampAuthority(certificate, nil, key, testTimestampPolicy)
+	require.Error(t, err)
+}
+
+func requireTimestampRejection(t *testing.T, tsa *TimestampAuthority, requestBytes []byte, failure int) {
+	responseBytes, err := tsa.Respond(requestBytes, time.Now())
+	require.NoError(t, err)
+	response := &timeStampResp{}
+	_, err = asn1.Unmarshal(responseBytes, response)
+	require.NoError(t, err)
+	require.Equal(t, pkiStatusRejection, response.Status.Status)
+	require.Equal(t, 1, response.Status.FailInfo.At(failure))
+	require.Empty(t, response.TimeStampToken.FullBytes)
+}
+
+func newTestTSA(t *testing.T) *httptest.Server {
+	tsa := newTestTimestampAuthority(t)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestBytes, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		responseBytes, err := tsa.Respond(requestBytes, time.Now())
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", TimestampReplyContentType)
+		w.Write(responseBytes)
+	}))
+}
+
+func newTestTimestampAuthority(t *testing.T) *TimestampAuthority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject:      pkix.Name{CommonName: "TSA"},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtraExtensions: []pkix.Extension{
+			newTimeStampingExtKeyUsageExtension(t),
+		},
+	}
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	require.NoError(t, err)
+	tsa, err := NewTimestampAuthority(certificate, nil, key, testTimestampPolicy)
+	require.NoError(t, err)
+	return tsa
+}
+
+func newTimeStampingExtKeyUsageExtension(t *testing.T) pkix.Extension {
+	value, err := asn1.Marshal([]asn1.ObjectIdentifier{{1, 3, 6, 1, 5, 5, 7, 3, 8}})
+	require.NoError(t, err)
+	return pkix.Extension{Id: asn1.ObjectIdentifier{2, 5, 29, 37}, Critical: true, Value: value}
+}
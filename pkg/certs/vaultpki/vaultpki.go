@@ -0,0 +1,223 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package vaultpki
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hdecarne-github/certd/internal/logging"
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/rs/zerolog"
+)
+
+// ProviderType is the type name used to define Vault PKI providers in the provider configuration.
+const ProviderType = "vault-pki"
+
+// ProviderPrefix is prepended to the provider name to build the CA name.
+const ProviderPrefix = "Vault:"
+
+// Config defines the Vault PKI secrets engine role to submit certificate requests to.
+type Config struct {
+	Address string        `yaml:"address"`
+	Token   string        `yaml:"token"`
+	Mount   string        `yaml:"mount"`
+	Role    string        `yaml:"role"`
+	TTL     time.Duration `yaml:"ttl"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func init() {
+	certs.RegisterProviderType(&certs.ProviderType{
+		Name: ProviderType,
+		NewConfig: func() any {
+			return &Config{
+				Mount:   "pki",
+				Timeout: 30 * time.Second,
+			}
+		},
+		New: func(name string, config any) (certs.CertificateProvider, error) {
+			return NewProvider(name, config.(*Config))
+		},
+	})
+}
+
+type vaultProvider struct {
+	name   string
+	config *Config
+	client *http.Client
+}
+
+// Create a provider submitting certificate requests to the given Vault PKI role.
+func NewProvider(name string, config *Config) (certs.CertificateProvider, error) {
+	if config.Address == "" {
+		return nil, fmt.Errorf("missing Vault address for provider '%s'", name)
+	}
+	if config.Role == "" {
+		return nil, fmt.Errorf("missing Vault PKI role for provider '%s'", name)
+	}
+	return &vaultProvider{
+		name:   name,
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}, nil
+}
+
+func (provider *vaultProvider) Name() string {
+	return provider.name
+}
+
+func (provider *vaultProvider) CAs() []string {
+	return []string{ProviderPrefix + provider.name}
+}
+
+func (provider *vaultProvider) NewCertificateFactory(ctx context.Context, ca string, request *certs.ProviderRequest) (certs.CertificateFactory, error) {
+	if ca != ProviderPrefix+provider.name {
+		return nil, fmt.Errorf("unrecognized Vault CA '%s'", ca)
+	}
+	ttl := provider.config.TTL
+	if request.Params["ttl"] != "" {
+		parsed, err := time.ParseDuration(request.Params["ttl"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid TTL '%s' (cause: %w)", request.Params["ttl"], err)
+		}
+		ttl = parsed
+	}
+	logger := logging.RootLogger().With().Str("Provider", ca).Logger()
+	return &VaultCertificateFactory{
+		provider: provider,
+		name:     ca,
+		request:  request,
+		ttl:      ttl,
+		logger:   &logger,
+	}, nil
+}
+
+type VaultCertificateFactory struct {
+	provider *vaultProvider
+	name     string
+	request  *certs.ProviderRequest
+	ttl      time.Duration
+	chain    []*x509.Certificate
+	logger   *zerolog.Logger
+}
+
+func (factory *VaultCertificateFactory) Name() string {
+	return factory.name
+}
+
+func (factory *VaultCertificateFactory) Chain() []*x509.Certificate {
+	return factory.chain
+}
+
+type signRequest struct {
+	CSR        string `json:"csr"`
+	CommonName string `json:"common_name"`
+	AltNames   string `json:"alt_names,omitempty"`
+	TTL        string `json:"ttl,omitempty"`
+	Format     string `json:"format"`
+}
+
+type signResponse struct {
+	Data   signResponseData `json:"data"`
+	Errors []string         `json:"errors"`
+}
+
+type signResponseData struct {
+	Certificate string   `json:"certificate"`
+	IssuingCA   string   `json:"issuing_ca"`
+	CAChain     []string `json:"ca_chain"`
+}
+
+func (factory *VaultCertificateFactory) New(ctx context.Context) (crypto.PrivateKey, *x509.Certificate, error) {
+	key, certificateRequest, err := factory.request.NewCertificateRequest(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	factory.logger.Info().Msgf("Submitting certificate request '%s' to Vault PKI role '%s'...", certificateRequest.Subject, factory.provider.config.Role)
+	sign := &signRequest{
+		CSR:        string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: certificateRequest.Raw})),
+		CommonName: certificateRequest.Subject.CommonName,
+		AltNames:   strings.Join(certificateRequest.DNSNames, ","),
+		Format:     "pem",
+	}
+	if factory.ttl > 0 {
+		sign.TTL = factory.ttl.String()
+	}
+	response, err := factory.provider.sign(ctx, sign)
+	if err != nil {
+		return nil, nil, err
+	}
+	certificates, err := certs.DecodeCertificates([]byte(response.Data.Certificate))
+	if err != nil || len(certificates) == 0 {
+		return nil, nil, fmt.Errorf("failed to decode issued certificate (cause: %v)", err)
+	}
+	chainPEM := response.Data.CAChain
+	if len(chainPEM) == 0 && response.Data.IssuingCA != "" {
+		chainPEM = []string{response.Data.IssuingCA}
+	}
+	chain := make([]*x509.Certificate, 0, len(chainPEM))
+	for _, issuerPEM := range chainPEM {
+		issuers, err := certs.DecodeCertificates([]byte(issuerPEM))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode CA chain (cause: %w)", err)
+		}
+		chain = append(chain, issuers...)
+	}
+	factory.chain = chain
+	return key, certificates[0], nil
+}
+
+func (provider *vaultProvider) sign(ctx context.Context, sign *signRequest) (*signResponse, error) {
+	body, err := json.Marshal(sign)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal sign request (cause: %w)", err)
+	}
+	url := fmt.Sprintf("%s/v1/%s/sign/%s", strings.TrimSuffix(provider.config.Address, "/"), strings.Trim(provider.config.Mount, "/"), provider.config.Role)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare sign request '%s' (cause: %w)", url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", provider.config.Token)
+	resp, err := provider.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send sign request '%s' (cause: %w)", url, err)
+	}
+	defer resp.Body.Close()
+	response := &signResponse{}
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if resp.StatusCode != http.StatusOK {
+		if err == nil && len(response.Errors) > 0 {
+			return nil, fmt.Errorf("sign request '%s' failed (status: %s; errors: %s)", url, resp.Status, strings.Join(response.Errors, "; "))
+		}
+		return nil, fmt.Errorf("sign request '%s' failed (status: %s)", url, resp.Status)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode sign response '%s' (cause: %w)", url, err)
+	}
+	return response, nil
+}
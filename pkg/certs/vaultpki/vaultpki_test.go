@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package vaultpki
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/certs"
+	"github.com/hdecarne-github/certd/pkg/keys/registry"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "test-token"
+
+func TestVaultCertificateFactory(t *testing.T) {
+	vault := newTestVault(t)
+	defer vault.Close()
+	provider, err := certs.NewProvider(ProviderType, "Test", func(config any) error {
+		vaultConfig := config.(*Config)
+		vaultConfig.Address = vault.URL
+		vaultConfig.Token = testToken
+		vaultConfig.Role = "server"
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Vault:Test"}, provider.CAs())
+	_, err = provider.NewCertificateFactory(context.Background(), "Vault:Other", &certs.ProviderRequest{})
+	require.Error(t, err)
+	request := &certs.ProviderRequest{
+		Domains:    []string{"www.example.org", "example.org"},
+		KeyFactory: registry.StandardKey("ECDSA P-256"),
+		Params:     map[string]string{"ttl": "24h"},
+	}
+	factory, err := provider.NewCertificateFactory(context.Background(), "Vault:Test", request)
+	require.NoError(t, err)
+	key, certificate, err := factory.New(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, key)
+	require.Equal(t, "www.example.org", certificate.Subject.CommonName)
+	require.Equal(t, []string{"www.example.org", "example.org"}, certificate.DNSNames)
+	require.Equal(t, 24*time.Hour, certificate.NotAfter.Sub(certificate.NotBefore))
+	chain := factory.(certs.CertificateChainFactory).Chain()
+	require.Equal(t, 1, len(chain))
+	require.NoError(t, certificate.CheckSignatureFrom(chain[0]))
+	invalidProvider, err := NewProvider("Invalid", &Config{Address: vault.URL, Token: "invalid", Mount: "pki", Role: "server"})
+	require.NoError(t, err)
+	factory, err = invalidProvider.NewCertificateFactory(context.Background(), "Vault:Invalid", request)
+	require.NoError(t, err)
+	_, _, err = factory.New(context.Background())
+	require.ErrorContains(t, err, "permission denied")
+}
+
+func TestNewProviderInvalidConfig(t *testing.T) {
+	_, err := NewProvider("Test", &Config{Role: "server"})
+	require.Error(t, err)
+	_, err = NewProvider("Test", &Config{Address: "https://vault.example.org:8200"})
+	require.Error(t, err)
+}
+
+func newTestVault(t *testing.T) *httptest.Server {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Vault Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caBytes)
+	require.NoError(t, err)
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caBytes}))
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != testToken {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(&signResponse{Errors: []string{"permission denied"}})
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/pki/sign/server" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sign := &signRequest{}
+		err := json.NewDecoder(r.Body).Decode(sign)
+		require.NoError(t, err)
+		csrBlock, _ := pem.Decode([]byte(sign.CSR))
+		require.NotNil(t, csrBlock)
+		csr, err := x509.ParseCertificateRequest(csrBlock.Bytes)
+		require.NoError(t, err)
+		ttl, err := time.ParseDuration(sign.TTL)
+		require.NoError(t, err)
+		notBefore := time.Now().Truncate(time.Second)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: sign.CommonName},
+			DNSNames:     csr.DNSNames,
+			NotBefore:    notBefore,
+			NotAfter:     notBefore.Add(ttl),
+		}
+		certificateBytes, err := x509.CreateCertificate(rand.Reader, template, ca, csr.PublicKey, caKey)
+		require.NoError(t, err)
+		response := &signResponse{
+			Data: signResponseData{
+				Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateBytes})),
+				IssuingCA:   caPEM,
+				CAChain:     []string{caPEM},
+			},
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+}
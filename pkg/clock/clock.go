@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// The clock backed by the system time.
+var System Clock = systemClock{}
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (clock *fixedClock) Now() time.Time {
+	return clock.now
+}
+
+// Create a clock always returning the given time.
+func Fixed(now time.Time) Clock {
+	return &fixedClock{now: now}
+}
+
+var currentLock sync.RWMutex
+var current = System
+
+// Get the current time of the active clock.
+func Now() time.Time {
+	return Current().Now()
+}
+
+// Get the active clock.
+func Current() Clock {
+	currentLock.RLock()
+	defer currentLock.RUnlock()
+	return current
+}
+
+// Replace the active clock (e.g. by a fixed clock for reproducible testing).
+func Set(clock Clock) {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+	current = clock
+}
+
+// Restore the system clock.
+func Reset() {
+	Set(System)
+}
+
+type clockKey struct{}
+
+// Bind the given clock to the context.
+//
+// Work bound to the returned context uses the given clock instead of the active one (see FromContext).
+func WithClock(ctx context.Context, clock Clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, clock)
+}
+
+// Get the clock bound to the given context (the active clock if none is bound).
+func FromContext(ctx context.Context) Clock {
+	clock, ok := ctx.Value(clockKey{}).(Clock)
+	if !ok {
+		return Current()
+	}
+	return clock
+}
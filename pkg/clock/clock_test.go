@@ -0,0 +1,42 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClock(t *testing.T) {
+	defer Reset()
+	fixed := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	Set(Fixed(fixed))
+	require.Equal(t, fixed, Now())
+	Reset()
+	require.WithinDuration(t, time.Now(), Now(), time.Second)
+}
+
+func TestContextClock(t *testing.T) {
+	fixed := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx := WithClock(context.Background(), Fixed(fixed))
+	require.Equal(t, fixed, FromContext(ctx).Now())
+	require.Equal(t, System, FromContext(context.Background()))
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entropy
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"math/big"
+	"sync"
+)
+
+var sourceLock sync.RWMutex
+var source io.Reader = rand.Reader
+var deterministic bool
+
+// Get the random source to use for key generation, serial numbers and signatures.
+func Reader() io.Reader {
+	sourceLock.RLock()
+	defer sourceLock.RUnlock()
+	return source
+}
+
+type readerKey struct{}
+
+// Bind the given random source to the context.
+//
+// Work bound to the returned context draws its randomness from the given reader instead of the process wide random
+// source (e.g. to run tests with different seeds in parallel).
+func WithReader(ctx context.Context, reader io.Reader) context.Context {
+	return context.WithValue(ctx, readerKey{}, reader)
+}
+
+func boundReader(ctx context.Context) io.Reader {
+	reader, ok := ctx.Value(readerKey{}).(io.Reader)
+	if !ok {
+		return Reader()
+	}
+	return reader
+}
+
+// Get the random source to use for work bound to the given context.
+//
+// This is the random source bound via WithReader or the process wide one. Reads fail with the context's error as
+// soon as the context is done. Key generations drawing their randomness from this reader are therefore aborted,
+// once their context is cancelled.
+func ContextReader(ctx context.Context) io.Reader {
+	return &contextReader{ctx: ctx, source: boundReader(ctx)}
+}
+
+type contextReader struct {
+	ctx    context.Context
+	source io.Reader
+}
+
+func (reader *contextReader) Read(p []byte) (int, error) {
+	err := reader.ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+	return reader.source.Read(p)
+}
+
+// Check whether the deterministic random source is active.
+func Deterministic() bool {
+	sourceLock.RLock()
+	defer sourceLock.RUnlock()
+	return deterministic
+}
+
+// Check whether the random source used for work bound to the given context is deterministic.
+func DeterministicContext(ctx context.Context) bool {
+	reader, ok := ctx.Value(readerKey{}).(io.Reader)
+	if !ok {
+		return Deterministic()
+	}
+	_, deterministic := reader.(*deterministicReader)
+	return deterministic
+}
+
+// Replace the random source with a deterministic one derived from the given seed.
+//
+// This renders all generated keys predictable and must only be used for testing.
+func Seed(seed string) {
+	sourceLock.Lock()
+	defer sourceLock.Unlock()
+	source = NewDeterministicReader([]byte(seed))
+	deterministic = true
+}
+
+// Restore the system random source.
+func Reset() {
+	sourceLock.Lock()
+	defer sourceLock.Unlock()
+	source = rand.Reader
+	deterministic = false
+}
+
+// Create a reader returning the same byte stream for the same seed.
+func NewDeterministicReader(seed []byte) io.Reader {
+	key := sha256.Sum256(seed)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	iv := make([]byte, block.BlockSize())
+	return &deterministicReader{stream: cipher.NewCTR(block, iv)}
+}
+
+type deterministicReader struct {
+	lock   sync.Mutex
+	stream cipher.Stream
+}
+
+func (reader *deterministicReader) Read(p []byte) (int, error) {
+	reader.lock.Lock()
+	defer reader.lock.Unlock()
+	for i := range p {
+		p[i] = 0
+	}
+	reader.stream.XORKeyStream(p, p)
+	return len(p), nil
+}
+
+var serialNumberLimit = new(big.Int).Lsh(big.NewInt(1), 128)
+
+// Generate a random 128 bit serial number using the random source of the given context.
+func SerialNumber(ctx context.Context) (*big.Int, error) {
+	return rand.Int(ContextReader(ctx), serialNumberLimit)
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package entropy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeterministicReader(t *testing.T) {
+	bytes1 := make([]byte, 64)
+	_, err := io.ReadFull(NewDeterministicReader([]byte("seed")), bytes1)
+	require.NoError(t, err)
+	bytes2 := make([]byte, 64)
+	_, err = io.ReadFull(NewDeterministicReader([]byte("seed")), bytes2)
+	require.NoError(t, err)
+	require.Equal(t, bytes1, bytes2)
+	bytes3 := make([]byte, 64)
+	_, err = io.ReadFull(NewDeterministicReader([]byte("other seed")), bytes3)
+	require.NoError(t, err)
+	require.False(t, bytes.Equal(bytes1, bytes3))
+}
+
+func TestSeed(t *testing.T) {
+	defer Reset()
+	require.False(t, Deterministic())
+	Seed("seed")
+	require.True(t, Deterministic())
+	serial1, err := SerialNumber(context.Background())
+	require.NoError(t, err)
+	Seed("seed")
+	serial2, err := SerialNumber(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, serial1, serial2)
+	Reset()
+	require.False(t, Deterministic())
+}
+
+func TestContextReader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := ContextReader(ctx)
+	_, err := io.ReadFull(reader, make([]byte, 64))
+	require.NoError(t, err)
+	cancel()
+	_, err = io.ReadFull(reader, make([]byte, 64))
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestWithReader(t *testing.T) {
+	require.False(t, DeterministicContext(context.Background()))
+	ctx1 := WithReader(context.Background(), NewDeterministicReader([]byte("seed")))
+	ctx2 := WithReader(context.Background(), NewDeterministicReader([]byte("seed")))
+	require.True(t, DeterministicContext(ctx1))
+	serial1, err := SerialNumber(ctx1)
+	require.NoError(t, err)
+	serial2, err := SerialNumber(ctx2)
+	require.NoError(t, err)
+	require.Equal(t, serial1, serial2)
+	require.False(t, Deterministic())
+}
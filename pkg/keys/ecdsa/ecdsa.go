@@ -18,10 +18,10 @@
 package ecdsa
 
 import (
+	"context"
 	"crypto"
 	algorithm "crypto/ecdsa"
 	"crypto/elliptic"
-	"crypto/rand"
 
 	"github.com/hdecarne-github/certd/pkg/keys"
 )
@@ -32,8 +32,8 @@ type ECDSAKeyPair struct {
 	key *algorithm.PrivateKey
 }
 
-func NewECDSAKeyPair(curve elliptic.Curve) (keys.KeyPair, error) {
-	key, err := algorithm.GenerateKey(curve, rand.Reader)
+func NewECDSAKeyPair(ctx context.Context, curve elliptic.Curve) (keys.KeyPair, error) {
+	key, err := generateKey(ctx, curve)
 	if err != nil {
 		return nil, err
 	}
@@ -60,8 +60,13 @@ func (factory *ECDSAKeyPairFactory) Name() string {
 	return ProviderName + " " + factory.curve.Params().Name
 }
 
-func (factory *ECDSAKeyPairFactory) New() (keys.KeyPair, error) {
-	return NewECDSAKeyPair(factory.curve)
+// Get the curve of the generated keys.
+func (factory *ECDSAKeyPairFactory) Curve() elliptic.Curve {
+	return factory.curve
+}
+
+func (factory *ECDSAKeyPairFactory) New(ctx context.Context) (keys.KeyPair, error) {
+	return NewECDSAKeyPair(ctx, factory.curve)
 }
 
 func StandardKeys() []keys.KeyPairFactory {
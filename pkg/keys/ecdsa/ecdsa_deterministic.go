@@ -0,0 +1,56 @@
+//go:build deterministic
+
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ecdsa
+
+import (
+	"context"
+	algorithm "crypto/ecdsa"
+	"crypto/elliptic"
+	"io"
+	"math/big"
+
+	"github.com/hdecarne-github/certd/pkg/entropy"
+)
+
+func generateKey(ctx context.Context, curve elliptic.Curve) (*algorithm.PrivateKey, error) {
+	if entropy.DeterministicContext(ctx) {
+		return generateDeterministicKey(curve, entropy.ContextReader(ctx))
+	}
+	return algorithm.GenerateKey(curve, entropy.ContextReader(ctx))
+}
+
+// The standard key generation deliberately consumes a random amount of
+// entropy, hence we derive the key ourselves in deterministic mode.
+func generateDeterministicKey(curve elliptic.Curve, random io.Reader) (*algorithm.PrivateKey, error) {
+	params := curve.Params()
+	seed := make([]byte, (params.BitSize+7)/8+8)
+	_, err := io.ReadFull(random, seed)
+	if err != nil {
+		return nil, err
+	}
+	n := new(big.Int).Sub(params.N, big.NewInt(1))
+	d := new(big.Int).SetBytes(seed)
+	d.Mod(d, n)
+	d.Add(d, big.NewInt(1))
+	key := &algorithm.PrivateKey{D: d}
+	key.Curve = curve
+	key.X, key.Y = curve.ScalarBaseMult(d.FillBytes(make([]byte, (params.BitSize+7)/8)))
+	return key, nil
+}
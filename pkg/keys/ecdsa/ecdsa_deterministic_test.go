@@ -0,0 +1,40 @@
+//go:build deterministic
+
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ecdsa
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hdecarne-github/certd/pkg/entropy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeterministicECDSAKeyPair(t *testing.T) {
+	defer entropy.Reset()
+	kpf := StandardKeys()[1]
+	entropy.Seed("seed")
+	keypair1, err := kpf.New(context.Background())
+	require.NoError(t, err)
+	entropy.Seed("seed")
+	keypair2, err := kpf.New(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, keypair1.Public(), keypair2.Public())
+}
@@ -0,0 +1,33 @@
+//go:build !deterministic
+
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ecdsa
+
+import (
+	"context"
+	algorithm "crypto/ecdsa"
+	"crypto/elliptic"
+
+	"github.com/hdecarne-github/certd/pkg/entropy"
+)
+
+// Deterministic key generation is only available in builds using the deterministic tag (for testing only).
+func generateKey(ctx context.Context, curve elliptic.Curve) (*algorithm.PrivateKey, error) {
+	return algorithm.GenerateKey(curve, entropy.ContextReader(ctx))
+}
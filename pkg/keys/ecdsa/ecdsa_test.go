@@ -18,6 +18,7 @@
 package ecdsa
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
@@ -30,7 +31,7 @@ func TestECDSAKeyPair(t *testing.T) {
 	for _, kpf := range kpfs {
 		fmt.Printf("Generating %s", kpf.Name())
 		start := time.Now()
-		keypair, err := kpf.New()
+		keypair, err := kpf.New(context.Background())
 		elapsed := time.Since(start)
 		fmt.Printf(" (took: %s)\n", elapsed)
 		require.NoError(t, err)
@@ -18,10 +18,11 @@
 package ed25519
 
 import (
+	"context"
 	"crypto"
 	algorithm "crypto/ed25519"
-	"crypto/rand"
 
+	"github.com/hdecarne-github/certd/pkg/entropy"
 	"github.com/hdecarne-github/certd/pkg/keys"
 )
 
@@ -32,8 +33,8 @@ type ED25519KeyPair struct {
 	private algorithm.PrivateKey
 }
 
-func NewED25519KeyPair() (keys.KeyPair, error) {
-	public, private, err := algorithm.GenerateKey(rand.Reader)
+func NewED25519KeyPair(ctx context.Context) (keys.KeyPair, error) {
+	public, private, err := algorithm.GenerateKey(entropy.ContextReader(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -58,8 +59,8 @@ func (factory *ED25519KeyPairFactory) Name() string {
 	return ProviderName
 }
 
-func (factory *ED25519KeyPairFactory) New() (keys.KeyPair, error) {
-	return NewED25519KeyPair()
+func (factory *ED25519KeyPairFactory) New(ctx context.Context) (keys.KeyPair, error) {
+	return NewED25519KeyPair(ctx)
 }
 
 func StandardKeys() []keys.KeyPairFactory {
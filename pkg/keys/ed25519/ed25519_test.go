@@ -18,10 +18,12 @@
 package ed25519
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
 
+	"github.com/hdecarne-github/certd/pkg/entropy"
 	"github.com/stretchr/testify/require"
 )
 
@@ -30,10 +32,22 @@ func TestED25519KeyPair(t *testing.T) {
 	for _, kpf := range kpfs {
 		fmt.Printf("Generating %s", kpf.Name())
 		start := time.Now()
-		keypair, err := kpf.New()
+		keypair, err := kpf.New(context.Background())
 		elapsed := time.Since(start)
 		fmt.Printf(" (took: %s)\n", elapsed)
 		require.NoError(t, err)
 		require.NotNil(t, keypair)
 	}
 }
+
+func TestDeterministicED25519KeyPair(t *testing.T) {
+	defer entropy.Reset()
+	kpf := NewED25519KeyPairFactory()
+	entropy.Seed("seed")
+	keypair1, err := kpf.New(context.Background())
+	require.NoError(t, err)
+	entropy.Seed("seed")
+	keypair2, err := kpf.New(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, keypair1.Public(), keypair2.Public())
+}
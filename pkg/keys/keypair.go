@@ -18,6 +18,7 @@
 package keys
 
 import (
+	"context"
 	"crypto"
 )
 
@@ -28,5 +29,52 @@ type KeyPair interface {
 
 type KeyPairFactory interface {
 	Name() string
-	New() (KeyPair, error)
+	// Generate a new key pair, aborting the generation as soon as the context is done.
+	New(ctx context.Context) (KeyPair, error)
+}
+
+// Limiter bounds the number of concurrent key generations.
+//
+// Acquire blocks until a generation may start or the context is done. The returned function releases the
+// acquired slot.
+type Limiter interface {
+	Acquire(ctx context.Context) (func(), error)
+}
+
+type limiterKey struct{}
+
+// Bind the limiter applied by NewKeyPair to the context.
+//
+// Key generations bound to a context without limiter are not limited.
+func WithLimiter(ctx context.Context, limiter Limiter) context.Context {
+	return context.WithValue(ctx, limiterKey{}, limiter)
+}
+
+func acquire(ctx context.Context) (func(), error) {
+	limiter, ok := ctx.Value(limiterKey{}).(Limiter)
+	if !ok || limiter == nil {
+		return func() {}, nil
+	}
+	return limiter.Acquire(ctx)
+}
+
+// Generate a new key pair using the given factory.
+//
+// The generation is subject to the limiter bound to the context via WithLimiter. If the context is done while waiting for the
+// limiter or during the generation, the generation is aborted and the context error is returned.
+func NewKeyPair(ctx context.Context, factory KeyPairFactory) (KeyPair, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+	release, err := acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	keyPair, err := factory.New(ctx)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return keyPair, err
 }
@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mldsa
+
+import (
+	"context"
+	"crypto"
+	algorithm "crypto/mldsa"
+	"fmt"
+	"io"
+
+	"github.com/hdecarne-github/certd/pkg/entropy"
+	"github.com/hdecarne-github/certd/pkg/keys"
+)
+
+// Experimental post-quantum ML-DSA (FIPS 204) keys.
+const ProviderName = "ML-DSA"
+
+type MLDSAKeyPair struct {
+	key *algorithm.PrivateKey
+}
+
+func NewMLDSAKeyPair(ctx context.Context, parameters algorithm.Parameters) (keys.KeyPair, error) {
+	seed := make([]byte, algorithm.PrivateKeySize)
+	_, err := io.ReadFull(entropy.ContextReader(ctx), seed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ML-DSA seed (cause: %w)", err)
+	}
+	key, err := algorithm.NewPrivateKey(parameters, seed)
+	if err != nil {
+		return nil, err
+	}
+	return &MLDSAKeyPair{key: key}, nil
+}
+
+func (keypair *MLDSAKeyPair) Public() crypto.PublicKey {
+	return keypair.key.Public()
+}
+
+func (keypair *MLDSAKeyPair) Private() crypto.PrivateKey {
+	return keypair.key
+}
+
+type MLDSAKeyPairFactory struct {
+	parameters algorithm.Parameters
+	name       string
+}
+
+func NewMLDSAKeyPairFactory(parameters algorithm.Parameters) keys.KeyPairFactory {
+	return &MLDSAKeyPairFactory{parameters: parameters, name: parameters.String()}
+}
+
+func (factory *MLDSAKeyPairFactory) Name() string {
+	return factory.name
+}
+
+func (factory *MLDSAKeyPairFactory) Parameters() algorithm.Parameters {
+	return factory.parameters
+}
+
+func (factory *MLDSAKeyPairFactory) New(ctx context.Context) (keys.KeyPair, error) {
+	return NewMLDSAKeyPair(ctx, factory.parameters)
+}
+
+func StandardKeys() []keys.KeyPairFactory {
+	return []keys.KeyPairFactory{
+		NewMLDSAKeyPairFactory(algorithm.MLDSA44()),
+		NewMLDSAKeyPairFactory(algorithm.MLDSA65()),
+		NewMLDSAKeyPairFactory(algorithm.MLDSA87()),
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mldsa
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hdecarne-github/certd/pkg/entropy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMLDSAKeyPair(t *testing.T) {
+	kpfs := StandardKeys()
+	for _, kpf := range kpfs {
+		fmt.Printf("Generating %s", kpf.Name())
+		start := time.Now()
+		keypair, err := kpf.New(context.Background())
+		elapsed := time.Since(start)
+		fmt.Printf(" (took: %s)\n", elapsed)
+		require.NoError(t, err)
+		require.NotNil(t, keypair)
+	}
+}
+
+func TestDeterministicMLDSAKeyPair(t *testing.T) {
+	defer entropy.Reset()
+	kpf := StandardKeys()[0]
+	entropy.Seed("seed")
+	keypair1, err := kpf.New(context.Background())
+	require.NoError(t, err)
+	entropy.Seed("seed")
+	keypair2, err := kpf.New(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, keypair1.Public(), keypair2.Public())
+}
+
+func TestMLDSASelfSigned(t *testing.T) {
+	keypair, err := StandardKeys()[1].New(context.Background())
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ML-DSA test root"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, template, keypair.Public(), keypair.Private())
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	require.NoError(t, err)
+	require.NoError(t, certificate.CheckSignatureFrom(certificate))
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(keypair.Private())
+	require.NoError(t, err)
+	key, err := x509.ParsePKCS8PrivateKey(keyBytes)
+	require.NoError(t, err)
+	require.Equal(t, keypair.Private(), key)
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package piv
+
+import (
+	"context"
+	"crypto"
+	"crypto/elliptic"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/hdecarne-github/certd/pkg/keys"
+	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/hdecarne-github/certd/pkg/keys/rsa"
+)
+
+const ProviderName = "PIV"
+
+// ErrNotSupported indicates a binary built without PIV support (see build tag piv).
+var ErrNotSupported = errors.New("PIV support not available (build with tag 'piv')")
+
+const (
+	AlgorithmEC256   = "EC256"
+	AlgorithmEC384   = "EC384"
+	AlgorithmRSA2048 = "RSA2048"
+)
+
+const (
+	PolicyDefault = ""
+	PolicyNever   = "never"
+	PolicyOnce    = "once"
+	PolicyAlways  = "always"
+	PolicyCached  = "cached"
+)
+
+// Config defines the PIV token and slot holding the key as well as the policies applied during key generation.
+type Config struct {
+	// Serial number of the token to use (0 selects the first token found)
+	Serial uint32
+	// Slot to use (e.g. "9c")
+	Slot string
+	// Key algorithm used during key generation
+	Algorithm string
+	// PIN policy applied during key generation (never, once, always)
+	PINPolicy string
+	// Touch policy applied during key generation (never, always, cached)
+	TouchPolicy string
+	// PIN used to access the key
+	PIN string
+	// Management key (hex encoded) required for key generation (token default if empty)
+	ManagementKey string
+}
+
+// Validate the configuration.
+func (config *Config) Validate() error {
+	_, err := config.slotKey()
+	if err != nil {
+		return err
+	}
+	switch config.Algorithm {
+	case AlgorithmEC256, AlgorithmEC384, AlgorithmRSA2048:
+	default:
+		return fmt.Errorf("unrecognized PIV algorithm '%s'", config.Algorithm)
+	}
+	switch config.PINPolicy {
+	case PolicyDefault, PolicyNever, PolicyOnce, PolicyAlways:
+	default:
+		return fmt.Errorf("unrecognized PIV PIN policy '%s'", config.PINPolicy)
+	}
+	switch config.TouchPolicy {
+	case PolicyDefault, PolicyNever, PolicyAlways, PolicyCached:
+	default:
+		return fmt.Errorf("unrecognized PIV touch policy '%s'", config.TouchPolicy)
+	}
+	_, err = config.managementKey()
+	return err
+}
+
+// Get the software key pair factory generating keys of the same type as the configured algorithm.
+//
+// Used to apply key policies to keys generated on the token.
+func (config *Config) EquivalentKeyPairFactory() (keys.KeyPairFactory, error) {
+	switch config.Algorithm {
+	case AlgorithmEC256:
+		return ecdsa.NewECDSAKeyPairFactory(elliptic.P256()), nil
+	case AlgorithmEC384:
+		return ecdsa.NewECDSAKeyPairFactory(elliptic.P384()), nil
+	case AlgorithmRSA2048:
+		return rsa.NewRSAKeyPairFactory(2048), nil
+	}
+	return nil, fmt.Errorf("unrecognized PIV algorithm '%s'", config.Algorithm)
+}
+
+// Get the slot's key reference (9a, 9c, 9d, 9e or one of the retired key management slots 82-95).
+func (config *Config) slotKey() (uint32, error) {
+	slotKey, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(config.Slot), "0x"), 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid PIV slot '%s' (cause: %w)", config.Slot, err)
+	}
+	switch {
+	case slotKey == 0x9a || slotKey == 0x9c || slotKey == 0x9d || slotKey == 0x9e:
+	case slotKey >= 0x82 && slotKey <= 0x95:
+	default:
+		return 0, fmt.Errorf("unsupported PIV slot '%s'", config.Slot)
+	}
+	return uint32(slotKey), nil
+}
+
+func (config *Config) managementKey() ([]byte, error) {
+	if config.ManagementKey == "" {
+		return nil, nil
+	}
+	managementKey, err := hex.DecodeString(config.ManagementKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PIV management key (cause: %w)", err)
+	}
+	if len(managementKey) != 24 {
+		return nil, fmt.Errorf("invalid PIV management key length %d", len(managementKey))
+	}
+	return managementKey, nil
+}
+
+// PIVKeyPairFactory generates keys on a PIV token.
+//
+// As the private key never leaves the token, the generated key pair only provides a crypto.Signer
+// accessing the token for each signing operation. Such keys can therefore not be persisted in a store.
+type PIVKeyPairFactory struct {
+	config *Config
+}
+
+func NewPIVKeyPairFactory(config *Config) keys.KeyPairFactory {
+	return &PIVKeyPairFactory{config: config}
+}
+
+func (factory *PIVKeyPairFactory) Name() string {
+	return ProviderName + " " + factory.config.Slot
+}
+
+// Generate a new key in the configured PIV slot.
+//
+// The generation on the token itself can not be interrupted; the context is only checked before it starts.
+func (factory *PIVKeyPairFactory) New(ctx context.Context) (keys.KeyPair, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+	err = factory.config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	public, err := generateKey(factory.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key in PIV slot '%s' (cause: %w)", factory.config.Slot, err)
+	}
+	return &PIVKeyPair{signer: &PIVSigner{config: factory.config, public: public}}, nil
+}
+
+type PIVKeyPair struct {
+	signer *PIVSigner
+}
+
+func (keypair *PIVKeyPair) Public() crypto.PublicKey {
+	return keypair.signer.Public()
+}
+
+func (keypair *PIVKeyPair) Private() crypto.PrivateKey {
+	return keypair.signer
+}
+
+// PIVSigner signs using the key stored in the configured PIV slot.
+type PIVSigner struct {
+	config *Config
+	public crypto.PublicKey
+}
+
+// Get the signer for the key with the given public key stored in the configured PIV slot.
+func NewPIVSigner(config *Config, public crypto.PublicKey) (*PIVSigner, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &PIVSigner{config: config, public: public}, nil
+}
+
+func (signer *PIVSigner) Public() crypto.PublicKey {
+	return signer.public
+}
+
+func (signer *PIVSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	signature, err := sign(signer.config, signer.public, rand, digest, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign using PIV slot '%s' (cause: %w)", signer.config.Slot, err)
+	}
+	return signature, nil
+}
@@ -0,0 +1,143 @@
+//go:build piv
+
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package piv
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-piv/piv-go/piv"
+)
+
+func generateKey(config *Config) (crypto.PublicKey, error) {
+	yk, err := openToken(config)
+	if err != nil {
+		return nil, err
+	}
+	defer yk.Close()
+	slot, err := tokenSlot(config)
+	if err != nil {
+		return nil, err
+	}
+	managementKey := piv.DefaultManagementKey
+	configManagementKey, err := config.managementKey()
+	if err != nil {
+		return nil, err
+	}
+	if configManagementKey != nil {
+		copy(managementKey[:], configManagementKey)
+	}
+	key := piv.Key{
+		Algorithm:   tokenAlgorithms[config.Algorithm],
+		PINPolicy:   tokenPINPolicies[config.PINPolicy],
+		TouchPolicy: tokenTouchPolicies[config.TouchPolicy],
+	}
+	return yk.GenerateKey(managementKey, slot, key)
+}
+
+func sign(config *Config, public crypto.PublicKey, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	yk, err := openToken(config)
+	if err != nil {
+		return nil, err
+	}
+	defer yk.Close()
+	slot, err := tokenSlot(config)
+	if err != nil {
+		return nil, err
+	}
+	private, err := yk.PrivateKey(slot, public, piv.KeyAuth{PIN: config.PIN})
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unexpected key type %T", private)
+	}
+	return signer.Sign(rand, digest, opts)
+}
+
+var tokenAlgorithms = map[string]piv.Algorithm{
+	AlgorithmEC256:   piv.AlgorithmEC256,
+	AlgorithmEC384:   piv.AlgorithmEC384,
+	AlgorithmRSA2048: piv.AlgorithmRSA2048,
+}
+
+var tokenPINPolicies = map[string]piv.PINPolicy{
+	PolicyDefault: piv.PINPolicyAlways,
+	PolicyNever:   piv.PINPolicyNever,
+	PolicyOnce:    piv.PINPolicyOnce,
+	PolicyAlways:  piv.PINPolicyAlways,
+}
+
+var tokenTouchPolicies = map[string]piv.TouchPolicy{
+	PolicyDefault: piv.TouchPolicyAlways,
+	PolicyNever:   piv.TouchPolicyNever,
+	PolicyAlways:  piv.TouchPolicyAlways,
+	PolicyCached:  piv.TouchPolicyCached,
+}
+
+func openToken(config *Config) (*piv.YubiKey, error) {
+	cards, err := piv.Cards()
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate PIV tokens (cause: %w)", err)
+	}
+	for _, card := range cards {
+		if !strings.Contains(strings.ToLower(card), "yubikey") {
+			continue
+		}
+		yk, err := piv.Open(card)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open PIV token '%s' (cause: %w)", card, err)
+		}
+		if config.Serial == 0 {
+			return yk, nil
+		}
+		serial, err := yk.Serial()
+		if err == nil && serial == config.Serial {
+			return yk, nil
+		}
+		yk.Close()
+	}
+	return nil, fmt.Errorf("no matching PIV token found (serial: %d)", config.Serial)
+}
+
+func tokenSlot(config *Config) (piv.Slot, error) {
+	slotKey, err := config.slotKey()
+	if err != nil {
+		return piv.Slot{}, err
+	}
+	switch slotKey {
+	case 0x9a:
+		return piv.SlotAuthentication, nil
+	case 0x9c:
+		return piv.SlotSignature, nil
+	case 0x9d:
+		return piv.SlotKeyManagement, nil
+	case 0x9e:
+		return piv.SlotCardAuthentication, nil
+	}
+	slot, ok := piv.RetiredKeyManagementSlot(slotKey)
+	if !ok {
+		return piv.Slot{}, fmt.Errorf("unsupported PIV slot '%s'", config.Slot)
+	}
+	return slot, nil
+}
@@ -0,0 +1,39 @@
+//go:build !piv
+
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package piv
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotSupported(t *testing.T) {
+	config := &Config{Slot: "9c", Algorithm: AlgorithmEC256}
+	_, err := NewPIVKeyPairFactory(config).New(context.Background())
+	require.ErrorIs(t, err, ErrNotSupported)
+	signer, err := NewPIVSigner(config, nil)
+	require.NoError(t, err)
+	_, err = signer.Sign(rand.Reader, make([]byte, 32), crypto.SHA256)
+	require.ErrorIs(t, err, ErrNotSupported)
+}
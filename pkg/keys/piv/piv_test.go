@@ -0,0 +1,56 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package piv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate(t *testing.T) {
+	config := &Config{Slot: "9c", Algorithm: AlgorithmEC256}
+	require.NoError(t, config.Validate())
+	config.Slot = "0x82"
+	require.NoError(t, config.Validate())
+	config.Slot = "9b"
+	require.Error(t, config.Validate())
+	config.Slot = "9c"
+	config.Algorithm = "EC521"
+	require.Error(t, config.Validate())
+	config.Algorithm = AlgorithmRSA2048
+	config.PINPolicy = PolicyCached
+	require.Error(t, config.Validate())
+	config.PINPolicy = PolicyOnce
+	config.TouchPolicy = PolicyCached
+	require.NoError(t, config.Validate())
+	config.ManagementKey = "0102"
+	require.Error(t, config.Validate())
+	config.ManagementKey = "010203040506070801020304050607080102030405060708"
+	require.NoError(t, config.Validate())
+}
+
+func TestEquivalentKeyPairFactory(t *testing.T) {
+	for algorithm, keyType := range map[string]string{AlgorithmEC256: "ECDSA P-256", AlgorithmEC384: "ECDSA P-384", AlgorithmRSA2048: "RSA 2048"} {
+		factory, err := (&Config{Algorithm: algorithm}).EquivalentKeyPairFactory()
+		require.NoError(t, err)
+		require.Equal(t, keyType, factory.Name())
+	}
+	_, err := (&Config{Algorithm: "EC521"}).EquivalentKeyPairFactory()
+	require.Error(t, err)
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package registry
+
+import (
+	"crypto"
+	cryptomldsa "crypto/mldsa"
+
+	"github.com/hdecarne-github/certd/pkg/keys/mldsa"
+)
+
+// Experimental key types are looked up like standard keys, but are not listed by any key provider and have to be
+// enabled explicitly via the key policy.
+func init() {
+	for _, key := range mldsa.StandardKeys() {
+		standardKeys[key.Name()] = key
+		experimentalKeys[key.Name()] = true
+	}
+	experimentalPublicKeyNames = append(experimentalPublicKeyNames, mldsaPublicKeyName)
+}
+
+func mldsaPublicKeyName(publicKey crypto.PublicKey) string {
+	key, ok := publicKey.(*cryptomldsa.PublicKey)
+	if !ok {
+		return ""
+	}
+	return key.Parameters().String()
+}
@@ -0,0 +1,40 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExperimentalPolicy(t *testing.T) {
+	require.True(t, IsExperimental("ML-DSA-65"))
+	require.False(t, IsExperimental("ED25519"))
+	kpf := StandardKey("ML-DSA-65")
+	require.NotNil(t, kpf)
+	requirePolicyViolation(t, (&Policy{}).Check(kpf, false))
+	require.NoError(t, (&Policy{Experimental: true}).Check(kpf, false))
+	requirePolicyViolation(t, (&Policy{Experimental: true}).Check(kpf, true))
+	requirePolicyViolation(t, (&Policy{Experimental: true, FIPS: true}).Check(kpf, false))
+	for _, providerName := range KeyProviders() {
+		for _, standardKey := range StandardKeys(providerName) {
+			require.False(t, IsExperimental(standardKey.Name()))
+		}
+	}
+}
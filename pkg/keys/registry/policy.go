@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package registry
+
+import (
+	"crypto"
+	cryptoecdsa "crypto/ecdsa"
+	cryptorsa "crypto/rsa"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/hdecarne-github/certd/pkg/keys"
+	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/hdecarne-github/certd/pkg/keys/ed25519"
+	"github.com/hdecarne-github/certd/pkg/keys/rsa"
+)
+
+// Key policy restricting the key types which may be requested.
+type Policy struct {
+	// Minimum RSA key size (in bits; unrestricted if 0)
+	MinRSABits int
+	// Allowed ECDSA curves (e.g. "P-256"; all if empty)
+	AllowedCurves []string
+	// Key types not supported by ACME CAs (e.g. "ED25519")
+	ACMEForbidden []string
+	// Restrict keys and signature algorithms to the FIPS-approved subset (always set in builds tagged "fips")
+	FIPS bool
+	// Enable experimental key types (e.g. post-quantum ML-DSA keys; not available for ACME CAs and in FIPS mode)
+	Experimental bool
+}
+
+// Minimum RSA key size and ECDSA curves approved in FIPS mode.
+const fipsMinRSABits = 2048
+
+var fipsCurves = []string{"P-256", "P-384", "P-521"}
+
+const reasonNotFIPSApproved = "not FIPS approved"
+
+// Error returned in case a key type violates the key policy.
+type PolicyViolationError struct {
+	KeyType string
+	Reason  string
+}
+
+func (err *PolicyViolationError) Error() string {
+	return fmt.Sprintf("key type '%s' violates key policy (%s)", err.KeyType, err.Reason)
+}
+
+// Check whether the given key factory complies with the key policy.
+//
+// Set acme to true, if the key is requested for an ACME CA. A nil policy accepts all key types (unless FIPS mode is
+// enforced by the build).
+func (policy *Policy) Check(factory keys.KeyPairFactory, acme bool) error {
+	if policy == nil {
+		if !fipsBuild {
+			return nil
+		}
+		policy = &Policy{}
+	}
+	keyType := factory.Name()
+	if acme && (contains(policy.ACMEForbidden, keyType) || IsExperimental(keyType)) {
+		return &PolicyViolationError{KeyType: keyType, Reason: "not supported by ACME CAs"}
+	}
+	if IsExperimental(keyType) && (!policy.Experimental || policy.fipsMode()) {
+		return &PolicyViolationError{KeyType: keyType, Reason: "experimental key type not enabled"}
+	}
+	switch factory := factory.(type) {
+	case *rsa.RSAKeyPairFactory:
+		return policy.checkRSA(keyType, factory.Bits())
+	case *ecdsa.ECDSAKeyPairFactory:
+		return policy.checkECDSA(keyType, factory.Curve().Params().Name)
+	case *ed25519.ED25519KeyPairFactory:
+		if policy.fipsMode() {
+			return &PolicyViolationError{KeyType: keyType, Reason: reasonNotFIPSApproved}
+		}
+	}
+	return nil
+}
+
+// Check whether the given public key complies with the key policy.
+//
+// Used to validate externally generated keys (e.g. uploaded public keys or certificate requests). In contrast to Check
+// any RSA key size is recognized. A nil policy accepts all keys (unless FIPS mode is enforced by the build).
+func (policy *Policy) CheckPublicKey(publicKey crypto.PublicKey) error {
+	if policy == nil {
+		if !fipsBuild {
+			return nil
+		}
+		policy = &Policy{}
+	}
+	switch publicKey := publicKey.(type) {
+	case *cryptorsa.PublicKey:
+		return policy.checkRSA(fmt.Sprintf("RSA %d", publicKey.N.BitLen()), publicKey.N.BitLen())
+	case *cryptoecdsa.PublicKey:
+		return policy.checkECDSA("ECDSA "+publicKey.Curve.Params().Name, publicKey.Curve.Params().Name)
+	}
+	factory, err := FactoryForPublicKey(publicKey)
+	if err != nil {
+		return &PolicyViolationError{KeyType: fmt.Sprintf("%T", publicKey), Reason: "unrecognized key type"}
+	}
+	return policy.Check(factory, false)
+}
+
+// Check whether the given certificate's key and signature algorithm comply with the FIPS mode restrictions.
+//
+// Used to validate existing certificates (e.g. issuers). Only applies if FIPS mode is active.
+func (policy *Policy) CheckCertificate(certificate *x509.Certificate) error {
+	if !policy.fipsMode() {
+		return nil
+	}
+	switch certificate.SignatureAlgorithm {
+	case x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+		x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS,
+		x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512:
+	default:
+		return &PolicyViolationError{KeyType: certificate.SignatureAlgorithm.String(), Reason: reasonNotFIPSApproved}
+	}
+	switch publicKey := certificate.PublicKey.(type) {
+	case *cryptorsa.PublicKey:
+		return policy.checkRSA(fmt.Sprintf("RSA %d", publicKey.N.BitLen()), publicKey.N.BitLen())
+	case *cryptoecdsa.PublicKey:
+		return policy.checkECDSA("ECDSA "+publicKey.Curve.Params().Name, publicKey.Curve.Params().Name)
+	}
+	return &PolicyViolationError{KeyType: certificate.PublicKeyAlgorithm.String(), Reason: reasonNotFIPSApproved}
+}
+
+// Get the standard keys of the given key provider complying with the key policy.
+func (policy *Policy) PermittedKeys(providerName string) []keys.KeyPairFactory {
+	permitted := make([]keys.KeyPairFactory, 0)
+	for _, factory := range StandardKeys(providerName) {
+		if policy.Check(factory, false) == nil {
+			permitted = append(permitted, factory)
+		}
+	}
+	return permitted
+}
+
+func (policy *Policy) fipsMode() bool {
+	return fipsBuild || (policy != nil && policy.FIPS)
+}
+
+func (policy *Policy) checkRSA(keyType string, bits int) error {
+	if bits < policy.MinRSABits {
+		return &PolicyViolationError{KeyType: keyType, Reason: fmt.Sprintf("RSA keys require at least %d bits", policy.MinRSABits)}
+	}
+	if policy.fipsMode() && bits < fipsMinRSABits {
+		return &PolicyViolationError{KeyType: keyType, Reason: fmt.Sprintf("%s (RSA keys require at least %d bits)", reasonNotFIPSApproved, fipsMinRSABits)}
+	}
+	return nil
+}
+
+func (policy *Policy) checkECDSA(keyType string, curve string) error {
+	if len(policy.AllowedCurves) > 0 && !contains(policy.AllowedCurves, curve) {
+		return &PolicyViolationError{KeyType: keyType, Reason: "curve not allowed"}
+	}
+	if policy.fipsMode() && !contains(fipsCurves, curve) {
+		return &PolicyViolationError{KeyType: keyType, Reason: fmt.Sprintf("%s (curve %s)", reasonNotFIPSApproved, curve)}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package registry
+
+import (
+	cryptoecdsa "crypto/ecdsa"
+	cryptoed25519 "crypto/ed25519"
+	"crypto/elliptic"
+	cryptorsa "crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
+	"github.com/hdecarne-github/certd/pkg/keys/ed25519"
+	"github.com/hdecarne-github/certd/pkg/keys/rsa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	policy := &Policy{
+		MinRSABits:    3072,
+		AllowedCurves: []string{"P-256", "P-384"},
+		ACMEForbidden: []string{"ED25519"},
+	}
+	require.NoError(t, policy.Check(StandardKey("RSA 3072"), false))
+	require.NoError(t, policy.Check(StandardKey("ECDSA P-384"), true))
+	require.NoError(t, policy.Check(StandardKey("ED25519"), false))
+	requirePolicyViolation(t, policy.Check(StandardKey("RSA 2048"), false))
+	requirePolicyViolation(t, policy.Check(rsa.NewRSAKeyPairFactory(1024), false))
+	requirePolicyViolation(t, policy.Check(StandardKey("ECDSA P-521"), false))
+	requirePolicyViolation(t, policy.Check(ecdsa.NewECDSAKeyPairFactory(elliptic.P224()), false))
+	requirePolicyViolation(t, policy.Check(StandardKey("ED25519"), true))
+	var nilPolicy *Policy
+	require.NoError(t, nilPolicy.Check(rsa.NewRSAKeyPairFactory(1024), true))
+}
+
+func TestFIPSPolicy(t *testing.T) {
+	policy := &Policy{FIPS: true}
+	require.NoError(t, policy.Check(StandardKey("RSA 2048"), false))
+	require.NoError(t, policy.Check(StandardKey("ECDSA P-256"), false))
+	require.NoError(t, policy.Check(StandardKey("ECDSA P-521"), false))
+	requirePolicyViolation(t, policy.Check(StandardKey("ED25519"), false))
+	requirePolicyViolation(t, policy.Check(StandardKey("ECDSA P-224"), false))
+	requirePolicyViolation(t, policy.Check(rsa.NewRSAKeyPairFactory(1024), false))
+	require.Empty(t, policy.PermittedKeys(ed25519.ProviderName))
+	require.Equal(t, 3, len(policy.PermittedKeys(ecdsa.ProviderName)))
+	require.Equal(t, 3, len(policy.PermittedKeys(rsa.ProviderName)))
+	ecdsaCertificate := &x509.Certificate{SignatureAlgorithm: x509.ECDSAWithSHA256, PublicKeyAlgorithm: x509.ECDSA, PublicKey: &cryptoecdsa.PublicKey{Curve: elliptic.P384()}}
+	require.NoError(t, policy.CheckCertificate(ecdsaCertificate))
+	sha1Certificate := &x509.Certificate{SignatureAlgorithm: x509.SHA1WithRSA, PublicKeyAlgorithm: x509.ECDSA, PublicKey: &cryptoecdsa.PublicKey{Curve: elliptic.P384()}}
+	requirePolicyViolation(t, policy.CheckCertificate(sha1Certificate))
+	ed25519Certificate := &x509.Certificate{SignatureAlgorithm: x509.PureEd25519, PublicKeyAlgorithm: x509.Ed25519, PublicKey: cryptoed25519.PublicKey{}}
+	requirePolicyViolation(t, policy.CheckCertificate(ed25519Certificate))
+	require.NoError(t, (&Policy{}).CheckCertificate(sha1Certificate))
+}
+
+func TestPublicKeyPolicy(t *testing.T) {
+	policy := &Policy{MinRSABits: 2048}
+	weakRSAKey := &cryptorsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), 1023), E: 65537}
+	requirePolicyViolation(t, policy.CheckPublicKey(weakRSAKey))
+	require.NoError(t, policy.CheckPublicKey(&cryptorsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), 3071), E: 65537}))
+	require.NoError(t, policy.CheckPublicKey(&cryptoecdsa.PublicKey{Curve: elliptic.P256()}))
+	require.NoError(t, policy.CheckPublicKey(cryptoed25519.PublicKey{}))
+	requirePolicyViolation(t, policy.CheckPublicKey("unknown"))
+	fipsPolicy := &Policy{FIPS: true}
+	requirePolicyViolation(t, fipsPolicy.CheckPublicKey(cryptoed25519.PublicKey{}))
+	requirePolicyViolation(t, fipsPolicy.CheckPublicKey(&cryptoecdsa.PublicKey{Curve: elliptic.P224()}))
+	var nilPolicy *Policy
+	require.NoError(t, nilPolicy.CheckPublicKey(weakRSAKey))
+}
+
+func requirePolicyViolation(t *testing.T, err error) {
+	var policyViolation *PolicyViolationError
+	require.True(t, errors.As(err, &policyViolation))
+}
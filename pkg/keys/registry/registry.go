@@ -18,6 +18,13 @@
 package registry
 
 import (
+	"crypto"
+	cryptoecdsa "crypto/ecdsa"
+	cryptoed25519 "crypto/ed25519"
+	cryptorsa "crypto/rsa"
+	"fmt"
+	"strings"
+
 	"github.com/hdecarne-github/certd/pkg/keys"
 	"github.com/hdecarne-github/certd/pkg/keys/ecdsa"
 	"github.com/hdecarne-github/certd/pkg/keys/ed25519"
@@ -27,6 +34,8 @@ import (
 var providerNames = []string{}
 var providerStandardKeys = make(map[string]func() []keys.KeyPairFactory, 0)
 var standardKeys = make(map[string]keys.KeyPairFactory, 0)
+var experimentalKeys = make(map[string]bool, 0)
+var experimentalPublicKeyNames = make([]func(crypto.PublicKey) string, 0)
 
 func KeyProviders() []string {
 	names := providerNames
@@ -41,6 +50,57 @@ func StandardKey(name string) keys.KeyPairFactory {
 	return standardKeys[name]
 }
 
+// Get the key factory for the given key type name.
+//
+// In contrast to StandardKey the lookup is lenient with respect to case, blanks and dashes (e.g. "ecdsa p256" and
+// "ECDSA P-256" are equivalent). Experimental key types are resolved as well; use the key policy to restrict them.
+func FactoryFor(name string) (keys.KeyPairFactory, error) {
+	factory := standardKeys[name]
+	if factory != nil {
+		return factory, nil
+	}
+	normalizedName := normalizeKeyName(name)
+	for standardName, standardKey := range standardKeys {
+		if normalizeKeyName(standardName) == normalizedName {
+			return standardKey, nil
+		}
+	}
+	return nil, fmt.Errorf("unrecognized key type '%s'", name)
+}
+
+// Get the key factory generating keys of the same type as the given public key.
+func FactoryForPublicKey(publicKey crypto.PublicKey) (keys.KeyPairFactory, error) {
+	var name string
+	switch key := publicKey.(type) {
+	case *cryptoecdsa.PublicKey:
+		name = ecdsa.ProviderName + " " + key.Curve.Params().Name
+	case cryptoed25519.PublicKey:
+		name = ed25519.ProviderName
+	case *cryptorsa.PublicKey:
+		name = fmt.Sprintf("%s %d", rsa.ProviderName, key.N.BitLen())
+	default:
+		for _, experimentalPublicKeyName := range experimentalPublicKeyNames {
+			name = experimentalPublicKeyName(publicKey)
+			if name != "" {
+				break
+			}
+		}
+		if name == "" {
+			return nil, fmt.Errorf("unrecognized public key type %T", publicKey)
+		}
+	}
+	return FactoryFor(name)
+}
+
+func normalizeKeyName(name string) string {
+	return strings.NewReplacer(" ", "", "-", "", "_", "").Replace(strings.ToUpper(name))
+}
+
+// Check whether the given key type is experimental (e.g. a post-quantum key type).
+func IsExperimental(name string) bool {
+	return experimentalKeys[name]
+}
+
 func init() {
 	providerNames = append(providerNames, ecdsa.ProviderName, ed25519.ProviderName, rsa.ProviderName)
 	providerStandardKeys[ecdsa.ProviderName] = ecdsa.StandardKeys
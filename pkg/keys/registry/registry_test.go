@@ -18,6 +18,7 @@
 package registry
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -35,3 +36,30 @@ func TestRegistry(t *testing.T) {
 		}
 	}
 }
+
+func TestFactoryFor(t *testing.T) {
+	for _, name := range []string{"ECDSA P-224", "ECDSA P224", "ecdsa p-224"} {
+		factory, err := FactoryFor(name)
+		require.NoError(t, err)
+		require.Equal(t, "ECDSA P-224", factory.Name())
+	}
+	factory, err := FactoryFor("rsa 4096")
+	require.NoError(t, err)
+	require.Equal(t, "RSA 4096", factory.Name())
+	_, err = FactoryFor("RSA 4092")
+	require.Error(t, err)
+}
+
+func TestFactoryForPublicKey(t *testing.T) {
+	for _, providerName := range KeyProviders() {
+		for _, standardKey := range StandardKeys(providerName) {
+			keyPair, err := standardKey.New(context.Background())
+			require.NoError(t, err)
+			factory, err := FactoryForPublicKey(keyPair.Public())
+			require.NoError(t, err)
+			require.Equal(t, standardKey.Name(), factory.Name())
+		}
+	}
+	_, err := FactoryForPublicKey("invalid")
+	require.Error(t, err)
+}
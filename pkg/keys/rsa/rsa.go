@@ -18,8 +18,8 @@
 package rsa
 
 import (
+	"context"
 	"crypto"
-	"crypto/rand"
 	algorithm "crypto/rsa"
 	"strconv"
 
@@ -32,8 +32,8 @@ type RSAKeyPair struct {
 	key *algorithm.PrivateKey
 }
 
-func NewRSAKeyPair(bits int) (keys.KeyPair, error) {
-	key, err := algorithm.GenerateKey(rand.Reader, bits)
+func NewRSAKeyPair(ctx context.Context, bits int) (keys.KeyPair, error) {
+	key, err := generateKey(ctx, bits)
 	if err != nil {
 		return nil, err
 	}
@@ -60,8 +60,13 @@ func (factory *RSAKeyPairFactory) Name() string {
 	return ProviderName + " " + strconv.Itoa(factory.bits)
 }
 
-func (factory *RSAKeyPairFactory) New() (keys.KeyPair, error) {
-	return NewRSAKeyPair(factory.bits)
+// Get the key size (in bits) of the generated keys.
+func (factory *RSAKeyPairFactory) Bits() int {
+	return factory.bits
+}
+
+func (factory *RSAKeyPairFactory) New(ctx context.Context) (keys.KeyPair, error) {
+	return NewRSAKeyPair(ctx, factory.bits)
 }
 
 func StandardKeys() []keys.KeyPairFactory {
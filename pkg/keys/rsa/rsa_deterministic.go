@@ -0,0 +1,91 @@
+//go:build deterministic
+
+/*
+ * Copyright (c) 2023 Holger de Carne and contributors, All Rights Reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package rsa
+
+import (
+	"context"
+	algorithm "crypto/rsa"
+	"io"
+	"math/big"
+
+	"github.com/hdecarne-github/certd/pkg/entropy"
+)
+
+func generateKey(ctx context.Context, bits int) (*algorithm.PrivateKey, error) {
+	if entropy.DeterministicContext(ctx) {
+		return generateDeterministicKey(entropy.ContextReader(ctx), bits)
+	}
+	return algorithm.GenerateKey(entropy.ContextReader(ctx), bits)
+}
+
+// The standard key generation deliberately consumes a random amount of
+// entropy, hence we derive the key ourselves in deterministic mode.
+func generateDeterministicKey(random io.Reader, bits int) (*algorithm.PrivateKey, error) {
+	e := big.NewInt(65537)
+	one := big.NewInt(1)
+	for {
+		p, err := generateDeterministicPrime(random, bits-bits/2)
+		if err != nil {
+			return nil, err
+		}
+		q, err := generateDeterministicPrime(random, bits/2)
+		if err != nil {
+			return nil, err
+		}
+		if p.Cmp(q) == 0 {
+			continue
+		}
+		n := new(big.Int).Mul(p, q)
+		if n.BitLen() != bits {
+			continue
+		}
+		phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+		d := new(big.Int).ModInverse(e, phi)
+		if d == nil {
+			continue
+		}
+		key := &algorithm.PrivateKey{
+			PublicKey: algorithm.PublicKey{N: n, E: int(e.Int64())},
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		key.Precompute()
+		return key, key.Validate()
+	}
+}
+
+func generateDeterministicPrime(random io.Reader, bits int) (*big.Int, error) {
+	candidateBytes := make([]byte, (bits+7)/8)
+	excessBits := uint(len(candidateBytes)*8 - bits)
+	for {
+		_, err := io.ReadFull(random, candidateBytes)
+		if err != nil {
+			return nil, err
+		}
+		candidate := new(big.Int).SetBytes(candidateBytes)
+		candidate.Rsh(candidate, excessBits)
+		candidate.SetBit(candidate, bits-1, 1)
+		candidate.SetBit(candidate, bits-2, 1)
+		candidate.SetBit(candidate, 0, 1)
+		if candidate.ProbablyPrime(20) {
+			return candidate, nil
+		}
+	}
+}
@@ -18,10 +18,12 @@
 package rsa
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
 
+	"github.com/hdecarne-github/certd/pkg/keys"
 	"github.com/stretchr/testify/require"
 )
 
@@ -30,10 +32,20 @@ func TestRSAKeyPair(t *testing.T) {
 	for _, kpf := range kpfs {
 		fmt.Printf("Generating %s", kpf.Name())
 		start := time.Now()
-		keypair, err := kpf.New()
+		keypair, err := kpf.New(context.Background())
 		elapsed := time.Since(start)
 		fmt.Printf(" (took: %s)\n", elapsed)
 		require.NoError(t, err)
 		require.NotNil(t, keypair)
 	}
 }
+
+func TestRSAKeyPairCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := keys.NewKeyPair(ctx, NewRSAKeyPairFactory(8192))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	// the generation itself stops (an uncancelled 8192 bit generation takes several seconds)
+	require.Less(t, time.Since(start), time.Second)
+}
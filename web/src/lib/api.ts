@@ -30,6 +30,7 @@ export class StoreEntry {
 	crl: boolean = false;
 	valid_from: Date = new Date(0);
 	valid_to: Date = new Date(0);
+	kind: string = "";
 }
 
 const storeEntries = {
@@ -77,6 +78,23 @@ const storeLocalIssuers = {
 	get: (basePath: string) => request.get<StoreLocalIssuers>(`${basePath}/api/store/local/issuers`)
 };
 
+export class ServerError {
+	message: string = '';
+	details: string = '';
+	code: string = '';
+	hint: string = '';
+	fields: FieldError[] = [];
+}
+
+export class FieldError {
+	field: string = '';
+	error: string = '';
+}
+
+const storeLocalIssuer = {
+	get: (basePath: string, name: string) => request.get<StoreLocalIssuer>(`${basePath}/api/store/local/issuers/${name}`)
+};
+
 export class StoreGenerate {
 	name: string = '';
 	ca: string = '';
@@ -153,15 +171,28 @@ const storeACMEGenerate = {
 	put: (basePath: string, body: StoreRemoteGenerate) => request.put<void>(`${basePath}/api/store/acme/generate`, body)
 };
 
+export class OID {
+	oid: string = '';
+	name: string = '';
+	description: string = '';
+	arc?: OID;
+}
+
+const oid = {
+	get: (basePath: string, oid: string) => request.get<OID>(`${basePath}/api/oids/${oid}`)
+};
+
 const api = {
 	about,
 	storeEntries,
 	storeEntryDetails,
 	storeCAs,
 	storeLocalIssuers,
+	storeLocalIssuer,
 	storeLocalGenerate,
 	storeRemoteGenerate,
 	storeACMEGenerate,
+	oid,
 };
 
 export default api;